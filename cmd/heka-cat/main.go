@@ -22,29 +22,84 @@ protobuf logs.
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/cmd/internal/cmdutil"
+	"github.com/mozilla-services/heka/compression"
 	"github.com/mozilla-services/heka/message"
-	"github.com/mozilla-services/heka/pipeline"
 )
 
-func makeSplitterRunner() (pipeline.SplitterRunner, error) {
-	splitter := &pipeline.HekaFramingSplitter{}
-	config := splitter.ConfigStruct()
-	err := splitter.Init(config)
-	if err != nil {
-		return nil, fmt.Errorf("Error initializing HekaFramingSplitter: %s", err)
+// Returns the value of a header (by its protobuf name or the name used in the
+// txt output) or the first value of a dynamic field.
+func fieldValue(msg *message.Message, name string) (value interface{}, ok bool) {
+	switch name {
+	case "Timestamp":
+		return msg.GetTimestamp(), true
+	case "Type":
+		return msg.GetType(), true
+	case "Hostname":
+		return msg.GetHostname(), true
+	case "Pid":
+		return msg.GetPid(), true
+	case "Uuid", "UUID":
+		return msg.GetUuidString(), true
+	case "Logger":
+		return msg.GetLogger(), true
+	case "Payload":
+		return msg.GetPayload(), true
+	case "EnvVersion":
+		return msg.GetEnvVersion(), true
+	case "Severity":
+		return msg.GetSeverity(), true
+	}
+	return msg.GetFieldValue(name)
+}
+
+// Parses the comma separated -fields list, ignoring blank entries.
+func parseFieldNames(list string) (names []string) {
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
 	}
-	srConfig := pipeline.CommonSplitterConfig{}
-	sRunner := pipeline.NewSplitterRunner("HekaFramingSplitter", splitter, srConfig)
-	return sRunner, nil
+	return
+}
+
+// Returns the values of the named fields, skipping any the message doesn't
+// have.
+func selectFields(msg *message.Message, names []string) map[string]interface{} {
+	selected := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if value, ok := fieldValue(msg, name); ok {
+			selected[name] = value
+		}
+	}
+	return selected
+}
+
+// Writes a `name: value` line for each of the named fields the message has.
+func writeFields(out io.Writer, msg *message.Message, names []string) {
+	for _, name := range names {
+		value, ok := fieldValue(msg, name)
+		if !ok {
+			continue
+		}
+		if name == "Timestamp" {
+			value = time.Unix(0, msg.GetTimestamp())
+		}
+		fmt.Fprintf(out, "%s: %v\n", name, value)
+	}
+	fmt.Fprintln(out)
 }
 
 func main() {
@@ -52,20 +107,27 @@ func main() {
 	flagFormat := flag.String("format", "txt", "output format [txt|json|heka|count]")
 	flagOutput := flag.String("output", "", "output filename, defaults to stdout")
 	flagTail := flag.Bool("tail", false, "don't exit on EOF")
+	flagPretty := flag.Bool("pretty", false, "indent the json output format")
 	flagOffset := flag.Int64("offset", 0, "starting offset for the input file in bytes")
 	flagMaxMessageSize := flag.Uint64("max-message-size", 4*1024*1024, "maximum message size in bytes")
+	flagFields := flag.String("fields", "", "comma separated list of fields to include in the txt and json output formats")
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	if flag.NArg() > 1 {
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	// No filename, or "-", means the stream is read from stdin.
+	inputName := "-"
+	if flag.NArg() == 1 {
+		inputName = flag.Arg(0)
+	}
 
 	if *flagMaxMessageSize < math.MaxUint32 {
 		maxSize := uint32(*flagMaxMessageSize)
 		message.SetMaxMessageSize(maxSize)
 	} else {
-		fmt.Fprintf(os.Stderr, "Message size is too large: %d\n", flagMaxMessageSize)
+		fmt.Fprintf(os.Stderr, "Message size is too large: %d\n", *flagMaxMessageSize)
 		os.Exit(8)
 	}
 
@@ -77,11 +139,15 @@ func main() {
 	}
 
 	var file *os.File
-	if file, err = os.Open(flag.Arg(0)); err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(3)
+	if inputName == "-" {
+		file = os.Stdin
+	} else {
+		if file, err = os.Open(inputName); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(3)
+		}
+		defer file.Close()
 	}
-	defer file.Close()
 
 	var out *os.File
 	if "" == *flagOutput {
@@ -94,30 +160,66 @@ func main() {
 		defer out.Close()
 	}
 
+	// Gzip and zstd compressed input is decompressed on the fly, in which case
+	// the offset is into the uncompressed stream. Stdin can't seek, so the
+	// magic bytes are peeked at through a buffer instead.
+	var reader io.Reader = file
 	var offset int64
-	if offset, err = file.Seek(*flagOffset, 0); err != nil {
+	var magic []byte
+	seekable := file != os.Stdin
+	if seekable {
+		magic = make([]byte, compression.DetectLength)
+		n, _ := io.ReadFull(file, magic)
+		magic = magic[:n]
+		if _, err = file.Seek(0, 0); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(5)
+		}
+	} else {
+		buffered := bufio.NewReader(file)
+		magic, _ = buffered.Peek(compression.DetectLength)
+		reader = buffered
+	}
+	if algorithm := compression.Detect(magic); algorithm != compression.None {
+		var decompressor io.ReadCloser
+		if decompressor, err = compression.NewReader(reader, algorithm); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(5)
+		}
+		defer decompressor.Close()
+		reader = decompressor
+		seekable = false
+	}
+	if seekable {
+		offset, err = file.Seek(*flagOffset, 0)
+	} else {
+		offset, err = io.CopyN(ioutil.Discard, reader, *flagOffset)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(5)
 	}
 
-	sRunner, err := makeSplitterRunner()
+	sRunner, err := cmdutil.MakeSplitterRunner()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(7)
 	}
 	msg := new(message.Message)
 	var processed, matched int64
+	fieldNames := parseFieldNames(*flagFields)
 
 	fmt.Fprintf(os.Stderr, "Input:%s  Offset:%d  Match:%s  Format:%s  Tail:%t  Output:%s\n",
-		flag.Arg(0), *flagOffset, *flagMatch, *flagFormat, *flagTail, *flagOutput)
+		inputName, *flagOffset, *flagMatch, *flagFormat, *flagTail, *flagOutput)
 	for true {
-		n, record, err := sRunner.GetRecordFromStream(file)
+		n, record, err := sRunner.GetRecordFromStream(reader)
 		if n > 0 && n != len(record) {
 			fmt.Fprintf(os.Stderr, "Corruption detected at offset: %d bytes: %d\n", offset, n-len(record))
 		}
 		if err != nil {
 			if err == io.EOF {
-				if !*flagTail || "count" == *flagFormat {
+				// A closed pipe won't get any more data.
+				if !*flagTail || "count" == *flagFormat || file == os.Stdin {
 					break
 				}
 				time.Sleep(time.Duration(500) * time.Millisecond)
@@ -142,25 +244,25 @@ func main() {
 				case "count":
 					// no op
 				case "json":
-					contents, _ := json.Marshal(msg)
+					var v interface{} = msg
+					if fieldNames != nil {
+						v = selectFields(msg, fieldNames)
+					}
+					var contents []byte
+					if *flagPretty {
+						contents, _ = json.MarshalIndent(v, "", "    ")
+					} else {
+						contents, _ = json.Marshal(v)
+					}
 					fmt.Fprintf(out, "%s\n", contents)
 				case "heka":
 					fmt.Fprintf(out, "%s", record)
 				default:
-					fmt.Fprintf(out, "Timestamp: %s\n"+
-						"Type: %s\n"+
-						"Hostname: %s\n"+
-						"Pid: %d\n"+
-						"UUID: %s\n"+
-						"Logger: %s\n"+
-						"Payload: %s\n"+
-						"EnvVersion: %s\n"+
-						"Severity: %d\n"+
-						"Fields: %+v\n\n",
-						time.Unix(0, msg.GetTimestamp()), msg.GetType(),
-						msg.GetHostname(), msg.GetPid(), msg.GetUuidString(),
-						msg.GetLogger(), msg.GetPayload(), msg.GetEnvVersion(),
-						msg.GetSeverity(), msg.Fields)
+					if fieldNames != nil {
+						writeFields(out, msg, fieldNames)
+						break
+					}
+					cmdutil.WriteText(out, msg)
 				}
 			}
 		}
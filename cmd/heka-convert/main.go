@@ -0,0 +1,304 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+/*
+
+A command-line utility for converting message corpora between the Heka
+protobuf stream format, JSON, and plain text.
+
+*/
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/client"
+	"github.com/mozilla-services/heka/cmd/internal/cmdutil"
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+	"github.com/pborman/uuid"
+)
+
+// MessageReader hands back the messages from an input stream one at a time,
+// returning io.EOF when the stream is exhausted.
+type MessageReader interface {
+	ReadMessage() (*message.Message, error)
+}
+
+// Reads framed protobuf messages using the HekaFramingSplitter, the same way
+// heka-cat does.
+type hekaReader struct {
+	r       io.Reader
+	sRunner pipeline.SplitterRunner
+	offset  int64
+}
+
+func (h *hekaReader) ReadMessage() (*message.Message, error) {
+	for {
+		n, record, err := h.sRunner.GetRecordFromStream(h.r)
+		if n > 0 && n != len(record) {
+			fmt.Fprintf(os.Stderr, "Corruption detected at offset: %d bytes: %d\n",
+				h.offset, n-len(record))
+		}
+		h.offset += int64(n)
+		if err != nil {
+			return nil, err
+		}
+		if len(record) == 0 {
+			continue
+		}
+		msg := new(message.Message)
+		headerLen := int(record[1]) + message.HEADER_FRAMING_SIZE
+		if err = proto.Unmarshal(record[headerLen:], msg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error unmarshalling message at offset: %d error: %s\n",
+				h.offset-int64(n), err)
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// Reads JSON encoded messages. Accepts either a single JSON array of message
+// objects or a stream of message objects, such as the output of `heka-cat
+// -format json`.
+type jsonReader struct {
+	dec *json.Decoder
+	// The decoded messages of an array, which is decoded all at once.
+	msgs []*message.Message
+}
+
+func newJsonReader(r io.Reader) (*jsonReader, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			// Empty input, there's nothing to decode.
+			return &jsonReader{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		br.UnreadByte()
+		jr := new(jsonReader)
+		if b == '[' {
+			if err = json.NewDecoder(br).Decode(&jr.msgs); err != nil {
+				return nil, err
+			}
+		} else {
+			jr.dec = json.NewDecoder(br)
+		}
+		return jr, nil
+	}
+}
+
+func (j *jsonReader) ReadMessage() (*message.Message, error) {
+	if j.dec == nil {
+		if len(j.msgs) == 0 {
+			return nil, io.EOF
+		}
+		msg := j.msgs[0]
+		j.msgs = j.msgs[1:]
+		return msg, nil
+	}
+	msg := new(message.Message)
+	if err := j.dec.Decode(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Reads plain text, generating one message per line with the line contents
+// as the message payload.
+type textReader struct {
+	reader *bufio.Reader
+}
+
+func newTextReader(r io.Reader) *textReader {
+	return &textReader{reader: bufio.NewReader(r)}
+}
+
+func (t *textReader) ReadMessage() (*message.Message, error) {
+	line, err := t.reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if len(line) > int(message.MAX_MESSAGE_SIZE) {
+		return nil, fmt.Errorf("line longer than the maximum message size: %d",
+			message.MAX_MESSAGE_SIZE)
+	}
+	msg := new(message.Message)
+	msg.SetPayload(line)
+	return msg, nil
+}
+
+// Messages from hand edited JSON and text input may be missing the required
+// protobuf fields, fill them in so the output is always valid.
+func fillRequired(msg *message.Message, hostname string) {
+	if len(msg.GetUuid()) == 0 {
+		msg.SetUuid(uuid.NewRandom())
+	}
+	if msg.Timestamp == nil {
+		msg.SetTimestamp(time.Now().UnixNano())
+	}
+	if msg.Hostname == nil && hostname != "" {
+		msg.SetHostname(hostname)
+	}
+}
+
+func writeMessage(out io.Writer, msg *message.Message, format string,
+	outBytes *[]byte) (err error) {
+
+	switch format {
+	case "json":
+		var contents []byte
+		if contents, err = json.Marshal(msg); err != nil {
+			return
+		}
+		_, err = fmt.Fprintf(out, "%s\n", contents)
+	case "heka":
+		var msgBytes []byte
+		if msgBytes, err = proto.Marshal(msg); err != nil {
+			return
+		}
+		if err = client.CreateHekaStream(msgBytes, outBytes, nil); err != nil {
+			return
+		}
+		_, err = out.Write(*outBytes)
+	default:
+		err = cmdutil.WriteText(out, msg)
+	}
+	return
+}
+
+func main() {
+	flagMatch := flag.String("match", "TRUE", "message_matcher filter expression")
+	flagFrom := flag.String("from", "heka", "input format [heka|json|txt]")
+	flagTo := flag.String("to", "json", "output format [heka|json|txt]")
+	flagOutput := flag.String("output", "", "output filename, defaults to stdout")
+	flagHostname := flag.String("hostname", "", "hostname for json/txt input messages without one")
+	flagMaxMessageSize := flag.Uint64("max-message-size", 4*1024*1024, "maximum message size in bytes")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *flagMaxMessageSize < math.MaxUint32 {
+		maxSize := uint32(*flagMaxMessageSize)
+		message.SetMaxMessageSize(maxSize)
+	} else {
+		fmt.Fprintf(os.Stderr, "Message size is too large: %d\n", *flagMaxMessageSize)
+		os.Exit(8)
+	}
+
+	switch *flagTo {
+	case "heka", "json", "txt":
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported output format: %s\n", *flagTo)
+		os.Exit(1)
+	}
+
+	var err error
+	var match *message.MatcherSpecification
+	if match, err = message.CreateMatcherSpecification(*flagMatch); err != nil {
+		fmt.Fprintf(os.Stderr, "Match specification - %s\n", err)
+		os.Exit(2)
+	}
+
+	var file *os.File
+	if file, err = os.Open(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(3)
+	}
+	defer file.Close()
+
+	var out *os.File
+	if "" == *flagOutput {
+		out = os.Stdout
+	} else {
+		if out, err = os.OpenFile(*flagOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(4)
+		}
+		defer out.Close()
+	}
+
+	var reader MessageReader
+	switch *flagFrom {
+	case "heka":
+		sRunner, err := cmdutil.MakeSplitterRunner()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(7)
+		}
+		reader = &hekaReader{r: file, sRunner: sRunner}
+	case "json":
+		if reader, err = newJsonReader(file); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading JSON input: %s\n", err)
+			os.Exit(5)
+		}
+	case "txt":
+		reader = newTextReader(file)
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported input format: %s\n", *flagFrom)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Input:%s  From:%s  To:%s  Match:%s  Output:%s\n",
+		flag.Arg(0), *flagFrom, *flagTo, *flagMatch, *flagOutput)
+
+	w := bufio.NewWriter(out)
+	outBytes := make([]byte, 0, message.MAX_RECORD_SIZE)
+	var processed, converted int64
+	var msg *message.Message
+	for {
+		if msg, err = reader.ReadMessage(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			break
+		}
+		processed += 1
+		fillRequired(msg, *flagHostname)
+		if !match.Match(msg) {
+			continue
+		}
+		if err = writeMessage(w, msg, *flagTo, &outBytes); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing message %d: %s\n", processed, err)
+			continue
+		}
+		converted += 1
+	}
+	if e := w.Flush(); e != nil && err == nil {
+		err = e
+	}
+	fmt.Fprintf(os.Stderr, "Processed: %d, converted: %d messages\n", processed, converted)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(6)
+	}
+}
@@ -44,6 +44,7 @@ import (
 	"github.com/gogo/protobuf/proto"
 	"github.com/mozilla-services/heka/client"
 	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
 	"github.com/mozilla-services/heka/plugins/tcp"
 	"github.com/pborman/uuid"
 )
@@ -56,6 +57,7 @@ type FloodTest struct {
 	PprofFile            string                       `toml:"pprof_file"`
 	Encoder              string                       `toml:"encoder"`
 	MsgInterval          string                       `toml:"message_interval"`
+	TargetRate           float64                      `toml:"target_rate"`
 	Signer               message.MessageSigningConfig `toml:"signer"`
 	CorruptPercentage    float64                      `toml:"corrupt_percentage"`
 	SignedPercentage     float64                      `toml:"signed_percentage"`
@@ -67,12 +69,79 @@ type FloodTest struct {
 	MaxMessageSize       uint32                       `toml:"max_message_size"`
 	ReconnectOnError     bool                         `toml:"reconnect_on_error"`
 	ReconnectInterval    int32                        `toml:"reconnect_interval"`
+	Types                []string                     `toml:"types"`
+	Loggers              []string                     `toml:"loggers"`
+	RoundRobin           bool                         `toml:"round_robin"`
+	TemplateFile         string                       `toml:"template_file"`
+	RestampTemplates     bool                         `toml:"restamp_templates"`
 	msgInterval          time.Duration
 }
 
+// Returns the number of distinct Type / Logger combinations the test will
+// generate.
+func (test *FloodTest) numNameCombinations() int {
+	n := 1
+	if len(test.Types) > 0 {
+		n *= len(test.Types)
+	}
+	if len(test.Loggers) > 0 {
+		n *= len(test.Loggers)
+	}
+	return n
+}
+
+// Returns the Type and Logger for the idx'th generated message, cycling
+// through every combination of the configured types and loggers.
+func (test *FloodTest) messageNames(idx int, defaultType, defaultLogger string) (
+	msgType, logger string) {
+
+	msgType, logger = defaultType, defaultLogger
+	if len(test.Types) > 0 {
+		msgType = test.Types[idx%len(test.Types)]
+		idx /= len(test.Types)
+	}
+	if len(test.Loggers) > 0 {
+		logger = test.Loggers[idx%len(test.Loggers)]
+	}
+	return
+}
+
 type FloodConfig map[string]FloodTest
 
-func timerLoop(count, bytes *uint64, ticker *time.Ticker) {
+// Paces the send loop to a steady message rate. Each message is due at a
+// fixed offset from the start of the schedule, so time spent encoding and
+// sending is absorbed rather than added to the delay.
+type ratePacer struct {
+	interval time.Duration
+	start    time.Time
+	sent     int64
+}
+
+func newRatePacer(rate float64) *ratePacer {
+	return &ratePacer{
+		interval: time.Duration(float64(time.Second) / rate),
+		start:    time.Now(),
+	}
+}
+
+// Blocks until the next message is due.
+func (r *ratePacer) wait() {
+	r.sent++
+	due := r.start.Add(time.Duration(r.sent) * r.interval)
+	now := time.Now()
+	if now.Sub(due) > time.Second {
+		// We fell far behind, e.g. while reconnecting. Start a new schedule
+		// instead of bursting to catch up.
+		r.start = now
+		r.sent = 0
+		return
+	}
+	if delay := due.Sub(now); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+func timerLoop(count, bytes *uint64, ticker *time.Ticker, targetRate float64) {
 	lastTime := time.Now().UTC()
 	lastCount := *count
 	lastBytes := *bytes
@@ -104,12 +173,17 @@ func timerLoop(count, bytes *uint64, ticker *time.Ticker) {
 		} else {
 			zeroes = 0
 		}
-		client.LogInfo.Printf("Sent %d messages. %0.2f msg/sec %0.2f Mbit/sec\n", newCount, msgRate, bitRate)
+		if targetRate > 0 {
+			client.LogInfo.Printf("Sent %d messages. %0.2f msg/sec (target %0.2f msg/sec) %0.2f Mbit/sec\n",
+				newCount, msgRate, targetRate, bitRate)
+		} else {
+			client.LogInfo.Printf("Sent %d messages. %0.2f msg/sec %0.2f Mbit/sec\n", newCount, msgRate, bitRate)
+		}
 	}
 }
 
 func makeVariableMessage(encoder client.StreamEncoder, items int,
-	rdm *randomDataMaker, oversized bool) [][]byte {
+	rdm *randomDataMaker, oversized bool, test *FloodTest) [][]byte {
 
 	ma := make([][]byte, items)
 	hostname, _ := os.Hostname()
@@ -120,8 +194,9 @@ func makeVariableMessage(encoder client.StreamEncoder, items int,
 		msg := &message.Message{}
 		msg.SetUuid(uuid.NewRandom())
 		msg.SetTimestamp(time.Now().UnixNano())
-		msg.SetType("hekabench")
-		msg.SetLogger("flood")
+		msgType, logger := test.messageNames(x, "hekabench", "flood")
+		msg.SetType(msgType)
+		msg.SetLogger(logger)
 		msg.SetEnvVersion("0.2")
 		msg.SetPid(pid)
 		msg.SetHostname(hostname)
@@ -250,26 +325,85 @@ func CreateHekaStream(msgBytes []byte, outBytes *[]byte) error {
 }
 
 func makeFixedMessage(encoder client.StreamEncoder, size uint64,
-	rdm *randomDataMaker) [][]byte {
+	rdm *randomDataMaker, test *FloodTest) [][]byte {
 
-	ma := make([][]byte, 1)
+	// One message for each Type / Logger combination, all sharing the same
+	// payload.
+	ma := make([][]byte, test.numNameCombinations())
 	hostname, _ := os.Hostname()
 	pid := int32(os.Getpid())
+	payload := makePayload(size, rdm)
 
-	msg := &message.Message{}
-	msg.SetType("hekabench")
-	msg.SetTimestamp(time.Now().UnixNano())
-	msg.SetUuid(uuid.NewRandom())
-	msg.SetSeverity(int32(6))
-	msg.SetEnvVersion("0.8")
-	msg.SetPid(pid)
-	msg.SetHostname(hostname)
-	msg.SetPayload(makePayload(size, rdm))
-	var stream []byte
-	if err := encoder.EncodeMessageStream(msg, &stream); err != nil {
-		client.LogError.Println(err)
-	}
-	ma[0] = stream
+	for x := range ma {
+		msg := &message.Message{}
+		msgType, logger := test.messageNames(x, "hekabench", "")
+		msg.SetType(msgType)
+		if logger != "" {
+			msg.SetLogger(logger)
+		}
+		msg.SetTimestamp(time.Now().UnixNano())
+		msg.SetUuid(uuid.NewRandom())
+		msg.SetSeverity(int32(6))
+		msg.SetEnvVersion("0.8")
+		msg.SetPid(pid)
+		msg.SetHostname(hostname)
+		msg.SetPayload(payload)
+		var stream []byte
+		if err := encoder.EncodeMessageStream(msg, &stream); err != nil {
+			client.LogError.Println(err)
+		}
+		ma[x] = stream
+	}
+	return ma
+}
+
+// Reads the Heka framed protobuf messages in a file, e.g. one written by a
+// FileOutput using the ProtobufEncoder.
+func loadTemplateMessages(path string) (msgs []*message.Message, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	splitter := &pipeline.HekaFramingSplitter{}
+	if err = splitter.Init(splitter.ConfigStruct()); err != nil {
+		return nil, fmt.Errorf("Error initializing HekaFramingSplitter: %s", err)
+	}
+	sRunner := pipeline.NewSplitterRunner("HekaFramingSplitter", splitter,
+		pipeline.CommonSplitterConfig{})
+	for {
+		_, record, err := sRunner.GetRecordFromStream(file)
+		if len(record) > 0 {
+			msg := new(message.Message)
+			headerLen := int(record[1]) + message.HEADER_FRAMING_SIZE
+			if err := proto.Unmarshal(record[headerLen:], msg); err != nil {
+				return nil, fmt.Errorf("Error unmarshalling template message %d: %s",
+					len(msgs), err)
+			}
+			msgs = append(msgs, msg)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("No messages found in template file %s", path)
+	}
+	return msgs, nil
+}
+
+func makeTemplateMessages(encoder client.StreamEncoder,
+	templates []*message.Message) [][]byte {
+
+	ma := make([][]byte, len(templates))
+	for x, msg := range templates {
+		if err := encoder.EncodeMessageStream(msg, &ma[x]); err != nil {
+			client.LogError.Println(err)
+		}
+	}
 	return ma
 }
 
@@ -334,6 +468,23 @@ func main() {
 			return
 		}
 	}
+	if test.TargetRate < 0 {
+		client.LogError.Printf("Invalid target_rate %g: must not be negative", test.TargetRate)
+		return
+	}
+	if test.TargetRate > 0 && test.msgInterval != 0 {
+		client.LogError.Println("Only one of message_interval and target_rate may be set")
+		return
+	}
+
+	var templates []*message.Message
+	if test.TemplateFile != "" {
+		var err error
+		if templates, err = loadTemplateMessages(test.TemplateFile); err != nil {
+			client.LogError.Printf("Error loading template file: %s", err)
+			return
+		}
+	}
 
 	if test.PprofFile != "" {
 		profFile, err := os.Create(test.PprofFile)
@@ -385,19 +536,30 @@ func main() {
 		asciiOnly: test.AsciiOnly,
 	}
 
-	if test.VariableSizeMessages {
-		numTestMessages = 64
-		unsignedMessages = makeVariableMessage(unsignedEncoder, numTestMessages, rdm, false)
-		signedMessages = makeVariableMessage(signedEncoder, numTestMessages, rdm, false)
-		oversizedMessages = makeVariableMessage(oversizedEncoder, 1, rdm, true)
+	if templates != nil {
+		numTestMessages = len(templates)
+		unsignedMessages = makeTemplateMessages(unsignedEncoder, templates)
+		signedMessages = makeTemplateMessages(signedEncoder, templates)
+		oversizedMessages = makeVariableMessage(oversizedEncoder, 1, rdm, true, &test)
+	} else if test.VariableSizeMessages {
+		// Use a multiple of the number of Type / Logger combinations so
+		// round robin selection cycles through them evenly.
+		combinations := test.numNameCombinations()
+		numTestMessages = (64 + combinations - 1) / combinations * combinations
+		unsignedMessages = makeVariableMessage(unsignedEncoder, numTestMessages, rdm,
+			false, &test)
+		signedMessages = makeVariableMessage(signedEncoder, numTestMessages, rdm,
+			false, &test)
+		oversizedMessages = makeVariableMessage(oversizedEncoder, 1, rdm, true, &test)
 	} else {
 		if test.StaticMessageSize == 0 {
 			test.StaticMessageSize = 1000
 		}
 		unsignedMessages = makeFixedMessage(unsignedEncoder, test.StaticMessageSize,
-			rdm)
+			rdm, &test)
 		signedMessages = makeFixedMessage(signedEncoder, test.StaticMessageSize,
-			rdm)
+			rdm, &test)
+		numTestMessages = len(unsignedMessages)
 	}
 	// wait for sigint
 	sigChan := make(chan os.Signal, 1)
@@ -408,12 +570,34 @@ func main() {
 
 	// set up counter loop
 	ticker := time.NewTicker(time.Duration(time.Second))
-	go timerLoop(&msgsSent, &bytesSent, ticker)
+	go timerLoop(&msgsSent, &bytesSent, ticker, test.TargetRate)
 
 	test.CorruptPercentage /= 100.0
 	test.SignedPercentage /= 100.0
 	test.OversizedPercentage /= 100.0
 
+	var pacer *ratePacer
+	if test.TargetRate > 0 {
+		pacer = newRatePacer(test.TargetRate)
+	}
+
+	// Returns the msgId'th message of msgs, or if template messages are being
+	// restamped, the template re-encoded with a new UUID and Timestamp.
+	var restamped []byte
+	messageBytes := func(msgs [][]byte, encoder client.StreamEncoder, msgId int) []byte {
+		if templates == nil || !test.RestampTemplates {
+			return msgs[msgId]
+		}
+		msg := templates[msgId]
+		msg.SetUuid(uuid.NewRandom())
+		msg.SetTimestamp(time.Now().UnixNano())
+		if err := encoder.EncodeMessageStream(msg, &restamped); err != nil {
+			client.LogError.Println(err)
+			return msgs[msgId]
+		}
+		return restamped
+	}
+
 	var buf []byte
 	for gotsigint := false; !gotsigint; {
 		runtime.Gosched()
@@ -423,7 +607,12 @@ func main() {
 			continue
 		default:
 		}
-		msgId := rand.Int() % numTestMessages
+		var msgId int
+		if test.RoundRobin || templates != nil {
+			msgId = int(msgsSent % uint64(numTestMessages))
+		} else {
+			msgId = rand.Int() % numTestMessages
+		}
 		corruptPercentage = math.Floor(float64(msgsSent) * test.CorruptPercentage)
 		if corruptPercentage != lastCorruptPercentage {
 			lastCorruptPercentage = corruptPercentage
@@ -434,14 +623,14 @@ func main() {
 		signedPercentage = math.Floor(float64(msgsSent) * test.SignedPercentage)
 		if signedPercentage != lastSignedPercentage {
 			lastSignedPercentage = signedPercentage
-			buf = signedMessages[msgId]
+			buf = messageBytes(signedMessages, signedEncoder, msgId)
 		} else {
 			oversizedPercentage = math.Floor(float64(msgsSent) * test.OversizedPercentage)
 			if oversizedPercentage != lastOversizedPercentage {
 				lastOversizedPercentage = oversizedPercentage
 				buf = oversizedMessages[0]
 			} else {
-				buf = unsignedMessages[msgId]
+				buf = messageBytes(unsignedMessages, unsignedEncoder, msgId)
 			}
 		}
 		bytesSent += uint64(len(buf))
@@ -468,7 +657,9 @@ func main() {
 		if test.NumMessages != 0 && msgsSent >= test.NumMessages {
 			break
 		}
-		if test.msgInterval != 0 {
+		if pacer != nil {
+			pacer.wait()
+		} else if test.msgInterval != 0 {
 			time.Sleep(test.msgInterval)
 		}
 	}
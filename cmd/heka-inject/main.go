@@ -24,8 +24,12 @@ pipeline.
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mozilla-services/heka/client"
@@ -57,6 +61,50 @@ type InjectData struct {
 	payload  string
 	pid      int
 	hostname string
+	fields   fieldFlags
+}
+
+// Collects the repeatable `-field name:type:value` flags.
+type fieldFlags []*message.Field
+
+func (ff *fieldFlags) String() string {
+	return fmt.Sprint(*ff)
+}
+
+func (ff *fieldFlags) Set(spec string) error {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return fmt.Errorf("field must be specified as name:type:value, got '%s'", spec)
+	}
+	name, fieldType, raw := parts[0], parts[1], parts[2]
+	var (
+		value interface{}
+		err   error
+	)
+	switch fieldType {
+	case "string":
+		value = raw
+	case "bytes":
+		value = []byte(raw)
+	case "int":
+		value, err = strconv.ParseInt(raw, 10, 64)
+	case "double":
+		value, err = strconv.ParseFloat(raw, 64)
+	case "bool":
+		value, err = strconv.ParseBool(raw)
+	default:
+		return fmt.Errorf("field type must be string, int, double, bool, or bytes, got '%s'",
+			fieldType)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s value for field '%s': %s", fieldType, name, raw)
+	}
+	field, err := message.NewField(name, value, "")
+	if err != nil {
+		return err
+	}
+	*ff = append(*ff, field)
+	return nil
 }
 
 func (hc *HekaClient) injectMessage(m *InjectData) (err error) {
@@ -71,18 +119,36 @@ func (hc *HekaClient) injectMessage(m *InjectData) (err error) {
 	msg.SetSeverity(int32(m.severity))
 	msg.SetHostname(m.hostname)
 	msg.SetPayload(string(m.payload))
+	for _, field := range m.fields {
+		msg.AddField(field)
+	}
 
-	err = hc.encoder.EncodeMessageStream(msg, &stream)
-	if err != nil {
-		client.LogError.Printf("Inject: [error] encode message: %s\n", err)
+	if err = hc.encoder.EncodeMessageStream(msg, &stream); err != nil {
+		return fmt.Errorf("encode message: %s", err)
 	}
-	err = hc.sender.SendMessage(stream)
-	if err != nil {
-		client.LogError.Printf("Inject: [error] send message: %s\n", err)
+	if err = hc.sender.SendMessage(stream); err != nil {
+		return fmt.Errorf("send message: %s", err)
 	}
 	return nil
 }
 
+// Sends a message for each line read from stdin, using the line as the
+// payload. Returns the number of messages sent.
+func (hc *HekaClient) injectLines(m *InjectData) (sent int, err error) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		m.payload = scanner.Text()
+		if err = hc.injectMessage(m); err != nil {
+			return
+		}
+		sent++
+	}
+	if err = scanner.Err(); err != nil {
+		err = fmt.Errorf("read stdin: %s", err)
+	}
+	return
+}
+
 func main() {
 	flagHekaInstance := flag.String("heka", "127.0.0.1:5565", "Heka instance to inject message")
 	flagType := flag.String("type", "inject.message", "Type of message")
@@ -91,6 +157,12 @@ func main() {
 	flagPayload := flag.String("payload", "", "Textual data")
 	flagPid := flag.Int("pid", 0, "Process ID generating message")
 	flagHostname := flag.String("hostname", "", "Hostname generating message")
+	flagStdin := flag.Bool("stdin", false,
+		"Send a message for each line read from stdin, ignoring -payload")
+	var fields fieldFlags
+	flag.Var(&fields, "field",
+		"Dynamic field as name:type:value, type is one of string, int, double, "+
+			"bool, or bytes. Can be repeated")
 
 	flag.Parse()
 
@@ -104,6 +176,7 @@ func main() {
 		logger:   *flagLogger,
 		severity: *flagSeverity,
 		payload:  *flagPayload,
+		fields:   fields,
 	}
 
 	if *flagPid == 0 {
@@ -119,10 +192,21 @@ func main() {
 	}
 
 	hc, err := NewHekaClient(*flagHekaInstance)
-	if err == nil {
-		err := hc.injectMessage(data)
+	if err != nil {
+		client.LogError.Printf("Inject: [error] %s\n", err)
+		os.Exit(1)
+	}
+	if *flagStdin {
+		sent, err := hc.injectLines(data)
+		client.LogInfo.Printf("Inject: sent %d messages\n", sent)
 		if err != nil {
 			client.LogError.Printf("Inject: [error] %s\n", err)
+			os.Exit(1)
 		}
+		return
+	}
+	if err = hc.injectMessage(data); err != nil {
+		client.LogError.Printf("Inject: [error] %s\n", err)
+		os.Exit(1)
 	}
 }
@@ -36,13 +36,14 @@ import (
 
 // Logstreamer config struct
 type LogstreamerConfig struct {
-	LogDirectory   string `toml:"log_directory"`
-	FileMatch      string `toml:"file_match"`
-	Priority       []string
-	Differentiator []string
-	OldestDuration string `toml:"oldest_duration"`
-	Translation    logstreamer.SubmatchTranslationMap
-	InitialTail    bool `toml:"initial_tail"`
+	LogDirectory    string `toml:"log_directory"`
+	FileMatch       string `toml:"file_match"`
+	Priority        []string
+	Differentiator  []string
+	OldestDuration  string `toml:"oldest_duration"`
+	IgnoreOlderThan string `toml:"ignore_older_than"`
+	Translation     logstreamer.SubmatchTranslationMap
+	InitialTail     bool `toml:"initial_tail"`
 }
 
 type Basic struct {
@@ -137,6 +138,13 @@ func parseConfig(name string, prim toml.Primitive) {
 	if err != nil {
 		client.LogError.Fatalf("Error initializing LogstreamSet: %s\n", err.Error())
 	}
+	if config.IgnoreOlderThan != "" {
+		ignoreOlderThan, err := time.ParseDuration(config.IgnoreOlderThan)
+		if err != nil {
+			client.LogError.Fatalf("Error parsing ignore_older_than: %s\n", err)
+		}
+		ls.SetIgnoreOlderThan(ignoreOlderThan)
+	}
 	streams, errs := ls.ScanForLogstreams()
 	if errs.IsError() {
 		client.LogError.Fatalf("Error scanning: %s\n", errs)
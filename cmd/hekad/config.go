@@ -45,9 +45,18 @@ type HekadConfig struct {
 	SampleDenominator     int    `toml:"sample_denominator"`
 	PidFile               string `toml:"pid_file"`
 	Hostname              string
-	MaxMessageSize        uint32 `toml:"max_message_size"`
-	LogFlags              int    `toml:"log_flags"`
-	FullBufferMaxRetries  uint32 `toml:"full_buffer_max_retries"`
+	MaxMessageSize        uint32  `toml:"max_message_size"`
+	LogFlags              int     `toml:"log_flags"`
+	FullBufferMaxRetries  uint32  `toml:"full_buffer_max_retries"`
+	MaxFields             int     `toml:"max_fields"`
+	MaxFieldBytes         int     `toml:"max_field_bytes"`
+	TapOutput             string  `toml:"tap_output"`
+	TapMatcher            string  `toml:"tap_matcher"`
+	TapSampleRate         float64 `toml:"tap_sample_rate"`
+	User                  string  `toml:"user"`
+	Group                 string  `toml:"group"`
+	TrackLatency          bool    `toml:"track_latency"`
+	HeartbeatInterval     string  `toml:"heartbeat_interval"`
 }
 
 func LoadHekadConfig(configPath string) (config *HekadConfig, err error) {
@@ -73,6 +82,8 @@ func LoadHekadConfig(configPath string) (config *HekadConfig, err error) {
 		Hostname:              hostname,
 		LogFlags:              log.LstdFlags,
 		FullBufferMaxRetries:  10,
+		TapMatcher:            "TRUE",
+		TapSampleRate:         1,
 	}
 
 	var configFile map[string]toml.Primitive
@@ -40,6 +40,7 @@ import (
 	_ "github.com/mozilla-services/heka/plugins/dasher"
 	_ "github.com/mozilla-services/heka/plugins/elasticsearch"
 	_ "github.com/mozilla-services/heka/plugins/file"
+	_ "github.com/mozilla-services/heka/plugins/fluentd"
 	_ "github.com/mozilla-services/heka/plugins/graphite"
 	_ "github.com/mozilla-services/heka/plugins/http"
 	_ "github.com/mozilla-services/heka/plugins/irc"
@@ -48,6 +49,8 @@ import (
 	_ "github.com/mozilla-services/heka/plugins/nagios"
 	_ "github.com/mozilla-services/heka/plugins/payload"
 	_ "github.com/mozilla-services/heka/plugins/process"
+	_ "github.com/mozilla-services/heka/plugins/prometheus"
+	_ "github.com/mozilla-services/heka/plugins/s3"
 	_ "github.com/mozilla-services/heka/plugins/smtp"
 	_ "github.com/mozilla-services/heka/plugins/statsd"
 	_ "github.com/mozilla-services/heka/plugins/tcp"
@@ -88,6 +91,17 @@ func setGlobalConfigs(config *HekadConfig) (*pipeline.GlobalConfigStruct, string
 	globals.SampleDenominator = config.SampleDenominator
 	globals.Hostname = config.Hostname
 	globals.FullBufferMaxRetries = uint(config.FullBufferMaxRetries)
+	globals.MaxFields = config.MaxFields
+	globals.MaxFieldBytes = config.MaxFieldBytes
+	globals.TrackLatency = config.TrackLatency
+	if config.HeartbeatInterval != "" {
+		globals.HeartbeatInterval, _ = time.ParseDuration(config.HeartbeatInterval)
+	}
+	globals.Tap = pipeline.TapConfig{
+		Output:     config.TapOutput,
+		Matcher:    config.TapMatcher,
+		SampleRate: config.TapSampleRate,
+	}
 
 	return globals, cpuProfName, memProfName
 }
@@ -139,7 +153,38 @@ func main() {
 		return
 	}
 
+	if config.HeartbeatInterval != "" {
+		if _, err = time.ParseDuration(config.HeartbeatInterval); err != nil {
+			pipeline.LogError.Printf("Can't parse `heartbeat_interval` time duration: %s\n",
+				config.HeartbeatInterval)
+			exitCode = 1
+			return
+		}
+	}
+
+	creds, err := lookupCredentials(config.User, config.Group)
+	if err != nil {
+		pipeline.LogError.Println("Error reading config: ", err)
+		exitCode = 1
+		return
+	}
+
+	configHash, err := pipeline.HashConfig(*configPath)
+	if err != nil {
+		pipeline.LogError.Println("Error reading config: ", err)
+		exitCode = 1
+		return
+	}
+
 	globals, cpuProfName, memProfName := setGlobalConfigs(config)
+	globals.Version = VERSION
+	globals.ConfigHash = configHash
+	globals.ConfigPath = *configPath
+	if creds != nil {
+		globals.DropPrivileges = func() error {
+			return dropPrivileges(creds)
+		}
+	}
 
 	if err = os.MkdirAll(globals.BaseDir, 0755); err != nil {
 		pipeline.LogError.Printf("Error creating 'base_dir' %s: %s", config.BaseDir, err)
@@ -235,33 +280,14 @@ func main() {
 }
 
 func loadFullConfig(pipeconf *pipeline.PipelineConfig, configPath *string) (err error) {
-	p, err := os.Open(*configPath)
+	filenames, err := pipeline.ConfigFiles(*configPath)
 	if err != nil {
-		return fmt.Errorf("error opening file: %s", err.Error())
+		return err
 	}
-	fi, err := p.Stat()
-	if err != nil {
-		return fmt.Errorf("can't stat file: %s", err.Error())
-	}
-
-	if fi.IsDir() {
-		files, _ := ioutil.ReadDir(*configPath)
-		for _, f := range files {
-			fName := f.Name()
-			if !strings.HasSuffix(fName, ".toml") {
-				// Skip non *.toml files in a config dir.
-				continue
-			}
-			err = pipeconf.PreloadFromConfigFile(filepath.Join(*configPath, fName))
-			if err != nil {
-				break
-			}
+	for _, filename := range filenames {
+		if err = pipeconf.PreloadFromConfigFile(filename); err != nil {
+			return err
 		}
-	} else {
-		err = pipeconf.PreloadFromConfigFile(*configPath)
-	}
-	if err == nil {
-		err = pipeconf.LoadConfig()
 	}
-	return err
+	return pipeconf.LoadConfig()
 }
@@ -0,0 +1,109 @@
+// +build !windows
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The group database read by lookupGroupId.
+var groupFile = "/etc/group"
+
+// User and group ids hekad switches to once its inputs have started.
+type credentials struct {
+	uid int
+	gid int
+}
+
+// Resolves the `user` and `group` config settings to numeric ids, returning
+// nil if neither is set. The group defaults to the user's primary group.
+func lookupCredentials(userName, groupName string) (*credentials, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+	creds := &credentials{uid: -1, gid: -1}
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return nil, fmt.Errorf("can't find user '%s': %s", userName, err)
+		}
+		if creds.uid, err = strconv.Atoi(u.Uid); err != nil {
+			return nil, fmt.Errorf("invalid uid for user '%s': %s", userName, u.Uid)
+		}
+		if creds.gid, err = strconv.Atoi(u.Gid); err != nil {
+			return nil, fmt.Errorf("invalid gid for user '%s': %s", userName, u.Gid)
+		}
+	}
+	if groupName != "" {
+		gid, err := lookupGroupId(groupName)
+		if err != nil {
+			return nil, fmt.Errorf("can't find group '%s': %s", groupName, err)
+		}
+		if creds.gid, err = strconv.Atoi(gid); err != nil {
+			return nil, fmt.Errorf("invalid gid for group '%s': %s", groupName, gid)
+		}
+	}
+	return creds, nil
+}
+
+// Finds the id of the named group in the group database. The os/user package
+// can't look up groups before Go 1.7.
+func lookupGroupId(groupName string) (string, error) {
+	file, err := os.Open(groupFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Each line is name:password:gid:members.
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 3 && fields[0] == groupName {
+			return fields[2], nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no such group")
+}
+
+// Switches the process to the given group and user. The group has to be
+// changed first, since an unprivileged user isn't allowed to do it.
+func dropPrivileges(creds *credentials) error {
+	if creds.gid != -1 {
+		if err := syscall.Setgroups([]int{creds.gid}); err != nil {
+			return fmt.Errorf("can't set supplementary groups: %s", err)
+		}
+		if err := syscall.Setgid(creds.gid); err != nil {
+			return fmt.Errorf("can't set gid to %d: %s", creds.gid, err)
+		}
+	}
+	if creds.uid != -1 {
+		if err := syscall.Setuid(creds.uid); err != nil {
+			return fmt.Errorf("can't set uid to %d: %s", creds.uid, err)
+		}
+	}
+	return nil
+}
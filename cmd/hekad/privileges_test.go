@@ -0,0 +1,76 @@
+// +build !windows
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"testing"
+)
+
+func TestLookupCredentials(t *testing.T) {
+	creds, err := lookupCredentials("", "")
+	if err != nil || creds != nil {
+		t.Fatalf("Expected no credentials, got: %v, %v", creds, err)
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("Can't look up the current user: ", err)
+	}
+	creds, err = lookupCredentials(current.Username, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.uid != os.Getuid() {
+		t.Fatalf("Expected uid %d, got: %d", os.Getuid(), creds.uid)
+	}
+	if creds.gid != os.Getgid() {
+		t.Fatalf("Expected gid %d, got: %d", os.Getgid(), creds.gid)
+	}
+
+	if _, err = lookupCredentials("no-such-heka-user", ""); err == nil {
+		t.Fatal("Expected an error for an unknown user")
+	}
+	if _, err = lookupCredentials("", "no-such-heka-group"); err == nil {
+		t.Fatal("Expected an error for an unknown group")
+	}
+}
+
+func TestLookupGroupId(t *testing.T) {
+	file, err := ioutil.TempFile("", "heka-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("root:x:0:\nheka:x:1234:alice,bob\n")
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig string) { groupFile = orig }(groupFile)
+	groupFile = file.Name()
+
+	creds, err := lookupCredentials("", "heka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.uid != -1 || creds.gid != 1234 {
+		t.Fatalf("Expected uid -1 and gid 1234, got: %d, %d", creds.uid, creds.gid)
+	}
+}
@@ -0,0 +1,30 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package main
+
+import "errors"
+
+type credentials struct{}
+
+func lookupCredentials(userName, groupName string) (*credentials, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+	return nil, errors.New("'user' and 'group' aren't supported on Windows")
+}
+
+func dropPrivileges(creds *credentials) error {
+	return nil
+}
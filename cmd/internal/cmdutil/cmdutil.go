@@ -0,0 +1,61 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+// Package cmdutil holds the helpers shared by the command-line utilities that
+// read and write Heka protobuf streams, such as heka-cat and heka-convert.
+package cmdutil
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+// Returns a SplitterRunner for reading a Heka protobuf stream.
+func MakeSplitterRunner() (pipeline.SplitterRunner, error) {
+	splitter := &pipeline.HekaFramingSplitter{}
+	config := splitter.ConfigStruct().(*pipeline.HekaFramingSplitterConfig)
+	// Skip over corrupt records rather than mis-framing the rest of the
+	// stream, callers report the skipped bytes as corruption.
+	config.Resync = true
+	err := splitter.Init(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error initializing HekaFramingSplitter: %s", err)
+	}
+	srConfig := pipeline.CommonSplitterConfig{}
+	sRunner := pipeline.NewSplitterRunner("HekaFramingSplitter", splitter, srConfig)
+	return sRunner, nil
+}
+
+// Writes the message in the human readable "txt" format.
+func WriteText(out io.Writer, msg *message.Message) error {
+	_, err := fmt.Fprintf(out, "Timestamp: %s\n"+
+		"Type: %s\n"+
+		"Hostname: %s\n"+
+		"Pid: %d\n"+
+		"UUID: %s\n"+
+		"Logger: %s\n"+
+		"Payload: %s\n"+
+		"EnvVersion: %s\n"+
+		"Severity: %d\n"+
+		"Fields: %+v\n\n",
+		time.Unix(0, msg.GetTimestamp()), msg.GetType(),
+		msg.GetHostname(), msg.GetPid(), msg.GetUuidString(),
+		msg.GetLogger(), msg.GetPayload(), msg.GetEnvVersion(),
+		msg.GetSeverity(), msg.Fields)
+	return err
+}
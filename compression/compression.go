@@ -0,0 +1,261 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+// Package compression provides the compression algorithms Heka can be
+// configured to use, behind a single reader / writer interface so every
+// plugin offering compression supports the same set.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/DataDog/zstd"
+)
+
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Checks that the algorithm is supported and that the level is valid for it.
+// A level of 0 always means the algorithm's default level.
+func Validate(algorithm string, level int) error {
+	switch algorithm {
+	case None, "":
+		return nil
+	case Gzip:
+		if level != 0 && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+			return fmt.Errorf("gzip compression level must be between %d and %d",
+				gzip.BestSpeed, gzip.BestCompression)
+		}
+		return nil
+	case Zstd:
+		if level < 0 || level > 22 {
+			return fmt.Errorf("zstd compression level must be between 1 and 22")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported compression algorithm: %s", algorithm)
+}
+
+// Returns the file extension conventionally used for the algorithm, or "" if
+// there isn't one.
+func Extension(algorithm string) string {
+	switch algorithm {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// Implemented by the writers NewWriter returns for the compressing
+// algorithms, so one writer can be reused for many streams.
+type WriteResetter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// Implemented by the readers NewReader returns for the compressing
+// algorithms, so one reader can be reused for many streams as long as it
+// isn't closed.
+type ReadResetter interface {
+	io.ReadCloser
+	Reset(r io.Reader) error
+}
+
+// Returns a writer that compresses everything written to it using the given
+// algorithm and level before writing it to w. Closing the returned writer
+// flushes any buffered data but doesn't close w.
+func NewWriter(w io.Writer, algorithm string, level int) (io.WriteCloser, error) {
+	if err := Validate(algorithm, level); err != nil {
+		return nil, err
+	}
+	switch algorithm {
+	case Gzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Zstd:
+		if level == 0 {
+			level = zstd.DefaultCompression
+		}
+		return &zstdWriter{zstd.NewWriterLevel(w, level), level}, nil
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// Returns a reader that decompresses the data read from r using the given
+// algorithm.
+func NewReader(r io.Reader, algorithm string) (io.ReadCloser, error) {
+	switch algorithm {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		return &zstdReader{zstd.NewReader(r)}, nil
+	case None, "":
+		return ioutil.NopCloser(r), nil
+	}
+	return nil, fmt.Errorf("unsupported compression algorithm: %s", algorithm)
+}
+
+// Identifies the compression algorithm used for some data from its first few
+// bytes, returning None if it isn't compressed with a supported algorithm.
+func Detect(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return Zstd
+	}
+	return None
+}
+
+// The number of leading bytes Detect needs to see.
+const DetectLength = 4
+
+// Compresses whole records, such as single messages or batches of them,
+// reusing one writer for all of them. It isn't safe for concurrent use.
+type Compressor struct {
+	algorithm string
+	level     int
+	buf       bytes.Buffer
+	writer    WriteResetter
+}
+
+// Returns a Compressor using the given algorithm and level.
+func NewCompressor(algorithm string, level int) (*Compressor, error) {
+	if err := Validate(algorithm, level); err != nil {
+		return nil, err
+	}
+	return &Compressor{algorithm: algorithm, level: level}, nil
+}
+
+// Returns the compressed data, which is only valid until the next call. With
+// None the data is returned as is.
+func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	if c.algorithm == None || c.algorithm == "" {
+		return data, nil
+	}
+	c.buf.Reset()
+	if c.writer == nil {
+		w, err := NewWriter(&c.buf, c.algorithm, c.level)
+		if err != nil {
+			return nil, err
+		}
+		c.writer = w.(WriteResetter)
+	} else {
+		c.writer.Reset(&c.buf)
+	}
+	if _, err := c.writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := c.writer.Close(); err != nil {
+		return nil, err
+	}
+	return c.buf.Bytes(), nil
+}
+
+// Returned by Decompressor.Decompress for data that decompresses to more
+// than the given limit.
+var ErrTooLarge = errors.New("decompressed data exceeds the size limit")
+
+// Decompresses whole records, reusing one reader per algorithm. The zero
+// value is ready to use. It isn't safe for concurrent use.
+type Decompressor struct {
+	readers map[string]ReadResetter
+}
+
+// Decompresses the data using the given algorithm into dst, which is
+// overwritten and grown as needed, and returns the result.
+func (d *Decompressor) Decompress(dst, data []byte, algorithm string,
+	limit int64) (out []byte, err error) {
+
+	if algorithm == None || algorithm == "" {
+		if int64(len(data)) > limit {
+			return nil, ErrTooLarge
+		}
+		return append(dst[:0], data...), nil
+	}
+	reader, ok := d.readers[algorithm]
+	if ok {
+		err = reader.Reset(bytes.NewReader(data))
+	} else {
+		var rc io.ReadCloser
+		if rc, err = NewReader(bytes.NewReader(data), algorithm); err == nil {
+			reader = rc.(ReadResetter)
+			if d.readers == nil {
+				d.readers = make(map[string]ReadResetter)
+			}
+			d.readers[algorithm] = reader
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(dst[:0])
+	n, err := buf.ReadFrom(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, ErrTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// The zstd bindings can't reset their streams, so resetting starts a new one
+// at the same level. The previous stream must have been closed, which frees
+// its compression context.
+type zstdWriter struct {
+	*zstd.Writer
+	level int
+}
+
+func (z *zstdWriter) Reset(w io.Writer) {
+	z.Writer = zstd.NewWriterLevel(w, z.level)
+}
+
+type zstdReader struct {
+	io.ReadCloser
+}
+
+func (z *zstdReader) Reset(r io.Reader) error {
+	if err := z.ReadCloser.Close(); err != nil {
+		return err
+	}
+	z.ReadCloser = zstd.NewReader(r)
+	return nil
+}
@@ -0,0 +1,147 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package compression
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 100))
+	for _, algorithm := range []string{None, Gzip, Zstd} {
+		for _, level := range []int{0, 1, 9} {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, algorithm, level)
+			if err != nil {
+				t.Fatalf("%s level %d: %s", algorithm, level, err)
+			}
+			w.Write(data)
+			if err = w.Close(); err != nil {
+				t.Fatalf("%s level %d: closing: %s", algorithm, level, err)
+			}
+			if algorithm != None && buf.Len() >= len(data) {
+				t.Errorf("%s level %d didn't compress: %d bytes", algorithm, level,
+					buf.Len())
+			}
+			if detected := Detect(buf.Bytes()); detected != algorithm {
+				t.Errorf("%s level %d detected as %s", algorithm, level, detected)
+			}
+
+			r, err := NewReader(&buf, algorithm)
+			if err != nil {
+				t.Fatalf("%s level %d: %s", algorithm, level, err)
+			}
+			out, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatalf("%s level %d: reading: %s", algorithm, level, err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Errorf("%s level %d: data doesn't round trip", algorithm, level)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := map[string]int{None: 0, Gzip: 9, Zstd: 19}
+	for algorithm, level := range valid {
+		if err := Validate(algorithm, level); err != nil {
+			t.Errorf("%s level %d: unexpected error: %s", algorithm, level, err)
+		}
+	}
+	invalid := map[string]int{Gzip: 10, Zstd: 23, "lzma": 0}
+	for algorithm, level := range invalid {
+		if err := Validate(algorithm, level); err == nil {
+			t.Errorf("%s level %d: expected an error", algorithm, level)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	for _, algorithm := range []string{Gzip, Zstd} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, algorithm, 0)
+		if err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+		wr, ok := w.(WriteResetter)
+		if !ok {
+			t.Fatalf("%s writer can't be reset", algorithm)
+		}
+		var r ReadResetter
+		for _, data := range []string{"first stream", "second stream"} {
+			buf.Reset()
+			wr.Reset(&buf)
+			wr.Write([]byte(data))
+			if err = wr.Close(); err != nil {
+				t.Fatalf("%s: closing: %s", algorithm, err)
+			}
+			if r == nil {
+				reader, err := NewReader(&buf, algorithm)
+				if err != nil {
+					t.Fatalf("%s: %s", algorithm, err)
+				}
+				if r, ok = reader.(ReadResetter); !ok {
+					t.Fatalf("%s reader can't be reset", algorithm)
+				}
+			} else if err = r.Reset(&buf); err != nil {
+				t.Fatalf("%s: resetting reader: %s", algorithm, err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s: reading: %s", algorithm, err)
+			}
+			if string(out) != data {
+				t.Errorf("%s: got %q, expected %q", algorithm, out, data)
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestCompressor(t *testing.T) {
+	var d Decompressor
+	data := []byte(strings.Repeat("compress me ", 100))
+	for _, algorithm := range []string{None, Gzip, Zstd} {
+		c, err := NewCompressor(algorithm, 0)
+		if err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+		for i := 0; i < 2; i++ {
+			compressed, err := c.Compress(data)
+			if err != nil {
+				t.Fatalf("%s: %s", algorithm, err)
+			}
+			if Detect(compressed) != algorithm {
+				t.Errorf("%s: detected %s", algorithm, Detect(compressed))
+			}
+			out, err := d.Decompress(nil, compressed, algorithm, int64(len(data)))
+			if err != nil {
+				t.Fatalf("%s: decompressing: %s", algorithm, err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Errorf("%s: got %q", algorithm, out)
+			}
+			_, err = d.Decompress(nil, compressed, algorithm, int64(len(data)-1))
+			if err != ErrTooLarge {
+				t.Errorf("%s: expected ErrTooLarge, got %v", algorithm, err)
+			}
+		}
+	}
+}
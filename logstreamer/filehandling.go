@@ -276,11 +276,23 @@ func (b ByPriority) Less(i, j int) bool {
 
 // Scans a directory recursively filtering out files that match the fileMatch regexp
 func ScanDirectoryForLogfiles(directoryPath string, fileMatch *regexp.Regexp) Logfiles {
+	return ScanDirectoryForNewLogfiles(directoryPath, fileMatch, time.Time{})
+}
+
+// Like ScanDirectoryForLogfiles, but skips files last modified before
+// newerThan while walking the directory, so they're never matched or
+// stat'ed again. A zero newerThan includes all files.
+func ScanDirectoryForNewLogfiles(directoryPath string, fileMatch *regexp.Regexp,
+	newerThan time.Time) Logfiles {
+
 	files := make(Logfiles, 0)
 	filepath.Walk(directoryPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() {
 			return nil
 		}
+		if !newerThan.IsZero() && !info.ModTime().After(newerThan) {
+			return nil
+		}
 		if fileMatch.MatchString(path) {
 			files = append(files, &Logfile{FileName: path})
 		}
@@ -308,6 +320,10 @@ type Logstream struct {
 	saveBuffer []byte
 	// Records whether the prior read hit an EOF
 	priorEOF bool
+	// Set when we stopped reading a compressed file that's still being
+	// written, to the number of bytes already read past the saved position,
+	// which are skipped when the file is reopened.
+	reopenSkip int64
 	// LogstreamSet to which this stream belongs, needed so we can trigger
 	// file rescanning when necessary.
 	set *LogstreamSet
@@ -370,15 +386,17 @@ type LogfilesMap map[string]Logfiles
 // A set of logstreams along with utility functions for rescanning and reparsing
 // logfiles into each logstream
 type LogstreamSet struct {
-	logstreams     map[string]*Logstream
-	rescanInterval time.Duration  // Frequency of full rescan
-	oldestDuration time.Duration  // Filter logfiles older than this duration ago
-	sortPattern    *SortPattern   // Used for creating logstreams and updating logfiles
-	logstreamMutex *sync.RWMutex  // Locking for manipulation of logstreams
-	logRoot        string         // Base path to walk for logfiles (ie, /var/log)
-	journalRoot    string         // Base path for journal files (ie, /etc/journals)
-	fileMatch      *regexp.Regexp // File match for regular expression
-	initialTail    bool           // Whether to ignore previous logfiles while initial scan
+	logstreams      map[string]*Logstream
+	rescanInterval  time.Duration  // Frequency of full rescan
+	oldestDuration  time.Duration  // Filter logfiles older than this duration ago
+	ignoreOlderThan time.Duration  // Skip logfiles older than this while scanning
+	sortPattern     *SortPattern   // Used for creating logstreams and updating logfiles
+	logstreamMutex  *sync.RWMutex  // Locking for manipulation of logstreams
+	logRoot         string         // Base path to walk for logfiles (ie, /var/log)
+	journalRoot     string         // Base path for journal files (ie, /etc/journals)
+	fileMatch       *regexp.Regexp // File match for regular expression
+	initialTail     bool           // Whether to ignore previous logfiles while initial scan
+	replay          *ReplayPoint   // Where to start reading instead of the journal position
 }
 
 // append a path separator if needed and escape regexp meta characters
@@ -420,6 +438,28 @@ func NewLogstreamSet(sortPattern *SortPattern, oldest time.Duration,
 	return ls, nil
 }
 
+// Sets a point that logstreams found from now on will start reading from,
+// overriding their journal position. The replay point is recorded in the
+// journal so it's only applied once, restarting with the same replay point
+// resumes from the journal as usual.
+func (ls *LogstreamSet) SetReplayPoint(rp *ReplayPoint) {
+	ls.replay = rp
+}
+
+// Sets an age beyond which logfiles are excluded from the logstreams while
+// the log directory is walked. Unlike the oldest duration, a logstream whose
+// current logfile ages out moves on to the next logfile once it's finished.
+func (ls *LogstreamSet) SetIgnoreOlderThan(d time.Duration) {
+	ls.ignoreOlderThan = d
+}
+
+// Whether a logfile last modified at modTime is excluded by the ignore older
+// than setting.
+func (ls *LogstreamSet) ignored(modTime time.Time) bool {
+	return ls.ignoreOlderThan != time.Duration(0) &&
+		!modTime.After(time.Now().Add(-ls.ignoreOlderThan))
+}
+
 // Access a logstream by name if it exists
 func (ls *LogstreamSet) GetLogstream(name string) (l *Logstream, ok bool) {
 	ls.logstreamMutex.RLock()
@@ -450,8 +490,12 @@ func (ls *LogstreamSet) ScanForLogstreams() (result []string, errors *MultipleEr
 	result = make([]string, 0, 0)
 	errors = NewMultipleError()
 
-	// Scan for all our logfiles
-	logfiles := ScanDirectoryForLogfiles(ls.logRoot, ls.fileMatch)
+	// Scan for all our logfiles, skipping the ones we ignore outright
+	var newerThan time.Time
+	if ls.ignoreOlderThan != time.Duration(0) {
+		newerThan = time.Now().Add(-ls.ignoreOlderThan)
+	}
+	logfiles := ScanDirectoryForNewLogfiles(ls.logRoot, ls.fileMatch, newerThan)
 
 	// Filter out old logfiles
 	if ls.oldestDuration != time.Duration(0) {
@@ -504,9 +548,14 @@ func (ls *LogstreamSet) ScanForLogstreams() (result []string, errors *MultipleEr
 		if !ok {
 			result = append(result, name)
 
-			// There's no journal file, we consider it was not scaned before.
-			// So initialTail can take effect.
-			if logstream.position.IsZero() && ls.initialTail {
+			if ls.replay != nil && logstream.position.ReplayFrom != ls.replay.Spec {
+				if err := ls.replay.Position(logstream.position, newLogfiles); err != nil {
+					errors.AddMessage(fmt.Sprintf("Can't move logstream %s to replay "+
+						"point %s: %s", name, ls.replay.Spec, err))
+				}
+			} else if logstream.position.IsZero() && ls.initialTail {
+				// There's no journal file, we consider it was not scaned
+				// before. So initialTail can take effect.
 				logstream.position.SetToTail(newLogfiles[len(newLogfiles)-1].FileName)
 			}
 
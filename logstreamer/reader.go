@@ -17,7 +17,6 @@ package logstreamer
 
 import (
 	"bytes"
-	"compress/gzip"
 	"crypto/sha1"
 	"encoding/json"
 	"errors"
@@ -25,7 +24,10 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/mozilla-services/heka/compression"
 	"github.com/mozilla-services/heka/ringbuf"
 )
 
@@ -34,6 +36,7 @@ type LogstreamLocation struct {
 	SeekPosition int64  `json:"seek"`
 	Filename     string `json:"file_name"`
 	Hash         string `json:"last_hash"`
+	ReplayFrom   string `json:"replay_from,omitempty"`
 	JournalPath  string `json:"-"`
 	lastLine     *ringbuf.Ringbuf
 }
@@ -163,6 +166,39 @@ func (l *LogstreamLocation) SetToTail(filePath string) (err error) {
 	return
 }
 
+// Sets the position to the given byte offset in the file, which for compressed
+// files is an offset into the uncompressed contents.
+func (l *LogstreamLocation) SetToOffset(filePath string, offset int64) (err error) {
+	var fd *os.File
+	if fd, err = os.Open(filePath); err != nil {
+		return
+	}
+	defer fd.Close()
+	var reader io.Reader
+	if reader, err = createFileReader(filePath, fd); err != nil {
+		return
+	}
+
+	// The hash covers the data just before the offset.
+	hashStart := offset - int64(LINEBUFFERLEN)
+	if hashStart < 0 {
+		hashStart = 0
+	}
+	if _, err = io.CopyN(ioutil.Discard, reader, hashStart); err != nil {
+		return
+	}
+	buf := make([]byte, offset-hashStart)
+	if _, err = io.ReadFull(reader, buf); err != nil {
+		return
+	}
+
+	l.Filename = filePath
+	l.SeekPosition = offset
+	l.lastLine.Write(buf)
+	l.GenerateHash()
+	return
+}
+
 func (l *LogstreamLocation) IsZero() bool {
 	return l.SeekPosition == 0 && l.Filename == "" && l.Hash == ""
 }
@@ -211,18 +247,20 @@ func (l *Logstream) NewerFileAvailable() (file string, ok bool) {
 		return "", false
 	}
 	fInfo, err := os.Stat(l.position.Filename)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return "", false
 	}
 
 	priorWasEmpty := false
 	lastSize := currentInfo.Size()
-	newSize := fInfo.Size()
-	// 1. If our size is greater than the file at this filename, we're not the
-	// same file.
-	if lastSize > newSize {
+	// 1. If there's no longer a file at this filename, e.g. because it was
+	// compressed after rotation, or if our size is greater than the file at
+	// this filename, we're not the same file.
+	if err != nil {
 		ok = true
-	} else if lastSize < newSize && lastSize == 0 {
+	} else if lastSize > fInfo.Size() {
+		ok = true
+	} else if lastSize < fInfo.Size() && lastSize == 0 {
 		// We have to double-check for cases where an empty file might have
 		// been deleted out from under us.
 		priorWasEmpty = true
@@ -274,6 +312,11 @@ func (l *Logstream) NewerFileAvailable() (file string, ok bool) {
 	defer l.lfMutex.RUnlock()
 	fileIndex := l.logfiles.IndexOf(l.position.Filename)
 	if fileIndex == -1 {
+		// Our file aged out of the list, so everything left in it is newer
+		if fInfo != nil && l.set != nil && l.set.ignored(fInfo.ModTime()) &&
+			len(l.logfiles) > 0 {
+			return l.logfiles[0].FileName, true
+		}
 		// We couldn't find our filename in the list? Then there's nothing
 		// newer
 		return file, ok
@@ -354,7 +397,7 @@ func (l *Logstream) LocatePriorLocation(checkFilename bool) (fd *os.File, reader
 		if err != nil {
 			return
 		}
-		if !isGzipFile(logfile.FileName) {
+		if !isCompressedFile(logfile.FileName) {
 			if info.Size() < l.position.SeekPosition {
 				continue
 			}
@@ -380,31 +423,43 @@ func (l *Logstream) LocatePriorLocation(checkFilename bool) (fd *os.File, reader
 	return
 }
 
-// Returns an io.Reader. If file is gzipped, returns a gzip.Reader.
+// Returns an io.Reader. If file is gzip or zstd compressed, returns a reader
+// that decompresses it.
 func createFileReader(path string, fd *os.File) (reader io.Reader, err error) {
-	if isGzipFile(path) {
-		reader, err = gzip.NewReader(fd)
+	if algorithm := fileCompression(path); algorithm != compression.None {
+		reader, err = compression.NewReader(fd, algorithm)
 	} else {
 		reader = fd
 	}
 	return
 }
 
-// Guesses if the given file is gzipped.
-func isGzipFile(path string) bool {
+// Guesses the compression used for the given file from its extension, or
+// failing that from its magic number. Going by the extension first keeps a
+// rotated `.gz` file that's still empty, or still being written by the
+// compressor, from being read as plain text.
+func fileCompression(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, algorithm := range []string{compression.Gzip, compression.Zstd} {
+		if ext == compression.Extension(algorithm) {
+			return algorithm
+		}
+	}
+
 	file, err := os.Open(path)
 	if err != nil {
-		return false
+		return compression.None
 	}
 	defer file.Close()
 
-	magic := make([]byte, 2)
-	numbytes, err := file.Read(magic)
-	if numbytes != 2 || err != nil {
-		return false
-	}
+	magic := make([]byte, compression.DetectLength)
+	numbytes, _ := io.ReadFull(file, magic)
+	return compression.Detect(magic[:numbytes])
+}
 
-	return (magic[0] == 0x1f && magic[1] == 0x8b)
+// Guesses if the given file is compressed.
+func isCompressedFile(path string) bool {
+	return fileCompression(path) != compression.None
 }
 
 var ErrorCantSeekPosition = errors.New("Unable to locate position")
@@ -417,9 +472,9 @@ var ErrorCantGzipToPosition = errors.New("Couldn't read gzip to seek position")
 // the file so there's no hash to compare, then it will return the open file
 // descriptor and related io.Reader. If they do not, or anything goes wrong
 // along the way, then an error will be returned. Note that the fd and the
-// io.Reader will be the same except in cases where the file was gzipped, in
-// which case the io.Reader will be the gzip reader and not the raw file
-// descriptor.
+// io.Reader will be the same except in cases where the file was gzip or zstd
+// compressed, in which case the io.Reader will be the decompressing reader and
+// not the raw file descriptor.
 func SeekInFile(path string, position *LogstreamLocation) (*os.File, io.Reader, error) {
 	fd, err := os.Open(path)
 	if err != nil {
@@ -436,9 +491,8 @@ func SeekInFile(path string, position *LogstreamLocation) (*os.File, io.Reader,
 	}
 
 	seekPos := position.SeekPosition - int64(LINEBUFFERLEN)
-	gzipped := isGzipFile(path)
-	if gzipped {
-		reader, err = gzip.NewReader(fd)
+	if algorithm := fileCompression(path); algorithm != compression.None {
+		reader, err = compression.NewReader(fd, algorithm)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -549,6 +603,14 @@ func (l *Logstream) Read(p []byte) (n int, err error) {
 	var reader io.Reader
 	if l.position.Filename != "" {
 		if fd, reader, err = l.LocatePriorLocation(true); err == nil {
+			if l.reopenSkip > 0 {
+				_, err = io.CopyN(ioutil.Discard, reader, l.reopenSkip)
+				if err != nil {
+					fd.Close()
+					return 0, io.EOF
+				}
+				l.reopenSkip = 0
+			}
 			l.fd = fd
 			l.reader = reader
 			return l.readBytes(p)
@@ -614,6 +676,19 @@ func (l *Logstream) readBytes(p []byte) (n int, err error) {
 		return
 	}
 
+	if err == io.ErrUnexpectedEOF && l.reader != io.Reader(l.fd) {
+		// A compressed file that's still being written. The decompressor
+		// can't continue past this point, so we close the file, keeping our
+		// position, and reopen it on the next read.
+		l.priorEOF = false
+		l.position.GenerateHash()
+		l.fd.Close()
+		l.fd = nil
+		l.reader = nil
+		l.reopenSkip = int64(len(l.saveBuffer))
+		return n, io.EOF
+	}
+
 	if err != io.EOF {
 		// Had an EOF before, clear it
 		if l.priorEOF {
@@ -663,7 +738,7 @@ func (l *Logstream) readBytes(p []byte) (n int, err error) {
 		return
 	}
 
-	// Create a gzip reader if needed.
+	// Create a decompressing reader if needed.
 	var reader io.Reader
 	reader, err = createFileReader(newerFilename, fd)
 	if err != nil {
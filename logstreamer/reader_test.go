@@ -16,12 +16,19 @@
 package logstreamer
 
 import (
-	"github.com/mozilla-services/heka/ringbuf"
-	gs "github.com/rafrombrc/gospec/src/gospec"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"time"
+
+	"github.com/mozilla-services/heka/compression"
+	"github.com/mozilla-services/heka/ringbuf"
+	gs "github.com/rafrombrc/gospec/src/gospec"
 )
 
 func ReaderSpec(c gs.Context) {
@@ -218,4 +225,159 @@ func ReaderSpec(c gs.Context) {
 		c.Expect(ls.position.Hash, gs.Equals, "72781af95a1583690cc97548fdcf3bb0efbe3119")
 		c.Expect(ls.position.Filename[len(testDirPath):], gs.Equals, "/2013/08/error.log")
 	})
+
+	c.Specify("A replay point", func() {
+		c.Specify("can be parsed", func() {
+			rp, err := ParseReplayPoint("1200")
+			c.Expect(err, gs.IsNil)
+			c.Expect(rp.Offset, gs.Equals, int64(1200))
+			c.Expect(rp.isTime, gs.IsFalse)
+
+			rp, err = ParseReplayPoint("2016-03-08T17:00:00Z")
+			c.Expect(err, gs.IsNil)
+			c.Expect(rp.isTime, gs.IsTrue)
+			c.Expect(rp.Time.Unix(), gs.Equals, int64(1457456400))
+
+			_, err = ParseReplayPoint("-5")
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = ParseReplayPoint("yesterday")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		regex := `/(?P<Year>\d+)/(?P<Month>\d+)/error\.log(\.(?P<Seq>\d+))?`
+		if runtime.GOOS == "windows" {
+			regex = `\\(?P<Year>\d+)\\(?P<Month>\d+)\\error\.log(\.(?P<Seq>\d+))?`
+		}
+		sp := &SortPattern{
+			FileMatch:      regex,
+			Translation:    make(SubmatchTranslationMap),
+			Priority:       []string{"Year", "Month", "^Seq"},
+			Differentiator: []string{"errorlog"},
+		}
+		lss, err := NewLogstreamSet(sp, 0, testDirPath, dirPath, true)
+		c.Assume(err, gs.IsNil)
+
+		c.Specify("overrides initial_tail", func() {
+			rp, _ := ParseReplayPoint("500")
+			lss.SetReplayPoint(rp)
+			names, _ := lss.ScanForLogstreams()
+			c.Assume(len(names), gs.Equals, 1)
+			position := lss.logstreams[names[0]].position
+			c.Expect(position.Filename[len(testDirPath):], gs.Equals, "/2010/07/error.log.2")
+			c.Expect(position.SeekPosition, gs.Equals, int64(500))
+			c.Expect(position.Hash, gs.Equals, "dc6d00ed4a287968635b8b5b96a505547e9161d3")
+			c.Expect(position.ReplayFrom, gs.Equals, "500")
+		})
+
+		c.Specify("can span logfiles", func() {
+			// error.log.2 is 1160 bytes and error.log.1 is empty.
+			rp, _ := ParseReplayPoint("1200")
+			lss.SetReplayPoint(rp)
+			names, _ := lss.ScanForLogstreams()
+			c.Assume(len(names), gs.Equals, 1)
+			position := lss.logstreams[names[0]].position
+			c.Expect(position.Filename[len(testDirPath):], gs.Equals, "/2010/07/error.log")
+			c.Expect(position.SeekPosition, gs.Equals, int64(40))
+		})
+	})
+
+	c.Specify("A gzipped logfile", func() {
+		tmpDir, err := ioutil.TempDir("", "logstreamer-gz")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(tmpDir)
+		path := filepath.Join(tmpDir, "app.log.1.gz")
+
+		c.Specify("is detected by its extension even when empty", func() {
+			err := ioutil.WriteFile(path, nil, 0644)
+			c.Assume(err, gs.IsNil)
+			c.Expect(fileCompression(path), gs.Equals, compression.Gzip)
+		})
+
+		c.Specify("that's still being written is read once complete", func() {
+			var content, compressed bytes.Buffer
+			for i := 0; i < 2000; i++ {
+				fmt.Fprintf(&content, "line %d of the rotated log\n", i)
+			}
+			gz := gzip.NewWriter(&compressed)
+			gz.Write(content.Bytes())
+			gz.Close()
+			half := compressed.Len() / 2
+			err := ioutil.WriteFile(path, compressed.Bytes()[:half], 0644)
+			c.Assume(err, gs.IsNil)
+
+			position := &LogstreamLocation{Filename: path, lastLine: ringbuf.New(LINEBUFFERLEN)}
+			stream := NewLogstream(Logfiles{&Logfile{FileName: path}}, position, nil)
+
+			var out bytes.Buffer
+			b := make([]byte, 4096)
+			readAll := func() {
+				for {
+					n, err := stream.Read(b)
+					out.Write(b[:n])
+					if err != nil {
+						c.Expect(err, gs.Equals, io.EOF)
+						return
+					}
+				}
+			}
+			readAll()
+			c.Expect(out.Len() > 0, gs.IsTrue)
+			c.Expect(out.Len() < content.Len(), gs.IsTrue)
+			c.Expect(position.Filename, gs.Equals, path)
+
+			err = ioutil.WriteFile(path, compressed.Bytes(), 0644)
+			c.Assume(err, gs.IsNil)
+			readAll()
+			c.Expect(out.String(), gs.Equals, content.String())
+		})
+	})
+
+	c.Specify("Logfiles older than the ignore older than duration", func() {
+		tmpDir, err := ioutil.TempDir("", "logstreamer-age")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(tmpDir)
+		oldPath := filepath.Join(tmpDir, "app.log.1")
+		newPath := filepath.Join(tmpDir, "app.log")
+		err = ioutil.WriteFile(oldPath, []byte("old line\n"), 0644)
+		c.Assume(err, gs.IsNil)
+		err = ioutil.WriteFile(newPath, []byte("new line\n"), 0644)
+		c.Assume(err, gs.IsNil)
+		lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+		c.Assume(os.Chtimes(oldPath, lastWeek, lastWeek), gs.IsNil)
+
+		sp := &SortPattern{
+			FileMatch:      `app\.log(\.(?P<Seq>\d+))?$`,
+			Translation:    make(SubmatchTranslationMap),
+			Priority:       []string{"^Seq"},
+			Differentiator: []string{"app"},
+		}
+		lss, err := NewLogstreamSet(sp, 0, tmpDir, tmpDir, false)
+		c.Assume(err, gs.IsNil)
+		lss.SetIgnoreOlderThan(24 * time.Hour)
+
+		c.Specify("are left out of the logstream", func() {
+			names, errs := lss.ScanForLogstreams()
+			c.Expect(errs.IsError(), gs.IsFalse)
+			c.Assume(len(names), gs.Equals, 1)
+			logfiles := lss.logstreams[names[0]].GetLogfiles()
+			c.Expect(len(logfiles), gs.Equals, 1)
+			c.Expect(logfiles[0].FileName, gs.Equals, newPath)
+		})
+
+		c.Specify("are moved on from once finished", func() {
+			names, _ := lss.ScanForLogstreams()
+			c.Assume(len(names), gs.Equals, 1)
+			stream := lss.logstreams[names[0]]
+			stream.position.Filename = oldPath
+
+			b := make([]byte, 100)
+			n, err := stream.Read(b)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(b[:n]), gs.Equals, "old line\n")
+			n, err = stream.Read(b)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(b[:n]), gs.Equals, "new line\n")
+			c.Expect(stream.position.Filename, gs.Equals, newPath)
+		})
+	})
 }
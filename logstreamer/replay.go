@@ -0,0 +1,119 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package logstreamer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// A point in a logstream to start reading from instead of the position stored
+// in the journal. Either a time, meaning the start of the oldest logfile
+// modified at or after that time, or a byte offset from the beginning of the
+// logstream, i.e. into the concatenation of all of its logfiles.
+type ReplayPoint struct {
+	// The setting the point was parsed from, recorded in the journal so the
+	// replay only happens once.
+	Spec   string
+	Time   time.Time
+	Offset int64
+	isTime bool
+}
+
+// Parses an RFC 3339 timestamp or a non-negative integer byte offset into a
+// ReplayPoint.
+func ParseReplayPoint(spec string) (*ReplayPoint, error) {
+	rp := &ReplayPoint{Spec: spec}
+	if offset, err := strconv.ParseInt(spec, 10, 64); err == nil {
+		if offset < 0 {
+			return nil, fmt.Errorf("replay offset can't be negative: %d", offset)
+		}
+		rp.Offset = offset
+		return rp, nil
+	}
+	t, err := time.Parse(time.RFC3339, spec)
+	if err != nil {
+		return nil, fmt.Errorf("replay point must be an RFC 3339 timestamp or a "+
+			"byte offset: %s", spec)
+	}
+	rp.Time = t
+	rp.isTime = true
+	return rp, nil
+}
+
+// Moves the position to the replay point within the provided logfiles, which
+// must be sorted oldest first. If the replay point is past the end of the
+// logstream the position is set to the end of the newest logfile.
+func (rp *ReplayPoint) Position(position *LogstreamLocation, logfiles Logfiles) (
+	err error) {
+
+	if len(logfiles) == 0 {
+		return nil
+	}
+	position.Reset()
+	position.ReplayFrom = rp.Spec
+
+	if rp.isTime {
+		for _, logfile := range logfiles {
+			info, err := os.Stat(logfile.FileName)
+			if err != nil {
+				return err
+			}
+			if !info.ModTime().Before(rp.Time) {
+				position.Filename = logfile.FileName
+				return nil
+			}
+		}
+	} else {
+		remaining := rp.Offset
+		for _, logfile := range logfiles {
+			size, err := logfileSize(logfile.FileName)
+			if err != nil {
+				return err
+			}
+			if remaining < size {
+				return position.SetToOffset(logfile.FileName, remaining)
+			}
+			remaining -= size
+		}
+	}
+	return position.SetToTail(logfiles[len(logfiles)-1].FileName)
+}
+
+// Returns the number of bytes a logfile will yield when read, which for
+// compressed files is the uncompressed size.
+func logfileSize(path string) (int64, error) {
+	if !isCompressedFile(path) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	reader, err := createFileReader(path, fd)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(ioutil.Discard, reader)
+}
@@ -30,6 +30,7 @@ func TestAllSpecs(t *testing.T) {
 	r.AddSpec(MessageFieldsSpec)
 	r.AddSpec(MessageEqualsSpec)
 	r.AddSpec(MatcherSpecificationSpec)
+	r.AddSpec(SyslogSpec)
 	gospec.MainGoTest(r, t)
 }
 
@@ -40,6 +40,14 @@ var (
 		"StampMicro":  time.StampMicro,
 		"StampNano":   time.StampNano,
 	}
+
+	// Common log timestamp formats that can be referred to by name in a list
+	// of candidate layouts, in addition to the basicTimeLayouts names.
+	namedTimeLayouts = map[string]string{
+		"syslog":  time.Stamp,
+		"apache":  "02/Jan/2006:15:04:05 -0700",
+		"iso8601": "2006-01-02T15:04:05.999999999Z0700",
+	}
 )
 
 // Parse a time with the supplied timeLayout, falling back to all the
@@ -113,3 +121,43 @@ func ForgivingTimeParse(timeLayout, inputTime string, loc *time.Location) (time.
 	}
 	return parsedTime, err
 }
+
+// Parse a time by trying each of the supplied layouts in turn, returning the
+// time from the first one that succeeds along with that layout. Layouts can
+// be Go time layout strings, the names of the Go time package layout
+// constants (e.g. "RFC3339"), "syslog", "apache", "iso8601", or one of the
+// "Epoch" formats. Unlike ForgivingTimeParse there's no fallback to other
+// layouts.
+func ParseTimeLayouts(layouts []string, inputTime string,
+	loc *time.Location) (parsedTime time.Time, layout string, err error) {
+
+	if len(layouts) == 0 {
+		return parsedTime, "", fmt.Errorf("No time layouts to parse '%s' with",
+			inputTime)
+	}
+	for _, layout = range layouts {
+		if strings.HasPrefix(layout, "Epoch") {
+			parsedTime, err = ForgivingTimeParse(layout, inputTime, loc)
+		} else {
+			parsedTime, err = time.ParseInLocation(ResolveTimeLayout(layout),
+				inputTime, loc)
+		}
+		if err == nil {
+			return parsedTime, layout, nil
+		}
+	}
+	return parsedTime, "", fmt.Errorf("'%s' doesn't match any of the time layouts",
+		inputTime)
+}
+
+// Returns the Go time layout string for a named layout, or the provided
+// string unchanged if it isn't a known name.
+func ResolveTimeLayout(name string) string {
+	if layout, ok := basicTimeLayouts[name]; ok {
+		return layout
+	}
+	if layout, ok := namedTimeLayouts[name]; ok {
+		return layout
+	}
+	return name
+}
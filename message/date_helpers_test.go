@@ -16,6 +16,7 @@ package message
 
 import (
 	"testing"
+	"time"
 )
 
 func TestEpochInt(t *testing.T) {
@@ -107,3 +108,40 @@ func TestEpochNanoFloat(t *testing.T) {
 		t.Errorf("Wrong EpochNano time w/ float: %d", ts.UnixNano())
 	}
 }
+
+func TestParseTimeLayouts(t *testing.T) {
+	layouts := []string{"RFC3339", "apache", "syslog", "EpochMilli"}
+	tests := []struct {
+		input  string
+		layout string
+		nanos  int64
+	}{
+		{"2014-10-27T22:17:14Z", "RFC3339", 1414448234000000000},
+		{"27/Oct/2014:15:17:14 -0700", "apache", 1414448234000000000},
+		{"1414448234638", "EpochMilli", 1414448234638000000},
+	}
+	for _, test := range tests {
+		ts, layout, err := ParseTimeLayouts(layouts, test.input, time.UTC)
+		if err != nil {
+			t.Errorf("Error parsing '%s': %s", test.input, err)
+			continue
+		}
+		if layout != test.layout {
+			t.Errorf("Wrong layout for '%s': %s", test.input, layout)
+		}
+		if ts.UnixNano() != test.nanos {
+			t.Errorf("Wrong time for '%s': %d", test.input, ts.UnixNano())
+		}
+	}
+
+	ts, layout, err := ParseTimeLayouts(layouts, "Oct 27 22:17:14", time.UTC)
+	if err != nil || layout != "syslog" {
+		t.Errorf("Error parsing syslog time: %s", err)
+	} else if ts.Month() != time.October || ts.Hour() != 22 {
+		t.Errorf("Wrong syslog time: %s", ts)
+	}
+
+	if _, _, err = ParseTimeLayouts(layouts, "yesterday", time.UTC); err == nil {
+		t.Error("Expected an error for an unparseable time")
+	}
+}
@@ -14,12 +14,16 @@
 
 package message
 
-import "strings"
+import (
+	"regexp"
+	"strings"
+)
 
 // MatcherSpecification used by the message router to distribute messages
 type MatcherSpecification struct {
-	vm   *tree
-	spec string
+	vm          *tree
+	spec        string
+	hasCaptures bool
 }
 
 // CreateMatcherSpecification compiles the spec string into a simple
@@ -31,13 +35,41 @@ func CreateMatcherSpecification(spec string) (*MatcherSpecification, error) {
 	if err != nil {
 		return nil, err
 	}
+	ms.hasCaptures = hasNamedGroups(ms.vm)
 	return ms, nil
 }
 
 // Match compares the message against the matcher spec and return the match
 // result
 func (m *MatcherSpecification) Match(message *Message) bool {
-	return evalMatcherSpecification(m.vm, message)
+	return evalMatcherSpecification(m.vm, message, nil)
+}
+
+// HasCaptures returns whether any of the spec's regular expressions has a
+// named group, i.e. whether MatchCaptures can return any captures.
+func (m *MatcherSpecification) HasCaptures() bool {
+	return m.hasCaptures
+}
+
+// MatchCaptures compares the message against the matcher spec like Match,
+// and also returns the values of the named groups (e.g. `(?P<ver>v\d+)`) of
+// the regular expressions that matched. Captures is nil if the message
+// didn't match or the spec has no named groups.
+func (m *MatcherSpecification) MatchCaptures(message *Message) (b bool,
+	captures map[string]string) {
+
+	if !m.hasCaptures {
+		return evalMatcherSpecification(m.vm, message, nil), nil
+	}
+	var pairs []string
+	if b = evalMatcherSpecification(m.vm, message, &pairs); !b {
+		return
+	}
+	captures = make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		captures[pairs[i]] = pairs[i+1]
+	}
+	return
 }
 
 // String outputs the spec as text
@@ -45,15 +77,49 @@ func (m *MatcherSpecification) String() string {
 	return m.spec
 }
 
-func evalMatcherSpecification(t *tree, msg *Message) (b bool) {
+// Returns whether any regular expression in the tree has a named group.
+func hasNamedGroups(t *tree) bool {
 	if t == nil {
 		return false
 	}
+	if re := t.stmt.value.regexp; re != nil && t.stmt.op.tokenId == OP_RE &&
+		hasNamedGroup(re) {
+		return true
+	}
+	return hasNamedGroups(t.left) || hasNamedGroups(t.right)
+}
+
+// Returns whether the regular expression has a named group. These are kept
+// as regular expressions instead of being turned into prefix or suffix tests.
+func hasNamedGroup(re *regexp.Regexp) bool {
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluates the tree against the message. If captures isn't nil the named
+// groups of the matching regular expressions are appended to it as name,
+// value pairs, dropping those of subtrees that evaluated to false.
+func evalMatcherSpecification(t *tree, msg *Message, captures *[]string) (b bool) {
+	if t == nil {
+		return false
+	}
+	if captures != nil {
+		n := len(*captures)
+		defer func() {
+			if !b {
+				*captures = (*captures)[:n]
+			}
+		}()
+	}
 
 	if t.left != nil {
-		b = evalMatcherSpecification(t.left, msg)
+		b = evalMatcherSpecification(t.left, msg, captures)
 	} else {
-		return testExpr(msg, t.stmt)
+		return testExpr(msg, t.stmt, captures)
 	}
 	if b == true && t.stmt.op.tokenId == OP_OR {
 		return // short circuit
@@ -63,7 +129,7 @@ func evalMatcherSpecification(t *tree, msg *Message) (b bool) {
 	}
 
 	if t.right != nil {
-		b = evalMatcherSpecification(t.right, msg)
+		b = evalMatcherSpecification(t.right, msg, captures)
 	}
 	return
 }
@@ -98,7 +164,22 @@ func getNumericValue(msg *Message, stmt *Statement) float64 {
 	return 0
 }
 
-func stringTest(s string, stmt *Statement) bool {
+// Appends the named groups of the regular expression's match in s to
+// captures, returning whether it matched.
+func captureGroups(re *regexp.Regexp, s string, captures *[]string) bool {
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return false
+	}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			*captures = append(*captures, name, m[i])
+		}
+	}
+	return true
+}
+
+func stringTest(s string, stmt *Statement, captures *[]string) bool {
 	if stmt.value.tokenId == NUMERIC_VALUE {
 		return false
 	}
@@ -123,6 +204,9 @@ func stringTest(s string, stmt *Statement) bool {
 		return (s >= stmt.value.token)
 	case OP_RE:
 		if stmt.value.regexp != nil {
+			if captures != nil {
+				return captureGroups(stmt.value.regexp, s, captures)
+			}
 			return stmt.value.regexp.MatchString(s)
 		} else if stmt.value.fieldIndex == STARTS_WITH {
 			return strings.HasPrefix(s, stmt.value.token)
@@ -172,7 +256,7 @@ func testNonExistence(stmt *Statement) bool {
 	return (stmt.value.tokenId == NIL_VALUE && stmt.op.tokenId == OP_EQ)
 }
 
-func testExpr(msg *Message, stmt *Statement) bool {
+func testExpr(msg *Message, stmt *Statement, captures *[]string) bool {
 	switch stmt.op.tokenId {
 	case TRUE:
 		return true
@@ -182,7 +266,7 @@ func testExpr(msg *Message, stmt *Statement) bool {
 		switch stmt.field.tokenId {
 		case VAR_UUID, VAR_TYPE, VAR_LOGGER, VAR_PAYLOAD,
 			VAR_ENVVERSION, VAR_HOSTNAME:
-			return stringTest(getStringValue(msg, stmt), stmt)
+			return stringTest(getStringValue(msg, stmt), stmt, captures)
 		case VAR_TIMESTAMP, VAR_SEVERITY, VAR_PID:
 			return numericTest(getNumericValue(msg, stmt), stmt)
 		case VAR_FIELDS:
@@ -206,12 +290,12 @@ func testExpr(msg *Message, stmt *Statement) bool {
 				if ai >= len(field.ValueString) {
 					return testNonExistence(stmt)
 				}
-				return stringTest(field.ValueString[ai], stmt)
+				return stringTest(field.ValueString[ai], stmt, captures)
 			case Field_BYTES:
 				if ai >= len(field.ValueBytes) {
 					return testNonExistence(stmt)
 				}
-				return stringTest(string(field.ValueBytes[ai]), stmt)
+				return stringTest(string(field.ValueBytes[ai]), stmt, captures)
 			case Field_INTEGER:
 				if ai >= len(field.ValueInteger) {
 					return testNonExistence(stmt)
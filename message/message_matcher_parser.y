@@ -6,6 +6,7 @@ import (
 	"log"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"unicode/utf8"
 )
@@ -28,7 +29,24 @@ var variables = map[string]int{
 	"Fields":     VAR_FIELDS,
 	"TRUE":       TRUE,
 	"FALSE":      FALSE,
-	"NIL":        NIL_VALUE}
+	"NIL":        NIL_VALUE,
+	"IN":         OP_IN,
+	"BETWEEN":    OP_BETWEEN,
+	"AND":        BETWEEN_AND}
+
+// Syslog severity names that can be used in place of the numeric value when
+// comparing against Severity.
+var severities = map[string]float64{
+	"EMERG":   0,
+	"ALERT":   1,
+	"CRIT":    2,
+	"ERR":     3,
+	"ERROR":   3,
+	"WARNING": 4,
+	"WARN":    4,
+	"NOTICE":  5,
+	"INFO":    6,
+	"DEBUG":   7}
 
 var parseLock sync.Mutex
 
@@ -68,6 +86,80 @@ func (s *stack) pop() (node *tree) {
 
 var nodes []*tree
 
+// Appends the nodes testing whether the variable equals any of the values,
+// i.e. `v IN (a, b, c)` is evaluated as `v == a || v == b || v == c`.
+func appendInNodes(variable yySymType, values []yySymType) {
+	eq := yySymType{tokenId: OP_EQ, token: "=="}
+	or := yySymType{tokenId: OP_OR, token: "||"}
+	for i, value := range values {
+		nodes = append(nodes, &tree{stmt: &Statement{variable, eq, value}})
+		if i > 0 {
+			nodes = append(nodes, &tree{stmt: &Statement{op: or}})
+		}
+	}
+}
+
+// Appends the nodes testing whether the variable is within the inclusive
+// range, i.e. `v BETWEEN a AND b` is evaluated as `v >= a && v <= b`.
+func appendBetweenNodes(variable, low, high yySymType) {
+	gte := yySymType{tokenId: OP_GTE, token: ">="}
+	lte := yySymType{tokenId: OP_LTE, token: "<="}
+	and := yySymType{tokenId: OP_AND, token: "&&"}
+	nodes = append(nodes, &tree{stmt: &Statement{variable, gte, low}})
+	nodes = append(nodes, &tree{stmt: &Statement{variable, lte, high}})
+	nodes = append(nodes, &tree{stmt: &Statement{op: and}})
+}
+
+// Converts a glob pattern, where '*' matches any run of characters, '?'
+// matches a single character and '\\' escapes the next character, to the
+// equivalent unanchored regular expression.
+func globToRegexp(glob string) string {
+	var expr string
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			expr += ".*"
+		case '?':
+			expr += "."
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			expr += regexp.QuoteMeta(glob[i : i+1])
+		default:
+			expr += regexp.QuoteMeta(glob[i : i+1])
+		}
+	}
+	return expr
+}
+
+// Turns a glob pattern string value into a regexp value matching the whole
+// string. Like regular expressions, patterns that only have a trailing or
+// leading '*' are tested as a prefix or suffix instead.
+func globValue(glob yySymType) yySymType {
+	g := glob.token
+	value := yySymType{tokenId: REGEXP_VALUE}
+	if strings.HasSuffix(g, "*") && !strings.HasSuffix(g, "\\*") {
+		re := regexp.MustCompile(globToRegexp(g[:len(g)-1]))
+		if s, b := re.LiteralPrefix(); b {
+			value.token = s
+			value.fieldIndex = STARTS_WITH
+			return value
+		}
+	}
+	if strings.HasPrefix(g, "*") {
+		re := regexp.MustCompile(globToRegexp(g[1:]))
+		if s, b := re.LiteralPrefix(); b {
+			value.token = s
+			value.fieldIndex = ENDS_WITH
+			return value
+		}
+	}
+	value.token = "(?s)^" + globToRegexp(g) + "$"
+	value.regexp = regexp.MustCompile(value.token)
+	return value
+}
+
 %}
 
 %union {
@@ -77,14 +169,16 @@ var nodes []*tree
    fieldIndex  int
    arrayIndex  int
    regexp      *regexp.Regexp
+   values      []yySymType
 }
 
 %token OP_EQ OP_NE OP_GT OP_GTE OP_LT OP_LTE OP_RE OP_NRE
 %token OP_OR OP_AND
+%token OP_IN OP_BETWEEN BETWEEN_AND
 %token VAR_UUID VAR_TYPE VAR_LOGGER VAR_PAYLOAD VAR_ENVVERSION VAR_HOSTNAME
 %token VAR_TIMESTAMP VAR_SEVERITY VAR_PID
 %token VAR_FIELDS
-%token STRING_VALUE NUMERIC_VALUE REGEXP_VALUE NIL_VALUE
+%token STRING_VALUE NUMERIC_VALUE REGEXP_VALUE NIL_VALUE SEVERITY_VALUE
 %token TRUE FALSE
 
 %start spec
@@ -117,9 +211,15 @@ string_vars : VAR_UUID
    | VAR_HOSTNAME
 ;
 numeric_vars : VAR_TIMESTAMP
-   | VAR_SEVERITY
    | VAR_PID
 ;
+severity_value : NUMERIC_VALUE
+   | SEVERITY_VALUE
+       {
+       $$ = $1
+       $$.tokenId = NUMERIC_VALUE
+       }
+;
 string_test : string_vars relational STRING_VALUE
        {
        //fmt.Println("string_test", $1, $2, $3)
@@ -130,6 +230,11 @@ string_test : string_vars relational STRING_VALUE
        //fmt.Println("string_test regexp", $1, $2, $3)
        nodes = append(nodes, &tree{stmt:&Statement{$1, $2, $3}})
        }
+   |   string_vars regexp STRING_VALUE
+       {
+       //fmt.Println("string_test glob", $1, $2, $3)
+       nodes = append(nodes, &tree{stmt:&Statement{$1, $2, globValue($3)}})
+       }
 ;
 numeric_test : numeric_vars relational NUMERIC_VALUE
    {
@@ -137,6 +242,12 @@ numeric_test : numeric_vars relational NUMERIC_VALUE
    nodes = append(nodes, &tree{stmt:&Statement{$1, $2, $3}})
    }
 ;
+severity_test : VAR_SEVERITY relational severity_value
+   {
+   //fmt.Println("severity_test", $1, $2, $3)
+   nodes = append(nodes, &tree{stmt:&Statement{$1, $2, $3}})
+   }
+;
 field_test : VAR_FIELDS relational NUMERIC_VALUE
       {
       //fmt.Println("field_test numeric", $1, $2, $3)
@@ -157,12 +268,96 @@ field_test : VAR_FIELDS relational NUMERIC_VALUE
       //fmt.Println("field_test regexp", $1, $2, $3)
       nodes = append(nodes, &tree{stmt:&Statement{$1, $2, $3}})
       }
+   | VAR_FIELDS regexp STRING_VALUE
+      {
+      //fmt.Println("field_test glob", $1, $2, $3)
+      nodes = append(nodes, &tree{stmt:&Statement{$1, $2, globValue($3)}})
+      }
    | VAR_FIELDS eqneq NIL_VALUE
       {
       //fmt.Println("field_test existence", $1, $2, $3)
       nodes = append(nodes, &tree{stmt:&Statement{$1, $2, $3}})
       }
 ;
+string_list : STRING_VALUE
+      {
+      $$.values = []yySymType{$1}
+      }
+   | string_list ',' STRING_VALUE
+      {
+      $$.values = append($1.values, $3)
+      }
+;
+numeric_list : NUMERIC_VALUE
+      {
+      $$.values = []yySymType{$1}
+      }
+   | numeric_list ',' NUMERIC_VALUE
+      {
+      $$.values = append($1.values, $3)
+      }
+;
+severity_list : severity_value
+      {
+      $$.values = []yySymType{$1}
+      }
+   | severity_list ',' severity_value
+      {
+      $$.values = append($1.values, $3)
+      }
+;
+set_test : string_vars OP_IN '(' string_list ')'
+      {
+      //fmt.Println("set_test string", $1, $4)
+      appendInNodes($1, $4.values)
+      }
+   | numeric_vars OP_IN '(' numeric_list ')'
+      {
+      //fmt.Println("set_test numeric", $1, $4)
+      appendInNodes($1, $4.values)
+      }
+   | VAR_SEVERITY OP_IN '(' severity_list ')'
+      {
+      //fmt.Println("set_test severity", $1, $4)
+      appendInNodes($1, $4.values)
+      }
+   | VAR_FIELDS OP_IN '(' string_list ')'
+      {
+      //fmt.Println("set_test field string", $1, $4)
+      appendInNodes($1, $4.values)
+      }
+   | VAR_FIELDS OP_IN '(' numeric_list ')'
+      {
+      //fmt.Println("set_test field numeric", $1, $4)
+      appendInNodes($1, $4.values)
+      }
+;
+range_test : string_vars OP_BETWEEN STRING_VALUE BETWEEN_AND STRING_VALUE
+      {
+      //fmt.Println("range_test string", $1, $3, $5)
+      appendBetweenNodes($1, $3, $5)
+      }
+   | numeric_vars OP_BETWEEN NUMERIC_VALUE BETWEEN_AND NUMERIC_VALUE
+      {
+      //fmt.Println("range_test numeric", $1, $3, $5)
+      appendBetweenNodes($1, $3, $5)
+      }
+   | VAR_SEVERITY OP_BETWEEN severity_value BETWEEN_AND severity_value
+      {
+      //fmt.Println("range_test severity", $1, $3, $5)
+      appendBetweenNodes($1, $3, $5)
+      }
+   | VAR_FIELDS OP_BETWEEN STRING_VALUE BETWEEN_AND STRING_VALUE
+      {
+      //fmt.Println("range_test field string", $1, $3, $5)
+      appendBetweenNodes($1, $3, $5)
+      }
+   | VAR_FIELDS OP_BETWEEN NUMERIC_VALUE BETWEEN_AND NUMERIC_VALUE
+      {
+      //fmt.Println("range_test field numeric", $1, $3, $5)
+      appendBetweenNodes($1, $3, $5)
+      }
+;
 boolean : TRUE | FALSE
 expr : '(' expr ')'
       {
@@ -180,7 +375,10 @@ expr : '(' expr ')'
       }
    | string_test
    | numeric_test
+   | severity_test
    | field_test
+   | set_test
+   | range_test
    | boolean
       {
          //fmt.Println("boolean", $1)
@@ -333,6 +531,13 @@ variable:
 		}
 	}
 	yylval.tokenId = variables[m.sym]
+	if severity, ok := severities[m.sym]; ok {
+		yylval.token = m.sym
+		yylval.double = severity
+		yylval.tokenId = SEVERITY_VALUE
+		m.peekrune = c
+		return yylval.tokenId
+	}
 	if yylval.tokenId == VAR_FIELDS {
 		if c != '[' {
 			return 0
@@ -462,7 +667,7 @@ regexpstring:
 	}
 	rlen := len(m.sym)
 	if rlen > 0 && m.sym[0] == '^' {
-		if re, err := regexp.Compile(m.sym[1:]); err == nil {
+		if re, err := regexp.Compile(m.sym[1:]); err == nil && !hasNamedGroup(re) {
 			if s, b := re.LiteralPrefix(); b {
 				yylval.token = s
 				yylval.fieldIndex = STARTS_WITH
@@ -472,7 +677,7 @@ regexpstring:
 		}
 	}
 	if rlen > 0 && m.sym[rlen-1] == '$' {
-		if re, err := regexp.Compile(m.sym[:rlen-1]); err == nil {
+		if re, err := regexp.Compile(m.sym[:rlen-1]); err == nil && !hasNamedGroup(re) {
 			if s, b := re.LiteralPrefix(); b {
 				yylval.token = s
 				yylval.fieldIndex = ENDS_WITH
@@ -72,13 +72,26 @@ func MatcherSpecificationSpec(c gospec.Context) {
 			"Pid !~ /6/",                                                  // regex not allowed on numeric
 			"Type =~ /test",                                               // unmatched slash
 			"Type == /test/",                                              // incorrect operator
-			"Type =~ 'test'",                                              // string instead of regexp
+			"Pid =~ 'test*'",                                              // glob not allowed on numeric
 			"Type =~ /\\ytest/",                                           // invalid escape character
 			"Type != 'test\"",                                             // mis matched quote types
 			"Pid =~ 6",                                                    // number instead of regexp
 			"NIL",                                                         // invalid use of constant
 			"Type == NIL",                                                 // existence check only works on fields
 			"Fields[test] > NIL",                                          // existence check only works with equals and not equals
+			"Pid == ERR",                                                  // severity names only work on Severity
+			"Fields[level] == INFO",                                       // severity names only work on Severity
+			"Severity == info",                                            // severity names are upper case
+			"Severity == BOGUS",                                           // unknown severity name
+			"Type IN ()",                                                  // empty set
+			"Type IN ('a',)",                                              // trailing comma
+			"Type IN ('a', 1)",                                            // mixed value types
+			"Severity IN ('a')",                                           // string set on numeric
+			"Type IN 'a'",                                                 // set without parens
+			"Type BETWEEN 'a'",                                            // missing upper bound
+			"Severity BETWEEN 1 && 2",                                     // && instead of AND
+			"Pid BETWEEN 'a' AND 'b'",                                     // string range on numeric
+			"Type == 'a' AND Type == 'b'",                                 // AND outside of BETWEEN
 		}
 
 		negative := []string{
@@ -96,6 +109,9 @@ func MatcherSpecificationSpec(c gospec.Context) {
 			"Severity <= 5",
 			"Severity > 6",
 			"Severity >= 7",
+			"Severity == DEBUG",
+			"Severity < INFO",
+			"Severity <= ERR",
 			"Fields[foo] == 'ba'",
 			"Fields[foo][1] == 'bar'",
 			"Fields[foo][0][1] == 'bar'",
@@ -116,6 +132,22 @@ func MatcherSpecificationSpec(c gospec.Context) {
 			"Type !~ /^TE/",
 			"Type !~ /ST$/",
 			"Logger =~ /./ && Type =~ /^anything/",
+			"Type IN ('a', 'b')",
+			"Severity IN (1, 2, 7)",
+			"Severity IN (DEBUG, ERR)",
+			"Fields[int] IN (1024)",
+			"Fields[missing] IN ('a')",
+			"Severity BETWEEN 0 AND 5",
+			"Severity BETWEEN WARNING AND NOTICE",
+			"Fields[int] BETWEEN 1000 AND 2000",
+			"Type IN ('TEST') && Severity IN (7)",
+			"Type =~ 'TE'",
+			"Type =~ 'te*'",
+			"Type =~ '*st'",
+			"Type =~ 'T?T'",
+			"Type !~ 'TE*'",
+			"Logger =~ 'Go\\*'",
+			"Fields[foo] =~ 'b*z'",
 		}
 
 		positive := []string{
@@ -137,6 +169,13 @@ func MatcherSpecificationSpec(c gospec.Context) {
 			"Severity == 6",
 			"Severity > 5",
 			"Severity >= 6",
+			"Severity == INFO",
+			"Severity != DEBUG",
+			"Severity > WARNING",
+			"Severity >= NOTICE",
+			"Severity < DEBUG",
+			"Severity <= INFO",
+			"Type == 'TEST' && Severity > ERR",
 			"Timestamp > 0",
 			"Type != 'test'",
 			"Type == 'TEST' && Severity == 6",
@@ -169,6 +208,30 @@ func MatcherSpecificationSpec(c gospec.Context) {
 			"Type =~ /ST$/",
 			"Type !~ /^te/",
 			"Type !~ /st$/",
+			"Type IN ('a', 'TEST')",
+			"Type IN ('TEST')",
+			"Severity IN (1, 6, 7)",
+			"Severity IN (WARN, INFO)",
+			"Fields[foo] IN ('bar', 'baz')",
+			"Fields[int] IN (1, 999)",
+			"Severity BETWEEN 3 AND 6",
+			"Severity BETWEEN INFO AND DEBUG",
+			"Type BETWEEN 'A' AND 'Z'",
+			"Fields[int] BETWEEN 900 AND 1000",
+			"Fields[double] BETWEEN 99 AND 100",
+			"Type IN ('x', 'y') || Severity BETWEEN 6 AND 7",
+			"(Type IN ('x') || Severity IN (6)) && Fields[foo] == 'bar'",
+			"Type =~ 'TEST'",
+			"Type =~ 'TE*'",
+			"Type =~ '*ST'",
+			"Type =~ 'T*T'",
+			"Type =~ 'T??T'",
+			"Type =~ '*'",
+			"Type !~ 'te*'",
+			"Logger =~ 'Go*'",
+			"Payload =~ '* Payload'",
+			"Fields[foo] =~ 'b?r'",
+			"Fields[foo][1] =~ 'alt*'",
 		}
 
 		c.Specify("malformed matcher tests", func() {
@@ -195,6 +258,58 @@ func MatcherSpecificationSpec(c gospec.Context) {
 				c.Expect(match, gs.IsTrue)
 			}
 		})
+
+		c.Specify("glob tests", func() {
+			ms, err := CreateMatcherSpecification("Logger =~ 'app.web.*'")
+			c.Assume(err, gs.IsNil)
+			c.Expect(ms.vm.stmt.value.fieldIndex, gs.Equals, STARTS_WITH)
+			c.Expect(ms.vm.stmt.value.token, gs.Equals, "app.web.")
+
+			msg.SetLogger("app.web.requests")
+			c.Expect(ms.Match(msg), gs.IsTrue)
+			msg.SetLogger("app.webserver")
+			c.Expect(ms.Match(msg), gs.IsFalse)
+
+			ms, err = CreateMatcherSpecification("Logger =~ '*.errors'")
+			c.Assume(err, gs.IsNil)
+			c.Expect(ms.vm.stmt.value.fieldIndex, gs.Equals, ENDS_WITH)
+
+			ms, err = CreateMatcherSpecification("Logger =~ 'app.*.errors'")
+			c.Assume(err, gs.IsNil)
+			c.Expect(ms.vm.stmt.value.regexp.String(), gs.Equals, "(?s)^app\\..*\\.errors$")
+		})
+
+		c.Specify("capture tests", func() {
+			ms, err := CreateMatcherSpecification(
+				"Fields[Payload] =~ /name=(?P<name>\\w+);type=(?P<type>\\w+)/ && Type == 'TEST'")
+			c.Assume(err, gs.IsNil)
+			c.Expect(ms.HasCaptures(), gs.IsTrue)
+			c.Expect(ms.Match(msg), gs.IsTrue)
+			expected := map[string]string{"name": "test", "type": "web"}
+			match, captures := ms.MatchCaptures(msg)
+			c.Expect(match, gs.IsTrue)
+			c.Expect(len(captures), gs.Equals, 2)
+			compareCaptures(c, expected, captures)
+
+			// Groups of branches that didn't match aren't captured.
+			ms, err = CreateMatcherSpecification(
+				"(Fields[foo][1] =~ /(?P<first>alt)/ && Type == 'bogus') || Fields[foo][1] =~ /(?P<last>nate)$/")
+			c.Assume(err, gs.IsNil)
+			match, captures = ms.MatchCaptures(msg)
+			c.Expect(match, gs.IsTrue)
+			c.Expect(len(captures), gs.Equals, 1)
+			compareCaptures(c, map[string]string{"last": "nate"}, captures)
+
+			ms, err = CreateMatcherSpecification("Fields[foo] =~ /(?P<word>bogus)/")
+			c.Assume(err, gs.IsNil)
+			match, captures = ms.MatchCaptures(msg)
+			c.Expect(match, gs.IsFalse)
+			c.Expect(len(captures), gs.Equals, 0)
+
+			ms, err = CreateMatcherSpecification("Fields[foo] =~ /bogus/")
+			c.Assume(err, gs.IsNil)
+			c.Expect(ms.HasCaptures(), gs.IsFalse)
+		})
 	})
 }
 
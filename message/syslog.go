@@ -0,0 +1,74 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package message
+
+import (
+	"fmt"
+)
+
+const (
+	// Name of the integer field carrying a message's syslog facility.
+	SYSLOG_FACILITY_FIELD = "syslog_facility"
+	// Facility of messages without a valid syslog facility field, i.e.
+	// user-level messages.
+	DEFAULT_SYSLOG_FACILITY = int32(1)
+	MAX_SYSLOG_FACILITY     = int32(23)
+)
+
+// SyslogFacility returns the syslog facility held by the named integer
+// field, and whether the message has a field with a valid facility.
+func (m *Message) SyslogFacility(name string) (facility int32, ok bool) {
+	value, ok := m.GetFieldValue(name)
+	if !ok {
+		return DEFAULT_SYSLOG_FACILITY, false
+	}
+	v, ok := value.(int64)
+	if !ok || v < 0 || v > int64(MAX_SYSLOG_FACILITY) {
+		return DEFAULT_SYSLOG_FACILITY, false
+	}
+	return int32(v), true
+}
+
+// GetSyslogFacility returns the facility held by the `syslog_facility` field,
+// or DEFAULT_SYSLOG_FACILITY if there isn't a valid one.
+func (m *Message) GetSyslogFacility() int32 {
+	facility, _ := m.SyslogFacility(SYSLOG_FACILITY_FIELD)
+	return facility
+}
+
+// SetSyslogFacility stores the facility in the `syslog_facility` field,
+// replacing any existing ones.
+func (m *Message) SetSyslogFacility(facility int32) error {
+	if facility < 0 || facility > MAX_SYSLOG_FACILITY {
+		return fmt.Errorf("invalid syslog facility: %d", facility)
+	}
+	for _, f := range m.FindAllFields(SYSLOG_FACILITY_FIELD) {
+		m.DeleteField(f)
+	}
+	NewInt64Field(m, SYSLOG_FACILITY_FIELD, int64(facility), "")
+	return nil
+}
+
+// SyslogPri combines a facility and a severity into a syslog PRI value.
+// Severities outside of 0-7 are treated as 7 (debug).
+func SyslogPri(facility, severity int32) int32 {
+	if severity < 0 || severity > 7 {
+		severity = 7
+	}
+	return facility*8 + severity
+}
+
+// GetSyslogPri returns the syslog PRI value of the message's facility and
+// Severity.
+func (m *Message) GetSyslogPri() int32 {
+	return SyslogPri(m.GetSyslogFacility(), m.GetSeverity())
+}
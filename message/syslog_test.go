@@ -0,0 +1,66 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package message
+
+import (
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SyslogSpec(c gs.Context) {
+	c.Specify("The syslog facility", func() {
+		msg := getTestMessage()
+
+		c.Specify("defaults to user-level messages", func() {
+			c.Expect(msg.GetSyslogFacility(), gs.Equals, DEFAULT_SYSLOG_FACILITY)
+			_, ok := msg.SyslogFacility(SYSLOG_FACILITY_FIELD)
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("is read from the syslog_facility field", func() {
+			NewInt64Field(msg, SYSLOG_FACILITY_FIELD, 16, "")
+			c.Expect(msg.GetSyslogFacility(), gs.Equals, int32(16))
+		})
+
+		c.Specify("is read from other fields", func() {
+			NewInt64Field(msg, "syslogfacility", 4, "")
+			facility, ok := msg.SyslogFacility("syslogfacility")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(facility, gs.Equals, int32(4))
+		})
+
+		c.Specify("ignores invalid values", func() {
+			NewInt64Field(msg, SYSLOG_FACILITY_FIELD, 24, "")
+			c.Expect(msg.GetSyslogFacility(), gs.Equals, DEFAULT_SYSLOG_FACILITY)
+			NewStringField(msg, "name", "local0")
+			_, ok := msg.SyslogFacility("name")
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("replaces existing values when set", func() {
+			NewInt64Field(msg, SYSLOG_FACILITY_FIELD, 3, "")
+			err := msg.SetSyslogFacility(20)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(msg.FindAllFields(SYSLOG_FACILITY_FIELD)), gs.Equals, 1)
+			c.Expect(msg.GetSyslogFacility(), gs.Equals, int32(20))
+			err = msg.SetSyslogFacility(24)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("is combined with the severity into the PRI", func() {
+			c.Expect(msg.GetSyslogPri(), gs.Equals, int32(14))
+			msg.SetSyslogFacility(16)
+			msg.SetSeverity(3)
+			c.Expect(msg.GetSyslogPri(), gs.Equals, int32(131))
+			c.Expect(SyslogPri(0, 9), gs.Equals, int32(7))
+		})
+	})
+}
@@ -37,14 +37,27 @@ func TestAllSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 	r.Parallel = false
 
+	r.AddSpec(CircuitBreakerSpec)
+	r.AddSpec(ConfigReloadSpec)
+	r.AddSpec(DaemonInfoSpec)
+	r.AddSpec(HeartbeatSpec)
+	r.AddSpec(FieldLimitsSpec)
+	r.AddSpec(FilterRunnerSpec)
 	r.AddSpec(HekaFramingSpec)
+	r.AddSpec(InputCheckpointSpec)
 	r.AddSpec(InputRunnerSpec)
+	r.AddSpec(JsonSpec)
+	r.AddSpec(LatencySpec)
+	r.AddSpec(MessageChunkerSpec)
+	r.AddSpec(MessageTapSpec)
 	r.AddSpec(MessageTemplateSpec)
+	r.AddSpec(OutputBatcherSpec)
 	r.AddSpec(OutputRunnerSpec)
 	r.AddSpec(ProtobufDecoderSpec)
 	r.AddSpec(QueueBufferSpec)
 	r.AddSpec(PatternGroupingSpec)
 	r.AddSpec(RegexSpec)
+	r.AddSpec(RequireMatcherSpec)
 	r.AddSpec(ReportSpec)
 	r.AddSpec(SplitterRunnerSpec)
 	r.AddSpec(StatAccumInputSpec)
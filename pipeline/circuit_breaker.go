@@ -0,0 +1,142 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Settings for the circuit breaker of a filter or output, see
+// `CommonFOConfig.CircuitBreaker`.
+type CircuitBreakerConfig struct {
+	// Number of consecutive ProcessMessage failures within `window` that
+	// will trip the breaker. Defaults to 5.
+	MaxFailures uint `toml:"max_failures"`
+	// Time span the consecutive failures have to happen within. Defaults to
+	// 1m.
+	Window string
+	// Time the breaker stays open, dropping messages, before it lets a
+	// message through to test whether the plugin recovered. Defaults to 30s.
+	Cooldown string
+}
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStateNames = []string{"closed", "open", "half-open"}
+
+// circuitBreaker keeps track of a plugin's consecutive delivery failures.
+// Once they reach the maximum within the window the breaker trips open and
+// the runner drops messages instead of handing them to the plugin until the
+// cooldown has passed. Then the breaker is half-open, the next message is
+// handed to the plugin, and its outcome closes or reopens the breaker.
+type circuitBreaker struct {
+	lock        sync.Mutex
+	maxFailures uint
+	window      time.Duration
+	cooldown    time.Duration
+	state       int
+	failures    uint
+	windowStart time.Time
+	openedAt    time.Time
+	tripCount   int64
+	now         func() time.Time
+}
+
+func newCircuitBreaker(config *CircuitBreakerConfig) (cb *circuitBreaker, err error) {
+	cb = &circuitBreaker{
+		maxFailures: config.MaxFailures,
+		now:         time.Now,
+	}
+	if cb.maxFailures == 0 {
+		cb.maxFailures = 5
+	}
+	window, cooldown := config.Window, config.Cooldown
+	if window == "" {
+		window = "1m"
+	}
+	if cooldown == "" {
+		cooldown = "30s"
+	}
+	if cb.window, err = time.ParseDuration(window); err != nil {
+		return nil, fmt.Errorf("invalid circuit_breaker window: %s", err)
+	}
+	if cb.cooldown, err = time.ParseDuration(cooldown); err != nil {
+		return nil, fmt.Errorf("invalid circuit_breaker cooldown: %s", err)
+	}
+	return cb, nil
+}
+
+// allow returns whether the next message should be handed to the plugin.
+// halfOpened is true if the cooldown just passed.
+func (cb *circuitBreaker) allow() (ok, halfOpened bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.state != breakerOpen {
+		return true, false
+	}
+	if cb.now().Sub(cb.openedAt) < cb.cooldown {
+		return false, false
+	}
+	cb.state = breakerHalfOpen
+	return true, true
+}
+
+// success records a delivered message. Returns true if that closed the
+// breaker.
+func (cb *circuitBreaker) success() (closed bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	closed = cb.state == breakerHalfOpen
+	cb.state = breakerClosed
+	cb.failures = 0
+	return closed
+}
+
+// failure records a failed delivery attempt. Returns true if that tripped
+// the breaker open.
+func (cb *circuitBreaker) failure() (tripped bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	now := cb.now()
+	switch cb.state {
+	case breakerOpen:
+		return false
+	case breakerClosed:
+		if cb.failures == 0 || now.Sub(cb.windowStart) > cb.window {
+			cb.failures = 0
+			cb.windowStart = now
+		}
+		cb.failures++
+		if cb.failures < cb.maxFailures {
+			return false
+		}
+	}
+	// Too many failures, or the test message failed while half-open.
+	cb.state = breakerOpen
+	cb.openedAt = now
+	cb.failures = 0
+	cb.tripCount++
+	return true
+}
+
+// status returns the breaker's state name and how often it has tripped.
+func (cb *circuitBreaker) status() (state string, tripCount int64) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return breakerStateNames[cb.state], cb.tripCount
+}
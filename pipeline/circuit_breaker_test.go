@@ -0,0 +1,92 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CircuitBreakerSpec(c gs.Context) {
+	c.Specify("A circuit breaker", func() {
+		now := time.Unix(1000, 0)
+		cb, err := newCircuitBreaker(&CircuitBreakerConfig{
+			MaxFailures: 3,
+			Window:      "10s",
+			Cooldown:    "5s",
+		})
+		c.Assume(err, gs.IsNil)
+		cb.now = func() time.Time { return now }
+
+		failTimes := func(n int) (tripped bool) {
+			for i := 0; i < n; i++ {
+				tripped = cb.failure()
+			}
+			return
+		}
+
+		c.Specify("trips after consecutive failures", func() {
+			c.Expect(failTimes(2), gs.IsFalse)
+			c.Expect(failTimes(1), gs.IsTrue)
+			ok, _ := cb.allow()
+			c.Expect(ok, gs.IsFalse)
+			state, trips := cb.status()
+			c.Expect(state, gs.Equals, "open")
+			c.Expect(trips, gs.Equals, int64(1))
+		})
+
+		c.Specify("resets the count on success", func() {
+			failTimes(2)
+			cb.success()
+			c.Expect(failTimes(2), gs.IsFalse)
+			state, _ := cb.status()
+			c.Expect(state, gs.Equals, "closed")
+		})
+
+		c.Specify("only counts failures within the window", func() {
+			failTimes(2)
+			now = now.Add(11 * time.Second)
+			c.Expect(failTimes(2), gs.IsFalse)
+			c.Expect(failTimes(1), gs.IsTrue)
+		})
+
+		c.Specify("half-opens after the cooldown", func() {
+			failTimes(3)
+			now = now.Add(5 * time.Second)
+			ok, halfOpened := cb.allow()
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(halfOpened, gs.IsTrue)
+			state, _ := cb.status()
+			c.Expect(state, gs.Equals, "half-open")
+
+			c.Specify("and closes on success", func() {
+				c.Expect(cb.success(), gs.IsTrue)
+				state, _ := cb.status()
+				c.Expect(state, gs.Equals, "closed")
+			})
+
+			c.Specify("and reopens on failure", func() {
+				c.Expect(cb.failure(), gs.IsTrue)
+				ok, _ := cb.allow()
+				c.Expect(ok, gs.IsFalse)
+				_, trips := cb.status()
+				c.Expect(trips, gs.Equals, int64(2))
+			})
+		})
+
+		c.Specify("rejects invalid durations", func() {
+			_, err := newCircuitBreaker(&CircuitBreakerConfig{Cooldown: "soon"})
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
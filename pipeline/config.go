@@ -28,6 +28,7 @@ import (
 	"reflect"
 	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bbangert/toml"
@@ -174,8 +175,18 @@ type PipelineConfig struct {
 	// Lock protecting access to running outputs so they can be removed
 	// safely.
 	outputsLock sync.RWMutex
+	// Is freed when all OutputRunners have stopped.
+	outputsWg sync.WaitGroup
+	// Makes sure only one config reload runs at a time.
+	reloadLock sync.Mutex
+	// The loaded [hekad] config section, if there is one.
+	hekadSection toml.Primitive
 	// Internal reporting channel.
 	reportRecycleChan chan *PipelinePack
+	// Highest `max_message_loops` override of any filter, accessed
+	// atomically. Packs are handed out up to this loop count, the filter's
+	// own limit is enforced when it injects them.
+	maxMsgLoopsOverride uint32
 
 	// The next few values are used only during the initial configuration
 	// loading process.
@@ -228,8 +239,12 @@ func NewPipelineConfig(globals *GlobalConfigStruct) (config *PipelineConfig) {
 // objects they are holding. Returns a PipelinePack for injection into Heka
 // pipeline, or nil if the msgLoopCount is above the configured maximum.
 func (self *PipelineConfig) PipelinePack(msgLoopCount uint) (*PipelinePack, error) {
-	if msgLoopCount++; msgLoopCount > self.Globals.MaxMsgLoops {
-		return nil, fmt.Errorf("exceeded MaxMsgLoops = %d", self.Globals.MaxMsgLoops)
+	maxMsgLoops := self.Globals.MaxMsgLoops
+	if override := uint(atomic.LoadUint32(&self.maxMsgLoopsOverride)); override > maxMsgLoops {
+		maxMsgLoops = override
+	}
+	if msgLoopCount++; msgLoopCount > maxMsgLoops {
+		return nil, fmt.Errorf("exceeded MaxMsgLoops = %d", maxMsgLoops)
 	}
 	var pack *PipelinePack
 	select {
@@ -246,6 +261,19 @@ func (self *PipelineConfig) PipelinePack(msgLoopCount uint) (*PipelinePack, erro
 	return pack, nil
 }
 
+// Raises the loop count up to which packs are handed out to at least the
+// provided filter override.
+func (self *PipelineConfig) addMsgLoopsOverride(maxMsgLoops uint) {
+	for {
+		current := atomic.LoadUint32(&self.maxMsgLoopsOverride)
+		if uint32(maxMsgLoops) <= current ||
+			atomic.CompareAndSwapUint32(&self.maxMsgLoopsOverride, current,
+				uint32(maxMsgLoops)) {
+			return
+		}
+	}
+}
+
 // Returns the router.
 func (self *PipelineConfig) Router() MessageRouter {
 	return self.router
@@ -269,7 +297,9 @@ func (self *PipelineConfig) Hostname() string {
 // Returns OutputRunner registered under the specified name, or nil (and ok ==
 // false) if no such name is registered.
 func (self *PipelineConfig) Output(name string) (oRunner OutputRunner, ok bool) {
+	self.outputsLock.RLock()
 	oRunner, ok = self.OutputRunners[name]
+	self.outputsLock.RUnlock()
 	return
 }
 
@@ -375,6 +405,40 @@ func (self *PipelineConfig) Filter(name string) (fRunner FilterRunner, ok bool)
 	return
 }
 
+// Returns the number of messages matched by the message matcher of the filter
+// or output with the given name, or ok == false if no such name is
+// registered.
+func (self *PipelineConfig) MatchCount(name string) (count int64, ok bool) {
+	var mr *MatchRunner
+	if fRunner, found := self.Filter(name); found {
+		mr = fRunner.MatchRunner()
+	} else {
+		if oRunner, found := self.Output(name); found {
+			mr = oRunner.MatchRunner()
+		}
+	}
+	if mr == nil {
+		return 0, false
+	}
+	return mr.MatchCount(), true
+}
+
+// Returns the PluginMakers of all of the plugins loaded from the config,
+// keyed by category and then by plugin name. The returned maps are copies,
+// but the makers themselves are shared.
+func (self *PipelineConfig) PluginMakers() map[string]map[string]PluginMaker {
+	self.makersLock.RLock()
+	defer self.makersLock.RUnlock()
+	makers := make(map[string]map[string]PluginMaker, len(self.makers))
+	for category, byName := range self.makers {
+		makers[category] = make(map[string]PluginMaker, len(byName))
+		for name, maker := range byName {
+			makers[category][name] = maker
+		}
+	}
+	return makers
+}
+
 // Returns the specified StatAccumulator input plugin, or an error if it can't
 // be found.
 func (self *PipelineConfig) StatAccumulator(name string) (statAccum StatAccumulator,
@@ -395,18 +459,19 @@ func (self *PipelineConfig) StatAccumulator(name string) (statAccum StatAccumula
 }
 
 // Starts the provided FilterRunner and adds it to the set of running Filters.
+// The router's channels are unbuffered, so the matcher is handed over only
+// after filtersLock has been released.
 func (self *PipelineConfig) AddFilterRunner(fRunner FilterRunner) error {
 	self.filtersLock.Lock()
-	defer self.filtersLock.Unlock()
 	self.FilterRunners[fRunner.Name()] = fRunner
+	self.filtersLock.Unlock()
 	self.filtersWg.Add(1)
 	if err := fRunner.Start(self, &self.filtersWg); err != nil {
 		self.filtersWg.Done()
 		return fmt.Errorf("AddFilterRunner '%s' failed to start: %s",
 			fRunner.Name(), err)
-	} else {
-		self.router.AddFilterMatcher() <- fRunner.MatchRunner()
 	}
+	self.router.AddFilterMatcher() <- fRunner.MatchRunner()
 	return nil
 }
 
@@ -419,13 +484,15 @@ func (self *PipelineConfig) RemoveFilterRunner(name string) bool {
 	}
 
 	self.filtersLock.Lock()
-	defer self.filtersLock.Unlock()
-	if fRunner, ok := self.FilterRunners[name]; ok {
-		self.router.RemoveFilterMatcher() <- fRunner.MatchRunner()
+	fRunner, ok := self.FilterRunners[name]
+	if ok {
 		delete(self.FilterRunners, name)
-		return true
 	}
-	return false
+	self.filtersLock.Unlock()
+	if ok {
+		self.router.RemoveFilterMatcher() <- fRunner.MatchRunner()
+	}
+	return ok
 }
 
 // AddInputRunner Starts the provided InputRunner and adds it to the set of
@@ -459,14 +526,35 @@ func (self *PipelineConfig) RemoveInputRunner(iRunner InputRunner) {
 	iRunner.Input().Stop()
 }
 
+// AddOutputRunner starts the provided OutputRunner and adds it to the set of
+// running Outputs. As with filters the matcher is handed to the router
+// without holding outputsLock, and Start looks up the fallback output through
+// Output, so it can't be called with the lock held either.
+func (self *PipelineConfig) AddOutputRunner(oRunner OutputRunner) error {
+	self.outputsLock.Lock()
+	self.OutputRunners[oRunner.Name()] = oRunner
+	self.outputsLock.Unlock()
+	self.outputsWg.Add(1)
+	if err := oRunner.Start(self, &self.outputsWg); err != nil {
+		self.outputsWg.Done()
+		self.outputsLock.Lock()
+		delete(self.OutputRunners, oRunner.Name())
+		self.outputsLock.Unlock()
+		return fmt.Errorf("AddOutputRunner '%s' failed to start: %s",
+			oRunner.Name(), err)
+	}
+	self.router.AddOutputMatcher() <- oRunner.MatchRunner()
+	return nil
+}
+
 // RemoveOutputRunner unregisters the provided OutputRunner from heka, and
 // removes it's message matcher from the heka router.
 func (self *PipelineConfig) RemoveOutputRunner(oRunner OutputRunner) {
 	name := oRunner.Name()
 	self.makersLock.Lock()
 	outputMakers := self.makers["Output"]
-	if _, ok := outputMakers[name]; ok {
-		self.router.RemoveOutputMatcher() <- oRunner.MatchRunner()
+	_, ok := outputMakers[name]
+	if ok {
 		delete(outputMakers, name)
 	}
 	self.makersLock.Unlock()
@@ -474,6 +562,10 @@ func (self *PipelineConfig) RemoveOutputRunner(oRunner OutputRunner) {
 	self.outputsLock.Lock()
 	delete(self.OutputRunners, name)
 	self.outputsLock.Unlock()
+
+	if ok {
+		self.router.RemoveOutputMatcher() <- oRunner.MatchRunner()
+	}
 }
 
 type ConfigFile PluginConfig
@@ -542,11 +634,19 @@ type CommonInputConfig struct {
 	Ticker             uint `toml:"ticker_interval"`
 	Decoder            string
 	Splitter           string
-	SyncDecode         *bool `toml:"synchronous_decode"`
-	SendDecodeFailures *bool `toml:"send_decode_failures"`
-	LogDecodeFailures  *bool `toml:"log_decode_failures"`
-	CanExit            *bool `toml:"can_exit"`
+	SyncDecode         *bool  `toml:"synchronous_decode"`
+	DecoderPoolSize    int    `toml:"decoder_pool_size"`
+	MaxFields          int    `toml:"max_fields"`
+	MaxFieldBytes      int    `toml:"max_field_bytes"`
+	RequireMatcher     string `toml:"require_matcher"`
+	CheckpointInterval uint   `toml:"checkpoint_interval"`
+	SendDecodeFailures *bool  `toml:"send_decode_failures"`
+	LogDecodeFailures  *bool  `toml:"log_decode_failures"`
+	CanExit            *bool  `toml:"can_exit"`
 	Retries            RetryOptions
+	// Name of an output that messages failing to decode are handed to
+	// directly, instead of being dropped or sent to the router.
+	DecodeFailureOutput string `toml:"decode_failure_output"`
 }
 
 type CommonFOConfig struct {
@@ -557,8 +657,31 @@ type CommonFOConfig struct {
 	Retries      RetryOptions
 	Encoder      string             // Output only.
 	UseFraming   *bool              `toml:"use_framing"` // Output only.
+	Transform    string             `toml:"transform"`   // Output only.
 	UseBuffering *bool              `toml:"use_buffering"`
 	Buffering    *QueueBufferConfig `toml:"buffering"`
+
+	// Output only.
+	FallbackOutput string `toml:"fallback_output"`
+	SplitSize      int    `toml:"split_size"`
+	SplitField     string `toml:"split_field"`
+	AckOnSuccess   bool   `toml:"ack_on_success"`
+
+	// Drops messages for a while after too many consecutive failures. Only
+	// supported by unbuffered plugins using the ProcessMessage API.
+	CircuitBreaker *CircuitBreakerConfig `toml:"circuit_breaker"`
+
+	// Output only. Messages whose timestamp is older than this duration are
+	// dropped before they reach the plugin.
+	MaxMessageAge string `toml:"max_message_age"`
+
+	// Output only. Hands the output batches of encoded records, see
+	// BatchProcessor.
+	BatchSize          uint   `toml:"batch_size"`
+	BatchFlushInterval string `toml:"batch_flush_interval"`
+
+	// Filter only.
+	MaxMsgLoops uint `toml:"max_message_loops"`
 }
 
 type CommonSplitterConfig struct {
@@ -576,6 +699,7 @@ func makeDefaultConfigs() map[string]bool {
 		"TokenSplitter":           false,
 		"PatternGroupingSplitter": false,
 		"HekaFramingSplitter":     false,
+		"JsonSplitter":            false,
 		"NullSplitter":            false,
 	}
 }
@@ -635,6 +759,8 @@ func (self *PipelineConfig) PreloadFromConfigFile(filename string) error {
 	// Load all the plugin makers and file them by category.
 	for name, conf := range configFile {
 		if name == HEKA_DAEMON {
+			// Kept so a config reload can tell whether it changed.
+			self.hekadSection = conf
 			continue
 		}
 		if _, ok := self.defaultConfigs[name]; ok {
@@ -715,7 +841,9 @@ func (self *PipelineConfig) LoadConfig() error {
 				self.log(err.Error())
 				self.errcnt++
 			}
+			self.makersLock.Lock()
 			self.makers[category][maker.Name()] = maker
+			self.makersLock.Unlock()
 			if category == "Encoder" {
 				continue
 			}
@@ -748,6 +876,13 @@ func (self *PipelineConfig) LoadConfig() error {
 		}
 	}
 
+	if self.Globals.Tap.Output != "" {
+		if err = self.setTap(); err != nil {
+			self.log(err.Error())
+			self.errcnt++
+		}
+	}
+
 	if self.errcnt != 0 {
 		return fmt.Errorf("%d errors loading plugins", self.errcnt)
 	}
@@ -0,0 +1,381 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bbangert/toml"
+)
+
+// ConfigFiles returns the TOML files making up the configuration at
+// configPath, which is either a single file or a directory whose *.toml files
+// are loaded in name order.
+func ConfigFiles(configPath string) ([]string, error) {
+	p, err := os.Open(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %s", err.Error())
+	}
+	defer p.Close()
+	fi, err := p.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("can't stat file: %s", err.Error())
+	}
+	if !fi.IsDir() {
+		return []string{configPath}, nil
+	}
+
+	var filenames []string
+	files, _ := ioutil.ReadDir(configPath)
+	for _, f := range files {
+		fName := f.Name()
+		if !strings.HasSuffix(fName, ".toml") {
+			// Skip non *.toml files in a config dir.
+			continue
+		}
+		filenames = append(filenames, filepath.Join(configPath, fName))
+	}
+	return filenames, nil
+}
+
+// Returns a SHA-256 hash of the config file, or of the *.toml files in the
+// config directory in the order they're loaded, so that instances running
+// different configs can be told apart.
+func HashConfig(configPath string) (string, error) {
+	paths, err := ConfigFiles(configPath)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading config file: %s", err)
+		}
+		fmt.Fprintf(h, "%s\n%d\n", filepath.Base(path), len(contents))
+		h.Write(contents)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Categories of the plugins a config reload can start and stop, in the order
+// they're stopped. They're started in the reverse order.
+var reloadCategories = []string{"Input", "Filter", "Output"}
+
+// Result of comparing the reloaded config to the running one.
+type configDiff struct {
+	// New makers of the plugins to start, by category.
+	start map[string][]PluginMaker
+	// Names of the running plugins to stop, by category.
+	stop map[string][]string
+	// Sections of other categories that were changed, which only take effect
+	// after a restart.
+	ignored []string
+}
+
+// Reload re-reads the configuration at configPath and applies the changes to
+// the running pipeline. Inputs, filters, and outputs whose config section was
+// removed are stopped, those that were added are started, and those whose
+// section changed are stopped and then started with the new config. Running
+// plugins whose section didn't change are left alone. Changes to other
+// plugins, e.g. decoders or encoders, and to the [hekad] section require a
+// restart and are only logged.
+func (self *PipelineConfig) Reload(configPath string) error {
+	self.reloadLock.Lock()
+	defer self.reloadLock.Unlock()
+	if self.Globals.IsShuttingDown() {
+		return nil
+	}
+
+	LogInfo.Printf("Reloading config from %s", configPath)
+	sections, err := readConfigSections(configPath)
+	if err != nil {
+		return err
+	}
+	diff, err := self.diffConfig(sections)
+	if err != nil {
+		return err
+	}
+	for _, name := range diff.ignored {
+		LogError.Printf("Config change to [%s] requires a restart, ignoring it.",
+			name)
+	}
+
+	for _, category := range reloadCategories {
+		for _, name := range diff.stop[category] {
+			if self.Globals.IsShuttingDown() {
+				return nil
+			}
+			self.stopPlugin(category, name)
+			LogInfo.Printf("Reload stopped %s: %s", strings.ToLower(category), name)
+		}
+	}
+
+	// The daemon info reports the config that's loaded now, even if some of
+	// the plugins below fail to start.
+	if hash, err := HashConfig(configPath); err != nil {
+		LogError.Printf("Can't hash reloaded config: %s", err)
+	} else {
+		self.Globals.setConfigHash(hash)
+	}
+
+	var errcnt int
+	for i := len(reloadCategories) - 1; i >= 0; i-- {
+		category := reloadCategories[i]
+		for _, maker := range diff.start[category] {
+			if self.Globals.IsShuttingDown() {
+				return nil
+			}
+			if err = self.startPlugin(maker); err != nil {
+				LogError.Printf("Reload can't start %s '%s': %s",
+					strings.ToLower(category), maker.Name(), err)
+				errcnt++
+				continue
+			}
+			LogInfo.Printf("Reload started %s: %s", strings.ToLower(category),
+				maker.Name())
+		}
+	}
+	self.logMissingOutputs()
+	if errcnt > 0 {
+		return fmt.Errorf("%d plugins failed to start", errcnt)
+	}
+	LogInfo.Println("Config reload complete.")
+	return nil
+}
+
+// Reads and merges the sections of all of the config files.
+func readConfigSections(configPath string) (ConfigFile, error) {
+	filenames, err := ConfigFiles(configPath)
+	if err != nil {
+		return nil, err
+	}
+	sections := make(ConfigFile)
+	for _, filename := range filenames {
+		contents, err := ReplaceEnvsFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var configFile ConfigFile
+		if _, err = toml.Decode(contents, &configFile); err != nil {
+			return nil, fmt.Errorf("Error decoding config file %s: %s", filename, err)
+		}
+		for name, conf := range configFile {
+			sections[name] = conf
+		}
+	}
+	return sections, nil
+}
+
+// Compares the config sections to those of the running plugins. Dynamically
+// created plugins, such as the filters of a SandboxManagerFilter, have no
+// maker and so are never touched.
+func (self *PipelineConfig) diffConfig(sections ConfigFile) (*configDiff, error) {
+	diff := &configDiff{
+		start: make(map[string][]PluginMaker),
+		stop:  make(map[string][]string),
+	}
+	if !reflect.DeepEqual(sections[HEKA_DAEMON], self.hekadSection) {
+		diff.ignored = append(diff.ignored, HEKA_DAEMON)
+	}
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		if name != HEKA_DAEMON {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	makers := make([]PluginMaker, len(names))
+	for i, name := range names {
+		maker, err := NewPluginMaker(name, self, sections[name])
+		if err != nil {
+			return nil, err
+		}
+		makers[i] = maker
+	}
+
+	self.makersLock.RLock()
+	defer self.makersLock.RUnlock()
+	for i, name := range names {
+		maker := makers[i]
+		category := maker.Category()
+		old, exists := self.makers[category][name]
+		if exists && reflect.DeepEqual(old.(*pluginMaker).tomlSection, sections[name]) {
+			continue
+		}
+		if !isReloadCategory(category) {
+			diff.ignored = append(diff.ignored, name)
+			continue
+		}
+		// Catch config errors before anything is stopped.
+		if _, err := maker.PrepConfig(); err != nil {
+			return nil, err
+		}
+		if exists {
+			diff.stop[category] = append(diff.stop[category], name)
+		}
+		diff.start[category] = append(diff.start[category], maker)
+	}
+	for _, category := range reloadCategories {
+		for name := range self.makers[category] {
+			if _, ok := sections[name]; !ok {
+				diff.stop[category] = append(diff.stop[category], name)
+			}
+		}
+		sort.Strings(diff.stop[category])
+	}
+	return diff, nil
+}
+
+func isReloadCategory(category string) bool {
+	for _, c := range reloadCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// Stops the named running plugin and waits for its runner to exit, so a
+// replacement doesn't compete with it for buffers or listening sockets.
+func (self *PipelineConfig) stopPlugin(category, name string) {
+	var runner PluginRunner
+	switch category {
+	case "Input":
+		self.inputsLock.RLock()
+		iRunner, ok := self.InputRunners[name]
+		self.inputsLock.RUnlock()
+		if ok {
+			self.RemoveInputRunner(iRunner)
+			runner = iRunner
+		}
+	case "Filter":
+		self.filtersLock.RLock()
+		fRunner, ok := self.FilterRunners[name]
+		self.filtersLock.RUnlock()
+		if ok {
+			markUnloaded(fRunner)
+			self.RemoveFilterRunner(name)
+			runner = fRunner
+		}
+	case "Output":
+		oRunner, ok := self.Output(name)
+		if ok {
+			markUnloaded(oRunner)
+			self.RemoveOutputRunner(oRunner)
+			runner = oRunner
+		}
+	}
+
+	self.makersLock.Lock()
+	delete(self.makers[category], name)
+	self.makersLock.Unlock()
+
+	if runner == nil {
+		return
+	}
+	var done chan struct{}
+	switch r := runner.(type) {
+	case *iRunner:
+		done = r.done
+	case *foRunner:
+		done = r.done
+	}
+	if done != nil {
+		<-done
+	}
+}
+
+// Logs the references of the running plugins to outputs that aren't running
+// anymore. Those to restarted outputs are resolved to the new runners when
+// they're used.
+func (self *PipelineConfig) logMissingOutputs() {
+	self.inputsLock.RLock()
+	for name, runner := range self.InputRunners {
+		r, ok := runner.(*iRunner)
+		if !ok || r.config.DecodeFailureOutput == "" {
+			continue
+		}
+		if _, ok = self.Output(r.config.DecodeFailureOutput); !ok {
+			LogError.Printf("Input '%s' decode_failure_output '%s' isn't running",
+				name, r.config.DecodeFailureOutput)
+		}
+	}
+	self.inputsLock.RUnlock()
+
+	self.outputsLock.RLock()
+	for name, runner := range self.OutputRunners {
+		r, ok := runner.(*foRunner)
+		if !ok || r.config.FallbackOutput == "" {
+			continue
+		}
+		if _, ok = self.OutputRunners[r.config.FallbackOutput]; !ok {
+			LogError.Printf("Output '%s' fallback_output '%s' isn't running",
+				name, r.config.FallbackOutput)
+		}
+	}
+	tapOutput := self.Globals.Tap.Output
+	if _, ok := self.OutputRunners[tapOutput]; tapOutput != "" && !ok {
+		LogError.Printf("tap_output '%s' isn't running", tapOutput)
+	}
+	self.outputsLock.RUnlock()
+}
+
+// Tells a filter or output runner that its exit is expected.
+func markUnloaded(runner PluginRunner) {
+	if r, ok := runner.(*foRunner); ok {
+		atomic.StoreInt32(&r.unloaded, 1)
+	}
+}
+
+// Creates and starts a plugin from a reloaded config section.
+func (self *PipelineConfig) startPlugin(maker PluginMaker) error {
+	category := maker.Category()
+	self.makersLock.Lock()
+	self.makers[category][maker.Name()] = maker
+	self.makersLock.Unlock()
+
+	runner, err := maker.MakeRunner("")
+	if err != nil {
+		self.makersLock.Lock()
+		delete(self.makers[category], maker.Name())
+		self.makersLock.Unlock()
+		return err
+	}
+	switch category {
+	case "Input":
+		return self.AddInputRunner(runner.(InputRunner))
+	case "Filter":
+		return self.AddFilterRunner(runner.(FilterRunner))
+	case "Output":
+		oRunner := runner.(OutputRunner)
+		if maker.Name() == self.Globals.Tap.Output {
+			if err = self.installTap(oRunner); err != nil {
+				self.makersLock.Lock()
+				delete(self.makers[category], maker.Name())
+				self.makersLock.Unlock()
+				return err
+			}
+		}
+		return self.AddOutputRunner(oRunner)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+const reloadBaseConfig = `
+[hekad]
+maxprocs = 2
+
+[StatAccumInput]
+
+[CounterFilter]
+message_matcher = "Type != 'heka.counter-output'"
+
+[TokenSplitter]
+delimiter = "\n"
+`
+
+func ConfigReloadSpec(c gs.Context) {
+	c.Specify("A config reload", func() {
+		tmpDir, err := ioutil.TempDir("", "config-reload")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(tmpDir)
+		writeConfig := func(name, contents string) {
+			err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(contents), 0644)
+			c.Assume(err, gs.IsNil)
+		}
+		writeConfig("base.toml", reloadBaseConfig)
+		writeConfig("notes.txt", "[Bogus]")
+
+		pConfig := NewPipelineConfig(nil)
+		c.Assume(pConfig.PreloadFromConfigFile(filepath.Join(tmpDir, "base.toml")), gs.IsNil)
+		c.Assume(pConfig.LoadConfig(), gs.IsNil)
+
+		diffDir := func() *configDiff {
+			sections, err := readConfigSections(tmpDir)
+			c.Assume(err, gs.IsNil)
+			diff, err := pConfig.diffConfig(sections)
+			c.Assume(err, gs.IsNil)
+			return diff
+		}
+
+		c.Specify("only reads the toml files", func() {
+			files, err := ConfigFiles(tmpDir)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(files), gs.Equals, 1)
+			c.Expect(files[0], gs.Equals, filepath.Join(tmpDir, "base.toml"))
+		})
+
+		c.Specify("hashes the toml files", func() {
+			hash, err := HashConfig(tmpDir)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(hash), gs.Equals, 64)
+			fileHash, err := HashConfig(filepath.Join(tmpDir, "base.toml"))
+			c.Expect(err, gs.IsNil)
+			c.Expect(fileHash, gs.Equals, hash)
+
+			writeConfig("other.toml", "[OtherCounter]\ntype = \"CounterFilter\"\n")
+			other, err := HashConfig(tmpDir)
+			c.Expect(err, gs.IsNil)
+			c.Expect(other, gs.Not(gs.Equals), hash)
+		})
+
+		c.Specify("refreshes the config hash", func() {
+			pConfig.Globals.ConfigHash = "stale"
+			c.Expect(pConfig.Reload(tmpDir), gs.IsNil)
+			hash, err := HashConfig(tmpDir)
+			c.Assume(err, gs.IsNil)
+			c.Expect(pConfig.Globals.DaemonInfo().ConfigHash, gs.Equals, hash)
+		})
+
+		c.Specify("leaves unchanged plugins alone", func() {
+			diff := diffDir()
+			c.Expect(len(diff.start), gs.Equals, 0)
+			c.Expect(len(diff.stop), gs.Equals, 0)
+			c.Expect(len(diff.ignored), gs.Equals, 0)
+		})
+
+		c.Specify("cycles a changed filter", func() {
+			writeConfig("base.toml", `
+[StatAccumInput]
+
+[CounterFilter]
+message_matcher = "Type == 'test'"
+
+[TokenSplitter]
+delimiter = "\n"
+`)
+			diff := diffDir()
+			c.Expect(len(diff.stop["Filter"]), gs.Equals, 1)
+			c.Expect(diff.stop["Filter"][0], gs.Equals, "CounterFilter")
+			c.Expect(len(diff.start["Filter"]), gs.Equals, 1)
+			c.Expect(diff.start["Filter"][0].Name(), gs.Equals, "CounterFilter")
+			c.Expect(len(diff.stop["Input"]), gs.Equals, 0)
+		})
+
+		c.Specify("starts added and stops removed plugins", func() {
+			writeConfig("base.toml", `
+[OtherCounter]
+type = "CounterFilter"
+message_matcher = "TRUE"
+
+[CounterFilter]
+message_matcher = "Type != 'heka.counter-output'"
+
+[TokenSplitter]
+delimiter = "\n"
+`)
+			diff := diffDir()
+			c.Expect(len(diff.start["Filter"]), gs.Equals, 1)
+			c.Expect(diff.start["Filter"][0].Name(), gs.Equals, "OtherCounter")
+			c.Expect(len(diff.stop["Filter"]), gs.Equals, 0)
+			c.Expect(len(diff.stop["Input"]), gs.Equals, 1)
+			c.Expect(diff.stop["Input"][0], gs.Equals, "StatAccumInput")
+		})
+
+		c.Specify("ignores changes needing a restart", func() {
+			writeConfig("splitter.toml", `
+[TokenSplitter]
+delimiter = "\t"
+`)
+			diff := diffDir()
+			c.Expect(len(diff.ignored), gs.Equals, 1)
+			c.Expect(diff.ignored[0], gs.Equals, "TokenSplitter")
+			c.Expect(len(diff.start), gs.Equals, 0)
+		})
+
+		c.Specify("ignores changes to the [hekad] section", func() {
+			writeConfig("hekad.toml", `
+[hekad]
+maxprocs = 4
+`)
+			diff := diffDir()
+			c.Expect(len(diff.ignored), gs.Equals, 1)
+			c.Expect(diff.ignored[0], gs.Equals, HEKA_DAEMON)
+			c.Expect(len(diff.start), gs.Equals, 0)
+			c.Expect(len(diff.stop), gs.Equals, 0)
+		})
+
+		c.Specify("fails on invalid config before stopping anything", func() {
+			writeConfig("bad.toml", `
+[BadCounter]
+type = "CounterFilter"
+bogus_setting = 1
+`)
+			sections, err := readConfigSections(tmpDir)
+			c.Assume(err, gs.IsNil)
+			_, err = pConfig.diffConfig(sections)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,70 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"github.com/mozilla-services/heka/message"
+)
+
+// Identifies the hekad build and the config it's running, so that instances
+// on old versions or with drifting configs can be spotted across a fleet.
+type DaemonInfo struct {
+	Version    string `json:"version"`
+	ConfigHash string `json:"config_hash"`
+	Hostname   string `json:"hostname"`
+}
+
+func (g *GlobalConfigStruct) DaemonInfo() DaemonInfo {
+	g.infoLock.RLock()
+	defer g.infoLock.RUnlock()
+	return DaemonInfo{
+		Version:    g.Version,
+		ConfigHash: g.ConfigHash,
+		Hostname:   g.Hostname,
+	}
+}
+
+func (g *GlobalConfigStruct) setConfigHash(hash string) {
+	g.infoLock.Lock()
+	g.ConfigHash = hash
+	g.infoLock.Unlock()
+}
+
+// Populates a `heka.daemon.info` message with the daemon info.
+func (info DaemonInfo) populate(msg *message.Message) {
+	msg.SetType("heka.daemon.info")
+	msg.SetLogger(HEKA_DAEMON)
+	msg.SetHostname(info.Hostname)
+	msg.SetPayload(info.Version + " " + info.ConfigHash)
+	message.NewStringField(msg, "version", info.Version)
+	message.NewStringField(msg, "config_hash", info.ConfigHash)
+}
+
+// Hands a `heka.daemon.info` message to the router for delivery, done once
+// at startup.
+func (pc *PipelineConfig) DaemonInfoMsg() {
+	pack, e := pc.PipelinePack(0)
+	if e != nil {
+		LogError.Println(e.Error())
+		return
+	}
+	pc.Globals.DaemonInfo().populate(pack.Message)
+	if err := pack.EncodeMsgBytes(); err != nil {
+		LogError.Printf("encoding heka.daemon.info message: %s\n", err.Error())
+		pack.recycle()
+	} else {
+		pc.router.InChan() <- pack
+	}
+}
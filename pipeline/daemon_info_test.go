@@ -0,0 +1,50 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func DaemonInfoSpec(c gs.Context) {
+	c.Specify("The daemon info", func() {
+		globals := DefaultGlobals()
+		globals.Version = "0.11.0"
+		globals.ConfigHash = "abc123"
+		globals.Hostname = "heka.example.com"
+		info := globals.DaemonInfo()
+
+		c.Specify("is taken from the globals", func() {
+			c.Expect(info.Version, gs.Equals, "0.11.0")
+			c.Expect(info.ConfigHash, gs.Equals, "abc123")
+			c.Expect(info.Hostname, gs.Equals, "heka.example.com")
+		})
+
+		c.Specify("populates a heka.daemon.info message", func() {
+			msg := new(message.Message)
+			info.populate(msg)
+			c.Expect(msg.GetType(), gs.Equals, "heka.daemon.info")
+			c.Expect(msg.GetLogger(), gs.Equals, "hekad")
+			c.Expect(msg.GetHostname(), gs.Equals, "heka.example.com")
+			val, ok := msg.GetFieldValue("version")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(val, gs.Equals, "0.11.0")
+			val, ok = msg.GetFieldValue("config_hash")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(val, gs.Equals, "abc123")
+		})
+	})
+}
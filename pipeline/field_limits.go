@@ -0,0 +1,94 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// fieldLimits caps the number of dynamic fields on the messages an input
+// delivers, and the size of each string or bytes field value, so
+// pathological messages can't blow up memory or downstream indexing.
+type fieldLimits struct {
+	maxFields     int
+	maxFieldBytes int
+	dropped       int64 // Fields removed because there were too many.
+	truncated     int64 // Fields with at least one value shortened.
+}
+
+// newFieldLimits returns nil if neither limit is set, so callers can skip the
+// check entirely.
+func newFieldLimits(maxFields, maxFieldBytes int) *fieldLimits {
+	if maxFields <= 0 && maxFieldBytes <= 0 {
+		return nil
+	}
+	return &fieldLimits{
+		maxFields:     maxFields,
+		maxFieldBytes: maxFieldBytes,
+	}
+}
+
+// apply enforces the limits on the pack's message, dropping any fields past
+// maxFields and truncating any string or bytes values that are longer than
+// maxFieldBytes.
+func (fl *fieldLimits) apply(pack *PipelinePack) {
+	if fl == nil {
+		return
+	}
+	msg := pack.Message
+	changed := false
+	if fl.maxFields > 0 && len(msg.Fields) > fl.maxFields {
+		atomic.AddInt64(&fl.dropped, int64(len(msg.Fields)-fl.maxFields))
+		msg.Fields = msg.Fields[:fl.maxFields]
+		changed = true
+	}
+	if fl.maxFieldBytes > 0 {
+		max := fl.maxFieldBytes
+		for _, field := range msg.Fields {
+			truncated := false
+			for i, v := range field.ValueString {
+				if len(v) > max {
+					// Back up to a rune boundary so we don't leave a partial
+					// UTF-8 sequence behind.
+					n := max
+					for n > 0 && !utf8.RuneStart(v[n]) {
+						n--
+					}
+					field.ValueString[i] = v[:n]
+					truncated = true
+				}
+			}
+			for i, v := range field.ValueBytes {
+				if len(v) > max {
+					field.ValueBytes[i] = v[:max]
+					truncated = true
+				}
+			}
+			if truncated {
+				atomic.AddInt64(&fl.truncated, 1)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		pack.TrustMsgBytes = false
+	}
+}
+
+// counts returns the number of dropped and truncated fields so far.
+func (fl *fieldLimits) counts() (dropped, truncated int64) {
+	return atomic.LoadInt64(&fl.dropped), atomic.LoadInt64(&fl.truncated)
+}
@@ -0,0 +1,80 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func FieldLimitsSpec(c gs.Context) {
+	c.Specify("Field limits", func() {
+		pack := NewPipelinePack(make(chan *PipelinePack, 1))
+		for i := 0; i < 5; i++ {
+			message.NewStringField(pack.Message, fmt.Sprintf("string%d", i), "0123456789")
+		}
+		f, _ := message.NewField("bytes", []byte("0123456789"), "")
+		pack.Message.AddField(f)
+		pack.TrustMsgBytes = true
+
+		c.Specify("aren't created when unset", func() {
+			c.Expect(newFieldLimits(0, 0) == nil, gs.IsTrue)
+			var fl *fieldLimits
+			fl.apply(pack)
+			c.Expect(len(pack.Message.Fields), gs.Equals, 6)
+			c.Expect(pack.TrustMsgBytes, gs.IsTrue)
+		})
+
+		c.Specify("drop excess fields", func() {
+			fl := newFieldLimits(3, 0)
+			fl.apply(pack)
+			c.Expect(len(pack.Message.Fields), gs.Equals, 3)
+			c.Expect(pack.TrustMsgBytes, gs.IsFalse)
+			dropped, truncated := fl.counts()
+			c.Expect(dropped, gs.Equals, int64(3))
+			c.Expect(truncated, gs.Equals, int64(0))
+		})
+
+		c.Specify("truncate long values", func() {
+			fl := newFieldLimits(0, 4)
+			fl.apply(pack)
+			c.Expect(len(pack.Message.Fields), gs.Equals, 6)
+			val, _ := pack.Message.GetFieldValue("string0")
+			c.Expect(val.(string), gs.Equals, "0123")
+			val, _ = pack.Message.GetFieldValue("bytes")
+			c.Expect(string(val.([]byte)), gs.Equals, "0123")
+			_, truncated := fl.counts()
+			c.Expect(truncated, gs.Equals, int64(6))
+		})
+
+		c.Specify("truncate on a rune boundary", func() {
+			pack.Message.Fields = nil
+			message.NewStringField(pack.Message, "utf8", "aé")
+			fl := newFieldLimits(0, 2)
+			fl.apply(pack)
+			val, _ := pack.Message.GetFieldValue("utf8")
+			c.Expect(val.(string), gs.Equals, "a")
+		})
+
+		c.Specify("leave small messages alone", func() {
+			fl := newFieldLimits(10, 100)
+			fl.apply(pack)
+			c.Expect(len(pack.Message.Fields), gs.Equals, 6)
+			c.Expect(pack.TrustMsgBytes, gs.IsTrue)
+		})
+	})
+}
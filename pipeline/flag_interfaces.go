@@ -43,6 +43,14 @@ type EncodesMsgBytes interface {
 	EncodesMsgBytes() bool
 }
 
+// DefersDelivery is implemented by output plugins whose ProcessMessage only
+// adds the message to a batch that's sent later, so that a nil return value
+// doesn't mean that the message has been delivered. The OutputRunner refuses
+// the `ack_on_success` setting for an output returning true.
+type DefersDelivery interface {
+	DefersDelivery() bool
+}
+
 // Restarting indicates a plug-in can handle being restart should it exit
 // before heka is shut-down.
 type Restarting interface {
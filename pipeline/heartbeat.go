@@ -0,0 +1,85 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// Snapshot of the daemon's health, emitted every `heartbeat_interval` so
+// that downstream monitoring can detect a wedged hekad.
+type heartbeat struct {
+	uptime     time.Duration
+	goroutines int
+	// Messages per second handed to the router since the previous heartbeat.
+	injectRate float64
+}
+
+// Populates a `heka.heartbeat` message with the heartbeat data.
+func (hb heartbeat) populate(msg *message.Message) {
+	uptime := int64(hb.uptime / time.Second)
+	msg.SetType("heka.heartbeat")
+	msg.SetLogger(HEKA_DAEMON)
+	msg.SetPayload(fmt.Sprintf("uptime=%ds goroutines=%d inject_rate=%.2f",
+		uptime, hb.goroutines, hb.injectRate))
+	message.NewInt64Field(msg, "uptime", uptime, "s")
+	message.NewInt64Field(msg, "goroutines", int64(hb.goroutines), "count")
+	if f, err := message.NewField("inject_rate", hb.injectRate, "count/s"); err == nil {
+		msg.AddField(f)
+	}
+}
+
+// Hands a `heka.heartbeat` message to the router every interval until the
+// stop channel is closed.
+func (pc *PipelineConfig) heartbeatLoop(interval time.Duration,
+	stop chan struct{}) {
+
+	start := time.Now()
+	last, lastCount := start, atomic.LoadInt64(&pc.router.processMessageCount)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			count := atomic.LoadInt64(&pc.router.processMessageCount)
+			hb := heartbeat{
+				uptime:     now.Sub(start),
+				goroutines: runtime.NumGoroutine(),
+				injectRate: float64(count-lastCount) / now.Sub(last).Seconds(),
+			}
+			last, lastCount = now, count
+			pc.heartbeatMsg(hb)
+		}
+	}
+}
+
+func (pc *PipelineConfig) heartbeatMsg(hb heartbeat) {
+	pack, e := pc.PipelinePack(0)
+	if e != nil {
+		LogError.Println(e.Error())
+		return
+	}
+	hb.populate(pack.Message)
+	if err := pack.EncodeMsgBytes(); err != nil {
+		LogError.Printf("encoding heka.heartbeat message: %s\n", err.Error())
+		pack.recycle()
+	} else {
+		pc.router.InChan() <- pack
+	}
+}
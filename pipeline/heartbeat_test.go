@@ -0,0 +1,57 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func HeartbeatSpec(c gs.Context) {
+	c.Specify("A heartbeat", func() {
+		hb := heartbeat{
+			uptime:     90*time.Second + 500*time.Millisecond,
+			goroutines: 42,
+			injectRate: 12.5,
+		}
+
+		c.Specify("populates a heka.heartbeat message", func() {
+			msg := new(message.Message)
+			hb.populate(msg)
+			c.Expect(msg.GetType(), gs.Equals, "heka.heartbeat")
+			c.Expect(msg.GetLogger(), gs.Equals, "hekad")
+			c.Expect(msg.GetPayload(), gs.Equals,
+				"uptime=90s goroutines=42 inject_rate=12.50")
+			val, ok := msg.GetFieldValue("uptime")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(val, gs.Equals, int64(90))
+			val, ok = msg.GetFieldValue("goroutines")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(val, gs.Equals, int64(42))
+			val, ok = msg.GetFieldValue("inject_rate")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(val, gs.Equals, 12.5)
+		})
+
+		c.Specify("is injected into the router", func() {
+			config := NewPipelineConfig(nil)
+			config.injectRecycleChan <- NewPipelinePack(config.injectRecycleChan)
+			config.heartbeatMsg(hb)
+			pack := <-config.router.InChan()
+			c.Expect(pack.Message.GetType(), gs.Equals, "heka.heartbeat")
+			c.Expect(pack.MsgLoopCount, gs.Equals, uint(1))
+			c.Expect(len(pack.MsgBytes) > 0, gs.IsTrue)
+		})
+	})
+}
@@ -0,0 +1,109 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// inputCheckpoint counts the records an input delivers and periodically
+// injects a `heka.input.checkpoint` message with the counts for the window
+// since the previous checkpoint, so downstream consumers can detect gaps.
+type inputCheckpoint struct {
+	interval    time.Duration
+	messages    int64
+	bytes       int64
+	windowStart time.Time
+}
+
+// newInputCheckpoint returns nil if the interval is 0, so callers can skip
+// the counting entirely.
+func newInputCheckpoint(interval uint) *inputCheckpoint {
+	if interval == 0 {
+		return nil
+	}
+	return &inputCheckpoint{
+		interval: time.Duration(interval) * time.Second,
+	}
+}
+
+// wrap returns a DeliverFunc that counts each pack before handing it to the
+// provided one.
+func (cp *inputCheckpoint) wrap(deliver DeliverFunc) DeliverFunc {
+	if cp == nil || deliver == nil {
+		return deliver
+	}
+	return func(pack *PipelinePack) {
+		cp.count(pack)
+		deliver(pack)
+	}
+}
+
+// count records a delivered pack. The size is that of the raw record, i.e.
+// the message bytes if the splitter provided them and the payload otherwise.
+func (cp *inputCheckpoint) count(pack *PipelinePack) {
+	size := len(pack.MsgBytes)
+	if size == 0 {
+		size = len(pack.Message.GetPayload())
+	}
+	atomic.AddInt64(&cp.messages, 1)
+	atomic.AddInt64(&cp.bytes, int64(size))
+}
+
+// populate resets the counters and fills in the checkpoint message for the
+// window ending at the provided time.
+func (cp *inputCheckpoint) populate(msg *message.Message, inputName string,
+	now time.Time) {
+
+	messages := atomic.SwapInt64(&cp.messages, 0)
+	bytes := atomic.SwapInt64(&cp.bytes, 0)
+	msg.SetType("heka.input.checkpoint")
+	msg.SetLogger(HEKA_DAEMON)
+	msg.SetTimestamp(now.UnixNano())
+	message.NewStringField(msg, "input", inputName)
+	message.NewInt64Field(msg, "message_count", messages, "count")
+	message.NewInt64Field(msg, "byte_count", bytes, "B")
+	message.NewInt64Field(msg, "window_start", cp.windowStart.UnixNano(), "ns")
+	message.NewInt64Field(msg, "window_end", now.UnixNano(), "ns")
+	cp.windowStart = now
+}
+
+// run injects a checkpoint message every interval until the stop channel is
+// closed.
+func (cp *inputCheckpoint) run(ir *iRunner, stop chan struct{}) {
+	cp.windowStart = time.Now()
+	ticker := time.NewTicker(cp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if ir.pConfig.Globals.IsShuttingDown() {
+				return
+			}
+			pack, err := ir.pConfig.PipelinePack(0)
+			if err != nil {
+				ir.LogError(err)
+				continue
+			}
+			cp.populate(pack.Message, ir.name, now)
+			ir.Inject(pack)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func InputCheckpointSpec(c gs.Context) {
+	c.Specify("An input checkpoint", func() {
+		recycleChan := make(chan *PipelinePack, 1)
+
+		c.Specify("isn't created when unset", func() {
+			cp := newInputCheckpoint(0)
+			c.Expect(cp == nil, gs.IsTrue)
+			called := false
+			deliver := cp.wrap(func(pack *PipelinePack) { called = true })
+			deliver(NewPipelinePack(recycleChan))
+			c.Expect(called, gs.IsTrue)
+		})
+
+		c.Specify("counts delivered records", func() {
+			cp := newInputCheckpoint(60)
+			var delivered []*PipelinePack
+			deliver := cp.wrap(func(pack *PipelinePack) {
+				delivered = append(delivered, pack)
+			})
+
+			pack := NewPipelinePack(recycleChan)
+			pack.Message.SetPayload("twelve bytes")
+			deliver(pack)
+			pack = NewPipelinePack(recycleChan)
+			pack.MsgBytes = []byte("raw")
+			pack.Message.SetPayload("ignored payload")
+			deliver(pack)
+			c.Expect(len(delivered), gs.Equals, 2)
+
+			start := time.Unix(1000, 0)
+			cp.windowStart = start
+			end := start.Add(time.Minute)
+			msg := new(message.Message)
+			cp.populate(msg, "TestInput", end)
+
+			c.Expect(msg.GetType(), gs.Equals, "heka.input.checkpoint")
+			c.Expect(msg.GetLogger(), gs.Equals, "hekad")
+			c.Expect(msg.GetTimestamp(), gs.Equals, end.UnixNano())
+			val, _ := msg.GetFieldValue("input")
+			c.Expect(val, gs.Equals, "TestInput")
+			val, _ = msg.GetFieldValue("message_count")
+			c.Expect(val, gs.Equals, int64(2))
+			val, _ = msg.GetFieldValue("byte_count")
+			c.Expect(val, gs.Equals, int64(15))
+			val, _ = msg.GetFieldValue("window_start")
+			c.Expect(val, gs.Equals, start.UnixNano())
+			val, _ = msg.GetFieldValue("window_end")
+			c.Expect(val, gs.Equals, end.UnixNano())
+
+			c.Specify("and starts a new window after each checkpoint", func() {
+				msg = new(message.Message)
+				cp.populate(msg, "TestInput", end.Add(time.Minute))
+				val, _ := msg.GetFieldValue("message_count")
+				c.Expect(val, gs.Equals, int64(0))
+				val, _ = msg.GetFieldValue("window_start")
+				c.Expect(val, gs.Equals, end.UnixNano())
+			})
+		})
+	})
+}
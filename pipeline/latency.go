@@ -0,0 +1,112 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of recent latency samples each output keeps to compute the
+// percentiles in its report.
+const latencySampleSize = 1024
+
+// latencyTracker keeps the pipeline latencies of the most recent messages
+// delivered by an output, i.e. the time from the input handing each message
+// to Heka until the output's ProcessMessage call succeeded, or the batch
+// holding it was sent.
+type latencyTracker struct {
+	lock    sync.Mutex
+	samples []int64
+	next    int
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{samples: make([]int64, 0, latencySampleSize)}
+}
+
+// observe records the latency of a message with the given ingest time, if it
+// has one. Messages generated by filters don't.
+func (lt *latencyTracker) observe(ingestTime int64) {
+	if lt == nil || ingestTime == 0 {
+		return
+	}
+	lt.add(time.Now().UnixNano() - ingestTime)
+}
+
+func (lt *latencyTracker) add(latency int64) {
+	lt.lock.Lock()
+	if len(lt.samples) < cap(lt.samples) {
+		lt.samples = append(lt.samples, latency)
+	} else {
+		lt.samples[lt.next] = latency
+		lt.next = (lt.next + 1) % len(lt.samples)
+	}
+	lt.lock.Unlock()
+}
+
+// percentiles returns the 50th, 95th, and 99th percentile of the kept
+// samples, in nanoseconds. ok is false if there aren't any samples yet.
+func (lt *latencyTracker) percentiles() (p50, p95, p99 int64, ok bool) {
+	lt.lock.Lock()
+	sorted := make([]int64, len(lt.samples))
+	copy(sorted, lt.samples)
+	lt.lock.Unlock()
+	if len(sorted) == 0 {
+		return 0, 0, 0, false
+	}
+	sort.Sort(int64Slice(sorted))
+	rank := func(p int) int64 {
+		// Nearest rank, i.e. the smallest sample that's >= p% of them.
+		i := (p*len(sorted)+99)/100 - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	return rank(50), rank(95), rank(99), true
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// stampIngest wraps an input's DeliverFunc so that each pack is stamped with
+// the time the input delivered it.
+func stampIngest(deliver DeliverFunc) DeliverFunc {
+	if deliver == nil {
+		return deliver
+	}
+	return func(pack *PipelinePack) {
+		pack.IngestTime = time.Now().UnixNano()
+		deliver(pack)
+	}
+}
+
+// Gives the packs a decoder produced the ingest time of the pack they were
+// decoded from.
+func propagateIngest(ingestTime int64, packs []*PipelinePack) {
+	if ingestTime == 0 {
+		return
+	}
+	for _, p := range packs {
+		if p.IngestTime == 0 {
+			p.IngestTime = ingestTime
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func LatencySpec(c gs.Context) {
+	c.Specify("A latency tracker", func() {
+		lt := newLatencyTracker()
+
+		c.Specify("has no percentiles without samples", func() {
+			_, _, _, ok := lt.percentiles()
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("computes the percentiles", func() {
+			for i := int64(100); i > 0; i-- {
+				lt.add(i)
+			}
+			p50, p95, p99, ok := lt.percentiles()
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(p50, gs.Equals, int64(50))
+			c.Expect(p95, gs.Equals, int64(95))
+			c.Expect(p99, gs.Equals, int64(99))
+		})
+
+		c.Specify("only keeps the most recent samples", func() {
+			for i := 0; i < latencySampleSize; i++ {
+				lt.add(1000)
+			}
+			for i := 0; i < latencySampleSize; i++ {
+				lt.add(1)
+			}
+			c.Expect(len(lt.samples), gs.Equals, latencySampleSize)
+			_, _, p99, _ := lt.percentiles()
+			c.Expect(p99, gs.Equals, int64(1))
+		})
+
+		c.Specify("ignores messages without an ingest time", func() {
+			lt.observe(0)
+			c.Expect(len(lt.samples), gs.Equals, 0)
+
+			lt.observe(time.Now().Add(-time.Second).UnixNano())
+			c.Assume(len(lt.samples), gs.Equals, 1)
+			c.Expect(lt.samples[0] >= int64(time.Second), gs.IsTrue)
+		})
+	})
+
+	c.Specify("Ingest stamping", func() {
+		var delivered *PipelinePack
+		deliver := stampIngest(func(pack *PipelinePack) {
+			delivered = pack
+		})
+		pack := NewPipelinePack(make(chan *PipelinePack, 1))
+		before := time.Now().UnixNano()
+		deliver(pack)
+		c.Expect(delivered, gs.Equals, pack)
+		c.Expect(pack.IngestTime >= before, gs.IsTrue)
+
+		c.Specify("is carried over to decoded packs", func() {
+			other := NewPipelinePack(make(chan *PipelinePack, 1))
+			propagateIngest(pack.IngestTime, []*PipelinePack{pack, other})
+			c.Expect(other.IngestTime, gs.Equals, pack.IngestTime)
+		})
+
+		c.Specify("is cleared when the pack is zeroed", func() {
+			pack.Zero()
+			c.Expect(pack.IngestTime, gs.Equals, int64(0))
+		})
+	})
+}
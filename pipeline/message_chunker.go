@@ -0,0 +1,189 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"unicode/utf8"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/pborman/uuid"
+)
+
+// Maximum number of packs a chunker hands out at a time. Chunks are delivered
+// in packs from the chunker's own pool, which the router goroutine can wait
+// on without competing with the plugins for the shared inject pool.
+const chunkPackPoolSize = 10
+
+// messageChunker splits messages whose payload, or the values of a repeated
+// field, are larger than an output's `split_size` into several smaller
+// messages. Each chunk gets its own UUID and carries `chunk_id` (the original
+// message's UUID), `chunk_index`, and `chunk_count` fields so the receiving
+// end can reassemble them.
+type messageChunker struct {
+	size      int
+	field     string // Split the values of this field instead of the payload.
+	packs     chan *PipelinePack
+	packsMade int
+}
+
+func newMessageChunker(size int, field string, poolSize int) *messageChunker {
+	return &messageChunker{
+		size:  size,
+		field: field,
+		packs: make(chan *PipelinePack, poolSize),
+	}
+}
+
+// pack returns an empty pack from the chunker's pool, allocating packs as
+// needed until the pool is full and waiting for one to be recycled after
+// that. Only called from the router goroutine of the chunker's MatchRunner.
+func (mc *messageChunker) pack() *PipelinePack {
+	select {
+	case pack := <-mc.packs:
+		return pack
+	default:
+	}
+	if mc.packsMade < cap(mc.packs) {
+		mc.packsMade++
+		return NewPipelinePack(mc.packs)
+	}
+	return <-mc.packs
+}
+
+// split returns the chunks for the provided message, or nil if the message is
+// small enough to be delivered as is.
+func (mc *messageChunker) split(msg *message.Message) []*message.Message {
+	var chunks []*message.Message
+	if mc.field == "" {
+		chunks = mc.splitPayload(msg)
+	} else {
+		chunks = mc.splitField(msg)
+	}
+	if chunks == nil {
+		return nil
+	}
+	chunkId := msg.GetUuidString()
+	for i, chunk := range chunks {
+		chunk.SetUuid(uuid.NewRandom())
+		message.NewStringField(chunk, "chunk_id", chunkId)
+		message.NewIntField(chunk, "chunk_index", i, "")
+		message.NewIntField(chunk, "chunk_count", len(chunks), "")
+	}
+	return chunks
+}
+
+func (mc *messageChunker) splitPayload(msg *message.Message) []*message.Message {
+	payload := msg.GetPayload()
+	if len(payload) <= mc.size {
+		return nil
+	}
+	var chunks []*message.Message
+	for len(payload) > 0 {
+		n := len(payload)
+		if n > mc.size {
+			// Back up to a rune boundary so multi-byte characters aren't
+			// split across chunks.
+			n = mc.size
+			for n > 0 && !utf8.RuneStart(payload[n]) {
+				n--
+			}
+			if n == 0 {
+				n = mc.size
+			}
+		}
+		chunk := message.CopyMessage(msg)
+		chunk.SetPayload(payload[:n])
+		chunks = append(chunks, chunk)
+		payload = payload[n:]
+	}
+	return chunks
+}
+
+func (mc *messageChunker) splitField(msg *message.Message) []*message.Message {
+	field := msg.FindFirstField(mc.field)
+	if field == nil {
+		return nil
+	}
+	sizes := fieldValueSizes(field)
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	if total <= mc.size || len(sizes) < 2 {
+		return nil
+	}
+
+	// Group consecutive values so each chunk stays under the limit, a single
+	// value that's over the limit gets a chunk of its own.
+	var chunks []*message.Message
+	start, groupSize := 0, 0
+	for i, size := range sizes {
+		if i > start && groupSize+size > mc.size {
+			chunks = append(chunks, fieldChunk(msg, mc.field, start, i))
+			start, groupSize = i, 0
+		}
+		groupSize += size
+	}
+	chunks = append(chunks, fieldChunk(msg, mc.field, start, len(sizes)))
+	return chunks
+}
+
+// Returns the size in bytes of each of the field's values.
+func fieldValueSizes(field *message.Field) []int {
+	var sizes []int
+	switch field.GetValueType() {
+	case message.Field_STRING:
+		for _, v := range field.ValueString {
+			sizes = append(sizes, len(v))
+		}
+	case message.Field_BYTES:
+		for _, v := range field.ValueBytes {
+			sizes = append(sizes, len(v))
+		}
+	case message.Field_INTEGER:
+		for _ = range field.ValueInteger {
+			sizes = append(sizes, 8)
+		}
+	case message.Field_DOUBLE:
+		for _ = range field.ValueDouble {
+			sizes = append(sizes, 8)
+		}
+	case message.Field_BOOL:
+		for _ = range field.ValueBool {
+			sizes = append(sizes, 1)
+		}
+	}
+	return sizes
+}
+
+// Returns a copy of the message where the named field only has the values in
+// the [start, end) range.
+func fieldChunk(msg *message.Message, name string, start, end int) *message.Message {
+	chunk := message.CopyMessage(msg)
+	field := chunk.FindFirstField(name)
+	switch field.GetValueType() {
+	case message.Field_STRING:
+		field.ValueString = field.ValueString[start:end]
+	case message.Field_BYTES:
+		field.ValueBytes = field.ValueBytes[start:end]
+	case message.Field_INTEGER:
+		field.ValueInteger = field.ValueInteger[start:end]
+	case message.Field_DOUBLE:
+		field.ValueDouble = field.ValueDouble[start:end]
+	case message.Field_BOOL:
+		field.ValueBool = field.ValueBool[start:end]
+	}
+	return chunk
+}
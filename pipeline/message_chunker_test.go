@@ -0,0 +1,142 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mozilla-services/heka/message"
+	ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func MessageChunkerSpec(c gs.Context) {
+	c.Specify("A message chunker", func() {
+		msg := ts.GetTestMessage()
+		uuidStr := msg.GetUuidString()
+
+		c.Specify("leaves small messages alone", func() {
+			msg.SetPayload("0123456789")
+			mc := newMessageChunker(10, "", 1)
+			c.Expect(mc.split(msg) == nil, gs.IsTrue)
+		})
+
+		c.Specify("splits the payload", func() {
+			msg.SetPayload("0123456789abcdefghij0123")
+			mc := newMessageChunker(10, "", 1)
+			chunks := mc.split(msg)
+			c.Assume(len(chunks), gs.Equals, 3)
+			c.Expect(chunks[0].GetPayload(), gs.Equals, "0123456789")
+			c.Expect(chunks[1].GetPayload(), gs.Equals, "abcdefghij")
+			c.Expect(chunks[2].GetPayload(), gs.Equals, "0123")
+			for i, chunk := range chunks {
+				c.Expect(chunk.GetUuidString(), gs.Not(gs.Equals), uuidStr)
+				c.Expect(chunk.GetType(), gs.Equals, msg.GetType())
+				val, _ := chunk.GetFieldValue("chunk_id")
+				c.Expect(val.(string), gs.Equals, uuidStr)
+				val, _ = chunk.GetFieldValue("chunk_index")
+				c.Expect(val.(int64), gs.Equals, int64(i))
+				val, _ = chunk.GetFieldValue("chunk_count")
+				c.Expect(val.(int64), gs.Equals, int64(3))
+			}
+			c.Expect(msg.GetPayload(), gs.Equals, "0123456789abcdefghij0123")
+		})
+
+		c.Specify("doesn't split multi-byte characters", func() {
+			msg.SetPayload("aaaaébb")
+			mc := newMessageChunker(5, "", 1)
+			chunks := mc.split(msg)
+			c.Assume(len(chunks), gs.Equals, 2)
+			c.Expect(chunks[0].GetPayload(), gs.Equals, "aaaa")
+			c.Expect(chunks[1].GetPayload(), gs.Equals, "ébb")
+		})
+
+		c.Specify("splits the values of a repeated field", func() {
+			f, _ := message.NewField("items", "aaaa", "")
+			f.AddValue("bbbb")
+			f.AddValue("cccc")
+			f.AddValue("dddddddddddd")
+			f.AddValue("e")
+			msg.AddField(f)
+			mc := newMessageChunker(10, "items", 1)
+			chunks := mc.split(msg)
+			c.Assume(len(chunks), gs.Equals, 4)
+			field := chunks[0].FindFirstField("items")
+			c.Expect(len(field.ValueString), gs.Equals, 2)
+			field = chunks[1].FindFirstField("items")
+			c.Expect(len(field.ValueString), gs.Equals, 1)
+			c.Expect(field.ValueString[0], gs.Equals, "cccc")
+			// Values over the limit get a chunk of their own.
+			field = chunks[2].FindFirstField("items")
+			c.Expect(len(field.ValueString), gs.Equals, 1)
+			c.Expect(field.ValueString[0], gs.Equals, "dddddddddddd")
+			field = chunks[3].FindFirstField("items")
+			c.Expect(field.ValueString[0], gs.Equals, "e")
+			c.Expect(chunks[3].GetPayload(), gs.Equals, msg.GetPayload())
+			c.Expect(len(msg.FindFirstField("items").ValueString), gs.Equals, 5)
+		})
+	})
+
+	c.Specify("A buffered output with split_size", func() {
+		tmpDir, err := ioutil.TempDir("", "chunker-tests")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(tmpDir)
+
+		pConfig := NewPipelineConfig(nil)
+		err = pConfig.RegisterDefault("HekaFramingSplitter")
+		c.Assume(err, gs.IsNil)
+		commonFO := CommonFOConfig{Matcher: "TRUE"}
+		oRunner, err := NewFORunner("FooOutput", &FooOutput{}, commonFO, "FooOutput", 10)
+		c.Assume(err, gs.IsNil)
+		qConfig := &QueueBufferConfig{CursorUpdateCount: 1, MaxFileSize: 66000}
+		feeder, reader, err := NewBufferSet(tmpDir, "test", qConfig, oRunner, pConfig)
+		c.Assume(err, gs.IsNil)
+		feeder.queue = tmpDir
+		reader.queue = tmpDir
+		reader.checkpointFilename = filepath.Join(tmpDir, "cp.txt")
+		err = feeder.RollQueue()
+		c.Assume(err, gs.IsNil)
+
+		mr := oRunner.matcher
+		mr.bufFeeder = feeder
+		mr.chunker = newMessageChunker(10, "", 1)
+
+		c.Specify("queues each chunk as an encoded record", func() {
+			recycleChan := make(chan *PipelinePack, 1)
+			pack := NewPipelinePack(recycleChan)
+			pack.Message = ts.GetTestMessage()
+			pack.Message.SetPayload("0123456789abcdefghij0123")
+			pack.MsgLoopCount = 2
+			err = mr.deliverChunks(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(recycleChan), gs.Equals, 1)
+			// The single chunk pack is back in the pool after each delivery.
+			c.Expect(len(mr.chunker.packs), gs.Equals, 1)
+			feeder.writeFile.Close()
+
+			recPack := NewPipelinePack(nil)
+			for i, payload := range []string{"0123456789", "abcdefghij", "0123"} {
+				err = reader.NextRecord(recPack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(recPack.Message.GetPayload(), gs.Equals, payload)
+				val, _ := recPack.Message.GetFieldValue("chunk_index")
+				c.Expect(val.(int64), gs.Equals, int64(i))
+			}
+			reader.readFile.Close()
+		})
+	})
+}
@@ -0,0 +1,102 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// Settings for the global message tap, which delivers a copy of a sample of
+// the messages matching a message matcher to a single output, whether or not
+// they match the output's own message_matcher. Meant to be switched on during
+// incidents to get a firehose of traffic into a debug output without having to
+// edit any of the existing matchers.
+type TapConfig struct {
+	// Name of the output the tapped messages are delivered to. The tap is
+	// disabled if this is empty.
+	Output string
+	// Message matcher selecting the messages to tap. Defaults to "TRUE".
+	Matcher string
+	// Fraction of the matching messages that are tapped, greater than 0 and
+	// at most 1. Defaults to 1, i.e. every matching message.
+	SampleRate float64
+}
+
+// Tap installed on the target output's MatchRunner. Only used from the
+// MatchRunner's goroutine, so it doesn't need any locking.
+type messageTap struct {
+	spec       *message.MatcherSpecification
+	sampleRate float64
+	rand       *rand.Rand
+}
+
+func newMessageTap(config TapConfig) (*messageTap, error) {
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		return nil, errors.New("`tap_sample_rate` must be greater than 0 and at most 1")
+	}
+	matcher := config.Matcher
+	if matcher == "" {
+		matcher = "TRUE"
+	}
+	spec, err := message.CreateMatcherSpecification(matcher)
+	if err != nil {
+		return nil, fmt.Errorf("invalid `tap_matcher`: %s", err)
+	}
+	return &messageTap{
+		spec:       spec,
+		sampleRate: config.SampleRate,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Returns whether the message should be copied to the tap output.
+func (t *messageTap) match(msg *message.Message) bool {
+	if !t.spec.Match(msg) {
+		return false
+	}
+	return t.sampleRate >= 1 || t.rand.Float64() < t.sampleRate
+}
+
+// Installs the global tap on the MatchRunner of the configured output. Must
+// be called after the outputs have been loaded and before they're started.
+func (self *PipelineConfig) setTap() error {
+	oRunner, ok := self.Output(self.Globals.Tap.Output)
+	if !ok {
+		return fmt.Errorf("tap output '%s' isn't configured", self.Globals.Tap.Output)
+	}
+	return self.installTap(oRunner)
+}
+
+// Installs the global tap on the output runner's MatchRunner, before the
+// runner is started. A config reload that restarts the tap output installs
+// it on the new runner.
+func (self *PipelineConfig) installTap(oRunner OutputRunner) error {
+	config := self.Globals.Tap
+	tap, err := newMessageTap(config)
+	if err != nil {
+		return err
+	}
+	mr := oRunner.MatchRunner()
+	if mr == nil {
+		return fmt.Errorf("tap output '%s' has no message matcher", config.Output)
+	}
+	mr.tap = tap
+	return nil
+}
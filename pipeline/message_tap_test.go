@@ -0,0 +1,112 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"math/rand"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func MessageTapSpec(c gs.Context) {
+	c.Specify("A message tap", func() {
+		config := TapConfig{Output: "DebugOutput", SampleRate: 1}
+
+		c.Specify("validates its settings", func() {
+			config.SampleRate = 0
+			_, err := newMessageTap(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+			config.SampleRate = 1.5
+			_, err = newMessageTap(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+			config.SampleRate = 1
+			config.Matcher = "Type =="
+			_, err = newMessageTap(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("taps matching messages", func() {
+			config.Matcher = "Type == 'tapped'"
+			tap, err := newMessageTap(config)
+			c.Assume(err, gs.IsNil)
+			pack := NewPipelinePack(nil)
+			pack.Message.SetType("tapped")
+			c.Expect(tap.match(pack.Message), gs.IsTrue)
+			pack.Message.SetType("other")
+			c.Expect(tap.match(pack.Message), gs.IsFalse)
+		})
+
+		c.Specify("samples matching messages", func() {
+			config.SampleRate = 0.25
+			tap, err := newMessageTap(config)
+			c.Assume(err, gs.IsNil)
+			tap.rand = rand.New(rand.NewSource(1))
+			pack := NewPipelinePack(nil)
+			tapped := 0
+			for i := 0; i < 1000; i++ {
+				if tap.match(pack.Message) {
+					tapped++
+				}
+			}
+			c.Expect(tapped > 150, gs.IsTrue)
+			c.Expect(tapped < 350, gs.IsTrue)
+		})
+
+		c.Specify("is installed on the tap output's runner", func() {
+			pConfig := NewPipelineConfig(nil)
+			pConfig.Globals.Tap = config
+			oRunner, err := NewFORunner("DebugOutput", &FooOutput{},
+				CommonFOConfig{Matcher: "FALSE"}, "FooOutput", 5)
+			c.Assume(err, gs.IsNil)
+			c.Expect(pConfig.setTap(), gs.Not(gs.IsNil))
+			pConfig.OutputRunners["DebugOutput"] = oRunner
+			c.Expect(pConfig.setTap(), gs.IsNil)
+			c.Expect(oRunner.MatchRunner().tap, gs.Not(gs.IsNil))
+
+			// As if a config reload restarted the output.
+			reloaded, err := NewFORunner("DebugOutput", &FooOutput{},
+				CommonFOConfig{Matcher: "FALSE"}, "FooOutput", 5)
+			c.Assume(err, gs.IsNil)
+			c.Expect(pConfig.installTap(reloaded), gs.IsNil)
+			c.Expect(reloaded.MatchRunner().tap, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("delivers tapped messages the output's matcher rejects", func() {
+			config.Matcher = "Type == 'tapped'"
+			tap, err := newMessageTap(config)
+			c.Assume(err, gs.IsNil)
+			matchChan := make(chan *PipelinePack, 5)
+			mr, err := NewMatchRunner("Type == 'routed'", "", nil, 5, matchChan)
+			c.Assume(err, gs.IsNil)
+			mr.tap = tap
+			recycleChan := make(chan *PipelinePack, 5)
+			for _, typ := range []string{"routed", "tapped", "other"} {
+				pack := NewPipelinePack(recycleChan)
+				pack.Message.SetType(typ)
+				pack.RefCount = 1
+				mr.inChan <- pack
+			}
+			mr.Close()
+			mr.run(1)
+
+			c.Expect(len(matchChan), gs.Equals, 2)
+			c.Expect((<-matchChan).Message.GetType(), gs.Equals, "routed")
+			c.Expect((<-matchChan).Message.GetType(), gs.Equals, "tapped")
+			c.Expect(len(recycleChan), gs.Equals, 1)
+			c.Expect(mr.MatchCount(), gs.Equals, int64(1))
+			c.Expect(mr.TapCount(), gs.Equals, int64(1))
+		})
+	})
+}
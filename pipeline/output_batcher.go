@@ -0,0 +1,187 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// Can be implemented by Outputs that are able to send several encoded
+// messages in one call. If the output's config sets `batch_size` the runner
+// encodes each message with the output's encoder and hands the records to
+// ProcessBatch once `batch_size` of them are collected or the
+// `batch_flush_interval` has passed, instead of calling ProcessMessage.
+// Returning a RetryMessageError causes the same batch to be retried before
+// any further records are collected, any other error drops the batch.
+type BatchProcessor interface {
+	ProcessBatch(records [][]byte) (err error)
+}
+
+// A message in the current batch to acknowledge or to record the latency of
+// once the batch is sent.
+type batchedMsg struct {
+	uuid         string
+	msgLoopCount uint
+	ingestTime   int64
+	ack          bool
+}
+
+// outputBatcher stands in for an output's ProcessMessage method when the
+// output is batching. It's only ever used from the runner's goroutine.
+type outputBatcher struct {
+	runner  *foRunner
+	plugin  BatchProcessor
+	size    int
+	records [][]byte
+	msgs    []batchedMsg
+	// Queue cursor of the last record added, committed once the batch is sent.
+	cursor   string
+	interval time.Duration
+	ticker   *time.Ticker
+}
+
+func newOutputBatcher(runner *foRunner, plugin BatchProcessor, size uint,
+	flushInterval string) (b *outputBatcher, err error) {
+
+	b = &outputBatcher{
+		runner:  runner,
+		plugin:  plugin,
+		size:    int(size),
+		records: make([][]byte, 0, size),
+	}
+	if flushInterval == "" {
+		flushInterval = "1s"
+	}
+	if b.interval, err = time.ParseDuration(flushInterval); err != nil {
+		return nil, fmt.Errorf("invalid batch_flush_interval: %s", err)
+	}
+	if b.interval < 0 {
+		return nil, fmt.Errorf("invalid batch_flush_interval: %s", flushInterval)
+	}
+	return b, nil
+}
+
+// flushChan returns the channel on which flush times are delivered, or nil
+// if the batch is only sent once it's full.
+func (b *outputBatcher) flushChan() <-chan time.Time {
+	if b == nil || b.interval == 0 {
+		return nil
+	}
+	if b.ticker == nil {
+		b.ticker = time.NewTicker(b.interval)
+	}
+	return b.ticker.C
+}
+
+func (b *outputBatcher) ProcessMessage(pack *PipelinePack) error {
+	// A full batch left over from a failed send goes out before anything new
+	// is added, so a retried message is never added twice.
+	if len(b.records) >= b.size {
+		if err := b.flush(); err != nil {
+			switch err.(type) {
+			case RetryMessageError, PluginExitError:
+				return err
+			}
+			// The message isn't part of the dropped batch.
+			b.runner.LogError(err)
+		}
+	}
+	record, err := b.runner.Encode(pack)
+	if err != nil {
+		return fmt.Errorf("can't encode message: %s", err)
+	}
+	if record != nil {
+		b.records = append(b.records, record)
+		ack := b.runner.wantsAck(pack)
+		if ack || b.runner.latency != nil {
+			b.msgs = append(b.msgs, batchedMsg{pack.Message.GetUuidString(),
+				pack.MsgLoopCount, pack.IngestTime, ack})
+		}
+	}
+	b.cursor = pack.QueueCursor
+	if len(b.records) < b.size {
+		return nil
+	}
+	if err = b.flush(); err != nil {
+		if _, ok := err.(RetryMessageError); ok {
+			// The message is in the batch, the retry happens when the next
+			// message arrives or the batch is flushed.
+			return nil
+		}
+	}
+	return err
+}
+
+// flush hands the collected records to the plugin. A RetryMessageError
+// leaves the records in place for the next attempt.
+func (b *outputBatcher) flush() (err error) {
+	if len(b.records) > 0 {
+		if err = b.plugin.ProcessBatch(b.records); err != nil {
+			switch err.(type) {
+			case RetryMessageError, PluginExitError:
+				return err
+			}
+			err = fmt.Errorf("dropped batch of %d records: %s", len(b.records), err)
+		} else {
+			var acks []batchedMsg
+			for _, msg := range b.msgs {
+				b.runner.latency.observe(msg.ingestTime)
+				if msg.ack {
+					acks = append(acks, msg)
+				}
+			}
+			if len(acks) > 0 {
+				// Each ack waits for a pack from the pool, and an output can
+				// match its own acks. Waiting here would deadlock once a batch
+				// needs more packs than the acks already routed to this output
+				// leave free.
+				go b.sendAcks(acks)
+			}
+		}
+		for i := range b.records {
+			b.records[i] = nil
+		}
+		b.records = b.records[:0]
+		b.msgs = b.msgs[:0]
+	}
+	// Sent or dropped, either way the records shouldn't be read again.
+	if b.cursor != "" {
+		b.runner.UpdateCursor(b.cursor)
+		b.cursor = ""
+	}
+	return err
+}
+
+// sendAcks injects the acks of the messages of a sent batch.
+func (b *outputBatcher) sendAcks(acks []batchedMsg) {
+	for _, msg := range acks {
+		b.runner.sendAck(msg.uuid, msg.msgLoopCount)
+	}
+}
+
+// timedFlush sends whatever has been collected when the flush interval
+// passes, logging any error.
+func (b *outputBatcher) timedFlush() {
+	if err := b.flush(); err != nil {
+		b.runner.LogError(err)
+	}
+}
+
+// stop sends the remaining records and stops the flush ticker.
+func (b *outputBatcher) stop() {
+	if b.ticker != nil {
+		b.ticker.Stop()
+		b.ticker = nil
+	}
+	b.timedFlush()
+}
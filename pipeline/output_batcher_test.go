@@ -0,0 +1,203 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"time"
+
+	ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/pborman/uuid"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+type _batchOutput struct {
+	batches []string
+	err     error
+}
+
+func (o *_batchOutput) ProcessBatch(records [][]byte) error {
+	if o.err != nil {
+		return o.err
+	}
+	var batch string
+	for _, record := range records {
+		batch += string(record) + ";"
+	}
+	o.batches = append(o.batches, batch)
+	return nil
+}
+
+func OutputBatcherSpec(c gs.Context) {
+	c.Specify("An output batcher", func() {
+		runner := &foRunner{encoder: new(_payloadEncoder)}
+		runner.name = "batchOutput"
+		output := new(_batchOutput)
+		b, err := newOutputBatcher(runner, output, 3, "0")
+		c.Assume(err, gs.IsNil)
+
+		pack := NewPipelinePack(nil)
+		pack.Message = ts.GetTestMessage()
+		send := func(payload string) error {
+			pack.Message.SetPayload(payload)
+			return b.ProcessMessage(pack)
+		}
+
+		c.Specify("sends a batch once it's full", func() {
+			c.Expect(send("a"), gs.IsNil)
+			c.Expect(send("b"), gs.IsNil)
+			c.Expect(len(output.batches), gs.Equals, 0)
+			c.Expect(send("c"), gs.IsNil)
+			c.Expect(len(output.batches), gs.Equals, 1)
+			c.Expect(output.batches[0], gs.Equals, "a;b;c;")
+			c.Expect(len(b.records), gs.Equals, 0)
+		})
+
+		c.Specify("sends a partial batch when flushed", func() {
+			send("a")
+			b.stop()
+			c.Expect(len(output.batches), gs.Equals, 1)
+			c.Expect(output.batches[0], gs.Equals, "a;")
+			b.stop()
+			c.Expect(len(output.batches), gs.Equals, 1)
+		})
+
+		c.Specify("retries the same batch before adding more", func() {
+			send("a")
+			send("b")
+			output.err = NewRetryMessageError("busy")
+			c.Expect(send("c"), gs.IsNil)
+			c.Expect(len(b.records), gs.Equals, 3)
+
+			// The next message is retried until the batch goes out, the
+			// runner hands over the same message again each time.
+			for i := 0; i < 2; i++ {
+				err := send("d")
+				_, ok := err.(RetryMessageError)
+				c.Expect(ok, gs.IsTrue)
+				c.Expect(len(b.records), gs.Equals, 3)
+			}
+
+			output.err = nil
+			c.Expect(send("d"), gs.IsNil)
+			c.Expect(len(output.batches), gs.Equals, 1)
+			c.Expect(output.batches[0], gs.Equals, "a;b;c;")
+			c.Expect(len(b.records), gs.Equals, 1)
+		})
+
+		c.Specify("retries a batch on a timed flush", func() {
+			send("a")
+			send("b")
+			output.err = NewRetryMessageError("busy")
+			send("c")
+			output.err = nil
+			b.timedFlush()
+			c.Expect(len(output.batches), gs.Equals, 1)
+			c.Expect(send("d"), gs.IsNil)
+			c.Expect(len(b.records), gs.Equals, 1)
+		})
+
+		c.Specify("acknowledges messages once the batch is sent", func() {
+			pConfig := NewPipelineConfig(nil)
+			runner.pConfig = pConfig
+			runner.h = pConfig
+			runner.config.AckOnSuccess = true
+			for i := 0; i < 3; i++ {
+				pConfig.injectRecycleChan <- NewPipelinePack(pConfig.injectRecycleChan)
+			}
+
+			uuids := make(map[string]bool)
+			for _, payload := range []string{"a", "b", "c"} {
+				pack.Message.SetUuid(uuid.NewRandom())
+				uuids[pack.Message.GetUuidString()] = true
+				c.Expect(send(payload), gs.IsNil)
+				if payload != "c" {
+					c.Expect(len(pConfig.injectRecycleChan), gs.Equals, 3)
+				}
+			}
+			c.Expect(len(output.batches), gs.Equals, 1)
+			for i := 0; i < 3; i++ {
+				ack := <-pConfig.router.inChan
+				uuid, _ := ack.Message.GetFieldValue("message_uuid")
+				c.Expect(uuids[uuid.(string)], gs.IsTrue)
+				delete(uuids, uuid.(string))
+			}
+		})
+
+		c.Specify("acknowledges a batch bigger than the pack pool", func() {
+			pConfig := NewPipelineConfig(nil)
+			runner.pConfig = pConfig
+			runner.h = pConfig
+			runner.config.AckOnSuccess = true
+			b, err = newOutputBatcher(runner, output, 5, "0")
+			c.Assume(err, gs.IsNil)
+			for i := 0; i < 2; i++ {
+				pConfig.injectRecycleChan <- NewPipelinePack(pConfig.injectRecycleChan)
+			}
+
+			// The batch goes out without waiting for the acks.
+			for _, payload := range []string{"a", "b", "c", "d", "e"} {
+				pack.Message.SetUuid(uuid.NewRandom())
+				c.Expect(send(payload), gs.IsNil)
+			}
+			c.Expect(len(output.batches), gs.Equals, 1)
+			// The acks trickle in as the ones delivered before are recycled,
+			// as if they were matched by the output itself.
+			for i := 0; i < 5; i++ {
+				ack := <-pConfig.router.inChan
+				c.Expect(isAck(ack.Message), gs.IsTrue)
+				ack.recycle()
+			}
+		})
+
+		c.Specify("records the latency once the batch is sent", func() {
+			runner.latency = newLatencyTracker()
+			pack.IngestTime = time.Now().UnixNano()
+			send("a")
+			send("b")
+			c.Expect(len(runner.latency.samples), gs.Equals, 0)
+			send("c")
+			c.Expect(len(runner.latency.samples), gs.Equals, 3)
+		})
+
+		c.Specify("doesn't acknowledge a dropped batch", func() {
+			pConfig := NewPipelineConfig(nil)
+			runner.pConfig = pConfig
+			runner.h = pConfig
+			runner.config.AckOnSuccess = true
+			pConfig.injectRecycleChan <- NewPipelinePack(pConfig.injectRecycleChan)
+
+			output.err = errors.New("bad request")
+			send("a")
+			send("b")
+			send("c")
+			c.Expect(len(pConfig.injectRecycleChan), gs.Equals, 1)
+		})
+
+		c.Specify("drops a batch on other errors", func() {
+			send("a")
+			send("b")
+			output.err = errors.New("bad request")
+			c.Expect(send("c"), gs.Not(gs.IsNil))
+			c.Expect(len(b.records), gs.Equals, 0)
+		})
+
+		c.Specify("has no flush channel without an interval", func() {
+			c.Expect(b.flushChan() == nil, gs.IsTrue)
+		})
+
+		c.Specify("rejects an invalid flush interval", func() {
+			_, err := newOutputBatcher(runner, output, 3, "soon")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
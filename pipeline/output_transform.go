@@ -0,0 +1,39 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+// OutputTransform is run by an OutputRunner on every pack immediately before
+// the pack is handed to the output's encoder. The pack is shared with every
+// other plugin that matched it, so a transform must not mutate the provided
+// pack's message in place. Instead it should return a pack holding the
+// message to be encoded, or nil if the message should not be encoded at all.
+type OutputTransform interface {
+	Transform(pack *PipelinePack) (*PipelinePack, error)
+	Stop()
+}
+
+// Constructor for OutputTransforms. Name is the full name of the transform,
+// and source is the transform code from the output's `transform` setting.
+type OutputTransformMaker func(name, source string, pConfig *PipelineConfig) (
+	OutputTransform, error)
+
+var availableTransforms = make(map[string]OutputTransformMaker)
+
+// Adds an OutputTransform implementation for the specified script type.
+// Registered by the sandbox plugins package, since the pipeline package can't
+// depend on the sandbox directly.
+func RegisterOutputTransform(scriptType string, maker OutputTransformMaker) {
+	availableTransforms[scriptType] = maker
+}
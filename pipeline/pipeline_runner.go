@@ -60,6 +60,24 @@ type GlobalConfigStruct struct {
 	abortChan             chan struct{}
 	FullBufferMaxRetries  uint
 	exitCode              int
+	MaxFields             int
+	MaxFieldBytes         int
+	Tap                   TapConfig
+	TrackLatency          bool
+	Version               string
+	// Hash of the loaded config, see HashConfig. Reload refreshes it while
+	// holding infoLock.
+	ConfigHash string
+	infoLock   sync.RWMutex
+	// How often a `heka.heartbeat` message is emitted, zero disables the
+	// heartbeat.
+	HeartbeatInterval time.Duration
+	// Config file or directory the plugins were loaded from. If set, SIGHUP
+	// reloads the plugin configuration from it, see PipelineConfig.Reload.
+	ConfigPath string
+	// Called once all of the inputs have been started, and so have bound
+	// their listeners, to switch hekad to an unprivileged user. Optional.
+	DropPrivileges func() error
 }
 
 // Creates a GlobalConfigStruct object populated w/ default values.
@@ -78,6 +96,7 @@ func DefaultGlobals() (globals *GlobalConfigStruct) {
 		sigChan:               make(chan os.Signal, 1),
 		Hostname:              hostname,
 		abortChan:             make(chan struct{}),
+		Tap:                   TapConfig{SampleRate: 1},
 	}
 }
 
@@ -181,6 +200,20 @@ type PipelinePack struct {
 	BufferedPack bool
 	// Used to send delivery result error back to the buffered plugin.
 	DelivErrChan chan error
+	// Holds the original signed stream framing header of the record from
+	// which the message was decoded, if the decoder was configured to
+	// preserve it. Is cleared whenever MsgBytes is re-encoded, since the
+	// signature will no longer match.
+	HeaderBytes []byte
+	// Time the message's input delivered it, in nanoseconds since the epoch,
+	// if the `track_latency` global is set. Zero for messages generated by
+	// filters.
+	IngestTime int64
+	// Named regular expression groups captured by the message matchers that
+	// matched the message, keyed by plugin name. The same pack is handed to
+	// every matching plugin, so access is guarded by capturesLock.
+	captures     map[string]map[string]string
+	capturesLock sync.Mutex
 }
 
 // Returns a new PipelinePack pointer that will recycle itself onto the
@@ -209,6 +242,9 @@ func (p *PipelinePack) Zero() {
 	p.Signer = ""
 	p.diagnostics.Reset()
 	p.TrustMsgBytes = false
+	p.HeaderBytes = p.HeaderBytes[:0]
+	p.IngestTime = 0
+	p.captures = nil
 	if p.BufferedPack {
 		p.QueueCursor = ""
 	}
@@ -238,6 +274,26 @@ func (p *PipelinePack) Recycle(delivErr error) {
 	}
 }
 
+// MatchCaptures returns the values of the named groups, e.g.
+// `(?P<ver>v\d+)`, captured by the regular expressions of the named plugin's
+// message_matcher when it matched the message, or nil if there are none.
+// Captures aren't available to plugins that use buffering, since they aren't
+// written to the queue buffer.
+func (p *PipelinePack) MatchCaptures(pluginName string) map[string]string {
+	p.capturesLock.Lock()
+	defer p.capturesLock.Unlock()
+	return p.captures[pluginName]
+}
+
+func (p *PipelinePack) setMatchCaptures(pluginName string, captures map[string]string) {
+	p.capturesLock.Lock()
+	if p.captures == nil {
+		p.captures = make(map[string]map[string]string)
+	}
+	p.captures[pluginName] = captures
+	p.capturesLock.Unlock()
+}
+
 // EncodeMsgBytes protobuf encodes the pack's message struct and copies the
 // result into the pack's MsgBytes attribute.
 func (p *PipelinePack) EncodeMsgBytes() error {
@@ -246,6 +302,7 @@ func (p *PipelinePack) EncodeMsgBytes() error {
 	}
 	msgBytes, err := proto.Marshal(p.Message)
 	if err == nil {
+		p.HeaderBytes = p.HeaderBytes[:0]
 		if cap(p.MsgBytes) < len(msgBytes) {
 			p.MsgBytes = msgBytes
 		} else {
@@ -263,16 +320,15 @@ func (p *PipelinePack) EncodeMsgBytes() error {
 func Run(config *PipelineConfig) (exitCode int) {
 	LogInfo.Println("Starting hekad...")
 
-	var outputsWg sync.WaitGroup
 	var err error
 
 	globals := config.Globals
 
 	for name, output := range config.OutputRunners {
-		outputsWg.Add(1)
-		if err = output.Start(config, &outputsWg); err != nil {
+		config.outputsWg.Add(1)
+		if err = output.Start(config, &config.outputsWg); err != nil {
 			LogError.Printf("Output '%s' failed to start: %s", name, err)
-			outputsWg.Done()
+			config.outputsWg.Done()
 			if !output.IsStoppable() {
 				globals.ShutDown(1)
 			}
@@ -332,6 +388,22 @@ func Run(config *PipelineConfig) (exitCode int) {
 		LogInfo.Println("Input started:", name)
 	}
 
+	if globals.DropPrivileges != nil {
+		if err = globals.DropPrivileges(); err != nil {
+			LogError.Printf("Can't drop privileges: %s", err)
+			globals.ShutDown(1)
+		} else {
+			LogInfo.Println("Dropped privileges.")
+		}
+	}
+
+	config.DaemonInfoMsg()
+
+	stopHeartbeat := make(chan struct{})
+	if globals.HeartbeatInterval > 0 {
+		go config.heartbeatLoop(globals.HeartbeatInterval, stopHeartbeat)
+	}
+
 	// wait for sigint
 	signal.Notify(globals.sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP,
 		SIGUSR1, SIGUSR2)
@@ -345,6 +417,13 @@ func Run(config *PipelineConfig) (exitCode int) {
 				if err := notify.Post(RELOAD, nil); err != nil {
 					LogError.Println("Error sending reload event: ", err)
 				}
+				if globals.ConfigPath != "" {
+					go func() {
+						if err := config.Reload(globals.ConfigPath); err != nil {
+							LogError.Println("Error reloading config: ", err)
+						}
+					}()
+				}
 			case syscall.SIGINT, syscall.SIGTERM:
 				LogInfo.Println("Shutdown initiated.")
 				globals.stop()
@@ -358,6 +437,13 @@ func Run(config *PipelineConfig) (exitCode int) {
 		}
 	}
 
+	close(stopHeartbeat)
+
+	// Let a running reload finish first, Reload gives up once it sees the
+	// shutdown, so no runners are added while they're being stopped.
+	config.reloadLock.Lock()
+	defer config.reloadLock.Unlock()
+
 	config.inputsLock.Lock()
 	for _, input := range config.InputRunners {
 		input.Input().Stop()
@@ -377,8 +463,15 @@ func Run(config *PipelineConfig) (exitCode int) {
 	config.decodersWg.Wait()
 	LogInfo.Println("Decoders shutdown complete")
 
-	config.filtersLock.Lock()
+	// The router channels are unbuffered, so the runners are copied out and
+	// their matchers removed without holding the locks.
+	config.filtersLock.RLock()
+	filters := make([]FilterRunner, 0, len(config.FilterRunners))
 	for _, filter := range config.FilterRunners {
+		filters = append(filters, filter)
+	}
+	config.filtersLock.RUnlock()
+	for _, filter := range filters {
 		// needed for a clean shutdown without deadlocking or orphaning messages
 		// 1. removes the matcher from the router
 		// 2. closes the matcher input channel and lets it drain
@@ -387,14 +480,19 @@ func Run(config *PipelineConfig) (exitCode int) {
 		config.router.RemoveFilterMatcher() <- filter.MatchRunner()
 		LogInfo.Printf("Stop message sent to filter '%s'", filter.Name())
 	}
-	config.filtersLock.Unlock()
 	config.filtersWg.Wait()
 
+	config.outputsLock.RLock()
+	outputs := make([]OutputRunner, 0, len(config.OutputRunners))
 	for _, output := range config.OutputRunners {
+		outputs = append(outputs, output)
+	}
+	config.outputsLock.RUnlock()
+	for _, output := range outputs {
 		config.router.RemoveOutputMatcher() <- output.MatchRunner()
 		LogInfo.Printf("Stop message sent to output '%s'", output.Name())
 	}
-	outputsWg.Wait()
+	config.outputsWg.Wait()
 
 	for name, encoder := range config.allEncoders {
 		if stopper, ok := encoder.(NeedsStopping); ok {
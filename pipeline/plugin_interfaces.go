@@ -85,6 +85,18 @@ type Encoder interface {
 	Encode(pack *PipelinePack) (output []byte, err error)
 }
 
+// Can be implemented by Encoders whose output needs a header, such as a CSV
+// column header row, at the start of each file or stream. By default such an
+// encoder prepends the header to the first record it encodes. Outputs that
+// know when they start a new file, such as the FileOutput, instead call
+// OutputWritesHeader and write the header at the start of each file.
+type HeaderEncoder interface {
+	// Returns the header, or nil if the encoder isn't configured to use one.
+	Header() []byte
+	// Tells the encoder that the output writes the header itself.
+	OutputWritesHeader()
+}
+
 // Can be implemented by Encoders to tell Heka that the Encoder needs to
 // perform some clean-up at shutdown time.
 type NeedsStopping interface {
@@ -333,6 +333,16 @@ func (m *pluginMaker) makeInputRunner(name string, config interface{}, input Inp
 			return nil, err
 		}
 	}
+	if commonInput.DecoderPoolSize == 0 {
+		poolSize := getAttr(config, "DecoderPoolSize", 1)
+		commonInput.DecoderPoolSize = poolSize.(int)
+	}
+	if commonInput.MaxFields == 0 {
+		commonInput.MaxFields = getAttr(config, "MaxFields", 0).(int)
+	}
+	if commonInput.MaxFieldBytes == 0 {
+		commonInput.MaxFieldBytes = getAttr(config, "MaxFieldBytes", 0).(int)
+	}
 	if commonInput.SendDecodeFailures == nil {
 		commonInput.SendDecodeFailures, err = getDefaultBool(config, "SendDecodeFailures")
 		if err != nil {
@@ -461,6 +471,13 @@ func (m *pluginMaker) MakeRunner(name string) (PluginRunner, error) {
 		}
 	}
 
+	if commonFO.MaxMsgLoops > 0 {
+		if m.category != "Filter" {
+			return nil, errors.New("'max_message_loops' is only supported by filters")
+		}
+		m.pConfig.addMsgLoopsOverride(commonFO.MaxMsgLoops)
+	}
+
 	return NewFORunner(name, plugin, commonFO, m.commonConfig.Typ,
 		m.pConfig.Globals.PluginChanSize)
 }
@@ -24,7 +24,9 @@ import (
 	"time"
 
 	"github.com/mozilla-services/heka/client"
+	"github.com/mozilla-services/heka/compression"
 	"github.com/mozilla-services/heka/message"
+	"github.com/pborman/uuid"
 )
 
 var ErrUnknownPluginType = errors.New("Unable to assert this is an Output or Filter")
@@ -67,6 +69,8 @@ type pRunnerBase struct {
 	h         PluginHelper
 	leakCount int
 	maker     PluginMaker
+	// Closed once the runner's goroutine has exited.
+	done chan struct{}
 }
 
 func (pr *pRunnerBase) Name() string {
@@ -89,6 +93,16 @@ func (pr *pRunnerBase) LeakCount() int {
 	return pr.leakCount
 }
 
+// Runs the starter in a new goroutine, closing the done channel when it
+// returns.
+func (pr *pRunnerBase) goStarter(starter func()) {
+	pr.done = make(chan struct{})
+	go func() {
+		defer close(pr.done)
+		starter()
+	}()
+}
+
 // AddDecodeFailureFields adds two fields to the provided message object. The
 // first field is a boolean field called `decode_failure`, set to true. The
 // second is a string field called `decode_error` which will contain the
@@ -112,6 +126,63 @@ func AddDecodeFailureFields(m *message.Message, errMsg string) error {
 	return nil
 }
 
+// reloadedOutput returns the runner currently registered under the output's
+// name, which replaces the given one when a config reload restarts the output.
+// References to another output, such as `fallback_output` and
+// `decode_failure_output`, go through it before every hand-off.
+func reloadedOutput(output OutputRunner) OutputRunner {
+	if r, ok := output.(*foRunner); ok && r.pConfig != nil {
+		if current, ok := r.pConfig.Output(r.name); ok {
+			return current
+		}
+	}
+	return output
+}
+
+// deliverDecodeFailure hands a pack that failed decoding straight to an
+// input's `decode_failure_output`, bypassing the output's message matcher. The
+// pack is tagged with the decode failure fields, and raw record bytes that
+// the splitter put in MsgBytes are kept in a `raw_message` field.
+func deliverDecodeFailure(output OutputRunner, pack *PipelinePack, name,
+	errMsg string) error {
+
+	output = reloadedOutput(output)
+	matcher := output.MatchRunner()
+	if matcher == nil || atomic.LoadInt32(&matcher.closing) != 0 {
+		pack.recycle()
+		return fmt.Errorf("decode_failure_output '%s' isn't running", output.Name())
+	}
+	msg := pack.Message
+	if len(pack.MsgBytes) > 0 && !pack.TrustMsgBytes {
+		raw := make([]byte, len(pack.MsgBytes))
+		copy(raw, pack.MsgBytes)
+		if f, err := message.NewField("raw_message", raw, ""); err == nil {
+			msg.AddField(f)
+		}
+	}
+	// A failed protobuf decode can leave us without the required headers.
+	if len(msg.GetUuid()) == 0 {
+		msg.SetUuid(uuid.NewRandom())
+	}
+	if msg.Timestamp == nil {
+		msg.SetTimestamp(time.Now().UnixNano())
+	}
+	if msg.GetLogger() == "" {
+		msg.SetLogger(name)
+	}
+	if err := AddDecodeFailureFields(msg, errMsg); err != nil {
+		pack.recycle()
+		return err
+	}
+	pack.TrustMsgBytes = false
+	if err := pack.EncodeMsgBytes(); err != nil {
+		pack.recycle()
+		return fmt.Errorf("encoding message: %s", err)
+	}
+	pack.diagnostics.AddStamp(output)
+	return matcher.deliver(pack)
+}
+
 type DeliverFunc func(pack *PipelinePack)
 
 type Deliverer interface {
@@ -121,10 +192,10 @@ type Deliverer interface {
 }
 
 type deliverer struct {
-	deliver DeliverFunc
-	dRunner DecoderRunner
-	decoder Decoder
-	pConfig *PipelineConfig
+	deliver  DeliverFunc
+	dRunners []DecoderRunner
+	decoders []Decoder
+	pConfig  *PipelineConfig
 }
 
 func (d *deliverer) Deliver(pack *PipelinePack) {
@@ -136,14 +207,14 @@ func (d *deliverer) DeliverFunc() DeliverFunc {
 }
 
 func (d *deliverer) Done() {
-	if d.dRunner != nil {
-		d.pConfig.StopDecoderRunner(d.dRunner)
+	for _, dRunner := range d.dRunners {
+		d.pConfig.StopDecoderRunner(dRunner)
 	}
 
-	if d.decoder != nil {
+	for _, decoder := range d.decoders {
 		d.pConfig.allSyncDecodersLock.Lock()
 		for i, reporting := range d.pConfig.allSyncDecoders {
-			if d.decoder == reporting.decoder {
+			if decoder == reporting.decoder {
 				d.pConfig.allSyncDecoders = append(d.pConfig.allSyncDecoders[:i], d.pConfig.allSyncDecoders[i+1:]...)
 				break
 			}
@@ -206,6 +277,7 @@ type iRunner struct {
 	ticker             <-chan time.Time
 	transient          bool
 	syncDecode         bool
+	decoderPoolSize    int
 	sendDecodeFailures bool
 	logDecodeFailures  bool
 	deliver            DeliverFunc
@@ -214,6 +286,10 @@ type iRunner struct {
 	canExit            bool
 	shutdownWanters    []WantsDecoderRunnerShutdown
 	shutdownLock       sync.Mutex
+	fieldLimits        *fieldLimits
+	requireMatcher     *requireMatcher
+	checkpoint         *inputCheckpoint
+	failureOutput      OutputRunner
 }
 
 func (ir *iRunner) Ticker() (ticker <-chan time.Time) {
@@ -248,6 +324,10 @@ func NewInputRunner(name string, input Input, config CommonInputConfig) (ir Inpu
 	if config.SyncDecode != nil {
 		runner.syncDecode = *config.SyncDecode
 	}
+	runner.decoderPoolSize = config.DecoderPoolSize
+	if runner.decoderPoolSize < 1 {
+		runner.decoderPoolSize = 1
+	}
 	if config.SendDecodeFailures != nil {
 		runner.sendDecodeFailures = *config.SendDecodeFailures
 	}
@@ -257,6 +337,7 @@ func NewInputRunner(name string, input Input, config CommonInputConfig) (ir Inpu
 	if config.CanExit != nil && *config.CanExit {
 		runner.canExit = true
 	}
+	runner.checkpoint = newInputCheckpoint(config.CheckpointInterval)
 
 	return runner
 }
@@ -283,6 +364,34 @@ func (ir *iRunner) Start(h PluginHelper, wg *sync.WaitGroup) (err error) {
 		ir.config.Splitter = "NullSplitter"
 	}
 
+	var ok bool
+
+	// Unset limits fall back to the global ones.
+	maxFields, maxFieldBytes := ir.config.MaxFields, ir.config.MaxFieldBytes
+	if maxFields == 0 {
+		maxFields = ir.pConfig.Globals.MaxFields
+	}
+	if maxFieldBytes == 0 {
+		maxFieldBytes = ir.pConfig.Globals.MaxFieldBytes
+	}
+	ir.fieldLimits = newFieldLimits(maxFields, maxFieldBytes)
+
+	if ir.config.RequireMatcher != "" && ir.config.Decoder == "" {
+		return fmt.Errorf("%s require_matcher needs a decoder", ir.name)
+	}
+	if ir.requireMatcher, err = newRequireMatcher(ir.config.RequireMatcher); err != nil {
+		return fmt.Errorf("%s invalid require_matcher: %s", ir.name, err)
+	}
+
+	if name := ir.config.DecodeFailureOutput; name != "" {
+		if ir.config.Decoder == "" {
+			return fmt.Errorf("%s decode_failure_output needs a decoder", ir.name)
+		}
+		if ir.failureOutput, ok = ir.pConfig.Output(name); !ok {
+			return fmt.Errorf("%s unknown decode_failure_output '%s'", ir.name, name)
+		}
+	}
+
 	ir.pConfig.makersLock.RLock()
 	splitters := ir.pConfig.makers["Splitter"]
 	splitterMaker, ok := splitters[ir.config.Splitter]
@@ -302,13 +411,19 @@ func (ir *iRunner) Start(h PluginHelper, wg *sync.WaitGroup) (err error) {
 			return fmt.Errorf("no registered '%s' decoder", ir.config.Decoder)
 		}
 	}
-	go ir.Starter(h, wg)
+	ir.goStarter(func() { ir.Starter(h, wg) })
 	return
 }
 
 func (ir *iRunner) Starter(h PluginHelper, wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	if ir.checkpoint != nil {
+		stopCheckpoints := make(chan struct{})
+		go ir.checkpoint.run(ir, stopCheckpoints)
+		defer close(stopCheckpoints)
+	}
+
 	globals := ir.pConfig.Globals
 	rh, err := NewRetryHelper(ir.config.Retries)
 	if err != nil {
@@ -323,7 +438,9 @@ func (ir *iRunner) Starter(h PluginHelper, wg *sync.WaitGroup) {
 
 		// ir.Input().Run() shouldn't return unless error or shutdown.
 		err := ir.input.Run(ir, h)
+		ir.pConfig.inputsLock.RLock()
 		registered, ok := ir.pConfig.InputRunners[ir.name]
+		ir.pConfig.inputsLock.RUnlock()
 
 		if !ok || registered != ir || globals.IsShuttingDown() {
 			// Plugin was removed deliberately from the list of InputRunners or
@@ -403,6 +520,7 @@ func (ir *iRunner) Unregister(pConfig *PipelineConfig) error {
 }
 
 func (ir *iRunner) Inject(pack *PipelinePack) error {
+	ir.fieldLimits.apply(pack)
 	if err := pack.EncodeMsgBytes(); err != nil {
 		err = fmt.Errorf("encoding message: %s", err.Error())
 		ir.LogError(err)
@@ -420,7 +538,7 @@ func (ir *iRunner) LogMessage(msg string) {
 	LogInfo.Printf("Input '%s': %s", ir.name, msg)
 }
 
-func (ir *iRunner) getDeliverFunc(token string) (DeliverFunc, DecoderRunner, Decoder) {
+func (ir *iRunner) getDeliverFunc(token string) (DeliverFunc, []DecoderRunner, []Decoder) {
 	var deliver DeliverFunc
 	decoderName := ir.config.Decoder
 	// If no decoder is specified we just inject into the router.
@@ -449,17 +567,71 @@ func (ir *iRunner) getDeliverFunc(token string) (DeliverFunc, DecoderRunner, Dec
 	// No synchronous decode means create a DecoderRunner and drop packs on
 	// its inChan.
 	if !ir.syncDecode {
-		dr, _ := ir.pConfig.DecoderRunner(decoderName, fullName)
-		dr.SetFailureHandling(ir.logDecodeFailures, ir.sendDecodeFailures)
-		inChan := dr.InChan()
+		if ir.decoderPoolSize == 1 {
+			dr, _ := ir.pConfig.DecoderRunner(decoderName, fullName)
+			dr.SetFailureHandling(ir.logDecodeFailures, ir.sendDecodeFailures)
+			ir.setDecoderChecks(dr)
+			inChan := dr.InChan()
+			deliver = func(pack *PipelinePack) {
+				inChan <- pack
+			}
+			return deliver, []DecoderRunner{dr}, nil
+		}
+
+		// With a pool we spin up a DecoderRunner for each worker and hand
+		// the packs out round robin. Delivery still blocks when the chosen
+		// DecoderRunner's inChan is full, but consecutive packs are decoded
+		// in parallel, so even the messages of a single stream may reach the
+		// router out of order, as the decoder_pool_size docs point out.
+		dRunners := make([]DecoderRunner, ir.decoderPoolSize)
+		inChans := make([]chan *PipelinePack, ir.decoderPoolSize)
+		for i := range dRunners {
+			dr, _ := ir.pConfig.DecoderRunner(decoderName,
+				fmt.Sprintf("%s-%d", fullName, i))
+			dr.SetFailureHandling(ir.logDecodeFailures, ir.sendDecodeFailures)
+			ir.setDecoderChecks(dr)
+			dRunners[i] = dr
+			inChans[i] = dr.InChan()
+		}
+		var next uint32
+		poolSize := uint32(ir.decoderPoolSize)
 		deliver = func(pack *PipelinePack) {
-			inChan <- pack
+			i := (atomic.AddUint32(&next, 1) - 1) % poolSize
+			inChans[i] <- pack
 		}
-		return deliver, dr, nil
+		return deliver, dRunners, nil
 	}
 
 	// Synchronous decode means create a decoder instance and call Decode
 	// directly.
+	if ir.decoderPoolSize == 1 {
+		decoder, decode := ir.syncDecoder(decoderName, fullName)
+		return decode, nil, []Decoder{decoder}
+	}
+
+	// With a pool each call borrows whichever decoder is free, so that
+	// goroutines sharing the deliverer decode in parallel. Packs delivered
+	// by the same goroutine are still decoded and injected in order.
+	decoders := make([]Decoder, ir.decoderPoolSize)
+	free := make(chan DeliverFunc, ir.decoderPoolSize)
+	for i := range decoders {
+		var decode DeliverFunc
+		decoders[i], decode = ir.syncDecoder(decoderName,
+			fmt.Sprintf("%s-%d", fullName, i))
+		free <- decode
+	}
+	deliver = func(pack *PipelinePack) {
+		decode := <-free
+		decode(pack)
+		free <- decode
+	}
+	return deliver, nil, decoders
+}
+
+// syncDecoder creates a decoder instance for synchronous decoding, and
+// returns it along with the function that decodes a pack with it and injects
+// the results.
+func (ir *iRunner) syncDecoder(decoderName, fullName string) (Decoder, DeliverFunc) {
 	decoder, _ := ir.pConfig.Decoder(decoderName)
 	if wanter, ok := decoder.(WantsDecoderRunner); ok {
 		dr := NewDecoderRunner(fullName, decoder, 0).(*dRunner)
@@ -481,42 +653,80 @@ func (ir *iRunner) getDeliverFunc(token string) (DeliverFunc, DecoderRunner, Dec
 	ir.pConfig.allSyncDecodersLock.Unlock()
 	// See if the decoder sets TrustMsgBytes for us.
 	_, trustMsgBytes := decoder.(EncodesMsgBytes)
-	deliver = func(pack *PipelinePack) {
-		packs, err := decoder.Decode(pack)
-		if err != nil {
-			errMsg := err.Error()
-			e := fmt.Errorf("decoding: %s", errMsg)
-			if ir.logDecodeFailures {
-				ir.LogError(e)
-			}
-			if !ir.sendDecodeFailures {
-				pack.recycle()
-				return
-			}
-			if err = AddDecodeFailureFields(pack.Message, errMsg); err != nil {
+	failed := func(pack *PipelinePack, err error) {
+		errMsg := err.Error()
+		e := fmt.Errorf("decoding: %s", errMsg)
+		if ir.logDecodeFailures {
+			ir.LogError(e)
+		}
+		if ir.failureOutput != nil {
+			if err = deliverDecodeFailure(ir.failureOutput, pack, ir.name,
+				errMsg); err != nil {
 				ir.LogError(err)
 			}
-			pack.TrustMsgBytes = false
-			ir.Inject(pack)
 			return
 		}
+		if !ir.sendDecodeFailures {
+			pack.recycle()
+			return
+		}
+		if err = AddDecodeFailureFields(pack.Message, errMsg); err != nil {
+			ir.LogError(err)
+		}
+		pack.TrustMsgBytes = false
+		ir.Inject(pack)
+	}
+	decode := func(pack *PipelinePack) {
+		ingestTime := pack.IngestTime
+		packs, err := decoder.Decode(pack)
+		if err != nil {
+			failed(pack, err)
+			return
+		}
+		propagateIngest(ingestTime, packs)
 		for _, p := range packs {
+			if err = ir.requireMatcher.check(p); err != nil {
+				failed(p, err)
+				continue
+			}
 			if !trustMsgBytes {
 				p.TrustMsgBytes = false
 			}
 			ir.Inject(p)
 		}
 	}
-	return deliver, nil, decoder
+	return decoder, decode
+}
+
+// setDecoderChecks shares the input's field limits, require_matcher and
+// decode_failure_output with a DecoderRunner so they're enforced on the
+// decoded messages.
+func (ir *iRunner) setDecoderChecks(dr DecoderRunner) {
+	if d, ok := dr.(*dRunner); ok {
+		d.fieldLimits = ir.fieldLimits
+		d.requireMatcher = ir.requireMatcher
+		d.failureOutput = ir.failureOutput
+		d.inputName = ir.name
+	}
+}
+
+// wrapDeliver adds the input's checkpoint counting and, if latency tracking
+// is on, the ingest time stamping to a DeliverFunc.
+func (ir *iRunner) wrapDeliver(deliver DeliverFunc) DeliverFunc {
+	if ir.pConfig.Globals.TrackLatency {
+		deliver = stampIngest(deliver)
+	}
+	return ir.checkpoint.wrap(deliver)
 }
 
 func (ir *iRunner) NewDeliverer(token string) Deliverer {
-	deliver, dRunner, decoder := ir.getDeliverFunc(token)
+	deliver, dRunners, decoders := ir.getDeliverFunc(token)
+	deliver = ir.wrapDeliver(deliver)
 	d := &deliverer{
-		deliver: deliver,
-		dRunner: dRunner,
-		decoder: decoder,
-		pConfig: ir.pConfig,
+		deliver:  deliver,
+		dRunners: dRunners,
+		decoders: decoders,
+		pConfig:  ir.pConfig,
 	}
 	return d
 }
@@ -527,7 +737,8 @@ func (ir *iRunner) Deliver(pack *PipelinePack) {
 		// first `getDeliverFunc` call has returned.
 		ir.delivererLock.Lock()
 		ir.delivererOnce.Do(func() {
-			ir.deliver, _, _ = ir.getDeliverFunc("")
+			deliver, _, _ := ir.getDeliverFunc("")
+			ir.deliver = ir.wrapDeliver(deliver)
 		})
 		ir.delivererLock.Unlock()
 	}
@@ -586,14 +797,18 @@ type DecoderRunner interface {
 
 type dRunner struct {
 	pRunnerBase
-	decoder      Decoder
-	inChan       chan *PipelinePack
-	router       *messageRouter
-	h            PluginHelper
-	printFailure bool
-	sendFailure  bool
-	encodes      bool
-	globals      *GlobalConfigStruct
+	decoder        Decoder
+	inChan         chan *PipelinePack
+	router         *messageRouter
+	h              PluginHelper
+	printFailure   bool
+	sendFailure    bool
+	encodes        bool
+	globals        *GlobalConfigStruct
+	fieldLimits    *fieldLimits
+	requireMatcher *requireMatcher
+	failureOutput  OutputRunner
+	inputName      string
 }
 
 // Creates and returns a new (but not yet started) DecoderRunner for the
@@ -633,23 +848,20 @@ func (dr *dRunner) start(h PluginHelper, wg *sync.WaitGroup) {
 		err   error
 	)
 	for pack = range dr.inChan {
+		ingestTime := pack.IngestTime
 		if packs, err = dr.decoder.Decode(pack); packs != nil {
+			propagateIngest(ingestTime, packs)
 			for _, p := range packs {
+				if err = dr.requireMatcher.check(p); err != nil {
+					dr.decodeFailed(p, err)
+					continue
+				}
 				dr.deliver(p)
 			}
 		} else {
 			if err != nil {
-				if dr.printFailure {
-					dr.LogError(err)
-				}
-				if dr.sendFailure {
-					if err = AddDecodeFailureFields(pack.Message, err.Error()); err != nil {
-						dr.LogError(err)
-					}
-					pack.TrustMsgBytes = false
-					dr.deliver(pack)
-					continue
-				}
+				dr.decodeFailed(pack, err)
+				continue
 			}
 			pack.recycle()
 			continue
@@ -662,7 +874,33 @@ func (dr *dRunner) start(h PluginHelper, wg *sync.WaitGroup) {
 	wg.Done()
 }
 
+// Handles a pack that failed decoding, or the require_matcher check, as the
+// input's failure settings say: log it, hand it to the decode failure output,
+// send it on tagged with the failure, or drop it.
+func (dr *dRunner) decodeFailed(pack *PipelinePack, err error) {
+	if dr.printFailure {
+		dr.LogError(err)
+	}
+	if dr.failureOutput != nil {
+		if err = deliverDecodeFailure(dr.failureOutput, pack, dr.inputName,
+			err.Error()); err != nil {
+			dr.LogError(err)
+		}
+		return
+	}
+	if !dr.sendFailure {
+		pack.recycle()
+		return
+	}
+	if err = AddDecodeFailureFields(pack.Message, err.Error()); err != nil {
+		dr.LogError(err)
+	}
+	pack.TrustMsgBytes = false
+	dr.deliver(pack)
+}
+
 func (dr *dRunner) deliver(pack *PipelinePack) {
+	dr.fieldLimits.apply(pack)
 	if !dr.encodes || !pack.TrustMsgBytes {
 		err := pack.EncodeMsgBytes()
 		if err != nil {
@@ -793,7 +1031,8 @@ type OutputRunner interface {
 	// instance.
 	Encoder() Encoder
 	// Uses the output's Encoder to encode the message attached to the
-	// provided PipelinePack. Will prepend a Heka stream framing header if
+	// provided PipelinePack, after applying the output's transform if one
+	// was configured. Will prepend a Heka stream framing header if
 	// use_framing was set to true in the output configuration.
 	Encode(pack *PipelinePack) (output []byte, err error)
 	// Returns whether or not use_framing was set to true in the output's
@@ -837,6 +1076,7 @@ func (kind foRunnerKind) String() string {
 type foRunner struct {
 	processMessageCount int64
 	dropMessageCount    int64
+	staleMessageCount   int64
 	capacity            int
 	pRunnerBase
 	pluginType   string
@@ -848,8 +1088,10 @@ type foRunner struct {
 	h            PluginHelper
 	retainPack   *PipelinePack
 	leakCount    int
-	encoder      Encoder // output only
-	useFraming   bool    // output only
+	encoder      Encoder         // output only
+	transform    OutputTransform // output only
+	useFraming   bool            // output only
+	relaysHeader bool            // output only
 	canExit      bool
 	useBuffering bool
 	kind         foRunnerKind
@@ -857,6 +1099,13 @@ type foRunner struct {
 	lastErr      error
 	bufReader    *BufferReader
 	stopChan     chan bool
+	fallback     OutputRunner   // output only
+	batcher      *outputBatcher // output only
+	breaker      *circuitBreaker
+	maxMsgAge    time.Duration
+	latency      *latencyTracker // output only
+	// Set when the runner is stopped by a config reload, accessed atomically.
+	unloaded int32
 }
 
 const pluginPoolSize = 2
@@ -905,6 +1154,10 @@ func NewFORunner(name string, plugin Plugin, config CommonFOConfig,
 			msg := "buffer full_action must be 'shutdown', 'drop', or 'block', got '%s'"
 			return nil, fmt.Errorf(msg, config.Buffering.FullAction)
 		}
+		if err := compression.Validate(config.Buffering.Compression,
+			config.Buffering.CompressionLevel); err != nil {
+			return nil, fmt.Errorf("buffer compression: %s", err)
+		}
 		runner.capacity = int(config.Buffering.MaxBufferSize) * 90 / 100
 	}
 
@@ -953,6 +1206,63 @@ func NewFORunner(name string, plugin Plugin, config CommonFOConfig,
 		return nil, err
 	}
 
+	if config.CircuitBreaker != nil {
+		if _, ok := plugin.(MessageProcessor); !ok || runner.useBuffering {
+			return nil, fmt.Errorf(
+				"'%s' circuit_breaker requires an unbuffered ProcessMessage plugin", name)
+		}
+		if runner.breaker, err = newCircuitBreaker(config.CircuitBreaker); err != nil {
+			return nil, fmt.Errorf("'%s': %s", name, err)
+		}
+	}
+
+	if config.MaxMessageAge != "" {
+		// Messages are checked as they're read from the buffer, or by the
+		// output's channelLoop otherwise.
+		_, ok := plugin.(MessageProcessor)
+		if !runner.useBuffering && (runner.kind != foOutput || !ok) {
+			return nil, fmt.Errorf(
+				"'%s' max_message_age requires use_buffering or a ProcessMessage output",
+				name)
+		}
+		if runner.maxMsgAge, err = time.ParseDuration(config.MaxMessageAge); err != nil {
+			return nil, fmt.Errorf("'%s' invalid max_message_age: %s", name, err)
+		}
+		if runner.maxMsgAge < 0 {
+			return nil, fmt.Errorf("'%s' max_message_age can't be negative", name)
+		}
+	}
+
+	if config.BatchSize > 0 {
+		bp, ok := plugin.(BatchProcessor)
+		if _, isOutput := plugin.(Output); !ok || !isOutput {
+			return nil, fmt.Errorf("'%s' can't support a batch_size setting", name)
+		}
+		if config.Encoder == "" {
+			return nil, fmt.Errorf("'%s' batch_size requires an encoder", name)
+		}
+		runner.batcher, err = newOutputBatcher(runner, bp, config.BatchSize,
+			config.BatchFlushInterval)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %s", name, err)
+		}
+	}
+
+	if config.AckOnSuccess {
+		_, ok := plugin.(MessageProcessor)
+		if runner.kind != foOutput || !ok {
+			return nil, fmt.Errorf("'%s' can't support an ack_on_success setting", name)
+		}
+		// A successful ProcessMessage call only means the message was queued.
+		// The runner's own batcher acknowledges messages once they're sent.
+		deferred, ok := plugin.(DefersDelivery)
+		if ok && deferred.DefersDelivery() {
+			return nil, fmt.Errorf(
+				"'%s' sends messages in batches and can't support ack_on_success",
+				name)
+		}
+	}
+
 	return runner, nil
 }
 
@@ -1026,6 +1336,44 @@ func (foRunner *foRunner) Start(h PluginHelper, wg *sync.WaitGroup) (err error)
 				foRunner.config.Encoder)
 		}
 		foRunner.encoder = encoder
+		// Only the ProtobufEncoder emits the MsgBytes unchanged, so it's the
+		// only one that can relay a preserved signed header.
+		_, foRunner.relaysHeader = encoder.(*ProtobufEncoder)
+	}
+
+	if foRunner.config.Transform != "" {
+		if foRunner.kind != foOutput {
+			return fmt.Errorf("%s: transform is only supported by outputs",
+				foRunner.name)
+		}
+		maker, ok := availableTransforms["lua"]
+		if !ok {
+			return fmt.Errorf("%s can't create transform: sandbox support not available",
+				foRunner.name)
+		}
+		fullName := fmt.Sprintf("%s-transform", foRunner.name)
+		if foRunner.transform, err = maker(fullName, foRunner.config.Transform,
+			foRunner.pConfig); err != nil {
+			return fmt.Errorf("%s can't create transform: %s", foRunner.name, err)
+		}
+	}
+
+	if foRunner.config.FallbackOutput != "" {
+		if err = foRunner.setFallback(); err != nil {
+			return err
+		}
+	}
+
+	var chunker *messageChunker
+	if foRunner.config.SplitSize > 0 {
+		if foRunner.kind != foOutput {
+			return fmt.Errorf("%s: split_size is only supported by outputs",
+				foRunner.name)
+		}
+		chunker = newMessageChunker(foRunner.config.SplitSize,
+			foRunner.config.SplitField, chunkPackPoolSize)
+	} else if foRunner.config.SplitField != "" {
+		return fmt.Errorf("%s: split_field requires split_size", foRunner.name)
 	}
 
 	var bufFeeder *BufferFeeder
@@ -1038,9 +1386,13 @@ func (foRunner *foRunner) Start(h PluginHelper, wg *sync.WaitGroup) (err error)
 	}
 
 	foRunner.stopChan = make(chan bool)
+	if foRunner.kind == foOutput && foRunner.pConfig.Globals.TrackLatency {
+		foRunner.latency = newLatencyTracker()
+	}
 
 	if foRunner.matcher != nil {
 		foRunner.matcher.bufFeeder = bufFeeder
+		foRunner.matcher.chunker = chunker
 		foRunner.matcher.globals = foRunner.pConfig.Globals
 		foRunner.matcher.stopChan = foRunner.stopChan
 		switch foRunner.kind {
@@ -1068,9 +1420,9 @@ func (foRunner *foRunner) Start(h PluginHelper, wg *sync.WaitGroup) (err error)
 		if !ok {
 			return errors.New("Not a new-style plugin.")
 		}
-		go foRunner.Starter(plugin, h, wg)
+		foRunner.goStarter(func() { foRunner.Starter(plugin, h, wg) })
 	} else {
-		go foRunner.OldStarter(h, wg)
+		foRunner.goStarter(func() { foRunner.OldStarter(h, wg) })
 	}
 
 	if foRunner.useBuffering && foRunner.BackPressured() {
@@ -1083,6 +1435,71 @@ func (foRunner *foRunner) Start(h PluginHelper, wg *sync.WaitGroup) (err error)
 	return
 }
 
+// setFallback looks up the output that messages this output declines will be
+// handed to.
+func (foRunner *foRunner) setFallback() error {
+	name := foRunner.config.FallbackOutput
+	if foRunner.kind != foOutput {
+		return fmt.Errorf("%s: fallback_output is only supported by outputs",
+			foRunner.name)
+	}
+	if name == foRunner.name {
+		return fmt.Errorf("%s: fallback_output can't refer to itself", foRunner.name)
+	}
+	// Old-style outputs only report delivery errors back to the runner when
+	// they're buffered.
+	if _, ok := foRunner.plugin.(Output); !ok && !foRunner.useBuffering {
+		return fmt.Errorf("%s: fallback_output requires use_buffering for this output",
+			foRunner.name)
+	}
+	oRunner, ok := foRunner.pConfig.Output(name)
+	if !ok {
+		return fmt.Errorf("%s: unknown fallback_output '%s'", foRunner.name, name)
+	}
+	foRunner.fallback = oRunner
+	return nil
+}
+
+// offerFallback hands a copy of a message that this output failed to deliver
+// to the fallback output, if there is one, bypassing the fallback's message
+// matcher. Returns true if the message was handed off. The
+// original pack still belongs to the caller.
+func (foRunner *foRunner) offerFallback(pack *PipelinePack) bool {
+	if foRunner.fallback == nil {
+		return false
+	}
+	fallback := reloadedOutput(foRunner.fallback)
+	matcher := fallback.MatchRunner()
+	if matcher == nil || atomic.LoadInt32(&matcher.closing) != 0 {
+		return false
+	}
+	fbPack, err := foRunner.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		foRunner.LogError(fmt.Errorf("can't hand message to fallback output '%s': %s",
+			fallback.Name(), err))
+		return false
+	}
+	pack.Message.Copy(fbPack.Message)
+	// Buffered and protobuf encoding fallbacks use the message bytes.
+	if pack.TrustMsgBytes {
+		fbPack.MsgBytes = append(fbPack.MsgBytes[:0], pack.MsgBytes...)
+		fbPack.TrustMsgBytes = true
+	} else if err = fbPack.EncodeMsgBytes(); err != nil {
+		fbPack.recycle()
+		foRunner.LogError(fmt.Errorf("can't hand message to fallback output '%s': %s",
+			fallback.Name(), err))
+		return false
+	}
+	fbPack.Signer = pack.Signer
+	fbPack.diagnostics.AddStamp(fallback)
+	if err = matcher.deliver(fbPack); err != nil {
+		foRunner.LogError(fmt.Errorf("can't hand message to fallback output '%s': %s",
+			fallback.Name(), err))
+		return false
+	}
+	return true
+}
+
 // bufferLoop is invoked for plugins that support the newer API when buffering
 // is turned on.
 func (foRunner *foRunner) bufferLoop(plugin MessageProcessor, h PluginHelper,
@@ -1107,6 +1524,11 @@ func (foRunner *foRunner) channelLoop(plugin MessageProcessor, h PluginHelper,
 		MaxRetries: -1,
 	})
 
+	if foRunner.batcher != nil {
+		defer foRunner.batcher.stop()
+	}
+	flushChan := foRunner.batcher.flushChan()
+
 	resetNeeded := false
 	ok := true
 	var pack *PipelinePack
@@ -1119,10 +1541,23 @@ func (foRunner *foRunner) channelLoop(plugin MessageProcessor, h PluginHelper,
 			if !ok {
 				break
 			}
+			if foRunner.isStale(pack) {
+				pack.recycle()
+				break
+			}
 		RetryLoop:
 			for !foRunner.pConfig.Globals.IsShuttingDown() {
+				if !foRunner.breakerAllows() {
+					if !foRunner.offerFallback(pack) {
+						atomic.AddInt64(&foRunner.dropMessageCount, 1)
+					}
+					pack.recycle()
+					break RetryLoop
+				}
 				err := plugin.ProcessMessage(pack)
 				if err == nil {
+					foRunner.breakerSuccess()
+					foRunner.delivered(pack)
 					pack.recycle()
 					break RetryLoop // Bumps us back to the outer loop.
 				}
@@ -1132,11 +1567,16 @@ func (foRunner *foRunner) channelLoop(plugin MessageProcessor, h PluginHelper,
 					return err
 				case RetryMessageError:
 					foRunner.LogError(err)
+					if foRunner.breakerFailure() {
+						continue // Drop it without waiting.
+					}
 					rh.Wait()
 					resetNeeded = true
 					continue // Try the same one again.
 				default:
 					foRunner.LogError(err)
+					foRunner.breakerFailure()
+					foRunner.offerFallback(pack)
 					pack.recycle()
 					break RetryLoop
 				}
@@ -1154,12 +1594,57 @@ func (foRunner *foRunner) channelLoop(plugin MessageProcessor, h PluginHelper,
 					return err
 				}
 			}
+		case <-flushChan:
+			foRunner.batcher.timedFlush()
 		}
 	}
 
 	return nil
 }
 
+// isStale returns whether the pack's message is older than the plugin's
+// `max_message_age`, counting it as stale if so. The message timestamp is
+// used rather than the time Heka received it, so data replayed from a buffer
+// or sent late by a client is judged by when it was generated.
+func (foRunner *foRunner) isStale(pack *PipelinePack) bool {
+	if foRunner.maxMsgAge == 0 ||
+		time.Since(time.Unix(0, pack.Message.GetTimestamp())) <= foRunner.maxMsgAge {
+		return false
+	}
+	atomic.AddInt64(&foRunner.staleMessageCount, 1)
+	return true
+}
+
+// breakerAllows returns whether the circuit breaker, if any, lets the next
+// message through to the plugin.
+func (foRunner *foRunner) breakerAllows() bool {
+	if foRunner.breaker == nil {
+		return true
+	}
+	ok, halfOpened := foRunner.breaker.allow()
+	if halfOpened {
+		foRunner.LogMessage("circuit breaker half-open, testing delivery")
+	}
+	return ok
+}
+
+func (foRunner *foRunner) breakerSuccess() {
+	if foRunner.breaker != nil && foRunner.breaker.success() {
+		foRunner.LogMessage("circuit breaker closed, delivery recovered")
+	}
+}
+
+// breakerFailure records a failed delivery with the circuit breaker, if any.
+// Returns true if the breaker is now open.
+func (foRunner *foRunner) breakerFailure() bool {
+	if foRunner.breaker == nil || !foRunner.breaker.failure() {
+		return false
+	}
+	foRunner.LogError(fmt.Errorf("circuit breaker open, dropping messages for %s",
+		foRunner.breaker.cooldown))
+	return true
+}
+
 // Starter is the main goroutine launched for plugins that support the newer
 // API.
 func (foRunner *foRunner) Starter(plugin MessageProcessor, h PluginHelper,
@@ -1235,11 +1720,17 @@ func (foRunner *foRunner) Starter(plugin MessageProcessor, h PluginHelper,
 		}
 	}
 
+	// Batching outputs get their messages through the batcher.
+	processor := plugin
+	if foRunner.batcher != nil {
+		processor = foRunner.batcher
+	}
+
 	for !globals.IsShuttingDown() {
 		if foRunner.useBuffering {
-			err = foRunner.bufferLoop(plugin, h, tickReceiver)
+			err = foRunner.bufferLoop(processor, h, tickReceiver)
 		} else {
-			err = foRunner.channelLoop(plugin, h, tickReceiver)
+			err = foRunner.channelLoop(processor, h, tickReceiver)
 		}
 
 		switch foRunner.kind {
@@ -1261,8 +1752,9 @@ func (foRunner *foRunner) Starter(plugin MessageProcessor, h PluginHelper,
 
 		foRunner.LogMessage("stopped")
 
-		// Are we shutting down? Save ourselves some time by exiting now.
-		if globals.IsShuttingDown() {
+		// Are we shutting down or unloaded? Save ourselves some time by
+		// exiting now.
+		if globals.IsShuttingDown() || atomic.LoadInt32(&foRunner.unloaded) != 0 {
 			break
 		}
 
@@ -1336,6 +1828,9 @@ func (foRunner *foRunner) Unregister(pConfig *PipelineConfig) error {
 }
 
 func (foRunner *foRunner) exit() {
+	if foRunner.transform != nil {
+		defer foRunner.transform.Stop()
+	}
 	if !foRunner.useBuffering {
 		defer func() {
 			var orphaned int
@@ -1356,6 +1851,12 @@ func (foRunner *foRunner) exit() {
 		return
 	}
 
+	// Or if a config reload took us out, it has unregistered us already.
+	if atomic.LoadInt32(&foRunner.unloaded) != 0 {
+		foRunner.LogMessage("unloaded")
+		return
+	}
+
 	// Also, if this isn't a "stoppable" plugin we shut everything down.
 	if !foRunner.IsStoppable() {
 		foRunner.LogMessage("has stopped, shutting down.")
@@ -1573,7 +2074,9 @@ func (foRunner foRunner) SendRecord(pack *PipelinePack) error {
 			} else {
 				if _, ok := err.(RetryMessageError); !ok {
 					foRunner.LogError(fmt.Errorf("can't send record: %s", err))
-					atomic.AddInt64(&foRunner.dropMessageCount, 1)
+					if !foRunner.offerFallback(pack) {
+						atomic.AddInt64(&foRunner.dropMessageCount, 1)
+					}
 					pack.recycle()
 					err = nil // Swallow the error so there's no retry.
 				}
@@ -1600,14 +2103,26 @@ func (foRunner *foRunner) UpdateCursor(queueCursor string) {
 }
 
 func (foRunner *foRunner) Inject(pack *PipelinePack) bool {
+	return foRunner.inject(pack, true)
+}
+
+// inject does the work of Inject. The self-match check is skipped for acks,
+// an output may be handed its own acks since acks are never acknowledged in
+// turn.
+func (foRunner *foRunner) inject(pack *PipelinePack, checkMatch bool) bool {
 	if pack.BufferedPack {
 		foRunner.LogError(errors.New("can't inject buffered plugin pack"))
 		return false
 	}
+	// Filters can be allowed more loops than the global maximum, which is
+	// then what's enforced here rather than when the pack was handed out.
+	if maxMsgLoops := foRunner.maxMsgLoops(); pack.MsgLoopCount > maxMsgLoops {
+		foRunner.LogError(fmt.Errorf("exceeded MaxMsgLoops = %d", maxMsgLoops))
+		pack.recycle()
+		return false
+	}
 	// Make sure we're not creating an obvious infinite routing loop.
-	spec := foRunner.MatchRunner().MatcherSpecification()
-	match := spec.Match(pack.Message)
-	if match {
+	if checkMatch && foRunner.MatchRunner().MatcherSpecification().Match(pack.Message) {
 		foRunner.LogError(errors.New("attempted to Inject a message to itself"))
 		pack.recycle()
 		return false
@@ -1628,6 +2143,57 @@ func (foRunner *foRunner) Inject(pack *PipelinePack) bool {
 	return true
 }
 
+// delivered is called once the output's ProcessMessage has handled the pack
+// without error, to record its latency and acknowledge it. Batched messages
+// are handled by the batcher once the batch is sent instead.
+func (foRunner *foRunner) delivered(pack *PipelinePack) {
+	if foRunner.batcher != nil {
+		return
+	}
+	foRunner.latency.observe(pack.IngestTime)
+	foRunner.ack(pack)
+}
+
+// ack injects a `heka.output.ack` message referencing the provided pack's
+// message, if the output is configured to acknowledge successful deliveries.
+func (foRunner *foRunner) ack(pack *PipelinePack) {
+	if !foRunner.wantsAck(pack) {
+		return
+	}
+	foRunner.sendAck(pack.Message.GetUuidString(), pack.MsgLoopCount)
+}
+
+// wantsAck returns whether the pack's message should be acknowledged once
+// it's delivered. Delivered acks aren't acknowledged.
+func (foRunner *foRunner) wantsAck(pack *PipelinePack) bool {
+	return foRunner.config.AckOnSuccess && !isAck(pack.Message)
+}
+
+func (foRunner *foRunner) sendAck(uuid string, msgLoopCount uint) {
+	ackPack, err := foRunner.pConfig.PipelinePack(msgLoopCount)
+	if err != nil {
+		foRunner.LogError(fmt.Errorf("can't generate ack message: %s", err))
+		return
+	}
+	ackPack.Message.SetType("heka.output.ack")
+	ackPack.Message.SetLogger(HEKA_DAEMON)
+	message.NewStringField(ackPack.Message, "output", foRunner.name)
+	message.NewStringField(ackPack.Message, "message_uuid", uuid)
+	foRunner.inject(ackPack, false)
+}
+
+func isAck(msg *message.Message) bool {
+	return msg.GetType() == "heka.output.ack" && msg.GetLogger() == HEKA_DAEMON
+}
+
+// Returns the highest loop count of the messages the plugin may inject.
+func (foRunner *foRunner) maxMsgLoops() uint {
+	if foRunner.config.MaxMsgLoops > 0 {
+		return foRunner.config.MaxMsgLoops
+	}
+	return foRunner.h.PipelineConfig().Globals.MaxMsgLoops
+}
+
 func (foRunner *foRunner) LogError(err error) {
 	LogError.Printf("Plugin '%s' error: %s", foRunner.name, err)
 }
@@ -1692,11 +2258,23 @@ func (foRunner *foRunner) Encoder() Encoder {
 }
 
 func (foRunner *foRunner) Encode(pack *PipelinePack) (output []byte, err error) {
+	if foRunner.transform != nil {
+		if pack, err = foRunner.transform.Transform(pack); err != nil || pack == nil {
+			return
+		}
+	}
 	var encoded []byte
 	if encoded, err = foRunner.encoder.Encode(pack); err != nil || encoded == nil {
 		return
 	}
 	if foRunner.useFraming {
+		if foRunner.relaysHeader && len(pack.HeaderBytes) > 0 && pack.TrustMsgBytes {
+			// Relay the original signed record verbatim.
+			output = make([]byte, 0, len(pack.HeaderBytes)+len(encoded))
+			output = append(output, pack.HeaderBytes...)
+			output = append(output, encoded...)
+			return
+		}
 		client.CreateHekaStream(encoded, &output, nil)
 	} else {
 		output = encoded
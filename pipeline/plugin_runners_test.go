@@ -19,7 +19,9 @@ package pipeline
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/mozilla-services/heka/message"
@@ -169,7 +171,7 @@ func InputRunnerSpec(c gs.Context) {
 
 				// Checking if decoder was added to global list of synchronous decoders
 				c.Expect(len(pConfig.allSyncDecoders), gs.Equals, 1)
-				c.Expect(pConfig.allSyncDecoders[0].decoder, gs.Equals, d.decoder)
+				c.Expect(pConfig.allSyncDecoders[0].decoder, gs.Equals, d.decoders[0])
 				c.Expect(pConfig.allSyncDecoders[0].name, gs.Equals, "syncdec-FooDecoder")
 
 				d.Done()
@@ -212,7 +214,7 @@ func InputRunnerSpec(c gs.Context) {
 
 				dWg := new(sync.WaitGroup)
 				dWg.Add(1)
-				d.dRunner.Start(pConfig, dWg)
+				d.dRunners[0].Start(pConfig, dWg)
 
 				// Make sure it was passed all the way through to the router.
 				recd := <-pConfig.router.inChan
@@ -228,7 +230,69 @@ func InputRunnerSpec(c gs.Context) {
 				pack.Recycle(nil)
 				input.Stop()
 				wg.Wait()
-				close(d.dRunner.InChan())
+				close(d.dRunners[0].InChan())
+			})
+
+			c.Specify("when using a decoder pool", func() {
+				commonInput.Decoder = "FooDecoder"
+				commonInput.DecoderPoolSize = 3
+				runner := NewInputRunner("pooled", input, commonInput).(*iRunner)
+				runner.pConfig = pConfig
+				d := runner.NewDeliverer("").(*deliverer)
+
+				c.Expect(len(d.dRunners), gs.Equals, 3)
+				for i, dr := range d.dRunners {
+					c.Expect(dr.Name(), gs.Equals,
+						fmt.Sprintf("pooled-FooDecoder-%d", i))
+				}
+
+				// Packs are handed out round robin, so consecutive packs of
+				// the same stream go to different decoders and may be
+				// reordered.
+				packs := make([]*PipelinePack, 4)
+				for i := range packs {
+					packs[i] = NewPipelinePack(pConfig.inputRecycleChan)
+					d.Deliver(packs[i])
+				}
+				c.Expect(<-d.dRunners[0].InChan(), gs.Equals, packs[0])
+				c.Expect(<-d.dRunners[1].InChan(), gs.Equals, packs[1])
+				c.Expect(<-d.dRunners[2].InChan(), gs.Equals, packs[2])
+				c.Expect(<-d.dRunners[0].InChan(), gs.Equals, packs[3])
+
+				d.Done()
+				for _, dr := range d.dRunners {
+					_, ok := <-dr.InChan()
+					c.Expect(ok, gs.IsFalse)
+				}
+			})
+
+			c.Specify("with a decoder pool and synchronous decoding", func() {
+				mockHelper.EXPECT().PipelineConfig().Return(pConfig)
+				syncDecode := true
+				commonInput.SyncDecode = &syncDecode
+				commonInput.Decoder = "FooDecoder"
+				commonInput.DecoderPoolSize = 2
+				runner := NewInputRunner("pooled", input, commonInput).(*iRunner)
+				runner.pConfig = pConfig
+				d := runner.NewDeliverer("").(*deliverer)
+				runner.deliver = d.deliver
+				startRunner(runner)
+
+				c.Expect(len(d.decoders), gs.Equals, 2)
+				c.Expect(len(pConfig.allSyncDecoders), gs.Equals, 2)
+				c.Expect(pConfig.allSyncDecoders[1].name, gs.Equals, "pooled-FooDecoder-1")
+
+				runner.Deliver(pack)
+				recd := <-pConfig.router.inChan
+				c.Expect(recd, gs.Equals, pack)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "FOO")
+
+				d.Done()
+				c.Expect(len(pConfig.allSyncDecoders), gs.Equals, 0)
+
+				pack.Recycle(nil)
+				input.Stop()
+				wg.Wait()
 			})
 
 			c.Specify("when using a decoder", func() {
@@ -326,6 +390,47 @@ func FilterRunnerSpec(c gs.Context) {
 			c.Expect(recd.TrustMsgBytes, gs.IsTrue)
 			c.Expect(bytes.Equal(msgEncoding, recd.MsgBytes), gs.IsTrue)
 		})
+
+		c.Specify("rejects messages past the max loop count", func() {
+			pack.MsgLoopCount = pConfig.Globals.MaxMsgLoops + 1
+			result := fRunner.Inject(pack)
+			c.Expect(result, gs.IsFalse)
+		})
+
+		c.Specify("with a max_message_loops override", func() {
+			fRunner.config.MaxMsgLoops = pConfig.Globals.MaxMsgLoops + 2
+			pConfig.addMsgLoopsOverride(fRunner.config.MaxMsgLoops)
+
+			c.Specify("injects messages up to its own limit", func() {
+				pack.MsgLoopCount = fRunner.config.MaxMsgLoops
+				result := fRunner.Inject(pack)
+				c.Expect(result, gs.IsTrue)
+				recd := <-pConfig.router.inChan
+				c.Expect(recd, gs.Equals, pack)
+			})
+
+			c.Specify("hands out packs up to the highest limit", func() {
+				_, err := pConfig.PipelinePack(fRunner.config.MaxMsgLoops)
+				c.Expect(err, gs.Not(gs.IsNil))
+				recd, err := pConfig.PipelinePack(fRunner.config.MaxMsgLoops - 1)
+				c.Expect(err, gs.IsNil)
+				c.Expect(recd.MsgLoopCount, gs.Equals, fRunner.config.MaxMsgLoops)
+			})
+		})
+
+		c.Specify("supports max_message_age only with buffering", func() {
+			commonFO.MaxMessageAge = "1h"
+			_, err := NewFORunner("counterFilter", filter, commonFO, "CounterFilter",
+				chanSize)
+			c.Expect(err, gs.Not(gs.IsNil))
+			useBuffering := true
+			commonFO.UseBuffering = &useBuffering
+			commonFO.Buffering = &QueueBufferConfig{}
+			fRunner, err := NewFORunner("counterFilter", filter, commonFO,
+				"CounterFilter", chanSize)
+			c.Expect(err, gs.IsNil)
+			c.Expect(fRunner.maxMsgAge, gs.Equals, time.Hour)
+		})
 	})
 }
 
@@ -374,6 +479,30 @@ func (enc *_payloadEncoder) Encode(pack *PipelinePack) (output []byte, err error
 	return []byte(pack.Message.GetPayload()), nil
 }
 
+type _ackOutput struct{}
+
+func (o *_ackOutput) Init(config interface{}) error {
+	return nil
+}
+
+func (o *_ackOutput) Prepare(or OutputRunner, h PluginHelper) error {
+	return nil
+}
+
+func (o *_ackOutput) ProcessMessage(pack *PipelinePack) error {
+	return nil
+}
+
+func (o *_ackOutput) CleanUp() {}
+
+type _deferringOutput struct {
+	_ackOutput
+}
+
+func (o *_deferringOutput) DefersDelivery() bool {
+	return true
+}
+
 type _ignoreEncoder struct{}
 
 func (enc *_ignoreEncoder) Encode(pack *PipelinePack) (output []byte, err error) {
@@ -531,6 +660,248 @@ func OutputRunnerSpec(c gs.Context) {
 			c.Expect(len(pConfig.inputRecycleChan), gs.Equals, 1)
 		})
 
+		c.Specify("with a fallback output", func() {
+			oRunner, err := NewFORunner("stoppingOutput", output, commonFO, "StoppingOutput",
+				chanSize)
+			c.Assume(err, gs.IsNil)
+			fbRunner, err := NewFORunner("fallbackOutput", &StoppingOutput{}, commonFO,
+				"StoppingOutput", chanSize)
+			c.Assume(err, gs.IsNil)
+			pConfig.OutputRunners["stoppingOutput"] = oRunner
+			pConfig.OutputRunners["fallbackOutput"] = fbRunner
+			oRunner.pConfig = pConfig
+			oRunner.h = pConfig
+			oRunner.config.FallbackOutput = "fallbackOutput"
+
+			c.Specify("requires buffering for old-style outputs", func() {
+				err := oRunner.setFallback()
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("won't fall back to itself", func() {
+				oRunner.useBuffering = true
+				oRunner.config.FallbackOutput = "stoppingOutput"
+				err := oRunner.setFallback()
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("requires the fallback to exist", func() {
+				oRunner.useBuffering = true
+				oRunner.config.FallbackOutput = "missingOutput"
+				err := oRunner.setFallback()
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("hands declined messages to the fallback", func() {
+				oRunner.useBuffering = true
+				err := oRunner.setFallback()
+				c.Assume(err, gs.IsNil)
+
+				pConfig.injectRecycleChan <- NewPipelinePack(pConfig.injectRecycleChan)
+				pack := NewPipelinePack(pConfig.inputRecycleChan)
+				pack.Message = ts.GetTestMessage()
+				c.Expect(oRunner.offerFallback(pack), gs.IsTrue)
+
+				fbPack := <-fbRunner.inChan
+				c.Expect(fbPack == pack, gs.IsFalse)
+				c.Expect(fbPack.Message.GetUuidString(), gs.Equals,
+					pack.Message.GetUuidString())
+				c.Expect(fbPack.MsgLoopCount, gs.Equals, uint(1))
+				c.Expect(fbPack.TrustMsgBytes, gs.IsTrue)
+				msg := new(message.Message)
+				err = proto.Unmarshal(fbPack.MsgBytes, msg)
+				c.Expect(err, gs.IsNil)
+				c.Expect(msg.GetUuidString(), gs.Equals, pack.Message.GetUuidString())
+			})
+
+			c.Specify("hands the original message bytes to the fallback", func() {
+				oRunner.useBuffering = true
+				err := oRunner.setFallback()
+				c.Assume(err, gs.IsNil)
+
+				pConfig.injectRecycleChan <- NewPipelinePack(pConfig.injectRecycleChan)
+				pack := NewPipelinePack(pConfig.inputRecycleChan)
+				pack.Message = ts.GetTestMessage()
+				err = pack.EncodeMsgBytes()
+				c.Assume(err, gs.IsNil)
+				c.Expect(oRunner.offerFallback(pack), gs.IsTrue)
+
+				fbPack := <-fbRunner.inChan
+				c.Expect(fbPack.TrustMsgBytes, gs.IsTrue)
+				c.Expect(bytes.Equal(fbPack.MsgBytes, pack.MsgBytes), gs.IsTrue)
+				c.Expect(&fbPack.MsgBytes[0] == &pack.MsgBytes[0], gs.IsFalse)
+			})
+
+			c.Specify("hands off to the fallback's reloaded runner", func() {
+				oRunner.useBuffering = true
+				err := oRunner.setFallback()
+				c.Assume(err, gs.IsNil)
+				reloaded, err := NewFORunner("fallbackOutput", &StoppingOutput{},
+					commonFO, "StoppingOutput", chanSize)
+				c.Assume(err, gs.IsNil)
+				fbRunner.pConfig = pConfig
+				pConfig.OutputRunners["fallbackOutput"] = reloaded
+
+				pConfig.injectRecycleChan <- NewPipelinePack(pConfig.injectRecycleChan)
+				pack := NewPipelinePack(pConfig.inputRecycleChan)
+				pack.Message = ts.GetTestMessage()
+				c.Expect(oRunner.offerFallback(pack), gs.IsTrue)
+				c.Expect(len(fbRunner.inChan), gs.Equals, 0)
+				fbPack := <-reloaded.inChan
+				c.Expect(fbPack.Message.GetUuidString(), gs.Equals,
+					pack.Message.GetUuidString())
+			})
+
+			c.Specify("doesn't hand off without a fallback", func() {
+				pack := NewPipelinePack(pConfig.inputRecycleChan)
+				c.Expect(oRunner.offerFallback(pack), gs.IsFalse)
+			})
+		})
+
+		c.Specify("hands decode failures to a decode_failure_output", func() {
+			fRunner, err := NewFORunner("failureOutput", &StoppingOutput{}, commonFO,
+				"StoppingOutput", chanSize)
+			c.Assume(err, gs.IsNil)
+
+			pack := NewPipelinePack(pConfig.inputRecycleChan)
+			pack.MsgBytes = append(pack.MsgBytes, "not protobuf"...)
+			err = deliverDecodeFailure(fRunner, pack, "myInput", "bad record")
+			c.Expect(err, gs.IsNil)
+
+			fPack := <-fRunner.inChan
+			c.Expect(fPack == pack, gs.IsTrue)
+			c.Expect(len(fPack.Message.GetUuid()), gs.Equals, 16)
+			c.Expect(fPack.Message.GetLogger(), gs.Equals, "myInput")
+			failure, _ := fPack.Message.GetFieldValue("decode_failure")
+			c.Expect(failure, gs.Equals, true)
+			errMsg, _ := fPack.Message.GetFieldValue("decode_error")
+			c.Expect(errMsg, gs.Equals, "bad record")
+			raw, _ := fPack.Message.GetFieldValue("raw_message")
+			c.Expect(string(raw.([]byte)), gs.Equals, "not protobuf")
+			c.Expect(fPack.TrustMsgBytes, gs.IsTrue)
+		})
+
+		c.Specify("records the latency of delivered messages", func() {
+			oRunner, err := NewFORunner("ackOutput", &_ackOutput{}, commonFO,
+				"AckOutput", chanSize)
+			c.Assume(err, gs.IsNil)
+			oRunner.latency = newLatencyTracker()
+			pack := NewPipelinePack(pConfig.inputRecycleChan)
+			pack.Message = ts.GetTestMessage()
+			pack.IngestTime = time.Now().Add(-time.Second).UnixNano()
+			oRunner.delivered(pack)
+			c.Assume(len(oRunner.latency.samples), gs.Equals, 1)
+			c.Expect(oRunner.latency.samples[0] >= int64(time.Second), gs.IsTrue)
+		})
+
+		c.Specify("with ack_on_success", func() {
+			commonFO.AckOnSuccess = true
+
+			c.Specify("requires an output using the ProcessMessage API", func() {
+				_, err := NewFORunner("stoppingOutput", output, commonFO, "StoppingOutput",
+					chanSize)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("refuses an output that defers delivery", func() {
+				_, err := NewFORunner("deferringOutput", &_deferringOutput{}, commonFO,
+					"DeferringOutput", chanSize)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("injects an ack message for a delivered message", func() {
+				// The output's own acks may be routed back to it.
+				commonFO.Matcher = "TRUE"
+				oRunner, err := NewFORunner("ackOutput", &_ackOutput{}, commonFO,
+					"AckOutput", chanSize)
+				c.Assume(err, gs.IsNil)
+				oRunner.pConfig = pConfig
+				oRunner.h = pConfig
+
+				pConfig.injectRecycleChan <- NewPipelinePack(pConfig.injectRecycleChan)
+				pack := NewPipelinePack(pConfig.inputRecycleChan)
+				pack.Message = ts.GetTestMessage()
+				oRunner.ack(pack)
+
+				ack := <-pConfig.router.inChan
+				c.Expect(ack.Message.GetType(), gs.Equals, "heka.output.ack")
+				c.Expect(ack.Message.GetLogger(), gs.Equals, "hekad")
+				name, _ := ack.Message.GetFieldValue("output")
+				c.Expect(name, gs.Equals, "ackOutput")
+				uuid, _ := ack.Message.GetFieldValue("message_uuid")
+				c.Expect(uuid, gs.Equals, pack.Message.GetUuidString())
+				c.Expect(ack.MsgLoopCount, gs.Equals, uint(1))
+
+				// Acks aren't acknowledged, so no pack is taken for another one.
+				pConfig.injectRecycleChan <- NewPipelinePack(pConfig.injectRecycleChan)
+				oRunner.ack(ack)
+				c.Expect(len(pConfig.injectRecycleChan), gs.Equals, 1)
+			})
+		})
+
+		c.Specify("attaches the matcher's captures to the pack", func() {
+			commonFO.Matcher = "Type =~ /^(?P<app>\\w+)\\.log$/"
+			oRunner, err := NewFORunner("stoppingOutput", output, commonFO, "StoppingOutput",
+				chanSize)
+			c.Assume(err, gs.IsNil)
+			mr := oRunner.matcher
+			recycleChan := make(chan *PipelinePack, 2)
+			for _, typ := range []string{"nginx.log", "other"} {
+				pack := NewPipelinePack(recycleChan)
+				pack.Message.SetType(typ)
+				mr.inChan <- pack
+			}
+			mr.Close()
+			mr.run(1)
+
+			c.Expect(len(oRunner.inChan), gs.Equals, 1)
+			pack := <-oRunner.inChan
+			captures := pack.MatchCaptures("stoppingOutput")
+			c.Expect(len(captures), gs.Equals, 1)
+			c.Expect(captures["app"], gs.Equals, "nginx")
+			c.Expect(pack.MatchCaptures("otherOutput") == nil, gs.IsTrue)
+			pack.recycle()
+			c.Expect(pack.MatchCaptures("stoppingOutput") == nil, gs.IsTrue)
+		})
+
+		c.Specify("with max_message_age", func() {
+			commonFO.MaxMessageAge = "1h"
+
+			c.Specify("requires buffering for old-style outputs", func() {
+				_, err := NewFORunner("stoppingOutput", output, commonFO, "StoppingOutput",
+					chanSize)
+				c.Expect(err, gs.Not(gs.IsNil))
+				useBuffering := true
+				commonFO.UseBuffering = &useBuffering
+				commonFO.Buffering = &QueueBufferConfig{}
+				_, err = NewFORunner("stoppingOutput", output, commonFO, "StoppingOutput",
+					chanSize)
+				c.Expect(err, gs.IsNil)
+			})
+
+			c.Specify("rejects an invalid duration", func() {
+				commonFO.MaxMessageAge = "an hour"
+				_, err := NewFORunner("ackOutput", &_ackOutput{}, commonFO, "AckOutput",
+					chanSize)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("drops messages by their timestamp", func() {
+				oRunner, err := NewFORunner("ackOutput", &_ackOutput{}, commonFO,
+					"AckOutput", chanSize)
+				c.Assume(err, gs.IsNil)
+				c.Expect(oRunner.maxMsgAge, gs.Equals, time.Hour)
+
+				pack := NewPipelinePack(nil)
+				pack.Message = ts.GetTestMessage()
+				pack.Message.SetTimestamp(time.Now().UnixNano())
+				c.Expect(oRunner.isStale(pack), gs.IsFalse)
+				pack.Message.SetTimestamp(time.Now().Add(-2 * time.Hour).UnixNano())
+				c.Expect(oRunner.isStale(pack), gs.IsTrue)
+				c.Expect(oRunner.staleMessageCount, gs.Equals, int64(1))
+			})
+		})
+
 		c.Specify("encodes a message", func() {
 			oRunner, err := NewFORunner("stoppingOutput", output, commonFO, "StoppingOutput",
 				chanSize)
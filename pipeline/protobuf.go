@@ -16,6 +16,7 @@
 package pipeline
 
 import (
+	"fmt"
 	"math/rand"
 	"sync"
 	"sync/atomic"
@@ -35,6 +36,28 @@ type ProtobufDecoder struct {
 	reportLock             sync.Mutex
 	sample                 bool
 	sampleDenominator      int
+	passthrough            bool
+	rejectedMessageCount   int64
+	maxFieldCount          int
+	maxValueCount          int
+	maxDecodedSize         int
+}
+
+type ProtobufDecoderConfig struct {
+	// If true, the original signed framing header of the record, as
+	// preserved by the HekaFramingSplitter, will be kept with the pack so an
+	// output using the ProtobufEncoder and framing can relay the exact signed
+	// record.
+	Passthrough bool `toml:"passthrough"`
+	// Maximum number of dynamic fields a message can have. Defaults to 0 (no
+	// limit).
+	MaxFieldCount int `toml:"max_field_count"`
+	// Maximum number of values across all of a message's dynamic fields.
+	// Defaults to 0 (no limit).
+	MaxValueCount int `toml:"max_value_count"`
+	// Maximum estimated in-memory size, in bytes, of a decoded message.
+	// Defaults to 0 (no limit).
+	MaxDecodedSize int `toml:"max_decoded_size"`
 }
 
 // Heka will call this before calling any other methods to give us access to
@@ -43,7 +66,17 @@ func (p *ProtobufDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
 	p.pConfig = pConfig
 }
 
+func (p *ProtobufDecoder) ConfigStruct() interface{} {
+	return &ProtobufDecoderConfig{}
+}
+
 func (p *ProtobufDecoder) Init(config interface{}) error {
+	if conf, ok := config.(*ProtobufDecoderConfig); ok {
+		p.passthrough = conf.Passthrough
+		p.maxFieldCount = conf.MaxFieldCount
+		p.maxValueCount = conf.MaxValueCount
+		p.maxDecodedSize = conf.MaxDecodedSize
+	}
 	p.sample = true
 	p.sampleDenominator = p.pConfig.Globals.SampleDenominator
 	return nil
@@ -59,9 +92,15 @@ func (p *ProtobufDecoder) Decode(pack *PipelinePack) (
 		startTime = time.Now()
 	}
 
-	if err = proto.Unmarshal(pack.MsgBytes, pack.Message); err == nil {
+	if err = p.checkLimits(pack.MsgBytes); err != nil {
+		atomic.AddInt64(&p.rejectedMessageCount, 1)
+		atomic.AddInt64(&p.processMessageFailures, 1)
+	} else if err = proto.Unmarshal(pack.MsgBytes, pack.Message); err == nil {
 		packs = []*PipelinePack{pack}
 		pack.TrustMsgBytes = true
+		if !p.passthrough {
+			pack.HeaderBytes = pack.HeaderBytes[:0]
+		}
 	} else {
 		atomic.AddInt64(&p.processMessageFailures, 1)
 	}
@@ -77,6 +116,29 @@ func (p *ProtobufDecoder) Decode(pack *PipelinePack) (
 	return
 }
 
+// Rejects encoded messages that would decode into more fields, values, or
+// memory than the configured limits allow, before any decoding happens.
+func (p *ProtobufDecoder) checkLimits(msgBytes []byte) error {
+	if p.maxFieldCount <= 0 && p.maxValueCount <= 0 && p.maxDecodedSize <= 0 {
+		return nil
+	}
+	stats, err := scanProtobufMessage(msgBytes)
+	switch {
+	case err != nil:
+		return err
+	case p.maxFieldCount > 0 && stats.fields > p.maxFieldCount:
+		return fmt.Errorf("message has %d fields, max is %d", stats.fields,
+			p.maxFieldCount)
+	case p.maxValueCount > 0 && stats.values > p.maxValueCount:
+		return fmt.Errorf("message has %d field values, max is %d", stats.values,
+			p.maxValueCount)
+	case p.maxDecodedSize > 0 && stats.decodedSize > p.maxDecodedSize:
+		return fmt.Errorf("decoded message would be ~%d bytes, max is %d",
+			stats.decodedSize, p.maxDecodedSize)
+	}
+	return nil
+}
+
 func (p *ProtobufDecoder) EncodesMsgBytes() bool {
 	return true
 }
@@ -89,6 +151,8 @@ func (p *ProtobufDecoder) ReportMsg(msg *message.Message) error {
 		atomic.LoadInt64(&p.processMessageCount), "count")
 	message.NewInt64Field(msg, "ProcessMessageFailures",
 		atomic.LoadInt64(&p.processMessageFailures), "count")
+	message.NewInt64Field(msg, "RejectedMessageCount",
+		atomic.LoadInt64(&p.rejectedMessageCount), "count")
 	message.NewInt64Field(msg, "ProcessMessageSamples",
 		p.processMessageSamples, "count")
 
@@ -0,0 +1,144 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Rough in-memory cost of each decoded Field struct and of each string or
+// bytes value's slice header, used to estimate a message's decoded size.
+const (
+	decodedFieldOverhead = 192
+	decodedValueOverhead = 24
+)
+
+var errBadProtobuf = errors.New("invalid protobuf encoding")
+
+// What decoding a protobuf encoded Message would produce, worked out from
+// the wire format without allocating anything.
+type protobufStats struct {
+	fields      int // Dynamic fields.
+	values      int // Values across all of the dynamic fields.
+	decodedSize int // Estimated size in bytes of the decoded message.
+}
+
+// scanProtobufMessage walks the wire encoding of a Message, counting its
+// dynamic fields and their values. A few bytes on the wire can declare a
+// field or value that takes up far more memory once unmarshaled, so this
+// lets the ProtobufDecoder reject such messages before decoding them.
+func scanProtobufMessage(b []byte) (stats protobufStats, err error) {
+	var (
+		num, wireType int
+		data          []byte
+	)
+	for len(b) > 0 {
+		if num, wireType, data, b, err = nextProtobufField(b); err != nil {
+			return
+		}
+		if wireType != 2 {
+			stats.decodedSize += 8
+			continue
+		}
+		if num != 10 {
+			stats.decodedSize += len(data)
+			continue
+		}
+		stats.fields++
+		stats.decodedSize += decodedFieldOverhead
+		if err = scanProtobufField(data, &stats); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Adds the values of a single encoded Field to the stats.
+func scanProtobufField(b []byte, stats *protobufStats) (err error) {
+	var (
+		num, wireType int
+		data          []byte
+	)
+	for len(b) > 0 {
+		if num, wireType, data, b, err = nextProtobufField(b); err != nil {
+			return
+		}
+		switch {
+		case num == 4 || num == 5: // value_string, value_bytes
+			stats.values++
+			stats.decodedSize += len(data) + decodedValueOverhead
+		case num == 6 && wireType == 2: // Packed value_integer.
+			n := 0
+			for _, c := range data {
+				if c < 0x80 {
+					n++
+				}
+			}
+			stats.values += n
+			stats.decodedSize += 8 * n
+		case num == 7 && wireType == 2: // Packed value_double.
+			stats.values += len(data) / 8
+			stats.decodedSize += len(data)
+		case num == 8 && wireType == 2: // Packed value_bool.
+			stats.values += len(data)
+			stats.decodedSize += len(data)
+		case num >= 6 && num <= 8: // Unpacked numeric values.
+			stats.values++
+			stats.decodedSize += 8
+		default:
+			stats.decodedSize += len(data)
+		}
+	}
+	return
+}
+
+// Splits the next key/value pair off of an encoded message, returning the
+// field number, the wire type, the value's bytes for length delimited values,
+// and the rest of the message.
+func nextProtobufField(b []byte) (num, wireType int, data, rest []byte,
+	err error) {
+
+	key, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0, nil, nil, errBadProtobuf
+	}
+	b = b[n:]
+	num, wireType = int(key>>3), int(key&7)
+	switch wireType {
+	case 0:
+		if _, n = binary.Uvarint(b); n <= 0 {
+			return 0, 0, nil, nil, errBadProtobuf
+		}
+	case 1:
+		n = 8
+	case 2:
+		length, m := binary.Uvarint(b)
+		if m <= 0 || length > uint64(len(b)-m) {
+			return 0, 0, nil, nil, errBadProtobuf
+		}
+		b = b[m:]
+		n = int(length)
+		data = b[:n]
+	case 5:
+		n = 4
+	default:
+		return 0, 0, nil, nil, errBadProtobuf
+	}
+	if n > len(b) {
+		return 0, 0, nil, nil, errBadProtobuf
+	}
+	return num, wireType, data, b[n:], nil
+}
@@ -17,9 +17,11 @@
 package pipeline
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/message"
 	ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/rafrombrc/gomock/gomock"
 	"github.com/rafrombrc/gospec/src/gospec"
@@ -56,6 +58,74 @@ func ProtobufDecoderSpec(c gospec.Context) {
 			c.Expect(v, gs.Equals, "bar")
 		})
 
+		c.Specify("drops a preserved framing header by default", func() {
+			pack.MsgBytes = encoded
+			pack.HeaderBytes = []byte("header")
+			_, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(pack.HeaderBytes), gs.Equals, 0)
+		})
+
+		c.Specify("keeps a preserved framing header in passthrough mode", func() {
+			decoder.passthrough = true
+			pack.MsgBytes = encoded
+			pack.HeaderBytes = []byte("header")
+			_, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(pack.HeaderBytes), gs.Equals, "header")
+			c.Expect(pack.TrustMsgBytes, gs.IsTrue)
+
+			c.Specify("until the message is re-encoded", func() {
+				pack.TrustMsgBytes = false
+				err = pack.EncodeMsgBytes()
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(pack.HeaderBytes), gs.Equals, 0)
+			})
+		})
+
+		c.Specify("enforces limits before decoding", func() {
+			big := ts.GetTestMessage()
+			for i := 0; i < 10; i++ {
+				f := message.NewFieldInit(fmt.Sprintf("int%d", i), message.Field_INTEGER, "")
+				for j := int64(1); j <= 3; j++ {
+					f.AddValue(j)
+				}
+				big.AddField(f)
+			}
+			bigEncoded, err := proto.Marshal(big)
+			c.Assume(err, gs.IsNil)
+			pack.MsgBytes = bigEncoded
+
+			c.Specify("on the field count", func() {
+				decoder.maxFieldCount = 10
+				_, err := decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+				c.Expect(decoder.rejectedMessageCount, gs.Equals, int64(1))
+				c.Expect(len(pack.Message.Fields), gs.Equals, 0)
+
+				pack.MsgBytes = encoded
+				_, err = decoder.Decode(pack)
+				c.Expect(err, gs.IsNil)
+			})
+
+			c.Specify("on the value count", func() {
+				decoder.maxValueCount = 30
+				_, err := decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+				decoder.maxValueCount = 31
+				_, err = decoder.Decode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(pack.Message.Fields), gs.Equals, 11)
+			})
+
+			c.Specify("on the decoded size", func() {
+				decoder.maxDecodedSize = len(bigEncoded)
+				_, err := decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+				c.Expect(decoder.rejectedMessageCount, gs.Equals, int64(1))
+			})
+		})
+
 		c.Specify("returns an error for bunk encoding", func() {
 			bunk := append([]byte{0, 0, 0}, encoded...)
 			pack.MsgBytes = bunk
@@ -17,6 +17,7 @@ package pipeline
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -25,11 +26,13 @@ import (
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/mozilla-services/heka/client"
+	"github.com/mozilla-services/heka/compression"
 	"github.com/mozilla-services/heka/message"
 )
 
@@ -64,10 +67,24 @@ type QueueBufferConfig struct {
 	MaxBufferSize     uint64 `toml:"max_buffer_size"`
 	FullAction        string `toml:"full_action"`
 	CursorUpdateCount uint   `toml:"cursor_update_count"`
+	MaxAge            string `toml:"max_age"`
+	// Free space to leave on the buffer's filesystem, either in bytes or as
+	// a percentage of its size, e.g. "10%". The buffer is treated as full
+	// once the free space drops below it. Defaults to "", i.e. no limit.
+	MinFreeSpace string `toml:"min_free_space"`
+	// Compression applied to each buffered record, "gzip", "zstd" or
+	// "none". Defaults to "none".
+	Compression string `toml:"compression"`
+	// Compression level, from 1 to 9 for gzip or 1 to 22 for zstd. Defaults to
+	// 0, i.e. the algorithm's default level.
+	CompressionLevel int `toml:"compression_level"`
 }
 
 const DefaultBufferMaxFileSize uint64 = uint64(512 * 1024 * 1024)
 
+// How long the BufferFeeder reuses a free space measurement.
+const freeSpaceCheckInterval = time.Second
+
 func defaultQueueBufferConfig() *QueueBufferConfig {
 	return &QueueBufferConfig{
 		MaxFileSize:       DefaultBufferMaxFileSize,
@@ -121,12 +138,19 @@ func NewBufferSet(queueDir, queueName string, config *QueueBufferConfig,
 }
 
 type BufferFeeder struct {
-	writeFile     *os.File
-	writeFileSize uint64
-	writeId       uint
-	queue         string
-	queueSize     *BufferSize
-	Config        *QueueBufferConfig
+	writeFile      *os.File
+	writeFileSize  uint64
+	writeId        uint
+	queue          string
+	queueSize      *BufferSize
+	Config         *QueueBufferConfig
+	minFreeBytes   uint64
+	minFreePercent float64
+	freeSpace      uint64 // Accessed atomically.
+	totalSpace     uint64
+	freeCheckedAt  time.Time
+	compressor     *compression.Compressor
+	ingestBuffer   []byte
 }
 
 func NewBufferFeeder(queue string, config *QueueBufferConfig, queueSize *BufferSize) (
@@ -139,6 +163,10 @@ func NewBufferFeeder(queue string, config *QueueBufferConfig, queueSize *BufferS
 	}
 
 	var err error
+	bf.minFreeBytes, bf.minFreePercent, err = parseMinFreeSpace(config.MinFreeSpace)
+	if err != nil {
+		return nil, err
+	}
 	if !fileExists(bf.queue) {
 		if err = os.MkdirAll(bf.queue, 0766); err != nil {
 			return nil, fmt.Errorf("can't make queue directory: %s", err)
@@ -178,19 +206,38 @@ func (bf *BufferFeeder) RollQueue() (err error) {
 // that QueueRecord is *not* thread safe, it should only ever be called by one
 // goroutine at a time.
 func (bf *BufferFeeder) QueueRecord(pack *PipelinePack) error {
+	msgBytes := pack.MsgBytes
+	if bf.Config.Compression != "" && bf.Config.Compression != compression.None {
+		var err error
+		if msgBytes, err = bf.compress(msgBytes); err != nil {
+			return fmt.Errorf("record compression error: %s", err)
+		}
+	}
+	if pack.IngestTime != 0 &&
+		uint32(len(msgBytes)+ingestTimeSize) <= message.MAX_MESSAGE_SIZE {
+		// Keeps the output's latency tracking accurate across the buffer.
+		var stamp [ingestTimeSize]byte
+		stamp[0] = ingestTimeMarker
+		binary.BigEndian.PutUint64(stamp[1:], uint64(pack.IngestTime))
+		bf.ingestBuffer = append(append(bf.ingestBuffer[:0], stamp[:]...), msgBytes...)
+		msgBytes = bf.ingestBuffer
+	}
 	maxQueueSize := bf.Config.MaxBufferSize
-	if maxQueueSize > 0 && (bf.queueSize.Get()+uint64(len(pack.MsgBytes)) > maxQueueSize) {
+	if maxQueueSize > 0 && (bf.queueSize.Get()+uint64(len(msgBytes)) > maxQueueSize) {
+		return QueueIsFull
+	}
+	if bf.lowOnSpace(uint64(len(msgBytes))) {
 		return QueueIsFull
 	}
 	maxQueueFileSize := bf.Config.MaxFileSize
-	if bf.writeFileSize+uint64(len(pack.MsgBytes)) > maxQueueFileSize {
+	if bf.writeFileSize+uint64(len(msgBytes)) > maxQueueFileSize {
 		if err := bf.RollQueue(); err != nil {
 			return fmt.Errorf("queue file rotation error: %s", err)
 		}
 	}
 
 	var outBytes []byte
-	err := client.CreateHekaStream(pack.MsgBytes, &outBytes, nil)
+	err := client.CreateHekaStream(msgBytes, &outBytes, nil)
 	if err != nil {
 		return fmt.Errorf("message framing error: %s", err)
 	}
@@ -216,6 +263,78 @@ func (bf *BufferFeeder) QueueRecord(pack *PipelinePack) error {
 	return nil
 }
 
+// Returns the compressed message bytes, or the original ones if compressing
+// them doesn't save any space. The returned slice is only valid until the
+// next call.
+func (bf *BufferFeeder) compress(msgBytes []byte) ([]byte, error) {
+	if bf.compressor == nil {
+		c, err := compression.NewCompressor(bf.Config.Compression,
+			bf.Config.CompressionLevel)
+		if err != nil {
+			return nil, err
+		}
+		bf.compressor = c
+	}
+	compressed, err := bf.compressor.Compress(msgBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed) >= len(msgBytes) {
+		return msgBytes, nil
+	}
+	return compressed, nil
+}
+
+// Parses a `min_free_space` setting, which is either a number of bytes or a
+// percentage.
+func parseMinFreeSpace(setting string) (minBytes uint64, percent float64, err error) {
+	if setting == "" {
+		return
+	}
+	if strings.HasSuffix(setting, "%") {
+		percent, err = strconv.ParseFloat(strings.TrimSuffix(setting, "%"), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			err = fmt.Errorf("`min_free_space` percentage must be between 0 and 100, got '%s'",
+				setting)
+		}
+		return
+	}
+	if minBytes, err = strconv.ParseUint(setting, 10, 64); err != nil {
+		err = fmt.Errorf("`min_free_space` must be a number of bytes or a percentage, got '%s'",
+			setting)
+	}
+	return
+}
+
+// Returns whether writing a record of the given size would leave less than
+// `min_free_space` free on the buffer's filesystem.
+func (bf *BufferFeeder) lowOnSpace(recordSize uint64) bool {
+	if bf.minFreeBytes == 0 && bf.minFreePercent == 0 {
+		return false
+	}
+	if now := time.Now(); now.Sub(bf.freeCheckedAt) >= freeSpaceCheckInterval {
+		free, total, err := diskSpace(bf.queue)
+		if err != nil {
+			// Can't tell, leave it to the write to fail.
+			return false
+		}
+		atomic.StoreUint64(&bf.freeSpace, free)
+		bf.totalSpace = total
+		bf.freeCheckedAt = now
+	}
+	minFree := bf.minFreeBytes
+	if bf.minFreePercent > 0 {
+		minFree = uint64(float64(bf.totalSpace) * bf.minFreePercent / 100)
+	}
+	return atomic.LoadUint64(&bf.freeSpace) < minFree+recordSize
+}
+
+// FreeSpace returns the most recently measured free space on the buffer's
+// filesystem, only measured if `min_free_space` is set.
+func (bf *BufferFeeder) FreeSpace() uint64 {
+	return atomic.LoadUint64(&bf.freeSpace)
+}
+
 type BufferReader struct {
 	readOffset         int64
 	cursorOffset       int64
@@ -231,6 +350,9 @@ type BufferReader struct {
 	checkpointFile     *os.File
 	queue              string
 	queueSize          *BufferSize
+	maxAge             time.Duration
+	expiredCount       int64
+	decompressor       compression.Decompressor
 }
 
 type BufferSender interface {
@@ -247,6 +369,17 @@ func NewBufferReader(queue string, config *QueueBufferConfig, queueSize *BufferS
 		runner:    runner,
 	}
 
+	if config.MaxAge != "" {
+		maxAge, err := time.ParseDuration(config.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid `max_age`: %s", err)
+		}
+		if maxAge < 0 {
+			return nil, errors.New("`max_age` can't be negative")
+		}
+		br.maxAge = maxAge
+	}
+
 	pConfig.makersLock.RLock()
 	splitterMakers := pConfig.makers["Splitter"]
 	maker, ok := splitterMakers["HekaFramingSplitter"]
@@ -447,6 +580,12 @@ func (br *BufferReader) NewStreamOutput(sender MessageProcessor, packSupply chan
 			br.readFile = nil
 		}
 	}()
+	batcher := br.runner.batcher
+	if batcher != nil {
+		// Send the last batch before the final checkpoint is written.
+		defer batcher.stop()
+	}
+	flushChan := batcher.flushChan()
 
 	rh, _ := NewRetryHelper(RetryOptions{
 		MaxDelay:   "1s",
@@ -487,6 +626,8 @@ func (br *BufferReader) NewStreamOutput(sender MessageProcessor, packSupply chan
 				if e := br.runTimerEvent(tickerPlugin); e != nil {
 					return e
 				}
+			case <-flushChan:
+				batcher.timedFlush()
 			case pack = <-packSupply:
 			}
 		} else {
@@ -497,6 +638,8 @@ func (br *BufferReader) NewStreamOutput(sender MessageProcessor, packSupply chan
 				if e := br.runTimerEvent(tickerPlugin); e != nil {
 					return e
 				}
+			case <-flushChan:
+				batcher.timedFlush()
 			default:
 			}
 		}
@@ -530,12 +673,15 @@ func (br *BufferReader) NewStreamOutput(sender MessageProcessor, packSupply chan
 					br.runner.LogError(fmt.Errorf("can't send record: %s", err))
 					// Falls through to a retry wait below.
 				default:
-					atomic.AddInt64(&br.runner.dropMessageCount, 1)
+					if !br.runner.offerFallback(pack) {
+						atomic.AddInt64(&br.runner.dropMessageCount, 1)
+					}
 					pack.recycle()
 					break sendLoop
 				}
 			} else {
 				atomic.AddInt64(&br.runner.processMessageCount, 1)
+				br.runner.delivered(pack)
 				pack.recycle()
 				break sendLoop
 			}
@@ -712,22 +858,80 @@ func (br *BufferReader) NextRecord(pack *PipelinePack) error {
 	if recordLen < headerLen {
 		return QueueInvalidRecord
 	}
-	msgLen := len(record) - headerLen
-	if cap(pack.MsgBytes) < msgLen {
-		pack.MsgBytes = make([]byte, msgLen)
+	record = record[headerLen:]
+	pack.IngestTime = 0
+	if len(record) > ingestTimeSize && record[0] == ingestTimeMarker {
+		pack.IngestTime = int64(binary.BigEndian.Uint64(record[1:ingestTimeSize]))
+		record = record[ingestTimeSize:]
+	}
+	if algorithm := compression.Detect(record); algorithm != compression.None {
+		if err = br.decompress(record, algorithm, pack); err != nil {
+			return fmt.Errorf("can't decompress record: %s", err)
+		}
 	} else {
-		pack.MsgBytes = pack.MsgBytes[:msgLen]
+		msgLen := len(record)
+		if cap(pack.MsgBytes) < msgLen {
+			pack.MsgBytes = make([]byte, msgLen)
+		} else {
+			pack.MsgBytes = pack.MsgBytes[:msgLen]
+		}
+		copy(pack.MsgBytes, record)
 	}
-	copy(pack.MsgBytes, record[headerLen:])
 	pack.TrustMsgBytes = true
 	err = proto.Unmarshal(pack.MsgBytes, pack.Message)
 	if err != nil {
 		return fmt.Errorf("can't unmarshal record: %s", err)
 	}
 	pack.QueueCursor = fmt.Sprintf("%d %d", br.readId, br.readOffset)
+	if br.maxAge > 0 &&
+		time.Since(time.Unix(0, pack.Message.GetTimestamp())) > br.maxAge {
+		// Too old to be worth delivering. The cursor isn't advanced here since
+		// the plugin might still be holding earlier records, it catches up
+		// the next time a record is processed.
+		atomic.AddInt64(&br.expiredCount, 1)
+		return QueueNeedData
+	}
+	if br.runner != nil && br.runner.isStale(pack) {
+		// Dropped by the plugin's `max_message_age`, same cursor handling as
+		// above.
+		return QueueNeedData
+	}
 	return nil
 }
 
+// Records of messages with an ingest time start with this marker followed by
+// the time as a big endian int64. It can't be the first byte of a protobuf
+// encoded message, 0x07 would be a key with the invalid wire type 7.
+const (
+	ingestTimeMarker = 0x07
+	ingestTimeSize   = 9
+)
+
+// Decompresses a compressed record into the pack's MsgBytes. Compressed
+// records are told apart from uncompressed ones by the algorithm's magic
+// number, which a message encoded by Heka can't start with since its first
+// byte is always the uuid's key, 0x0a.
+func (br *BufferReader) decompress(record []byte, algorithm string,
+	pack *PipelinePack) error {
+
+	// Guard against records that decompress to more than a message can hold.
+	msgBytes, err := br.decompressor.Decompress(pack.MsgBytes, record, algorithm,
+		int64(message.MAX_MESSAGE_SIZE))
+	if err == compression.ErrTooLarge {
+		return QueueInvalidRecord
+	} else if err != nil {
+		return err
+	}
+	pack.MsgBytes = msgBytes
+	return nil
+}
+
+// ExpiredCount returns the number of records that have been skipped because
+// they were older than the configured `max_age`.
+func (br *BufferReader) ExpiredCount() int64 {
+	return atomic.LoadInt64(&br.expiredCount)
+}
+
 func parseQueueCursor(queueCursor []byte) (id uint, offset int64, err error) {
 	idx := bytes.IndexByte(queueCursor, ' ')
 	if idx == -1 {
@@ -20,6 +20,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/bbangert/toml"
 	"github.com/gogo/protobuf/proto"
@@ -105,6 +107,23 @@ func QueueBufferSpec(c gs.Context) {
 			c.Expect(err, gs.Not(gs.IsNil))
 		})
 
+		c.Specify("parseMinFreeSpace", func() {
+			minBytes, percent, err := parseMinFreeSpace("")
+			c.Expect(err, gs.IsNil)
+			c.Expect(minBytes, gs.Equals, uint64(0))
+			c.Expect(percent, gs.Equals, float64(0))
+			minBytes, percent, err = parseMinFreeSpace("1073741824")
+			c.Expect(err, gs.IsNil)
+			c.Expect(minBytes, gs.Equals, uint64(1073741824))
+			minBytes, percent, err = parseMinFreeSpace("12.5%")
+			c.Expect(err, gs.IsNil)
+			c.Expect(percent, gs.Equals, 12.5)
+			_, _, err = parseMinFreeSpace("100%")
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, _, err = parseMinFreeSpace("1GB")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
 		c.Specify("findBufferId", func() {
 			c.Expect(findBufferId(tmpDir, true), gs.Equals, uint(0))
 			c.Expect(findBufferId(tmpDir, false), gs.Equals, uint(0))
@@ -277,6 +296,23 @@ func QueueBufferSpec(c gs.Context) {
 				c.Expect(len(queueFiles), gs.Equals, numFiles+1)
 			})
 
+			c.Specify("when free space is below min_free_space", func() {
+				// More than any filesystem the tests will run on.
+				feeder.minFreeBytes = uint64(1 << 60)
+				err = feeder.RollQueue()
+				c.Expect(err, gs.IsNil)
+
+				err = feeder.QueueRecord(newpack)
+				c.Expect(err, gs.Equals, QueueIsFull)
+				c.Expect(feeder.queueSize.Get(), gs.Equals, uint64(0))
+				c.Expect(feeder.FreeSpace() > 0, gs.IsTrue)
+
+				feeder.minFreeBytes = uint64(1)
+				err = feeder.QueueRecord(newpack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(feeder.queueSize.Get(), gs.Equals, uint64(expectedLen))
+			})
+
 			c.Specify("rolls when queue file hits max size", func() {
 				feeder.Config.MaxFileSize = uint64(300)
 				c.Assume(feeder.writeFileSize, gs.Equals, uint64(0))
@@ -301,6 +337,134 @@ func QueueBufferSpec(c gs.Context) {
 			})
 		})
 
+		c.Specify("NextRecord", func() {
+			feeder.queue = tmpDir
+			reader.queue = tmpDir
+			reader.checkpointFilename = filepath.Join(tmpDir, "cp.txt")
+			encoder := client.NewProtobufEncoder(nil)
+			queueMsg := func(payload string, timestamp time.Time) {
+				newpack := NewPipelinePack(nil)
+				newpack.Message = ts.GetTestMessage()
+				newpack.Message.SetPayload(payload)
+				newpack.Message.SetTimestamp(timestamp.UnixNano())
+				newpack.MsgBytes, err = encoder.EncodeMessage(newpack.Message)
+				c.Assume(err, gs.IsNil)
+				err = feeder.QueueRecord(newpack)
+				c.Assume(err, gs.IsNil)
+			}
+			err = feeder.RollQueue()
+			c.Assume(err, gs.IsNil)
+			queueMsg("stale", time.Now().Add(-2*time.Hour))
+			queueMsg("fresh", time.Now())
+			feeder.writeFile.Close()
+			pack := NewPipelinePack(nil)
+
+			c.Specify("returns records in order", func() {
+				err = reader.NextRecord(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "stale")
+				err = reader.NextRecord(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "fresh")
+				c.Expect(or.staleMessageCount, gs.Equals, int64(0))
+				c.Expect(reader.ExpiredCount(), gs.Equals, int64(0))
+			})
+
+			c.Specify("skips records older than max_age", func() {
+				reader.maxAge = time.Hour
+				err = reader.NextRecord(pack)
+				c.Expect(err, gs.Equals, QueueNeedData)
+				c.Expect(reader.ExpiredCount(), gs.Equals, int64(1))
+				err = reader.NextRecord(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "fresh")
+				c.Expect(reader.ExpiredCount(), gs.Equals, int64(1))
+				c.Expect(or.staleMessageCount, gs.Equals, int64(0))
+			})
+
+			c.Specify("skips records older than the output's max_message_age", func() {
+				or.maxMsgAge = time.Hour
+				err = reader.NextRecord(pack)
+				c.Expect(err, gs.Equals, QueueNeedData)
+				err = reader.NextRecord(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "fresh")
+				c.Expect(or.staleMessageCount, gs.Equals, int64(1))
+				c.Expect(reader.ExpiredCount(), gs.Equals, int64(0))
+			})
+
+			c.Specify("decompresses gzipped records", func() {
+				feeder.Config.Compression = "gzip"
+				err = feeder.RollQueue()
+				c.Assume(err, gs.IsNil)
+				long := strings.Repeat("compress me ", 100)
+				queueMsg(long, time.Now())
+				queueMsg("short", time.Now())
+				feeder.writeFile.Close()
+				fi, err := os.Stat(getQueueFilename(feeder.queue, feeder.writeId))
+				c.Assume(err, gs.IsNil)
+				c.Expect(fi.Size() < int64(len(long)), gs.IsTrue)
+
+				for _, payload := range []string{"stale", "fresh", long, "short"} {
+					err = reader.NextRecord(pack)
+					c.Expect(err, gs.IsNil)
+					c.Expect(pack.Message.GetPayload(), gs.Equals, payload)
+				}
+				// The cursor is kept in compressed file bytes.
+				c.Expect(reader.readId, gs.Equals, feeder.writeId)
+				c.Expect(reader.readOffset, gs.Equals, fi.Size())
+			})
+
+			c.Specify("decompresses records written with either algorithm", func() {
+				err = feeder.RollQueue()
+				c.Assume(err, gs.IsNil)
+				gzipped := strings.Repeat("gzip me ", 100)
+				zstded := strings.Repeat("zstd me ", 100)
+				feeder.Config.Compression = "gzip"
+				queueMsg(gzipped, time.Now())
+				// As if the setting changed while records are still buffered.
+				feeder.Config.Compression = "zstd"
+				feeder.Config.CompressionLevel = 19
+				feeder.compressor = nil
+				queueMsg(zstded, time.Now())
+				queueMsg(gzipped, time.Now())
+				feeder.writeFile.Close()
+
+				for _, payload := range []string{"stale", "fresh", gzipped, zstded, gzipped} {
+					err = reader.NextRecord(pack)
+					c.Expect(err, gs.IsNil)
+					c.Expect(pack.Message.GetPayload(), gs.Equals, payload)
+				}
+			})
+
+			c.Specify("keeps the ingest time of a record", func() {
+				err = feeder.RollQueue()
+				c.Assume(err, gs.IsNil)
+				newpack := NewPipelinePack(nil)
+				newpack.Message = ts.GetTestMessage()
+				newpack.Message.SetPayload("stamped")
+				newpack.MsgBytes, err = encoder.EncodeMessage(newpack.Message)
+				c.Assume(err, gs.IsNil)
+				newpack.IngestTime = 12345
+				err = feeder.QueueRecord(newpack)
+				c.Assume(err, gs.IsNil)
+				feeder.writeFile.Close()
+
+				for _, payload := range []string{"stale", "fresh"} {
+					err = reader.NextRecord(pack)
+					c.Expect(err, gs.IsNil)
+					c.Expect(pack.Message.GetPayload(), gs.Equals, payload)
+					c.Expect(pack.IngestTime, gs.Equals, int64(0))
+				}
+				err = reader.NextRecord(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "stamped")
+				c.Expect(pack.IngestTime, gs.Equals, int64(12345))
+			})
+
+			reader.readFile.Close()
+		})
+
 		c.Specify("getQueueBufferSize", func() {
 			c.Expect(getQueueBufferSize(tmpDir), gs.Equals, uint64(0))
 
@@ -66,6 +66,12 @@ func PopulateReportMsg(pr PluginRunner, msg *message.Message) (err error) {
 		}
 		fRunner.MatchRunner().reportLock.Unlock()
 		message.NewInt64Field(msg, "MatchAvgDuration", tmp, "ns")
+		message.NewInt64Field(msg, "MatchCount", fRunner.MatchRunner().MatchCount(),
+			"count")
+		if fRunner.MatchRunner().tap != nil {
+			message.NewInt64Field(msg, "TapCount", fRunner.MatchRunner().TapCount(),
+				"count")
+		}
 	} else if dRunner, ok := pr.(DecoderRunner); ok {
 		message.NewIntField(msg, "InChanCapacity", cap(dRunner.InChan()), "count")
 		message.NewIntField(msg, "InChanLength", len(dRunner.InChan()), "count")
@@ -141,6 +147,15 @@ func (pc *PipelineConfig) reports(reportChan chan *PipelinePack) {
 		if runner.SynchronousDecode() {
 			message.NewStringField(pack.Message, "SynchronousDecode", "true")
 		}
+		if ir, ok := runner.(*iRunner); ok && ir.fieldLimits != nil {
+			dropped, truncated := ir.fieldLimits.counts()
+			message.NewInt64Field(pack.Message, "DroppedFieldCount", dropped, "count")
+			message.NewInt64Field(pack.Message, "TruncatedFieldCount", truncated, "count")
+		}
+		if ir, ok := runner.(*iRunner); ok && ir.requireMatcher != nil {
+			message.NewInt64Field(pack.Message, "RejectedMessageCount",
+				ir.requireMatcher.rejectedCount(), "count")
+		}
 		reportChan <- pack
 	}
 	pc.inputsLock.Unlock()
@@ -201,19 +216,85 @@ func (pc *PipelineConfig) reports(reportChan chan *PipelinePack) {
 		pack = getReport(runner)
 		message.NewStringField(pack.Message, "name", name)
 		message.NewStringField(pack.Message, "key", "filters")
+		addBufferReport(runner, pack.Message)
+		addBreakerReport(runner, pack.Message)
+		addStaleReport(runner, pack.Message)
 		reportChan <- pack
 	}
 	pc.filtersLock.Unlock()
 
+	pc.outputsLock.RLock()
 	for name, runner := range pc.OutputRunners {
 		pack = getReport(runner)
 		message.NewStringField(pack.Message, "name", name)
 		message.NewStringField(pack.Message, "key", "outputs")
+		addBufferReport(runner, pack.Message)
+		addBreakerReport(runner, pack.Message)
+		addLatencyReport(runner, pack.Message)
+		addStaleReport(runner, pack.Message)
 		reportChan <- pack
 	}
+	pc.outputsLock.RUnlock()
 	close(reportChan)
 }
 
+// Adds the queue buffer stats to the report message of a filter or output
+// that's using buffering.
+func addBufferReport(runner PluginRunner, msg *message.Message) {
+	foRunner, ok := runner.(*foRunner)
+	if !ok || foRunner.bufReader == nil {
+		return
+	}
+	if foRunner.bufReader.maxAge != 0 {
+		message.NewInt64Field(msg, "ExpiredMessageCount",
+			foRunner.bufReader.ExpiredCount(), "count")
+	}
+	if foRunner.bufReader.config.MinFreeSpace != "" && foRunner.matcher != nil &&
+		foRunner.matcher.bufFeeder != nil {
+		message.NewInt64Field(msg, "BufferFreeSpace",
+			int64(foRunner.matcher.bufFeeder.FreeSpace()), "B")
+	}
+}
+
+// Adds the circuit breaker state and trip count to the report message of a
+// filter or output that has a circuit breaker.
+func addBreakerReport(runner PluginRunner, msg *message.Message) {
+	foRunner, ok := runner.(*foRunner)
+	if !ok || foRunner.breaker == nil {
+		return
+	}
+	state, tripCount := foRunner.breaker.status()
+	message.NewStringField(msg, "CircuitBreakerState", state)
+	message.NewInt64Field(msg, "CircuitBreakerTripCount", tripCount, "count")
+}
+
+// Adds the number of messages dropped for being older than `max_message_age`
+// to the report message of a filter or output with that setting.
+func addStaleReport(runner PluginRunner, msg *message.Message) {
+	foRunner, ok := runner.(*foRunner)
+	if !ok || foRunner.maxMsgAge == 0 {
+		return
+	}
+	message.NewInt64Field(msg, "StaleMessageCount",
+		atomic.LoadInt64(&foRunner.staleMessageCount), "count")
+}
+
+// Adds the pipeline latency percentiles to the report message of an output,
+// if latency tracking is on and it has received any messages.
+func addLatencyReport(runner PluginRunner, msg *message.Message) {
+	foRunner, ok := runner.(*foRunner)
+	if !ok || foRunner.latency == nil {
+		return
+	}
+	p50, p95, p99, ok := foRunner.latency.percentiles()
+	if !ok {
+		return
+	}
+	message.NewInt64Field(msg, "LatencyP50", p50, "ns")
+	message.NewInt64Field(msg, "LatencyP95", p95, "ns")
+	message.NewInt64Field(msg, "LatencyP99", p99, "ns")
+}
+
 // Use type aliases for readability.
 type pluginReportDataMap map[string]interface{}
 type fullReportDataMap map[string][]pluginReportDataMap
@@ -322,7 +403,7 @@ func (pc *PipelineConfig) FormatTextReport(report_type, payload string) string {
 
 	header := []string{
 		"InChanCapacity", "InChanLength", "MatchChanCapacity", "MatchChanLength",
-		"MatchAvgDuration", "ProcessMessageCount", "InjectMessageCount", "Memory",
+		"MatchAvgDuration", "MatchCount", "ProcessMessageCount", "InjectMessageCount", "Memory",
 		"MaxMemory", "MaxInstructions", "MaxOutput", "ProcessMessageAvgDuration",
 		"TimerEventAvgDuration", "SynchronousDecode",
 	}
@@ -15,6 +15,8 @@
 package pipeline
 
 import (
+	"sync/atomic"
+
 	"github.com/mozilla-services/heka/message"
 	ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/rafrombrc/gomock/gomock"
@@ -111,6 +113,12 @@ func ReportSpec(c gs.Context) {
 				c.Assume(ok, gs.IsTrue)
 				c.Expect(int(i), gs.Equals, leakCount)
 			})
+
+			c.Specify("has its match count set", func() {
+				val, ok := msg.GetFieldValue("MatchCount")
+				c.Assume(ok, gs.IsTrue)
+				c.Expect(val.(int64), gs.Equals, int64(0))
+			})
 		})
 
 		c.Specify("w/ an input", func() {
@@ -140,6 +148,15 @@ func ReportSpec(c gs.Context) {
 		pc.FilterRunners = map[string]FilterRunner{fName: fRunner}
 		pc.InputRunners = map[string]InputRunner{iName: iRunner}
 
+		c.Specify("returns match counts by plugin name", func() {
+			atomic.AddInt64(&fRunner.matcher.matchCount, 3)
+			count, ok := pc.MatchCount(fName)
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(count, gs.Equals, int64(3))
+			_, ok = pc.MatchCount("missing")
+			c.Expect(ok, gs.IsFalse)
+		})
+
 		c.Specify("returns full set of accurate reports", func() {
 			reportChan := make(chan *PipelinePack)
 			go pc.reports(reportChan)
@@ -0,0 +1,58 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// requireMatcher checks the messages produced by an input's decoder against
+// its `require_matcher`, so partial parses that are missing required data are
+// treated as decode failures instead of flowing downstream.
+type requireMatcher struct {
+	spec     *message.MatcherSpecification
+	rejected int64 // Decoded messages that didn't match.
+}
+
+// newRequireMatcher returns nil if no matcher is configured, so callers can
+// skip the check entirely.
+func newRequireMatcher(spec string) (*requireMatcher, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	ms, err := message.CreateMatcherSpecification(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &requireMatcher{spec: ms}, nil
+}
+
+// check returns an error if the pack's message doesn't match.
+func (rm *requireMatcher) check(pack *PipelinePack) error {
+	if rm == nil || rm.spec.Match(pack.Message) {
+		return nil
+	}
+	atomic.AddInt64(&rm.rejected, 1)
+	return fmt.Errorf("decoded message doesn't match require_matcher: %s",
+		rm.spec.String())
+}
+
+// rejectedCount returns the number of messages that failed the check so far.
+func (rm *requireMatcher) rejectedCount() int64 {
+	return atomic.LoadInt64(&rm.rejected)
+}
@@ -0,0 +1,56 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func RequireMatcherSpec(c gs.Context) {
+	c.Specify("A require matcher", func() {
+		pack := NewPipelinePack(make(chan *PipelinePack, 1))
+
+		c.Specify("isn't created when unset", func() {
+			rm, err := newRequireMatcher("")
+			c.Expect(err, gs.IsNil)
+			c.Expect(rm == nil, gs.IsTrue)
+			c.Expect(rm.check(pack), gs.IsNil)
+		})
+
+		c.Specify("rejects an invalid expression", func() {
+			_, err := newRequireMatcher("Fields[status] ==")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("passes matching messages", func() {
+			rm, err := newRequireMatcher("Fields[status] != NIL")
+			c.Assume(err, gs.IsNil)
+			message.NewIntField(pack.Message, "status", 200, "")
+			c.Expect(rm.check(pack), gs.IsNil)
+			c.Expect(rm.rejectedCount(), gs.Equals, int64(0))
+		})
+
+		c.Specify("rejects and counts messages that don't match", func() {
+			rm, err := newRequireMatcher("Fields[status] != NIL")
+			c.Assume(err, gs.IsNil)
+			err = rm.check(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(err.Error(), gs.Equals,
+				"decoded message doesn't match require_matcher: Fields[status] != NIL")
+			c.Expect(rm.rejectedCount(), gs.Equals, int64(1))
+		})
+	})
+}
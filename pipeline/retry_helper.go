@@ -21,7 +21,10 @@ import (
 	"time"
 )
 
-var ErrMaxRetriesExceeded = errors.New("Max retries exceeded")
+var (
+	ErrMaxRetriesExceeded = errors.New("Max retries exceeded")
+	ErrRetryStopped       = errors.New("Retry wait stopped")
+)
 
 // This struct provides a structure for the available retry options for a
 // RetryHelper.
@@ -99,6 +102,15 @@ func NewRetryHelper(opts RetryOptions) (helper *RetryHelper, err error) {
 //
 // If the max retries has been exceeded, an error will be returned
 func (r *RetryHelper) Wait() error {
+	return r.WaitOrStop(nil)
+}
+
+// Wait for a retry, giving up early if the stop channel is closed before the
+// delay is over.
+//
+// If the max retries has been exceeded, ErrMaxRetriesExceeded will be
+// returned, and if the wait was interrupted ErrRetryStopped will be.
+func (r *RetryHelper) WaitOrStop(stopChan chan bool) error {
 	if r.retries != -1 && r.times >= r.retries {
 		return ErrMaxRetriesExceeded
 	}
@@ -108,6 +120,9 @@ func (r *RetryHelper) Wait() error {
 	select {
 	case <-timer.C:
 		break
+	case <-stopChan:
+		timer.Stop()
+		return ErrRetryStopped
 	}
 	r.curDelay *= 2
 	r.times += 1
@@ -47,6 +47,9 @@ type MessageRouter interface {
 	// be removed from the router, the matcher channel closed and drained, the
 	// filter channel closed and drained, and the filter exited.
 	RemoveFilterMatcher() chan *MatchRunner
+	// Channel to facilitate adding a matcher to the router which starts the
+	// message flow to the associated output.
+	AddOutputMatcher() chan *MatchRunner
 	// Channel to facilitate removing an Output.  If the matcher exists it will
 	// be removed from the router, the matcher channel closed and drained, the
 	// output channel closed and drained, and the output exited.
@@ -58,6 +61,7 @@ type messageRouter struct {
 	inChan              chan *PipelinePack
 	addFilterMatcher    chan *MatchRunner
 	removeFilterMatcher chan *MatchRunner
+	addOutputMatcher    chan *MatchRunner
 	removeOutputMatcher chan *MatchRunner
 	fMatchers           []*MatchRunner
 	oMatchers           []*MatchRunner
@@ -76,6 +80,7 @@ func NewMessageRouter(chanSize int, abortChan chan struct{}) (router *messageRou
 	router.inChan = make(chan *PipelinePack, chanSize)
 	router.addFilterMatcher = make(chan *MatchRunner, 0)
 	router.removeFilterMatcher = make(chan *MatchRunner, 0)
+	router.addOutputMatcher = make(chan *MatchRunner, 0)
 	router.removeOutputMatcher = make(chan *MatchRunner, 0)
 	router.fMatcherMap = make(map[string]*MatchRunner)
 	router.oMatcherMap = make(map[string]*MatchRunner)
@@ -94,6 +99,10 @@ func (self *messageRouter) RemoveFilterMatcher() chan *MatchRunner {
 	return self.removeFilterMatcher
 }
 
+func (self *messageRouter) AddOutputMatcher() chan *MatchRunner {
+	return self.addOutputMatcher
+}
+
 func (self *messageRouter) RemoveOutputMatcher() chan *MatchRunner {
 	return self.removeOutputMatcher
 }
@@ -136,24 +145,7 @@ func (self *messageRouter) Start() {
 			select {
 			case matcher = <-self.addFilterMatcher:
 				if matcher != nil {
-					exists := false
-					available := -1
-					for i, m := range self.fMatchers {
-						if m == nil {
-							available = i
-						}
-						if matcher == m {
-							exists = true
-							break
-						}
-					}
-					if !exists {
-						if available != -1 {
-							self.fMatchers[available] = matcher
-						} else {
-							self.fMatchers = append(self.fMatchers, matcher)
-						}
-					}
+					self.fMatchers = addMatcher(self.fMatchers, matcher)
 				}
 			case matcher = <-self.removeFilterMatcher:
 				if matcher != nil {
@@ -165,6 +157,10 @@ func (self *messageRouter) Start() {
 						}
 					}
 				}
+			case matcher = <-self.addOutputMatcher:
+				if matcher != nil {
+					self.oMatchers = addMatcher(self.oMatchers, matcher)
+				}
 			case matcher = <-self.removeOutputMatcher:
 				if matcher != nil {
 					for i, m := range self.oMatchers {
@@ -202,19 +198,42 @@ func (self *messageRouter) Start() {
 			}
 		}
 		for _, matcher = range self.oMatchers {
-			matcher.Close()
+			if matcher != nil {
+				matcher.Close()
+			}
 		}
 		LogInfo.Println("MessageRouter stopped.")
 	}()
 	LogInfo.Println("MessageRouter started.")
 }
 
+// Adds the matcher to the slice unless it's already there, reusing the slot
+// of a removed matcher if there is one.
+func addMatcher(matchers []*MatchRunner, matcher *MatchRunner) []*MatchRunner {
+	available := -1
+	for i, m := range matchers {
+		if m == nil {
+			available = i
+		}
+		if matcher == m {
+			return matchers
+		}
+	}
+	if available != -1 {
+		matchers[available] = matcher
+		return matchers
+	}
+	return append(matchers, matcher)
+}
+
 // Encapsulates the mechanics of testing messages against a specific plugin's
 // message_matcher value.
 type MatchRunner struct {
 	closing       int32
 	matchSamples  int64
 	matchDuration int64
+	matchCount    int64
+	tapCount      int64
 	spec          *message.MatcherSpecification
 	signer        string
 	inChan        chan *PipelinePack
@@ -223,8 +242,10 @@ type MatchRunner struct {
 	pluginRunner  PluginRunner
 	reportLock    sync.Mutex
 	bufFeeder     *BufferFeeder
+	chunker       *messageChunker
 	globals       *GlobalConfigStruct
 	retry         *RetryHelper
+	tap           *messageTap
 }
 
 // Creates and returns a new MatchRunner if possible, or a relevant error if
@@ -277,6 +298,17 @@ func (mr *MatchRunner) GetAvgDuration() (duration int64) {
 	return
 }
 
+// Returns the number of messages the runner has matched since it started.
+func (mr *MatchRunner) MatchCount() int64 {
+	return atomic.LoadInt64(&mr.matchCount)
+}
+
+// Returns the number of messages the global tap has delivered that the
+// runner's own matcher didn't match.
+func (mr *MatchRunner) TapCount() int64 {
+	return atomic.LoadInt64(&mr.tapCount)
+}
+
 func (mr *MatchRunner) run(sampleDenom int) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -316,7 +348,7 @@ func (mr *MatchRunner) run(sampleDenom int) {
 		if counter == random {
 			startTime = time.Now()
 
-			match = mr.spec.Match(pack.Message)
+			match = mr.match(pack)
 
 			duration = time.Since(startTime).Nanoseconds()
 			mr.reportLock.Lock()
@@ -329,13 +361,28 @@ func (mr *MatchRunner) run(sampleDenom int) {
 				counter = 0
 			}
 		} else {
-			match = mr.spec.Match(pack.Message)
+			match = mr.match(pack)
 			counter++
 		}
+		tapped := false
+		if !match && mr.tap != nil {
+			tapped = mr.tap.match(pack.Message)
+			match = tapped
+		}
 
 		if match {
+			if tapped {
+				atomic.AddInt64(&mr.tapCount, 1)
+			} else {
+				atomic.AddInt64(&mr.matchCount, 1)
+			}
 			pack.diagnostics.AddStamp(mr.pluginRunner)
-			err := mr.deliver(pack)
+			var err error
+			if mr.chunker != nil {
+				err = mr.deliverChunks(pack)
+			} else {
+				err = mr.deliver(pack)
+			}
 			if err != nil {
 				mr.pluginRunner.LogError(fmt.Errorf("can't deliver matched message: %s",
 					err))
@@ -352,6 +399,19 @@ func (mr *MatchRunner) run(sampleDenom int) {
 	}
 }
 
+// Tests the pack's message against the matcher spec. If the spec has named
+// groups their captured values are attached to the pack.
+func (mr *MatchRunner) match(pack *PipelinePack) bool {
+	if !mr.spec.HasCaptures() {
+		return mr.spec.Match(pack.Message)
+	}
+	match, captures := mr.spec.MatchCaptures(pack.Message)
+	if len(captures) > 0 {
+		pack.setMatchCaptures(mr.pluginRunner.Name(), captures)
+	}
+	return match
+}
+
 // Starts the runner listening for messages on its input channel. Any message
 // that is a match will be placed on the provided matchChan, or written out to
 // the disk queue if buffering is in play. Any messages that are not a match
@@ -391,3 +451,34 @@ func (mr *MatchRunner) deliver(pack *PipelinePack) error {
 	}
 	return errors.New("no queue buffer or match chan for delivery")
 }
+
+// Splits an oversized message into chunks and delivers each of them in its
+// own pack, recycling the original. Messages that are small enough are
+// delivered as is.
+func (mr *MatchRunner) deliverChunks(pack *PipelinePack) error {
+	chunks := mr.chunker.split(pack.Message)
+	if chunks == nil {
+		return mr.deliver(pack)
+	}
+	defer pack.recycle()
+	for _, chunk := range chunks {
+		chunkPack := mr.chunker.pack()
+		chunkPack.Message = chunk
+		chunkPack.MsgLoopCount = pack.MsgLoopCount
+		chunkPack.Signer = pack.Signer
+		chunkPack.IngestTime = pack.IngestTime
+		if captures := pack.MatchCaptures(mr.pluginRunner.Name()); captures != nil {
+			chunkPack.setMatchCaptures(mr.pluginRunner.Name(), captures)
+		}
+		chunkPack.diagnostics.AddStamp(mr.pluginRunner)
+		// Buffered and protobuf encoding outputs use the message bytes.
+		if err := chunkPack.EncodeMsgBytes(); err != nil {
+			chunkPack.recycle()
+			return fmt.Errorf("can't encode message chunk: %s", err)
+		}
+		if err := mr.deliver(chunkPack); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"io"
 	"time"
 
+	"github.com/mozilla-services/heka/compression"
 	"github.com/mozilla-services/heka/message"
 	"github.com/pborman/uuid"
 )
@@ -60,6 +61,7 @@ type sRunner struct {
 	unframer        UnframingSplitter
 	ir              InputRunner
 	packDecorator   func(*PipelinePack)
+	decompressor    compression.Decompressor
 }
 
 func NewSplitterRunner(name string, splitter Splitter,
@@ -260,7 +262,23 @@ func (sr *sRunner) DeliverRecord(record []byte, del Deliverer) {
 			return
 		}
 	}
-	if sr.useMsgBytes {
+	algorithm := compression.None
+	if sr.useMsgBytes && sr.unframer != nil {
+		// Records compressed by the sender, e.g. a TcpOutput with
+		// `compression` set, start with the algorithm's magic number, which
+		// a message encoded by Heka can't start with.
+		algorithm = compression.Detect(unframed)
+	}
+	if algorithm != compression.None {
+		msgBytes, err := sr.decompressor.Decompress(pack.MsgBytes, unframed,
+			algorithm, int64(message.MAX_MESSAGE_SIZE))
+		if err != nil {
+			sr.LogError(fmt.Errorf("can't decompress record: %s", err))
+			pack.recycle()
+			return
+		}
+		pack.MsgBytes = msgBytes
+	} else if sr.useMsgBytes {
 		// Put the blob in the pack and let the decoder sort it out.
 		messageLen := len(unframed)
 		if messageLen > cap(pack.MsgBytes) {
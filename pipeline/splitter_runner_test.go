@@ -23,6 +23,9 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/client"
+	"github.com/mozilla-services/heka/compression"
 	"github.com/mozilla-services/heka/message"
 	ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/rafrombrc/gomock/gomock"
@@ -195,6 +198,33 @@ func SplitterRunnerSpec(c gs.Context) {
 			c.Expect(err, gs.Equals, io.EOF)
 		})
 
+		c.Specify("decompresses compressed records", func() {
+			ir := NewMockInputRunner(ctrl)
+			sr.SetInputRunner(ir)
+			recycleChan := make(chan *PipelinePack, 1)
+			pack := NewPipelinePack(recycleChan)
+			ir.EXPECT().InChan().Return(recycleChan).Times(2)
+			ir.EXPECT().Deliver(pack).Times(2)
+
+			msg := ts.GetTestMessage()
+			msg.SetPayload(strings.Repeat("compress me ", 100))
+			msgBytes, err := proto.Marshal(msg)
+			c.Assume(err, gs.IsNil)
+			for _, algorithm := range []string{compression.Gzip, compression.Zstd} {
+				compressor, err := compression.NewCompressor(algorithm, 0)
+				c.Assume(err, gs.IsNil)
+				compressed, err := compressor.Compress(msgBytes)
+				c.Assume(err, gs.IsNil)
+				var record []byte
+				err = client.CreateHekaStream(compressed, &record, nil)
+				c.Assume(err, gs.IsNil)
+
+				recycleChan <- pack
+				sr.DeliverRecord(record, nil)
+				c.Expect(bytes.Equal(pack.MsgBytes, msgBytes), gs.IsTrue)
+			}
+		})
+
 		c.Specify("correctly handles appends after EOF", func() {
 			half := len(b) / 2
 			reader := makeMockReader(b[:half])
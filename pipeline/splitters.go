@@ -21,11 +21,14 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"hash"
 	"regexp"
 
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/compression"
 	"github.com/mozilla-services/heka/message"
 )
 
@@ -188,6 +191,11 @@ type RegexSplitter struct {
 type RegexSplitterConfig struct {
 	Delimiter    string
 	DelimiterEOL bool `toml:"delimiter_eol"`
+	// Either "start" or "end", whether the delimiter marks the start of a
+	// record or the end of one. When set the delimiter text is kept with the
+	// record (only the capture group if there is one) and this overrides
+	// `delimiter_eol`.
+	DelimiterLocation string `toml:"delimiter_location"`
 }
 
 func (r *RegexSplitter) ConfigStruct() interface{} {
@@ -208,6 +216,22 @@ func (r *RegexSplitter) Init(config interface{}) error {
 			conf.Delimiter)
 	}
 	r.eol = conf.DelimiterEOL
+	r.captureLen = 0
+	switch conf.DelimiterLocation {
+	case "":
+		return nil
+	case "start":
+		r.eol = false
+	case "end":
+		r.eol = true
+	default:
+		return fmt.Errorf("delimiter_location must be \"start\" or \"end\": %s",
+			conf.DelimiterLocation)
+	}
+	if r.delimiter.NumSubexp() == 0 {
+		// Capture the whole delimiter so it's kept with the record.
+		r.delimiter = regexp.MustCompile("(" + conf.Delimiter + ")")
+	}
 	return nil
 }
 
@@ -234,6 +258,78 @@ func (r *RegexSplitter) FindRecord(buf []byte) (bytesRead int, record []byte) {
 	return bytesRead, record
 }
 
+// JsonSplitter splits a stream of JSON values by tracking the brace and
+// bracket depth, so that pretty-printed objects spanning many lines are
+// returned as a single record. Any data between the top level values (e.g.
+// whitespace or newlines) is discarded.
+type JsonSplitter struct {
+	compact bool
+	buf     bytes.Buffer
+}
+
+type JsonSplitterConfig struct {
+	// Whether to remove the insignificant whitespace from each record.
+	Compact bool `toml:"compact"`
+}
+
+func (j *JsonSplitter) ConfigStruct() interface{} {
+	return &JsonSplitterConfig{}
+}
+
+func (j *JsonSplitter) Init(config interface{}) error {
+	conf := config.(*JsonSplitterConfig)
+	j.compact = conf.Compact
+	return nil
+}
+
+func (j *JsonSplitter) FindRecord(buf []byte) (bytesRead int, record []byte) {
+	start := bytes.IndexAny(buf, "{[")
+	if start == -1 {
+		return len(buf), nil // nothing but data between values, discard it
+	}
+
+	var (
+		depth    int
+		inString bool
+		escaped  bool
+	)
+	for i := start; i < len(buf); i++ {
+		c := buf[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				record = buf[start : i+1]
+				if j.compact {
+					j.buf.Reset()
+					if err := json.Compact(&j.buf, record); err == nil {
+						record = j.buf.Bytes()
+					}
+				}
+				return i + 1, record
+			}
+		}
+	}
+	// Discard anything before the start of the value and read more data to
+	// find the end of it.
+	return start, nil
+}
+
 // Heka Message signer object.
 type Signer struct {
 	HmacKey string `toml:"hmac_key"`
@@ -275,6 +371,10 @@ type HekaFramingSplitter struct {
 	*HekaFramingSplitterConfig
 	header *message.Header
 	sr     SplitterRunner
+	// Used to check that framed records hold a valid message when resyncing.
+	msg *message.Message
+	// Bytes of corrupt data skipped since the last record was found.
+	skipped int
 }
 
 type HekaFramingSplitterConfig struct {
@@ -282,6 +382,9 @@ type HekaFramingSplitterConfig struct {
 	Signers     map[string]Signer `toml:"signer"`
 	UseMsgBytes bool              `toml:"use_message_bytes"`
 	SkipAuth    bool              `toml:"skip_authentication"`
+	// Whether records whose message data doesn't decode are skipped, with
+	// framing resuming at the next record separator.
+	Resync bool `toml:"resync"`
 }
 
 func (h *HekaFramingSplitter) SetSplitterRunner(sr SplitterRunner) {
@@ -297,10 +400,27 @@ func (h *HekaFramingSplitter) ConfigStruct() interface{} {
 func (h *HekaFramingSplitter) Init(config interface{}) error {
 	h.HekaFramingSplitterConfig = config.(*HekaFramingSplitterConfig)
 	h.header = &message.Header{}
+	h.msg = &message.Message{}
+	h.skipped = 0
 	return nil
 }
 
 func (h *HekaFramingSplitter) FindRecord(buf []byte) (bytesRead int, record []byte) {
+	bytesRead, record = h.findRecord(buf)
+	if record == nil {
+		h.skipped += bytesRead
+		return
+	}
+	h.skipped += bytesRead - len(record)
+	if h.skipped > 0 && h.Resync {
+		h.sr.LogError(fmt.Errorf("resynced after skipping %d bytes of corrupt data",
+			h.skipped))
+	}
+	h.skipped = 0
+	return
+}
+
+func (h *HekaFramingSplitter) findRecord(buf []byte) (bytesRead int, record []byte) {
 	bytesRead = bytes.IndexByte(buf, message.RECORD_SEPARATOR)
 	if bytesRead == -1 {
 		bytesRead = len(buf)
@@ -325,15 +445,22 @@ func (h *HekaFramingSplitter) FindRecord(buf []byte) (bytesRead int, record []by
 		if len(buf) < messageEnd {
 			return // read more data to get the remainder of the message
 		}
-		record = buf[bytesRead:messageEnd]
-		bytesRead = messageEnd
 		h.header.Reset()
-	} else {
-		var n int
-		bytesRead++                               // advance over the current record separator
-		n, record = h.FindRecord(buf[bytesRead:]) // header was invalid, look again
-		bytesRead += n
+		// Compressed messages are checked when they're decompressed.
+		data := buf[headerEnd:messageEnd]
+		if !h.Resync || compression.Detect(data) != compression.None ||
+			proto.Unmarshal(data, h.msg) == nil {
+			record = buf[bytesRead:messageEnd]
+			bytesRead = messageEnd
+			return bytesRead, record
+		}
+		// The record is corrupt, e.g. truncated with the next record's data
+		// taken as its remainder, so fall through to look again.
 	}
+	var n int
+	bytesRead++                               // advance over the current record separator
+	n, record = h.findRecord(buf[bytesRead:]) // header was invalid, look again
+	bytesRead += n
 	return bytesRead, record
 }
 
@@ -348,6 +475,11 @@ func (h *HekaFramingSplitter) UnframeRecord(framed []byte, pack *PipelinePack) [
 		}
 		if decoded && authenticateMessage(h.Signers, header, unframed) {
 			pack.Signer = header.GetHmacSigner()
+			if header.Hmac != nil {
+				// Preserve the signed header in case a passthrough decoder
+				// wants to relay the original record.
+				pack.HeaderBytes = append(pack.HeaderBytes[:0], framed[:headerLen]...)
+			}
 		} else {
 			return nil
 		}
@@ -371,4 +503,7 @@ func init() {
 	RegisterPlugin("HekaFramingSplitter", func() interface{} {
 		return &HekaFramingSplitter{}
 	})
+	RegisterPlugin("JsonSplitter", func() interface{} {
+		return &JsonSplitter{}
+	})
 }
@@ -21,6 +21,7 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"io"
+	"testing/iotest"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/mozilla-services/heka/message"
@@ -341,6 +342,55 @@ func RegexSpec(c gs.Context) {
 			c.Expect(string(sRunner.GetRemainingData()), gs.Equals, "test")
 		})
 
+		c.Specify("rejects an invalid delimiter location", func() {
+			config.DelimiterLocation = "middle"
+			err := splitter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("keeps the delimiter at the end w/ delimiter location", func() {
+			reader := bytes.NewReader(buf)
+			config.DelimiterLocation = "end"
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			n, record, err := sRunner.GetRecordFromStream(reader)
+			c.Expect(n, gs.Equals, 6)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(record), gs.Equals, "test1\n")
+			n, record, err = sRunner.GetRecordFromStream(reader)
+			c.Expect(n, gs.Equals, 7)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(record), gs.Equals, "test12\n")
+		})
+
+		c.Specify("joins multiline records split across reads", func() {
+			trace := "2016-01-01 12:00:00 ERROR boom\n" +
+				"\tat a.b(C.java:1)\n" +
+				"\tat d.e(F.java:2)"
+			data := trace + "\n2016-01-01 12:00:01 INFO ok" +
+				"\n2016-01-01 12:00:02 INFO done\n"
+			reader := iotest.OneByteReader(bytes.NewReader([]byte(data)))
+			config.Delimiter = `\n(\d{4}-\d{2}-\d{2} )`
+			config.DelimiterLocation = "start"
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			nextRecord := func() string {
+				for {
+					_, record, err := sRunner.GetRecordFromStream(reader)
+					if len(record) > 0 || err != nil {
+						return string(record)
+					}
+				}
+			}
+			c.Expect(nextRecord(), gs.Equals, trace)
+			c.Expect(nextRecord(), gs.Equals, "2016-01-01 12:00:01 INFO ok")
+			c.Expect(nextRecord(), gs.Equals, "")
+			c.Expect(string(sRunner.GetRemainingData()), gs.Equals,
+				"2016-01-01 12:00:02 INFO done\n")
+		})
+
 		c.Specify("splits w/ capture", func() {
 			reader := bytes.NewReader(buf)
 			config.Delimiter = "(\n)"
@@ -367,6 +417,75 @@ func RegexSpec(c gs.Context) {
 	})
 }
 
+func JsonSpec(c gs.Context) {
+	c.Specify("A JsonSplitter", func() {
+		splitter := &JsonSplitter{}
+		config := splitter.ConfigStruct().(*JsonSplitterConfig)
+		sRunner := makeSplitterRunner("JsonSplitter", splitter)
+		first := `{
+    "kind": "Pod",
+    "metadata": {
+        "name": "web-1",
+        "labels": {"app": "web {tier}"}
+    },
+    "status": {
+        "phase": "Running",
+        "message": "quote \" and } brace"
+    }
+}`
+		second := `[
+    1,
+    {"a": [2, 3]}
+]`
+		buf := []byte(first + "\n" + second + "\n{\n  \"partial\": ")
+
+		nextRecord := func(reader io.Reader) (record []byte, err error) {
+			for err == nil && len(record) == 0 {
+				_, record, err = sRunner.GetRecordFromStream(reader)
+			}
+			return
+		}
+
+		c.Specify("reassembles multi-line values", func() {
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			reader := bytes.NewReader(buf)
+			record, err := nextRecord(reader)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(record), gs.Equals, first)
+			record, err = nextRecord(reader)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(record), gs.Equals, second)
+			record, err = nextRecord(reader)
+			c.Expect(err, gs.Equals, io.EOF)
+			c.Expect(len(record), gs.Equals, 0)
+			c.Expect(string(sRunner.GetRemainingData()), gs.Equals, "{\n  \"partial\": ")
+		})
+
+		c.Specify("waits for the end of a value", func() {
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			n, record := splitter.FindRecord([]byte("\n\n  {\"a\": {"))
+			c.Expect(n, gs.Equals, 4)
+			c.Expect(len(record), gs.Equals, 0)
+			n, record = splitter.FindRecord([]byte("\n \n"))
+			c.Expect(n, gs.Equals, 3)
+			c.Expect(len(record), gs.Equals, 0)
+		})
+
+		c.Specify("compacts records", func() {
+			config.Compact = true
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			n, record := splitter.FindRecord(buf)
+			c.Expect(n, gs.Equals, len(first))
+			c.Expect(string(record), gs.Equals, `{"kind":"Pod","metadata":{"name":"web-1",`+
+				`"labels":{"app":"web {tier}"}},"status":{"phase":"Running",`+
+				`"message":"quote \" and } brace"}}`)
+		})
+	})
+}
+
 func encodeMessage(hbytes, mbytes []byte) (emsg []byte) {
 	emsg = make([]byte, 3+len(hbytes)+len(mbytes))
 	emsg[0] = message.RECORD_SEPARATOR
@@ -424,6 +543,40 @@ func HekaFramingSpec(c gs.Context) {
 			c.Expect(string(record), gs.Equals, string(b[5:]))
 		})
 
+		c.Specify("with a truncated record", func() {
+			r := []byte("\x1e\x02\x08\x3e\x1f\x0a\x10\x90\x1d\x56\x27\xec\x49\x4c\x8f\xba\x8e\x84\x9b\xaa\xf7\xa6\xf6\x10\xa6\x97\x8a\x8f\xb6\xc1\xae\x8e\x13\x1a\x09\x68\x65\x6b\x61\x62\x65\x6e\x63\x68\x28\x06\x3a\x03\x30\x2e\x38\x40\xbf\xe5\x01\x4a\x0a\x74\x72\x69\x6e\x6b\x2d\x78\x32\x33\x30")
+			var b []byte
+			b = append(b, r[:40]...)
+			b = append(b, r...)
+			b = append(b, r...)
+			reader := bytes.NewReader(b)
+
+			c.Specify("mis-frames the following record", func() {
+				err := splitter.Init(config)
+				c.Assume(err, gs.IsNil)
+
+				n, record, err := sRunner.GetRecordFromStream(reader)
+				c.Expect(n, gs.Equals, 67)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(record), gs.Equals, string(b[:67]))
+			})
+
+			c.Specify("resyncs at the next record when configured to", func() {
+				config.Resync = true
+				err := splitter.Init(config)
+				c.Assume(err, gs.IsNil)
+
+				n, record, err := sRunner.GetRecordFromStream(reader)
+				c.Expect(n, gs.Equals, 107) // skips the truncated record
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(record), gs.Equals, string(r))
+				n, record, err = sRunner.GetRecordFromStream(reader)
+				c.Expect(n, gs.Equals, 67)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(record), gs.Equals, string(r))
+			})
+		})
+
 		c.Specify("using authentication", func() {
 			key := "testkey"
 			config.Signers = map[string]Signer{"test_1": {key}}
@@ -27,6 +27,22 @@ func TestAllSpecs(t *testing.T) {
 	r.AddSpec(ScribbleDecoderSpec)
 	r.AddSpec(PayloadEncoderSpec)
 	r.AddSpec(RstEncoderSpec)
+	r.AddSpec(SessionizeFilterSpec)
+	r.AddSpec(RateFilterSpec)
+	r.AddSpec(DeltaFilterSpec)
+	r.AddSpec(TransitionFilterSpec)
+	r.AddSpec(UserAgentFilterSpec)
+	r.AddSpec(ReverseDnsFilterSpec)
+	r.AddSpec(BatchFilterSpec)
+	r.AddSpec(CoalesceFilterSpec)
+	r.AddSpec(DistinctCountFilterSpec)
+	r.AddSpec(JsonLinesEncoderSpec)
+	r.AddSpec(CsvEncoderSpec)
+	r.AddSpec(Rfc5424EncoderSpec)
+	r.AddSpec(SampleFilterSpec)
+	r.AddSpec(DedupFilterSpec)
+	r.AddSpec(FieldFilterSpec)
+	r.AddSpec(MessageTemplateSpec)
 
 	gospec.MainGoTest(r, t)
 }
@@ -17,11 +17,13 @@ package amqp
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"sync/atomic"
 
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	"github.com/mozilla-services/heka/plugins/tcp"
 	"github.com/streadway/amqp"
@@ -46,7 +48,8 @@ type AMQPInputConfig struct {
 	// the routing key to bind the queue to the exchange with
 	// Defaults to empty string
 	RoutingKey string `toml:"routing_key"`
-	// How many messages should be pre-fetched before message acks
+	// How many messages should be pre-fetched before message acks, i.e. the
+	// maximum number of unacked messages in flight. 0 means no limit.
 	// See http://www.rabbitmq.com/blog/2012/04/25/rabbitmq-performance-measurements-part-2/
 	// for benchmarks showing the impact of low prefetch counts
 	// Defaults to 2
@@ -86,6 +89,9 @@ type AMQPInput struct {
 	connWg  *sync.WaitGroup
 	amqpHub AMQPConnectionHub
 	stopped uint32
+
+	ackCount  int64
+	nackCount int64
 }
 
 func (ai *AMQPInput) ConfigStruct() interface{} {
@@ -107,6 +113,9 @@ func (ai *AMQPInput) ConfigStruct() interface{} {
 func (ai *AMQPInput) Init(config interface{}) (err error) {
 	conf := config.(*AMQPInputConfig)
 	ai.config = conf
+	if conf.PrefetchCount < 0 {
+		return errors.New("`prefetch_count` can't be negative")
+	}
 	var tlsConf *tls.Config = nil
 	if strings.HasPrefix(conf.URL, "amqps://") && &ai.config.Tls != nil {
 		if tlsConf, err = tcp.CreateGoTlsConfig(&ai.config.Tls); err != nil {
@@ -160,11 +169,13 @@ func (ai *AMQPInput) Init(config interface{}) (err error) {
 				return
 			}
 		}
+	}
 
-		err = ch.Qos(conf.PrefetchCount, 0, false)
-		if err != nil {
-			return
-		}
+	// QoS is a channel setting that doesn't need any permissions, so it's
+	// also applied for read only users.
+	err = ch.Qos(conf.PrefetchCount, 0, false)
+	if err != nil {
+		return
 	}
 	ai.ch = ch
 	return
@@ -206,14 +217,27 @@ func (ai *AMQPInput) Run(ir InputRunner, h PluginHelper) (err error) {
 			break
 		}
 
+		// SplitBytes doesn't return until the records have been handed off
+		// to the decoder or router, so the broker is only told we have the
+		// message once we actually do.
 		n, e = sRunner.SplitBytes(msg.Body, nil)
 		if e != nil {
 			ir.LogError(fmt.Errorf("processing message of type %s: %s", msg.Type, e.Error()))
+			// Not requeued since it would fail again, but this lets a
+			// dead letter exchange pick it up.
+			if e = msg.Nack(false, false); e != nil {
+				ir.LogError(fmt.Errorf("nacking message: %s", e))
+			}
+			atomic.AddInt64(&ai.nackCount, 1)
+			continue
 		}
 		if n > 0 && n != len(msg.Body) {
 			ir.LogError(fmt.Errorf("extra data in message of type %s dropped", msg.Type))
 		}
-		msg.Ack(false)
+		if e = msg.Ack(false); e != nil {
+			ir.LogError(fmt.Errorf("acking message: %s", e))
+		}
+		atomic.AddInt64(&ai.ackCount, 1)
 	}
 
 	if atomic.LoadUint32(&ai.stopped) == 0 {
@@ -224,6 +248,17 @@ func (ai *AMQPInput) Run(ir InputRunner, h PluginHelper) (err error) {
 	return err
 }
 
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (ai *AMQPInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "AckCount", atomic.LoadInt64(&ai.ackCount),
+		"count")
+	message.NewInt64Field(msg, "NackCount", atomic.LoadInt64(&ai.nackCount),
+		"count")
+	message.NewIntField(msg, "PrefetchCount", ai.config.PrefetchCount, "count")
+	return nil
+}
+
 func (ai *AMQPInput) CleanupForRestart() {
 	ai.amqpHub.Close(ai.config.URL, ai.connWg)
 	ai.connWg.Wait()
@@ -63,6 +63,10 @@ type AMQPOutputConfig struct {
 	Encoder string
 	// Allows us to use framing by default.
 	UseFraming bool `toml:"use_framing"`
+	// Whether to put the channel into confirm mode and wait for the broker
+	// to confirm each publish before the message is considered delivered.
+	// Defaults to false.
+	PublisherConfirms bool `toml:"publisher_confirms"`
 }
 
 type AMQPOutput struct {
@@ -80,6 +84,10 @@ type AMQPOutput struct {
 	connWg *sync.WaitGroup
 	// Hold a reference to the connection hub.
 	amqpHub AMQPConnectionHub
+	// Receive the delivery tags of acked and nacked publishes when publisher
+	// confirms are enabled.
+	ackChan  chan uint64
+	nackChan chan uint64
 }
 
 func (ao *AMQPOutput) ConfigStruct() interface{} {
@@ -123,6 +131,14 @@ func (ao *AMQPOutput) Init(config interface{}) (err error) {
 		usageWg.Done()
 		return
 	}
+	if conf.PublisherConfirms {
+		if err = ch.Confirm(false); err != nil {
+			usageWg.Done()
+			return fmt.Errorf("can't enable publisher confirms: %s", err)
+		}
+		ao.ackChan, ao.nackChan = ch.NotifyConfirm(make(chan uint64, 1),
+			make(chan uint64, 1))
+	}
 	ao.ch = ch
 	return
 }
@@ -185,6 +201,20 @@ func (ao *AMQPOutput) Run(or OutputRunner, h PluginHelper) (err error) {
 			if err != nil {
 				err = NewRetryMessageError(err.Error())
 				ok = false
+			} else if conf.PublisherConfirms {
+				// Only advance the cursor once the broker has taken
+				// responsibility for the message, otherwise have it retried.
+				select {
+				case <-ao.ackChan:
+					or.UpdateCursor(pack.QueueCursor)
+				case <-ao.nackChan:
+					pack.Recycle(NewRetryMessageError("publish nacked by broker"))
+					continue
+				case <-stopChan:
+					err = NewRetryMessageError("channel closed before publish " +
+						"was confirmed")
+					ok = false
+				}
 			} else {
 				or.UpdateCursor(pack.QueueCursor)
 			}
@@ -16,6 +16,7 @@
 package amqp
 
 import (
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -136,6 +137,51 @@ func AMQPPluginSpec(c gs.Context) {
 			c.Expect(err, gs.Not(gs.IsNil))
 		})
 
+		c.Specify("nacks a message that can't be split", func() {
+			streamChan := make(chan amqp.Delivery, 1)
+			ack := plugins_ts.NewMockAcknowledger(ctrl)
+			ack.EXPECT().Nack(gomock.Any(), false, false)
+			streamChan <- amqp.Delivery{
+				ContentType:  "text/plain",
+				Body:         []byte("This is a message"),
+				Timestamp:    time.Now(),
+				Acknowledger: ack,
+			}
+			mch.EXPECT().Consume("", "", false, false, false, false,
+				gomock.Any()).Return(streamChan, nil)
+
+			// Increase the usage since Run decrements it on close.
+			ug.Add(1)
+
+			splitCall := ith.MockSplitterRunner.EXPECT().SplitBytes(gomock.Any(),
+				nil)
+			splitCall.Do(func(recd []byte, del Deliverer) {
+				bytesChan <- recd
+			})
+			splitCall.Return(0, errors.New("split failed"))
+			ith.MockInputRunner.EXPECT().LogError(gomock.Any())
+			ith.MockSplitterRunner.EXPECT().UseMsgBytes().Return(false)
+			ith.MockSplitterRunner.EXPECT().SetPackDecorator(gomock.Any())
+			ith.MockSplitterRunner.EXPECT().Done()
+			go func() {
+				err := amqpInput.Run(ith.MockInputRunner, ith.MockHelper)
+				errChan <- err
+			}()
+
+			<-bytesChan
+			close(streamChan)
+			err = <-errChan
+			c.Expect(err, gs.Not(gs.IsNil))
+
+			msg := new(message.Message)
+			err = amqpInput.ReportMsg(msg)
+			c.Expect(err, gs.IsNil)
+			acks, _ := msg.GetFieldValue("AckCount")
+			c.Expect(acks, gs.Equals, int64(0))
+			nacks, _ := msg.GetFieldValue("NackCount")
+			c.Expect(nacks, gs.Equals, int64(1))
+		})
+
 		c.Specify("consumes a protobuf encoded message", func() {
 			encoder := client.NewProtobufEncoder(nil)
 			streamChan := make(chan amqp.Delivery, 1)
@@ -280,5 +326,63 @@ func AMQPPluginSpec(c gs.Context) {
 			err = <-errChan
 			c.Expect(err, gs.IsNil)
 		})
+
+		c.Specify("with publisher confirms", func() {
+			encoder := new(ProtobufEncoder)
+			encoder.SetPipelineConfig(pConfig)
+			encoder.Init(nil)
+			protoBytes, err := encoder.Encode(pack)
+			c.Expect(err, gs.IsNil)
+			oth.MockOutputRunner.EXPECT().Encoder().Return(encoder)
+			oth.MockOutputRunner.EXPECT().Encode(pack).Return(protoBytes, nil)
+
+			config.PublisherConfirms = true
+			ackChan := make(chan uint64, 1)
+			nackChan := make(chan uint64, 1)
+			mch.EXPECT().Confirm(false).Return(nil)
+			mch.EXPECT().NotifyConfirm(gomock.Any(), gomock.Any()).Return(ackChan,
+				nackChan)
+
+			err = amqpOutput.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			mch.EXPECT().Publish("", "test", false, false, gomock.Any()).Return(nil)
+			pack.BufferedPack = true
+			pack.DelivErrChan = make(chan error, 1)
+			inChan <- pack
+			close(inChan)
+
+			run := func() {
+				go func() {
+					err := amqpOutput.Run(oth.MockOutputRunner, oth.MockHelper)
+					errChan <- err
+				}()
+			}
+
+			c.Specify("delivers the message once it's acked", func() {
+				ackChan <- 1
+				run()
+				err = <-pack.DelivErrChan
+				c.Expect(err, gs.IsNil)
+
+				close(closeChan)
+				ug.Wait()
+				err = <-errChan
+				c.Expect(err, gs.IsNil)
+			})
+
+			c.Specify("retries the message when it's nacked", func() {
+				nackChan <- 1
+				run()
+				err = <-pack.DelivErrChan
+				_, ok := err.(RetryMessageError)
+				c.Expect(ok, gs.IsTrue)
+
+				close(closeChan)
+				ug.Wait()
+				err = <-errChan
+				c.Expect(err, gs.IsNil)
+			})
+		})
 	})
 }
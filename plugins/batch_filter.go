@@ -0,0 +1,204 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Filter that accumulates the payloads of the messages it receives and
+// injects them as a single aggregate message, either as a JSON array or
+// joined with a delimiter, once the batch is full or has been open for long
+// enough. Lets outputs feeding bulk APIs send one message per batch without
+// each of them having to implement batching.
+type BatchFilter struct {
+	conf    *BatchFilterConfig
+	timeout time.Duration
+	buf     bytes.Buffer
+	count   int
+	first   int64 // Earliest message timestamp in the batch, in ns.
+	last    int64 // Latest message timestamp in the batch, in ns.
+}
+
+// BatchFilter config struct.
+type BatchFilterConfig struct {
+	// Number of messages that triggers a batch to be emitted. Defaults to 100.
+	BatchSize int `toml:"batch_size"`
+	// Number of milliseconds after the first message of a batch is received
+	// that the batch is emitted even if it isn't full. Defaults to 5000.
+	BatchTimeout uint `toml:"batch_timeout"`
+	// How the payloads are combined, either "json_array" or "delimited".
+	// Defaults to "json_array".
+	Combine string `toml:"combine"`
+	// Separator placed between payloads when combining with "delimited".
+	// Defaults to "\n".
+	Delimiter string `toml:"delimiter"`
+	// Type to use for the emitted aggregate messages. Defaults to
+	// "heka.batch".
+	MessageType string `toml:"message_type"`
+}
+
+func (this *BatchFilter) ConfigStruct() interface{} {
+	return &BatchFilterConfig{
+		BatchSize:    100,
+		BatchTimeout: 5000,
+		Combine:      "json_array",
+		Delimiter:    "\n",
+		MessageType:  "heka.batch",
+	}
+}
+
+func (this *BatchFilter) Init(config interface{}) (err error) {
+	this.conf = config.(*BatchFilterConfig)
+	if this.conf.BatchSize < 1 {
+		return errors.New("`batch_size` must be greater than zero")
+	}
+	if this.conf.BatchTimeout == 0 {
+		return errors.New("`batch_timeout` must be greater than zero")
+	}
+	switch this.conf.Combine {
+	case "json_array", "delimited":
+	default:
+		return fmt.Errorf("invalid `combine` value '%s', must be 'json_array' "+
+			"or 'delimited'", this.conf.Combine)
+	}
+	this.timeout = time.Duration(this.conf.BatchTimeout) * time.Millisecond
+	this.reset()
+	return
+}
+
+func (this *BatchFilter) Run(fr FilterRunner, h PluginHelper) (err error) {
+	inChan := fr.InChan()
+
+	var (
+		ok           = true
+		pack         *PipelinePack
+		msgLoopCount uint
+		timer        = time.NewTimer(this.timeout)
+		// Only set while there's an open batch.
+		timeout <-chan time.Time
+	)
+	timer.Stop()
+	for ok {
+		select {
+		case pack, ok = <-inChan:
+			if !ok {
+				break
+			}
+			msgLoopCount = pack.MsgLoopCount
+			this.add(pack.Message)
+			fr.UpdateCursor(pack.QueueCursor)
+			pack.Recycle(nil)
+			if this.count == 1 {
+				timer.Reset(this.timeout)
+				timeout = timer.C
+			}
+			if this.count >= this.conf.BatchSize {
+				timer.Stop()
+				timeout = nil
+				this.emit(fr, h, msgLoopCount)
+			}
+		case <-timeout:
+			timeout = nil
+			this.emit(fr, h, msgLoopCount)
+		}
+	}
+	// Don't drop a partial batch on shutdown.
+	if this.count > 0 {
+		this.emit(fr, h, msgLoopCount)
+	}
+	return
+}
+
+func (this *BatchFilter) CleanupForRestart() {
+	this.reset()
+}
+
+func (this *BatchFilter) reset() {
+	this.buf.Reset()
+	this.count = 0
+	this.first = 0
+	this.last = 0
+	if this.conf.Combine == "json_array" {
+		this.buf.WriteByte('[')
+	}
+}
+
+// Adds the message's payload to the current batch. Payloads that aren't valid
+// JSON are added to JSON arrays as strings.
+func (this *BatchFilter) add(msg *message.Message) {
+	payload := msg.GetPayload()
+	if this.conf.Combine == "json_array" {
+		if this.count > 0 {
+			this.buf.WriteByte(',')
+		}
+		size := this.buf.Len()
+		if err := json.Compact(&this.buf, []byte(payload)); err != nil {
+			this.buf.Truncate(size)
+			quoted, _ := json.Marshal(payload)
+			this.buf.Write(quoted)
+		}
+	} else {
+		if this.count > 0 {
+			this.buf.WriteString(this.conf.Delimiter)
+		}
+		this.buf.WriteString(payload)
+	}
+
+	ts := msg.GetTimestamp()
+	if this.count == 0 || ts < this.first {
+		this.first = ts
+	}
+	if this.count == 0 || ts > this.last {
+		this.last = ts
+	}
+	this.count++
+}
+
+// Injects the aggregate message for the current batch and starts a new one.
+func (this *BatchFilter) emit(fr FilterRunner, h PluginHelper, msgLoopCount uint) {
+	defer this.reset()
+	if this.count == 0 {
+		return
+	}
+	pack, e := h.PipelinePack(msgLoopCount)
+	if e != nil {
+		fr.LogError(e)
+		return
+	}
+	if this.conf.Combine == "json_array" {
+		this.buf.WriteByte(']')
+	}
+	pack.Message.SetLogger(fr.Name())
+	pack.Message.SetType(this.conf.MessageType)
+	pack.Message.SetPayload(this.buf.String())
+	message.NewIntField(pack.Message, "count", this.count, "count")
+	message.NewInt64Field(pack.Message, "first_timestamp", this.first, "ns")
+	message.NewInt64Field(pack.Message, "last_timestamp", this.last, "ns")
+	fr.Inject(pack)
+}
+
+func init() {
+	RegisterPlugin("BatchFilter", func() interface{} {
+		return new(BatchFilter)
+	})
+}
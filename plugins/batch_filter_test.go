@@ -0,0 +1,155 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	pm "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func BatchFilterSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newMsg := func(payload string, ts int64) *message.Message {
+		msg := pipeline_ts.GetTestMessage()
+		msg.SetPayload(payload)
+		msg.SetTimestamp(ts)
+		return msg
+	}
+
+	c.Specify("A BatchFilter", func() {
+		filter := new(BatchFilter)
+		config := filter.ConfigStruct().(*BatchFilterConfig)
+		fr := pm.NewMockFilterRunner(ctrl)
+		h := pm.NewMockPluginHelper(ctrl)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		c.Specify("rejects an unknown combine strategy", func() {
+			config.Combine = "zip"
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("combines payloads into a JSON array", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.add(newMsg(`{"a": 1}`, 3000))
+			filter.add(newMsg("not json", 1000))
+			filter.add(newMsg("[1, 2]", 2000))
+
+			h.EXPECT().PipelinePack(uint(0)).Return(pack, nil)
+			fr.EXPECT().Name().Return("batcher")
+			fr.EXPECT().Inject(pack).Return(true)
+			filter.emit(fr, h, 0)
+
+			msg := pack.Message
+			c.Expect(msg.GetType(), gs.Equals, "heka.batch")
+			c.Expect(msg.GetLogger(), gs.Equals, "batcher")
+			c.Expect(msg.GetPayload(), gs.Equals, `[{"a":1},"not json",[1,2]]`)
+			val, _ := msg.GetFieldValue("count")
+			c.Expect(val.(int64), gs.Equals, int64(3))
+			val, _ = msg.GetFieldValue("first_timestamp")
+			c.Expect(val.(int64), gs.Equals, int64(1000))
+			val, _ = msg.GetFieldValue("last_timestamp")
+			c.Expect(val.(int64), gs.Equals, int64(3000))
+			c.Expect(filter.count, gs.Equals, 0)
+			c.Expect(filter.buf.String(), gs.Equals, "[")
+		})
+
+		c.Specify("joins payloads with a delimiter", func() {
+			config.Combine = "delimited"
+			config.Delimiter = "|"
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.add(newMsg("one", 1000))
+			filter.add(newMsg("two", 2000))
+
+			h.EXPECT().PipelinePack(uint(0)).Return(pack, nil)
+			fr.EXPECT().Name().Return("batcher")
+			fr.EXPECT().Inject(pack).Return(true)
+			filter.emit(fr, h, 0)
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "one|two")
+		})
+
+		c.Specify("doesn't emit an empty batch", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.emit(fr, h, 0)
+		})
+
+		c.Specify("when running", func() {
+			inChan := make(chan *PipelinePack, 5)
+			fr.EXPECT().InChan().Return(inChan)
+			fr.EXPECT().UpdateCursor(gomock.Any()).AnyTimes()
+			fr.EXPECT().Name().Return("batcher").AnyTimes()
+			recycle := make(chan *PipelinePack, 5)
+			send := func(payload string) {
+				p := NewPipelinePack(recycle)
+				p.Message = newMsg(payload, 1000)
+				inChan <- p
+			}
+			injected := make(chan string, 5)
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected <- p.Message.GetPayload()
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(gomock.Any()).Return(pack, nil).AnyTimes()
+			done := make(chan error)
+
+			c.Specify("emits full batches", func() {
+				config.BatchSize = 2
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				go func() {
+					done <- filter.Run(fr, h)
+				}()
+				send("1")
+				send("2")
+				c.Expect(<-injected, gs.Equals, "[1,2]")
+				send("3")
+				close(inChan)
+				c.Expect(<-done, gs.IsNil)
+				c.Expect(<-injected, gs.Equals, "[3]")
+			})
+
+			c.Specify("emits partial batches after the timeout", func() {
+				config.BatchTimeout = 10
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				go func() {
+					done <- filter.Run(fr, h)
+				}()
+				send("1")
+				select {
+				case payload := <-injected:
+					c.Expect(payload, gs.Equals, "[1]")
+				case <-time.After(time.Second):
+					c.Expect("timed out", gs.Equals, "")
+				}
+				close(inChan)
+				c.Expect(<-done, gs.IsNil)
+				c.Expect(len(injected), gs.Equals, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,234 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"container/list"
+	"errors"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/pborman/uuid"
+)
+
+// Repeat suppression state for a single fingerprint.
+type coalesceState struct {
+	fingerprint uint64
+	msg         *message.Message // Copy of the message that opened the window.
+	opened      time.Time        // Wall clock time the window was opened.
+	repeats     int64
+	lastRepeat  int64 // Timestamp of the most recent repeat, in ns.
+	elem        *list.Element
+}
+
+// Filter that passes on the first of a run of identical messages right away,
+// suppresses any identical messages during the following window, and then
+// emits a summary with the number of suppressed repeats, like syslog's "last
+// message repeated N times".
+type CoalesceFilter struct {
+	conf   *CoalesceFilterConfig
+	window time.Duration
+	states map[uint64]*coalesceState
+	// States ordered by when their window was opened, oldest first. Windows
+	// all have the same length, so this is also the order they close in.
+	order *list.List
+}
+
+// CoalesceFilter config struct.
+type CoalesceFilterConfig struct {
+	// Message attributes whose values together determine whether two
+	// messages are identical. Supports "Type", "Logger", "Hostname",
+	// "Severity", "Payload", and any dynamic field name. Defaults to all of
+	// those headers.
+	FingerprintFields []string `toml:"fingerprint_fields"`
+	// Number of seconds identical messages are suppressed for after the
+	// first one. Defaults to 60.
+	Window uint `toml:"window"`
+	// Maximum number of fingerprints to track. When this is exceeded the
+	// oldest window is closed early. Defaults to 10000.
+	MaxKeys int `toml:"max_keys"`
+	// Type to use for the passed on first messages. Defaults to
+	// "heka.coalesce".
+	MessageType string `toml:"message_type"`
+	// Type to use for the repeat summary messages. Defaults to
+	// "heka.coalesce.summary".
+	SummaryType string `toml:"summary_type"`
+	// How often windows are checked for having closed, in seconds. Defaults
+	// to 5.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+func (this *CoalesceFilter) ConfigStruct() interface{} {
+	return &CoalesceFilterConfig{
+		FingerprintFields: []string{"Type", "Logger", "Hostname", "Severity", "Payload"},
+		Window:            60,
+		MaxKeys:           10000,
+		MessageType:       "heka.coalesce",
+		SummaryType:       "heka.coalesce.summary",
+		TickerInterval:    uint(5),
+	}
+}
+
+func (this *CoalesceFilter) Init(config interface{}) (err error) {
+	this.conf = config.(*CoalesceFilterConfig)
+	if len(this.conf.FingerprintFields) == 0 {
+		return errors.New("`fingerprint_fields` must not be empty")
+	}
+	if this.conf.Window == 0 {
+		return errors.New("`window` must be greater than zero")
+	}
+	if this.conf.MaxKeys < 1 {
+		return errors.New("`max_keys` must be greater than zero")
+	}
+	this.window = time.Duration(this.conf.Window) * time.Second
+	this.states = make(map[uint64]*coalesceState)
+	this.order = list.New()
+	return
+}
+
+func (this *CoalesceFilter) Run(fr FilterRunner, h PluginHelper) (err error) {
+	inChan := fr.InChan()
+	ticker := fr.Ticker()
+
+	var (
+		ok   = true
+		pack *PipelinePack
+	)
+	for ok {
+		select {
+		case pack, ok = <-inChan:
+			if !ok {
+				break
+			}
+			now := time.Now()
+			for _, s := range this.expire(now) {
+				this.emitSummary(fr, h, s, pack.MsgLoopCount)
+			}
+			first, evicted := this.addMessage(pack.Message, now)
+			if evicted != nil {
+				this.emitSummary(fr, h, evicted, pack.MsgLoopCount)
+			}
+			if first {
+				this.emitFirst(fr, h, pack.Message, pack.MsgLoopCount)
+			}
+			fr.UpdateCursor(pack.QueueCursor)
+			pack.Recycle(nil)
+		case <-ticker:
+			for _, s := range this.expire(time.Now()) {
+				this.emitSummary(fr, h, s, 0)
+			}
+		}
+	}
+	// Don't lose the counts of the windows that are still open.
+	for _, s := range this.expire(time.Time{}) {
+		this.emitSummary(fr, h, s, 0)
+	}
+	return
+}
+
+func (this *CoalesceFilter) CleanupForRestart() {
+	this.states = make(map[uint64]*coalesceState)
+	this.order.Init()
+}
+
+// Records the message, returning whether it's the first of its window and so
+// should be passed on. If a window had to be closed early to make room for a
+// new one its state is returned so its summary can be emitted.
+func (this *CoalesceFilter) addMessage(msg *message.Message, now time.Time) (
+	first bool, evicted *coalesceState) {
+
+	fp := messageHash(msg, this.conf.FingerprintFields)
+	if s, ok := this.states[fp]; ok {
+		s.repeats++
+		s.lastRepeat = msg.GetTimestamp()
+		return false, nil
+	}
+	if len(this.states) >= this.conf.MaxKeys {
+		evicted = this.order.Front().Value.(*coalesceState)
+		this.remove(evicted)
+	}
+	s := &coalesceState{
+		fingerprint: fp,
+		msg:         message.CopyMessage(msg),
+		opened:      now,
+	}
+	s.elem = this.order.PushBack(s)
+	this.states[fp] = s
+	return true, evicted
+}
+
+// Closes all of the windows that are over at the given time, or all of them
+// if it's the zero time, returning the states of those that had any repeats.
+func (this *CoalesceFilter) expire(now time.Time) (closed []*coalesceState) {
+	for e := this.order.Front(); e != nil; e = this.order.Front() {
+		s := e.Value.(*coalesceState)
+		if !now.IsZero() && now.Sub(s.opened) < this.window {
+			break
+		}
+		this.remove(s)
+		if s.repeats > 0 {
+			closed = append(closed, s)
+		}
+	}
+	return
+}
+
+func (this *CoalesceFilter) remove(s *coalesceState) {
+	this.order.Remove(s.elem)
+	delete(this.states, s.fingerprint)
+}
+
+// Injects a copy of the message with the filter's message type.
+func (this *CoalesceFilter) emitFirst(fr FilterRunner, h PluginHelper,
+	msg *message.Message, msgLoopCount uint) {
+
+	pack, e := h.PipelinePack(msgLoopCount)
+	if e != nil {
+		fr.LogError(e)
+		return
+	}
+	msg.Copy(pack.Message)
+	pack.Message.SetUuid(uuid.NewRandom())
+	pack.Message.SetType(this.conf.MessageType)
+	message.NewStringField(pack.Message, "original_type", msg.GetType())
+	fr.Inject(pack)
+}
+
+// Injects the repeat summary for a closed window, which is a copy of the
+// message that opened it with the summary type and the repeat count.
+func (this *CoalesceFilter) emitSummary(fr FilterRunner, h PluginHelper,
+	s *coalesceState, msgLoopCount uint) {
+
+	pack, e := h.PipelinePack(msgLoopCount)
+	if e != nil {
+		fr.LogError(e)
+		return
+	}
+	s.msg.Copy(pack.Message)
+	pack.Message.SetUuid(uuid.NewRandom())
+	pack.Message.SetType(this.conf.SummaryType)
+	pack.Message.SetTimestamp(s.lastRepeat)
+	message.NewStringField(pack.Message, "original_type", s.msg.GetType())
+	message.NewInt64Field(pack.Message, "repeat_count", s.repeats, "count")
+	message.NewInt64Field(pack.Message, "first_timestamp", s.msg.GetTimestamp(), "ns")
+	fr.Inject(pack)
+}
+
+func init() {
+	RegisterPlugin("CoalesceFilter", func() interface{} {
+		return new(CoalesceFilter)
+	})
+}
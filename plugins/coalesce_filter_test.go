@@ -0,0 +1,140 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	pm "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CoalesceFilterSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newMsg := func(host, payload string, ts int64) *message.Message {
+		msg := pipeline_ts.GetTestMessage()
+		msg.SetHostname(host)
+		msg.SetPayload(payload)
+		msg.SetTimestamp(ts)
+		return msg
+	}
+
+	c.Specify("A CoalesceFilter", func() {
+		filter := new(CoalesceFilter)
+		config := filter.ConfigStruct().(*CoalesceFilterConfig)
+		now := time.Now()
+
+		c.Specify("requires fingerprint fields", func() {
+			config.FingerprintFields = nil
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("passes on the first message and counts repeats", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			first, _ := filter.addMessage(newMsg("a", "disk full", 10), now)
+			c.Expect(first, gs.IsTrue)
+			first, _ = filter.addMessage(newMsg("a", "disk full", 20), now)
+			c.Expect(first, gs.IsFalse)
+			first, _ = filter.addMessage(newMsg("a", "disk full", 30), now)
+			c.Expect(first, gs.IsFalse)
+			first, _ = filter.addMessage(newMsg("b", "disk full", 30), now)
+			c.Expect(first, gs.IsTrue)
+			first, _ = filter.addMessage(newMsg("a", "disk ok", 30), now)
+			c.Expect(first, gs.IsTrue)
+
+			c.Expect(len(filter.expire(now.Add(30*time.Second))), gs.Equals, 0)
+			closed := filter.expire(now.Add(time.Minute))
+			c.Assume(len(closed), gs.Equals, 1)
+			c.Expect(closed[0].repeats, gs.Equals, int64(2))
+			c.Expect(closed[0].lastRepeat, gs.Equals, int64(30))
+			c.Expect(closed[0].msg.GetPayload(), gs.Equals, "disk full")
+			c.Expect(len(filter.states), gs.Equals, 0)
+
+			// A new window is opened once the previous one is closed.
+			first, _ = filter.addMessage(newMsg("a", "disk full", 40), now)
+			c.Expect(first, gs.IsTrue)
+		})
+
+		c.Specify("uses the fingerprint fields", func() {
+			config.FingerprintFields = []string{"Payload"}
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", "disk full", 10), now)
+			first, _ := filter.addMessage(newMsg("b", "disk full", 20), now)
+			c.Expect(first, gs.IsFalse)
+		})
+
+		c.Specify("closes the oldest window past max_keys", func() {
+			config.MaxKeys = 2
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", "x", 10), now)
+			filter.addMessage(newMsg("a", "x", 20), now)
+			filter.addMessage(newMsg("b", "x", 20), now)
+			_, evicted := filter.addMessage(newMsg("c", "x", 30), now)
+			c.Assume(evicted, gs.Not(gs.IsNil))
+			c.Expect(evicted.msg.GetHostname(), gs.Equals, "a")
+			c.Expect(evicted.repeats, gs.Equals, int64(1))
+			c.Expect(len(filter.states), gs.Equals, 2)
+		})
+
+		c.Specify("emits", func() {
+			fr := pm.NewMockFilterRunner(ctrl)
+			h := pm.NewMockPluginHelper(ctrl)
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			msg := newMsg("a", "disk full", 10)
+
+			supply := make(chan *PipelinePack, 1)
+			pack := NewPipelinePack(supply)
+			h.EXPECT().PipelinePack(uint(0)).Return(pack, nil)
+			fr.EXPECT().Inject(pack).Return(true)
+
+			c.Specify("a copy of the first message", func() {
+				filter.emitFirst(fr, h, msg, 0)
+				c.Expect(pack.Message.GetType(), gs.Equals, "heka.coalesce")
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "disk full")
+				c.Expect(pack.Message.GetHostname(), gs.Equals, "a")
+				c.Expect(pack.Message.GetUuidString() != msg.GetUuidString(), gs.IsTrue)
+				val, _ := pack.Message.GetFieldValue("original_type")
+				c.Expect(val, gs.Equals, msg.GetType())
+			})
+
+			c.Specify("a repeat summary", func() {
+				filter.addMessage(msg, now)
+				filter.addMessage(newMsg("a", "disk full", 20), now)
+				closed := filter.expire(time.Time{})
+				c.Assume(len(closed), gs.Equals, 1)
+				filter.emitSummary(fr, h, closed[0], 0)
+				c.Expect(pack.Message.GetType(), gs.Equals, "heka.coalesce.summary")
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "disk full")
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(20))
+				val, _ := pack.Message.GetFieldValue("repeat_count")
+				c.Expect(val, gs.Equals, int64(1))
+				val, _ = pack.Message.GetFieldValue("first_timestamp")
+				c.Expect(val, gs.Equals, int64(10))
+			})
+		})
+	})
+}
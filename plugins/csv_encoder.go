@@ -0,0 +1,191 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+// CsvEncoder serializes each message as an RFC 4180 CSV row, with a column
+// for each of the configured message headers and fields.
+type CsvEncoder struct {
+	config  *CsvEncoderConfig
+	columns []csvColumn
+	header  []byte
+	// Set once the header has been prepended to a record, or an output has
+	// said it writes the header itself.
+	headerDone bool
+}
+
+type CsvEncoderConfig struct {
+	// Ordered list of the columns, each either the name of a message header
+	// ("Timestamp", "Uuid", "Type", "Logger", "Severity", "Payload",
+	// "EnvVersion", "Pid", or "Hostname") or a dynamic field reference like
+	// "Fields[status]". Required.
+	Columns []string `toml:"columns"`
+	// Whether to write a header row with the column names. Defaults to false.
+	PrintHeader bool `toml:"print_header"`
+	// Whether rows end with "\r\n", as RFC 4180 specifies, instead of "\n".
+	// Defaults to true.
+	UseCrlf bool `toml:"use_crlf"`
+}
+
+// A single output column, either a message header or a dynamic field.
+type csvColumn struct {
+	name  string
+	field bool
+}
+
+var csvHeaderColumns = map[string]bool{
+	"Timestamp":  true,
+	"Uuid":       true,
+	"Type":       true,
+	"Logger":     true,
+	"Severity":   true,
+	"Payload":    true,
+	"EnvVersion": true,
+	"Pid":        true,
+	"Hostname":   true,
+}
+
+func (ce *CsvEncoder) ConfigStruct() interface{} {
+	return &CsvEncoderConfig{
+		UseCrlf: true,
+	}
+}
+
+func (ce *CsvEncoder) Init(config interface{}) (err error) {
+	ce.config = config.(*CsvEncoderConfig)
+	if len(ce.config.Columns) == 0 {
+		return errors.New("`columns` must not be empty")
+	}
+	ce.columns = make([]csvColumn, len(ce.config.Columns))
+	names := make([]string, len(ce.config.Columns))
+	for i, spec := range ce.config.Columns {
+		if csvHeaderColumns[spec] {
+			ce.columns[i] = csvColumn{name: spec}
+		} else if strings.HasPrefix(spec, "Fields[") && strings.HasSuffix(spec, "]") &&
+			len(spec) > len("Fields[]") {
+
+			ce.columns[i] = csvColumn{name: spec[len("Fields[") : len(spec)-1], field: true}
+		} else {
+			return fmt.Errorf("invalid column: %s", spec)
+		}
+		names[i] = ce.columns[i].name
+	}
+	ce.headerDone = false
+	ce.header = nil
+	if ce.config.PrintHeader {
+		if ce.header, err = ce.row(names); err != nil {
+			return fmt.Errorf("can't encode header: %s", err)
+		}
+	}
+	return
+}
+
+// Encodes a single row.
+func (ce *CsvEncoder) row(values []string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	w.UseCRLF = ce.config.UseCrlf
+	if err := w.Write(values); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (ce *CsvEncoder) Header() []byte {
+	return ce.header
+}
+
+func (ce *CsvEncoder) OutputWritesHeader() {
+	ce.headerDone = true
+}
+
+// Returns the first value of the message's field as a string, or "" if the
+// message doesn't have the field.
+func csvFieldValue(msg *message.Message, name string) string {
+	value, ok := msg.GetFieldValue(name)
+	if !ok {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func (ce *CsvEncoder) Encode(pack *pipeline.PipelinePack) (output []byte, err error) {
+	msg := pack.Message
+	values := make([]string, len(ce.columns))
+	for i, col := range ce.columns {
+		if col.field {
+			values[i] = csvFieldValue(msg, col.name)
+			continue
+		}
+		switch col.name {
+		case "Timestamp":
+			values[i] = time.Unix(0, msg.GetTimestamp()).UTC().Format(time.RFC3339Nano)
+		case "Uuid":
+			values[i] = msg.GetUuidString()
+		case "Type":
+			values[i] = msg.GetType()
+		case "Logger":
+			values[i] = msg.GetLogger()
+		case "Severity":
+			values[i] = strconv.Itoa(int(msg.GetSeverity()))
+		case "Payload":
+			values[i] = msg.GetPayload()
+		case "EnvVersion":
+			values[i] = msg.GetEnvVersion()
+		case "Pid":
+			values[i] = strconv.Itoa(int(msg.GetPid()))
+		case "Hostname":
+			values[i] = msg.GetHostname()
+		}
+	}
+	if output, err = ce.row(values); err != nil {
+		return nil, err
+	}
+	if !ce.headerDone && ce.header != nil {
+		output = append(append([]byte{}, ce.header...), output...)
+	}
+	ce.headerDone = true
+	return output, nil
+}
+
+func init() {
+	pipeline.RegisterPlugin("CsvEncoder", func() interface{} {
+		return new(CsvEncoder)
+	})
+}
@@ -0,0 +1,103 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CsvEncoderSpec(c gs.Context) {
+
+	c.Specify("A CsvEncoder", func() {
+		encoder := new(CsvEncoder)
+		config := encoder.ConfigStruct().(*CsvEncoderConfig)
+		supply := make(chan *pipeline.PipelinePack, 1)
+
+		pack := pipeline.NewPipelinePack(supply)
+		pack.Message.SetPayload("multi\nline, \"quoted\" payload")
+		timestamp := time.Date(2016, 3, 1, 12, 30, 0, 500, time.UTC)
+		pack.Message.SetTimestamp(timestamp.UnixNano())
+		pack.Message.SetType("test.type")
+		pack.Message.SetSeverity(4)
+		message.NewStringField(pack.Message, "user", "bob")
+		message.NewInt64Field(pack.Message, "status", 404, "")
+
+		config.Columns = []string{"Timestamp", "Type", "Severity", "Fields[status]",
+			"Fields[missing]", "Payload"}
+
+		c.Specify("rejects invalid columns", func() {
+			config.Columns = []string{"Type", "Fields[]"}
+			err := encoder.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+			config.Columns = []string{"Bogus"}
+			err = encoder.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+			config.Columns = nil
+			err = encoder.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		// Line breaks within values are written as the configured line
+		// ending, like the row endings.
+		c.Specify("encodes a row per message, quoting as needed", func() {
+			err := encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(encoder.Header()), gs.Equals, 0)
+			output, err := encoder.Encode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(output), gs.Equals,
+				"2016-03-01T12:30:00.0000005Z,test.type,4,404,,"+
+					"\"multi\r\nline, \"\"quoted\"\" payload\"\r\n")
+		})
+
+		c.Specify("uses bare newlines if configured to", func() {
+			config.Columns = []string{"Type", "Fields[user]"}
+			config.UseCrlf = false
+			err := encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(output), gs.Equals, "test.type,bob\n")
+		})
+
+		c.Specify("with a header", func() {
+			config.Columns = []string{"Type", "Fields[user]"}
+			config.PrintHeader = true
+			err := encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(string(encoder.Header()), gs.Equals, "Type,user\r\n")
+
+			c.Specify("prepends it to the first record only", func() {
+				output, err := encoder.Encode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(output), gs.Equals, "Type,user\r\ntest.type,bob\r\n")
+				output, err = encoder.Encode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(output), gs.Equals, "test.type,bob\r\n")
+			})
+
+			c.Specify("leaves it to outputs that write it themselves", func() {
+				encoder.OutputWritesHeader()
+				output, err := encoder.Encode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(output), gs.Equals, "test.type,bob\r\n")
+			})
+		})
+	})
+}
@@ -74,6 +74,9 @@ type DashboardOutput struct {
 	handler          http.Handler
 	pConfig          *PipelineConfig
 	starterFunc      func(output *DashboardOutput) error
+	// Latest report data in the Prometheus text format, served at /metrics.
+	metrics     []byte
+	metricsLock sync.RWMutex
 }
 
 // Heka will call this before calling any other methods to give us access to
@@ -154,9 +157,14 @@ func (self *DashboardOutput) Init(config interface{}) (err error) {
 		}
 		self.handler = http.FileServer(http.Dir(self.workingDirectory))
 	}
+	mux := http.NewServeMux()
+	mux.Handle("/", self.handler)
+	mux.HandleFunc("/version", self.serveVersion)
+	mux.HandleFunc("/metrics", self.serveMetrics)
+	mux.HandleFunc("/config", self.serveConfig)
 	self.server = &http.Server{
 		Addr:         conf.Address,
-		Handler:      httpPlugin.CustomHeadersHandler(self.handler, conf.Headers),
+		Handler:      httpPlugin.CustomHeadersHandler(mux, conf.Headers),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
@@ -164,6 +172,34 @@ func (self *DashboardOutput) Init(config interface{}) (err error) {
 	return
 }
 
+// Serves the hekad version and config hash as JSON.
+func (self *DashboardOutput) serveVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(self.pConfig.Globals.DaemonInfo()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Serves the plugin report data from the latest `heka.all-report` message in
+// the Prometheus text exposition format.
+func (self *DashboardOutput) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	self.metricsLock.RLock()
+	metrics := self.metrics
+	self.metricsLock.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(metrics)
+}
+
+// Serves the effective config of all of the loaded plugins as JSON, with
+// credentials redacted.
+func (self *DashboardOutput) serveConfig(w http.ResponseWriter, r *http.Request) {
+	configs := renderPluginConfigs(self.pConfig.PluginMakers())
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(configs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func (self *DashboardOutput) Run(or OutputRunner, h PluginHelper) (err error) {
 	inChan := or.InChan()
 	ticker := or.Ticker()
@@ -191,6 +227,13 @@ func (self *DashboardOutput) Run(or OutputRunner, h PluginHelper) (err error) {
 			case "heka.all-report":
 				fn := filepath.Join(self.dataDirectory, "heka_report.json")
 				overwriteFile(fn, msg.GetPayload())
+				if metrics, err := renderPrometheusMetrics(msg.GetPayload()); err != nil {
+					or.LogError(err)
+				} else {
+					self.metricsLock.Lock()
+					self.metrics = metrics
+					self.metricsLock.Unlock()
+				}
 				sbxsLock.Lock()
 				if err := overwritePluginListFile(self.dataDirectory, sandboxes); err != nil {
 					or.LogError(fmt.Errorf("Can't write plugin list file to '%s': %s",
@@ -15,12 +15,14 @@
 package dasher
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -37,6 +39,8 @@ func TestAllSpecs(t *testing.T) {
 	r.Parallel = false
 
 	r.AddSpec(DashboardOutputSpec)
+	r.AddSpec(PluginConfigSpec)
+	r.AddSpec(PrometheusMetricsSpec)
 
 	gs.MainGoTest(r, t)
 }
@@ -133,6 +137,63 @@ func DashboardOutputSpec(c gs.Context) {
 					c.Expect(eq, gs.IsTrue)
 				})
 
+				c.Specify("serves the version", func() {
+					pConfig.Globals.Version = "0.11.0"
+					pConfig.Globals.ConfigHash = "abc123"
+					err = dashboardOutput.Init(config)
+					c.Assume(err, gs.IsNil)
+					ts.Config = dashboardOutput.server
+
+					startOutput()
+
+					inChan <- pack
+					<-startedChan
+					resp, err := http.Get(ts.URL + "/version")
+					c.Assume(err, gs.IsNil)
+					defer resp.Body.Close()
+					c.Assume(resp.StatusCode, gs.Equals, 200)
+
+					var info pipeline.DaemonInfo
+					err = json.NewDecoder(resp.Body).Decode(&info)
+					c.Assume(err, gs.IsNil)
+					c.Expect(info.Version, gs.Equals, "0.11.0")
+					c.Expect(info.ConfigHash, gs.Equals, "abc123")
+				})
+
+				c.Specify("serves report data for Prometheus", func() {
+					err = dashboardOutput.Init(config)
+					c.Assume(err, gs.IsNil)
+					ts.Config = dashboardOutput.server
+
+					pack.Message.SetType("heka.all-report")
+					pack.Message.SetPayload(`{"outputs": [{"Name": "LogOutput",
+						"ProcessMessageCount": {"value": 5, "representation": "count"}}]}`)
+
+					startOutput()
+
+					inChan <- pack
+					<-startedChan
+					// Wait for the report to be processed.
+					for i := 0; i < 100; i++ {
+						dashboardOutput.metricsLock.RLock()
+						done := dashboardOutput.metrics != nil
+						dashboardOutput.metricsLock.RUnlock()
+						if done {
+							break
+						}
+						time.Sleep(10 * time.Millisecond)
+					}
+					resp, err := http.Get(ts.URL + "/metrics")
+					c.Assume(err, gs.IsNil)
+					defer resp.Body.Close()
+					c.Assume(resp.StatusCode, gs.Equals, 200)
+					body, err := ioutil.ReadAll(resp.Body)
+					c.Assume(err, gs.IsNil)
+					c.Expect(strings.Contains(string(body),
+						`heka_log_output_process_message_count_total{plugin_type="outputs"} 5`),
+						gs.IsTrue)
+				})
+
 				close(inChan)
 				c.Expect(<-errChan, gs.IsNil)
 
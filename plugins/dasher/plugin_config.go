@@ -0,0 +1,165 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package dasher
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Value shown in place of the secret config settings.
+const redactedValue = "REDACTED"
+
+// Config settings whose name matches are assumed to hold credentials.
+var secretKeyRegex = regexp.MustCompile("(?i)password|passwd|secret|token|key")
+
+// The effective config of a single plugin, as served at /config.
+type pluginConfigEntry struct {
+	Name string
+	Type string
+	// Heka's common settings for the plugin category, e.g. `message_matcher`.
+	Common interface{} `json:",omitempty"`
+	// The plugin's own settings.
+	Config interface{} `json:",omitempty"`
+	Error  string      `json:",omitempty"`
+}
+
+type commonConfigPreparer interface {
+	PrepCommonTypedConfig() (interface{}, error)
+}
+
+// Decodes the config of each plugin maker into a fresh config struct, so the
+// result holds the values the plugin was started with: its ConfigStruct
+// defaults overridden by the TOML settings, with any environment variables
+// already substituted. The entries are grouped by category, using the same
+// section names as the `heka.all-report` data, e.g. "inputs".
+func renderPluginConfigs(makers map[string]map[string]PluginMaker) map[string][]pluginConfigEntry {
+	configs := make(map[string][]pluginConfigEntry, len(makers))
+	for category, byName := range makers {
+		if len(byName) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(byName))
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		entries := make([]pluginConfigEntry, len(names))
+		for i, name := range names {
+			maker := byName[name]
+			entry := pluginConfigEntry{Name: name, Type: maker.Type()}
+			if preparer, ok := maker.(commonConfigPreparer); ok {
+				if common, err := preparer.PrepCommonTypedConfig(); err == nil {
+					entry.Common = configValue(reflect.ValueOf(common))
+				}
+			}
+			config, err := maker.PrepConfig()
+			if err != nil {
+				entry.Error = err.Error()
+			} else {
+				entry.Config = configValue(reflect.ValueOf(config))
+			}
+			entries[i] = entry
+		}
+		configs[strings.ToLower(category)+"s"] = entries
+	}
+	return configs
+}
+
+// Converts a config value to a JSON friendly form. Structs become objects
+// keyed by their TOML setting names, and the values of settings that look
+// like credentials are redacted.
+func configValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Invalid, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return configValue(v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		obj := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				// Unexported.
+				continue
+			}
+			key := strings.Split(field.Tag.Get("toml"), ",")[0]
+			if key == "-" {
+				continue
+			}
+			if key == "" {
+				key = field.Name
+			}
+			obj[key] = configSetting(key, v.Field(i))
+		}
+		return obj
+	case reflect.Map:
+		obj := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			obj[key] = configSetting(key, v.MapIndex(k))
+		}
+		return obj
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = configValue(v.Index(i))
+		}
+		return list
+	case reflect.String:
+		return redactUrlPassword(v.String())
+	}
+	return v.Interface()
+}
+
+// Converts the value of the named setting, redacting it if it's a non-empty
+// secret.
+func configSetting(key string, v reflect.Value) interface{} {
+	value := configValue(v)
+	if value == nil || !secretKeyRegex.MatchString(key) {
+		return value
+	}
+	if s, ok := value.(string); ok && s == "" {
+		return value
+	}
+	return redactedValue
+}
+
+// Redacts the password of URLs with credentials, e.g. an AMQP server URL.
+func redactUrlPassword(s string) string {
+	if !strings.Contains(s, "://") || !strings.Contains(s, "@") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	u.User = url.UserPassword(u.User.Username(), redactedValue)
+	return u.String()
+}
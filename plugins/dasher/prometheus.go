@@ -0,0 +1,115 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package dasher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var promInvalidChars = regexp.MustCompile("[^a-zA-Z0-9_]+")
+
+// Converts a plugin name or report key such as "ProcessMessageCount" to a
+// snake cased Prometheus metric name component, "process_message_count".
+func promNamePart(s string) string {
+	var buf bytes.Buffer
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a new word at a lower to upper case transition, or at the
+			// last upper case letter of an acronym followed by a lower case one.
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) &&
+					unicode.IsUpper(runes[i-1]))) {
+				buf.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		buf.WriteRune(r)
+	}
+	name := promInvalidChars.ReplaceAllString(buf.String(), "_")
+	return strings.Trim(name, "_")
+}
+
+// Report keys ending in "Count" are cumulative counts, everything else
+// (channel lengths and capacities, queue sizes, durations) is a gauge.
+func isPromCounter(key string) bool {
+	return strings.HasSuffix(key, "Count")
+}
+
+// Renders the JSON payload of a `heka.all-report` message in the Prometheus
+// text exposition format. Each numeric report value becomes a metric named
+// after the plugin and the report key, e.g. TcpOutput's DropMessageCount
+// becomes heka_tcp_output_drop_message_count_total, with the report section
+// ("inputs", "outputs", etc.) as its plugin_type label.
+func renderPrometheusMetrics(report string) ([]byte, error) {
+	var data map[string][]map[string]interface{}
+	if err := json.Unmarshal([]byte(report), &data); err != nil {
+		return nil, fmt.Errorf("can't parse report: %s", err)
+	}
+	sections := make([]string, 0, len(data))
+	for section := range data {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	buf := new(bytes.Buffer)
+	seen := make(map[string]bool)
+	for _, section := range sections {
+		for _, plugin := range data[section] {
+			pluginName, _ := plugin["Name"].(string)
+			keys := make([]string, 0, len(plugin))
+			for key := range plugin {
+				if key != "Name" {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				valMap, ok := plugin[key].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				value, ok := valMap["value"].(float64)
+				if !ok {
+					continue
+				}
+				name := "heka_" + promNamePart(pluginName) + "_" + promNamePart(key)
+				metricType := "gauge"
+				if isPromCounter(key) {
+					name += "_total"
+					metricType = "counter"
+				}
+				// Prometheus rejects duplicate metrics, so the first one wins.
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+				fmt.Fprintf(buf, "# HELP %s %s reported by %s.\n", name, key,
+					pluginName)
+				fmt.Fprintf(buf, "# TYPE %s %s\n", name, metricType)
+				fmt.Fprintf(buf, "%s{plugin_type=%s} %s\n", name, strconv.Quote(section),
+					strconv.FormatFloat(value, 'g', -1, 64))
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,64 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package dasher
+
+import (
+	"strings"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func PrometheusMetricsSpec(c gs.Context) {
+	c.Specify("Prometheus metric names", func() {
+		c.Expect(promNamePart("ProcessMessageCount"), gs.Equals, "process_message_count")
+		c.Expect(promNamePart("TcpOutput"), gs.Equals, "tcp_output")
+		c.Expect(promNamePart("HTTPInput"), gs.Equals, "http_input")
+		c.Expect(promNamePart("my-output.1"), gs.Equals, "my_output_1")
+	})
+
+	c.Specify("Rendering a report", func() {
+		report := `{
+			"globals": [{"Name": "Router",
+				"InChanLength": {"value": 3, "representation": "count"},
+				"ProcessMessageCount": {"value": 1000, "representation": "count"}}],
+			"outputs": [{"Name": "TcpOutput",
+				"DropMessageCount": {"value": 2, "representation": "count"},
+				"SignerKeyVersion": {"value": 1, "representation": ""},
+				"Error": {"value": "oops", "representation": ""}}]
+		}`
+
+		c.Specify("renders counters and gauges", func() {
+			metrics, err := renderPrometheusMetrics(report)
+			c.Assume(err, gs.IsNil)
+			lines := strings.Split(strings.TrimSpace(string(metrics)), "\n")
+			c.Assume(len(lines), gs.Equals, 12)
+			c.Expect(lines[1], gs.Equals, "# TYPE heka_router_in_chan_length gauge")
+			c.Expect(lines[2], gs.Equals, `heka_router_in_chan_length{plugin_type="globals"} 3`)
+			c.Expect(lines[4], gs.Equals,
+				"# TYPE heka_router_process_message_count_total counter")
+			c.Expect(lines[5], gs.Equals,
+				`heka_router_process_message_count_total{plugin_type="globals"} 1000`)
+			c.Expect(lines[8], gs.Equals,
+				`heka_tcp_output_drop_message_count_total{plugin_type="outputs"} 2`)
+			c.Expect(lines[11], gs.Equals,
+				`heka_tcp_output_signer_key_version{plugin_type="outputs"} 1`)
+		})
+
+		c.Specify("fails on invalid reports", func() {
+			_, err := renderPrometheusMetrics("not json")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,165 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// A recently seen message hash.
+type dedupEntry struct {
+	hash     uint64
+	lastSeen time.Time
+	elem     *list.Element
+}
+
+// Filter that drops messages identical to one seen recently and injects a
+// copy of all of the others.
+type DedupFilter struct {
+	conf *DedupFilterConfig
+	fr   FilterRunner
+	h    PluginHelper
+	ttl  time.Duration
+
+	lock    sync.Mutex
+	entries map[uint64]*dedupEntry
+	// Entries ordered from least to most recently seen.
+	lru        *list.List
+	dedupCount int64
+}
+
+// DedupFilter config struct.
+type DedupFilterConfig struct {
+	// Message attributes whose values together determine whether two
+	// messages are identical. Supports "Type", "Logger", "Hostname",
+	// "Severity", "Payload", and any dynamic field name. Defaults to
+	// "Payload".
+	HashFields []string `toml:"hash_fields"`
+	// Maximum number of message hashes to remember. Defaults to 10000.
+	CacheSize int `toml:"cache_size"`
+	// Number of seconds a message hash is remembered for after it was last
+	// seen. Defaults to 0, i.e. hashes are only forgotten when the cache is
+	// full.
+	Ttl uint `toml:"ttl"`
+	// Prepended to the original message Type to give the Type of the
+	// injected copies. Defaults to "dedup.".
+	TypePrefix string `toml:"type_prefix"`
+}
+
+func (f *DedupFilter) ConfigStruct() interface{} {
+	return &DedupFilterConfig{
+		HashFields: []string{"Payload"},
+		CacheSize:  10000,
+		TypePrefix: "dedup.",
+	}
+}
+
+func (f *DedupFilter) Init(config interface{}) error {
+	f.conf = config.(*DedupFilterConfig)
+	if len(f.conf.HashFields) == 0 {
+		return errors.New("`hash_fields` must not be empty")
+	}
+	if f.conf.CacheSize < 1 {
+		return errors.New("`cache_size` must be greater than zero")
+	}
+	if f.conf.TypePrefix == "" {
+		return errors.New("`type_prefix` must be specified so the injected " +
+			"messages can be told apart from the originals")
+	}
+	f.ttl = time.Duration(f.conf.Ttl) * time.Second
+	f.entries = make(map[uint64]*dedupEntry)
+	f.lru = list.New()
+	return nil
+}
+
+func (f *DedupFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func (f *DedupFilter) ProcessMessage(pack *PipelinePack) error {
+	if f.seen(messageHash(pack.Message, f.conf.HashFields), time.Now()) {
+		return nil
+	}
+	newPack, err := f.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		return err
+	}
+	pack.Message.Copy(newPack.Message)
+	newPack.Message.SetType(f.conf.TypePrefix + newPack.Message.GetType())
+	f.fr.Inject(newPack)
+	return nil
+}
+
+func (f *DedupFilter) CleanUp() {}
+
+// Records a sighting of the hash, returning whether it was already in the
+// cache, i.e. whether the message is a duplicate.
+func (f *DedupFilter) seen(hash uint64, now time.Time) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.ttl > 0 {
+		for e := f.lru.Front(); e != nil; e = f.lru.Front() {
+			entry := e.Value.(*dedupEntry)
+			if now.Sub(entry.lastSeen) < f.ttl {
+				break
+			}
+			f.remove(entry)
+		}
+	}
+	if entry, ok := f.entries[hash]; ok {
+		entry.lastSeen = now
+		f.lru.MoveToBack(entry.elem)
+		f.dedupCount++
+		return true
+	}
+	if len(f.entries) >= f.conf.CacheSize {
+		f.remove(f.lru.Front().Value.(*dedupEntry))
+	}
+	entry := &dedupEntry{hash: hash, lastSeen: now}
+	entry.elem = f.lru.PushBack(entry)
+	f.entries[hash] = entry
+	return false
+}
+
+func (f *DedupFilter) remove(entry *dedupEntry) {
+	f.lru.Remove(entry.elem)
+	delete(f.entries, entry.hash)
+}
+
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (f *DedupFilter) ReportMsg(msg *message.Message) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	message.NewInt64Field(msg, "CacheEntries", int64(len(f.entries)), "count")
+	message.NewInt64Field(msg, "DedupCount", f.dedupCount, "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("DedupFilter", func() interface{} {
+		return new(DedupFilter)
+	})
+}
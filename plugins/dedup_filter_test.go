@@ -0,0 +1,129 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	pm "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func DedupFilterSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newMsg := func(payload string) *message.Message {
+		msg := pipeline_ts.GetTestMessage()
+		msg.SetPayload(payload)
+		return msg
+	}
+
+	c.Specify("A DedupFilter", func() {
+		filter := new(DedupFilter)
+		config := filter.ConfigStruct().(*DedupFilterConfig)
+		now := time.Now()
+		hash := func(msg *message.Message) uint64 {
+			return messageHash(msg, filter.conf.HashFields)
+		}
+
+		c.Specify("requires a positive cache size", func() {
+			config.CacheSize = 0
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("hashes the payload by default", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			msg := newMsg("foo")
+			other := newMsg("foo")
+			other.SetHostname("elsewhere")
+			c.Expect(hash(msg), gs.Equals, hash(other))
+			c.Expect(hash(msg), gs.Not(gs.Equals), hash(newMsg("bar")))
+		})
+
+		c.Specify("hashes the configured fields", func() {
+			config.HashFields = []string{"Hostname", "foo"}
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			msg := newMsg("foo")
+			other := newMsg("bar")
+			c.Expect(hash(msg), gs.Equals, hash(other))
+			other.SetHostname("elsewhere")
+			c.Expect(hash(msg), gs.Not(gs.Equals), hash(other))
+		})
+
+		c.Specify("evicts the least recently seen hash when full", func() {
+			config.CacheSize = 2
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(filter.seen(1, now), gs.IsFalse)
+			c.Expect(filter.seen(2, now), gs.IsFalse)
+			c.Expect(filter.seen(1, now), gs.IsTrue)
+			c.Expect(filter.seen(3, now), gs.IsFalse)
+			c.Expect(len(filter.entries), gs.Equals, 2)
+			c.Expect(filter.seen(1, now), gs.IsTrue)
+			c.Expect(filter.seen(2, now), gs.IsFalse)
+		})
+
+		c.Specify("forgets hashes after the ttl", func() {
+			config.Ttl = 60
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(filter.seen(1, now), gs.IsFalse)
+			c.Expect(filter.seen(1, now.Add(59*time.Second)), gs.IsTrue)
+			c.Expect(filter.seen(1, now.Add(118*time.Second)), gs.IsTrue)
+			c.Expect(filter.seen(1, now.Add(178*time.Second)), gs.IsFalse)
+		})
+
+		c.Specify("only injects the first of identical messages", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			fr := pm.NewMockFilterRunner(ctrl)
+			h := pm.NewMockPluginHelper(ctrl)
+			err = filter.Prepare(fr, h)
+			c.Assume(err, gs.IsNil)
+
+			supply := make(chan *PipelinePack, 1)
+			pack := NewPipelinePack(supply)
+			pack.Message = newMsg("foo")
+			newPack := NewPipelinePack(supply)
+			h.EXPECT().PipelinePack(pack.MsgLoopCount).Return(newPack, nil)
+			fr.EXPECT().Inject(newPack).Return(true)
+
+			err = filter.ProcessMessage(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(newPack.Message.GetType(), gs.Equals, "dedup.TEST")
+			c.Expect(newPack.Message.GetPayload(), gs.Equals, "foo")
+
+			err = filter.ProcessMessage(pack)
+			c.Expect(err, gs.IsNil)
+
+			msg := new(message.Message)
+			err = filter.ReportMsg(msg)
+			c.Expect(err, gs.IsNil)
+			entries, _ := msg.GetFieldValue("CacheEntries")
+			c.Expect(entries, gs.Equals, int64(1))
+			dedups, _ := msg.GetFieldValue("DedupCount")
+			c.Expect(dedups, gs.Equals, int64(1))
+		})
+	})
+}
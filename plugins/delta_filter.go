@@ -0,0 +1,271 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Most recent snapshot for a single key.
+type deltaState struct {
+	key       string
+	values    map[string]float64 // Counter field name to value.
+	timestamp int64              // Timestamp of the snapshot message, in ns.
+	lastSeen  time.Time          // Wall clock time of the snapshot.
+	elem      *list.Element
+}
+
+// The differences between two consecutive snapshots, ready to be emitted.
+type deltaSample struct {
+	key       string
+	msg       *message.Message // The newer snapshot.
+	elapsed   int64            // Time between the snapshots, in ns.
+	fields    []string         // Counters with a delta, in config order.
+	deltas    map[string]float64
+	resetting []string // Counters that went backwards, i.e. were reset.
+}
+
+// Filter that turns messages carrying cumulative counters into messages
+// carrying the change in each counter since the previous message with the
+// same key.
+type DeltaFilter struct {
+	conf   *DeltaFilterConfig
+	ttl    time.Duration
+	states map[string]*deltaState
+	// States ordered from least to most recently seen, used for both TTL
+	// expiration and eviction when max_keys is reached.
+	lru *list.List
+}
+
+// DeltaFilter config struct.
+type DeltaFilterConfig struct {
+	// Names of the message fields holding cumulative counters. Required.
+	CounterFields []string `toml:"counter_fields"`
+	// Message fields whose values are joined together to identify each
+	// snapshot series. Supports "Type", "Logger", "Hostname", and any dynamic
+	// field name. Defaults to a single series for all messages.
+	KeyFields []string `toml:"key_fields"`
+	// Maximum number of keys to track. When this is exceeded the least
+	// recently seen key is forgotten. Defaults to 10000.
+	MaxKeys int `toml:"max_keys"`
+	// Number of seconds a key can go without receiving any messages before
+	// it's forgotten. Defaults to 3600, 0 means keys never expire.
+	Ttl uint `toml:"ttl"`
+	// Type to use for the emitted delta messages. Defaults to "heka.delta".
+	MessageType string `toml:"message_type"`
+	// Defaults to 60 second intervals.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+func (this *DeltaFilter) ConfigStruct() interface{} {
+	return &DeltaFilterConfig{
+		MaxKeys:        10000,
+		Ttl:            3600,
+		MessageType:    "heka.delta",
+		TickerInterval: uint(60),
+	}
+}
+
+func (this *DeltaFilter) Init(config interface{}) (err error) {
+	this.conf = config.(*DeltaFilterConfig)
+	if len(this.conf.CounterFields) == 0 {
+		return errors.New("`counter_fields` must be specified")
+	}
+	if this.conf.MaxKeys < 1 {
+		return errors.New("`max_keys` must be greater than zero")
+	}
+	this.ttl = time.Duration(this.conf.Ttl) * time.Second
+	this.states = make(map[string]*deltaState)
+	this.lru = list.New()
+	return
+}
+
+func (this *DeltaFilter) Run(fr FilterRunner, h PluginHelper) (err error) {
+	inChan := fr.InChan()
+	ticker := fr.Ticker()
+
+	var (
+		ok     = true
+		pack   *PipelinePack
+		sample *deltaSample
+	)
+	for ok {
+		select {
+		case pack, ok = <-inChan:
+			if !ok {
+				break
+			}
+			sample = this.addMessage(pack.Message, time.Now())
+			if sample != nil {
+				this.emit(fr, h, sample, pack.MsgLoopCount)
+			}
+			fr.UpdateCursor(pack.QueueCursor)
+			pack.Recycle(nil)
+		case <-ticker:
+			this.expire(time.Now())
+		}
+	}
+	return
+}
+
+func (this *DeltaFilter) CleanupForRestart() {
+	this.states = make(map[string]*deltaState)
+	this.lru.Init()
+}
+
+// Returns the numeric value of a counter field.
+func counterValue(msg *message.Message, name string) (value float64, ok bool) {
+	val, ok := msg.GetFieldValue(name)
+	if !ok {
+		return
+	}
+	switch v := val.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Records the message as the latest snapshot for its key, returning the
+// deltas from the previous snapshot. Returns nil for the first snapshot of a
+// key, or if none of the counters were in both snapshots.
+func (this *DeltaFilter) addMessage(msg *message.Message,
+	now time.Time) (sample *deltaSample) {
+
+	values := make(map[string]float64, len(this.conf.CounterFields))
+	for _, name := range this.conf.CounterFields {
+		if value, ok := counterValue(msg, name); ok {
+			values[name] = value
+		}
+	}
+	if len(values) == 0 {
+		return
+	}
+	key := messageKey(msg, this.conf.KeyFields)
+	ts := msg.GetTimestamp()
+
+	s, ok := this.states[key]
+	if !ok {
+		if len(this.states) >= this.conf.MaxKeys {
+			this.remove(this.lru.Front().Value.(*deltaState))
+		}
+		s = &deltaState{key: key}
+		s.elem = this.lru.PushBack(s)
+		this.states[key] = s
+	} else {
+		this.lru.MoveToBack(s.elem)
+		sample = &deltaSample{
+			key:     key,
+			msg:     msg,
+			elapsed: ts - s.timestamp,
+			deltas:  make(map[string]float64, len(values)),
+		}
+		for _, name := range this.conf.CounterFields {
+			value, ok := values[name]
+			if !ok {
+				continue
+			}
+			last, ok := s.values[name]
+			if !ok {
+				continue
+			}
+			if value >= last {
+				sample.deltas[name] = value - last
+			} else {
+				// The counter was reset, so everything counted since the
+				// reset is the increase.
+				sample.deltas[name] = value
+				sample.resetting = append(sample.resetting, name)
+			}
+			sample.fields = append(sample.fields, name)
+		}
+		if len(sample.fields) == 0 {
+			sample = nil
+		}
+	}
+	s.values = values
+	s.timestamp = ts
+	s.lastSeen = now
+	return
+}
+
+// Forgets all of the keys that haven't been seen within the TTL.
+func (this *DeltaFilter) expire(now time.Time) {
+	if this.ttl == 0 {
+		return
+	}
+	for e := this.lru.Front(); e != nil; e = this.lru.Front() {
+		s := e.Value.(*deltaState)
+		if now.Sub(s.lastSeen) < this.ttl {
+			break
+		}
+		this.remove(s)
+	}
+}
+
+func (this *DeltaFilter) remove(s *deltaState) {
+	this.lru.Remove(s.elem)
+	delete(this.states, s.key)
+}
+
+func (this *DeltaFilter) emit(fr FilterRunner, h PluginHelper, sample *deltaSample,
+	msgLoopCount uint) {
+
+	pack, e := h.PipelinePack(msgLoopCount)
+	if e != nil {
+		fr.LogError(e)
+		return
+	}
+	pack.Message.SetLogger(fr.Name())
+	pack.Message.SetType(this.conf.MessageType)
+	pack.Message.SetHostname(sample.msg.GetHostname())
+	pack.Message.SetTimestamp(sample.msg.GetTimestamp())
+	pack.Message.SetPayload(fmt.Sprintf("%s: %d deltas over %s", sample.key,
+		len(sample.fields), time.Duration(sample.elapsed)))
+	message.NewStringField(pack.Message, "key", sample.key)
+	for _, name := range sample.fields {
+		representation := ""
+		if field := sample.msg.FindFirstField(name); field != nil {
+			representation = field.GetRepresentation()
+		}
+		if f, e := message.NewField(name, sample.deltas[name], representation); e == nil {
+			pack.Message.AddField(f)
+		}
+	}
+	message.NewInt64Field(pack.Message, "elapsed", sample.elapsed, "ns")
+	if len(sample.resetting) > 0 {
+		if f, e := message.NewField("reset_fields", sample.resetting[0], ""); e == nil {
+			for _, name := range sample.resetting[1:] {
+				f.AddValue(name)
+			}
+			pack.Message.AddField(f)
+		}
+	}
+	fr.Inject(pack)
+}
+
+func init() {
+	RegisterPlugin("DeltaFilter", func() interface{} {
+		return new(DeltaFilter)
+	})
+}
@@ -0,0 +1,118 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func DeltaFilterSpec(c gs.Context) {
+	newMsg := func(host string, reads, writes int64, ts int64) *message.Message {
+		msg := pipeline_ts.GetTestMessage()
+		msg.SetHostname(host)
+		msg.SetTimestamp(ts)
+		message.NewInt64Field(msg, "reads", reads, "count")
+		if writes >= 0 {
+			message.NewInt64Field(msg, "writes", writes, "count")
+		}
+		return msg
+	}
+
+	c.Specify("A DeltaFilter", func() {
+		filter := new(DeltaFilter)
+		config := filter.ConfigStruct().(*DeltaFilterConfig)
+		config.CounterFields = []string{"reads", "writes"}
+		config.KeyFields = []string{"Hostname"}
+		now := time.Now()
+
+		c.Specify("requires counter fields", func() {
+			config.CounterFields = nil
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("computes deltas between consecutive snapshots", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(filter.addMessage(newMsg("a", 10, 5, 100), now), gs.IsNil)
+			c.Expect(filter.addMessage(newMsg("b", 50, 50, 100), now), gs.IsNil)
+
+			sample := filter.addMessage(newMsg("a", 25, 5, 300), now)
+			c.Assume(sample, gs.Not(gs.IsNil))
+			c.Expect(sample.key, gs.Equals, "a")
+			c.Expect(sample.elapsed, gs.Equals, int64(200))
+			c.Expect(len(sample.fields), gs.Equals, 2)
+			c.Expect(sample.deltas["reads"], gs.Equals, float64(15))
+			c.Expect(sample.deltas["writes"], gs.Equals, float64(0))
+			c.Expect(len(sample.resetting), gs.Equals, 0)
+		})
+
+		c.Specify("treats a decrease as a counter reset", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", 100, 5, 100), now)
+			sample := filter.addMessage(newMsg("a", 7, 6, 200), now)
+			c.Assume(sample, gs.Not(gs.IsNil))
+			c.Expect(sample.deltas["reads"], gs.Equals, float64(7))
+			c.Expect(sample.deltas["writes"], gs.Equals, float64(1))
+			c.Expect(len(sample.resetting), gs.Equals, 1)
+			c.Expect(sample.resetting[0], gs.Equals, "reads")
+		})
+
+		c.Specify("only reports counters present in both snapshots", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", 10, -1, 100), now)
+			sample := filter.addMessage(newMsg("a", 20, 5, 200), now)
+			c.Assume(sample, gs.Not(gs.IsNil))
+			c.Expect(len(sample.fields), gs.Equals, 1)
+			c.Expect(sample.fields[0], gs.Equals, "reads")
+
+			sample = filter.addMessage(newMsg("a", 30, 8, 300), now)
+			c.Expect(len(sample.fields), gs.Equals, 2)
+		})
+
+		c.Specify("evicts the least recently seen key", func() {
+			config.MaxKeys = 2
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", 1, 1, 100), now)
+			filter.addMessage(newMsg("b", 1, 1, 100), now)
+			filter.addMessage(newMsg("a", 2, 2, 200), now)
+			filter.addMessage(newMsg("c", 1, 1, 200), now)
+			c.Expect(len(filter.states), gs.Equals, 2)
+			_, ok := filter.states["b"]
+			c.Expect(ok, gs.IsFalse)
+			// The next snapshot for an evicted key is treated as the first.
+			c.Expect(filter.addMessage(newMsg("b", 5, 5, 300), now), gs.IsNil)
+		})
+
+		c.Specify("expires idle keys", func() {
+			config.Ttl = 60
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", 1, 1, 100), now.Add(-2*time.Minute))
+			filter.addMessage(newMsg("b", 1, 1, 100), now)
+			filter.expire(now)
+			c.Expect(len(filter.states), gs.Equals, 1)
+			_, ok := filter.states["a"]
+			c.Expect(ok, gs.IsFalse)
+		})
+	})
+}
@@ -0,0 +1,215 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Filter that estimates the number of distinct values of a message field per
+// key with HyperLogLog sketches, emitting one message per key with the
+// estimate every ticker interval and then starting over. Memory use is
+// bounded by the sketch precision and the maximum number of keys rather than
+// by the number of distinct values.
+type DistinctCountFilter struct {
+	conf     *DistinctCountFilterConfig
+	sketches map[string]*hyperLogLog
+	// Number of messages dropped during the current interval because there
+	// were already `max_keys` keys.
+	dropped int
+}
+
+// DistinctCountFilter config struct.
+type DistinctCountFilterConfig struct {
+	// Name of the message field holding the values to count, e.g. a user id.
+	// Supports "Type", "Logger", "Hostname", "Payload", and any dynamic field
+	// name. Required.
+	ValueField string `toml:"value_field"`
+	// Message fields whose values are joined together to identify each
+	// count. Supports "Type", "Logger", "Hostname", and any dynamic field
+	// name. Defaults to a single count for all messages.
+	KeyFields []string `toml:"key_fields"`
+	// Number of bits used to pick a sketch register, between 4 and 16. Each
+	// sketch uses 2^precision bytes. Defaults to 14, i.e. 16KiB per key with
+	// a standard error of about 0.8%.
+	Precision uint `toml:"precision"`
+	// Maximum number of keys counted per interval, messages for any further
+	// keys are dropped. Defaults to 1000.
+	MaxKeys int `toml:"max_keys"`
+	// Type to use for the emitted count messages. Defaults to
+	// "heka.distinct_count".
+	MessageType string `toml:"message_type"`
+	// Interval over which the distinct values are counted, in seconds.
+	// Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+func (this *DistinctCountFilter) ConfigStruct() interface{} {
+	return &DistinctCountFilterConfig{
+		Precision:      14,
+		MaxKeys:        1000,
+		MessageType:    "heka.distinct_count",
+		TickerInterval: uint(60),
+	}
+}
+
+func (this *DistinctCountFilter) Init(config interface{}) (err error) {
+	this.conf = config.(*DistinctCountFilterConfig)
+	if this.conf.ValueField == "" {
+		return errors.New("`value_field` must be specified")
+	}
+	if this.conf.Precision < 4 || this.conf.Precision > 16 {
+		return errors.New("`precision` must be between 4 and 16")
+	}
+	if this.conf.MaxKeys < 1 {
+		return errors.New("`max_keys` must be greater than zero")
+	}
+	if this.conf.TickerInterval == 0 {
+		return errors.New("`ticker_interval` must be greater than zero")
+	}
+	this.sketches = make(map[string]*hyperLogLog)
+	return
+}
+
+func (this *DistinctCountFilter) Run(fr FilterRunner, h PluginHelper) (err error) {
+	inChan := fr.InChan()
+	ticker := fr.Ticker()
+
+	var (
+		ok           = true
+		pack         *PipelinePack
+		msgLoopCount uint
+	)
+	for ok {
+		select {
+		case pack, ok = <-inChan:
+			if !ok {
+				break
+			}
+			msgLoopCount = pack.MsgLoopCount
+			this.addMessage(pack.Message)
+			fr.UpdateCursor(pack.QueueCursor)
+			pack.Recycle(nil)
+		case <-ticker:
+			if this.dropped > 0 {
+				fr.LogError(fmt.Errorf("dropped %d messages for keys past `max_keys`",
+					this.dropped))
+			}
+			for _, count := range this.tick() {
+				this.emit(fr, h, count, msgLoopCount)
+			}
+		}
+	}
+	return
+}
+
+func (this *DistinctCountFilter) CleanupForRestart() {
+	this.sketches = make(map[string]*hyperLogLog)
+	this.dropped = 0
+}
+
+// Returns the message's value for the value field, and whether it has one.
+func (this *DistinctCountFilter) value(msg *message.Message) (string, bool) {
+	switch this.conf.ValueField {
+	case "Type":
+		return msg.GetType(), true
+	case "Logger":
+		return msg.GetLogger(), true
+	case "Hostname":
+		return msg.GetHostname(), true
+	case "Payload":
+		return msg.GetPayload(), true
+	}
+	val, ok := msg.GetFieldValue(this.conf.ValueField)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(val), true
+}
+
+func (this *DistinctCountFilter) addMessage(msg *message.Message) {
+	value, ok := this.value(msg)
+	if !ok {
+		return
+	}
+	key := messageKey(msg, this.conf.KeyFields)
+	sketch, ok := this.sketches[key]
+	if !ok {
+		if len(this.sketches) >= this.conf.MaxKeys {
+			this.dropped++
+			return
+		}
+		sketch = newHyperLogLog(this.conf.Precision)
+		this.sketches[key] = sketch
+	}
+	sketch.add(value)
+}
+
+// A single estimated count, ready to be emitted.
+type distinctCount struct {
+	key   string
+	count uint64
+}
+
+// Closes out the current interval, returning the estimate for every key
+// sorted by key. Keys with no values during the interval are reported with a
+// count of zero once and then forgotten, so they stop holding their memory.
+func (this *DistinctCountFilter) tick() (counts []distinctCount) {
+	keys := make([]string, 0, len(this.sketches))
+	for key := range this.sketches {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sketch := this.sketches[key]
+		count := sketch.estimate()
+		counts = append(counts, distinctCount{key: key, count: count})
+		if count == 0 {
+			delete(this.sketches, key)
+		} else {
+			sketch.reset()
+		}
+	}
+	this.dropped = 0
+	return
+}
+
+func (this *DistinctCountFilter) emit(fr FilterRunner, h PluginHelper,
+	count distinctCount, msgLoopCount uint) {
+
+	pack, e := h.PipelinePack(msgLoopCount)
+	if e != nil {
+		fr.LogError(e)
+		return
+	}
+	pack.Message.SetLogger(fr.Name())
+	pack.Message.SetType(this.conf.MessageType)
+	pack.Message.SetPayload(fmt.Sprintf("%s %d distinct", count.key, count.count))
+	message.NewStringField(pack.Message, "key", count.key)
+	message.NewInt64Field(pack.Message, "distinct_count", int64(count.count), "count")
+	message.NewIntField(pack.Message, "interval", int(this.conf.TickerInterval), "s")
+	fr.Inject(pack)
+}
+
+func init() {
+	RegisterPlugin("DistinctCountFilter", func() interface{} {
+		return new(DistinctCountFilter)
+	})
+}
@@ -0,0 +1,129 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"strconv"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	pm "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func DistinctCountFilterSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newMsg := func(host, user string) *message.Message {
+		msg := pipeline_ts.GetTestMessage()
+		msg.SetHostname(host)
+		message.NewStringField(msg, "user_id", user)
+		return msg
+	}
+
+	c.Specify("A DistinctCountFilter", func() {
+		filter := new(DistinctCountFilter)
+		config := filter.ConfigStruct().(*DistinctCountFilterConfig)
+		config.ValueField = "user_id"
+		config.KeyFields = []string{"Hostname"}
+
+		c.Specify("requires a value field", func() {
+			config.ValueField = ""
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects an out of range precision", func() {
+			config.Precision = 3
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+			config.Precision = 17
+			err = filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("estimates distinct values per key", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			for i := 0; i < 10000; i++ {
+				user := strconv.Itoa(i)
+				filter.addMessage(newMsg("a", user))
+				filter.addMessage(newMsg("a", user))
+				if i < 10 {
+					filter.addMessage(newMsg("b", user))
+				}
+			}
+			filter.addMessage(pipeline_ts.GetTestMessage())
+
+			counts := filter.tick()
+			c.Assume(len(counts), gs.Equals, 2)
+			c.Expect(counts[0].key, gs.Equals, "a")
+			c.Expect(counts[0].count > 9700, gs.IsTrue)
+			c.Expect(counts[0].count < 10300, gs.IsTrue)
+			c.Expect(counts[1].key, gs.Equals, "b")
+			c.Expect(counts[1].count, gs.Equals, uint64(10))
+
+			c.Specify("and starts over each interval", func() {
+				filter.addMessage(newMsg("a", "1"))
+				counts := filter.tick()
+				c.Assume(len(counts), gs.Equals, 2)
+				c.Expect(counts[0].count, gs.Equals, uint64(1))
+				c.Expect(counts[1].count, gs.Equals, uint64(0))
+				counts = filter.tick()
+				c.Assume(len(counts), gs.Equals, 1)
+				c.Expect(counts[0].key, gs.Equals, "a")
+			})
+		})
+
+		c.Specify("drops messages past max_keys", func() {
+			config.MaxKeys = 1
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", "1"))
+			filter.addMessage(newMsg("b", "1"))
+			c.Expect(filter.dropped, gs.Equals, 1)
+			c.Expect(len(filter.tick()), gs.Equals, 1)
+			c.Expect(filter.dropped, gs.Equals, 0)
+		})
+
+		c.Specify("emits count messages", func() {
+			fr := pm.NewMockFilterRunner(ctrl)
+			h := pm.NewMockPluginHelper(ctrl)
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			supply := make(chan *PipelinePack, 1)
+			pack := NewPipelinePack(supply)
+			h.EXPECT().PipelinePack(uint(0)).Return(pack, nil)
+			fr.EXPECT().Name().Return("uniques")
+			fr.EXPECT().Inject(pack).Return(true)
+			filter.emit(fr, h, distinctCount{key: "a", count: 42}, 0)
+
+			msg := pack.Message
+			c.Expect(msg.GetType(), gs.Equals, "heka.distinct_count")
+			c.Expect(msg.GetLogger(), gs.Equals, "uniques")
+			val, _ := msg.GetFieldValue("key")
+			c.Expect(val.(string), gs.Equals, "a")
+			val, _ = msg.GetFieldValue("distinct_count")
+			c.Expect(val.(int64), gs.Equals, int64(42))
+			val, _ = msg.GetFieldValue("interval")
+			c.Expect(val.(int64), gs.Equals, int64(60))
+		})
+	})
+}
@@ -32,6 +32,8 @@ type ElasticSearchCoordinates struct {
 	Type                 string
 	Id                   string
 	ESIndexFromTimestamp bool
+	// Substituted for %{Fields[name]} tokens referencing a missing field.
+	MissingFieldValue string
 }
 
 // Renders the coordinates of the ElasticSearch document as JSON.
@@ -65,7 +67,22 @@ func (e *ElasticSearchCoordinates) PopulateBuffer(m *message.Message, buf *bytes
 	buf.WriteString(`}}`)
 }
 
-// Replaces a date pattern (ex: %{2012.09.19} in the index name
+// Returns the string representation of a message field value.
+func fieldValueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Replaces a date pattern (ex: %{2012.09.19} in the index name, message
+// headers (ex: %{Type}) and message fields (ex: %{Fields[tenant]}). A
+// referenced field that the message doesn't have is replaced with the
+// MissingFieldValue, and an error is returned.
 func interpolateFlag(e *ElasticSearchCoordinates, m *message.Message, name string) (
 	interpolatedValue string, err error) {
 
@@ -94,8 +111,18 @@ func interpolateFlag(e *ElasticSearchCoordinates, m *message.Message, name strin
 				iSlice[i] = strings.Replace(iSlice[i], element[:elEnd+1],
 					strconv.Itoa(int(m.GetSeverity())), -1)
 			default:
-				if fname, ok := m.GetFieldValue(elVal); ok {
-					iSlice[i] = strings.Replace(iSlice[i], element[:elEnd+1], fname.(string), -1)
+				if strings.HasPrefix(elVal, "Fields[") && strings.HasSuffix(elVal, "]") {
+					fieldName := elVal[len("Fields[") : len(elVal)-1]
+					value := e.MissingFieldValue
+					if fvalue, ok := m.GetFieldValue(fieldName); ok {
+						value = fieldValueString(fvalue)
+					} else {
+						err = fmt.Errorf("Could not interpolate field from config: %s", name)
+					}
+					iSlice[i] = strings.Replace(iSlice[i], element[:elEnd+1], value, -1)
+				} else if fname, ok := m.GetFieldValue(elVal); ok {
+					iSlice[i] = strings.Replace(iSlice[i], element[:elEnd+1],
+						fieldValueString(fname), -1)
 				} else {
 					var t time.Time
 					if e.ESIndexFromTimestamp && m.Timestamp != nil {
@@ -41,6 +41,10 @@ type ESBatch struct {
 	batch       []byte
 }
 
+// Returned by sendRecord when the output is stopped before a batch could be
+// indexed.
+var errSendAbandoned = errors.New("output stopped before the batch was indexed")
+
 type MsgPack struct {
 	bytes       []byte
 	queueCursor string
@@ -91,6 +95,9 @@ type ElasticSearchOutputConfig struct {
 	Username string `toml:"username"`
 	// Optional password for HTTP authentication.
 	Password string `toml:"password"`
+	// Optional extra HTTP headers sent with every bulk request, e.g. an API
+	// key required by a proxy.
+	Headers map[string]string `toml:"headers"`
 	// Specify an overall timeout value in milliseconds for bulk request to complete.
 	// Default is 0 (infinite)
 	HTTPTimeout uint32 `toml:"http_timeout"`
@@ -101,10 +108,23 @@ type ElasticSearchOutputConfig struct {
 	// Default is 0 (infinite)
 	ConnectTimeout uint32 `toml:"connect_timeout"`
 	// Whether or not to buffer records to disk before sending to ElasticSearch.
-	UseBuffering bool `toml:"use_buffering"`
+	// Defaults to true.
+	UseBuffering *bool `toml:"use_buffering"`
+	Buffering    QueueBufferConfig
+	// Controls the backoff between attempts to index a batch while the
+	// cluster is unreachable. Defaults to retrying forever, with the delay
+	// capped at 5 seconds.
+	Retries RetryOptions
 }
 
 func (o *ElasticSearchOutput) ConfigStruct() interface{} {
+	b := true
+	queueConfig := QueueBufferConfig{
+		CursorUpdateCount: 1,
+		MaxBufferSize:     0,
+		MaxFileSize:       128 * 1024 * 1024,
+		FullAction:        "shutdown",
+	}
 	return &ElasticSearchOutputConfig{
 		FlushInterval:         1000,
 		FlushCount:            10,
@@ -114,7 +134,13 @@ func (o *ElasticSearchOutput) ConfigStruct() interface{} {
 		HTTPTimeout:           0,
 		HTTPDisableKeepalives: false,
 		ConnectTimeout:        0,
-		UseBuffering:          true,
+		UseBuffering:          &b,
+		Buffering:             queueConfig,
+		Retries: RetryOptions{
+			MaxDelay:   "5s",
+			Delay:      "250ms",
+			MaxRetries: -1,
+		},
 	}
 }
 
@@ -137,8 +163,14 @@ func (o *ElasticSearchOutput) Init(config interface{}) (err error) {
 				}
 			}
 
+			// Credentials can also be embedded in the server URL.
+			username, password := o.conf.Username, o.conf.Password
+			if username == "" && serverUrl.User != nil {
+				username = serverUrl.User.Username()
+				password, _ = serverUrl.User.Password()
+			}
 			o.bulkIndexer = NewHttpBulkIndexer(scheme, serverUrl.Host, serverUrl.Path,
-				o.conf.FlushCount, o.conf.Username, o.conf.Password, o.conf.HTTPTimeout,
+				o.conf.FlushCount, username, password, o.conf.Headers, o.conf.HTTPTimeout,
 				o.conf.HTTPDisableKeepalives, o.conf.ConnectTimeout, tlsConf)
 		case "udp":
 			o.bulkIndexer = NewUDPBulkIndexer(serverUrl.Host, o.conf.FlushCount)
@@ -146,7 +178,11 @@ func (o *ElasticSearchOutput) Init(config interface{}) (err error) {
 			err = errors.New("Server URL must specify one of `udp`, `http`, or `https`.")
 		}
 	} else {
-		err = fmt.Errorf("Unable to parse ElasticSearch server URL [%s]: %s", o.conf.Server, err)
+		// The URL is left out of the error since it may contain credentials.
+		if uErr, ok := err.(*url.Error); ok {
+			err = uErr.Err
+		}
+		err = fmt.Errorf("Unable to parse ElasticSearch server URL: %s", err)
 	}
 	return
 }
@@ -161,10 +197,7 @@ func (o *ElasticSearchOutput) Prepare(or OutputRunner, h PluginHelper) error {
 	o.stopChan = or.StopChan()
 
 	var err error
-	o.outputBlock, err = NewRetryHelper(RetryOptions{
-		MaxDelay:   "5s",
-		MaxRetries: -1,
-	})
+	o.outputBlock, err = NewRetryHelper(o.conf.Retries)
 	if err != nil {
 		return fmt.Errorf("can't create retry helper: %s", err.Error())
 	}
@@ -190,12 +223,23 @@ func (o *ElasticSearchOutput) ProcessMessage(pack *PipelinePack) error {
 	}
 
 	if outBytes != nil {
-		o.recvChan <- MsgPack{bytes: outBytes, queueCursor: pack.QueueCursor}
+		// Blocks while the cluster is unreachable, letting the disk buffer (or
+		// the router, if buffering is disabled) absorb the back pressure.
+		select {
+		case o.recvChan <- MsgPack{bytes: outBytes, queueCursor: pack.QueueCursor}:
+		case <-o.stopChan:
+			return NewRetryMessageError("output stopped")
+		}
 	}
 
 	return nil
 }
 
+// Messages are indexed in bulk by the batchSender goroutine.
+func (o *ElasticSearchOutput) DefersDelivery() bool {
+	return true
+}
+
 func (o *ElasticSearchOutput) batchSender() {
 	ok := true
 	for ok {
@@ -254,7 +298,11 @@ func (o *ElasticSearchOutput) committer() {
 				continue
 			}
 		}
-		if err := o.sendRecord(b.batch); err != nil {
+		if err := o.sendRecord(b.batch); err == errSendAbandoned {
+			// Leave the cursor alone so that buffered records that weren't
+			// indexed are replayed on restart.
+			return
+		} else if err != nil {
 			atomic.AddInt64(&o.dropMessageCount, b.count)
 			o.or.LogError(err)
 		} else {
@@ -267,8 +315,10 @@ func (o *ElasticSearchOutput) committer() {
 }
 
 // sendRecord invokes the indexer to send a batch of data to
-// ElasticSearch. Blocks until the send goes through, only returns an error if
-// the sending is abandoned.
+// ElasticSearch. Retryable failures are retried as specified by the `retries`
+// config, so this blocks until the send goes through, the retries are used
+// up, the error can't be retried, or the output is stopped, in which case
+// errSendAbandoned is returned.
 func (o *ElasticSearchOutput) sendRecord(buffer []byte) error {
 	err, retry := o.bulkIndexer.Index(buffer)
 	if err == nil {
@@ -282,7 +332,7 @@ func (o *ElasticSearchOutput) sendRecord(buffer []byte) error {
 	for {
 		select {
 		case <-o.stopChan:
-			return err
+			return errSendAbandoned
 		default:
 		}
 		e := o.outputBlock.Wait()
@@ -345,11 +395,13 @@ type HttpBulkIndexer struct {
 	username string
 	// Optional password for HTTP authentication
 	password string
+	// Optional extra HTTP headers
+	headers map[string]string
 }
 
 func NewHttpBulkIndexer(protocol string, domain string, path string, maxCount int,
-	username string, password string, httpTimeout uint32, httpDisableKeepalives bool,
-	connectTimeout uint32, tlsConf *tls.Config) *HttpBulkIndexer {
+	username string, password string, headers map[string]string, httpTimeout uint32,
+	httpDisableKeepalives bool, connectTimeout uint32, tlsConf *tls.Config) *HttpBulkIndexer {
 
 	tr := &http.Transport{
 		TLSClientConfig:   tlsConf,
@@ -371,6 +423,7 @@ func NewHttpBulkIndexer(protocol string, domain string, path string, maxCount in
 		client:   client,
 		username: username,
 		password: password,
+		headers:  headers,
 	}
 }
 
@@ -397,7 +450,10 @@ func (h *HttpBulkIndexer) Index(body []byte) (err error, retry bool) {
 		return fmt.Errorf("Can't create bulk request: %s", err.Error()), true
 	}
 	request.Header.Add("Accept", "application/json")
-	if h.username != "" && h.password != "" {
+	for name, value := range h.headers {
+		request.Header.Set(name, value)
+	}
+	if h.username != "" {
 		request.SetBasicAuth(h.username, h.password)
 	}
 
@@ -425,15 +481,20 @@ func (h *HttpBulkIndexer) Index(body []byte) (err error, retry bool) {
 			return fmt.Errorf("HTTP response didn't contain valid JSON. Status: %s. Body: %s",
 				response.Status, string(response_body)), true
 		}
+		// The cluster being overloaded or unavailable is worth retrying, other
+		// errors aren't. 429 is Too Many Requests, which net/http doesn't name
+		// in Go 1.4.
+		retry = response.StatusCode == 429 ||
+			response.StatusCode >= 500
 		json_errors, ok := response_body_json["errors"].(bool)
 		if ok && json_errors && response.StatusCode != 200 {
 			return fmt.Errorf(
 				"ElasticSearch server reported error within JSON. Status: %s. Body: %s",
-				response.Status, string(response_body)), false
+				response.Status, string(response_body)), retry
 		}
 		if response.StatusCode > 304 {
 			return fmt.Errorf("HTTP response error. Status: %s. Body: %s", response.Status,
-				string(response_body)), false
+				string(response_body)), retry
 		}
 	}
 	return nil, false
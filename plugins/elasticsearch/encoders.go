@@ -273,6 +273,9 @@ type ESJsonEncoderConfig struct {
 	// When formating the Index use the Timestamp from the Message instead of
 	// time of processing. Defaults to false.
 	ESIndexFromTimestamp bool `toml:"es_index_from_timestamp"`
+	// Value substituted for %{Fields[name]} tokens in the Index and TypeName
+	// when the message doesn't have the field. Defaults to "unknown".
+	MissingFieldValue string `toml:"missing_field_value"`
 	// Document ID to use. Defaults to "".
 	Id string
 	// Fields to which formatting will not be applied.
@@ -292,6 +295,7 @@ func (e *ESJsonEncoder) ConfigStruct() interface{} {
 		TypeName:             "message",
 		Timestamp:            "%Y-%m-%dT%H:%M:%S",
 		ESIndexFromTimestamp: false,
+		MissingFieldValue:    "unknown",
 		Id:                   "",
 		FieldMappings: &ESFieldMappings{
 			Timestamp:  "Timestamp",
@@ -323,6 +327,7 @@ func (e *ESJsonEncoder) Init(config interface{}) (err error) {
 		Type:                 conf.TypeName,
 		ESIndexFromTimestamp: conf.ESIndexFromTimestamp,
 		Id:                   conf.Id,
+		MissingFieldValue:    conf.MissingFieldValue,
 	}
 	e.fieldMappings = conf.FieldMappings
 	e.dynamicFields = conf.DynamicFields
@@ -445,6 +450,9 @@ type ESLogstashV0EncoderConfig struct {
 	// When formating the Index use the Timestamp from the Message instead of
 	// time of processing. Defaults to false.
 	ESIndexFromTimestamp bool `toml:"es_index_from_timestamp"`
+	// Value substituted for %{Fields[name]} tokens in the Index and TypeName
+	// when the message doesn't have the field. Defaults to "unknown".
+	MissingFieldValue string `toml:"missing_field_value"`
 	// Document ID to use. Defaults to "".
 	Id string
 	// Fields to which formatting will not be applied.
@@ -464,6 +472,7 @@ func (e *ESLogstashV0Encoder) ConfigStruct() interface{} {
 		Timestamp:            "%Y-%m-%dT%H:%M:%S",
 		UseMessageType:       false,
 		ESIndexFromTimestamp: false,
+		MissingFieldValue:    "unknown",
 		Id:                   "",
 		ReplaceDotsWith:      ".",
 	}
@@ -485,6 +494,7 @@ func (e *ESLogstashV0Encoder) Init(config interface{}) (err error) {
 		Type:                 conf.TypeName,
 		ESIndexFromTimestamp: conf.ESIndexFromTimestamp,
 		Id:                   conf.Id,
+		MissingFieldValue:    conf.MissingFieldValue,
 	}
 	e.dynamicFields = conf.DynamicFields
 
@@ -35,6 +35,7 @@ func TestAllSpecs(t *testing.T) {
 	r.Parallel = false
 
 	r.AddSpec(ESEncodersSpec)
+	r.AddSpec(ElasticSearchOutputSpec)
 
 	gs.MainGoTest(r, t)
 }
@@ -158,6 +159,22 @@ func ESEncodersSpec(c gs.Context) {
 				"Could not interpolate field from config: %{idFail}"), gs.IsTrue)
 			c.Expect(unInterpolatedId, gs.Equals, "idFail")
 		})
+
+		c.Specify("should interpolate Fields[name] tokens", func() {
+			coord := &ElasticSearchCoordinates{MissingFieldValue: "unknown"}
+			index, err := interpolateFlag(coord, pack.Message,
+				"heka-%{Fields[idField]}-%{Type}-%{Fields[\"number]}")
+			c.Expect(err, gs.IsNil)
+			c.Expect(index, gs.Equals, "heka-1234-TEST-64")
+		})
+
+		c.Specify("should use the default for missing Fields[name] tokens", func() {
+			coord := &ElasticSearchCoordinates{MissingFieldValue: "unknown"}
+			index, err := interpolateFlag(coord, pack.Message,
+				"heka-%{Fields[tenant]}-%{Type}")
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(index, gs.Equals, "heka-unknown-TEST")
+		})
 	})
 
 	c.Specify("ESLogstashV0Encoder", func() {
@@ -0,0 +1,164 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Filter that renames, copies, and removes dynamic message fields, and
+// injects the resulting copy of each message.
+type FieldFilter struct {
+	conf *FieldFilterConfig
+	fr   FilterRunner
+	h    PluginHelper
+
+	mutatedCount   int64
+	unchangedCount int64
+}
+
+// FieldFilter config struct.
+type FieldFilterConfig struct {
+	// Pairs of [from, to] field names. All fields named `from` are renamed
+	// to `to`, replacing any existing `to` fields.
+	Rename [][]string
+	// Pairs of [from, to] field names. All fields named `from` are copied to
+	// `to`, replacing any existing `to` fields.
+	Copy [][]string
+	// Names of the fields to remove.
+	Remove []string
+	// Prepended to the original message Type to give the Type of the injected
+	// messages. Defaults to "fields.".
+	TypePrefix string `toml:"type_prefix"`
+}
+
+func (f *FieldFilter) ConfigStruct() interface{} {
+	return &FieldFilterConfig{
+		TypePrefix: "fields.",
+	}
+}
+
+func (f *FieldFilter) Init(config interface{}) error {
+	f.conf = config.(*FieldFilterConfig)
+	if len(f.conf.Rename) == 0 && len(f.conf.Copy) == 0 && len(f.conf.Remove) == 0 {
+		return errors.New("at least one of `rename`, `copy`, or `remove` " +
+			"must be specified")
+	}
+	for _, pair := range f.conf.Rename {
+		if len(pair) != 2 {
+			return fmt.Errorf("`rename` entries must be [from, to] pairs, got %v", pair)
+		}
+	}
+	for _, pair := range f.conf.Copy {
+		if len(pair) != 2 {
+			return fmt.Errorf("`copy` entries must be [from, to] pairs, got %v", pair)
+		}
+	}
+	if f.conf.TypePrefix == "" {
+		return errors.New("`type_prefix` must be specified so the injected " +
+			"messages can be told apart from the originals")
+	}
+	return nil
+}
+
+func (f *FieldFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func (f *FieldFilter) ProcessMessage(pack *PipelinePack) error {
+	newPack, err := f.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		return err
+	}
+	pack.Message.Copy(newPack.Message)
+	if f.mutate(newPack.Message) {
+		atomic.AddInt64(&f.mutatedCount, 1)
+	} else {
+		atomic.AddInt64(&f.unchangedCount, 1)
+	}
+	newPack.Message.SetType(f.conf.TypePrefix + newPack.Message.GetType())
+	// The message no longer matches any encoded bytes.
+	newPack.TrustMsgBytes = false
+	f.fr.Inject(newPack)
+	return nil
+}
+
+func (f *FieldFilter) CleanUp() {}
+
+// Applies the renames, then the copies, then the removals to the message.
+// Returns whether any field changed.
+func (f *FieldFilter) mutate(msg *message.Message) (changed bool) {
+	for _, pair := range f.conf.Rename {
+		fields := msg.FindAllFields(pair[0])
+		if len(fields) == 0 || pair[0] == pair[1] {
+			continue
+		}
+		removeFields(msg, pair[1])
+		for _, field := range fields {
+			name := pair[1]
+			field.Name = &name
+		}
+		changed = true
+	}
+	for _, pair := range f.conf.Copy {
+		fields := msg.FindAllFields(pair[0])
+		if len(fields) == 0 || pair[0] == pair[1] {
+			continue
+		}
+		removeFields(msg, pair[1])
+		for _, field := range fields {
+			field = message.CopyField(field)
+			name := pair[1]
+			field.Name = &name
+			msg.AddField(field)
+		}
+		changed = true
+	}
+	for _, name := range f.conf.Remove {
+		if removeFields(msg, name) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Deletes all fields with the given name, returning whether there were any.
+func removeFields(msg *message.Message, name string) bool {
+	fields := msg.FindAllFields(name)
+	for _, field := range fields {
+		msg.DeleteField(field)
+	}
+	return len(fields) > 0
+}
+
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (f *FieldFilter) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "MutatedCount",
+		atomic.LoadInt64(&f.mutatedCount), "count")
+	message.NewInt64Field(msg, "UnchangedCount",
+		atomic.LoadInt64(&f.unchangedCount), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("FieldFilter", func() interface{} {
+		return new(FieldFilter)
+	})
+}
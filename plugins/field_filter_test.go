@@ -0,0 +1,108 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	pm "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func FieldFilterSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A FieldFilter", func() {
+		filter := new(FieldFilter)
+		config := filter.ConfigStruct().(*FieldFilterConfig)
+		fr := pm.NewMockFilterRunner(ctrl)
+		h := pm.NewMockPluginHelper(ctrl)
+		supply := make(chan *PipelinePack, 1)
+
+		testMessage := func() *message.Message {
+			msg := pipeline_ts.GetTestMessage()
+			f, _ := message.NewField("number", 64, "count")
+			msg.AddField(f)
+			f, _ = message.NewField("bytes", []byte("data"), "")
+			msg.AddField(f)
+			return msg
+		}
+
+		c.Specify("requires at least one mutation", func() {
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("requires pairs", func() {
+			config.Rename = [][]string{{"foo"}}
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("injects a mutated copy", func() {
+			config.Rename = [][]string{{"foo", "renamed"}}
+			config.Copy = [][]string{{"number", "number_copy"}}
+			config.Remove = []string{"bytes", "missing"}
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			err = filter.Prepare(fr, h)
+			c.Assume(err, gs.IsNil)
+
+			pack := NewPipelinePack(supply)
+			pack.Message = testMessage()
+			pack.TrustMsgBytes = true
+			newPack := NewPipelinePack(supply)
+			newPack.TrustMsgBytes = true
+			h.EXPECT().PipelinePack(pack.MsgLoopCount).Return(newPack, nil)
+			fr.EXPECT().Inject(newPack).Return(true)
+
+			err = filter.ProcessMessage(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(newPack.TrustMsgBytes, gs.IsFalse)
+			newMsg := newPack.Message
+			c.Expect(newMsg.GetType(), gs.Equals, "fields.TEST")
+			c.Expect(newMsg.FindFirstField("foo"), gs.IsNil)
+			renamed, _ := newMsg.GetFieldValue("renamed")
+			c.Expect(renamed, gs.Equals, "bar")
+			number, _ := newMsg.GetFieldValue("number")
+			numberCopy, _ := newMsg.GetFieldValue("number_copy")
+			c.Expect(numberCopy, gs.Equals, number)
+			c.Expect(newMsg.FindFirstField("bytes"), gs.IsNil)
+
+			// The original message is untouched.
+			foo, _ := pack.Message.GetFieldValue("foo")
+			c.Expect(foo, gs.Equals, "bar")
+			c.Expect(pack.Message.FindFirstField("bytes") != nil, gs.IsTrue)
+
+			msg := new(message.Message)
+			err = filter.ReportMsg(msg)
+			c.Expect(err, gs.IsNil)
+			mutated, _ := msg.GetFieldValue("MutatedCount")
+			c.Expect(mutated, gs.Equals, int64(1))
+		})
+
+		c.Specify("replaces existing target fields", func() {
+			config.Rename = [][]string{{"foo", "number"}}
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			msg := testMessage()
+			c.Expect(filter.mutate(msg), gs.IsTrue)
+			c.Expect(len(msg.FindAllFields("number")), gs.Equals, 1)
+			number, _ := msg.GetFieldValue("number")
+			c.Expect(number, gs.Equals, "bar")
+		})
+	})
+}
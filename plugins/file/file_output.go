@@ -19,12 +19,15 @@ package file
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/cactus/gostrftime"
+	"github.com/mozilla-services/heka/compression"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	"github.com/mozilla-services/heka/plugins"
 	"github.com/rafrombrc/go-notify"
@@ -33,11 +36,26 @@ import (
 type outBatch struct {
 	data   []byte
 	cursor string
+	// Data for each output file, used instead of `data` when `path_template`
+	// is in use.
+	byPath map[string][]byte
 }
 
 func newOutBatch() *outBatch {
 	return &outBatch{
-		data: make([]byte, 0, 10000),
+		data:   make([]byte, 0, 10000),
+		byPath: make(map[string][]byte),
+	}
+}
+
+func (b *outBatch) empty() bool {
+	return len(b.data) == 0 && len(b.byPath) == 0
+}
+
+func (b *outBatch) reset() {
+	b.data = b.data[:0]
+	for path := range b.byPath {
+		delete(b.byPath, path)
 	}
 }
 
@@ -54,6 +72,12 @@ type FileOutput struct {
 	timerChan  <-chan time.Time
 	rotateChan chan time.Time
 	closing    chan struct{}
+	template   *plugins.MessageTemplate
+	pool       *filePool
+	// Parsed `rotate_interval`, 0 if the file isn't rotated periodically.
+	rotateEvery time.Duration
+	// Written at the start of each new file, if the encoder has one.
+	header []byte
 }
 
 // ConfigStruct for FileOutput plugin.
@@ -64,6 +88,21 @@ type FileOutputConfig struct {
 	// http://golang.org/pkg/time/#Time.Format
 	Path string
 
+	// Output file path containing `%{name}` references to message headers or
+	// fields, e.g. "logs/%{tenant}/current.log", so that each message is
+	// written to the file for its values. Can't be used with `path` or
+	// `rotation_interval`.
+	PathTemplate string `toml:"path_template"`
+
+	// Value used in place of any field referenced in `path_template` that a
+	// message doesn't have (default "unknown").
+	MissingValue string `toml:"missing_value"`
+
+	// Maximum number of files to keep open when `path_template` is in use.
+	// When a message needs another file the least recently used one is
+	// closed (default 100).
+	MaxOpenFiles int `toml:"max_open_files"`
+
 	// Output file permissions (default "644").
 	Perm string
 
@@ -73,6 +112,29 @@ type FileOutputConfig struct {
 	// (default 0, i.e. disabled). Set to 0 to disable.
 	RotationInterval uint32 `toml:"rotation_interval"`
 
+	// Interval at which the output file is rotated in-process, e.g. "1h" or
+	// "30m" (default "", i.e. disabled). Unlike with `rotation_interval` the
+	// output path doesn't change; the current file is renamed with a
+	// timestamp suffix and a new one is opened in its place. Empty files
+	// aren't rotated.
+	RotateInterval string `toml:"rotate_interval"`
+
+	// Size in bytes past which the output file is rotated like with
+	// `rotate_interval` (default 0, i.e. disabled). Files are rotated between
+	// batches, so a file can exceed the size by up to one batch.
+	MaxFileSize uint64 `toml:"max_file_size"`
+
+	// Compression applied to rotated files, "gzip", "zstd" or "none"
+	// (default "none").
+	RotateCompression string `toml:"rotate_compression"`
+
+	// Level used by `rotate_compression`, from 1 to 9 for gzip or 1 to 22 for
+	// zstd (default 0, i.e. the algorithm's default level).
+	RotateCompressionLevel int `toml:"rotate_compression_level"`
+
+	// Older spelling of `rotate_compression = "gzip"`, still accepted.
+	RotateGzip bool `toml:"rotate_gzip"`
+
 	// Interval at which accumulated file data should be written to disk, in
 	// milliseconds (default 1000, i.e. 1 second). Set to 0 to disable.
 	FlushInterval uint32 `toml:"flush_interval"`
@@ -111,6 +173,8 @@ func (o *FileOutput) ConfigStruct() interface{} {
 		FlushCount:       1,
 		FlushOperator:    "AND",
 		FolderPerm:       "700",
+		MissingValue:     "unknown",
+		MaxOpenFiles:     100,
 		BufferConfig:     bufConfig,
 	}
 }
@@ -150,6 +214,54 @@ func (o *FileOutput) Init(config interface{}) (err error) {
 	}
 
 	o.closing = make(chan struct{})
+	if conf.PathTemplate != "" {
+		if conf.Path != "" {
+			return errors.New("Only one of 'path' and 'path_template' can be specified.")
+		}
+		if conf.RotationInterval != 0 {
+			return errors.New("Parameter 'rotation_interval' can't be used with 'path_template'.")
+		}
+		if conf.RotateInterval != "" || conf.MaxFileSize != 0 {
+			return errors.New("Parameters 'rotate_interval' and 'max_file_size' can't be used with 'path_template'.")
+		}
+		if conf.MaxOpenFiles < 1 {
+			return errors.New("Parameter 'max_open_files' needs to be greater than 0.")
+		}
+		o.template, err = plugins.NewMessageTemplate(conf.PathTemplate,
+			sanitizePathValue(conf.MissingValue), sanitizePathValue)
+		if err != nil {
+			return fmt.Errorf("Parameter 'path_template' is invalid: %s", err)
+		}
+		o.path = conf.PathTemplate
+		o.pool = newFilePool(conf.MaxOpenFiles, o.perm, o.folderPerm)
+		o.batchChan = make(chan *outBatch)
+		o.backChan = make(chan *outBatch, 2) // Never block on the hand-back
+		o.rotateChan = make(chan time.Time)
+		return nil
+	}
+
+	if conf.RotateInterval != "" {
+		if o.rotateEvery, err = time.ParseDuration(conf.RotateInterval); err != nil {
+			return fmt.Errorf("Parameter 'rotate_interval' is invalid: %s", err)
+		}
+		if o.rotateEvery <= 0 {
+			return errors.New("Parameter 'rotate_interval' needs to be positive.")
+		}
+	}
+	if conf.RotateGzip {
+		if conf.RotateCompression != "" && conf.RotateCompression != compression.Gzip {
+			return errors.New("Parameter 'rotate_gzip' can't be used with another 'rotate_compression'.")
+		}
+		conf.RotateCompression = compression.Gzip
+	}
+	if err = compression.Validate(conf.RotateCompression,
+		conf.RotateCompressionLevel); err != nil {
+		return fmt.Errorf("Parameter 'rotate_compression' is invalid: %s", err)
+	}
+	if conf.RotationInterval != 0 && (o.rotateEvery != 0 || conf.MaxFileSize != 0) {
+		return errors.New("Parameter 'rotation_interval' can't be used with 'rotate_interval' or 'max_file_size'.")
+	}
+
 	switch conf.RotationInterval {
 	case 0:
 		// date rotation is disabled
@@ -208,8 +320,27 @@ func (o *FileOutput) openFile() (err error) {
 	if err = plugins.CheckWritePermission(basePath); err != nil {
 		return
 	}
-	o.file, err = os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, o.perm)
-	return
+	if o.file, err = os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, o.perm); err != nil {
+		return
+	}
+	return writeFileHeader(o.file, o.header)
+}
+
+// Writes the header to the file if the file is empty, i.e. it was just
+// created or rotated in.
+func writeFileHeader(file *os.File, header []byte) error {
+	if len(header) == 0 {
+		return nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > 0 {
+		return nil
+	}
+	_, err = file.Write(header)
+	return err
 }
 
 func (o *FileOutput) Run(or OutputRunner, h PluginHelper) error {
@@ -224,6 +355,17 @@ func (o *FileOutput) Run(or OutputRunner, h PluginHelper) error {
 			or.SetUseFraming(true)
 		}
 	}
+	if headerEnc, ok := enc.(HeaderEncoder); ok {
+		// We know when a new file is started, so we write the header rather
+		// than having the encoder prepend it to its first record.
+		headerEnc.OutputWritesHeader()
+		o.header = headerEnc.Header()
+		if o.pool != nil {
+			o.pool.header = o.header
+		} else if err := writeFileHeader(o.file, o.header); err != nil {
+			return fmt.Errorf("can't write header to %s: %s", o.path, err)
+		}
+	}
 
 	errChan := make(chan error, 1)
 	go o.committer(or, errChan)
@@ -257,7 +399,7 @@ func (o *FileOutput) receiver(or OutputRunner, errChan chan error) (err error) {
 		case pack, ok = <-inChan:
 			if !ok {
 				// Closed inChan => we're shutting down, flush data
-				if len(out.data) > 0 {
+				if !out.empty() {
 					o.batchChan <- out
 				}
 				close(o.batchChan)
@@ -269,7 +411,12 @@ func (o *FileOutput) receiver(or OutputRunner, errChan chan error) (err error) {
 				continue
 			}
 			if outBytes != nil {
-				out.data = append(out.data, outBytes...)
+				if o.template != nil {
+					path := o.templatePath(pack.Message)
+					out.byPath[path] = append(out.byPath[path], outBytes...)
+				} else {
+					out.data = append(out.data, outBytes...)
+				}
 				out.cursor = pack.QueueCursor
 				msgCounter++
 			}
@@ -327,28 +474,53 @@ func (o *FileOutput) committer(or OutputRunner, errChan chan error) {
 	hupChan := make(chan interface{})
 	notify.Start(RELOAD, hupChan)
 
+	var rotateTick <-chan time.Time
+	if o.rotateEvery > 0 {
+		ticker := time.NewTicker(o.rotateEvery)
+		defer ticker.Stop()
+		rotateTick = ticker.C
+	}
+
 	for ok {
 		select {
 		case out, ok = <-o.batchChan:
 			if !ok {
 				// Channel is closed => we're shutting down, exit cleanly.
-				o.file.Close()
+				if o.pool != nil {
+					o.pool.closeAll()
+				} else {
+					o.file.Close()
+				}
 				close(o.closing)
 				break
 			}
-			n, err := o.file.Write(out.data)
-			if err != nil {
-				or.LogError(fmt.Errorf("Can't write to %s: %s", o.path, err))
-			} else if n != len(out.data) {
-				or.LogError(fmt.Errorf("data loss - truncated output for %s", o.path))
-				or.UpdateCursor(out.cursor)
+			if o.pool != nil {
+				o.commitByPath(or, out)
+			} else if err = o.rotateBySize(or, len(out.data)); err != nil {
+				// The batch isn't written, so the cursor stays put.
+				close(o.closing)
+				errChan <- err
+				ok = false
 			} else {
-				o.file.Sync()
-				or.UpdateCursor(out.cursor)
+				n, err := o.file.Write(out.data)
+				if err != nil {
+					or.LogError(fmt.Errorf("Can't write to %s: %s", o.path, err))
+				} else if n != len(out.data) {
+					or.LogError(fmt.Errorf("data loss - truncated output for %s", o.path))
+					or.UpdateCursor(out.cursor)
+				} else {
+					o.file.Sync()
+					or.UpdateCursor(out.cursor)
+				}
 			}
-			out.data = out.data[:0]
+			out.reset()
 			o.backChan <- out
 		case <-hupChan:
+			if o.pool != nil {
+				// Files are reopened as they're next written to.
+				o.pool.closeAll()
+				break
+			}
 			o.file.Close()
 			if err = o.openFile(); err != nil {
 				close(o.closing)
@@ -367,8 +539,138 @@ func (o *FileOutput) committer(or OutputRunner, errChan chan error) {
 				ok = false
 				break
 			}
+		case <-rotateTick:
+			if err = o.rotateFile(or); err != nil {
+				close(o.closing)
+				errChan <- err
+				ok = false
+			}
+		}
+	}
+}
+
+// Rotates the output file before `size` more bytes are written to it if that
+// would take it past `max_file_size`.
+func (o *FileOutput) rotateBySize(or OutputRunner, size int) error {
+	if o.MaxFileSize == 0 {
+		return nil
+	}
+	info, err := o.file.Stat()
+	if err != nil {
+		return fmt.Errorf("can't stat %s: %s", o.path, err)
+	}
+	if uint64(info.Size())+uint64(size) <= o.MaxFileSize {
+		return nil
+	}
+	return o.rotateFile(or)
+}
+
+// Renames the output file with a timestamp suffix, compressing it if
+// configured, and opens a new file at the output path. Every batch written to
+// the old file has already been synced and had its cursor committed. Only
+// failing to open the new file is fatal.
+func (o *FileOutput) rotateFile(or OutputRunner) error {
+	info, err := o.file.Stat()
+	if err == nil && info.Size() <= int64(len(o.header)) {
+		// Nothing written since the file was opened.
+		return nil
+	}
+	o.file.Close()
+	ext := compression.Extension(o.RotateCompression)
+	rotated := rotatedPath(o.path, ext, time.Now())
+	if err = os.Rename(o.path, rotated); err != nil {
+		or.LogError(fmt.Errorf("can't rotate %s: %s", o.path, err))
+		rotated = ""
+	}
+	if err = o.openFile(); err != nil {
+		return fmt.Errorf("unable to open file '%s' after rotation: %s", o.path, err)
+	}
+	if rotated != "" && ext != "" {
+		if err = o.compressFile(rotated, ext); err != nil {
+			or.LogError(fmt.Errorf("can't compress %s: %s", rotated, err))
+		}
+	}
+	return nil
+}
+
+// Returns the name a rotated file is renamed to, i.e. the path with a
+// timestamp suffix, plus a counter if the file was already rotated within
+// the same second. `ext` is the extension the file gets when it's compressed.
+func rotatedPath(path, ext string, t time.Time) string {
+	base := fmt.Sprintf("%s.%s", path, t.Format("20060102-150405"))
+	rotated := base
+	for i := 1; fileExists(rotated) || fileExists(rotated+ext); i++ {
+		rotated = fmt.Sprintf("%s.%d", base, i)
+	}
+	return rotated
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// Compresses the file to a file with the given extension next to it, removing
+// the original once the compressed copy is complete.
+func (o *FileOutput) compressFile(path, ext string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	compressedPath := path + ext
+	out, err := os.OpenFile(compressedPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, o.perm)
+	if err != nil {
+		return err
+	}
+	w, err := compression.NewWriter(out, o.RotateCompression,
+		o.RotateCompressionLevel)
+	if err == nil {
+		if _, err = io.Copy(w, in); err == nil {
+			if err = w.Close(); err == nil {
+				err = out.Sync()
+			}
 		}
 	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(compressedPath)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Returns the `path_template` file path for the message.
+func (o *FileOutput) templatePath(msg *message.Message) string {
+	return filepath.Clean(o.template.Render(msg))
+}
+
+// Writes each of the batch's chunks of data to its own file. The cursor is
+// only updated if every write succeeded.
+func (o *FileOutput) commitByPath(or OutputRunner, out *outBatch) {
+	failed := false
+	for path, data := range out.byPath {
+		file, err := o.pool.get(path)
+		if err != nil {
+			or.LogError(fmt.Errorf("Can't open %s: %s", path, err))
+			failed = true
+			continue
+		}
+		n, err := file.Write(data)
+		if err != nil {
+			or.LogError(fmt.Errorf("Can't write to %s: %s", path, err))
+			failed = true
+		} else if n != len(data) {
+			or.LogError(fmt.Errorf("data loss - truncated output for %s", path))
+		} else {
+			file.Sync()
+		}
+	}
+	if !failed {
+		or.UpdateCursor(out.cursor)
+	}
 }
 
 func init() {
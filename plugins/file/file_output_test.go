@@ -25,6 +25,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mozilla-services/heka/compression"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/mozilla-services/heka/plugins"
@@ -143,6 +145,52 @@ func FileOutputSpec(c gs.Context) {
 
 		})
 
+		c.Specify("writes the encoder's header at the start of each file", func() {
+			csvEncoder := new(plugins.CsvEncoder)
+			csvConfig := csvEncoder.ConfigStruct().(*plugins.CsvEncoderConfig)
+			csvConfig.Columns = []string{"Type", "Payload"}
+			csvConfig.PrintHeader = true
+			err := csvEncoder.Init(csvConfig)
+			c.Assume(err, gs.IsNil)
+			header := "Type,Payload\r\n"
+
+			err = fileOutput.Init(config)
+			c.Assume(err, gs.IsNil)
+			oth.MockOutputRunner.EXPECT().Encoder().Return(csvEncoder)
+			oth.MockOutputRunner.EXPECT().InChan().Return(inChan)
+			close(inChan)
+			err = fileOutput.Run(oth.MockOutputRunner, oth.MockHelper)
+			c.Assume(err, gs.IsNil)
+			<-fileOutput.closing // Wait for the committer to close the file.
+			contents, err := ioutil.ReadFile(tmpFilePath)
+			c.Assume(err, gs.IsNil)
+			c.Expect(string(contents), gs.Equals, header)
+
+			// The encoder leaves the header to us.
+			output, err := csvEncoder.Encode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(output), gs.Equals, "TEST,Test Payload\r\n")
+
+			c.Specify("but not when appending to a file", func() {
+				err = fileOutput.openFile()
+				c.Assume(err, gs.IsNil)
+				fileOutput.file.Close()
+				contents, err = ioutil.ReadFile(tmpFilePath)
+				c.Assume(err, gs.IsNil)
+				c.Expect(string(contents), gs.Equals, header)
+			})
+
+			c.Specify("when a new file is opened", func() {
+				os.Remove(tmpFilePath)
+				err = fileOutput.openFile()
+				c.Assume(err, gs.IsNil)
+				fileOutput.file.Close()
+				contents, err = ioutil.ReadFile(tmpFilePath)
+				c.Assume(err, gs.IsNil)
+				c.Expect(string(contents), gs.Equals, header)
+			})
+		})
+
 		c.Specify("processes incoming messages", func() {
 			err := fileOutput.Init(config)
 			c.Assume(err, gs.IsNil)
@@ -255,6 +303,234 @@ func FileOutputSpec(c gs.Context) {
 			})
 		}
 
+		c.Specify("w/ in-process rotation", func() {
+			tmpDir, err := ioutil.TempDir("", "fileoutput-rotate")
+			c.Assume(err, gs.IsNil)
+			defer os.RemoveAll(tmpDir)
+			config.Path = filepath.Join(tmpDir, "out.log")
+			config.MaxFileSize = 12
+
+			commit := func(batches ...string) {
+				go fileOutput.committer(oth.MockOutputRunner, errChan)
+				go func() {
+					<-fileOutput.backChan // The committer's initial batch.
+					for i, data := range batches {
+						cursor := fmt.Sprintf("cursor%d", i)
+						oth.MockOutputRunner.EXPECT().UpdateCursor(cursor)
+						fileOutput.batchChan <- &outBatch{data: []byte(data), cursor: cursor}
+						<-fileOutput.backChan
+					}
+					close(fileOutput.batchChan)
+				}()
+				<-fileOutput.closing
+			}
+
+			c.Specify("rotates once the file would exceed max_file_size", func() {
+				err := fileOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				commit("first\n", "second\n", "3\n")
+
+				contents, err := ioutil.ReadFile(config.Path)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(contents), gs.Equals, "second\n3\n")
+				rotated, err := filepath.Glob(config.Path + ".*")
+				c.Assume(err, gs.IsNil)
+				c.Assume(len(rotated), gs.Equals, 1)
+				contents, err = ioutil.ReadFile(rotated[0])
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(contents), gs.Equals, "first\n")
+			})
+
+			expectCompressed := func(algorithm string) {
+				err := fileOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				commit("first\n", "second\n")
+
+				rotated, err := filepath.Glob(config.Path + ".*")
+				c.Assume(err, gs.IsNil)
+				c.Assume(len(rotated), gs.Equals, 1)
+				c.Expect(filepath.Ext(rotated[0]), gs.Equals, compression.Extension(algorithm))
+				file, err := os.Open(rotated[0])
+				c.Assume(err, gs.IsNil)
+				defer file.Close()
+				r, err := compression.NewReader(file, algorithm)
+				c.Assume(err, gs.IsNil)
+				defer r.Close()
+				contents, err := ioutil.ReadAll(r)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(contents), gs.Equals, "first\n")
+			}
+
+			c.Specify("gzips the rotated files", func() {
+				config.RotateCompression = compression.Gzip
+				expectCompressed(compression.Gzip)
+			})
+
+			c.Specify("zstd compresses the rotated files", func() {
+				config.RotateCompression = compression.Zstd
+				expectCompressed(compression.Zstd)
+			})
+
+			c.Specify("gzips the rotated files with rotate_gzip", func() {
+				config.RotateGzip = true
+				expectCompressed(compression.Gzip)
+			})
+
+			c.Specify("doesn't reuse the name of a file rotated in the same second", func() {
+				now := time.Now()
+				first := rotatedPath(config.Path, ".gz", now)
+				c.Expect(first, gs.Equals, config.Path+"."+now.Format("20060102-150405"))
+				err := ioutil.WriteFile(first+".gz", nil, 0644)
+				c.Assume(err, gs.IsNil)
+				c.Expect(rotatedPath(config.Path, ".gz", now), gs.Equals, first+".1")
+			})
+
+			c.Specify("rejects invalid settings", func() {
+				c.Specify("with an invalid interval", func() {
+					config.RotateInterval = "daily"
+					c.Expect(fileOutput.Init(config), gs.Not(gs.IsNil))
+				})
+
+				c.Specify("with an unknown compression", func() {
+					config.RotateCompression = "lzma"
+					c.Expect(fileOutput.Init(config), gs.Not(gs.IsNil))
+				})
+
+				c.Specify("with an invalid compression level", func() {
+					config.RotateCompression = compression.Gzip
+					config.RotateCompressionLevel = 12
+					c.Expect(fileOutput.Init(config), gs.Not(gs.IsNil))
+				})
+
+				c.Specify("with rotate_gzip and another compression", func() {
+					config.RotateGzip = true
+					config.RotateCompression = compression.Zstd
+					c.Expect(fileOutput.Init(config), gs.Not(gs.IsNil))
+				})
+
+				c.Specify("with rotation_interval", func() {
+					config.RotationInterval = 24
+					c.Expect(fileOutput.Init(config), gs.Not(gs.IsNil))
+				})
+			})
+		})
+
+		c.Specify("w/ a path_template", func() {
+			tmpDir, err := ioutil.TempDir("", "fileoutput-template")
+			c.Assume(err, gs.IsNil)
+			defer os.RemoveAll(tmpDir)
+			config.Path = ""
+			config.PathTemplate = filepath.Join(tmpDir, "%{Type}", "%{tenant}.log")
+
+			c.Specify("interpolates message fields", func() {
+				err := fileOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				msg := pipeline_ts.GetTestMessage()
+				message.NewStringField(msg, "tenant", "acme")
+				c.Expect(fileOutput.templatePath(msg), gs.Equals,
+					filepath.Join(tmpDir, "TEST", "acme.log"))
+
+				c.Specify("replacing missing fields", func() {
+					msg = pipeline_ts.GetTestMessage()
+					c.Expect(fileOutput.templatePath(msg), gs.Equals,
+						filepath.Join(tmpDir, "TEST", "unknown.log"))
+				})
+
+				c.Specify("without leaving the directory", func() {
+					msg = pipeline_ts.GetTestMessage()
+					message.NewStringField(msg, "tenant", "../../etc/passwd")
+					c.Expect(fileOutput.templatePath(msg), gs.Equals,
+						filepath.Join(tmpDir, "TEST", ".._.._etc_passwd.log"))
+					msg.SetType("..")
+					c.Expect(fileOutput.templatePath(msg), gs.Equals,
+						filepath.Join(tmpDir, "_", ".._.._etc_passwd.log"))
+				})
+			})
+
+			c.Specify("rejects invalid settings", func() {
+				c.Specify("with a path", func() {
+					config.Path = tmpFilePath
+					c.Expect(fileOutput.Init(config), gs.Not(gs.IsNil))
+				})
+
+				c.Specify("with rotation", func() {
+					config.RotationInterval = 24
+					c.Expect(fileOutput.Init(config), gs.Not(gs.IsNil))
+				})
+
+				c.Specify("with no field references", func() {
+					config.PathTemplate = filepath.Join(tmpDir, "out.log")
+					c.Expect(fileOutput.Init(config), gs.Not(gs.IsNil))
+				})
+
+				c.Specify("with an unterminated field reference", func() {
+					config.PathTemplate = filepath.Join(tmpDir, "%{tenant.log")
+					c.Expect(fileOutput.Init(config), gs.Not(gs.IsNil))
+				})
+			})
+
+			c.Specify("groups batch data by path", func() {
+				config.FlushCount = 2
+				config.FlushInterval = 0
+				err := fileOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				msg2 := pipeline_ts.GetTestMessage()
+				message.NewStringField(msg2, "tenant", "acme")
+				msg2.SetPayload("MESSAGE 2")
+				pack2 := NewPipelinePack(pConfig.InputRecycleChan())
+				pack2.Message = msg2
+				pack2.QueueCursor = "queuecursor2"
+
+				oth.MockOutputRunner.EXPECT().InChan().Return(inChan)
+				oth.MockOutputRunner.EXPECT().Encode(pack).Return(encoder.Encode(pack))
+				oth.MockOutputRunner.EXPECT().Encode(pack2).Return(encoder.Encode(pack2))
+				go fileOutput.receiver(oth.MockOutputRunner, errChan)
+				inChan <- pack
+				inChan <- pack2
+				out := <-fileOutput.batchChan
+				c.Expect(len(out.data), gs.Equals, 0)
+				c.Expect(string(out.byPath[filepath.Join(tmpDir, "TEST", "unknown.log")]),
+					gs.Equals, "Test Payload\n")
+				c.Expect(string(out.byPath[filepath.Join(tmpDir, "TEST", "acme.log")]),
+					gs.Equals, "MESSAGE 2\n")
+				c.Expect(out.cursor, gs.Equals, "queuecursor2")
+				close(inChan)
+				fileOutput.backChan <- newOutBatch()
+			})
+
+			c.Specify("commits each path to its own file", func() {
+				config.MaxOpenFiles = 1
+				err := fileOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				pathA := filepath.Join(tmpDir, "a", "current.log")
+				pathB := filepath.Join(tmpDir, "b", "current.log")
+				batch := newOutBatch()
+				batch.byPath[pathA] = []byte("first a\n")
+				batch.byPath[pathB] = []byte("first b\n")
+				batch.cursor = "cursor1"
+				oth.MockOutputRunner.EXPECT().UpdateCursor("cursor1")
+
+				go fileOutput.committer(oth.MockOutputRunner, errChan)
+				go func() {
+					<-fileOutput.backChan // The committer's initial batch.
+					fileOutput.batchChan <- batch
+					batch := <-fileOutput.backChan
+					c.Expect(len(batch.byPath), gs.Equals, 0)
+					c.Expect(fileOutput.pool.size(), gs.Equals, 1)
+					close(fileOutput.batchChan)
+				}()
+				<-fileOutput.closing
+
+				c.Expect(fileOutput.pool.size(), gs.Equals, 0)
+				contents, err := ioutil.ReadFile(pathA)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(contents), gs.Equals, "first a\n")
+				contents, err = ioutil.ReadFile(pathB)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(contents), gs.Equals, "first b\n")
+			})
+		})
+
 		c.Specify("that starts receiving w/ a flush interval", func() {
 			config.FlushInterval = 100000000 // We'll trigger the timer manually.
 			inChan := make(chan *PipelinePack)
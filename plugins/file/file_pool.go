@@ -0,0 +1,119 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mozilla-services/heka/plugins"
+)
+
+// Keeps interpolated values from reaching outside of the directory they're
+// used in, path separators are replaced and "." or ".." become "_".
+func sanitizePathValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, value)
+	if value == "." || value == ".." {
+		return "_"
+	}
+	return value
+}
+
+// An open output file, tracked by the filePool.
+type pooledFile struct {
+	path string
+	file *os.File
+	elem *list.Element
+}
+
+// Bounded set of open output files. When opening another file would go past
+// the limit, the least recently used file is synced and closed.
+type filePool struct {
+	max        int
+	perm       os.FileMode
+	folderPerm os.FileMode
+	files      map[string]*pooledFile
+	// Written at the start of each new file, if set.
+	header []byte
+	// Files ordered from least to most recently used.
+	lru *list.List
+}
+
+func newFilePool(max int, perm, folderPerm os.FileMode) *filePool {
+	return &filePool{
+		max:        max,
+		perm:       perm,
+		folderPerm: folderPerm,
+		files:      make(map[string]*pooledFile),
+		lru:        list.New(),
+	}
+}
+
+// Returns the open file for the given path, opening it if needed.
+func (p *filePool) get(path string) (*os.File, error) {
+	if pf, ok := p.files[path]; ok {
+		p.lru.MoveToBack(pf.elem)
+		return pf.file, nil
+	}
+	if len(p.files) >= p.max {
+		p.remove(p.lru.Front().Value.(*pooledFile))
+	}
+	basePath := filepath.Dir(path)
+	if err := os.MkdirAll(basePath, p.folderPerm); err != nil {
+		return nil, fmt.Errorf("can't create the basepath: %s", err)
+	}
+	if err := plugins.CheckWritePermission(basePath); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, p.perm)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeFileHeader(file, p.header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	pf := &pooledFile{path: path, file: file}
+	pf.elem = p.lru.PushBack(pf)
+	p.files[path] = pf
+	return file, nil
+}
+
+func (p *filePool) remove(pf *pooledFile) {
+	pf.file.Sync()
+	pf.file.Close()
+	p.lru.Remove(pf.elem)
+	delete(p.files, pf.path)
+}
+
+// Number of files currently open.
+func (p *filePool) size() int {
+	return len(p.files)
+}
+
+// Syncs and closes all of the open files.
+func (p *filePool) closeAll() {
+	for e := p.lru.Front(); e != nil; e = p.lru.Front() {
+		p.remove(e.Value.(*pooledFile))
+	}
+}
@@ -0,0 +1,475 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package fluentd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/mozilla-services/heka/plugins/tcp"
+)
+
+// Entries waiting to be sent for a single tag, already MessagePack encoded.
+type forwardBatch struct {
+	entries []byte
+	count   int
+}
+
+// Output plugin that sends messages to a Fluentd (or Fluent Bit) server using
+// the forward protocol's Forward mode, optionally waiting for the server to
+// acknowledge each chunk before the messages are considered delivered.
+type ForwardOutput struct {
+	sentMessageCount int64
+	conf             *ForwardOutputConfig
+	or               OutputRunner
+	connection       net.Conn
+	reader           *bufio.Reader
+	ackTimeout       time.Duration
+	batches          map[string]*forwardBatch
+	batchCount       int
+	tlsConf          *tcp.TlsClientConfig
+	// Cursor of the most recently batched message, committed once everything
+	// batched has been sent.
+	queueCursor string
+}
+
+// ConfigStruct for ForwardOutput plugin.
+type ForwardOutputConfig struct {
+	// String representation of the TCP address of the Fluentd server.
+	// Defaults to "localhost:24224".
+	Address string
+	UseTls  bool `toml:"use_tls"`
+	Tls     tcp.TlsConfig
+	// Message field used as the Fluentd tag. Supports "Type", "Logger",
+	// "Hostname", and any dynamic field name. Defaults to "Type".
+	TagField string `toml:"tag_field"`
+	// Tag to use for messages with an empty or missing tag field. Defaults to
+	// "heka".
+	DefaultTag string `toml:"default_tag"`
+	// Number of messages to batch before sending them. Defaults to 100.
+	FlushCount int `toml:"flush_count"`
+	// Whether to request an ack for each chunk, only advancing the buffer
+	// cursor once it arrives. Defaults to true.
+	RequireAck bool `toml:"require_ack"`
+	// Number of seconds to wait for an ack before giving up on the connection
+	// and resending. Defaults to 30.
+	AckTimeout uint `toml:"ack_timeout"`
+	// Send timestamps as Fluentd EventTime values with nanosecond precision
+	// instead of integer seconds. Defaults to true.
+	EventTime bool `toml:"event_time"`
+	// Shared key for the forward protocol's authentication handshake. Leaving
+	// it empty disables the handshake.
+	SharedKey string `toml:"shared_key"`
+	// Hostname to identify ourselves with during the handshake. Defaults to
+	// Heka's hostname.
+	SelfHostname string `toml:"self_hostname"`
+	// Credentials sent if the server requires user authentication.
+	Username string
+	Password string
+	// Interval at which batched messages are flushed even if flush_count
+	// hasn't been reached, in seconds. Defaults to 1.
+	TickerInterval uint `toml:"ticker_interval"`
+	// Defaults to true for ForwardOutput.
+	UseBuffering *bool `toml:"use_buffering"`
+	Buffering    QueueBufferConfig
+}
+
+func (o *ForwardOutput) ConfigStruct() interface{} {
+	b := true
+	queueConfig := QueueBufferConfig{
+		CursorUpdateCount: 1,
+		MaxBufferSize:     0,
+		MaxFileSize:       128 * 1024 * 1024,
+		FullAction:        "shutdown",
+	}
+	return &ForwardOutputConfig{
+		Address:        "localhost:24224",
+		TagField:       "Type",
+		DefaultTag:     "heka",
+		FlushCount:     100,
+		RequireAck:     true,
+		AckTimeout:     30,
+		EventTime:      true,
+		TickerInterval: uint(1),
+		UseBuffering:   &b,
+		Buffering:      queueConfig,
+	}
+}
+
+func (o *ForwardOutput) Init(config interface{}) (err error) {
+	o.conf = config.(*ForwardOutputConfig)
+	if o.conf.FlushCount < 1 {
+		return errors.New("`flush_count` must be greater than zero")
+	}
+	if o.conf.Username != "" && o.conf.SharedKey == "" {
+		return errors.New("`username` requires `shared_key` to be set")
+	}
+	o.ackTimeout = time.Duration(o.conf.AckTimeout) * time.Second
+	o.batches = make(map[string]*forwardBatch)
+	if o.conf.UseTls {
+		if o.tlsConf, err = tcp.NewTlsClientConfig(&o.conf.Tls); err != nil {
+			return fmt.Errorf("TLS init error: %s", err)
+		}
+	}
+	return
+}
+
+func (o *ForwardOutput) Prepare(or OutputRunner, h PluginHelper) (err error) {
+	o.or = or
+	if o.conf.SelfHostname == "" {
+		o.conf.SelfHostname = h.PipelineConfig().Hostname()
+	}
+	if o.tlsConf != nil {
+		o.tlsConf.WatchReload()
+	}
+	return
+}
+
+func (o *ForwardOutput) ProcessMessage(pack *PipelinePack) (err error) {
+	// A full batch that couldn't be sent earlier has to go out before we take
+	// on anything new.
+	if o.batchCount >= o.conf.FlushCount {
+		if err = o.flush(); err != nil {
+			return NewRetryMessageError("can't flush: %s", err)
+		}
+	}
+
+	tag := o.tag(pack.Message)
+	batch, ok := o.batches[tag]
+	if !ok {
+		batch = new(forwardBatch)
+		o.batches[tag] = batch
+	}
+	batch.entries = o.appendEntry(batch.entries, pack.Message)
+	batch.count++
+	o.batchCount++
+	o.queueCursor = pack.QueueCursor
+
+	if o.batchCount >= o.conf.FlushCount {
+		if e := o.flush(); e != nil {
+			o.or.LogError(fmt.Errorf("can't flush: %s", e))
+		}
+	}
+	return nil
+}
+
+func (o *ForwardOutput) TimerEvent() (err error) {
+	if o.batchCount == 0 {
+		return
+	}
+	if err = o.flush(); err != nil {
+		err = fmt.Errorf("can't flush: %s", err)
+	}
+	return
+}
+
+// Messages are sent in batches by flush.
+func (o *ForwardOutput) DefersDelivery() bool {
+	return true
+}
+
+func (o *ForwardOutput) CleanUp() {
+	o.cleanupConn()
+	if o.tlsConf != nil {
+		o.tlsConf.Close()
+	}
+}
+
+func (o *ForwardOutput) cleanupConn() {
+	if o.connection != nil {
+		o.connection.Close()
+		o.connection = nil
+	}
+}
+
+// Returns the tag for a message.
+func (o *ForwardOutput) tag(msg *message.Message) (tag string) {
+	switch o.conf.TagField {
+	case "Type":
+		tag = msg.GetType()
+	case "Logger":
+		tag = msg.GetLogger()
+	case "Hostname":
+		tag = msg.GetHostname()
+	default:
+		if val, ok := msg.GetFieldValue(o.conf.TagField); ok {
+			tag = fmt.Sprint(val)
+		}
+	}
+	if tag == "" {
+		tag = o.conf.DefaultTag
+	}
+	return
+}
+
+// Appends a `[time, record]` entry for the message. The record holds the
+// message headers and dynamic fields, fields with multiple values become
+// arrays.
+func (o *ForwardOutput) appendEntry(b []byte, msg *message.Message) []byte {
+	b = appendArrayHeader(b, 2)
+	if o.conf.EventTime {
+		b = appendEventTime(b, msg.GetTimestamp())
+	} else {
+		b = appendInt(b, msg.GetTimestamp()/1e9)
+	}
+
+	b = appendMapHeader(b, 9+len(msg.Fields))
+	b = appendString(appendString(b, "Uuid"), msg.GetUuidString())
+	b = appendInt(appendString(b, "Timestamp"), msg.GetTimestamp())
+	b = appendString(appendString(b, "Type"), msg.GetType())
+	b = appendString(appendString(b, "Logger"), msg.GetLogger())
+	b = appendInt(appendString(b, "Severity"), int64(msg.GetSeverity()))
+	b = appendString(appendString(b, "Payload"), msg.GetPayload())
+	b = appendString(appendString(b, "EnvVersion"), msg.GetEnvVersion())
+	b = appendInt(appendString(b, "Pid"), int64(msg.GetPid()))
+	b = appendString(appendString(b, "Hostname"), msg.GetHostname())
+	for _, field := range msg.Fields {
+		b = appendField(appendString(b, field.GetName()), field)
+	}
+	return b
+}
+
+func appendField(b []byte, field *message.Field) []byte {
+	var n int
+	switch field.GetValueType() {
+	case message.Field_STRING:
+		n = len(field.ValueString)
+	case message.Field_BYTES:
+		n = len(field.ValueBytes)
+	case message.Field_INTEGER:
+		n = len(field.ValueInteger)
+	case message.Field_DOUBLE:
+		n = len(field.ValueDouble)
+	case message.Field_BOOL:
+		n = len(field.ValueBool)
+	}
+	if n == 0 {
+		return appendNil(b)
+	}
+	if n > 1 {
+		b = appendArrayHeader(b, n)
+	}
+	for i := 0; i < n; i++ {
+		switch field.GetValueType() {
+		case message.Field_STRING:
+			b = appendString(b, field.ValueString[i])
+		case message.Field_BYTES:
+			b = appendBin(b, field.ValueBytes[i])
+		case message.Field_INTEGER:
+			b = appendInt(b, field.ValueInteger[i])
+		case message.Field_DOUBLE:
+			b = appendFloat(b, field.ValueDouble[i])
+		case message.Field_BOOL:
+			b = appendBool(b, field.ValueBool[i])
+		}
+	}
+	return b
+}
+
+// Sends every pending batch, one Forward mode message per tag. Batches are
+// dropped as they're sent so a failure only leaves the unsent ones behind,
+// and the buffer cursor is only advanced once all of them have gone out.
+func (o *ForwardOutput) flush() (err error) {
+	if o.connection == nil {
+		if err = o.connect(); err != nil {
+			o.cleanupConn()
+			return fmt.Errorf("can't connect to %s: %s", o.conf.Address, err)
+		}
+	}
+	for tag, batch := range o.batches {
+		if err = o.send(tag, batch); err != nil {
+			o.cleanupConn()
+			return
+		}
+		delete(o.batches, tag)
+		o.batchCount -= batch.count
+		atomic.AddInt64(&o.sentMessageCount, int64(batch.count))
+	}
+	o.or.UpdateCursor(o.queueCursor)
+	return
+}
+
+func (o *ForwardOutput) send(tag string, batch *forwardBatch) (err error) {
+	var chunk string
+	b := appendArrayHeader(nil, 3)
+	b = appendString(b, tag)
+	b = appendArrayHeader(b, batch.count)
+	b = append(b, batch.entries...)
+	if o.conf.RequireAck {
+		if chunk, err = newChunkId(); err != nil {
+			return
+		}
+		b = appendMapHeader(b, 2)
+		b = appendString(appendString(b, "chunk"), chunk)
+	} else {
+		b = appendMapHeader(b, 1)
+	}
+	b = appendInt(appendString(b, "size"), int64(batch.count))
+
+	if _, err = o.connection.Write(b); err != nil {
+		return fmt.Errorf("writing to %s: %s", o.conf.Address, err)
+	}
+	if !o.conf.RequireAck {
+		return
+	}
+
+	o.connection.SetReadDeadline(time.Now().Add(o.ackTimeout))
+	defer o.connection.SetReadDeadline(time.Time{})
+	resp, err := decodeMsgpack(o.reader)
+	if err != nil {
+		return fmt.Errorf("reading ack: %s", err)
+	}
+	m, ok := resp.(map[string]interface{})
+	if !ok || asString(m["ack"]) != chunk {
+		return fmt.Errorf("unexpected ack response: %v", resp)
+	}
+	return
+}
+
+func (o *ForwardOutput) connect() (err error) {
+	if o.conf.UseTls {
+		var goTlsConf *tls.Config
+		if goTlsConf, err = o.tlsConf.Config(); err != nil {
+			return
+		}
+		o.connection, err = tls.Dial("tcp", o.conf.Address, goTlsConf)
+	} else {
+		o.connection, err = net.Dial("tcp", o.conf.Address)
+	}
+	if err != nil {
+		return
+	}
+	o.reader = bufio.NewReader(o.connection)
+	if o.conf.SharedKey != "" {
+		o.connection.SetReadDeadline(time.Now().Add(o.ackTimeout))
+		defer o.connection.SetReadDeadline(time.Time{})
+		err = o.handshake()
+	}
+	return
+}
+
+// Answers the server's HELO with a PING carrying our shared key digest (and
+// user credentials, if it asks for them), then checks the server's digest in
+// its PONG.
+func (o *ForwardOutput) handshake() (err error) {
+	helo, err := o.readCommand("HELO", 2)
+	if err != nil {
+		return
+	}
+	options, _ := helo[1].(map[string]interface{})
+	nonce := asString(options["nonce"])
+	authSalt := asString(options["auth"])
+
+	var salt string
+	if salt, err = newChunkId(); err != nil {
+		return
+	}
+	hostname := o.conf.SelfHostname
+	username, passwordDigest := "", ""
+	if authSalt != "" {
+		username = o.conf.Username
+		passwordDigest = sha512Hex(authSalt, username, o.conf.Password)
+	}
+	b := appendArrayHeader(nil, 6)
+	b = appendString(b, "PING")
+	b = appendString(b, hostname)
+	b = appendString(b, salt)
+	b = appendString(b, sha512Hex(salt, hostname, nonce, o.conf.SharedKey))
+	b = appendString(b, username)
+	b = appendString(b, passwordDigest)
+	if _, err = o.connection.Write(b); err != nil {
+		return
+	}
+
+	pong, err := o.readCommand("PONG", 5)
+	if err != nil {
+		return
+	}
+	if ok, _ := pong[1].(bool); !ok {
+		return fmt.Errorf("authentication failed: %s", asString(pong[2]))
+	}
+	serverHostname := asString(pong[3])
+	if asString(pong[4]) != sha512Hex(salt, serverHostname, nonce, o.conf.SharedKey) {
+		return errors.New("server's shared key digest doesn't match")
+	}
+	return
+}
+
+// Reads a handshake message, checking its name and minimum length.
+func (o *ForwardOutput) readCommand(name string, minLen int) (
+	cmd []interface{}, err error) {
+
+	resp, err := decodeMsgpack(o.reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", name, err)
+	}
+	cmd, ok := resp.([]interface{})
+	if !ok || len(cmd) < minLen || asString(cmd[0]) != name {
+		return nil, fmt.Errorf("expected %s, got: %v", name, resp)
+	}
+	return
+}
+
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (o *ForwardOutput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "SentMessageCount",
+		atomic.LoadInt64(&o.sentMessageCount), "count")
+	return nil
+}
+
+func newChunkId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func sha512Hex(parts ...string) string {
+	h := sha512.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Servers may send strings as either str or bin.
+func asString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
+
+func init() {
+	RegisterPlugin("ForwardOutput", func() interface{} {
+		return new(ForwardOutput)
+	})
+}
@@ -0,0 +1,214 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package fluentd
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	plugins_ts "github.com/mozilla-services/heka/plugins/testsupport"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func MsgpackSpec(c gs.Context) {
+	decode := func(b []byte) interface{} {
+		v, err := decodeMsgpack(bufio.NewReader(bytes.NewReader(b)))
+		c.Assume(err, gs.IsNil)
+		return v
+	}
+
+	c.Specify("Msgpack encoding", func() {
+		c.Specify("round trips integers", func() {
+			for _, i := range []int64{0, 1, 127, 128, -1, -32, -33, -129, 70000,
+				-70000, 1 << 40, -(1 << 40)} {
+
+				c.Expect(decode(appendInt(nil, i)), gs.Equals, i)
+			}
+		})
+
+		c.Specify("round trips strings of every length class", func() {
+			for _, n := range []int{0, 31, 32, 255, 256, 70000} {
+				s := string(bytes.Repeat([]byte("x"), n))
+				c.Expect(decode(appendString(nil, s)), gs.Equals, s)
+			}
+		})
+
+		c.Specify("round trips nested containers", func() {
+			b := appendArrayHeader(nil, 3)
+			b = appendBool(b, true)
+			b = appendFloat(b, 1.5)
+			b = appendMapHeader(b, 1)
+			b = appendBin(appendString(b, "key"), []byte("value"))
+			a := decode(b).([]interface{})
+			c.Expect(len(a), gs.Equals, 3)
+			c.Expect(a[0], gs.Equals, true)
+			c.Expect(a[1], gs.Equals, 1.5)
+			m := a[2].(map[string]interface{})
+			c.Expect(string(m["key"].([]byte)), gs.Equals, "value")
+		})
+
+		c.Specify("encodes EventTime as extension type 0", func() {
+			b := appendEventTime(nil, 1500000000123456789)
+			c.Expect(bytes.Equal(b, []byte{0xd7, 0x00, 0x59, 0x68, 0x2f, 0x00, 0x07,
+				0x5b, 0xcd, 0x15}), gs.IsTrue)
+		})
+	})
+}
+
+func ForwardOutputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	pConfig := NewPipelineConfig(nil)
+
+	c.Specify("A ForwardOutput", func() {
+		output := new(ForwardOutput)
+		config := output.ConfigStruct().(*ForwardOutputConfig)
+		config.FlushCount = 2
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		c.Assume(err, gs.IsNil)
+		defer ln.Close()
+		config.Address = ln.Addr().String()
+
+		oth := plugins_ts.NewOutputTestHelper(ctrl)
+		oth.MockHelper.EXPECT().PipelineConfig().Return(pConfig)
+
+		msg := pipeline_ts.GetTestMessage()
+		pack := NewPipelinePack(nil)
+		pack.Message = msg
+
+		// Fake Fluentd server, optionally running the shared key handshake,
+		// that acks and hands back the first Forward mode message it gets.
+		received := make(chan []interface{}, 1)
+		serve := func(sharedKey string) {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			if sharedKey != "" {
+				b := appendArrayHeader(nil, 2)
+				b = appendString(b, "HELO")
+				b = appendMapHeader(b, 2)
+				b = appendString(appendString(b, "nonce"), "nonce")
+				b = appendString(appendString(b, "auth"), "")
+				conn.Write(b)
+				v, err := decodeMsgpack(r)
+				if err != nil {
+					return
+				}
+				ping := v.([]interface{})
+				salt := ping[2].(string)
+				ok := ping[3].(string) == sha512Hex(salt, ping[1].(string), "nonce",
+					sharedKey)
+				b = appendArrayHeader(nil, 5)
+				b = appendString(b, "PONG")
+				b = appendBool(b, ok)
+				b = appendString(b, "")
+				b = appendString(b, "server")
+				b = appendString(b, sha512Hex(salt, "server", "nonce", sharedKey))
+				conn.Write(b)
+			}
+			v, err := decodeMsgpack(r)
+			if err != nil {
+				return
+			}
+			forward := v.([]interface{})
+			option := forward[2].(map[string]interface{})
+			if chunk, ok := option["chunk"]; ok {
+				b := appendMapHeader(nil, 1)
+				b = appendString(appendString(b, "ack"), chunk.(string))
+				conn.Write(b)
+			}
+			received <- forward
+		}
+
+		c.Specify("batches entries per tag and commits after the ack", func() {
+			go serve("")
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			err = output.Prepare(oth.MockOutputRunner, oth.MockHelper)
+			c.Assume(err, gs.IsNil)
+
+			oth.MockOutputRunner.EXPECT().UpdateCursor("cursor2")
+			pack.QueueCursor = "cursor1"
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+			pack.QueueCursor = "cursor2"
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+
+			forward := <-received
+			c.Expect(forward[0], gs.Equals, msg.GetType())
+			entries := forward[1].([]interface{})
+			c.Expect(len(entries), gs.Equals, 2)
+			record := entries[0].([]interface{})[1].(map[string]interface{})
+			c.Expect(record["Payload"], gs.Equals, msg.GetPayload())
+			c.Expect(record["Timestamp"], gs.Equals, msg.GetTimestamp())
+			foo, _ := msg.GetFieldValue("foo")
+			c.Expect(record["foo"], gs.Equals, foo)
+			c.Expect(output.batchCount, gs.Equals, 0)
+		})
+
+		c.Specify("uses a dynamic field as the tag", func() {
+			config.TagField = "foo"
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			err = output.Prepare(oth.MockOutputRunner, oth.MockHelper)
+			c.Assume(err, gs.IsNil)
+
+			foo, _ := msg.GetFieldValue("foo")
+			c.Expect(output.tag(msg), gs.Equals, foo)
+			msg.Fields = nil
+			c.Expect(output.tag(msg), gs.Equals, "heka")
+		})
+
+		c.Specify("authenticates with a shared key", func() {
+			config.SharedKey = "secret"
+			go serve("secret")
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			err = output.Prepare(oth.MockOutputRunner, oth.MockHelper)
+			c.Assume(err, gs.IsNil)
+
+			oth.MockOutputRunner.EXPECT().UpdateCursor("cursor")
+			pack.QueueCursor = "cursor"
+			output.ProcessMessage(pack)
+			c.Expect(output.TimerEvent(), gs.IsNil)
+			forward := <-received
+			c.Expect(len(forward[1].([]interface{})), gs.Equals, 1)
+		})
+
+		c.Specify("keeps the batch when the handshake fails", func() {
+			config.SharedKey = "secret"
+			go serve("wrong")
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			err = output.Prepare(oth.MockOutputRunner, oth.MockHelper)
+			c.Assume(err, gs.IsNil)
+
+			output.ProcessMessage(pack)
+			err = output.TimerEvent()
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(output.batchCount, gs.Equals, 1)
+			c.Expect(output.connection, gs.IsNil)
+		})
+	})
+}
@@ -0,0 +1,272 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package fluentd
+
+// Just enough MessagePack (https://github.com/msgpack/msgpack/blob/master/spec.md)
+// to speak the Fluentd forward protocol: appending encoders for the types a
+// Heka message can hold, and a generic decoder for the server's responses.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+func appendNil(b []byte) []byte {
+	return append(b, 0xc0)
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+func appendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		return append(b, byte(v))
+	case v < 0 && v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return append(b, 0xd1, byte(v>>8), byte(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return appendUint32(append(b, 0xd2), uint32(v))
+	}
+	return appendUint64(append(b, 0xd3), uint64(v))
+}
+
+func appendFloat(b []byte, v float64) []byte {
+	return appendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendBin(b []byte, v []byte) []byte {
+	n := len(v)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xc5, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, v...)
+}
+
+func appendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xdc, byte(n>>8), byte(n))
+	}
+	return appendUint32(append(b, 0xdd), uint32(n))
+}
+
+func appendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	}
+	return appendUint32(append(b, 0xdf), uint32(n))
+}
+
+// Appends a Fluentd EventTime, i.e. extension type 0 holding the seconds and
+// nanoseconds as big endian 32 bit integers.
+func appendEventTime(b []byte, ns int64) []byte {
+	b = append(b, 0xd7, 0x00)
+	b = appendUint32(b, uint32(ns/1e9))
+	return appendUint32(b, uint32(ns%1e9))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// Decodes a single MessagePack object. Maps are returned as
+// map[string]interface{} (non-string keys are formatted with fmt), arrays as
+// []interface{}, str as string, bin as []byte, integers as int64 (or uint64
+// if they don't fit), floats as float64, and extension types as []byte.
+func decodeMsgpack(r *bufio.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return readString(r, int(c&0x1f))
+	case c&0xf0 == 0x90:
+		return readArray(r, int(c&0x0f))
+	case c&0xf0 == 0x80:
+		return readMap(r, int(c&0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readLength(r, c-0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return readBytes(r, n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := readLength(r, c-0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return readBytes(r, n+1) // Includes the type byte.
+	case 0xca:
+		v, err := readUint(r, 4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := readUint(r, 8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := readUint(r, 1<<(c-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if v > math.MaxInt64 {
+			return v, nil
+		}
+		return int64(v), nil
+	case 0xd0:
+		v, err := readUint(r, 1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := readUint(r, 2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := readUint(r, 4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := readUint(r, 8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return readBytes(r, 1+1<<(c-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := readLength(r, c-0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return readString(r, n)
+	case 0xdc, 0xdd:
+		n, err := readLength(r, c-0xdc+1)
+		if err != nil {
+			return nil, err
+		}
+		return readArray(r, n)
+	case 0xde, 0xdf:
+		n, err := readLength(r, c-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		return readMap(r, n)
+	}
+	return nil, fmt.Errorf("invalid MessagePack type byte: 0x%x", c)
+}
+
+// Reads a 1, 2, or 4 byte length, selected by sizeIdx 0, 1, or 2.
+func readLength(r *bufio.Reader, sizeIdx byte) (int, error) {
+	v, err := readUint(r, 1<<sizeIdx)
+	return int(v), err
+}
+
+func readUint(r *bufio.Reader, size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func readBytes(r *bufio.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+func readString(r *bufio.Reader, n int) (string, error) {
+	b, err := readBytes(r, n)
+	return string(b), err
+}
+
+func readArray(r *bufio.Reader, n int) ([]interface{}, error) {
+	a := make([]interface{}, n)
+	for i := range a {
+		v, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func readMap(r *bufio.Reader, n int) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		m[key] = v
+	}
+	return m, nil
+}
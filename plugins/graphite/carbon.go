@@ -22,6 +22,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/mozilla-services/heka/pipeline"
 )
@@ -34,6 +35,8 @@ type CarbonOutput struct {
 	*net.TCPAddr
 	*net.TCPConn
 	send func(or OutputRunner, data []byte)
+	// Metrics waiting to be sent when using the pickle protocol.
+	batch []carbonMetric
 }
 
 // ConfigStruct for CarbonOutput plugin.
@@ -45,14 +48,25 @@ type CarbonOutputConfig struct {
 	TCPKeepAlive bool `toml:"tcp_keep_alive"`
 	// If true, use UDP rather than TCP (default) to send the data
 	Protocol string `toml:"protocol"`
+	// Number of metrics sent at once when using the pickle protocol.
+	// Defaults to 500.
+	BatchSize int `toml:"batch_size"`
+	// Maximum number of milliseconds metrics are held back for when using
+	// the pickle protocol. Defaults to 1000.
+	FlushInterval uint32 `toml:"flush_interval"`
 }
 
 func (t *CarbonOutput) ConfigStruct() interface{} {
-	return &CarbonOutputConfig{Address: "localhost:2003"}
+	return &CarbonOutputConfig{
+		Address:       "localhost:2003",
+		BatchSize:     500,
+		FlushInterval: 1000,
+	}
 }
 
 func (t *CarbonOutput) Init(config interface{}) (err error) {
 	t.CarbonOutputConfig = config.(*CarbonOutputConfig)
+	t.batch = nil
 
 	switch t.Protocol {
 	case "", "tcp":
@@ -62,8 +76,18 @@ func (t *CarbonOutput) Init(config interface{}) (err error) {
 		t.send = t.sendUDP
 		t.UDPAddr, err = net.ResolveUDPAddr("udp", t.Address)
 		t.bufSplitSize = 63488 // 62KiB
+	case "pickle":
+		if t.BatchSize < 1 {
+			return fmt.Errorf("CarbonOutput: batch_size must be greater than 0")
+		}
+		if t.FlushInterval == 0 {
+			return fmt.Errorf("CarbonOutput: flush_interval must be greater than 0")
+		}
+		t.send = t.sendTCP
+		t.TCPAddr, err = net.ResolveTCPAddr("tcp", t.Address)
+		t.batch = make([]carbonMetric, 0, t.BatchSize)
 	default:
-		err = fmt.Errorf(`CarbonOutput: "%s" is not a supported protocol, must be "tcp" or "udp"`, t.Protocol)
+		err = fmt.Errorf(`CarbonOutput: "%s" is not a supported protocol, must be "tcp", "udp" or "pickle"`, t.Protocol)
 	}
 
 	return
@@ -77,6 +101,10 @@ func (t *CarbonOutput) ProcessPack(pack *PipelinePack, or OutputRunner) {
 	or.UpdateCursor(pack.QueueCursor)
 	pack.Recycle(nil)
 
+	var (
+		timestamp uint64
+		value     float64
+	)
 	lines := strings.Split(payload, "\n")
 	clean_statmetrics := make([]string, len(lines))
 	index := 0
@@ -88,17 +116,27 @@ func (t *CarbonOutput) ProcessPack(pack *PipelinePack, or OutputRunner) {
 			continue
 		}
 
-		if _, e = strconv.ParseUint(fields[2], 0, 32); e != nil {
+		if timestamp, e = strconv.ParseUint(fields[2], 0, 32); e != nil {
 			or.LogError(fmt.Errorf("parsing time: %s", e))
 			continue
 		}
-		if _, e = strconv.ParseFloat(fields[1], 64); e != nil {
+		if value, e = strconv.ParseFloat(fields[1], 64); e != nil {
 			or.LogError(fmt.Errorf("parsing value '%s': %s", fields[1], e))
 			continue
 		}
+		if t.batch != nil {
+			t.batch = append(t.batch, carbonMetric{fields[0], value, uint32(timestamp)})
+			if len(t.batch) >= t.BatchSize {
+				t.flush(or)
+			}
+			continue
+		}
 		clean_statmetrics[index] = line
 		index += 1
 	}
+	if t.batch != nil {
+		return
+	}
 	clean_statmetrics = clean_statmetrics[:index]
 
 	// Stuff each parseable statmetric into a bytebuffer
@@ -115,6 +153,15 @@ func (t *CarbonOutput) ProcessPack(pack *PipelinePack, or OutputRunner) {
 	t.send(or, buffer.Bytes())
 }
 
+// Sends the batched metrics using the pickle protocol.
+func (t *CarbonOutput) flush(or OutputRunner) {
+	if len(t.batch) == 0 {
+		return
+	}
+	t.send(or, encodePickle(t.batch))
+	t.batch = t.batch[:0]
+}
+
 func (t *CarbonOutput) sendTCP(or OutputRunner, data []byte) {
 	write := func() (err error) {
 		if t.TCPConn == nil {
@@ -177,9 +224,28 @@ func (t *CarbonOutput) Run(or OutputRunner, h PluginHelper) (err error) {
 		pack *PipelinePack
 	)
 
-	for pack = range or.InChan() {
-		t.ProcessPack(pack, or)
+	if t.batch == nil {
+		for pack = range or.InChan() {
+			t.ProcessPack(pack, or)
+		}
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(t.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+	inChan := or.InChan()
+	ok := true
+	for ok {
+		select {
+		case pack, ok = <-inChan:
+			if ok {
+				t.ProcessPack(pack, or)
+			}
+		case <-ticker.C:
+			t.flush(or)
+		}
 	}
+	t.flush(or)
 
 	return
 }
@@ -71,6 +71,15 @@ func CarbonOutputSpec(c gs.Context) {
 		return pack
 	}
 
+	c.Specify("encodePickle", func() {
+		c.Specify("pickles metrics as (name, (timestamp, value)) tuples", func() {
+			data := encodePickle([]carbonMetric{{"a.b", 1.5, 1476500000}})
+			c.Expect(string(data), gs.Equals, "\x00\x00\x00\x1b\x80\x02\x5d\x28"+
+				"\x55\x03\x61\x2e\x62\x4a\x20\x9a\x01\x58\x47\x3f\xf8\x00\x00"+
+				"\x00\x00\x00\x00\x86\x86\x65\x2e")
+		})
+	})
+
 	c.Specify("A CarbonOutput ", func() {
 		inChan := make(chan *PipelinePack, 1)
 		output := new(CarbonOutput)
@@ -184,6 +193,42 @@ func CarbonOutputSpec(c gs.Context) {
 				err = <-errChan
 				c.Expect(err, gs.IsNil)
 			})
+
+			c.Specify("writes pickled batches to the network", func() {
+				config.Protocol = "pickle"
+				config.BatchSize = count
+				config.Address = fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.TCPAddr).Port)
+				err = output.Init(config)
+				c.Assume(err, gs.IsNil)
+				inChan <- pack
+				go startOutput(output, oth)
+
+				select {
+				case err = <-errChan:
+					c.Assume(err, gs.IsNil)
+				case conn = <-connChan:
+					defer conn.Close()
+				}
+
+				go collectData(conn)
+
+				metrics := make([]carbonMetric, count)
+				for i := 0; i < count; i++ {
+					statTime := baseTime.Add(time.Duration(i) * time.Second)
+					metrics[i] = carbonMetric{fmt.Sprintf("stats.name.%d", i),
+						float64(i * 2), uint32(statTime.Unix())}
+				}
+				select {
+				case err = <-errChan:
+					c.Assume(err, gs.IsNil)
+				case data := <-dataChan:
+					c.Expect(data, gs.Equals, string(encodePickle(metrics)))
+				}
+
+				close(inChan)
+				err = <-errChan
+				c.Expect(err, gs.IsNil)
+			})
 		})
 
 		c.Specify("using UDP", func() {
@@ -0,0 +1,74 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// A single parsed statmetric.
+type carbonMetric struct {
+	name      string
+	value     float64
+	timestamp uint32
+}
+
+// Python pickle protocol 2 opcodes, see Python's pickletools module.
+const (
+	pickleProto      = 0x80
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleAppends    = 'e'
+	pickleShortBin   = 'U'
+	pickleBinString  = 'T'
+	pickleBinInt     = 'J'
+	pickleBinFloat   = 'G'
+	pickleTuple2     = 0x86
+	pickleStop       = '.'
+	pickleSizeHeader = 4
+)
+
+// Encodes the metrics as a message for carbon's pickle receiver, i.e. a
+// pickled list of `(name, (timestamp, value))` tuples prefixed with its
+// length as a 4 byte big endian integer.
+func encodePickle(metrics []carbonMetric) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(make([]byte, pickleSizeHeader))
+	buf.Write([]byte{pickleProto, 2, pickleEmptyList, pickleMark})
+	num := make([]byte, 8)
+	for _, m := range metrics {
+		if len(m.name) < 256 {
+			buf.Write([]byte{pickleShortBin, byte(len(m.name))})
+		} else {
+			buf.WriteByte(pickleBinString)
+			binary.LittleEndian.PutUint32(num, uint32(len(m.name)))
+			buf.Write(num[:4])
+		}
+		buf.WriteString(m.name)
+		buf.WriteByte(pickleBinInt)
+		binary.LittleEndian.PutUint32(num, m.timestamp)
+		buf.Write(num[:4])
+		buf.WriteByte(pickleBinFloat)
+		binary.BigEndian.PutUint64(num, math.Float64bits(m.value))
+		buf.Write(num)
+		buf.Write([]byte{pickleTuple2, pickleTuple2})
+	}
+	buf.Write([]byte{pickleAppends, pickleStop})
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-pickleSizeHeader))
+	return data
+}
@@ -24,6 +24,9 @@ func TestAllSpecs(t *testing.T) {
 	r.Parallel = false
 
 	r.AddSpec(HttpInputSpec)
+	r.AddSpec(HttpInputDecompressionSpec)
+	r.AddSpec(HttpInputMultipleUrlsSpec)
+	r.AddSpec(HttpInputPagingSpec)
 	r.AddSpec(HttpListenInputSpec)
 	r.AddSpec(HttpOutputSpec)
 
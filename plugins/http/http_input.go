@@ -18,13 +18,22 @@
 package http
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/mozilla-services/heka/compression"
 	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	"github.com/pborman/uuid"
@@ -53,8 +62,9 @@ type HttpInput struct {
 
 // Http Input config struct
 type HttpInputConfig struct {
-	// Url for HttpInput to request.
-	Url string
+	// Url for HttpInput to request, either a single URL string or a list of
+	// URLs.
+	Url interface{}
 	// Urls for HttpInput to request.
 	Urls []string
 	// Request method. Default is "GET"
@@ -72,6 +82,17 @@ type HttpInputConfig struct {
 	SuccessSeverity int32 `toml:"success_severity"`
 	// Severity level of errors and unsuccessful requests. Default is 1 (alert)
 	ErrorSeverity int32 `toml:"error_severity"`
+	// Dotted path to the next page's URL in a JSON response body, e.g.
+	// "links.next". If set, each poll keeps requesting the next page until
+	// a response doesn't have one.
+	NextUrlPath string `toml:"next_url_path"`
+	// Maximum number of pages to request for each URL per poll when
+	// following next_url_path. Default is 10.
+	MaxPages uint `toml:"max_pages"`
+	// Forces the response bodies to be decompressed with the given scheme,
+	// "gzip", "deflate" or "zstd", for servers that don't set the Content-Encoding
+	// header. By default the header decides.
+	Decompress string `toml:"decompress"`
 }
 
 func (hi *HttpInput) SetName(name string) {
@@ -84,18 +105,45 @@ func (hi *HttpInput) ConfigStruct() interface{} {
 		TickerInterval:  uint(10),
 		SuccessSeverity: int32(6),
 		ErrorSeverity:   int32(1),
+		MaxPages:        uint(10),
 	}
 }
 
 func (hi *HttpInput) Init(config interface{}) error {
 	hi.conf = config.(*HttpInputConfig)
-	if (hi.conf.Urls == nil) && (hi.conf.Url == "") {
+	hi.urls = nil
+	switch u := hi.conf.Url.(type) {
+	case nil:
+	case string:
+		if u != "" {
+			hi.urls = append(hi.urls, u)
+		}
+	case []string:
+		hi.urls = append(hi.urls, u...)
+	case []interface{}:
+		for _, item := range u {
+			itemStr, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("Url list must only contain strings, got %v", item)
+			}
+			hi.urls = append(hi.urls, itemStr)
+		}
+	default:
+		return fmt.Errorf("Url must be a string or a list of strings, got %v",
+			hi.conf.Url)
+	}
+	hi.urls = append(hi.urls, hi.conf.Urls...)
+	if len(hi.urls) == 0 {
 		return fmt.Errorf("Url or Urls must contain at least one URL")
 	}
-	if hi.conf.Urls != nil {
-		hi.urls = hi.conf.Urls
-	} else {
-		hi.urls = []string{hi.conf.Url}
+	if hi.conf.NextUrlPath != "" && hi.conf.MaxPages == 0 {
+		return fmt.Errorf("max_pages must be greater than 0")
+	}
+	switch hi.conf.Decompress {
+	case "", "gzip", "deflate", "zstd":
+	default:
+		return fmt.Errorf("decompress must be \"gzip\", \"deflate\" or \"zstd\", "+
+			"got \"%s\"", hi.conf.Decompress)
 	}
 	hi.stopChan = make(chan bool)
 
@@ -131,6 +179,7 @@ func (hi *HttpInput) makePackDecorator(respData ResponseData) func(*PipelinePack
 			pack.Message.SetSeverity(hi.conf.SuccessSeverity)
 		}
 		pack.Message.SetLogger(respData.Url)
+		hi.addField(pack, "url", respData.Url, "")
 		hi.addField(pack, "StatusCode", respData.StatusCode, "")
 		hi.addField(pack, "Status", respData.Status, "")
 		hi.addField(pack, "ResponseSize", respData.Size, "B")
@@ -140,7 +189,9 @@ func (hi *HttpInput) makePackDecorator(respData ResponseData) func(*PipelinePack
 	return packDecorator
 }
 
-func (hi *HttpInput) fetchUrl(url string, sRunner SplitterRunner) {
+// Fetches the URL and delivers the response, returning the URL of the next
+// page if next_url_path is in use and the response has one.
+func (hi *HttpInput) fetchUrl(url string, sRunner SplitterRunner) (nextUrl string) {
 	responseTimeStart := time.Now()
 	httpClient := &http.Client{}
 	req, err := http.NewRequest(hi.conf.Method, url, strings.NewReader(hi.conf.Body))
@@ -169,6 +220,7 @@ func (hi *HttpInput) fetchUrl(url string, sRunner SplitterRunner) {
 		pack.Message.SetPayload(err.Error())
 		pack.Message.SetSeverity(hi.conf.ErrorSeverity)
 		pack.Message.SetLogger(url)
+		hi.addField(pack, "url", url, "")
 		hi.ir.Deliver(pack)
 		return
 	}
@@ -186,11 +238,119 @@ func (hi *HttpInput) fetchUrl(url string, sRunner SplitterRunner) {
 		sRunner.SetPackDecorator(hi.makePackDecorator(respData))
 	}
 
-	err = sRunner.SplitStreamNullSplitterToEOF(resp.Body, nil)
+	var body io.Reader = resp.Body
+	encoding := hi.conf.Decompress
+	if encoding == "" {
+		// The transport drops the Content-Encoding header of bodies it
+		// already decompressed itself.
+		encoding = resp.Header.Get("Content-Encoding")
+	}
+	if body, err = decompressBody(body, encoding); err != nil {
+		hi.ir.LogError(fmt.Errorf("decompressing %s response: %s", url, err.Error()))
+		resp.Body.Close()
+		return
+	}
+	if hi.conf.NextUrlPath != "" && resp.StatusCode == 200 {
+		// We need the whole response to find the next page's URL.
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			hi.ir.LogError(fmt.Errorf("reading %s response: %s", url, err.Error()))
+			resp.Body.Close()
+			return
+		}
+		if nextUrl, err = nextPageUrl(data, hi.conf.NextUrlPath, url); err != nil {
+			hi.ir.LogError(fmt.Errorf("finding next page in %s response: %s", url,
+				err.Error()))
+		}
+		body = bytes.NewReader(data)
+	}
+
+	err = sRunner.SplitStreamNullSplitterToEOF(body, nil)
 	if err != nil && err != io.EOF {
 		hi.ir.LogError(fmt.Errorf("fetching %s response input: %s", url, err.Error()))
 	}
 	resp.Body.Close()
+	return
+}
+
+// Wraps the response body in a reader that decompresses it as it's read,
+// according to the Content-Encoding. Bodies with no or an unknown encoding are
+// returned untouched.
+func decompressBody(body io.Reader, encoding string) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		return compression.NewReader(body, compression.Gzip)
+	case "zstd":
+		return compression.NewReader(body, compression.Zstd)
+	case "deflate":
+		// Content-Encoding deflate is meant to be zlib wrapped, but plenty of
+		// servers send raw deflate data instead.
+		buffered := bufio.NewReader(body)
+		header, _ := buffered.Peek(2)
+		if len(header) == 2 && header[0]&0x0f == 8 &&
+			(uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	}
+	return body, nil
+}
+
+// Fetches the URL and, if next_url_path is in use, each of the following
+// pages, stopping when a page has no next URL, at a URL that's already been
+// requested, or after max_pages pages.
+func (hi *HttpInput) fetchPages(url string, sRunner SplitterRunner) {
+	seen := make(map[string]bool)
+	for pages := uint(0); url != "" && !seen[url]; pages++ {
+		if hi.conf.NextUrlPath != "" && pages >= hi.conf.MaxPages {
+			hi.ir.LogMessage(fmt.Sprintf("stopping after max_pages (%d) pages, "+
+				"next page is %s", hi.conf.MaxPages, url))
+			return
+		}
+		seen[url] = true
+		url = hi.fetchUrl(url, sRunner)
+	}
+}
+
+// Extracts the next page's URL from the JSON response body using the dotted
+// path, resolving it relative to the current page's URL. Returns "" if the
+// value is missing, null, or empty.
+func nextPageUrl(body []byte, path, pageUrl string) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", err
+	}
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	for _, key := range strings.Split(path, ".") {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			doc = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", nil
+			}
+			doc = node[i]
+		default:
+			return "", nil
+		}
+	}
+	next, ok := doc.(string)
+	if !ok && doc != nil {
+		return "", fmt.Errorf("%s isn't a string", path)
+	}
+	if next == "" {
+		return "", nil
+	}
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(next)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
 }
 
 func (hi *HttpInput) Run(ir InputRunner, h PluginHelper) (err error) {
@@ -213,16 +373,27 @@ func (hi *HttpInput) Run(ir InputRunner, h PluginHelper) (err error) {
 	for {
 		select {
 		case <-ticker:
-			for i, url := range hi.urls {
-				sRunner := hi.sRunners[i]
-				hi.fetchUrl(url, sRunner)
-			}
+			hi.pollUrls()
 		case <-hi.stopChan:
 			return nil
 		}
 	}
 }
 
+// Polls all of the URLs concurrently, each with its own SplitterRunner, and
+// waits for them all to finish so a slow URL is never polled twice at once.
+func (hi *HttpInput) pollUrls() {
+	var wg sync.WaitGroup
+	wg.Add(len(hi.urls))
+	for i, url := range hi.urls {
+		go func(url string, sRunner SplitterRunner) {
+			defer wg.Done()
+			hi.fetchPages(url, sRunner)
+		}(url, hi.sRunners[i])
+	}
+	wg.Wait()
+}
+
 func (hi *HttpInput) Stop() {
 	close(hi.stopChan)
 }
@@ -16,9 +16,19 @@
 package http
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"time"
 
+	"github.com/mozilla-services/heka/compression"
 	. "github.com/mozilla-services/heka/pipeline"
 	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/mozilla-services/heka/pipelinemock"
@@ -87,7 +97,12 @@ func HttpInputSpec(c gs.Context) {
 			tickChan <- time.Now()
 
 			// Getting the decorator means we've made our HTTP request.
-			<-decChan
+			dec := <-decChan
+			dec(ith.Pack)
+
+			url, ok := ith.Pack.Message.GetFieldValue("url")
+			c.Assume(ok, gs.IsTrue)
+			c.Expect(url, gs.Equals, "http://localhost:9876/")
 		})
 
 		c.Specify("supports configuring HTTP Basic Authentication", func() {
@@ -202,3 +217,360 @@ func HttpInputSpec(c gs.Context) {
 		c.Expect(runOutput, gs.IsNil)
 	})
 }
+
+func HttpInputMultipleUrlsSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A HttpInput with several URLs", func() {
+		httpInput := HttpInput{}
+		config := httpInput.ConfigStruct().(*HttpInputConfig)
+
+		c.Specify("accepts a single url string", func() {
+			config.Url = "http://a.example.com/"
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(httpInput.urls), gs.Equals, 1)
+			c.Expect(httpInput.urls[0], gs.Equals, "http://a.example.com/")
+		})
+
+		c.Specify("accepts a url list", func() {
+			config.Url = []interface{}{"http://a.example.com/", "http://b.example.com/"}
+			config.Urls = []string{"http://c.example.com/"}
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(httpInput.urls), gs.Equals, 3)
+			c.Expect(httpInput.urls[1], gs.Equals, "http://b.example.com/")
+			c.Expect(httpInput.urls[2], gs.Equals, "http://c.example.com/")
+		})
+
+		c.Specify("rejects invalid urls", func() {
+			err := httpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+			config.Url = []interface{}{"http://a.example.com/", 5}
+			err = httpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+			config.Url = 5
+			err = httpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("polls them all even when one fails", func() {
+			ir := pipelinemock.NewMockInputRunner(ctrl)
+			sRunner0 := pipelinemock.NewMockSplitterRunner(ctrl)
+			sRunner1 := pipelinemock.NewMockSplitterRunner(ctrl)
+			httpInput.ir = ir
+
+			server := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprint(w, r.URL.Path)
+				}))
+			defer server.Close()
+			down := httptest.NewServer(http.NotFoundHandler())
+			down.Close()
+
+			config.Url = []interface{}{down.URL + "/down", server.URL + "/up"}
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			httpInput.sRunners = []SplitterRunner{sRunner0, sRunner1}
+
+			pConfig := NewPipelineConfig(nil)
+			inChan := make(chan *PipelinePack, 1)
+			inChan <- NewPipelinePack(pConfig.InputRecycleChan())
+			ir.EXPECT().InChan().Return(inChan)
+			var errPack *PipelinePack
+			ir.EXPECT().Deliver(gomock.Any()).Do(func(pack *PipelinePack) {
+				errPack = pack
+			})
+
+			var body string
+			sRunner1.EXPECT().UseMsgBytes().Return(true)
+			splitCall := sRunner1.EXPECT().SplitStreamNullSplitterToEOF(gomock.Any(), nil)
+			splitCall.Do(func(r io.Reader, d Deliverer) {
+				data, _ := ioutil.ReadAll(r)
+				body = string(data)
+			}).Return(io.EOF)
+
+			httpInput.pollUrls()
+			c.Expect(body, gs.Equals, "/up")
+			c.Assume(errPack, gs.Not(gs.IsNil))
+			c.Expect(errPack.Message.GetType(), gs.Equals, "heka.httpinput.error")
+			url, _ := errPack.Message.GetFieldValue("url")
+			c.Expect(url, gs.Equals, down.URL+"/down")
+		})
+	})
+}
+
+func HttpInputPagingSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("Finding the next page URL", func() {
+		body := []byte(`{"data": [1, 2], "links": {"next": "/items?page=2"},
+			"pages": [{"next": "http://other.example.com/3"}], "done": null}`)
+
+		c.Specify("follows the dotted path", func() {
+			next, err := nextPageUrl(body, "links.next", "http://api.example.com/items")
+			c.Expect(err, gs.IsNil)
+			c.Expect(next, gs.Equals, "http://api.example.com/items?page=2")
+
+			next, err = nextPageUrl(body, "$.pages.0.next", "http://api.example.com/items")
+			c.Expect(err, gs.IsNil)
+			c.Expect(next, gs.Equals, "http://other.example.com/3")
+		})
+
+		c.Specify("returns nothing when there's no next page", func() {
+			for _, path := range []string{"done", "missing", "links.missing",
+				"pages.1.next", "data.x"} {
+
+				next, err := nextPageUrl(body, path, "http://api.example.com/")
+				c.Expect(err, gs.IsNil)
+				c.Expect(next, gs.Equals, "")
+			}
+		})
+
+		c.Specify("fails on non string values and invalid JSON", func() {
+			_, err := nextPageUrl(body, "data", "http://api.example.com/")
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = nextPageUrl([]byte("not json"), "next", "http://api.example.com/")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A paging HttpInput", func() {
+		httpInput := HttpInput{}
+		ir := pipelinemock.NewMockInputRunner(ctrl)
+		sRunner := pipelinemock.NewMockSplitterRunner(ctrl)
+		httpInput.ir = ir
+
+		var requested []string
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				requested = append(requested, r.URL.RequestURI())
+				page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				if page < 3 {
+					fmt.Fprintf(w, `{"next": "/?page=%d"}`, page+1)
+				} else {
+					fmt.Fprint(w, `{"next": null}`)
+				}
+			}))
+		defer server.Close()
+
+		config := httpInput.ConfigStruct().(*HttpInputConfig)
+		config.Url = server.URL + "/?page=0"
+		config.NextUrlPath = "next"
+
+		var bodies []string
+		sRunner.EXPECT().UseMsgBytes().Return(true).AnyTimes()
+		splitCall := sRunner.EXPECT().SplitStreamNullSplitterToEOF(gomock.Any(), nil)
+		splitCall.Do(func(r io.Reader, d Deliverer) {
+			data, _ := ioutil.ReadAll(r)
+			bodies = append(bodies, string(data))
+		}).Return(io.EOF).AnyTimes()
+
+		c.Specify("fetches pages until there's no next URL", func() {
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			httpInput.fetchPages(httpInput.urls[0], sRunner)
+			c.Expect(len(requested), gs.Equals, 4)
+			c.Expect(requested[3], gs.Equals, "/?page=3")
+			c.Expect(len(bodies), gs.Equals, 4)
+			c.Expect(bodies[0], gs.Equals, `{"next": "/?page=1"}`)
+		})
+
+		c.Specify("stops at max_pages", func() {
+			config.MaxPages = 2
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			ir.EXPECT().LogMessage(gomock.Any())
+			httpInput.fetchPages(httpInput.urls[0], sRunner)
+			c.Expect(len(requested), gs.Equals, 2)
+		})
+
+		c.Specify("only fetches one page without next_url_path", func() {
+			config.NextUrlPath = ""
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			httpInput.fetchPages(httpInput.urls[0], sRunner)
+			c.Expect(len(requested), gs.Equals, 1)
+		})
+	})
+}
+
+func HttpInputDecompressionSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	compress := func(encoding, data string) []byte {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "zlib":
+			w = zlib.NewWriter(&buf)
+		case "deflate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		case "zstd":
+			w, _ = compression.NewWriter(&buf, compression.Zstd, 0)
+		}
+		w.Write([]byte(data))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	c.Specify("Decompressing a response body", func() {
+		read := func(body []byte, encoding string) string {
+			r, err := decompressBody(bytes.NewReader(body), encoding)
+			c.Assume(err, gs.IsNil)
+			data, err := ioutil.ReadAll(r)
+			c.Assume(err, gs.IsNil)
+			return string(data)
+		}
+
+		c.Specify("handles gzip", func() {
+			c.Expect(read(compress("gzip", "gzipped"), "gzip"), gs.Equals, "gzipped")
+			c.Expect(read(compress("gzip", "gzipped"), " X-Gzip"), gs.Equals, "gzipped")
+		})
+
+		c.Specify("handles zstd", func() {
+			c.Expect(read(compress("zstd", "zstd data"), "zstd"), gs.Equals, "zstd data")
+		})
+
+		c.Specify("handles zlib wrapped and raw deflate", func() {
+			c.Expect(read(compress("zlib", "zlib data"), "deflate"), gs.Equals,
+				"zlib data")
+			c.Expect(read(compress("deflate", "raw data"), "deflate"), gs.Equals,
+				"raw data")
+		})
+
+		c.Specify("leaves other bodies untouched", func() {
+			c.Expect(read([]byte("plain"), ""), gs.Equals, "plain")
+			c.Expect(read([]byte("plain"), "identity"), gs.Equals, "plain")
+			c.Expect(read([]byte("plain"), "br"), gs.Equals, "plain")
+		})
+
+		c.Specify("fails on an invalid gzip body", func() {
+			_, err := decompressBody(bytes.NewReader([]byte("plain")), "gzip")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A HttpInput fetching compressed responses", func() {
+		httpInput := HttpInput{}
+		ir := pipelinemock.NewMockInputRunner(ctrl)
+		sRunner := pipelinemock.NewMockSplitterRunner(ctrl)
+		httpInput.ir = ir
+
+		setHeader := true
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if setHeader {
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+				w.Write(compress("gzip", "line one\nline two\n"))
+			}))
+		defer server.Close()
+
+		config := httpInput.ConfigStruct().(*HttpInputConfig)
+		config.Url = server.URL
+		// Keeps the Go HTTP client from decompressing the response itself.
+		config.Headers = map[string]string{"Accept-Encoding": "gzip"}
+
+		var bodies []string
+		sRunner.EXPECT().UseMsgBytes().Return(true).AnyTimes()
+		splitCall := sRunner.EXPECT().SplitStreamNullSplitterToEOF(gomock.Any(), nil)
+		splitCall.Do(func(r io.Reader, d Deliverer) {
+			data, _ := ioutil.ReadAll(r)
+			bodies = append(bodies, string(data))
+		}).Return(io.EOF).AnyTimes()
+
+		c.Specify("decompresses based on the Content-Encoding header", func() {
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			httpInput.fetchUrl(httpInput.urls[0], sRunner)
+			c.Assume(len(bodies), gs.Equals, 1)
+			c.Expect(bodies[0], gs.Equals, "line one\nline two\n")
+		})
+
+		c.Specify("decompresses when forced to", func() {
+			setHeader = false
+			config.Decompress = "gzip"
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			httpInput.fetchUrl(httpInput.urls[0], sRunner)
+			c.Assume(len(bodies), gs.Equals, 1)
+			c.Expect(bodies[0], gs.Equals, "line one\nline two\n")
+		})
+
+		c.Specify("rejects unknown schemes", func() {
+			config.Decompress = "bzip2"
+			err := httpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A paging HttpInput fetching compressed responses", func() {
+		httpInput := HttpInput{}
+		ir := pipelinemock.NewMockInputRunner(ctrl)
+		sRunner := pipelinemock.NewMockSplitterRunner(ctrl)
+		httpInput.ir = ir
+
+		var requested []string
+		truncate := false
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				requested = append(requested, r.URL.RequestURI())
+				w.Header().Set("Content-Encoding", "gzip")
+				var body []byte
+				if r.URL.Query().Get("page") == "" {
+					body = compress("gzip", `{"next": "/?page=1"}`)
+				} else {
+					body = compress("gzip", `{"next": null}`)
+				}
+				if truncate {
+					// Fails to decompress part way through.
+					body = body[:len(body)-4]
+				}
+				w.Write(body)
+			}))
+		defer server.Close()
+
+		config := httpInput.ConfigStruct().(*HttpInputConfig)
+		config.Url = server.URL + "/"
+		config.NextUrlPath = "next"
+		config.Headers = map[string]string{"Accept-Encoding": "gzip"}
+
+		var bodies []string
+		sRunner.EXPECT().UseMsgBytes().Return(true).AnyTimes()
+		splitCall := sRunner.EXPECT().SplitStreamNullSplitterToEOF(gomock.Any(), nil)
+		splitCall.Do(func(r io.Reader, d Deliverer) {
+			data, _ := ioutil.ReadAll(r)
+			bodies = append(bodies, string(data))
+		}).Return(io.EOF).AnyTimes()
+
+		c.Specify("finds the next page in the decompressed body", func() {
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			httpInput.fetchPages(httpInput.urls[0], sRunner)
+			c.Expect(len(requested), gs.Equals, 2)
+			c.Expect(requested[1], gs.Equals, "/?page=1")
+			c.Assume(len(bodies), gs.Equals, 2)
+			c.Expect(bodies[0], gs.Equals, `{"next": "/?page=1"}`)
+			c.Expect(bodies[1], gs.Equals, `{"next": null}`)
+		})
+
+		c.Specify("doesn't deliver a body it can't read", func() {
+			truncate = true
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			ir.EXPECT().LogError(gomock.Any())
+			httpInput.fetchPages(httpInput.urls[0], sRunner)
+			c.Expect(len(requested), gs.Equals, 1)
+			c.Expect(len(bodies), gs.Equals, 0)
+		})
+	})
+}
@@ -0,0 +1,104 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// HyperLogLog sketch for estimating the number of distinct values added to it
+// using a fixed amount of memory, 2^precision bytes. The standard error of the
+// estimate is about 1.04/sqrt(2^precision), e.g. 0.8% with a precision of 14.
+type hyperLogLog struct {
+	precision uint
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint) *hyperLogLog {
+	return &hyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+// Returns a 64 bit hash of the value. FNV-1a on its own doesn't mix the high
+// bits well enough for short inputs, so it's followed by the splitmix64
+// finalizer.
+func hashValue(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (hll *hyperLogLog) add(value string) {
+	x := hashValue(value)
+	idx := x >> (64 - hll.precision)
+	// Position of the leftmost 1 bit in the remaining bits, the sentinel bit
+	// caps it when they're all zero.
+	w := x<<hll.precision | 1<<(hll.precision-1)
+	rank := uint8(1)
+	for w&(1<<63) == 0 {
+		rank++
+		w <<= 1
+	}
+	if rank > hll.registers[idx] {
+		hll.registers[idx] = rank
+	}
+}
+
+// Returns the estimated number of distinct values added since the last reset.
+func (hll *hyperLogLog) estimate() uint64 {
+	m := float64(len(hll.registers))
+	var (
+		sum   float64
+		zeros int
+	)
+	for _, r := range hll.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(hll.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities.
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+func (hll *hyperLogLog) reset() {
+	for i := range hll.registers {
+		hll.registers[i] = 0
+	}
+}
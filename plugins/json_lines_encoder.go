@@ -0,0 +1,155 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+// JsonLinesEncoder serializes each message as a single line of JSON, with the
+// message's dynamic fields hoisted to top level keys alongside the message
+// headers, for log stores that ingest newline delimited JSON.
+type JsonLinesEncoder struct {
+	config *JsonLinesEncoderConfig
+}
+
+type JsonLinesEncoderConfig struct {
+	// Prefix added to the key of each dynamic field, e.g. "Fields." to keep
+	// them apart from the message headers. Defaults to "".
+	FieldPrefix string `toml:"field_prefix"`
+}
+
+// Keys of the message headers, which take precedence over fields with the
+// same key.
+var jsonLinesHeaderKeys = map[string]bool{
+	"Uuid":      true,
+	"Timestamp": true,
+	"Type":      true,
+	"Logger":    true,
+	"Severity":  true,
+	"Payload":   true,
+	"Hostname":  true,
+}
+
+func (je *JsonLinesEncoder) ConfigStruct() interface{} {
+	return &JsonLinesEncoderConfig{}
+}
+
+func (je *JsonLinesEncoder) Init(config interface{}) (err error) {
+	je.config = config.(*JsonLinesEncoderConfig)
+	return
+}
+
+// Writes a key and its JSON encoded value to the object being built in buf.
+func writeJsonKey(buf *bytes.Buffer, key string, value interface{}) error {
+	keyData, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	valueData, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if buf.Len() > 1 {
+		buf.WriteByte(',')
+	}
+	buf.Write(keyData)
+	buf.WriteByte(':')
+	buf.Write(valueData)
+	return nil
+}
+
+// Returns the field's value, or a slice of its values if it has more than
+// one. Byte values are base64 encoded by the JSON encoder.
+func jsonLinesFieldValue(field *message.Field) interface{} {
+	var values []interface{}
+	switch field.GetValueType() {
+	case message.Field_STRING:
+		for _, v := range field.GetValueString() {
+			values = append(values, v)
+		}
+	case message.Field_BYTES:
+		for _, v := range field.GetValueBytes() {
+			values = append(values, v)
+		}
+	case message.Field_INTEGER:
+		for _, v := range field.GetValueInteger() {
+			values = append(values, v)
+		}
+	case message.Field_DOUBLE:
+		for _, v := range field.GetValueDouble() {
+			values = append(values, v)
+		}
+	case message.Field_BOOL:
+		for _, v := range field.GetValueBool() {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+func (je *JsonLinesEncoder) Encode(pack *pipeline.PipelinePack) (output []byte, err error) {
+	msg := pack.Message
+	buf := new(bytes.Buffer)
+	buf.WriteByte('{')
+	timestamp := time.Unix(0, msg.GetTimestamp()).UTC().Format(time.RFC3339Nano)
+	headers := []struct {
+		key   string
+		value interface{}
+	}{
+		{"Uuid", msg.GetUuidString()},
+		{"Timestamp", timestamp},
+		{"Type", msg.GetType()},
+		{"Logger", msg.GetLogger()},
+		{"Severity", msg.GetSeverity()},
+		{"Payload", msg.GetPayload()},
+		{"Hostname", msg.GetHostname()},
+	}
+	for _, header := range headers {
+		if err = writeJsonKey(buf, header.key, header.value); err != nil {
+			return
+		}
+	}
+
+	// Duplicate keys aren't valid JSON, so the first field with a given name
+	// wins and fields clashing with the headers are skipped.
+	seen := make(map[string]bool)
+	for _, field := range msg.GetFields() {
+		key := je.config.FieldPrefix + field.GetName()
+		if jsonLinesHeaderKeys[key] || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if err = writeJsonKey(buf, key, jsonLinesFieldValue(field)); err != nil {
+			return
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+func init() {
+	pipeline.RegisterPlugin("JsonLinesEncoder", func() interface{} {
+		return new(JsonLinesEncoder)
+	})
+}
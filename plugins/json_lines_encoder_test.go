@@ -0,0 +1,99 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+	"github.com/pborman/uuid"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func JsonLinesEncoderSpec(c gs.Context) {
+
+	c.Specify("A JsonLinesEncoder", func() {
+		encoder := new(JsonLinesEncoder)
+		config := encoder.ConfigStruct().(*JsonLinesEncoderConfig)
+		supply := make(chan *pipeline.PipelinePack, 1)
+
+		pack := pipeline.NewPipelinePack(supply)
+		pack.Message.SetPayload("multi\nline \"payload\"")
+		timestamp := time.Date(2016, 3, 1, 12, 30, 0, 500, time.UTC)
+		pack.Message.SetTimestamp(timestamp.UnixNano())
+		pack.Message.SetType("test.type")
+		pack.Message.SetHostname("somehost.example.com")
+		pack.Message.SetUuid(uuid.Parse("72de6a05-1b99-4a88-84c2-90797624c68f"))
+		pack.Message.SetLogger("loggyloglog")
+		pack.Message.SetSeverity(4)
+
+		message.NewStringField(pack.Message, "user", "bob")
+		field, err := message.NewField("codes", 200, "")
+		c.Assume(err, gs.IsNil)
+		field.AddValue(404)
+		pack.Message.AddField(field)
+		message.NewInt64Field(pack.Message, "Type", 7, "")
+		message.NewStringField(pack.Message, "user", "alice")
+
+		decode := func(output []byte) map[string]interface{} {
+			c.Expect(bytes.Count(output, []byte("\n")), gs.Equals, 1)
+			c.Expect(output[len(output)-1], gs.Equals, byte('\n'))
+			var decoded map[string]interface{}
+			err := json.Unmarshal(output, &decoded)
+			c.Assume(err, gs.IsNil)
+			return decoded
+		}
+
+		c.Specify("hoists the fields to top level keys", func() {
+			err := encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			decoded := decode(output)
+
+			c.Expect(decoded["Uuid"], gs.Equals, "72de6a05-1b99-4a88-84c2-90797624c68f")
+			c.Expect(decoded["Timestamp"], gs.Equals, "2016-03-01T12:30:00.0000005Z")
+			c.Expect(decoded["Type"], gs.Equals, "test.type")
+			c.Expect(decoded["Logger"], gs.Equals, "loggyloglog")
+			c.Expect(decoded["Severity"], gs.Equals, float64(4))
+			c.Expect(decoded["Payload"], gs.Equals, "multi\nline \"payload\"")
+			c.Expect(decoded["Hostname"], gs.Equals, "somehost.example.com")
+			c.Expect(decoded["user"], gs.Equals, "bob")
+			codes, ok := decoded["codes"].([]interface{})
+			c.Assume(ok, gs.IsTrue)
+			c.Expect(len(codes), gs.Equals, 2)
+			c.Expect(codes[1], gs.Equals, float64(404))
+			c.Expect(len(decoded), gs.Equals, 9)
+		})
+
+		c.Specify("prefixes the field keys", func() {
+			config.FieldPrefix = "Fields."
+			err := encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			decoded := decode(output)
+
+			c.Expect(decoded["Type"], gs.Equals, "test.type")
+			c.Expect(decoded["Fields.Type"], gs.Equals, float64(7))
+			c.Expect(decoded["Fields.user"], gs.Equals, "bob")
+			_, ok := decoded["user"]
+			c.Expect(ok, gs.IsFalse)
+		})
+	})
+}
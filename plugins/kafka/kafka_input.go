@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
 	"github.com/mozilla-services/heka/message"
 	"github.com/mozilla-services/heka/pipeline"
 	"github.com/mozilla-services/heka/plugins/tcp"
@@ -59,8 +60,11 @@ type KafkaInputConfig struct {
 	MinFetchSize     int32  `toml:"min_fetch_size"`
 	MaxMessageSize   int32  `toml:"max_message_size"`
 	MaxWaitTime      uint32 `toml:"max_wait_time"`
-	OffsetMethod     string `toml:"offset_method"` // Manual, Newest, Oldest
+	OffsetMethod     string `toml:"offset_method"` // Manual, Newest, Oldest, Broker
 	EventBufferSize  int    `toml:"event_buffer_size"`
+	// How often offsets are committed to the broker (in milliseconds), only
+	// used with the Broker offset method.
+	OffsetCommitInterval uint32 `toml:"offset_commit_interval"`
 }
 
 type KafkaInput struct {
@@ -71,6 +75,7 @@ type KafkaInput struct {
 	saramaConfig       *sarama.Config
 	consumer           sarama.Consumer
 	partitionConsumer  sarama.PartitionConsumer
+	groupConsumer      *cluster.Consumer
 	pConfig            *pipeline.PipelineConfig
 	ir                 pipeline.InputRunner
 	checkpointFile     *os.File
@@ -96,6 +101,7 @@ func (k *KafkaInput) ConfigStruct() interface{} {
 		MaxWaitTime:                250,
 		OffsetMethod:               "Manual",
 		EventBufferSize:            16,
+		OffsetCommitInterval:       1000,
 	}
 }
 
@@ -173,6 +179,8 @@ func (k *KafkaInput) Init(config interface{}) (err error) {
 
 	var offset int64
 	switch k.config.OffsetMethod {
+	case "Broker":
+		return k.initGroupConsumer()
 	case "Manual":
 		if fileExists(k.checkpointFilename) {
 			if offset, err = readCheckpoint(k.checkpointFilename); err != nil {
@@ -212,6 +220,26 @@ func (k *KafkaInput) Init(config interface{}) (err error) {
 	return err
 }
 
+// initGroupConsumer joins the consumer group, which assigns the topic's
+// partitions across its members, and resumes from the offsets the group has
+// committed to the broker.
+func (k *KafkaInput) initGroupConsumer() (err error) {
+	if k.config.OffsetCommitInterval == 0 {
+		return errors.New("offset_commit_interval must be greater than 0")
+	}
+	clusterConfig := cluster.NewConfig()
+	clusterConfig.Config = *k.saramaConfig
+	clusterConfig.ChannelBufferSize = k.config.EventBufferSize
+	clusterConfig.Consumer.Return.Errors = true
+	clusterConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	clusterConfig.Consumer.Offsets.CommitInterval = time.Duration(
+		k.config.OffsetCommitInterval) * time.Millisecond
+
+	k.groupConsumer, err = cluster.NewConsumer(k.config.Addrs, k.config.Group,
+		[]string{k.config.Topic}, clusterConfig)
+	return err
+}
+
 func (k *KafkaInput) addField(pack *pipeline.PipelinePack, name string,
 	value interface{}, representation string) {
 
@@ -226,8 +254,15 @@ func (k *KafkaInput) Run(ir pipeline.InputRunner, h pipeline.PluginHelper) (err
 	sRunner := ir.NewSplitterRunner("")
 
 	defer func() {
-		k.partitionConsumer.Close()
-		k.consumer.Close()
+		if k.groupConsumer != nil {
+			// Commits the offsets of the messages we've processed.
+			if err := k.groupConsumer.Close(); err != nil {
+				ir.LogError(fmt.Errorf("closing group consumer: %s", err))
+			}
+		} else {
+			k.partitionConsumer.Close()
+			k.consumer.Close()
+		}
 		if k.checkpointFile != nil {
 			k.checkpointFile.Close()
 		}
@@ -257,8 +292,19 @@ func (k *KafkaInput) Run(ir pipeline.InputRunner, h pipeline.PluginHelper) (err
 		sRunner.SetPackDecorator(packDec)
 	}
 
-	eventChan := k.partitionConsumer.Messages()
-	cErrChan := k.partitionConsumer.Errors()
+	var (
+		eventChan <-chan *sarama.ConsumerMessage
+		cErrChan  <-chan *sarama.ConsumerError
+		gErrChan  <-chan error
+		gErr      error
+	)
+	if k.groupConsumer != nil {
+		eventChan = k.groupConsumer.Messages()
+		gErrChan = k.groupConsumer.Errors()
+	} else {
+		eventChan = k.partitionConsumer.Messages()
+		cErrChan = k.partitionConsumer.Errors()
+	}
 	for {
 		select {
 		case event, ok = <-eventChan:
@@ -275,10 +321,13 @@ func (k *KafkaInput) Run(ir pipeline.InputRunner, h pipeline.PluginHelper) (err
 					event.Topic))
 			}
 
-			if k.config.OffsetMethod == "Manual" {
+			switch k.config.OffsetMethod {
+			case "Manual":
 				if err = k.writeCheckpoint(event.Offset + 1); err != nil {
 					return err
 				}
+			case "Broker":
+				k.groupConsumer.MarkOffset(event, "")
 			}
 
 		case cError, ok = <-cErrChan:
@@ -302,6 +351,15 @@ func (k *KafkaInput) Run(ir pipeline.InputRunner, h pipeline.PluginHelper) (err
 			atomic.AddInt64(&k.processMessageFailures, 1)
 			ir.LogError(cError.Err)
 
+		case gErr, ok = <-gErrChan:
+			if !ok {
+				// Don't exit until the eventChan is closed.
+				gErrChan = nil
+				continue
+			}
+			atomic.AddInt64(&k.processMessageFailures, 1)
+			ir.LogError(gErr)
+
 		case <-k.stopChan:
 			return nil
 		}
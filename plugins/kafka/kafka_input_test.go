@@ -58,6 +58,24 @@ func TestInvalidOffsetMethod(t *testing.T) {
 	}
 }
 
+func TestBrokerOffsetMethodCommitInterval(t *testing.T) {
+	pConfig := NewPipelineConfig(nil)
+	ki := new(KafkaInput)
+	ki.SetName("test")
+	ki.SetPipelineConfig(pConfig)
+
+	config := ki.ConfigStruct().(*KafkaInputConfig)
+	config.Addrs = append(config.Addrs, "localhost:5432")
+	config.OffsetMethod = "Broker"
+	config.OffsetCommitInterval = 0
+	err := ki.Init(config)
+
+	errmsg := "offset_commit_interval must be greater than 0"
+	if err.Error() != errmsg {
+		t.Errorf("Expected: %s, received: %s", errmsg, err)
+	}
+}
+
 func TestReceivePayloadMessage(t *testing.T) {
 	broker := sarama.NewMockBroker(t, 1)
 	ctrl := gomock.NewController(t)
@@ -43,6 +43,11 @@ type KafkaOutputConfig struct {
 	UseTls bool `toml:"use_tls"`
 	Tls    tcp.TlsConfig
 
+	// SASL Config
+	SaslUsername  string `toml:"sasl_username"`
+	SaslPassword  string `toml:"sasl_password"`
+	SaslMechanism string `toml:"sasl_mechanism"` // PLAIN
+
 	// Broker Config
 	MaxOpenRequests int    `toml:"max_open_reqests"`
 	DialTimeout     uint32 `toml:"dial_timeout"`
@@ -108,6 +113,7 @@ func (k *KafkaOutput) ConfigStruct() interface{} {
 		CompressionCodec:           "None",
 		MaxBufferTime:              1,
 		MaxBufferedBytes:           1,
+		SaslMechanism:              "PLAIN",
 	}
 }
 
@@ -228,6 +234,18 @@ func (k *KafkaOutput) Init(config interface{}) (err error) {
 		}
 	}
 
+	if len(k.config.SaslUsername) > 0 {
+		if k.config.SaslMechanism != "PLAIN" {
+			return fmt.Errorf("invalid sasl_mechanism: %s", k.config.SaslMechanism)
+		}
+		k.saramaConfig.Net.SASL.Enable = true
+		k.saramaConfig.Net.SASL.Handshake = true
+		k.saramaConfig.Net.SASL.User = k.config.SaslUsername
+		k.saramaConfig.Net.SASL.Password = k.config.SaslPassword
+	} else if len(k.config.SaslPassword) > 0 {
+		return errors.New("sasl_password requires sasl_username to be set")
+	}
+
 	k.saramaConfig.Net.MaxOpenRequests = k.config.MaxOpenRequests
 	k.saramaConfig.Net.DialTimeout = time.Duration(k.config.DialTimeout) * time.Millisecond
 	k.saramaConfig.Net.ReadTimeout = time.Duration(k.config.ReadTimeout) * time.Millisecond
@@ -291,6 +309,12 @@ func (k *KafkaOutput) Init(config interface{}) (err error) {
 
 	k.client, err = sarama.NewClient(k.config.Addrs, k.saramaConfig)
 	if err != nil {
+		if k.saramaConfig.Net.SASL.Enable {
+			// Failed authentication closes the broker connection, which the
+			// client only reports as running out of brokers.
+			return fmt.Errorf("can't connect to brokers, check the SASL credentials: %s",
+				err)
+		}
 		return err
 	}
 	k.producer, err = sarama.NewAsyncProducer(k.config.Addrs, k.saramaConfig)
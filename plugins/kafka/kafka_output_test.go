@@ -267,6 +267,40 @@ func TestInvalidCompressionCodec(t *testing.T) {
 	}
 }
 
+func TestInvalidSaslMechanism(t *testing.T) {
+	pConfig := NewPipelineConfig(nil)
+	ko := new(KafkaOutput)
+	ko.SetPipelineConfig(pConfig)
+	config := ko.ConfigStruct().(*KafkaOutputConfig)
+	config.Addrs = append(config.Addrs, "localhost:5432")
+	config.Topic = "test"
+	config.SaslUsername = "heka"
+	config.SaslPassword = "secret"
+	config.SaslMechanism = "GSSAPI"
+	err := ko.Init(config)
+
+	errmsg := "invalid sasl_mechanism: GSSAPI"
+	if err.Error() != errmsg {
+		t.Errorf("Expected: %s, received: %s", errmsg, err)
+	}
+}
+
+func TestSaslPasswordWithoutUsername(t *testing.T) {
+	pConfig := NewPipelineConfig(nil)
+	ko := new(KafkaOutput)
+	ko.SetPipelineConfig(pConfig)
+	config := ko.ConfigStruct().(*KafkaOutputConfig)
+	config.Addrs = append(config.Addrs, "localhost:5432")
+	config.Topic = "test"
+	config.SaslPassword = "secret"
+	err := ko.Init(config)
+
+	errmsg := "sasl_password requires sasl_username to be set"
+	if err.Error() != errmsg {
+		t.Errorf("Expected: %s, received: %s", errmsg, err)
+	}
+}
+
 func TestSendMessage(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	broker := sarama.NewMockBroker(t, 2)
@@ -44,6 +44,8 @@ type LogstreamerInputConfig struct {
 	Differentiator []string
 	// Oldest logfiles to parse, as a duration parseable
 	OldestDuration string `toml:"oldest_duration"`
+	// Logfiles older than this duration are skipped entirely while scanning
+	IgnoreOlderThan string `toml:"ignore_older_than"`
 	// Translation map for sorting substitutions
 	Translation ls.SubmatchTranslationMap
 	// Rescan interval declares how often the full directory scanner
@@ -56,6 +58,9 @@ type LogstreamerInputConfig struct {
 	Splitter string
 	// Whether to ignore previous logfiles while initial scan
 	InitialTail bool `toml:"initial_tail"`
+	// Timestamp or logstream byte offset to start reading from instead of
+	// the journal position, applied once
+	ReplayFrom string `toml:"replay_from"`
 }
 
 type LogstreamerInput struct {
@@ -162,6 +167,20 @@ func (li *LogstreamerInput) Init(config interface{}) (err error) {
 	if err != nil {
 		return
 	}
+	if conf.IgnoreOlderThan != "" {
+		var ignoreOlderThan time.Duration
+		if ignoreOlderThan, err = time.ParseDuration(conf.IgnoreOlderThan); err != nil {
+			return fmt.Errorf("invalid `ignore_older_than`: %s", err)
+		}
+		li.logstreamSet.SetIgnoreOlderThan(ignoreOlderThan)
+	}
+	if conf.ReplayFrom != "" {
+		var rp *ls.ReplayPoint
+		if rp, err = ls.ParseReplayPoint(conf.ReplayFrom); err != nil {
+			return fmt.Errorf("invalid `replay_from`: %s", err)
+		}
+		li.logstreamSet.SetReplayPoint(rp)
+	}
 	// Initial scan for logstreams
 	plugins, errs = li.logstreamSet.ScanForLogstreams()
 	if errs.IsError() {
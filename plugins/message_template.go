@@ -0,0 +1,114 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cactus/gostrftime"
+	"github.com/mozilla-services/heka/message"
+)
+
+// A single piece of a MessageTemplate, either literal text or a reference.
+type templatePart struct {
+	text string
+	ref  bool
+}
+
+// Text containing `%{...}` references that are replaced with values from a
+// message, e.g. "logs/%{Hostname}/%{%Y/%m/%d}.log". A reference is a message
+// header, a dynamic field by name or as `Fields[name]`, or a strftime pattern
+// starting with `%`, which is applied to the message's timestamp in UTC.
+type MessageTemplate struct {
+	parts []templatePart
+	// Used for values the message doesn't have, or that are empty.
+	missing string
+	// Applied to each value taken from the message, if set.
+	escape func(string) string
+}
+
+// Parses the template, which must contain at least one reference.
+func NewMessageTemplate(spec, missing string, escape func(string) string) (
+	*MessageTemplate, error) {
+
+	t := &MessageTemplate{missing: missing, escape: escape}
+	hasRef := false
+	rest := spec
+	for {
+		start := strings.Index(rest, "%{")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated reference: %s", spec)
+		}
+		end += start
+		ref := rest[start+2 : end]
+		if ref == "" {
+			return nil, fmt.Errorf("empty reference: %s", spec)
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{text: rest[:start]})
+		}
+		t.parts = append(t.parts, templatePart{text: ref, ref: true})
+		hasRef = true
+		rest = rest[end+1:]
+	}
+	if rest != "" {
+		t.parts = append(t.parts, templatePart{text: rest})
+	}
+	if !hasRef {
+		return nil, errors.New("must contain at least one `%{...}` reference")
+	}
+	return t, nil
+}
+
+// Returns the template's text with every reference replaced by its value for
+// the message. Messages without a timestamp use the current time.
+func (t *MessageTemplate) Render(msg *message.Message) string {
+	ts := time.Now()
+	if nanos := msg.GetTimestamp(); nanos != 0 {
+		ts = time.Unix(0, nanos)
+	}
+	ts = ts.UTC()
+	var b []byte
+	for _, part := range t.parts {
+		if !part.ref {
+			b = append(b, part.text...)
+			continue
+		}
+		if strings.HasPrefix(part.text, "%") {
+			b = append(b, gostrftime.Strftime(part.text, ts)...)
+			continue
+		}
+		name := part.text
+		if strings.HasPrefix(name, "Fields[") && strings.HasSuffix(name, "]") {
+			name = name[len("Fields[") : len(name)-1]
+		}
+		value, _ := messageFieldValue(msg, name)
+		if t.escape != nil {
+			value = t.escape(value)
+		}
+		if value == "" {
+			value = t.missing
+		}
+		b = append(b, value...)
+	}
+	return string(b)
+}
@@ -0,0 +1,62 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func MessageTemplateSpec(c gs.Context) {
+	c.Specify("A message template", func() {
+		msg := pipeline_ts.GetTestMessage()
+		msg.SetHostname("web1")
+		msg.SetTimestamp(time.Date(2016, 3, 12, 14, 5, 9, 0, time.UTC).UnixNano())
+		message.NewStringField(msg, "tenant", "acme")
+
+		c.Specify("resolves headers, fields, and the timestamp", func() {
+			tmpl, err := NewMessageTemplate(
+				"logs/%{%Y/%m/%d}/%{Hostname}/%{Fields[tenant]}-%{%H%M%S}.pb", "unknown", nil)
+			c.Assume(err, gs.IsNil)
+			c.Expect(tmpl.Render(msg), gs.Equals, "logs/2016/03/12/web1/acme-140509.pb")
+		})
+
+		c.Specify("replaces missing fields", func() {
+			tmpl, err := NewMessageTemplate("%{region}/%{UUID}", "unknown", nil)
+			c.Assume(err, gs.IsNil)
+			c.Expect(tmpl.Render(msg), gs.Equals, "unknown/"+msg.GetUuidString())
+		})
+
+		c.Specify("escapes the message's values", func() {
+			tmpl, err := NewMessageTemplate("%{%Y}-%{Hostname}-%{region}", "none",
+				strings.ToUpper)
+			c.Assume(err, gs.IsNil)
+			c.Expect(tmpl.Render(msg), gs.Equals, "2016-WEB1-none")
+		})
+
+		c.Specify("rejects invalid templates", func() {
+			_, err := NewMessageTemplate("logs/%{Hostname", "unknown", nil)
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = NewMessageTemplate("logs/%{}", "unknown", nil)
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = NewMessageTemplate("logs/current", "unknown", nil)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
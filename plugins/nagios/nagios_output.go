@@ -21,6 +21,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,11 +32,18 @@ import (
 )
 
 // NagiosOutput can be configured to use the http client to submit the passive
-// checks directly to the nagios cgi, or pipe them to the send_nsca program.
-// To use send_nsca, one needs to provide the send_nsca_bin, and optionally
-// send_nsca_args config entries. To use http, one needs to provide Url, and
-// optionally username, password, and response_header_timeout.
+// checks directly to the nagios cgi, pipe them to the send_nsca program, or
+// speak the NSCA protocol to an nsca daemon itself. To use send_nsca, one needs
+// to provide the send_nsca_bin, and optionally send_nsca_args config entries.
+// To use http, one needs to provide Url, and optionally username, password,
+// and response_header_timeout. To use nsca, one needs to set transport to
+// "nsca" and provide nsca_address, and optionally nsca_encryption and
+// nsca_password.
 type NagiosOutputConfig struct {
+	// One of "http", "send_nsca" or "nsca". If not specified, "send_nsca" is
+	// used if send_nsca_bin is set, otherwise "http".
+	Transport string `toml:"transport"`
+
 	// Must match Nagios service's service_description attribute; if not
 	// specified in the config explicitly, the name of the output is used.
 	NagiosServiceDescription string `toml:"nagios_service_description"`
@@ -51,6 +59,13 @@ type NagiosOutputConfig struct {
 	SendNscaArgs           []string `toml:"send_nsca_args"`
 	SendNscaTimeoutSeconds uint     `toml:"send_nsca_timeout"`
 
+	// Address (host:port) of the nsca daemon, the encryption method and
+	// password it is configured with, and the connection timeout in seconds.
+	NscaAddress        string `toml:"nsca_address"`
+	NscaEncryption     string `toml:"nsca_encryption"`
+	NscaPassword       string `toml:"nsca_password"`
+	NscaTimeoutSeconds uint   `toml:"nsca_timeout"`
+
 	// URL to the Nagios cmd.cgi
 	Url string
 	// Nagios username
@@ -69,29 +84,52 @@ type NagiosOutputConfig struct {
 
 func (n *NagiosOutput) ConfigStruct() interface{} {
 	return &NagiosOutputConfig{
-		Url: "http://localhost/cgi-bin/cmd.cgi",
+		Url:                    "http://localhost/cgi-bin/cmd.cgi",
 		ResponseHeaderTimeout:  2,
 		SendNscaTimeoutSeconds: 5,
+		NscaAddress:            "localhost:5667",
+		NscaEncryption:         "xor",
+		NscaTimeoutSeconds:     10,
 	}
 }
 
 type NagiosOutput struct {
 	conf      *NagiosOutputConfig
 	client    *http.Client
+	nsca      *nscaClient
 	submitter func(host, service_description, state, output string) (err error)
 }
 
 func (n *NagiosOutput) Init(config interface{}) (err error) {
 	n.conf = config.(*NagiosOutputConfig)
 
-	n.submitter = n.submitSendNsca
-	if n.conf.SendNscaBin == "" {
-		// HTTP is implied.
+	transport := n.conf.Transport
+	if transport == "" {
+		transport = "http"
+		if n.conf.SendNscaBin != "" {
+			transport = "send_nsca"
+		}
+	}
+
+	switch transport {
+	case "send_nsca":
+		if n.conf.SendNscaBin == "" {
+			return fmt.Errorf("send_nsca transport requires send_nsca_bin")
+		}
+		n.submitter = n.submitSendNsca
+	case "nsca":
+		timeout := time.Duration(n.conf.NscaTimeoutSeconds) * time.Second
+		if n.nsca, err = newNscaClient(n.conf.NscaAddress, n.conf.NscaEncryption,
+			n.conf.NscaPassword, timeout); err != nil {
+			return
+		}
+		n.submitter = n.submitNsca
+	case "http":
 		n.submitter = n.submitHttp
 
 		rht := time.Duration(n.conf.ResponseHeaderTimeout) * time.Second
-		transport := &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
+		httpTransport := &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
 			ResponseHeaderTimeout: rht,
 		}
 		if n.conf.UseTls {
@@ -99,11 +137,13 @@ func (n *NagiosOutput) Init(config interface{}) (err error) {
 			if tlsConf, err = tcp.CreateGoTlsConfig(&n.conf.Tls); err != nil {
 				return fmt.Errorf("TLS init error: %s", err)
 			}
-			transport.TLSClientConfig = tlsConf
+			httpTransport.TLSClientConfig = tlsConf
 		}
 		n.client = &http.Client{
-			Transport: transport,
+			Transport: httpTransport,
 		}
+	default:
+		return fmt.Errorf("unknown transport: %s", transport)
 	}
 	return
 }
@@ -183,6 +223,16 @@ func (n *NagiosOutput) submitSendNsca(host, service_description, state,
 	return
 }
 
+func (n *NagiosOutput) submitNsca(host, service_description, state,
+	output string) (err error) {
+
+	var code int
+	if code, err = strconv.Atoi(state); err != nil {
+		return
+	}
+	return n.nsca.submit(host, service_description, int16(code), output)
+}
+
 func (n *NagiosOutput) submitHttp(host, service_description, state,
 	output string) (err error) {
 
@@ -16,7 +16,11 @@ package nagios
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"net"
 	"net/http"
 	"os"
@@ -131,6 +135,78 @@ func NagiosOutputSpec(c gs.Context) {
 			})
 		})
 
+		c.Specify("using NSCA transport", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			c.Assume(err, gs.IsNil)
+			defer listener.Close()
+
+			config.Transport = "nsca"
+			config.NscaAddress = listener.Addr().String()
+			config.NscaPassword = "secret"
+
+			iv := make([]byte, nscaIVSize)
+			for i := range iv {
+				iv[i] = byte(i * 7)
+			}
+			packetChan := make(chan []byte, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				initPacket := make([]byte, nscaInitSize)
+				copy(initPacket, iv)
+				binary.BigEndian.PutUint32(initPacket[nscaIVSize:], 1234567890)
+				conn.Write(initPacket)
+				packet := make([]byte, nscaPacketSize)
+				io.ReadFull(conn, packet)
+				packetChan <- packet
+			}()
+
+			cString := func(b []byte) string {
+				return string(b[:bytes.IndexByte(b, 0)])
+			}
+
+			c.Specify("sends an encrypted data packet", func() {
+				err = output.Init(config)
+				c.Assume(err, gs.IsNil)
+				outputWg.Add(1)
+				go run()
+
+				msg.SetPayload("WARNING:" + payload)
+				inChan <- pack
+				close(inChan)
+				outputWg.Wait()
+				packet := <-packetChan
+
+				// Undo the XOR encryption.
+				for i := range packet {
+					packet[i] ^= iv[i%len(iv)]
+					packet[i] ^= config.NscaPassword[i%len(config.NscaPassword)]
+				}
+				c.Expect(binary.BigEndian.Uint16(packet), gs.Equals, uint16(3))
+				c.Expect(binary.BigEndian.Uint32(packet[nscaTimeOffset:]), gs.Equals,
+					uint32(1234567890))
+				c.Expect(binary.BigEndian.Uint16(packet[nscaStateOffset:]), gs.Equals,
+					uint16(1))
+				c.Expect(cString(packet[nscaHostOffset:]), gs.Equals, "my.host.name")
+				c.Expect(cString(packet[nscaSvcOffset:]), gs.Equals, "GoSpec")
+				c.Expect(cString(packet[nscaOutputOffset:]), gs.Equals, payload)
+
+				crc := binary.BigEndian.Uint32(packet[nscaCrcOffset:])
+				binary.BigEndian.PutUint32(packet[nscaCrcOffset:], 0)
+				c.Expect(crc32.ChecksumIEEE(packet), gs.Equals, crc)
+			})
+		})
+
+		c.Specify("rejects an unknown NSCA encryption method", func() {
+			config.Transport = "nsca"
+			config.NscaEncryption = "rot13"
+			err := output.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
 		if runtime.GOOS != "windows" {
 			outPath := filepath.Join(os.TempDir(), "heka-nagios-test-output.txt")
 			echoFile := fmt.Sprintf(echoFileTmpl, outPath)
@@ -0,0 +1,191 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package nagios
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Sizes and offsets of the NSCA protocol version 3 packets, matching the
+// layout of the C structs used by the nsca daemon.
+const (
+	nscaVersion      = 3
+	nscaIVSize       = 128
+	nscaInitSize     = nscaIVSize + 4
+	nscaHostSize     = 64
+	nscaServiceSize  = 128
+	nscaOutputSize   = 512
+	nscaCrcOffset    = 4
+	nscaTimeOffset   = 8
+	nscaStateOffset  = 12
+	nscaHostOffset   = 14
+	nscaSvcOffset    = nscaHostOffset + nscaHostSize
+	nscaOutputOffset = nscaSvcOffset + nscaServiceSize
+	nscaPacketSize   = 720 // Includes the trailing struct padding.
+)
+
+// Encryption methods understood by the nsca daemon, keyed by the names used
+// in the `nsca_encryption` setting. The values match nsca's
+// `decryption_method` settings.
+var nscaEncryptionMethods = map[string]int{
+	"none": 0,
+	"xor":  1,
+	"des":  2,
+	"3des": 3,
+	"aes":  14, // mcrypt's RIJNDAEL-128 with a 256 bit key.
+}
+
+// nscaClient submits passive check results directly to an nsca daemon, one
+// connection per check result, as send_nsca does.
+type nscaClient struct {
+	address  string
+	method   int
+	password string
+	timeout  time.Duration
+}
+
+func newNscaClient(address, encryption, password string,
+	timeout time.Duration) (*nscaClient, error) {
+
+	method, ok := nscaEncryptionMethods[encryption]
+	if !ok {
+		return nil, fmt.Errorf("unsupported nsca_encryption: %s", encryption)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid nsca_address: %s", err)
+	}
+	return &nscaClient{
+		address:  address,
+		method:   method,
+		password: password,
+		timeout:  timeout,
+	}, nil
+}
+
+func (c *nscaClient) submit(host, service_description string, state int16,
+	output string) (err error) {
+
+	var conn net.Conn
+	if conn, err = net.DialTimeout("tcp", c.address, c.timeout); err != nil {
+		return
+	}
+	defer conn.Close()
+	if c.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	// The server opens with the IV to use for encryption and the timestamp
+	// to put in the data packet.
+	initPacket := make([]byte, nscaInitSize)
+	if _, err = io.ReadFull(conn, initPacket); err != nil {
+		return fmt.Errorf("reading nsca init packet: %s", err)
+	}
+	iv := initPacket[:nscaIVSize]
+	timestamp := binary.BigEndian.Uint32(initPacket[nscaIVSize:])
+
+	packet := nscaPacket(host, service_description, state, output, timestamp)
+	if err = c.encrypt(packet, iv); err != nil {
+		return
+	}
+	_, err = conn.Write(packet)
+	return
+}
+
+// nscaPacket builds a checksummed version 3 data packet.
+func nscaPacket(host, service_description string, state int16, output string,
+	timestamp uint32) []byte {
+
+	packet := make([]byte, nscaPacketSize)
+	// Like send_nsca, fill the unused space with random data.
+	for i := range packet {
+		packet[i] = byte(rand.Intn(256))
+	}
+	binary.BigEndian.PutUint16(packet, nscaVersion)
+	binary.BigEndian.PutUint32(packet[nscaTimeOffset:], timestamp)
+	binary.BigEndian.PutUint16(packet[nscaStateOffset:], uint16(state))
+	putCString(packet[nscaHostOffset:nscaSvcOffset], host)
+	putCString(packet[nscaSvcOffset:nscaOutputOffset], service_description)
+	putCString(packet[nscaOutputOffset:nscaOutputOffset+nscaOutputSize], output)
+
+	binary.BigEndian.PutUint32(packet[nscaCrcOffset:], 0)
+	binary.BigEndian.PutUint32(packet[nscaCrcOffset:], crc32.ChecksumIEEE(packet))
+	return packet
+}
+
+// putCString copies s into the fixed size buffer as a NUL terminated string,
+// truncating it if necessary.
+func putCString(buf []byte, s string) {
+	n := copy(buf[:len(buf)-1], s)
+	buf[n] = 0
+}
+
+func (c *nscaClient) encrypt(packet, iv []byte) (err error) {
+	var block cipher.Block
+	key := []byte(c.password)
+
+	switch c.method {
+	case 0:
+		return
+	case 1:
+		for i := range packet {
+			packet[i] ^= iv[i%len(iv)]
+		}
+		if len(key) > 0 {
+			for i := range packet {
+				packet[i] ^= key[i%len(key)]
+			}
+		}
+		return
+	case 2:
+		block, err = des.NewCipher(nscaKey(key, 8))
+	case 3:
+		block, err = des.NewTripleDESCipher(nscaKey(key, 24))
+	case 14:
+		block, err = aes.NewCipher(nscaKey(key, 32))
+	}
+	if err != nil {
+		return
+	}
+	cfb8Encrypt(block, iv[:block.BlockSize()], packet)
+	return
+}
+
+// nscaKey zero pads or truncates the password to the cipher's key size, as
+// mcrypt does.
+func nscaKey(password []byte, size int) []byte {
+	key := make([]byte, size)
+	copy(key, password)
+	return key
+}
+
+// cfb8Encrypt encrypts buf in place using mcrypt's "cfb" mode, i.e. CFB with
+// an 8 bit feedback, which the standard library doesn't provide.
+func cfb8Encrypt(block cipher.Block, iv, buf []byte) {
+	register := make([]byte, len(iv))
+	copy(register, iv)
+	out := make([]byte, len(iv))
+	for i := range buf {
+		block.Encrypt(out, register)
+		buf[i] ^= out[0]
+		copy(register, register[1:])
+		register[len(register)-1] = buf[i]
+	}
+}
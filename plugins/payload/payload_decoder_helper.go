@@ -26,21 +26,44 @@ type PayloadDecoderHelper struct {
 	Captures        map[string]string
 	dRunner         DecoderRunner
 	TimestampLayout string
-	TzLocation      *time.Location
-	SeverityMap     map[string]int32
+	// Candidate layouts tried in turn instead of TimestampLayout, if set.
+	TimestampLayouts []string
+	// Name of the field the matching candidate layout is recorded in, if set.
+	TimestampLayoutField string
+	TzLocation           *time.Location
+	SeverityMap          map[string]int32
 }
 
 /*
-Timestamps strings are decoded using the TimestampLayout and written
+Timestamps strings are decoded using the TimestampLayout, or the first of
+the TimestampLayouts that matches if any are specified, and written
 back to the Message as nanoseconds into the Timestamp field.
 In the case that a timestamp string is not in the capture map, no
 timestamp is written and the default of 0 is used.
 */
 func (pdh *PayloadDecoderHelper) DecodeTimestamp(pack *PipelinePack) {
 	if timeStamp, ok := pdh.Captures["Timestamp"]; ok {
-		val, err := ForgivingTimeParse(pdh.TimestampLayout, timeStamp, pdh.TzLocation)
-		if err != nil {
-			pdh.dRunner.LogError(fmt.Errorf("Don't recognize Timestamp: '%s'", timeStamp))
+		var (
+			val time.Time
+			err error
+		)
+		if len(pdh.TimestampLayouts) > 0 {
+			var layout string
+			val, layout, err = ParseTimeLayouts(pdh.TimestampLayouts, timeStamp,
+				pdh.TzLocation)
+			if err != nil {
+				pdh.dRunner.LogError(fmt.Errorf("Don't recognize Timestamp: '%s'",
+					timeStamp))
+				return
+			}
+			if pdh.TimestampLayoutField != "" {
+				NewStringField(pack.Message, pdh.TimestampLayoutField, layout)
+			}
+		} else {
+			val, err = ForgivingTimeParse(pdh.TimestampLayout, timeStamp, pdh.TzLocation)
+			if err != nil {
+				pdh.dRunner.LogError(fmt.Errorf("Don't recognize Timestamp: '%s'", timeStamp))
+			}
 		}
 		// If we only get a timestamp, use the current date
 		if val.Year() == 0 && val.Month() == 1 && val.Day() == 1 {
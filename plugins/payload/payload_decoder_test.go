@@ -218,6 +218,42 @@ func PayloadDecodersSpec(c gospec.Context) {
 			pack.Zero()
 		})
 
+		c.Specify("tries each of the timestamp layouts", func() {
+			conf.MatchRegex = `\[(?P<Timestamp>[^\]]+)\]`
+			conf.TimestampLayouts = []string{"RFC3339", "apache", "EpochMilli"}
+			conf.TimestampLayoutField = "ts_layout"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+			decoder.SetDecoderRunner(dRunner)
+
+			payloads := map[string]string{
+				"[2013-04-18T21:00:28Z]":       "RFC3339",
+				"[18/Apr/2013:14:00:28 -0700]": "apache",
+				"[1366318828000]":              "EpochMilli",
+			}
+			for payload, layout := range payloads {
+				pack.Message.SetPayload(payload)
+				_, err = decoder.Decode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1366318828000000000))
+				val, _ := pack.Message.GetFieldValue("ts_layout")
+				c.Expect(val, gs.Equals, layout)
+				pack.Zero()
+			}
+
+			c.Specify("and logs timestamps that don't match any of them", func() {
+				dRunner.EXPECT().LogError(gomock.Any())
+				pack.Message.SetPayload("[5:16PM]")
+				_, err = decoder.Decode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(0))
+				_, ok := pack.Message.GetFieldValue("ts_layout")
+				c.Expect(ok, gs.IsFalse)
+				pack.Zero()
+			})
+		})
+
 		c.Specify("adjusts timestamps as specified", func() {
 			conf.MatchRegex = `\[(?P<Timestamp>[^\]]+)\]`
 			conf.TimestampLayout = "02/Jan/2006:15:04:05"
@@ -40,6 +40,18 @@ type PayloadRegexDecoderConfig struct {
 	// http://golang.org/pkg/time/#pkg-constants).
 	TimestampLayout string `toml:"timestamp_layout"`
 
+	// Ordered list of candidate timestamp layouts, tried in turn until one
+	// parses the timestamp. Entries can be Go time layout strings or the
+	// names of common formats, e.g. "RFC3339", "syslog" or "apache". Takes
+	// precedence over `timestamp_layout`, and there's no fallback to the
+	// default layouts.
+	TimestampLayouts []string `toml:"timestamp_layouts"`
+
+	// Name of a message field in which to record which of the
+	// `timestamp_layouts` matched, for debugging. Defaults to not recording
+	// the layout.
+	TimestampLayoutField string `toml:"timestamp_layout_field"`
+
 	// Time zone in which the timestamps in the text are presumed to be in.
 	// Should be a location name corresponding to a file in the IANA Time Zone
 	// database (e.g. "America/Los_Angeles"), as parsed by Go's
@@ -54,13 +66,15 @@ type PayloadRegexDecoderConfig struct {
 }
 
 type PayloadRegexDecoder struct {
-	Match           *regexp.Regexp
-	SeverityMap     map[string]int32
-	MessageFields   MessageTemplate
-	TimestampLayout string
-	tzLocation      *time.Location
-	dRunner         DecoderRunner
-	logErrors       bool
+	Match                *regexp.Regexp
+	SeverityMap          map[string]int32
+	MessageFields        MessageTemplate
+	TimestampLayout      string
+	TimestampLayouts     []string
+	timestampLayoutField string
+	tzLocation           *time.Location
+	dRunner              DecoderRunner
+	logErrors            bool
 }
 
 func (ld *PayloadRegexDecoder) ConfigStruct() interface{} {
@@ -93,6 +107,8 @@ func (ld *PayloadRegexDecoder) Init(config interface{}) (err error) {
 		}
 	}
 	ld.TimestampLayout = conf.TimestampLayout
+	ld.TimestampLayouts = conf.TimestampLayouts
+	ld.timestampLayoutField = conf.TimestampLayoutField
 	if ld.tzLocation, err = time.LoadLocation(conf.TimestampLocation); err != nil {
 		err = fmt.Errorf("PayloadRegexDecoder unknown timestamp_location '%s': %s",
 			conf.TimestampLocation, err)
@@ -141,11 +157,13 @@ func (ld *PayloadRegexDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack
 	}
 
 	pdh := &PayloadDecoderHelper{
-		Captures:        captures,
-		dRunner:         ld.dRunner,
-		TimestampLayout: ld.TimestampLayout,
-		TzLocation:      ld.tzLocation,
-		SeverityMap:     ld.SeverityMap,
+		Captures:             captures,
+		dRunner:              ld.dRunner,
+		TimestampLayout:      ld.TimestampLayout,
+		TimestampLayouts:     ld.TimestampLayouts,
+		TimestampLayoutField: ld.timestampLayoutField,
+		TzLocation:           ld.tzLocation,
+		SeverityMap:          ld.SeverityMap,
 	}
 
 	pdh.DecodeTimestamp(pack)
@@ -39,6 +39,18 @@ type PayloadXmlDecoderConfig struct {
 	// match, all the default time layout's will be tried.
 	TimestampLayout string `toml:"timestamp_layout"`
 
+	// Ordered list of candidate timestamp layouts, tried in turn until one
+	// parses the timestamp. Entries can be Go time layout strings or the
+	// names of common formats, e.g. "RFC3339", "syslog" or "apache". Takes
+	// precedence over `timestamp_layout`, and there's no fallback to the
+	// default layouts.
+	TimestampLayouts []string `toml:"timestamp_layouts"`
+
+	// Name of a message field in which to record which of the
+	// `timestamp_layouts` matched, for debugging. Defaults to not recording
+	// the layout.
+	TimestampLayoutField string `toml:"timestamp_layout_field"`
+
 	// Time zone in which the timestamps in the text are presumed to be in.
 	// Should be a location name corresponding to a file in the IANA Time Zone
 	// database (e.g. "America/Los_Angeles"), as parsed by Go's
@@ -50,12 +62,14 @@ type PayloadXmlDecoderConfig struct {
 }
 
 type PayloadXmlDecoder struct {
-	XPathMap        map[string]*xmlpath.Path
-	SeverityMap     map[string]int32
-	MessageFields   MessageTemplate
-	TimestampLayout string
-	tzLocation      *time.Location
-	dRunner         DecoderRunner
+	XPathMap             map[string]*xmlpath.Path
+	SeverityMap          map[string]int32
+	MessageFields        MessageTemplate
+	TimestampLayout      string
+	TimestampLayouts     []string
+	timestampLayoutField string
+	tzLocation           *time.Location
+	dRunner              DecoderRunner
 }
 
 func (pxd *PayloadXmlDecoder) ConfigStruct() interface{} {
@@ -85,6 +99,8 @@ func (pxd *PayloadXmlDecoder) Init(config interface{}) (err error) {
 		}
 	}
 	pxd.TimestampLayout = conf.TimestampLayout
+	pxd.TimestampLayouts = conf.TimestampLayouts
+	pxd.timestampLayoutField = conf.TimestampLayoutField
 	if pxd.tzLocation, err = time.LoadLocation(conf.TimestampLocation); err != nil {
 		err = fmt.Errorf("PayloadXmlDecoder unknown timestamp_location '%s': %s",
 			conf.TimestampLocation, err)
@@ -128,11 +144,13 @@ func (pxd *PayloadXmlDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack,
 	captures := pxd.match(pack.Message.GetPayload())
 
 	pdh := &PayloadDecoderHelper{
-		Captures:        captures,
-		dRunner:         pxd.dRunner,
-		TimestampLayout: pxd.TimestampLayout,
-		TzLocation:      pxd.tzLocation,
-		SeverityMap:     pxd.SeverityMap,
+		Captures:             captures,
+		dRunner:              pxd.dRunner,
+		TimestampLayout:      pxd.TimestampLayout,
+		TimestampLayouts:     pxd.TimestampLayouts,
+		TimestampLayoutField: pxd.timestampLayoutField,
+		TzLocation:           pxd.tzLocation,
+		SeverityMap:          pxd.SeverityMap,
 	}
 
 	pdh.DecodeTimestamp(pack)
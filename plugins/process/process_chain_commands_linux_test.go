@@ -60,3 +60,9 @@ const PROCESSINPUT_PIPE_CMD2 = "grep"
 
 var PROCESSINPUT_PIPE_CMD2_ARGS = []string{"ignore"}
 var PROCESSINPUT_PIPE_OUTPUT = "ignore this line"
+
+// ProcessOutput test configuration
+const PROCESSOUTPUT_CMD = "cat"
+
+var PROCESSOUTPUT_CMD_ARGS = []string{}
+var PROCESSOUTPUT_OUTPUT = "one\ntwo\n"
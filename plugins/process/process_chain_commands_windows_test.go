@@ -60,3 +60,9 @@ const PROCESSINPUT_PIPE_CMD2 = "findstr"
 
 var PROCESSINPUT_PIPE_CMD2_ARGS = []string{"ignore"}
 var PROCESSINPUT_PIPE_OUTPUT = []string{"ignore ", "this ", "line\r"}
+
+// ProcessOutput test configuration
+const PROCESSOUTPUT_CMD = "more"
+
+var PROCESSOUTPUT_CMD_ARGS = []string{}
+var PROCESSOUTPUT_OUTPUT = "one\r\ntwo\r\n"
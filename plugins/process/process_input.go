@@ -19,11 +19,14 @@ package process
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -91,6 +94,10 @@ type ProcessInputConfig struct {
 
 	ParseStdout bool `toml:"stdout"`
 	ParseStderr bool `toml:"stderr"`
+
+	// Skip a scheduled run if the previous run of the command chain hasn't
+	// finished yet.
+	PreventOverlap bool `toml:"prevent_overlap"`
 }
 
 // Helper function for manually comparing structs since a map attribute means
@@ -108,6 +115,9 @@ func (pic *ProcessInputConfig) Equals(otherPic *ProcessInputConfig) bool {
 	if pic.ParseStderr != otherPic.ParseStderr {
 		return false
 	}
+	if pic.PreventOverlap != otherPic.PreventOverlap {
+		return false
+	}
 	if len(pic.Command) != len(otherPic.Command) {
 		return false
 	}
@@ -128,9 +138,11 @@ func (pic *ProcessInputConfig) Equals(otherPic *ProcessInputConfig) bool {
 // output as a stream into Message objects to be passed into
 // the Router for delivery to matching Filter or Output plugins.
 type ProcessInput struct {
+	skippedRuns int64
 	ProcessName string
 	cc          *CommandChain
 	ir          InputRunner
+	pConfig     *PipelineConfig
 
 	parseStdout bool
 	parseStderr bool
@@ -149,9 +161,18 @@ type ProcessInput struct {
 	tickInterval   uint
 	immediateStart bool
 
+	// Lock file held while the command chain runs, only used if
+	// prevent_overlap is set.
+	lockFile string
+
 	once sync.Once
 }
 
+// SetPipelineConfig implements the WantsPipelineConfig interface.
+func (pi *ProcessInput) SetPipelineConfig(pConfig *PipelineConfig) {
+	pi.pConfig = pConfig
+}
+
 // ConfigStruct implements the HasConfigStruct interface and sets
 // defaults.
 func (pi *ProcessInput) ConfigStruct() interface{} {
@@ -176,6 +197,14 @@ func (pi *ProcessInput) Init(config interface{}) (err error) {
 		return fmt.Errorf("No Command Configured")
 	}
 
+	if conf.PreventOverlap {
+		lockDir := pi.pConfig.Globals.PrependBaseDir("process_input")
+		if err = os.MkdirAll(lockDir, 0700); err != nil {
+			return fmt.Errorf("can't create lock directory: %s", err)
+		}
+		pi.lockFile = filepath.Join(lockDir, pi.ProcessName+".lock")
+	}
+
 	pi.cc = NewCommandChain(time.Duration(conf.TimeoutSeconds) * time.Second)
 
 	// We need to mangle the indexes to be integers
@@ -309,10 +338,11 @@ func (pi *ProcessInput) RunCmd() {
 		return
 	}
 
+	tickChan := pi.ir.Ticker()
 	if pi.immediateStart {
 		pi.runOnce()
+		pi.skipMissedTick(tickChan)
 	}
-	tickChan := pi.ir.Ticker()
 	for {
 		select {
 		case <-tickChan:
@@ -324,16 +354,82 @@ func (pi *ProcessInput) RunCmd() {
 				pi.stopChan <- true
 				return
 			}
+			pi.skipMissedTick(tickChan)
 		case <-pi.stopChan:
 			return
 		}
 	}
 }
 
+// skipMissedTick discards a tick that fired while the previous run was still
+// in progress, so it doesn't start another run straight away. Only applies if
+// prevent_overlap is set.
+func (pi *ProcessInput) skipMissedTick(tickChan <-chan time.Time) {
+	if pi.lockFile == "" {
+		return
+	}
+	select {
+	case <-tickChan:
+		pi.skipRun("previous run still in progress")
+	default:
+	}
+}
+
+func (pi *ProcessInput) skipRun(reason string) {
+	atomic.AddInt64(&pi.skippedRuns, 1)
+	pi.ir.LogMessage(fmt.Sprintf("Skipped run: %s", reason))
+}
+
+// acquireLock creates the lock file, returning false if it's held by a run
+// that hasn't finished yet. A lock file left behind by another Heka process,
+// e.g. after a crash, is taken over.
+func (pi *ProcessInput) acquireLock() (bool, error) {
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	f, err := os.OpenFile(pi.lockFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = f.Write(pid)
+		f.Close()
+		return err == nil, err
+	}
+	if !os.IsExist(err) {
+		return false, err
+	}
+	owner, err := ioutil.ReadFile(pi.lockFile)
+	if err != nil {
+		return false, err
+	}
+	if string(owner) == string(pid) {
+		return false, nil
+	}
+	if err = ioutil.WriteFile(pi.lockFile, pid, 0600); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (pi *ProcessInput) releaseLock() {
+	if err := os.Remove(pi.lockFile); err != nil && !os.IsNotExist(err) {
+		pi.ir.LogError(fmt.Errorf("can't remove lock file: %s", err))
+	}
+}
+
 func (pi *ProcessInput) runOnce() {
 	// Stdout of the last command in the pipe gets sent to provided stdout.
 	var err error
 
+	if pi.lockFile != "" {
+		var ok bool
+		if ok, err = pi.acquireLock(); err != nil {
+			pi.ir.LogError(fmt.Errorf("can't acquire lock file: %s", err))
+			return
+		}
+		if !ok {
+			pi.skipRun("lock file held by a running command chain")
+			return
+		}
+		defer pi.releaseLock()
+	}
+
 	if err = pi.cc.Start(); err != nil {
 		pi.exitError = fmt.Errorf("CommandChain::Start() error: [%s]", err)
 		return
@@ -386,6 +482,14 @@ func (pi *ProcessInput) ParseOutput(r io.Reader, deliverer Deliverer,
 	}
 }
 
+// ReportMsg implements the ReportingPlugin interface to provide plugin state
+// information to the Heka report and dashboard.
+func (pi *ProcessInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "SkippedRuns", atomic.LoadInt64(&pi.skippedRuns),
+		"count")
+	return nil
+}
+
 // CleanupForRestart implements the Restarting interface.
 func (pi *ProcessInput) CleanupForRestart() {
 	// Reset the CommandChain (and therefore os.exec status)
@@ -18,6 +18,10 @@ package process
 import (
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	. "github.com/mozilla-services/heka/pipeline"
@@ -150,6 +154,52 @@ func ProcessInputSpec(c gs.Context) {
 				err = <-errChan
 				c.Expect(err, gs.IsNil)
 			})
+
+			c.Specify("skips a run while the lock file is held", func() {
+				tmpDir, err := ioutil.TempDir("", "process_input")
+				c.Assume(err, gs.IsNil)
+				defer os.RemoveAll(tmpDir)
+				pConfig.Globals.BaseDir = tmpDir
+				pInput.SetPipelineConfig(pConfig)
+				pInput.SetName("LockedCmd")
+
+				config.PreventOverlap = true
+				config.Command["0"] = cmdConfig{
+					Bin:  PROCESSINPUT_TEST1_CMD,
+					Args: PROCESSINPUT_TEST1_CMD_ARGS,
+				}
+				err = pInput.Init(config)
+				c.Assume(err, gs.IsNil)
+
+				lockFile := filepath.Join(tmpDir, "process_input", "LockedCmd.lock")
+				pid := strconv.Itoa(os.Getpid())
+				err = ioutil.WriteFile(lockFile, []byte(pid), 0600)
+				c.Assume(err, gs.IsNil)
+
+				logChan := make(chan string, 1)
+				logCall := ith.MockInputRunner.EXPECT().LogMessage(gomock.Any())
+				logCall.Do(func(msg string) {
+					logChan <- msg
+				})
+
+				go func() {
+					errChan <- pInput.Run(ith.MockInputRunner, ith.MockHelper)
+				}()
+				tickChan <- time.Now()
+				c.Expect(<-logChan, gs.Equals,
+					"Skipped run: lock file held by a running command chain")
+				c.Expect(atomic.LoadInt64(&pInput.skippedRuns), gs.Equals, int64(1))
+
+				os.Remove(lockFile)
+				tickChan <- time.Now()
+				actual := <-bytesChan
+				c.Expect(string(actual), gs.Equals, PROCESSINPUT_TEST1_OUTPUT+"\n")
+				<-decChan
+
+				pInput.Stop()
+				err = <-errChan
+				c.Expect(err, gs.IsNil)
+			})
 		})
 
 		c.Specify("using stderr", func() {
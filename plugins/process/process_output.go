@@ -0,0 +1,183 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package process
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Output plugin that starts an external command and writes each encoded
+// message to its standard input. The command is restarted if it exits, with
+// the back off specified by the output's `retries` setting if it can't be.
+type ProcessOutput struct {
+	conf   *ProcessOutputConfig
+	or     OutputRunner
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	exited chan struct{}
+	// Where the command's standard output goes, discarded if nil. Overridden
+	// by the tests.
+	stdout io.Writer
+
+	processMessageCount int64
+	dropMessageCount    int64
+	restartCount        int64
+}
+
+// ProcessOutput config struct.
+type ProcessOutputConfig struct {
+	// Command to run. Required.
+	Command cmdConfig `toml:"command"`
+	// Number of seconds to wait for the command to exit after its standard
+	// input is closed before it's killed. Defaults to 5.
+	ShutdownTimeout uint `toml:"shutdown_timeout"`
+}
+
+func (o *ProcessOutput) ConfigStruct() interface{} {
+	return &ProcessOutputConfig{
+		ShutdownTimeout: 5,
+	}
+}
+
+func (o *ProcessOutput) Init(config interface{}) error {
+	o.conf = config.(*ProcessOutputConfig)
+	if o.conf.Command.Bin == "" {
+		return errors.New("`command` must specify a `bin`")
+	}
+	return nil
+}
+
+func (o *ProcessOutput) Prepare(or OutputRunner, h PluginHelper) error {
+	o.or = or
+	return o.start()
+}
+
+// Starts the command, with a goroutine waiting for it to exit.
+func (o *ProcessOutput) start() (err error) {
+	cmd := exec.Command(o.conf.Command.Bin, o.conf.Command.Args...)
+	cmd.Dir = o.conf.Command.Directory
+	cmd.Env = o.conf.Command.Env
+	cmd.Stdout = o.stdout
+	if o.stdin, err = cmd.StdinPipe(); err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		o.stdin.Close()
+		return err
+	}
+	o.cmd = cmd
+	exited := make(chan struct{})
+	o.exited = exited
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			o.or.LogMessage(fmt.Sprintf("command exited: %s", err))
+		} else {
+			o.or.LogMessage("command exited")
+		}
+		close(exited)
+	}()
+	return nil
+}
+
+// Closes the command's standard input and waits for it to exit, killing it if
+// it takes longer than the shutdown timeout.
+func (o *ProcessOutput) stop() {
+	if o.cmd == nil {
+		return
+	}
+	o.stdin.Close()
+	timeout := time.Duration(o.conf.ShutdownTimeout) * time.Second
+	select {
+	case <-o.exited:
+	case <-time.After(timeout):
+		o.cmd.Process.Kill()
+		<-o.exited
+	}
+	o.cmd = nil
+}
+
+// Returns whether the command has exited.
+func (o *ProcessOutput) hasExited() bool {
+	select {
+	case <-o.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// Writes the encoded message to the command's standard input, restarting the
+// command first if it has exited. Writes block while the command isn't
+// reading its input, holding back the output's queue until it catches up.
+func (o *ProcessOutput) ProcessMessage(pack *PipelinePack) error {
+	record, err := o.or.Encode(pack)
+	if err != nil {
+		atomic.AddInt64(&o.dropMessageCount, 1)
+		return fmt.Errorf("can't encode: %s", err)
+	}
+	if len(record) == 0 {
+		o.or.UpdateCursor(pack.QueueCursor)
+		return nil
+	}
+
+	if o.cmd != nil && o.hasExited() {
+		o.stop()
+	}
+	if o.cmd == nil {
+		if err = o.start(); err != nil {
+			return NewRetryMessageError("can't restart command: %s", err)
+		}
+		atomic.AddInt64(&o.restartCount, 1)
+	}
+
+	if _, err = o.stdin.Write(record); err != nil {
+		o.stop()
+		return NewRetryMessageError("writing to command: %s", err)
+	}
+	atomic.AddInt64(&o.processMessageCount, 1)
+	o.or.UpdateCursor(pack.QueueCursor)
+	return nil
+}
+
+func (o *ProcessOutput) CleanUp() {
+	o.stop()
+}
+
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (o *ProcessOutput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ProcessMessageCount",
+		atomic.LoadInt64(&o.processMessageCount), "count")
+	message.NewInt64Field(msg, "DropMessageCount",
+		atomic.LoadInt64(&o.dropMessageCount), "count")
+	message.NewInt64Field(msg, "RestartCount",
+		atomic.LoadInt64(&o.restartCount), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("ProcessOutput", func() interface{} {
+		return new(ProcessOutput)
+	})
+}
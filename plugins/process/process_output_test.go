@@ -0,0 +1,112 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package process
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	plugins_ts "github.com/mozilla-services/heka/plugins/testsupport"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func ProcessOutputSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A ProcessOutput", func() {
+		output := new(ProcessOutput)
+		config := output.ConfigStruct().(*ProcessOutputConfig)
+		config.Command = cmdConfig{
+			Bin:  PROCESSOUTPUT_CMD,
+			Args: PROCESSOUTPUT_CMD_ARGS,
+		}
+		oth := plugins_ts.NewOutputTestHelper(ctrl)
+		var stdout bytes.Buffer
+		output.stdout = &stdout
+
+		pack := NewPipelinePack(nil)
+		expectRecords := func(records ...string) {
+			for _, record := range records {
+				oth.MockOutputRunner.EXPECT().Encode(pack).Return([]byte(record), nil)
+			}
+		}
+		oth.MockOutputRunner.EXPECT().UpdateCursor(gomock.Any()).AnyTimes()
+		oth.MockOutputRunner.EXPECT().LogMessage(gomock.Any()).AnyTimes()
+
+		c.Specify("requires a command", func() {
+			config.Command.Bin = ""
+			err := output.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("writes records to the command's stdin", func() {
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			err = output.Prepare(oth.MockOutputRunner, oth.MockHelper)
+			c.Assume(err, gs.IsNil)
+			expectRecords("one\n", "two\n")
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+			output.CleanUp()
+			c.Expect(stdout.String(), gs.Equals, PROCESSOUTPUT_OUTPUT)
+			c.Expect(output.processMessageCount, gs.Equals, int64(2))
+		})
+
+		c.Specify("restarts the command if it exits", func() {
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			err = output.Prepare(oth.MockOutputRunner, oth.MockHelper)
+			c.Assume(err, gs.IsNil)
+			output.cmd.Process.Kill()
+			<-output.exited
+			expectRecords("one\n", "two\n")
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+			c.Expect(output.restartCount, gs.Equals, int64(1))
+			output.CleanUp()
+			c.Expect(stdout.String(), gs.Equals, PROCESSOUTPUT_OUTPUT)
+		})
+
+		c.Specify("asks for a retry if the command can't be restarted", func() {
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			err = output.Prepare(oth.MockOutputRunner, oth.MockHelper)
+			c.Assume(err, gs.IsNil)
+			output.cmd.Process.Kill()
+			<-output.exited
+			output.conf.Command.Bin = "./not_a_command"
+			expectRecords("one\n")
+			err = output.ProcessMessage(pack)
+			_, ok := err.(RetryMessageError)
+			c.Expect(ok, gs.IsTrue)
+		})
+
+		c.Specify("drops records that can't be encoded", func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			oth := plugins_ts.NewOutputTestHelper(ctrl)
+			oth.MockOutputRunner.EXPECT().Encode(pack).Return(nil, errors.New("bad"))
+			output.or = oth.MockOutputRunner
+			err := output.ProcessMessage(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(output.dropMessageCount, gs.Equals, int64(1))
+		})
+	})
+}
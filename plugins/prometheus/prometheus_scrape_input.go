@@ -0,0 +1,239 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/pborman/uuid"
+)
+
+const acceptHeader = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+
+// Field names used for the sample data, labels with one of these names are
+// stored with a "label_" prefix instead.
+var reservedFields = map[string]bool{
+	"metric":      true,
+	"family":      true,
+	"metric_type": true,
+	"value":       true,
+	"target":      true,
+	"stale":       true,
+}
+
+// Input that periodically scrapes Prometheus `/metrics` endpoints and
+// generates a message for each sample.
+type PrometheusScrapeInput struct {
+	conf     *PrometheusScrapeInputConfig
+	ir       InputRunner
+	client   *http.Client
+	hostname string
+	stopChan chan struct{}
+	// Series seen in the most recent successful scrape of each target, used
+	// to notice the ones that go away.
+	series map[string]map[string]*sample
+
+	scrapeCount       int64
+	failedScrapeCount int64
+	sampleCount       int64
+}
+
+// PrometheusScrapeInput config struct.
+type PrometheusScrapeInputConfig struct {
+	// URLs of the endpoints to scrape. Required.
+	Urls []string `toml:"urls"`
+	// Extra request headers.
+	Headers map[string]string `toml:"headers"`
+	// Username and password for Basic Authentication.
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	// Number of seconds to wait for a scrape to complete. Defaults to 10.
+	Timeout uint `toml:"timeout"`
+	// Type of the generated messages. Defaults to "heka.prometheus".
+	MessageType string `toml:"message_type"`
+	// Prefix prepended to label names to make the field names. Defaults to
+	// no prefix.
+	LabelPrefix string `toml:"label_prefix"`
+	// Whether to generate a message flagged with a `stale` field when a series
+	// from the previous scrape of a target is no longer exposed. Defaults to
+	// true.
+	EmitStale bool `toml:"emit_stale"`
+	// Scrape interval in seconds. Defaults to 15.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+func (pi *PrometheusScrapeInput) ConfigStruct() interface{} {
+	return &PrometheusScrapeInputConfig{
+		Timeout:        10,
+		MessageType:    "heka.prometheus",
+		EmitStale:      true,
+		TickerInterval: uint(15),
+	}
+}
+
+func (pi *PrometheusScrapeInput) Init(config interface{}) error {
+	pi.conf = config.(*PrometheusScrapeInputConfig)
+	if len(pi.conf.Urls) == 0 {
+		return errors.New("`urls` must contain at least one URL")
+	}
+	if pi.conf.Timeout == 0 {
+		return errors.New("`timeout` must be greater than zero")
+	}
+	pi.client = &http.Client{
+		Timeout: time.Duration(pi.conf.Timeout) * time.Second,
+	}
+	pi.series = make(map[string]map[string]*sample)
+	pi.stopChan = make(chan struct{})
+	return nil
+}
+
+func (pi *PrometheusScrapeInput) Run(ir InputRunner, h PluginHelper) error {
+	pi.ir = ir
+	pi.hostname = h.Hostname()
+
+	ticker := ir.Ticker()
+	for {
+		select {
+		case <-ticker:
+			for _, url := range pi.conf.Urls {
+				pi.scrape(url)
+			}
+		case <-pi.stopChan:
+			return nil
+		}
+	}
+}
+
+func (pi *PrometheusScrapeInput) Stop() {
+	close(pi.stopChan)
+}
+
+// Fetches and delivers the samples for a single target. An "up" sample is
+// always delivered, with a value of 1 if the scrape succeeded and 0 if it
+// didn't.
+func (pi *PrometheusScrapeInput) scrape(url string) {
+	atomic.AddInt64(&pi.scrapeCount, 1)
+	now := time.Now()
+	samples, err := pi.fetch(url)
+	up := &sample{name: "up", family: "up", metricType: "gauge", value: 1}
+	if err != nil {
+		atomic.AddInt64(&pi.failedScrapeCount, 1)
+		pi.ir.LogError(fmt.Errorf("scraping %s: %s", url, err))
+		up.value = 0
+		pi.deliver(url, up, now, false)
+		return
+	}
+
+	current := make(map[string]*sample, len(samples))
+	for _, s := range samples {
+		current[s.key()] = s
+		pi.deliver(url, s, now, false)
+	}
+	atomic.AddInt64(&pi.sampleCount, int64(len(samples)))
+	if pi.conf.EmitStale {
+		for key, s := range pi.series[url] {
+			if _, ok := current[key]; !ok {
+				pi.deliver(url, s, now, true)
+			}
+		}
+	}
+	pi.series[url] = current
+	pi.deliver(url, up, now, false)
+}
+
+func (pi *PrometheusScrapeInput) fetch(url string) ([]*sample, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if pi.conf.Username != "" {
+		req.SetBasicAuth(pi.conf.Username, pi.conf.Password)
+	}
+	req.Header.Set("Accept", acceptHeader)
+	req.Header.Set("User-Agent", "Heka")
+	for key, value := range pi.conf.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := pi.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return parseTextFormat(resp.Body)
+}
+
+func (pi *PrometheusScrapeInput) deliver(url string, s *sample, now time.Time,
+	stale bool) {
+
+	pack := <-pi.ir.InChan()
+	msg := pack.Message
+	msg.SetUuid(uuid.NewRandom())
+	if s.timestamp != 0 && !stale {
+		msg.SetTimestamp(s.timestamp * int64(time.Millisecond))
+	} else {
+		msg.SetTimestamp(now.UnixNano())
+	}
+	msg.SetType(pi.conf.MessageType)
+	msg.SetLogger(pi.ir.Name())
+	msg.SetHostname(pi.hostname)
+	message.NewStringField(msg, "metric", s.name)
+	message.NewStringField(msg, "family", s.family)
+	message.NewStringField(msg, "metric_type", s.metricType)
+	message.NewStringField(msg, "target", url)
+	if stale {
+		if f, err := message.NewField("stale", true, ""); err == nil {
+			msg.AddField(f)
+		}
+	} else {
+		if f, err := message.NewField("value", s.value, ""); err == nil {
+			msg.AddField(f)
+		}
+	}
+	for _, l := range s.labels {
+		name := pi.conf.LabelPrefix + l.name
+		if reservedFields[name] {
+			name = "label_" + name
+		}
+		message.NewStringField(msg, name, l.value)
+	}
+	pi.ir.Deliver(pack)
+}
+
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (pi *PrometheusScrapeInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ScrapeCount",
+		atomic.LoadInt64(&pi.scrapeCount), "count")
+	message.NewInt64Field(msg, "FailedScrapeCount",
+		atomic.LoadInt64(&pi.failedScrapeCount), "count")
+	message.NewInt64Field(msg, "SampleCount",
+		atomic.LoadInt64(&pi.sampleCount), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("PrometheusScrapeInput", func() interface{} {
+		return new(PrometheusScrapeInput)
+	})
+}
@@ -0,0 +1,242 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package prometheus
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+const exposition = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# A comment.
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+metric_without_timestamp_and_labels 12.47
+nan_metric NaN
+`
+
+func TextFormatSpec(c gs.Context) {
+	c.Specify("The text format parser", func() {
+		c.Specify("parses samples", func() {
+			samples, err := parseTextFormat(strings.NewReader(exposition))
+			c.Assume(err, gs.IsNil)
+			c.Assume(len(samples), gs.Equals, 12)
+
+			s := samples[0]
+			c.Expect(s.name, gs.Equals, "http_requests_total")
+			c.Expect(s.family, gs.Equals, "http_requests_total")
+			c.Expect(s.metricType, gs.Equals, "counter")
+			c.Expect(s.value, gs.Equals, float64(1027))
+			c.Expect(s.timestamp, gs.Equals, int64(1395066363000))
+			c.Expect(len(s.labels), gs.Equals, 2)
+			c.Expect(s.labels[0], gs.Equals, label{"method", "post"})
+			c.Expect(s.labels[1], gs.Equals, label{"code", "200"})
+			c.Expect(samples[1].value, gs.Equals, float64(3))
+
+			s = samples[2]
+			c.Expect(s.metricType, gs.Equals, "untyped")
+			c.Expect(s.labels[0].value, gs.Equals, `C:\DIR\FILE.TXT`)
+			c.Expect(s.labels[1].value, gs.Equals, "Cannot find file:\n\"FILE.TXT\"")
+			c.Expect(s.timestamp, gs.Equals, int64(0))
+
+			for _, s := range samples[3:6] {
+				c.Expect(s.family, gs.Equals, "rpc_duration_seconds")
+				c.Expect(s.metricType, gs.Equals, "summary")
+			}
+			for _, s := range samples[6:10] {
+				c.Expect(s.family, gs.Equals, "http_request_duration_seconds")
+				c.Expect(s.metricType, gs.Equals, "histogram")
+			}
+			c.Expect(samples[7].labels[0].value, gs.Equals, "+Inf")
+			c.Expect(samples[10].labels, gs.IsNil)
+			c.Expect(math.IsNaN(samples[11].value), gs.IsTrue)
+		})
+
+		c.Specify("generates series keys independent of label order", func() {
+			samples, err := parseTextFormat(strings.NewReader(
+				"a{x=\"1\",y=\"2\"} 1\na{y=\"2\",x=\"1\",} 2\na{x=\"2\"} 3\n"))
+			c.Assume(err, gs.IsNil)
+			c.Expect(samples[0].key(), gs.Equals, samples[1].key())
+			c.Expect(samples[0].key() == samples[2].key(), gs.IsFalse)
+		})
+
+		c.Specify("rejects malformed lines", func() {
+			bad := []string{
+				"1metric 1",
+				"metric",
+				"metric one",
+				"metric 1 2 3",
+				"metric 1 soon",
+				"metric{a=1} 1",
+				"metric{a=\"1\" 1",
+				"metric{=\"1\"} 1",
+				"metric{a=\"1\"b=\"2\"} 1",
+			}
+			for _, line := range bad {
+				_, err := parseTextFormat(strings.NewReader(line))
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+	})
+}
+
+func PrometheusScrapeInputSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	pConfig := NewPipelineConfig(nil)
+
+	c.Specify("A PrometheusScrapeInput", func() {
+		input := new(PrometheusScrapeInput)
+		config := input.ConfigStruct().(*PrometheusScrapeInputConfig)
+		ir := pipelinemock.NewMockInputRunner(ctrl)
+		h := pipelinemock.NewMockPluginHelper(ctrl)
+
+		body := "# TYPE jobs gauge\njobs{queue=\"a\"} 3\njobs{queue=\"b\",value=\"x\"} 4\n"
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				c.Expect(r.Header.Get("Accept"), gs.Equals, acceptHeader)
+				w.WriteHeader(status)
+				w.Write([]byte(body))
+			}))
+		defer server.Close()
+		config.Urls = []string{server.URL}
+
+		ir.EXPECT().Name().Return("PromInput").AnyTimes()
+		recycleChan := make(chan *PipelinePack, 10)
+		for i := 0; i < 10; i++ {
+			recycleChan <- NewPipelinePack(pConfig.InputRecycleChan())
+		}
+		ir.EXPECT().InChan().Return(recycleChan).AnyTimes()
+		var delivered []*message.Message
+		ir.EXPECT().Deliver(gomock.Any()).Do(func(pack *PipelinePack) {
+			delivered = append(delivered, pack.Message)
+			pack.Message = new(message.Message)
+			recycleChan <- pack
+		}).AnyTimes()
+
+		err := input.Init(config)
+		c.Assume(err, gs.IsNil)
+		input.ir = ir
+		input.hostname = "prom.example.com"
+
+		c.Specify("delivers a message per sample and an up sample", func() {
+			input.scrape(server.URL)
+			c.Assume(len(delivered), gs.Equals, 3)
+
+			msg := delivered[0]
+			c.Expect(msg.GetType(), gs.Equals, "heka.prometheus")
+			c.Expect(msg.GetLogger(), gs.Equals, "PromInput")
+			c.Expect(msg.GetHostname(), gs.Equals, "prom.example.com")
+			val, _ := msg.GetFieldValue("metric")
+			c.Expect(val, gs.Equals, "jobs")
+			val, _ = msg.GetFieldValue("metric_type")
+			c.Expect(val, gs.Equals, "gauge")
+			val, _ = msg.GetFieldValue("value")
+			c.Expect(val, gs.Equals, float64(3))
+			val, _ = msg.GetFieldValue("queue")
+			c.Expect(val, gs.Equals, "a")
+			val, _ = msg.GetFieldValue("target")
+			c.Expect(val, gs.Equals, server.URL)
+
+			// Labels that collide with the sample fields are prefixed.
+			val, _ = delivered[1].GetFieldValue("value")
+			c.Expect(val, gs.Equals, float64(4))
+			val, _ = delivered[1].GetFieldValue("label_value")
+			c.Expect(val, gs.Equals, "x")
+
+			val, _ = delivered[2].GetFieldValue("metric")
+			c.Expect(val, gs.Equals, "up")
+			val, _ = delivered[2].GetFieldValue("value")
+			c.Expect(val, gs.Equals, float64(1))
+
+			c.Specify("and flags series that go away as stale", func() {
+				body = "# TYPE jobs gauge\njobs{queue=\"a\"} 5\n"
+				delivered = nil
+				input.scrape(server.URL)
+				c.Assume(len(delivered), gs.Equals, 3)
+				val, _ := delivered[1].GetFieldValue("stale")
+				c.Expect(val, gs.Equals, true)
+				val, _ = delivered[1].GetFieldValue("queue")
+				c.Expect(val, gs.Equals, "b")
+				_, ok := delivered[1].GetFieldValue("value")
+				c.Expect(ok, gs.IsFalse)
+			})
+		})
+
+		c.Specify("delivers a down sample when a scrape fails", func() {
+			status = http.StatusInternalServerError
+			ir.EXPECT().LogError(gomock.Any())
+			input.scrape(server.URL)
+			c.Assume(len(delivered), gs.Equals, 1)
+			val, _ := delivered[0].GetFieldValue("metric")
+			c.Expect(val, gs.Equals, "up")
+			val, _ = delivered[0].GetFieldValue("value")
+			c.Expect(val, gs.Equals, float64(0))
+			c.Expect(input.failedScrapeCount, gs.Equals, int64(1))
+
+			c.Specify("without flagging existing series as stale", func() {
+				status = http.StatusOK
+				input.scrape(server.URL)
+				delivered = nil
+				status = http.StatusBadGateway
+				ir.EXPECT().LogError(gomock.Any())
+				input.scrape(server.URL)
+				c.Expect(len(delivered), gs.Equals, 1)
+			})
+		})
+
+		c.Specify("scrapes on each tick", func() {
+			tickChan := make(chan time.Time)
+			ir.EXPECT().Ticker().Return(tickChan)
+			h.EXPECT().Hostname().Return("prom.example.com")
+			done := make(chan error)
+			go func() {
+				done <- input.Run(ir, h)
+			}()
+			tickChan <- time.Now()
+			tickChan <- time.Now()
+			input.Stop()
+			c.Expect(<-done, gs.IsNil)
+			c.Expect(input.scrapeCount, gs.Equals, int64(2))
+		})
+	})
+}
@@ -0,0 +1,230 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Value of a label on a sample.
+type label struct {
+	name  string
+	value string
+}
+
+// A single sample line from the Prometheus text exposition format.
+type sample struct {
+	name       string // Sample name, e.g. "http_request_duration_seconds_bucket".
+	family     string // Metric family name, e.g. "http_request_duration_seconds".
+	metricType string // Type of the family, "untyped" if no TYPE line was seen.
+	labels     []label
+	value      float64
+	timestamp  int64 // Milliseconds since the epoch, 0 if not specified.
+}
+
+// Returns a string uniquely identifying the sample's series, i.e. its name
+// and labels.
+func (s *sample) key() string {
+	labels := make([]string, len(s.labels))
+	for i, l := range s.labels {
+		labels[i] = l.name + "=" + strconv.Quote(l.value)
+	}
+	sort.Strings(labels)
+	return s.name + "{" + strings.Join(labels, ",") + "}"
+}
+
+// Suffixes of the samples that make up histograms and summaries.
+var familySuffixes = map[string][]string{
+	"histogram": {"_bucket", "_sum", "_count"},
+	"summary":   {"_sum", "_count"},
+}
+
+// Longest line parseTextFormat accepts.
+const maxLineSize = 1024 * 1024
+
+// Parses the Prometheus text exposition format, version 0.0.4, into samples.
+// Returns an error for the first line that can't be parsed.
+func parseTextFormat(r io.Reader) ([]*sample, error) {
+	var (
+		samples []*sample
+		types   = make(map[string]string)
+		lineNum int
+	)
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			if line == "" {
+				break
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		lineNum++
+		if len(line) > maxLineSize {
+			return nil, fmt.Errorf("line %d: longer than %d bytes", lineNum,
+				maxLineSize)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			fields := strings.Fields(line[1:])
+			if len(fields) >= 3 && fields[0] == "TYPE" {
+				types[fields[1]] = strings.ToLower(fields[2])
+			}
+			// HELP lines and other comments are ignored.
+			continue
+		}
+		s, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNum, err)
+		}
+		s.family, s.metricType = sampleFamily(s.name, types)
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// Figures out which metric family a sample belongs to from the TYPE lines seen
+// so far.
+func sampleFamily(name string, types map[string]string) (family, metricType string) {
+	if t, ok := types[name]; ok {
+		return name, t
+	}
+	for t, suffixes := range familySuffixes {
+		for _, suffix := range suffixes {
+			if !strings.HasSuffix(name, suffix) {
+				continue
+			}
+			base := strings.TrimSuffix(name, suffix)
+			if types[base] == t {
+				return base, t
+			}
+		}
+	}
+	return name, "untyped"
+}
+
+func parseSample(line string) (*sample, error) {
+	s := new(sample)
+	i := 0
+	for i < len(line) && isNameChar(line[i], i == 0) {
+		i++
+	}
+	if i == 0 {
+		return nil, fmt.Errorf("invalid metric name: %s", line)
+	}
+	s.name = line[:i]
+	rest := strings.TrimLeft(line[i:], " \t")
+	if strings.HasPrefix(rest, "{") {
+		var err error
+		if s.labels, rest, err = parseLabels(rest[1:]); err != nil {
+			return nil, err
+		}
+	}
+	parts := strings.Fields(rest)
+	if len(parts) < 1 || len(parts) > 2 {
+		return nil, fmt.Errorf("expected a value and optional timestamp: %s", line)
+	}
+	var err error
+	if s.value, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return nil, fmt.Errorf("invalid value '%s'", parts[0])
+	}
+	if len(parts) == 2 {
+		if s.timestamp, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid timestamp '%s'", parts[1])
+		}
+	}
+	return s, nil
+}
+
+// Parses the labels following the opening brace, returning the rest of the
+// line after the closing brace.
+func parseLabels(line string) (labels []label, rest string, err error) {
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(line, "}") {
+			return labels, line[1:], nil
+		}
+		i := 0
+		for i < len(line) && isNameChar(line[i], i == 0) && line[i] != ':' {
+			i++
+		}
+		if i == 0 {
+			return nil, "", fmt.Errorf("invalid label name: %s", line)
+		}
+		name := line[:i]
+		line = strings.TrimLeft(line[i:], " \t")
+		if !strings.HasPrefix(line, "=") {
+			return nil, "", fmt.Errorf("expected '=' after label '%s'", name)
+		}
+		line = strings.TrimLeft(line[1:], " \t")
+		if !strings.HasPrefix(line, `"`) {
+			return nil, "", fmt.Errorf("expected quoted value for label '%s'", name)
+		}
+		var value []byte
+		closed := false
+		i = 1
+		for ; i < len(line); i++ {
+			c := line[i]
+			if c == '"' {
+				closed = true
+				break
+			}
+			if c == '\\' && i+1 < len(line) {
+				i++
+				switch line[i] {
+				case 'n':
+					value = append(value, '\n')
+				case '\\', '"':
+					value = append(value, line[i])
+				default:
+					value = append(value, '\\', line[i])
+				}
+				continue
+			}
+			value = append(value, c)
+		}
+		if !closed {
+			return nil, "", fmt.Errorf("unterminated value for label '%s'", name)
+		}
+		labels = append(labels, label{name: name, value: string(value)})
+		line = strings.TrimLeft(line[i+1:], " \t")
+		if strings.HasPrefix(line, ",") {
+			line = line[1:]
+		} else if !strings.HasPrefix(line, "}") {
+			return nil, "", fmt.Errorf("expected ',' or '}' after label '%s'", name)
+		}
+	}
+}
+
+// Metric names match [a-zA-Z_:][a-zA-Z0-9_:]*, label names are the same
+// without the colons.
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Counter state for a single key.
+type rateCounter struct {
+	last     float64 // Most recent counter value.
+	hasLast  bool
+	delta    float64 // Counter increase during the current interval.
+	seen     bool    // Whether there was any data during the current interval.
+	idleTime uint    // Number of consecutive intervals with no data.
+}
+
+// A single computed rate, ready to be emitted.
+type rateSample struct {
+	key   string
+	delta float64
+	rate  float64
+}
+
+// Filter that computes per second rates from monotonic counter fields,
+// emitting one message per key every ticker interval. Intervals without any
+// data emit a zero rate so the resulting series has no gaps.
+type RateFilter struct {
+	conf     *RateFilterConfig
+	counters map[string]*rateCounter
+}
+
+// RateFilter config struct.
+type RateFilterConfig struct {
+	// Name of the message field holding the counter value. Required.
+	CounterField string `toml:"counter_field"`
+	// Message fields whose values are joined together to identify each
+	// counter. Supports "Type", "Logger", "Hostname", and any dynamic field
+	// name. Defaults to a single counter for all messages.
+	KeyFields []string `toml:"key_fields"`
+	// Number of consecutive empty intervals after which a key is forgotten
+	// and no longer zero filled. Defaults to 10, 0 means keys never expire.
+	ExpireIntervals uint `toml:"expire_intervals"`
+	// Type to use for the emitted rate messages. Defaults to "heka.rate".
+	MessageType string `toml:"message_type"`
+	// Interval over which each rate is calculated, in seconds. Defaults to
+	// 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+func (this *RateFilter) ConfigStruct() interface{} {
+	return &RateFilterConfig{
+		ExpireIntervals: 10,
+		MessageType:     "heka.rate",
+		TickerInterval:  uint(60),
+	}
+}
+
+func (this *RateFilter) Init(config interface{}) (err error) {
+	this.conf = config.(*RateFilterConfig)
+	if this.conf.CounterField == "" {
+		return errors.New("`counter_field` must be specified")
+	}
+	if this.conf.TickerInterval == 0 {
+		return errors.New("`ticker_interval` must be greater than zero")
+	}
+	this.counters = make(map[string]*rateCounter)
+	return
+}
+
+func (this *RateFilter) Run(fr FilterRunner, h PluginHelper) (err error) {
+	inChan := fr.InChan()
+	ticker := fr.Ticker()
+
+	var (
+		ok           = true
+		pack         *PipelinePack
+		msgLoopCount uint
+	)
+	for ok {
+		select {
+		case pack, ok = <-inChan:
+			if !ok {
+				break
+			}
+			msgLoopCount = pack.MsgLoopCount
+			if e := this.addMessage(pack.Message); e != nil {
+				fr.LogError(e)
+			}
+			fr.UpdateCursor(pack.QueueCursor)
+			pack.Recycle(nil)
+		case <-ticker:
+			for _, sample := range this.tick() {
+				this.emit(fr, h, sample, msgLoopCount)
+			}
+		}
+	}
+	return
+}
+
+func (this *RateFilter) CleanupForRestart() {
+	this.counters = make(map[string]*rateCounter)
+}
+
+func (this *RateFilter) addMessage(msg *message.Message) error {
+	val, ok := msg.GetFieldValue(this.conf.CounterField)
+	if !ok {
+		return nil
+	}
+	var value float64
+	switch v := val.(type) {
+	case int64:
+		value = float64(v)
+	case float64:
+		value = v
+	default:
+		return fmt.Errorf("counter field '%s' isn't numeric", this.conf.CounterField)
+	}
+
+	key := messageKey(msg, this.conf.KeyFields)
+	counter, ok := this.counters[key]
+	if !ok {
+		counter = new(rateCounter)
+		this.counters[key] = counter
+	}
+	if counter.hasLast {
+		if value >= counter.last {
+			counter.delta += value - counter.last
+		} else {
+			// The counter was reset, so everything counted since the reset
+			// is the increase.
+			counter.delta += value
+		}
+	}
+	counter.last = value
+	counter.hasLast = true
+	counter.seen = true
+	return nil
+}
+
+// Closes out the current interval, returning a sample for every known key
+// sorted by key.
+func (this *RateFilter) tick() (samples []rateSample) {
+	seconds := float64(this.conf.TickerInterval)
+	keys := make([]string, 0, len(this.counters))
+	for key, counter := range this.counters {
+		if counter.seen {
+			counter.idleTime = 0
+		} else {
+			counter.idleTime++
+			if this.conf.ExpireIntervals > 0 &&
+				counter.idleTime > this.conf.ExpireIntervals {
+				delete(this.counters, key)
+				continue
+			}
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		counter := this.counters[key]
+		samples = append(samples, rateSample{
+			key:   key,
+			delta: counter.delta,
+			rate:  counter.delta / seconds,
+		})
+		counter.delta = 0
+		counter.seen = false
+	}
+	return
+}
+
+func (this *RateFilter) emit(fr FilterRunner, h PluginHelper, sample rateSample,
+	msgLoopCount uint) {
+
+	pack, e := h.PipelinePack(msgLoopCount)
+	if e != nil {
+		fr.LogError(e)
+		return
+	}
+	pack.Message.SetLogger(fr.Name())
+	pack.Message.SetType(this.conf.MessageType)
+	pack.Message.SetPayload(fmt.Sprintf("%s %0.2f/sec", sample.key, sample.rate))
+	message.NewStringField(pack.Message, "key", sample.key)
+	if f, e := message.NewField("rate", sample.rate, "count/s"); e == nil {
+		pack.Message.AddField(f)
+	}
+	if f, e := message.NewField("delta", sample.delta, "count"); e == nil {
+		pack.Message.AddField(f)
+	}
+	message.NewIntField(pack.Message, "interval", int(this.conf.TickerInterval), "s")
+	fr.Inject(pack)
+}
+
+func init() {
+	RegisterPlugin("RateFilter", func() interface{} {
+		return new(RateFilter)
+	})
+}
@@ -0,0 +1,132 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	pm "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func RateFilterSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newMsg := func(host string, count int64) *message.Message {
+		msg := pipeline_ts.GetTestMessage()
+		msg.SetHostname(host)
+		message.NewInt64Field(msg, "requests", count, "count")
+		return msg
+	}
+
+	c.Specify("A RateFilter", func() {
+		filter := new(RateFilter)
+		config := filter.ConfigStruct().(*RateFilterConfig)
+		config.CounterField = "requests"
+		config.KeyFields = []string{"Hostname"}
+		config.TickerInterval = 10
+
+		c.Specify("requires a counter field", func() {
+			config.CounterField = ""
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("computes per key rates", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", 100))
+			filter.addMessage(newMsg("a", 150))
+			filter.addMessage(newMsg("b", 10))
+			filter.addMessage(newMsg("a", 200))
+			filter.addMessage(newMsg("b", 30))
+
+			samples := filter.tick()
+			c.Expect(len(samples), gs.Equals, 2)
+			c.Expect(samples[0].key, gs.Equals, "a")
+			c.Expect(samples[0].delta, gs.Equals, float64(100))
+			c.Expect(samples[0].rate, gs.Equals, float64(10))
+			c.Expect(samples[1].key, gs.Equals, "b")
+			c.Expect(samples[1].rate, gs.Equals, float64(2))
+		})
+
+		c.Specify("treats a decrease as a counter reset", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", 100))
+			filter.addMessage(newMsg("a", 120))
+			filter.addMessage(newMsg("a", 5))
+
+			samples := filter.tick()
+			c.Expect(len(samples), gs.Equals, 1)
+			c.Expect(samples[0].delta, gs.Equals, float64(25))
+		})
+
+		c.Specify("zero fills empty intervals", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", 100))
+			filter.addMessage(newMsg("a", 200))
+			filter.tick()
+
+			samples := filter.tick()
+			c.Expect(len(samples), gs.Equals, 1)
+			c.Expect(samples[0].rate, gs.Equals, float64(0))
+
+			// The rate continues from the last value seen.
+			filter.addMessage(newMsg("a", 250))
+			samples = filter.tick()
+			c.Expect(samples[0].delta, gs.Equals, float64(50))
+		})
+
+		c.Specify("expires idle keys", func() {
+			config.ExpireIntervals = 2
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", 100))
+			c.Expect(len(filter.tick()), gs.Equals, 1)
+			c.Expect(len(filter.tick()), gs.Equals, 1)
+			c.Expect(len(filter.tick()), gs.Equals, 1)
+			c.Expect(len(filter.tick()), gs.Equals, 0)
+		})
+
+		c.Specify("emits rate messages", func() {
+			fr := pm.NewMockFilterRunner(ctrl)
+			h := pm.NewMockPluginHelper(ctrl)
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			supply := make(chan *PipelinePack, 1)
+			pack := NewPipelinePack(supply)
+			h.EXPECT().PipelinePack(uint(0)).Return(pack, nil)
+			fr.EXPECT().Name().Return("rates")
+			fr.EXPECT().Inject(pack).Return(true)
+			filter.emit(fr, h, rateSample{key: "a", delta: 50, rate: 5}, 0)
+
+			msg := pack.Message
+			c.Expect(msg.GetType(), gs.Equals, "heka.rate")
+			val, _ := msg.GetFieldValue("key")
+			c.Expect(val.(string), gs.Equals, "a")
+			val, _ = msg.GetFieldValue("rate")
+			c.Expect(val.(float64), gs.Equals, float64(5))
+			val, _ = msg.GetFieldValue("interval")
+			c.Expect(val.(int64), gs.Equals, int64(10))
+		})
+	})
+}
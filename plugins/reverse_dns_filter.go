@@ -0,0 +1,216 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"container/list"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/pborman/uuid"
+)
+
+type cachedHostname struct {
+	ip       string
+	hostname string // Empty if the lookup failed.
+	expires  time.Time
+	elem     *list.Element
+}
+
+// Filter that resolves an IP address field to a hostname with a reverse DNS
+// lookup and injects a copy of the message with the hostname added as a
+// field. Results are cached, and lookups that fail or take too long are
+// skipped so a slow resolver can't stall the pipeline.
+type ReverseDnsFilter struct {
+	conf        *ReverseDnsFilterConfig
+	timeout     time.Duration
+	ttl         time.Duration
+	negativeTtl time.Duration
+	cache       map[string]*cachedHostname
+	// Cached results ordered from least to most recently used.
+	lru *list.List
+	// Overridden by the tests.
+	lookupAddr func(addr string) ([]string, error)
+}
+
+// ReverseDnsFilter config struct.
+type ReverseDnsFilterConfig struct {
+	// Name of the message field holding the IP address. Required.
+	IpField string `toml:"ip_field"`
+	// Name of the field the hostname is stored in. Defaults to
+	// "hostname_resolved".
+	HostnameField string `toml:"hostname_field"`
+	// Prepended to the original message Type to give the Type of the enriched
+	// copies. Defaults to "dns.".
+	TypePrefix string `toml:"type_prefix"`
+	// Number of milliseconds to wait for a lookup before giving up on it.
+	// Defaults to 500.
+	Timeout uint `toml:"timeout"`
+	// Maximum number of IP addresses to cache results for. Defaults to 10000.
+	CacheSize int `toml:"cache_size"`
+	// Number of seconds a successful lookup is cached for. Defaults to 3600.
+	CacheTtl uint `toml:"cache_ttl"`
+	// Number of seconds a failed lookup is cached for, so addresses without a
+	// PTR record aren't looked up for every message. Defaults to 300.
+	NegativeCacheTtl uint `toml:"negative_cache_ttl"`
+}
+
+func (this *ReverseDnsFilter) ConfigStruct() interface{} {
+	return &ReverseDnsFilterConfig{
+		HostnameField:    "hostname_resolved",
+		TypePrefix:       "dns.",
+		Timeout:          500,
+		CacheSize:        10000,
+		CacheTtl:         3600,
+		NegativeCacheTtl: 300,
+	}
+}
+
+func (this *ReverseDnsFilter) Init(config interface{}) (err error) {
+	this.conf = config.(*ReverseDnsFilterConfig)
+	if this.conf.IpField == "" {
+		return errors.New("`ip_field` must be specified")
+	}
+	if this.conf.HostnameField == "" {
+		return errors.New("`hostname_field` must be specified")
+	}
+	if this.conf.TypePrefix == "" {
+		return errors.New("`type_prefix` must be specified so the enriched " +
+			"messages can be told apart from the originals")
+	}
+	if this.conf.Timeout == 0 {
+		return errors.New("`timeout` must be greater than zero")
+	}
+	if this.conf.CacheSize < 1 {
+		return errors.New("`cache_size` must be greater than zero")
+	}
+	this.timeout = time.Duration(this.conf.Timeout) * time.Millisecond
+	this.ttl = time.Duration(this.conf.CacheTtl) * time.Second
+	this.negativeTtl = time.Duration(this.conf.NegativeCacheTtl) * time.Second
+	this.cache = make(map[string]*cachedHostname)
+	this.lru = list.New()
+	if this.lookupAddr == nil {
+		this.lookupAddr = net.LookupAddr
+	}
+	return
+}
+
+func (this *ReverseDnsFilter) Run(fr FilterRunner, h PluginHelper) (err error) {
+	for pack := range fr.InChan() {
+		if ip := this.ip(pack.Message); ip != "" {
+			newPack, e := h.PipelinePack(pack.MsgLoopCount)
+			if e != nil {
+				fr.LogError(e)
+			} else {
+				pack.Message.Copy(newPack.Message)
+				this.enrich(newPack.Message, this.resolve(ip, time.Now()))
+				fr.Inject(newPack)
+			}
+		}
+		fr.UpdateCursor(pack.QueueCursor)
+		pack.Recycle(nil)
+	}
+	return
+}
+
+func (this *ReverseDnsFilter) CleanupForRestart() {
+	this.cache = make(map[string]*cachedHostname)
+	this.lru.Init()
+}
+
+// Returns the message's IP address, or "" if it doesn't have one.
+func (this *ReverseDnsFilter) ip(msg *message.Message) string {
+	val, ok := msg.GetFieldValue(this.conf.IpField)
+	if !ok {
+		return ""
+	}
+	ip, _ := val.(string)
+	return ip
+}
+
+// Turns a copy of the original message into the enriched message by giving
+// it a new UUID and Type and adding the hostname, if there is one.
+func (this *ReverseDnsFilter) enrich(msg *message.Message, hostname string) {
+	msg.SetUuid(uuid.NewRandom())
+	msg.SetType(this.conf.TypePrefix + msg.GetType())
+	if hostname != "" {
+		message.NewStringField(msg, this.conf.HostnameField, hostname)
+	}
+}
+
+// Returns the hostname for the IP address, using the cached result if there
+// is one that hasn't expired. Returns "" if the lookup fails.
+func (this *ReverseDnsFilter) resolve(ip string, now time.Time) string {
+	if c, ok := this.cache[ip]; ok {
+		if now.Before(c.expires) {
+			this.lru.MoveToBack(c.elem)
+			return c.hostname
+		}
+		this.remove(c)
+	}
+	hostname := this.lookup(ip)
+	ttl := this.ttl
+	if hostname == "" {
+		ttl = this.negativeTtl
+	}
+	if ttl == 0 {
+		return hostname
+	}
+	if len(this.cache) >= this.conf.CacheSize {
+		this.remove(this.lru.Front().Value.(*cachedHostname))
+	}
+	c := &cachedHostname{ip: ip, hostname: hostname, expires: now.Add(ttl)}
+	c.elem = this.lru.PushBack(c)
+	this.cache[ip] = c
+	return hostname
+}
+
+func (this *ReverseDnsFilter) remove(c *cachedHostname) {
+	this.lru.Remove(c.elem)
+	delete(this.cache, c.ip)
+}
+
+// Does the reverse lookup, giving up after the timeout. A lookup that times
+// out finishes in the background and its result is discarded.
+func (this *ReverseDnsFilter) lookup(ip string) string {
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	result := make(chan string, 1)
+	go func() {
+		names, err := this.lookupAddr(ip)
+		if err != nil || len(names) == 0 {
+			result <- ""
+			return
+		}
+		result <- strings.TrimSuffix(names[0], ".")
+	}()
+	select {
+	case hostname := <-result:
+		return hostname
+	case <-time.After(this.timeout):
+		return ""
+	}
+}
+
+func init() {
+	RegisterPlugin("ReverseDnsFilter", func() interface{} {
+		return new(ReverseDnsFilter)
+	})
+}
@@ -0,0 +1,118 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func ReverseDnsFilterSpec(c gs.Context) {
+	c.Specify("A ReverseDnsFilter", func() {
+		filter := new(ReverseDnsFilter)
+		config := filter.ConfigStruct().(*ReverseDnsFilterConfig)
+		config.IpField = "remote_addr"
+		lookups := 0
+		filter.lookupAddr = func(addr string) ([]string, error) {
+			lookups++
+			switch addr {
+			case "10.0.0.1":
+				return []string{"web-1.example.com."}, nil
+			case "10.0.0.2":
+				time.Sleep(100 * time.Millisecond)
+				return []string{"slow.example.com."}, nil
+			}
+			return nil, errors.New("no such host")
+		}
+		msg := pipeline_ts.GetTestMessage()
+		message.NewStringField(msg, "remote_addr", "10.0.0.1")
+		now := time.Now()
+
+		c.Specify("requires an IP field", func() {
+			config.IpField = ""
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("adds the resolved hostname to the copy", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			ip := filter.ip(msg)
+			c.Expect(ip, gs.Equals, "10.0.0.1")
+			enriched := message.CopyMessage(msg)
+			filter.enrich(enriched, filter.resolve(ip, now))
+
+			c.Expect(enriched.GetType(), gs.Equals, "dns.TEST")
+			c.Expect(enriched.GetUuidString(), gs.Not(gs.Equals),
+				msg.GetUuidString())
+			hostname, _ := enriched.GetFieldValue("hostname_resolved")
+			c.Expect(hostname, gs.Equals, "web-1.example.com")
+		})
+
+		c.Specify("skips failed lookups", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(filter.resolve("10.0.0.9", now), gs.Equals, "")
+			c.Expect(filter.resolve("not an ip", now), gs.Equals, "")
+			enriched := message.CopyMessage(msg)
+			filter.enrich(enriched, "")
+			_, ok := enriched.GetFieldValue("hostname_resolved")
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("gives up on slow lookups", func() {
+			config.Timeout = 10
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(filter.resolve("10.0.0.2", now), gs.Equals, "")
+		})
+
+		c.Specify("caches results until they expire", func() {
+			config.CacheTtl = 60
+			config.NegativeCacheTtl = 10
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.resolve("10.0.0.1", now)
+			filter.resolve("10.0.0.9", now)
+			filter.resolve("10.0.0.1", now.Add(30*time.Second))
+			filter.resolve("10.0.0.9", now.Add(5*time.Second))
+			c.Expect(lookups, gs.Equals, 2)
+
+			filter.resolve("10.0.0.9", now.Add(30*time.Second))
+			c.Expect(lookups, gs.Equals, 3)
+			c.Expect(filter.resolve("10.0.0.1", now.Add(time.Minute)), gs.Equals,
+				"web-1.example.com")
+			c.Expect(lookups, gs.Equals, 4)
+		})
+
+		c.Specify("caches a bounded number of results", func() {
+			config.CacheSize = 2
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.resolve("10.0.0.1", now)
+			filter.resolve("10.0.0.3", now)
+			filter.resolve("10.0.0.1", now)
+			filter.resolve("10.0.0.4", now)
+			c.Expect(len(filter.cache), gs.Equals, 2)
+			_, ok := filter.cache["10.0.0.3"]
+			c.Expect(ok, gs.IsFalse)
+			c.Expect(filter.lru.Len(), gs.Equals, 2)
+		})
+	})
+}
@@ -0,0 +1,216 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+// Rfc5424Encoder serializes each message as an RFC 5424 syslog message, with
+// the selected dynamic fields as the parameters of a structured data
+// element.
+type Rfc5424Encoder struct {
+	config *Rfc5424EncoderConfig
+}
+
+type Rfc5424EncoderConfig struct {
+	// Facility used for messages without a valid `facility_field`. Defaults
+	// to 1 (user-level messages).
+	Facility int32
+	// Name of the integer field holding the message's facility. Defaults to
+	// "syslog_facility".
+	FacilityField string `toml:"facility_field"`
+	// SD-ID of the structured data element holding the fields. Defaults to
+	// "fields@32473".
+	SdId string `toml:"sd_id"`
+	// Names of the fields to add as SD-PARAMs. Defaults to none, i.e. no
+	// structured data.
+	Fields []string
+	// Whether to prefix each message with its length as described by RFC
+	// 6587's octet counting, instead of terminating it with a newline.
+	OctetCounting bool `toml:"octet_counting"`
+}
+
+// Maximum lengths of the header fields.
+const (
+	rfc5424MaxHostname = 255
+	rfc5424MaxAppName  = 48
+	rfc5424MaxProcId   = 128
+	rfc5424MaxMsgId    = 32
+	rfc5424MaxSdName   = 32
+)
+
+func (re *Rfc5424Encoder) ConfigStruct() interface{} {
+	return &Rfc5424EncoderConfig{
+		Facility:      message.DEFAULT_SYSLOG_FACILITY,
+		FacilityField: message.SYSLOG_FACILITY_FIELD,
+		SdId:          "fields@32473",
+	}
+}
+
+func (re *Rfc5424Encoder) Init(config interface{}) (err error) {
+	re.config = config.(*Rfc5424EncoderConfig)
+	if re.config.Facility < 0 || re.config.Facility > message.MAX_SYSLOG_FACILITY {
+		return fmt.Errorf("`facility` must be between 0 and 23, got %d",
+			re.config.Facility)
+	}
+	if len(re.config.Fields) > 0 {
+		if !validSdName(re.config.SdId) {
+			return fmt.Errorf("invalid `sd_id`: %q", re.config.SdId)
+		}
+		for _, name := range re.config.Fields {
+			if !validSdName(name) {
+				return fmt.Errorf("field name %q isn't a valid SD-PARAM name", name)
+			}
+		}
+	}
+	return
+}
+
+// Reports whether the string is a valid SD-ID or PARAM-NAME, i.e. 1 to 32
+// printable US-ASCII characters other than '=', ']', and '"'.
+func validSdName(name string) bool {
+	if name == "" || len(name) > rfc5424MaxSdName {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if ch < 33 || ch > 126 || ch == '=' || ch == ']' || ch == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// Writes a header field, replacing characters that aren't printable US-ASCII
+// with '_' and truncating it to the maximum length. Empty values are written
+// as the NILVALUE.
+func writeRfc5424Header(buf *bytes.Buffer, value string, maxLen int) {
+	if value == "" {
+		buf.WriteByte('-')
+		return
+	}
+	if len(value) > maxLen {
+		value = value[:maxLen]
+	}
+	for i := 0; i < len(value); i++ {
+		if ch := value[i]; ch < 33 || ch > 126 {
+			buf.WriteByte('_')
+		} else {
+			buf.WriteByte(ch)
+		}
+	}
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// Returns the values of all of the message's fields with the given name as
+// strings.
+func rfc5424FieldValues(msg *message.Message, name string) (values []string) {
+	for _, field := range msg.FindAllFields(name) {
+		switch field.GetValueType() {
+		case message.Field_STRING:
+			values = append(values, field.GetValueString()...)
+		case message.Field_BYTES:
+			for _, v := range field.GetValueBytes() {
+				values = append(values, string(v))
+			}
+		case message.Field_INTEGER:
+			for _, v := range field.GetValueInteger() {
+				values = append(values, strconv.FormatInt(v, 10))
+			}
+		case message.Field_DOUBLE:
+			for _, v := range field.GetValueDouble() {
+				values = append(values, strconv.FormatFloat(v, 'g', -1, 64))
+			}
+		case message.Field_BOOL:
+			for _, v := range field.GetValueBool() {
+				values = append(values, strconv.FormatBool(v))
+			}
+		}
+	}
+	return
+}
+
+func (re *Rfc5424Encoder) Encode(pack *pipeline.PipelinePack) (output []byte, err error) {
+	msg := pack.Message
+	facility, ok := msg.SyslogFacility(re.config.FacilityField)
+	if !ok {
+		facility = re.config.Facility
+	}
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "<%d>1 ", message.SyslogPri(facility, msg.GetSeverity()))
+	// RFC 5424 allows at most microsecond precision.
+	buf.WriteString(time.Unix(0, msg.GetTimestamp()).UTC().Format(
+		"2006-01-02T15:04:05.000000Z07:00"))
+	buf.WriteByte(' ')
+	writeRfc5424Header(buf, msg.GetHostname(), rfc5424MaxHostname)
+	buf.WriteByte(' ')
+	writeRfc5424Header(buf, msg.GetLogger(), rfc5424MaxAppName)
+	buf.WriteByte(' ')
+	var procId string
+	if pid := msg.GetPid(); pid != 0 {
+		procId = strconv.Itoa(int(pid))
+	}
+	writeRfc5424Header(buf, procId, rfc5424MaxProcId)
+	buf.WriteByte(' ')
+	writeRfc5424Header(buf, msg.GetType(), rfc5424MaxMsgId)
+	buf.WriteByte(' ')
+
+	sdStart := buf.Len()
+	for _, name := range re.config.Fields {
+		for _, value := range rfc5424FieldValues(msg, name) {
+			if buf.Len() == sdStart {
+				buf.WriteByte('[')
+				buf.WriteString(re.config.SdId)
+			}
+			fmt.Fprintf(buf, ` %s="%s"`, name, sdValueEscaper.Replace(value))
+		}
+	}
+	if buf.Len() == sdStart {
+		buf.WriteByte('-')
+	} else {
+		buf.WriteByte(']')
+	}
+
+	if payload := msg.GetPayload(); payload != "" {
+		buf.WriteByte(' ')
+		if re.config.OctetCounting {
+			buf.WriteString(payload)
+		} else {
+			// Keep the message on a single line.
+			buf.WriteString(strings.Replace(payload, "\n", " ", -1))
+		}
+	}
+
+	if re.config.OctetCounting {
+		output = make([]byte, 0, buf.Len()+8)
+		output = strconv.AppendInt(output, int64(buf.Len()), 10)
+		output = append(output, ' ')
+		return append(output, buf.Bytes()...), nil
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func init() {
+	pipeline.RegisterPlugin("Rfc5424Encoder", func() interface{} {
+		return new(Rfc5424Encoder)
+	})
+}
@@ -0,0 +1,104 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func Rfc5424EncoderSpec(c gs.Context) {
+
+	c.Specify("An Rfc5424Encoder", func() {
+		encoder := new(Rfc5424Encoder)
+		config := encoder.ConfigStruct().(*Rfc5424EncoderConfig)
+		supply := make(chan *pipeline.PipelinePack, 1)
+
+		pack := pipeline.NewPipelinePack(supply)
+		pack.Message.SetPayload("multi\nline payload")
+		timestamp := time.Date(2016, 3, 1, 12, 30, 0, 123456789, time.UTC)
+		pack.Message.SetTimestamp(timestamp.UnixNano())
+		pack.Message.SetType("nginx.access")
+		pack.Message.SetHostname("somehost.example.com")
+		pack.Message.SetLogger("nginx")
+		pack.Message.SetPid(4242)
+		pack.Message.SetSeverity(4)
+		message.NewStringField(pack.Message, "user", `bob "the" [admin]`)
+		message.NewInt64Field(pack.Message, "status", 404, "")
+
+		c.Specify("writes one line per message", func() {
+			config.Fields = []string{"user", "status", "missing"}
+			err := encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(string(output), gs.Equals,
+				`<12>1 2016-03-01T12:30:00.123456Z somehost.example.com nginx 4242 `+
+					`nginx.access [fields@32473 user="bob \"the\" [admin\]" status="404"] `+
+					"multi line payload\n")
+		})
+
+		c.Specify("uses NILVALUEs for missing headers and structured data", func() {
+			pack.Message.SetLogger("")
+			pack.Message.SetPid(0)
+			pack.Message.SetPayload("")
+			err := encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(string(output), gs.Equals,
+				"<12>1 2016-03-01T12:30:00.123456Z somehost.example.com - - nginx.access -\n")
+		})
+
+		c.Specify("takes the facility from the facility field", func() {
+			pack.Message.SetSyslogFacility(16)
+			err := encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(string(output[:5]), gs.Equals, "<132>")
+		})
+
+		c.Specify("reads the facility from a custom field", func() {
+			message.NewInt64Field(pack.Message, "syslogfacility", 4, "")
+			config.FacilityField = "syslogfacility"
+			err := encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(string(output[:4]), gs.Equals, "<36>")
+		})
+
+		c.Specify("prefixes the message length when octet counting", func() {
+			config.OctetCounting = true
+			err := encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := "<12>1 2016-03-01T12:30:00.123456Z somehost.example.com nginx 4242 " +
+				"nginx.access - multi\nline payload"
+			c.Expect(string(output), gs.Equals, "99 "+msg)
+			c.Expect(len(msg), gs.Equals, 99)
+		})
+
+		c.Specify("rejects invalid settings", func() {
+			config.Facility = 24
+			c.Expect(encoder.Init(config), gs.Not(gs.IsNil))
+			config.Facility = 1
+			config.Fields = []string{"bad=name"}
+			c.Expect(encoder.Init(config), gs.Not(gs.IsNil))
+		})
+	})
+}
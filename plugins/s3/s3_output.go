@@ -0,0 +1,273 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdRoll/goamz/aws"
+	s3api "github.com/AdRoll/goamz/s3"
+	"github.com/mozilla-services/heka/compression"
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/mozilla-services/heka/plugins"
+)
+
+// The part of the S3 API used by S3Output, so tests can stand in for S3.
+type s3Putter interface {
+	Put(path string, data []byte, contType string, perm s3api.ACL,
+		options s3api.Options) error
+}
+
+// Output plugin that collects encoded messages and uploads them to S3, one
+// object per batch, for archival.
+type S3Output struct {
+	uploadCount   int64
+	uploadedBytes int64
+	conf          *S3OutputConfig
+	or            OutputRunner
+	bucket        s3Putter
+	template      *plugins.MessageTemplate
+	compressor    *compression.Compressor
+	flushInterval time.Duration
+	// Encoded records of the current batch.
+	buffer []byte
+	// Key of the object the current batch is uploaded as, resolved from the
+	// batch's first message.
+	key        string
+	batchStart time.Time
+	// Cursor of the most recently batched message, committed once the batch
+	// has been uploaded.
+	queueCursor string
+}
+
+// ConfigStruct for S3Output plugin.
+type S3OutputConfig struct {
+	// Name of the bucket the objects are uploaded to.
+	Bucket string
+	// AWS region of the bucket. Defaults to "us-east-1".
+	Region string
+	// AWS credentials. If they aren't set they're taken from the
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables, the
+	// AWS credentials file, or the EC2 instance's IAM role.
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	// Template of the object keys. `%{...}` references to message headers
+	// or fields are replaced with the values of the batch's first message,
+	// and those starting with `%`, e.g. `%{%Y/%m/%d}`, with its timestamp
+	// in strftime format. Defaults to "%{%Y/%m/%d}/%{%H%M%S}-%{UUID}".
+	KeyTemplate string `toml:"key_template"`
+	// Value used in place of any field referenced in `key_template` that the
+	// message doesn't have. Defaults to "unknown".
+	MissingValue string `toml:"missing_value"`
+	// Content type of the uploaded objects. Defaults to
+	// "application/octet-stream".
+	ContentType string `toml:"content_type"`
+	// Compression applied to each uploaded object, "gzip", "zstd" or "none"
+	// (default "none"). The object keys get the algorithm's file extension.
+	Compression string
+	// Compression level, 0 for the algorithm's default level.
+	CompressionLevel int `toml:"compression_level"`
+	// Number of bytes of encoded messages to collect before uploading them.
+	// Defaults to 10MiB.
+	BufferSize uint64 `toml:"buffer_size"`
+	// Maximum number of seconds a batch is collected before it's uploaded,
+	// even if it's smaller than `buffer_size`. Defaults to 300.
+	FlushInterval uint `toml:"flush_interval"`
+	// Interval at which the age of the batch is checked, in seconds.
+	// Defaults to 1.
+	TickerInterval uint `toml:"ticker_interval"`
+	// Allows for a default encoder.
+	Encoder string
+	// Specifies whether or not Heka's stream framing will be applied to the
+	// output. Defaults to true if ProtobufEncoder is used, false otherwise.
+	UseFraming *bool `toml:"use_framing"`
+	// Defaults to true for S3Output.
+	UseBuffering *bool `toml:"use_buffering"`
+	Buffering    QueueBufferConfig
+}
+
+func (o *S3Output) ConfigStruct() interface{} {
+	b := true
+	queueConfig := QueueBufferConfig{
+		CursorUpdateCount: 1,
+		MaxBufferSize:     0,
+		MaxFileSize:       128 * 1024 * 1024,
+		FullAction:        "shutdown",
+	}
+	return &S3OutputConfig{
+		Region:         "us-east-1",
+		KeyTemplate:    "%{%Y/%m/%d}/%{%H%M%S}-%{UUID}",
+		MissingValue:   "unknown",
+		ContentType:    "application/octet-stream",
+		BufferSize:     10 * 1024 * 1024,
+		FlushInterval:  300,
+		TickerInterval: 1,
+		Encoder:        "ProtobufEncoder",
+		UseBuffering:   &b,
+		Buffering:      queueConfig,
+	}
+}
+
+func (o *S3Output) Init(config interface{}) (err error) {
+	o.conf = config.(*S3OutputConfig)
+	if o.conf.Bucket == "" {
+		return errors.New("`bucket` must be specified")
+	}
+	if o.conf.BufferSize == 0 {
+		return errors.New("`buffer_size` must be greater than zero")
+	}
+	if o.conf.FlushInterval == 0 {
+		return errors.New("`flush_interval` must be greater than zero")
+	}
+	o.template, err = plugins.NewMessageTemplate(o.conf.KeyTemplate, o.conf.MissingValue, nil)
+	if err != nil {
+		return fmt.Errorf("invalid `key_template`: %s", err)
+	}
+	o.compressor, err = compression.NewCompressor(o.conf.Compression, o.conf.CompressionLevel)
+	if err != nil {
+		return fmt.Errorf("invalid `compression`: %s", err)
+	}
+	o.flushInterval = time.Duration(o.conf.FlushInterval) * time.Second
+
+	if o.bucket == nil { // Tests might have set this already.
+		region, ok := aws.Regions[o.conf.Region]
+		if !ok {
+			return fmt.Errorf("unknown S3 region: %s", o.conf.Region)
+		}
+		auth, err := aws.GetAuth(o.conf.AccessKey, o.conf.SecretKey, "", time.Time{})
+		if err != nil {
+			return fmt.Errorf("can't get AWS credentials: %s", err)
+		}
+		o.bucket = s3api.New(auth, region).Bucket(o.conf.Bucket)
+	}
+	return
+}
+
+func (o *S3Output) Prepare(or OutputRunner, h PluginHelper) (err error) {
+	if o.conf.UseFraming == nil {
+		// Nothing was specified, we'll default to framing IFF ProtobufEncoder
+		// is being used.
+		if _, ok := or.Encoder().(*ProtobufEncoder); ok {
+			or.SetUseFraming(true)
+		}
+	}
+	o.or = or
+	return
+}
+
+func (o *S3Output) ProcessMessage(pack *PipelinePack) (err error) {
+	record, err := o.or.Encode(pack)
+	if err != nil {
+		return fmt.Errorf("can't encode: %s", err)
+	}
+	if record == nil {
+		if len(o.buffer) == 0 {
+			o.or.UpdateCursor(pack.QueueCursor)
+		} else {
+			o.queueCursor = pack.QueueCursor
+		}
+		return nil
+	}
+
+	// Upload what we have first if this record would overflow the batch. If
+	// that fails the same message is retried, and nothing past the uploaded
+	// batch is acknowledged in the meantime.
+	if len(o.buffer) > 0 && uint64(len(o.buffer)+len(record)) > o.conf.BufferSize {
+		if err = o.upload(); err != nil {
+			return NewRetryMessageError("can't upload to S3: %s", err)
+		}
+	}
+
+	if len(o.buffer) == 0 {
+		o.startBatch(pack.Message)
+	}
+	o.buffer = append(o.buffer, record...)
+	o.queueCursor = pack.QueueCursor
+
+	if uint64(len(o.buffer)) >= o.conf.BufferSize {
+		if e := o.upload(); e != nil {
+			o.or.LogError(fmt.Errorf("can't upload to S3: %s", e))
+		}
+	}
+	return nil
+}
+
+func (o *S3Output) TimerEvent() (err error) {
+	if len(o.buffer) == 0 || time.Since(o.batchStart) < o.flushInterval {
+		return
+	}
+	if err = o.upload(); err != nil {
+		err = fmt.Errorf("can't upload to S3: %s", err)
+	}
+	return
+}
+
+// Messages are sent in batches by upload.
+func (o *S3Output) DefersDelivery() bool {
+	return true
+}
+
+// Makes a last attempt to upload the current batch. If it fails the records
+// are dropped here, but they're still in the disk buffer if it's in use.
+func (o *S3Output) CleanUp() {
+	if len(o.buffer) == 0 {
+		return
+	}
+	if err := o.upload(); err != nil {
+		o.or.LogError(fmt.Errorf("can't upload to S3: %s", err))
+		o.buffer = o.buffer[:0]
+	}
+}
+
+func (o *S3Output) startBatch(msg *message.Message) {
+	o.batchStart = time.Now()
+	o.key = o.template.Render(msg) + compression.Extension(o.conf.Compression)
+}
+
+// Uploads the current batch as a single object, advancing the buffer cursor
+// only once it's stored.
+func (o *S3Output) upload() error {
+	data, err := o.compressor.Compress(o.buffer)
+	if err != nil {
+		return fmt.Errorf("compressing %s: %s", o.key, err)
+	}
+	err = o.bucket.Put(o.key, data, o.conf.ContentType, s3api.Private,
+		s3api.Options{})
+	if err != nil {
+		return fmt.Errorf("storing %s: %s", o.key, err)
+	}
+	atomic.AddInt64(&o.uploadCount, 1)
+	atomic.AddInt64(&o.uploadedBytes, int64(len(data)))
+	o.buffer = o.buffer[:0]
+	o.or.UpdateCursor(o.queueCursor)
+	return nil
+}
+
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (o *S3Output) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "UploadCount", atomic.LoadInt64(&o.uploadCount),
+		"count")
+	message.NewInt64Field(msg, "UploadedBytes", atomic.LoadInt64(&o.uploadedBytes),
+		"B")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("S3Output", func() interface{} {
+		return new(S3Output)
+	})
+}
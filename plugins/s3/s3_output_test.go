@@ -0,0 +1,164 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package s3
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	s3api "github.com/AdRoll/goamz/s3"
+	"github.com/mozilla-services/heka/compression"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	plugins_ts "github.com/mozilla-services/heka/plugins/testsupport"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Stands in for an S3 bucket, keeping the uploaded objects in memory.
+type mockBucket struct {
+	objects map[string]string
+	keys    []string
+	err     error
+}
+
+func (b *mockBucket) Put(path string, data []byte, contType string, perm s3api.ACL,
+	options s3api.Options) error {
+
+	if b.err != nil {
+		return b.err
+	}
+	b.objects[path] = string(data)
+	b.keys = append(b.keys, path)
+	return nil
+}
+
+func S3OutputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("An S3Output", func() {
+		output := new(S3Output)
+		bucket := &mockBucket{objects: make(map[string]string)}
+		output.bucket = bucket
+		config := output.ConfigStruct().(*S3OutputConfig)
+		config.Bucket = "archive"
+		config.KeyTemplate = "%{Type}/%{UUID}"
+		config.BufferSize = 10
+
+		oth := plugins_ts.NewOutputTestHelper(ctrl)
+		encoder := new(ProtobufEncoder)
+		oth.MockOutputRunner.EXPECT().Encoder().Return(encoder)
+		oth.MockOutputRunner.EXPECT().SetUseFraming(true)
+
+		err := output.Init(config)
+		c.Assume(err, gs.IsNil)
+		err = output.Prepare(oth.MockOutputRunner, oth.MockHelper)
+		c.Assume(err, gs.IsNil)
+
+		newPack := func(record, cursor string) *PipelinePack {
+			pack := NewPipelinePack(nil)
+			pack.Message = pipeline_ts.GetTestMessage()
+			pack.QueueCursor = cursor
+			oth.MockOutputRunner.EXPECT().Encode(pack).Return([]byte(record), nil)
+			return pack
+		}
+
+		c.Specify("uploads a batch once buffer_size is reached", func() {
+			pack1 := newPack("12345", "cursor1")
+			pack2 := newPack("67890", "cursor2")
+			c.Expect(output.ProcessMessage(pack1), gs.IsNil)
+			c.Expect(len(bucket.keys), gs.Equals, 0)
+			oth.MockOutputRunner.EXPECT().UpdateCursor("cursor2")
+			c.Expect(output.ProcessMessage(pack2), gs.IsNil)
+			c.Assume(len(bucket.keys), gs.Equals, 1)
+			key := "TEST/" + pack1.Message.GetUuidString()
+			c.Expect(bucket.keys[0], gs.Equals, key)
+			c.Expect(bucket.objects[key], gs.Equals, "1234567890")
+		})
+
+		c.Specify("uploads before a record would overflow the batch", func() {
+			c.Expect(output.ProcessMessage(newPack("123456", "cursor1")), gs.IsNil)
+			oth.MockOutputRunner.EXPECT().UpdateCursor("cursor1")
+			c.Expect(output.ProcessMessage(newPack("78901", "cursor2")), gs.IsNil)
+			c.Assume(len(bucket.keys), gs.Equals, 1)
+			c.Expect(bucket.objects[bucket.keys[0]], gs.Equals, "123456")
+			c.Expect(string(output.buffer), gs.Equals, "78901")
+		})
+
+		c.Specify("retries the message if the upload fails", func() {
+			c.Expect(output.ProcessMessage(newPack("123456", "cursor1")), gs.IsNil)
+			bucket.err = errors.New("service unavailable")
+			pack := newPack("78901", "cursor2")
+			err := output.ProcessMessage(pack)
+			_, ok := err.(RetryMessageError)
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(string(output.buffer), gs.Equals, "123456")
+
+			bucket.err = nil
+			oth.MockOutputRunner.EXPECT().Encode(pack).Return([]byte("78901"), nil)
+			oth.MockOutputRunner.EXPECT().UpdateCursor("cursor1")
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+			c.Expect(string(output.buffer), gs.Equals, "78901")
+		})
+
+		c.Specify("uploads a partial batch after flush_interval", func() {
+			c.Expect(output.ProcessMessage(newPack("123", "cursor1")), gs.IsNil)
+			c.Expect(output.TimerEvent(), gs.IsNil)
+			c.Expect(len(bucket.keys), gs.Equals, 0)
+
+			output.batchStart = time.Now().Add(-output.flushInterval)
+			bucket.err = errors.New("service unavailable")
+			c.Expect(output.TimerEvent(), gs.Not(gs.IsNil))
+			bucket.err = nil
+			oth.MockOutputRunner.EXPECT().UpdateCursor("cursor1")
+			c.Expect(output.TimerEvent(), gs.IsNil)
+			c.Expect(len(bucket.keys), gs.Equals, 1)
+			c.Expect(len(output.buffer), gs.Equals, 0)
+		})
+
+		c.Specify("compresses the uploaded objects", func() {
+			config.Compression = compression.Zstd
+			config.CompressionLevel = 3
+			c.Assume(output.Init(config), gs.IsNil)
+			pack1 := newPack("12345", "cursor1")
+			c.Expect(output.ProcessMessage(pack1), gs.IsNil)
+			oth.MockOutputRunner.EXPECT().UpdateCursor("cursor2")
+			c.Expect(output.ProcessMessage(newPack("67890", "cursor2")), gs.IsNil)
+			c.Assume(len(bucket.keys), gs.Equals, 1)
+			key := "TEST/" + pack1.Message.GetUuidString() + ".zst"
+			c.Expect(bucket.keys[0], gs.Equals, key)
+
+			r, err := compression.NewReader(strings.NewReader(bucket.objects[key]),
+				compression.Zstd)
+			c.Assume(err, gs.IsNil)
+			defer r.Close()
+			data, err := ioutil.ReadAll(r)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(data), gs.Equals, "1234567890")
+		})
+
+		c.Specify("rejects an invalid compression level", func() {
+			config.Compression = compression.Gzip
+			config.CompressionLevel = 10
+			c.Expect(output.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("requires a bucket", func() {
+			config.Bucket = ""
+			c.Expect(output.Init(config), gs.Not(gs.IsNil))
+		})
+	})
+}
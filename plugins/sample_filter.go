@@ -0,0 +1,112 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync/atomic"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Filter that keeps one in every `sample_rate` messages, chosen by hashing
+// the message UUID, and injects a copy of each kept message.
+type SampleFilter struct {
+	conf *SampleFilterConfig
+	fr   FilterRunner
+	h    PluginHelper
+
+	keptCount    int64
+	droppedCount int64
+}
+
+// SampleFilter config struct.
+type SampleFilterConfig struct {
+	// Keep one in this many messages, e.g. 100 keeps 1/100 of the messages.
+	// Required.
+	SampleRate uint32 `toml:"sample_rate"`
+	// Prepended to the original message Type to give the Type of the kept
+	// copies. Defaults to "sampled.".
+	TypePrefix string `toml:"type_prefix"`
+}
+
+func (f *SampleFilter) ConfigStruct() interface{} {
+	return &SampleFilterConfig{
+		TypePrefix: "sampled.",
+	}
+}
+
+func (f *SampleFilter) Init(config interface{}) error {
+	f.conf = config.(*SampleFilterConfig)
+	if f.conf.SampleRate == 0 {
+		return errors.New("`sample_rate` must be greater than 0")
+	}
+	if f.conf.TypePrefix == "" {
+		return errors.New("`type_prefix` must be specified so the sampled " +
+			"messages can be told apart from the originals")
+	}
+	return nil
+}
+
+func (f *SampleFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func (f *SampleFilter) ProcessMessage(pack *PipelinePack) error {
+	if !f.keep(pack.Message) {
+		atomic.AddInt64(&f.droppedCount, 1)
+		return nil
+	}
+	newPack, err := f.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		return err
+	}
+	pack.Message.Copy(newPack.Message)
+	newPack.Message.SetType(f.conf.TypePrefix + newPack.Message.GetType())
+	if f.fr.Inject(newPack) {
+		atomic.AddInt64(&f.keptCount, 1)
+	}
+	return nil
+}
+
+func (f *SampleFilter) CleanUp() {}
+
+// Returns whether the message is in the sample. The decision only depends on
+// the message UUID, so a given message is always either kept or dropped.
+func (f *SampleFilter) keep(msg *message.Message) bool {
+	hash := fnv.New32a()
+	hash.Write(msg.GetUuid())
+	return hash.Sum32()%f.conf.SampleRate == 0
+}
+
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (f *SampleFilter) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "KeptCount",
+		atomic.LoadInt64(&f.keptCount), "count")
+	message.NewInt64Field(msg, "DroppedCount",
+		atomic.LoadInt64(&f.droppedCount), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("SampleFilter", func() interface{} {
+		return new(SampleFilter)
+	})
+}
@@ -0,0 +1,119 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	pm "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/pborman/uuid"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SampleFilterSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A SampleFilter", func() {
+		filter := new(SampleFilter)
+		config := filter.ConfigStruct().(*SampleFilterConfig)
+		config.SampleRate = 10
+		fr := pm.NewMockFilterRunner(ctrl)
+		h := pm.NewMockPluginHelper(ctrl)
+		supply := make(chan *PipelinePack, 1)
+
+		c.Specify("requires a sample rate", func() {
+			config.SampleRate = 0
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("requires a type prefix", func() {
+			config.TypePrefix = ""
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("keeps the same messages every time", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			msg := pipeline_ts.GetTestMessage()
+			kept := 0
+			for i := 0; i < 10000; i++ {
+				msg.SetUuid(uuid.NewRandom())
+				keep := filter.keep(msg)
+				c.Expect(filter.keep(message.CopyMessage(msg)), gs.Equals, keep)
+				if keep {
+					kept++
+				}
+			}
+			c.Expect(kept > 800 && kept < 1200, gs.IsTrue)
+		})
+
+		c.Specify("injects a copy of kept messages", func() {
+			config.SampleRate = 1
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			err = filter.Prepare(fr, h)
+			c.Assume(err, gs.IsNil)
+
+			pack := NewPipelinePack(supply)
+			pack.Message = pipeline_ts.GetTestMessage()
+			newPack := NewPipelinePack(supply)
+			h.EXPECT().PipelinePack(pack.MsgLoopCount).Return(newPack, nil)
+			fr.EXPECT().Inject(newPack).Return(true)
+
+			err = filter.ProcessMessage(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(newPack.Message.GetType(), gs.Equals, "sampled.TEST")
+			c.Expect(newPack.Message.GetUuidString(), gs.Equals,
+				pack.Message.GetUuidString())
+			c.Expect(newPack.Message.GetPayload(), gs.Equals,
+				pack.Message.GetPayload())
+
+			msg := new(message.Message)
+			err = filter.ReportMsg(msg)
+			c.Expect(err, gs.IsNil)
+			kept, _ := msg.GetFieldValue("KeptCount")
+			c.Expect(kept, gs.Equals, int64(1))
+			dropped, _ := msg.GetFieldValue("DroppedCount")
+			c.Expect(dropped, gs.Equals, int64(0))
+		})
+
+		c.Specify("counts dropped messages", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			err = filter.Prepare(fr, h)
+			c.Assume(err, gs.IsNil)
+
+			pack := NewPipelinePack(supply)
+			pack.Message = pipeline_ts.GetTestMessage()
+			for filter.keep(pack.Message) {
+				pack.Message.SetUuid(uuid.NewRandom())
+			}
+			err = filter.ProcessMessage(pack)
+			c.Expect(err, gs.IsNil)
+
+			msg := new(message.Message)
+			err = filter.ReportMsg(msg)
+			c.Expect(err, gs.IsNil)
+			dropped, _ := msg.GetFieldValue("DroppedCount")
+			c.Expect(dropped, gs.Equals, int64(1))
+		})
+	})
+}
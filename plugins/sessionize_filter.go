@@ -0,0 +1,245 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// State accumulated for a single open session.
+type session struct {
+	id       string
+	count    int64
+	first    int64 // Earliest message timestamp, in ns.
+	last     int64 // Latest message timestamp, in ns.
+	pages    map[string]bool
+	lastSeen time.Time // Wall clock time of the most recent message.
+	reason   string    // Why the session was closed.
+	elem     *list.Element
+}
+
+// Filter that groups messages into sessions by a session id field, emitting
+// a summary message for each session when it is closed, either by an
+// explicit end event or by being idle for too long.
+type SessionizeFilter struct {
+	conf        *SessionizeFilterConfig
+	idleTimeout time.Duration
+	endMatcher  *message.MatcherSpecification
+	sessions    map[string]*session
+	// Sessions ordered from least to most recently seen, used for both idle
+	// expiration and eviction when max_sessions is reached.
+	lru *list.List
+}
+
+// SessionizeFilter config struct.
+type SessionizeFilterConfig struct {
+	// Name of the message field holding the session id. Defaults to
+	// "session_id".
+	SessionField string `toml:"session_field"`
+	// Name of the message field used to count distinct pages per session.
+	// Defaults to "page". Set to an empty string to skip page tracking.
+	PageField string `toml:"page_field"`
+	// Number of seconds a session can go without receiving any messages
+	// before it is closed. Defaults to 1800 (i.e. 30 minutes).
+	IdleTimeout uint `toml:"idle_timeout"`
+	// Maximum number of open sessions to track. When this is exceeded the
+	// least recently seen session is closed early. Defaults to 10000.
+	MaxSessions int `toml:"max_sessions"`
+	// Optional message matcher identifying messages that end a session. A
+	// matching message is counted as part of the session before the session
+	// is closed.
+	EndMatcher string `toml:"end_matcher"`
+	// Type to use for the emitted summary messages. Defaults to
+	// "heka.sessionize".
+	MessageType string `toml:"message_type"`
+	// Defaults to 5 second intervals.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+func (this *SessionizeFilter) ConfigStruct() interface{} {
+	return &SessionizeFilterConfig{
+		SessionField:   "session_id",
+		PageField:      "page",
+		IdleTimeout:    1800,
+		MaxSessions:    10000,
+		MessageType:    "heka.sessionize",
+		TickerInterval: uint(5),
+	}
+}
+
+func (this *SessionizeFilter) Init(config interface{}) (err error) {
+	this.conf = config.(*SessionizeFilterConfig)
+	if this.conf.SessionField == "" {
+		return errors.New("`session_field` must be specified")
+	}
+	if this.conf.MaxSessions < 1 {
+		return errors.New("`max_sessions` must be greater than zero")
+	}
+	if this.conf.IdleTimeout == 0 {
+		return errors.New("`idle_timeout` must be greater than zero")
+	}
+	if this.conf.EndMatcher != "" {
+		if this.endMatcher, err = message.CreateMatcherSpecification(
+			this.conf.EndMatcher); err != nil {
+			return fmt.Errorf("invalid `end_matcher`: %s", err)
+		}
+	}
+	this.idleTimeout = time.Duration(this.conf.IdleTimeout) * time.Second
+	this.sessions = make(map[string]*session)
+	this.lru = list.New()
+	return
+}
+
+func (this *SessionizeFilter) Run(fr FilterRunner, h PluginHelper) (err error) {
+	inChan := fr.InChan()
+	ticker := fr.Ticker()
+
+	var (
+		ok           = true
+		pack         *PipelinePack
+		msgLoopCount uint
+		closed       []*session
+	)
+	for ok {
+		select {
+		case pack, ok = <-inChan:
+			if !ok {
+				break
+			}
+			msgLoopCount = pack.MsgLoopCount
+			closed = this.addMessage(pack.Message, time.Now())
+			fr.UpdateCursor(pack.QueueCursor)
+			pack.Recycle(nil)
+			for _, s := range closed {
+				this.emit(fr, h, s, msgLoopCount)
+			}
+		case <-ticker:
+			for _, s := range this.expire(time.Now()) {
+				this.emit(fr, h, s, msgLoopCount)
+			}
+		}
+	}
+	// Any sessions still open at shutdown are discarded.
+	return
+}
+
+func (this *SessionizeFilter) CleanupForRestart() {
+	this.sessions = make(map[string]*session)
+	this.lru.Init()
+}
+
+// Adds the message to its session, returning any sessions that were closed
+// as a result.
+func (this *SessionizeFilter) addMessage(msg *message.Message,
+	now time.Time) (closed []*session) {
+
+	val, ok := msg.GetFieldValue(this.conf.SessionField)
+	if !ok {
+		return
+	}
+	id, ok := val.(string)
+	if !ok {
+		id = fmt.Sprint(val)
+	}
+	ts := msg.GetTimestamp()
+
+	s, ok := this.sessions[id]
+	if ok {
+		this.lru.MoveToBack(s.elem)
+	} else {
+		if len(this.sessions) >= this.conf.MaxSessions {
+			oldest := this.lru.Front().Value.(*session)
+			closed = append(closed, this.remove(oldest, "evicted"))
+		}
+		s = &session{id: id, first: ts, last: ts, pages: make(map[string]bool)}
+		s.elem = this.lru.PushBack(s)
+		this.sessions[id] = s
+	}
+
+	s.count++
+	if ts < s.first {
+		s.first = ts
+	}
+	if ts > s.last {
+		s.last = ts
+	}
+	s.lastSeen = now
+	if this.conf.PageField != "" {
+		if page, ok := msg.GetFieldValue(this.conf.PageField); ok {
+			s.pages[fmt.Sprint(page)] = true
+		}
+	}
+
+	if this.endMatcher != nil && this.endMatcher.Match(msg) {
+		closed = append(closed, this.remove(s, "end"))
+	}
+	return
+}
+
+// Removes and returns all of the sessions that have been idle for longer than
+// the idle timeout.
+func (this *SessionizeFilter) expire(now time.Time) (closed []*session) {
+	for e := this.lru.Front(); e != nil; e = this.lru.Front() {
+		s := e.Value.(*session)
+		if now.Sub(s.lastSeen) < this.idleTimeout {
+			break
+		}
+		closed = append(closed, this.remove(s, "idle"))
+	}
+	return
+}
+
+func (this *SessionizeFilter) remove(s *session, reason string) *session {
+	s.reason = reason
+	this.lru.Remove(s.elem)
+	delete(this.sessions, s.id)
+	return s
+}
+
+func (this *SessionizeFilter) emit(fr FilterRunner, h PluginHelper, s *session,
+	msgLoopCount uint) {
+
+	pack, e := h.PipelinePack(msgLoopCount)
+	if e != nil {
+		fr.LogError(e)
+		return
+	}
+	pack.Message.SetLogger(fr.Name())
+	pack.Message.SetType(this.conf.MessageType)
+	pack.Message.SetPayload(fmt.Sprintf("Session %s closed (%s): %d messages",
+		s.id, s.reason, s.count))
+	message.NewStringField(pack.Message, "session_id", s.id)
+	message.NewStringField(pack.Message, "close_reason", s.reason)
+	message.NewInt64Field(pack.Message, "count", s.count, "count")
+	message.NewInt64Field(pack.Message, "duration", s.last-s.first, "ns")
+	message.NewInt64Field(pack.Message, "first_timestamp", s.first, "ns")
+	message.NewInt64Field(pack.Message, "last_timestamp", s.last, "ns")
+	if this.conf.PageField != "" {
+		message.NewIntField(pack.Message, "distinct_pages", len(s.pages), "count")
+	}
+	fr.Inject(pack)
+}
+
+func init() {
+	RegisterPlugin("SessionizeFilter", func() interface{} {
+		return new(SessionizeFilter)
+	})
+}
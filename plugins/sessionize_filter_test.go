@@ -0,0 +1,153 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	pm "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SessionizeFilterSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newMsg := func(id, page string, ts int64) *message.Message {
+		msg := pipeline_ts.GetTestMessage()
+		msg.SetTimestamp(ts)
+		message.NewStringField(msg, "session_id", id)
+		if page != "" {
+			message.NewStringField(msg, "page", page)
+		}
+		return msg
+	}
+
+	c.Specify("A SessionizeFilter", func() {
+		filter := new(SessionizeFilter)
+		config := filter.ConfigStruct().(*SessionizeFilterConfig)
+		now := time.Now()
+
+		c.Specify("rejects an invalid end matcher", func() {
+			config.EndMatcher = "Type =="
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("accumulates session state", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			closed := filter.addMessage(newMsg("a", "/one", 3000), now)
+			c.Expect(len(closed), gs.Equals, 0)
+			filter.addMessage(newMsg("a", "/two", 1000), now)
+			filter.addMessage(newMsg("a", "/one", 5000), now)
+			filter.addMessage(newMsg("b", "/one", 9000), now)
+			c.Expect(len(filter.sessions), gs.Equals, 2)
+
+			s := filter.sessions["a"]
+			c.Expect(s.count, gs.Equals, int64(3))
+			c.Expect(s.first, gs.Equals, int64(1000))
+			c.Expect(s.last, gs.Equals, int64(5000))
+			c.Expect(len(s.pages), gs.Equals, 2)
+		})
+
+		c.Specify("ignores messages without a session id", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			closed := filter.addMessage(pipeline_ts.GetTestMessage(), now)
+			c.Expect(len(closed), gs.Equals, 0)
+			c.Expect(len(filter.sessions), gs.Equals, 0)
+		})
+
+		c.Specify("closes idle sessions", func() {
+			config.IdleTimeout = 10
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", "", 1000), now)
+			filter.addMessage(newMsg("b", "", 1000), now.Add(5*time.Second))
+
+			closed := filter.expire(now.Add(9 * time.Second))
+			c.Expect(len(closed), gs.Equals, 0)
+			closed = filter.expire(now.Add(11 * time.Second))
+			c.Expect(len(closed), gs.Equals, 1)
+			c.Expect(closed[0].id, gs.Equals, "a")
+			c.Expect(closed[0].reason, gs.Equals, "idle")
+			c.Expect(len(filter.sessions), gs.Equals, 1)
+		})
+
+		c.Specify("closes a session on an end event", func() {
+			config.EndMatcher = "Fields[page] == '/logout'"
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", "/one", 1000), now)
+			closed := filter.addMessage(newMsg("a", "/logout", 2000), now)
+			c.Expect(len(closed), gs.Equals, 1)
+			c.Expect(closed[0].reason, gs.Equals, "end")
+			c.Expect(closed[0].count, gs.Equals, int64(2))
+			c.Expect(len(filter.sessions), gs.Equals, 0)
+		})
+
+		c.Specify("evicts the least recently seen session", func() {
+			config.MaxSessions = 2
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", "", 1000), now)
+			filter.addMessage(newMsg("b", "", 1000), now)
+			filter.addMessage(newMsg("a", "", 2000), now)
+			closed := filter.addMessage(newMsg("c", "", 3000), now)
+			c.Expect(len(closed), gs.Equals, 1)
+			c.Expect(closed[0].id, gs.Equals, "b")
+			c.Expect(closed[0].reason, gs.Equals, "evicted")
+			c.Expect(len(filter.sessions), gs.Equals, 2)
+		})
+
+		c.Specify("emits a summary message", func() {
+			fr := pm.NewMockFilterRunner(ctrl)
+			h := pm.NewMockPluginHelper(ctrl)
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", "/one", 1000), now)
+			filter.addMessage(newMsg("a", "/two", 4000), now)
+			closed := filter.expire(now.Add(time.Hour))
+			c.Assume(len(closed), gs.Equals, 1)
+
+			supply := make(chan *PipelinePack, 1)
+			pack := NewPipelinePack(supply)
+			h.EXPECT().PipelinePack(uint(0)).Return(pack, nil)
+			fr.EXPECT().Name().Return("sessions")
+			fr.EXPECT().Inject(pack).Return(true)
+			filter.emit(fr, h, closed[0], 0)
+
+			msg := pack.Message
+			c.Expect(msg.GetType(), gs.Equals, "heka.sessionize")
+			c.Expect(msg.GetLogger(), gs.Equals, "sessions")
+			val, _ := msg.GetFieldValue("session_id")
+			c.Expect(val.(string), gs.Equals, "a")
+			val, _ = msg.GetFieldValue("close_reason")
+			c.Expect(val.(string), gs.Equals, "idle")
+			val, _ = msg.GetFieldValue("count")
+			c.Expect(val.(int64), gs.Equals, int64(2))
+			val, _ = msg.GetFieldValue("duration")
+			c.Expect(val.(int64), gs.Equals, int64(3000))
+			val, _ = msg.GetFieldValue("distinct_pages")
+			c.Expect(val.(int64), gs.Equals, int64(2))
+		})
+	})
+}
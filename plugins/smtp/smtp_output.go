@@ -18,6 +18,7 @@
 package smtp
 
 import (
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -27,6 +28,7 @@ import (
 	"time"
 
 	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/mozilla-services/heka/plugins/tcp"
 )
 
 const BASE64_ENCODING_LINE_LENGTH = 76
@@ -40,6 +42,9 @@ type SmtpOutput struct {
 	or           OutputRunner
 	fullMsg      []byte
 	headerLen    int
+	hostname     string
+	tlsConf      *tls.Config
+	authFailed   bool
 }
 
 type SmtpOutputConfig struct {
@@ -55,8 +60,16 @@ type SmtpOutputConfig struct {
 	Auth string
 	// SMTP user
 	User string
+	// Alias for User.
+	Username string
 	// SMTP password
 	Password string
+	// Set to true if the connection must be upgraded to TLS with STARTTLS
+	// before sending. Otherwise STARTTLS is only used if the server offers
+	// it.
+	UseTls bool `toml:"use_tls"`
+	// Subsection for TLS configuration.
+	Tls tcp.TlsConfig
 	// Set a minimum time interval between each email. The value indicates a
 	// minimum number of seconds between each email. If more than one message
 	// is received in the period, the mail text is concatenated. Default is 0,
@@ -90,15 +103,33 @@ func (s *SmtpOutput) Init(config interface{}) (err error) {
 		return fmt.Errorf("Host must contain a port specifier")
 	}
 
-	s.sendFunction = smtp.SendMail
+	s.hostname = host
+	s.sendFunction = s.smtpSend
+	s.authFailed = false
 
-	if s.conf.Auth == "Plain" {
-		s.auth = smtp.PlainAuth("", s.conf.User, s.conf.Password, host)
-	} else if s.conf.Auth == "CRAMMD5" {
-		s.auth = smtp.CRAMMD5Auth(s.conf.User, s.conf.Password)
-	} else if s.conf.Auth == "none" {
+	s.tlsConf = nil
+	if s.conf.UseTls {
+		if s.tlsConf, err = tcp.CreateGoTlsConfig(&s.conf.Tls); err != nil {
+			return fmt.Errorf("TLS init error: %s", err)
+		}
+		if s.tlsConf.ServerName == "" {
+			s.tlsConf.ServerName = host
+		}
+	}
+
+	user := s.conf.User
+	if user == "" {
+		user = s.conf.Username
+	}
+
+	switch strings.ToLower(s.conf.Auth) {
+	case "plain":
+		s.auth = smtp.PlainAuth("", user, s.conf.Password, host)
+	case "crammd5":
+		s.auth = smtp.CRAMMD5Auth(user, s.conf.Password)
+	case "none", "":
 		s.auth = nil
-	} else {
+	default:
 		return fmt.Errorf("Invalid auth type: %s", s.conf.Auth)
 	}
 	return
@@ -138,10 +169,12 @@ func (s *SmtpOutput) Run(or OutputRunner, h PluginHelper) (err error) {
 		if s.conf.SendInterval == 0 {
 			err = s.sendMail(contents)
 			if err != nil {
+				s.checkAuthError(err)
 				e := NewRetryMessageError("sending error: %s", err.Error())
 				pack.Recycle(e)
 				continue
 			}
+			s.authFailed = false
 		} else {
 			s.inMessage <- contents
 		}
@@ -193,6 +226,83 @@ func (s SmtpOutput) sendMail(contents []byte) error {
 	return s.sendFunction(s.conf.Host, s.auth, s.conf.SendFrom, s.conf.SendTo, s.fullMsg)
 }
 
+// authError is returned by smtpSend when the server rejects our credentials.
+type authError struct {
+	err error
+}
+
+func (e authError) Error() string {
+	return fmt.Sprintf("SMTP authentication failed: %s", e.err)
+}
+
+// checkAuthError logs authentication failures once until a send succeeds
+// again, rather than on every retry. Returns true if err is an
+// authentication failure.
+func (s *SmtpOutput) checkAuthError(err error) bool {
+	if _, ok := err.(authError); !ok {
+		return false
+	}
+	if !s.authFailed {
+		s.or.LogError(err)
+		s.authFailed = true
+	}
+	return true
+}
+
+// smtpSend works like smtp.SendMail, but uses our TLS configuration for
+// STARTTLS, requires it if use_tls is set, and reports authentication
+// failures as an authError.
+func (s *SmtpOutput) smtpSend(addr string, a smtp.Auth, from string, to []string,
+	msg []byte) (err error) {
+
+	var c *smtp.Client
+	if c, err = smtp.Dial(addr); err != nil {
+		return
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		tlsConf := s.tlsConf
+		if tlsConf == nil {
+			tlsConf = &tls.Config{ServerName: s.hostname}
+		}
+		if err = c.StartTLS(tlsConf); err != nil {
+			return
+		}
+	} else if s.conf.UseTls {
+		return errors.New("server doesn't support STARTTLS")
+	}
+
+	if a != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return authError{errors.New("server doesn't support AUTH")}
+		}
+		if err = c.Auth(a); err != nil {
+			return authError{err}
+		}
+	}
+
+	if err = c.Mail(from); err != nil {
+		return
+	}
+	for _, addr := range to {
+		if err = c.Rcpt(addr); err != nil {
+			return
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(msg); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	return c.Quit()
+}
+
 // Only called once at startup.
 func (s SmtpOutput) getHeader() []byte {
 	var subject string
@@ -222,6 +332,15 @@ func (s *SmtpOutput) sendLoop() {
 	// Time indicating when the last message was sent.
 	lastSent := time.Now().Add(-tickerDur)
 
+	// Starts a timeout function that will fire when the duration has passed.
+	startTimeOut := func() {
+		go func() {
+			dur := lastSent.Sub(time.Now()) + tickerDur
+			time.Sleep(dur)
+			timeOut <- true
+		}()
+	}
+
 	for {
 		select {
 		case msg := <-s.inMessage:
@@ -230,20 +349,21 @@ func (s *SmtpOutput) sendLoop() {
 			if len(queue) == 0 && time.Now().After(lastSent.Add(tickerDur)) {
 				err = s.sendMail(msg)
 				lastSent = time.Now()
-				if err != nil {
+				if err == nil {
+					s.authFailed = false
+					continue
+				}
+				if !s.checkAuthError(err) {
 					s.or.LogError(err)
+					continue
 				}
-				continue
-			}
-			if len(queue) == 0 {
-				// The ticker duration has not expired yet, but no messages
-				// are queued, so we start a timeout function that will fire
+				// Authentication failed, queue the message to be retried
 				// when the duration has passed.
-				go func() {
-					dur := lastSent.Sub(time.Now()) + tickerDur
-					time.Sleep(dur)
-					timeOut <- true
-				}()
+				startTimeOut()
+			} else if len(queue) == 0 {
+				// The ticker duration has not expired yet, but no messages
+				// are queued, so we start the timeout.
+				startTimeOut()
 			}
 			queue = append(queue, msg...)
 			queue = append(queue, []byte("\r\n\r\n")...)
@@ -251,12 +371,18 @@ func (s *SmtpOutput) sendLoop() {
 			// When the timeout has expired, send the messages that are
 			// queued.
 			contents := queue[:len(queue)-4]
-			queue = queue[:0]
 			err = s.sendMail(contents)
 			lastSent = time.Now()
-			if err != nil {
+			if err == nil {
+				s.authFailed = false
+			} else if s.checkAuthError(err) {
+				// Keep the queued messages and retry them later.
+				startTimeOut()
+				continue
+			} else {
 				s.or.LogError(err)
 			}
+			queue = queue[:0]
 		}
 	}
 }
@@ -18,6 +18,7 @@ package smtp
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	. "github.com/mozilla-services/heka/pipeline"
 	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
@@ -97,6 +98,41 @@ func SmtpOutputSpec(c gs.Context) {
 			close(inChan)
 			wg.Wait()
 		})
+
+		c.Specify("logs authentication failures only once", func() {
+			err := smtpOutput.Init(config)
+			c.Assume(err, gs.IsNil)
+			var attempts int
+			smtpOutput.sendFunction = func(addr string, a smtp.Auth, from string,
+				to []string, msg []byte) error {
+
+				attempts++
+				return authError{errors.New("535 Authentication failed")}
+			}
+
+			pack.Message.SetPayload("Write me out to the network")
+			encCall.Return(encoder.Encode(pack)).Times(2)
+			oth.MockOutputRunner.EXPECT().LogError(gomock.Any()).Times(1)
+			wg.Add(1)
+			go func() {
+				smtpOutput.Run(oth.MockOutputRunner, oth.MockHelper)
+				wg.Done()
+			}()
+			inChan <- pack
+			inChan <- pack
+			close(inChan)
+			wg.Wait()
+			c.Expect(attempts, gs.Equals, 2)
+			c.Expect(smtpOutput.authFailed, gs.IsTrue)
+		})
+
+		c.Specify("accepts lower case auth types", func() {
+			config.Auth = "crammd5"
+			config.Username = "test"
+			err := smtpOutput.Init(config)
+			c.Expect(err, gs.IsNil)
+			c.Expect(smtpOutput.auth, gs.Not(gs.IsNil))
+		})
 	})
 
 	c.Specify("SmtpOutput Message Body Encoding", func() {
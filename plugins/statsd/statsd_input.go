@@ -18,10 +18,12 @@ package statsd
 import (
 	"bytes"
 	"fmt"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	"math"
 	"net"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -40,6 +42,15 @@ type StatsdInput struct {
 	statAccum     StatAccumulator
 	maxMsgSize    uint
 	ir            InputRunner
+
+	packetCount          int64
+	malformedPacketCount int64
+	malformedLineCount   int64
+	counterCount         int64
+	gaugeCount           int64
+	timerCount           int64
+	histogramCount       int64
+	meterCount           int64
 }
 
 // StatsInput config struct
@@ -126,16 +137,60 @@ func (s *StatsdInput) Stop() {
 // object that can be passed to the StatMonitor.
 func (s *StatsdInput) handleMessage(message []byte) {
 	stats, badLines := parseMessage(message)
+	atomic.AddInt64(&s.packetCount, 1)
+	if len(badLines) > 0 {
+		atomic.AddInt64(&s.malformedPacketCount, 1)
+		atomic.AddInt64(&s.malformedLineCount, int64(len(badLines)))
+	}
 	for _, line := range badLines {
 		s.ir.LogError(fmt.Errorf("can't parse message: %s", string(line)))
 	}
 	for _, stat := range stats {
+		s.countStat(stat)
 		if !s.statAccum.DropStat(stat) {
 			s.ir.LogError(fmt.Errorf("undelivered stat: %+v", stat))
 		}
 	}
 }
 
+// Increments the count of parsed stats of the stat's metric type.
+func (s *StatsdInput) countStat(stat Stat) {
+	switch stat.Modifier {
+	case "c":
+		atomic.AddInt64(&s.counterCount, 1)
+	case "g":
+		atomic.AddInt64(&s.gaugeCount, 1)
+	case "ms":
+		atomic.AddInt64(&s.timerCount, 1)
+	case "h":
+		atomic.AddInt64(&s.histogramCount, 1)
+	case "m":
+		atomic.AddInt64(&s.meterCount, 1)
+	}
+}
+
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (s *StatsdInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "PacketCount",
+		atomic.LoadInt64(&s.packetCount), "count")
+	message.NewInt64Field(msg, "MalformedPacketCount",
+		atomic.LoadInt64(&s.malformedPacketCount), "count")
+	message.NewInt64Field(msg, "MalformedLineCount",
+		atomic.LoadInt64(&s.malformedLineCount), "count")
+	message.NewInt64Field(msg, "CounterCount",
+		atomic.LoadInt64(&s.counterCount), "count")
+	message.NewInt64Field(msg, "GaugeCount",
+		atomic.LoadInt64(&s.gaugeCount), "count")
+	message.NewInt64Field(msg, "TimerCount",
+		atomic.LoadInt64(&s.timerCount), "count")
+	message.NewInt64Field(msg, "HistogramCount",
+		atomic.LoadInt64(&s.histogramCount), "count")
+	message.NewInt64Field(msg, "MeterCount",
+		atomic.LoadInt64(&s.meterCount), "count")
+	return nil
+}
+
 func parseMessage(message []byte) ([]Stat, [][]byte) {
 	message = bytes.Trim(message, " \t\n")
 
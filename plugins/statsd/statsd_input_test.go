@@ -16,6 +16,7 @@ package statsd
 
 import (
 	"fmt"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	. "github.com/mozilla-services/heka/pipelinemock"
@@ -85,6 +86,35 @@ func StatsdInputSpec(c gs.Context) {
 			}()
 			wg.Wait()
 		})
+
+		c.Specify("reports counts by metric type and malformed packets", func() {
+			statsdInput.ir = ith.MockInputRunner
+			statsdInput.statAccum = mockStatAccum
+			mockStatAccum.EXPECT().DropStat(gomock.Any()).Return(true).Times(4)
+			ith.MockInputRunner.EXPECT().LogError(gomock.Any()).Times(2)
+
+			statsdInput.handleMessage([]byte("a:1|c\nb:2|c\nc:3|ms\n"))
+			statsdInput.handleMessage([]byte("d:4|g\ngarbage\ne:5|s\n"))
+			statsdInput.handleMessage([]byte(""))
+
+			msg := new(message.Message)
+			err := statsdInput.ReportMsg(msg)
+			c.Expect(err, gs.IsNil)
+			expected := map[string]int64{
+				"PacketCount":          3,
+				"MalformedPacketCount": 1,
+				"MalformedLineCount":   2,
+				"CounterCount":         2,
+				"GaugeCount":           1,
+				"TimerCount":           1,
+				"HistogramCount":       0,
+				"MeterCount":           0,
+			}
+			for name, count := range expected {
+				val, _ := msg.GetFieldValue(name)
+				c.Expect(val, gs.Equals, count)
+			}
+		})
 	})
 }
 
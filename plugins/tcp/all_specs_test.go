@@ -27,6 +27,8 @@ func TestAllSpecs(t *testing.T) {
 	r.AddSpec(TcpOutputSpec)
 	r.AddSpec(TlsSpec)
 	r.AddSpec(TcpInputSpecFailure)
+	r.AddSpec(TcpInputInterfaceSpec)
+	r.AddSpec(TcpInputUnixSpec)
 
 	gospec.MainGoTest(r, t)
 }
@@ -0,0 +1,92 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package tcp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// InterfaceAddress returns the address a listener should bind to in order to
+// only accept traffic on the named network interface. If the provided address
+// doesn't specify a host, the interface's first address of the family
+// matching network (IPv4 preferred for "tcp" and "udp") is used with the
+// address's port. If the address does specify a host, it must be an IP that
+// belongs to the interface.
+func InterfaceAddress(ifaceName, address, network string) (string, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return "", fmt.Errorf("unknown interface '%s': %s", ifaceName, err)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address '%s': %s", address, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("can't get addresses for interface '%s': %s",
+			ifaceName, err)
+	}
+	// IPv4 addresses go first so they're preferred when no family was
+	// specified.
+	var v4s, v6s []net.IP
+	for _, addr := range addrs {
+		var ip net.IP
+		switch a := addr.(type) {
+		case *net.IPNet:
+			ip = a.IP
+		case *net.IPAddr:
+			ip = a.IP
+		default:
+			continue
+		}
+		if ip.To4() != nil {
+			if !strings.HasSuffix(network, "6") {
+				v4s = append(v4s, ip)
+			}
+		} else if !strings.HasSuffix(network, "4") {
+			v6s = append(v6s, ip)
+		}
+	}
+	ips := append(v4s, v6s...)
+	if len(ips) == 0 {
+		return "", fmt.Errorf("interface '%s' has no %s addresses", ifaceName,
+			network)
+	}
+
+	if host == "" {
+		ip := ips[0]
+		host = ip.String()
+		if ip.To4() == nil && ip.IsLinkLocalUnicast() {
+			host = fmt.Sprintf("%s%%%s", host, iface.Name)
+		}
+		return net.JoinHostPort(host, port), nil
+	}
+
+	hostIP := net.ParseIP(strings.SplitN(host, "%", 2)[0])
+	if hostIP == nil {
+		return "", fmt.Errorf("address host '%s' must be an IP when an "+
+			"interface is specified", host)
+	}
+	for _, ip := range ips {
+		if ip.Equal(hostIP) {
+			return address, nil
+		}
+	}
+	return "", fmt.Errorf("address '%s' doesn't belong to interface '%s'",
+		address, ifaceName)
+}
@@ -20,9 +20,13 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 )
 
@@ -30,20 +34,32 @@ import (
 // specified TCP socket. Creates a separate goroutine for each TCP connection.
 type TcpInput struct {
 	keepAliveDuration time.Duration
+	idleTimeout       time.Duration
 	listener          net.Listener
 	wg                sync.WaitGroup
 	stopChan          chan bool
 	ir                InputRunner
 	config            *TcpInputConfig
+	connections       int64
+	idleClosed        int64
+	tlsRejected       int64
 }
 
+// How long a TLS client has to complete its handshake before the connection
+// is dropped.
+const tlsHandshakeTimeout = 10 * time.Second
+
 type TcpInputConfig struct {
-	// Network type (e.g. "tcp", "tcp4", "tcp6", "unix" or "unixpacket").
-	// Needs to match the input type.
+	// Network type ("tcp", "tcp4", "tcp6", or "unix"). Needs to match the
+	// output type.
 	Net string
 	// String representation of the address of the network connection on which
-	// the listener should be listening (e.g. "127.0.0.1:5565").
+	// the listener should be listening (e.g. "127.0.0.1:5565"), or the path
+	// of the socket file for "unix".
 	Address string
+	// Optional name of the network interface (e.g. "eth1") the listener
+	// should be bound to.
+	Interface string `toml:"interface"`
 	// Set to true if the TCP connection should be tunneled through TLS.
 	// Requires additional Tls config section.
 	UseTls bool `toml:"use_tls"`
@@ -53,6 +69,9 @@ type TcpInputConfig struct {
 	KeepAlive bool `toml:"keep_alive"`
 	// Integer indicating seconds between keep alives.
 	KeepAlivePeriod int `toml:"keep_alive_period"`
+	// Integer indicating seconds a connection may go without producing a
+	// record before it is closed. Zero disables the idle timeout.
+	IdleTimeout uint `toml:"idle_timeout"`
 	// So we can default to using ProtobufDecoder.
 	Decoder string
 	// So we can default to using HekaFramingSplitter.
@@ -72,13 +91,26 @@ func (t *TcpInput) ConfigStruct() interface{} {
 func (t *TcpInput) Init(config interface{}) error {
 	var err error
 	t.config = config.(*TcpInputConfig)
-	address, err := net.ResolveTCPAddr(t.config.Net, t.config.Address)
-	if err != nil {
-		return fmt.Errorf("ResolveTCPAddress failed: %s\n", err.Error())
-	}
-	t.listener, err = net.ListenTCP(t.config.Net, address)
-	if err != nil {
-		return fmt.Errorf("ListenTCP failed: %s\n", err.Error())
+	if t.config.Net == "unix" {
+		if err = t.listenUnix(); err != nil {
+			return err
+		}
+	} else {
+		addrStr := t.config.Address
+		if t.config.Interface != "" {
+			if addrStr, err = InterfaceAddress(t.config.Interface, addrStr,
+				t.config.Net); err != nil {
+				return err
+			}
+		}
+		address, err := net.ResolveTCPAddr(t.config.Net, addrStr)
+		if err != nil {
+			return fmt.Errorf("ResolveTCPAddress failed: %s\n", err.Error())
+		}
+		t.listener, err = net.ListenTCP(t.config.Net, address)
+		if err != nil {
+			return fmt.Errorf("ListenTCP failed: %s\n", err.Error())
+		}
 	}
 	// We're already listening, make sure we clean up if init fails later on.
 	closeIt := true
@@ -87,23 +119,69 @@ func (t *TcpInput) Init(config interface{}) error {
 			t.listener.Close()
 		}
 	}()
+	if t.config.KeepAlivePeriod != 0 {
+		t.keepAliveDuration = time.Duration(t.config.KeepAlivePeriod) * time.Second
+	}
+	if t.config.KeepAlive {
+		// Keep alives are set on the raw TCP connection, so this has to wrap
+		// the listener before TLS does.
+		t.listener = &keepAliveListener{
+			TCPListener: t.listener.(*net.TCPListener),
+			period:      t.keepAliveDuration,
+		}
+	}
 	if t.config.UseTls {
 		if err = t.setupTls(&t.config.Tls); err != nil {
 			return err
 		}
 	}
-	if t.config.KeepAlivePeriod != 0 {
-		t.keepAliveDuration = time.Duration(t.config.KeepAlivePeriod) * time.Second
-	}
+	t.idleTimeout = time.Duration(t.config.IdleTimeout) * time.Second
 	t.stopChan = make(chan bool)
 	closeIt = false
 	return nil
 }
 
+// Listens on the Unix socket at the configured address, replacing a socket
+// file left behind by a process that didn't shut down cleanly. The listener
+// removes the socket file again when it's closed.
+func (t *TcpInput) listenUnix() (err error) {
+	if runtime.GOOS == "windows" {
+		return errors.New("Can't use Unix sockets on Windows.")
+	}
+	if t.config.UseTls || t.config.KeepAlive || t.config.Interface != "" {
+		return errors.New("`use_tls`, `keep_alive`, and `interface` can't be " +
+			"used with Unix sockets")
+	}
+	path := t.config.Address
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("%s is in use by another listener", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return fmt.Errorf("can't remove stale socket: %s", err)
+		}
+	}
+	if t.listener, err = net.Listen("unix", path); err != nil {
+		return fmt.Errorf("Listen failed: %s", err)
+	}
+	return nil
+}
+
 func (t *TcpInput) setupTls(tomlConf *TlsConfig) (err error) {
 	if tomlConf.CertFile == "" || tomlConf.KeyFile == "" {
 		return errors.New("TLS config requires both cert_file and key_file value.")
 	}
+	switch tomlConf.ClientAuth {
+	case "RequireAndVerifyClientCert", "VerifyClientCertIfGiven":
+		if tomlConf.ClientCAs == "" {
+			return fmt.Errorf("TLS client_auth '%s' requires a client_cafile value.",
+				tomlConf.ClientAuth)
+		}
+	}
 	var goConf *tls.Config
 	if goConf, err = CreateGoTlsConfig(tomlConf); err == nil {
 		t.listener = tls.NewListener(t.listener, goConf)
@@ -111,25 +189,86 @@ func (t *TcpInput) setupTls(tomlConf *TlsConfig) (err error) {
 	return
 }
 
+// Listener that enables TCP keep alives on each accepted connection.
+type keepAliveListener struct {
+	*net.TCPListener
+	period time.Duration
+}
+
+func (l *keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetKeepAlive(true)
+	if l.period != 0 {
+		conn.SetKeepAlivePeriod(l.period)
+	}
+	return conn, nil
+}
+
+// Deliverer wrapper that keeps track of when a connection last produced a
+// record, for the idle timeout.
+type idleDeliverer struct {
+	Deliverer
+	lastRecord time.Time
+}
+
+func (d *idleDeliverer) Deliver(pack *PipelinePack) {
+	d.lastRecord = time.Now()
+	d.Deliverer.Deliver(pack)
+}
+
 // Listen on the provided TCP connection, extracting messages from the incoming
 // data until the connection is closed or Stop is called on the input.
 func (t *TcpInput) handleConnection(conn net.Conn) {
 	raddr := conn.RemoteAddr().String()
+	if raddr == "" {
+		// Unix socket clients are usually unnamed.
+		raddr = conn.LocalAddr().String()
+	}
 	host, _, err := net.SplitHostPort(raddr)
 	if err != nil {
 		host = raddr
 	}
 
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// Complete the handshake up front, so clients that fail the TLS
+		// requirements, e.g. by not presenting a valid client cert, are
+		// reported instead of silently dropped by the splitter.
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err = tlsConn.Handshake(); err != nil {
+			t.ir.LogError(fmt.Errorf("TLS handshake with %s failed: %s", raddr, err))
+			atomic.AddInt64(&t.tlsRejected, 1)
+			conn.Close()
+			atomic.AddInt64(&t.connections, -1)
+			t.wg.Done()
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+
 	deliverer := t.ir.NewDeliverer(host)
 	sr := t.ir.NewSplitterRunner(host)
 
 	defer func() {
 		conn.Close()
+		atomic.AddInt64(&t.connections, -1)
 		t.wg.Done()
 		deliverer.Done()
 		sr.Done()
 	}()
 
+	var idleDel *idleDeliverer
+	pollInterval := 5 * time.Second
+	if t.idleTimeout > 0 {
+		idleDel = &idleDeliverer{Deliverer: deliverer, lastRecord: time.Now()}
+		deliverer = idleDel
+		if t.idleTimeout < pollInterval {
+			pollInterval = t.idleTimeout
+		}
+	}
+
 	if !sr.UseMsgBytes() {
 		name := t.ir.Name()
 		packDec := func(pack *PipelinePack) {
@@ -141,7 +280,7 @@ func (t *TcpInput) handleConnection(conn net.Conn) {
 
 	stopped := false
 	for !stopped {
-		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		conn.SetReadDeadline(time.Now().Add(pollInterval))
 		select {
 		case <-t.stopChan:
 			stopped = true
@@ -151,6 +290,12 @@ func (t *TcpInput) handleConnection(conn net.Conn) {
 				if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
 					// keep the connection open, we are just checking to see if
 					// we are shutting down: Issue #354
+					if idleDel != nil && time.Since(idleDel.lastRecord) >= t.idleTimeout {
+						t.ir.LogMessage(fmt.Sprintf("closing idle connection from %s",
+							raddr))
+						atomic.AddInt64(&t.idleClosed, 1)
+						stopped = true
+					}
 				} else {
 					stopped = true
 				}
@@ -172,16 +317,7 @@ func (t *TcpInput) Run(ir InputRunner, h PluginHelper) error {
 				break
 			}
 		}
-		if t.config.KeepAlive {
-			tcpConn, ok := conn.(*net.TCPConn)
-			if !ok {
-				return errors.New("KeepAlive only supported for TCP Connections.")
-			}
-			tcpConn.SetKeepAlive(t.config.KeepAlive)
-			if t.keepAliveDuration != 0 {
-				tcpConn.SetKeepAlivePeriod(t.keepAliveDuration)
-			}
-		}
+		atomic.AddInt64(&t.connections, 1)
 		t.wg.Add(1)
 		go t.handleConnection(conn)
 	}
@@ -196,6 +332,18 @@ func (t *TcpInput) Stop() {
 	close(t.stopChan)
 }
 
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (t *TcpInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "Connections",
+		atomic.LoadInt64(&t.connections), "count")
+	message.NewInt64Field(msg, "IdleClosed",
+		atomic.LoadInt64(&t.idleClosed), "count")
+	message.NewInt64Field(msg, "TlsRejected",
+		atomic.LoadInt64(&t.tlsRejected), "count")
+	return nil
+}
+
 func init() {
 	RegisterPlugin("TcpInput", func() interface{} {
 		return new(TcpInput)
@@ -20,9 +20,14 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/mozilla-services/heka/pipelinemock"
@@ -44,6 +49,12 @@ func (a *address) String() string {
 	return a.str
 }
 
+type timeoutError struct{}
+
+func (e timeoutError) Error() string   { return "i/o timeout" }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
 func TcpInputSpec(c gs.Context) {
 	t := &pipeline_ts.SimpleT{}
 	ctrl := gomock.NewController(t)
@@ -101,6 +112,17 @@ func TcpInputSpec(c gs.Context) {
 			errChan <- err
 		}
 
+		// For connections that are expected to fail the TLS handshake, and
+		// therefore never reach the splitter.
+		rejectedChan := make(chan error, 1)
+		startRejectingServer := func() {
+			ith.MockInputRunner.EXPECT().LogError(gomock.Any()).Do(func(err error) {
+				rejectedChan <- err
+			})
+			err := tcpInput.Run(ith.MockInputRunner, ith.MockHelper)
+			errChan <- err
+		}
+
 		c.Specify("not using TLS", func() {
 			err := tcpInput.Init(config)
 			c.Assume(err, gs.IsNil)
@@ -128,6 +150,59 @@ func TcpInputSpec(c gs.Context) {
 			})
 		})
 
+		c.Specify("with an idle timeout", func() {
+			config.IdleTimeout = 1
+			err := tcpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			c.Specify("closes connections that don't produce records", func() {
+				srDoneWG.Add(1)
+				ith.MockInputRunner.EXPECT().Name().Return("mock_name")
+				ith.MockInputRunner.EXPECT().NewDeliverer(gomock.Any()).Return(ith.MockDeliverer)
+				ith.MockDeliverer.EXPECT().Done()
+				ith.MockInputRunner.EXPECT().NewSplitterRunner(gomock.Any()).Return(
+					ith.MockSplitterRunner)
+				ith.MockSplitterRunner.EXPECT().UseMsgBytes().Return(false)
+				ith.MockSplitterRunner.EXPECT().SetPackDecorator(gomock.Any())
+				ith.MockSplitterRunner.EXPECT().Done().Do(func() {
+					srDoneWG.Done()
+				})
+				splitCall := ith.MockSplitterRunner.EXPECT().SplitStream(gomock.Any(),
+					gomock.Any()).AnyTimes()
+				splitCall.Do(func(conn net.Conn, del Deliverer) {
+					ioutil.ReadAll(conn)
+				})
+				splitCall.Return(timeoutError{})
+				ith.MockInputRunner.EXPECT().LogMessage(gomock.Any())
+
+				go func() {
+					errChan <- tcpInput.Run(ith.MockInputRunner, ith.MockHelper)
+				}()
+
+				outConn, err := net.Dial("tcp", ith.AddrStr)
+				c.Assume(err, gs.IsNil)
+				defer outConn.Close()
+
+				// The input closes the connection on us once it's gone idle.
+				outConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, err = outConn.Read(make([]byte, 1))
+				c.Expect(err, gs.Equals, io.EOF)
+				srDoneWG.Wait()
+
+				msg := new(message.Message)
+				err = tcpInput.ReportMsg(msg)
+				c.Expect(err, gs.IsNil)
+				connections, _ := msg.GetFieldValue("Connections")
+				c.Expect(connections, gs.Equals, int64(0))
+				idleClosed, _ := msg.GetFieldValue("IdleClosed")
+				c.Expect(idleClosed, gs.Equals, int64(1))
+
+				tcpInput.Stop()
+				err = <-errChan
+				c.Expect(err, gs.IsNil)
+			})
+		})
+
 		c.Specify("using TLS", func() {
 			config.UseTls = true
 
@@ -175,7 +250,7 @@ func TcpInputSpec(c gs.Context) {
 				err := tcpInput.Init(config)
 				c.Expect(err, gs.IsNil)
 
-				go startServer()
+				go startRejectingServer()
 
 				clientConfig := &tls.Config{
 					InsecureSkipVerify: true,
@@ -185,12 +260,94 @@ func TcpInputSpec(c gs.Context) {
 				c.Expect(conn, gs.IsNil)
 				c.Expect(err, gs.Not(gs.IsNil))
 
-				<-bytesChan
+				err = <-rejectedChan
+				c.Expect(err.Error(), pipeline_ts.StringContains, "TLS handshake with 127.0.0.1:")
 
 				tcpInput.Stop()
 				err = <-errChan
 				c.Expect(err, gs.IsNil)
-				srDoneWG.Wait()
+				c.Expect(atomic.LoadInt64(&tcpInput.tlsRejected), gs.Equals, int64(1))
+			})
+
+			c.Specify("requiring client certs", func() {
+				config.Tls.ClientAuth = "RequireAndVerifyClientCert"
+				config.Tls.ClientCAs = "./testsupport/client_ca.pem"
+
+				c.Specify("fails to init w/o a client_cafile", func() {
+					config.Tls.ClientCAs = ""
+					err := tcpInput.Init(config)
+					c.Expect(err, gs.Not(gs.IsNil))
+					c.Expect(err.Error(), gs.Equals, "TLS client_auth "+
+						"'RequireAndVerifyClientCert' requires a client_cafile value.")
+				})
+
+				c.Specify("accepts clients w/ a cert signed by the CA", func() {
+					err := tcpInput.Init(config)
+					c.Expect(err, gs.IsNil)
+
+					go startServer()
+					data := []byte("This is a test.")
+
+					clientConfig, err := CreateGoTlsConfig(&TlsConfig{
+						CertFile:           "./testsupport/client_cert.pem",
+						KeyFile:            "./testsupport/client_key.pem",
+						InsecureSkipVerify: true,
+					})
+					c.Assume(err, gs.IsNil)
+					outConn, err := tls.Dial("tcp", ith.AddrStr, clientConfig)
+					c.Assume(err, gs.IsNil)
+					n, err := outConn.Write(data)
+					c.Assume(err, gs.IsNil)
+					c.Expect(n, gs.Equals, len(data))
+					outConn.Close()
+
+					recd := <-bytesChan
+					c.Expect(string(recd), gs.Equals, string(data))
+
+					tcpInput.Stop()
+					err = <-errChan
+					c.Expect(err, gs.IsNil)
+					srDoneWG.Wait()
+				})
+
+				rejectsClient := func(clientConfig *tls.Config) {
+					err := tcpInput.Init(config)
+					c.Expect(err, gs.IsNil)
+
+					go startRejectingServer()
+
+					// Depending on the TLS version the client may only notice
+					// the rejection on its first read, so we only check the
+					// server side.
+					if conn, err := tls.Dial("tcp", ith.AddrStr, clientConfig); err == nil {
+						conn.Read(make([]byte, 1))
+						conn.Close()
+					}
+
+					err = <-rejectedChan
+					c.Expect(err.Error(), pipeline_ts.StringContains, "TLS handshake with 127.0.0.1:")
+
+					tcpInput.Stop()
+					err = <-errChan
+					c.Expect(err, gs.IsNil)
+					c.Expect(atomic.LoadInt64(&tcpInput.tlsRejected), gs.Equals, int64(1))
+				}
+
+				c.Specify("rejects clients w/o a cert", func() {
+					rejectsClient(&tls.Config{InsecureSkipVerify: true})
+				})
+
+				c.Specify("rejects clients w/ a cert not signed by the CA", func() {
+					// The server's self-signed cert isn't trusted as a client
+					// cert.
+					clientConfig, err := CreateGoTlsConfig(&TlsConfig{
+						CertFile:           "./testsupport/cert.pem",
+						KeyFile:            "./testsupport/key.pem",
+						InsecureSkipVerify: true,
+					})
+					c.Assume(err, gs.IsNil)
+					rejectsClient(clientConfig)
+				})
 			})
 		})
 	})
@@ -206,3 +363,111 @@ func TcpInputSpecFailure(c gs.Context) {
 	c.Assume(err.Error(), gs.Equals, "ResolveTCPAddress failed: unknown network udp\n")
 
 }
+
+func TcpInputInterfaceSpec(c gs.Context) {
+	var loopback string
+	ifaces, err := net.Interfaces()
+	c.Assume(err, gs.IsNil)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+	c.Assume(loopback, gs.Not(gs.Equals), "")
+
+	c.Specify("A TcpInput bound to an interface", func() {
+		tcpInput := &TcpInput{}
+		config := &TcpInputConfig{
+			Net:       "tcp4",
+			Interface: loopback,
+		}
+
+		c.Specify("uses the interface address for a bare port", func() {
+			config.Address = ":0"
+			err := tcpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			defer tcpInput.listener.Close()
+			addr := tcpInput.listener.Addr().(*net.TCPAddr)
+			c.Expect(addr.IP.IsLoopback(), gs.IsTrue)
+		})
+
+		c.Specify("honors a matching interface address", func() {
+			config.Address = "127.0.0.1:0"
+			err := tcpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			defer tcpInput.listener.Close()
+			addr := tcpInput.listener.Addr().(*net.TCPAddr)
+			c.Expect(addr.IP.String(), gs.Equals, "127.0.0.1")
+		})
+
+		c.Specify("rejects an address from another interface", func() {
+			config.Address = "192.0.2.1:0"
+			err := tcpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects an unknown interface", func() {
+			config.Address = ":0"
+			config.Interface = "nosuchiface0"
+			err := tcpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
+
+func TcpInputUnixSpec(c gs.Context) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	tmpDir, err := ioutil.TempDir("", "tcpinput-unix")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "heka.sock")
+
+	c.Specify("A TcpInput on a Unix socket", func() {
+		tcpInput := &TcpInput{}
+		config := tcpInput.ConfigStruct().(*TcpInputConfig)
+		config.Net = "unix"
+		config.Address = path
+
+		c.Specify("listens on the socket and removes it when closed", func() {
+			err := tcpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			conn, err := net.Dial("unix", path)
+			c.Expect(err, gs.IsNil)
+			conn.Close()
+			tcpInput.listener.Close()
+			_, err = os.Stat(path)
+			c.Expect(os.IsNotExist(err), gs.IsTrue)
+		})
+
+		c.Specify("replaces a stale socket file", func() {
+			// Leave a socket file behind, like a crashed process would.
+			otherPath := filepath.Join(tmpDir, "other.sock")
+			listener, err := net.Listen("unix", otherPath)
+			c.Assume(err, gs.IsNil)
+			c.Assume(os.Link(otherPath, path), gs.IsNil)
+			listener.Close()
+
+			err = tcpInput.Init(config)
+			c.Expect(err, gs.IsNil)
+			tcpInput.listener.Close()
+		})
+
+		c.Specify("refuses a socket that's in use", func() {
+			listener, err := net.Listen("unix", path)
+			c.Assume(err, gs.IsNil)
+			defer listener.Close()
+
+			err = tcpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("refuses TLS", func() {
+			config.UseTls = true
+			err := tcpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -18,13 +18,18 @@ package tcp
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"regexp"
+	"runtime"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/mozilla-services/heka/client"
+	"github.com/mozilla-services/heka/compression"
 	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 )
@@ -42,12 +47,22 @@ type TcpOutput struct {
 	reportLock          sync.Mutex
 	or                  OutputRunner
 	pConfig             *PipelineConfig
+	stopChan            chan bool
+	reconnectBlock      *RetryHelper
+	tlsConf             *TlsClientConfig
+	// Signing config currently in use, nil if records aren't signed.
+	signer        *message.MessageSigningConfig
+	signerVersion int64
+	// Nil if records aren't compressed.
+	compressor *compression.Compressor
 }
 
 // ConfigStruct for TcpOutput plugin.
 type TcpOutputConfig struct {
+	// Network type ("tcp" or "unix"). Defaults to "tcp".
+	Net string
 	// String representation of the TCP address to which this output should be
-	// sending data.
+	// sending data, or the path of the socket file for "unix".
 	Address      string
 	LocalAddress string `toml:"local_address"`
 	UseTls       bool   `toml:"use_tls"`
@@ -68,9 +83,30 @@ type TcpOutputConfig struct {
 	// output. We do some magic to default to true if ProtobufEncoder is used,
 	// false otherwise.
 	UseFraming *bool `toml:"use_framing"`
+	// Signs each record with an HMAC using this config if a name is
+	// specified. Requires framing.
+	Signer message.MessageSigningConfig `toml:"signer"`
+	// Additional signing keys, by key version, that a signer control message
+	// can switch to.
+	SignerKeys map[string]string `toml:"signer_keys"`
+	// Type of the control messages that switch the signing key. Defaults to
+	// "heka.signer.rotate".
+	SignerControlType string `toml:"signer_control_type"`
+	// Name of the message signer control messages must be signed by. Key
+	// rotation is disabled if empty.
+	SignerControlSigner string `toml:"signer_control_signer"`
+	// Compression applied to each record, "gzip", "zstd" or "none" (default
+	// "none"). Requires framing.
+	Compression string
+	// Compression level, 0 for the algorithm's default level.
+	CompressionLevel int `toml:"compression_level"`
 	// Defaults to true for TcpOutput.
 	UseBuffering *bool `toml:"use_buffering"`
 	Buffering    QueueBufferConfig
+	// Controls the backoff between attempts to reconnect while the remote
+	// end is unreachable. Defaults to retrying forever, with the delay
+	// doubling from 250ms up to 30 seconds.
+	Retries RetryOptions
 }
 
 func (t *TcpOutput) ConfigStruct() interface{} {
@@ -82,10 +118,17 @@ func (t *TcpOutput) ConfigStruct() interface{} {
 		FullAction:        "shutdown",
 	}
 	return &TcpOutputConfig{
-		Address:      "localhost:9125",
-		Encoder:      "ProtobufEncoder",
-		UseBuffering: &b,
-		Buffering:    queueConfig,
+		Net:               "tcp",
+		Address:           "localhost:9125",
+		Encoder:           "ProtobufEncoder",
+		SignerControlType: "heka.signer.rotate",
+		UseBuffering:      &b,
+		Buffering:         queueConfig,
+		Retries: RetryOptions{
+			MaxDelay:   "30s",
+			Delay:      "250ms",
+			MaxRetries: -1,
+		},
 	}
 }
 
@@ -98,6 +141,20 @@ func (t *TcpOutput) Init(config interface{}) (err error) {
 	t.conf = config.(*TcpOutputConfig)
 	t.address = t.conf.Address
 
+	switch t.conf.Net {
+	case "tcp":
+	case "unix":
+		if runtime.GOOS == "windows" {
+			return errors.New("Can't use Unix sockets on Windows.")
+		}
+		if t.conf.UseTls || t.conf.KeepAlive || t.conf.LocalAddress != "" {
+			return errors.New("`use_tls`, `keep_alive`, and `local_address` " +
+				"can't be used with Unix sockets")
+		}
+	default:
+		return fmt.Errorf("unsupported `net`: %s", t.conf.Net)
+	}
+
 	if t.conf.LocalAddress != "" {
 		// Error out if use_tls and local_address options are both set for now.
 		if t.conf.UseTls {
@@ -107,15 +164,67 @@ func (t *TcpOutput) Init(config interface{}) (err error) {
 		t.localAddress, err = net.ResolveTCPAddr("tcp", t.conf.LocalAddress)
 	}
 
+	if t.conf.UseTls {
+		if t.tlsConf, err = NewTlsClientConfig(&t.conf.Tls); err != nil {
+			return fmt.Errorf("TLS init error: %s", err)
+		}
+	}
+
+	if t.reconnectBlock, err = NewRetryHelper(t.conf.Retries); err != nil {
+		return fmt.Errorf("can't create retry helper: %s", err)
+	}
+
 	if t.conf.KeepAlivePeriod != 0 {
 		t.keepAliveDuration = time.Duration(t.conf.KeepAlivePeriod) * time.Second
 	}
 
+	if t.conf.Signer.Name != "" {
+		if t.conf.UseFraming != nil && !*t.conf.UseFraming {
+			return errors.New("message signing requires `use_framing`")
+		}
+		if t.conf.Signer.Key == "" {
+			return errors.New("signer `hmac_key` must be specified")
+		}
+		switch t.conf.Signer.Hash {
+		case "", "md5", "sha1":
+		default:
+			return fmt.Errorf("unsupported signer `hmac_hash`: %s",
+				t.conf.Signer.Hash)
+		}
+		for version := range t.conf.SignerKeys {
+			if _, e := strconv.ParseUint(version, 10, 32); e != nil {
+				return fmt.Errorf("invalid `signer_keys` version: %s", version)
+			}
+		}
+		signer := t.conf.Signer
+		t.setSigner(&signer)
+	} else if len(t.conf.SignerKeys) > 0 || t.conf.SignerControlSigner != "" {
+		return errors.New("`signer_keys` and `signer_control_signer` require a " +
+			"`signer` name")
+	}
+
+	if t.conf.Compression != "" && t.conf.Compression != compression.None {
+		if t.conf.UseFraming != nil && !*t.conf.UseFraming {
+			return errors.New("compression requires `use_framing`")
+		}
+		t.compressor, err = compression.NewCompressor(t.conf.Compression,
+			t.conf.CompressionLevel)
+		if err != nil {
+			return fmt.Errorf("invalid `compression`: %s", err)
+		}
+	}
+
 	return
 }
 
 func (t *TcpOutput) Prepare(or OutputRunner, h PluginHelper) (err error) {
-	if t.conf.UseFraming == nil {
+	if t.signer != nil || t.compressor != nil {
+		// We frame the records ourselves so the header can be signed and
+		// the message compressed inside the framing.
+		if or.UsesFraming() {
+			or.SetUseFraming(false)
+		}
+	} else if t.conf.UseFraming == nil {
 		// Nothing was specified, we'll default to framing IFF ProtobufEncoder
 		// is being used.
 		if _, ok := or.Encoder().(*ProtobufEncoder); ok {
@@ -125,6 +234,10 @@ func (t *TcpOutput) Prepare(or OutputRunner, h PluginHelper) (err error) {
 
 	t.pConfig = h.PipelineConfig()
 	t.or = or
+	t.stopChan = or.StopChan()
+	if t.tlsConf != nil {
+		t.tlsConf.WatchReload()
+	}
 
 	return nil
 }
@@ -138,14 +251,37 @@ func (t *TcpOutput) cleanupConn() {
 
 func (t *TcpOutput) CleanUp() {
 	t.cleanupConn()
+	if t.tlsConf != nil {
+		t.tlsConf.Close()
+	}
 }
 
 func (t *TcpOutput) ProcessMessage(pack *PipelinePack) (err error) {
+	if t.signer != nil && t.conf.SignerControlSigner != "" &&
+		pack.Message.GetType() == t.conf.SignerControlType {
+		// Control messages are consumed, not forwarded.
+		if pack.Signer != t.conf.SignerControlSigner {
+			err = fmt.Errorf("ignoring signer control message not signed by '%s'",
+				t.conf.SignerControlSigner)
+		} else {
+			err = t.rotateSigner(pack.Message)
+		}
+		t.or.UpdateCursor(pack.QueueCursor)
+		return err
+	}
+
 	if t.connection == nil {
 		if err = t.connect(); err != nil {
 			// Explicitly set t.connection to nil because Go, see
 			// http://golang.org/doc/faq#nil_error.
 			t.connection = nil
+			// Back off before the message is retried so an unreachable
+			// endpoint isn't hammered with connection attempts. The pack
+			// isn't acknowledged, so it stays buffered in the meantime.
+			if e := t.reconnectBlock.WaitOrStop(t.stopChan); e == ErrMaxRetriesExceeded {
+				return NewPluginExitError("can't connect to %s, giving up: %s",
+					t.address, err)
+			}
 			return NewRetryMessageError("can't connect: %s", err)
 		}
 	}
@@ -159,6 +295,25 @@ func (t *TcpOutput) ProcessMessage(pack *PipelinePack) (err error) {
 		atomic.AddInt64(&t.dropMessageCount, 1)
 		return fmt.Errorf("can't encode: %s", err)
 	}
+	if t.compressor != nil && record != nil {
+		var compressed []byte
+		if compressed, err = t.compressor.Compress(record); err != nil {
+			atomic.AddInt64(&t.dropMessageCount, 1)
+			return fmt.Errorf("can't compress: %s", err)
+		}
+		// Small messages can grow, the receiver copes with either.
+		if len(compressed) < len(record) {
+			record = compressed
+		}
+	}
+	if (t.signer != nil || t.compressor != nil) && record != nil {
+		var framed []byte
+		if err = client.CreateHekaStream(record, &framed, t.signer); err != nil {
+			atomic.AddInt64(&t.dropMessageCount, 1)
+			return fmt.Errorf("can't frame: %s", err)
+		}
+		record = framed
+	}
 
 	if n, err = t.connection.Write(record); err != nil {
 		t.cleanupConn()
@@ -169,6 +324,7 @@ func (t *TcpOutput) ProcessMessage(pack *PipelinePack) (err error) {
 	} else {
 		atomic.AddInt64(&t.processMessageCount, 1)
 		t.or.UpdateCursor(pack.QueueCursor)
+		t.reconnectBlock.Reset()
 		if t.conf.ReconnectAfter > 0 &&
 			atomic.LoadInt64(&t.processMessageCount)%t.conf.ReconnectAfter == 0 {
 
@@ -184,15 +340,15 @@ func (t *TcpOutput) connect() (err error) {
 
 	if t.conf.UseTls {
 		var goTlsConf *tls.Config
-		if goTlsConf, err = CreateGoTlsConfig(&t.conf.Tls); err != nil {
-			return fmt.Errorf("TLS init error: %s", err)
+		if goTlsConf, err = t.tlsConf.Config(); err != nil {
+			return
 		}
 		// We should use DialWithDialer but its not in GOLANG release yet.
 		// https://code.google.com/p/go/source/detail?r=3d37606fb79393f22a69573afe31f0b0cd4866e3&name=default
 		// t.connection, err = tls.DialWithDialer(dialer, "tcp", t.address, goTlsConf)
 		t.connection, err = tls.Dial("tcp", t.address, goTlsConf)
 	} else {
-		t.connection, err = dialer.Dial("tcp", t.address)
+		t.connection, err = dialer.Dial(t.conf.Net, t.address)
 	}
 	if err == nil && t.conf.KeepAlive {
 		tcpConn, ok := t.connection.(*net.TCPConn)
@@ -208,6 +364,54 @@ func (t *TcpOutput) connect() (err error) {
 	return
 }
 
+func (t *TcpOutput) setSigner(signer *message.MessageSigningConfig) {
+	t.signer = signer
+	atomic.StoreInt64(&t.signerVersion, int64(signer.Version))
+}
+
+// Switches signing to the key version in the control message's `version`
+// field. Only the configured `signer` version and `signer_keys` can be
+// switched to, keys are never taken from the message itself. The signer name
+// and hash function remain the same.
+func (t *TcpOutput) rotateSigner(msg *message.Message) error {
+	val, ok := msg.GetFieldValue("version")
+	if !ok {
+		return errors.New("signer control message has no `version` field")
+	}
+	var version uint32
+	switch v := val.(type) {
+	case int64:
+		version = uint32(v)
+	case float64:
+		version = uint32(v)
+	case string:
+		parsed, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid signer control message version: %s", v)
+		}
+		version = uint32(parsed)
+	default:
+		return fmt.Errorf("invalid signer control message version: %v", val)
+	}
+
+	key, ok := t.conf.SignerKeys[strconv.FormatUint(uint64(version), 10)]
+	if !ok {
+		if version != t.conf.Signer.Version {
+			return fmt.Errorf("no signing key for version %d", version)
+		}
+		key = t.conf.Signer.Key
+	}
+
+	t.setSigner(&message.MessageSigningConfig{
+		Name:    t.signer.Name,
+		Hash:    t.signer.Hash,
+		Key:     key,
+		Version: version,
+	})
+	t.or.LogMessage(fmt.Sprintf("switched to signing key version %d", version))
+	return nil
+}
+
 // Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
 // information to the Heka report and dashboard.
 func (t *TcpOutput) ReportMsg(msg *message.Message) error {
@@ -218,6 +422,10 @@ func (t *TcpOutput) ReportMsg(msg *message.Message) error {
 		atomic.LoadInt64(&t.processMessageCount), "count")
 	message.NewInt64Field(msg, "DropMessageCount",
 		atomic.LoadInt64(&t.dropMessageCount), "count")
+	if t.conf.Signer.Name != "" {
+		message.NewInt64Field(msg, "SignerKeyVersion",
+			atomic.LoadInt64(&t.signerVersion), "")
+	}
 
 	return nil
 }
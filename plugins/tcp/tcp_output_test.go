@@ -16,13 +16,18 @@
 package tcp
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
 	"io/ioutil"
 	"net"
 	"os"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/compression"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/mozilla-services/heka/plugins"
@@ -56,6 +61,8 @@ func TcpOutputSpec(c gs.Context) {
 		tickChan := make(chan time.Time)
 		oth := plugins_ts.NewOutputTestHelper(ctrl)
 		oth.MockOutputRunner.EXPECT().Ticker().Return(tickChan).AnyTimes()
+		stopChan := make(chan bool)
+		oth.MockOutputRunner.EXPECT().StopChan().Return(stopChan).AnyTimes()
 		encoder := new(ProtobufEncoder)
 		encoder.SetPipelineConfig(pConfig)
 		encoder.Init(nil)
@@ -150,6 +157,8 @@ func TcpOutputSpec(c gs.Context) {
 
 		c.Specify("far end not initially listening", func() {
 			oth.MockOutputRunner.EXPECT().LogError(gomock.Any()).AnyTimes()
+			config.Retries.Delay = "1ms"
+			config.Retries.MaxJitter = "1ms"
 
 			err := tcpOutput.Init(config)
 			c.Assume(err, gs.IsNil)
@@ -209,6 +218,249 @@ func TcpOutputSpec(c gs.Context) {
 			tcpOutput.CleanUp()
 		})
 
+		c.Specify("backs off between reconnects", func() {
+			config.Retries.Delay = "1ms"
+			config.Retries.MaxJitter = "1ms"
+			config.Retries.MaxRetries = 2
+
+			oth.MockOutputRunner.EXPECT().Encoder().Return(encoder)
+			oth.MockOutputRunner.EXPECT().SetUseFraming(true)
+
+			c.Specify("and gives up after max_retries", func() {
+				err := tcpOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				err = tcpOutput.Prepare(oth.MockOutputRunner, oth.MockHelper)
+				c.Assume(err, gs.IsNil)
+
+				for i := 0; i < 2; i++ {
+					err = tcpOutput.ProcessMessage(pack)
+					_, ok := err.(RetryMessageError)
+					c.Expect(ok, gs.IsTrue)
+				}
+				err = tcpOutput.ProcessMessage(pack)
+				_, ok := err.(PluginExitError)
+				c.Expect(ok, gs.IsTrue)
+				c.Expect(atomic.LoadInt64(&tcpOutput.processMessageCount), gs.Equals,
+					int64(0))
+			})
+
+			c.Specify("and stops waiting when the output is stopped", func() {
+				config.Retries.Delay = "1m"
+				err := tcpOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				err = tcpOutput.Prepare(oth.MockOutputRunner, oth.MockHelper)
+				c.Assume(err, gs.IsNil)
+
+				close(stopChan)
+				start := time.Now()
+				err = tcpOutput.ProcessMessage(pack)
+				_, ok := err.(RetryMessageError)
+				c.Expect(ok, gs.IsTrue)
+				c.Expect(time.Since(start) < time.Second, gs.IsTrue)
+			})
+		})
+
+		c.Specify("with a signer", func() {
+			config.Signer = message.MessageSigningConfig{
+				Name:    "dc1",
+				Hash:    "sha1",
+				Key:     "key1",
+				Version: 1,
+			}
+			config.SignerKeys = map[string]string{"2": "key2"}
+			config.SignerControlSigner = "ops"
+
+			c.Specify("rejects disabled framing", func() {
+				useFraming := false
+				config.UseFraming = &useFraming
+				err := tcpOutput.Init(config)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("rejects invalid key versions", func() {
+				config.SignerKeys = map[string]string{"two": "key2"}
+				err := tcpOutput.Init(config)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("signs the records and switches keys", func() {
+				ln, err := net.Listen("tcp", "localhost:9125")
+				c.Assume(err, gs.IsNil)
+				defer ln.Close()
+				ch := make(chan []byte, 2)
+				go func() {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+					for i := 0; i < 2; i++ {
+						b := make([]byte, 1000)
+						n, _ := conn.Read(b)
+						ch <- b[:n]
+					}
+				}()
+
+				err = tcpOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				oth.MockOutputRunner.EXPECT().UsesFraming().Return(false)
+				err = tcpOutput.Prepare(oth.MockOutputRunner, oth.MockHelper)
+				c.Assume(err, gs.IsNil)
+
+				checkRecord := func(record []byte, key string, version uint32) {
+					c.Assume(len(record) > message.HEADER_FRAMING_SIZE, gs.IsTrue)
+					headerSize := int(record[1])
+					headerEnd := message.HEADER_DELIMITER_SIZE + headerSize
+					header := new(message.Header)
+					ok, err := message.DecodeHeader(record[2:headerEnd+1], header)
+					c.Assume(err, gs.IsNil)
+					c.Assume(ok, gs.IsTrue)
+					c.Expect(header.GetHmacSigner(), gs.Equals, "dc1")
+					c.Expect(header.GetHmacKeyVersion(), gs.Equals, version)
+					c.Expect(header.GetHmacHashFunction(), gs.Equals, message.Header_SHA1)
+					hm := hmac.New(sha1.New, []byte(key))
+					hm.Write(record[headerEnd+1:])
+					c.Expect(hmac.Equal(hm.Sum(nil), header.GetHmac()), gs.IsTrue)
+					c.Expect(string(record[headerEnd+1:]), gs.Equals, string(matchBytes))
+				}
+
+				oth.MockOutputRunner.EXPECT().Encode(pack).Return(matchBytes, nil).Times(2)
+				oth.MockOutputRunner.EXPECT().UpdateCursor(pack.QueueCursor).Times(3)
+				err = tcpOutput.ProcessMessage(pack)
+				c.Expect(err, gs.IsNil)
+				checkRecord(<-ch, "key1", 1)
+
+				control := NewPipelinePack(nil)
+				control.Message.SetType("heka.signer.rotate")
+				control.Signer = "ops"
+				message.NewInt64Field(control.Message, "version", 2, "")
+				control.QueueCursor = pack.QueueCursor
+				oth.MockOutputRunner.EXPECT().LogMessage(gomock.Any())
+				err = tcpOutput.ProcessMessage(control)
+				c.Expect(err, gs.IsNil)
+				c.Expect(atomic.LoadInt64(&tcpOutput.signerVersion), gs.Equals, int64(2))
+
+				err = tcpOutput.ProcessMessage(pack)
+				c.Expect(err, gs.IsNil)
+				checkRecord(<-ch, "key2", 2)
+				tcpOutput.CleanUp()
+			})
+
+			c.Specify("rejects control messages for unknown key versions", func() {
+				err := tcpOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				oth.MockOutputRunner.EXPECT().UsesFraming().Return(false)
+				err = tcpOutput.Prepare(oth.MockOutputRunner, oth.MockHelper)
+				c.Assume(err, gs.IsNil)
+
+				control := NewPipelinePack(nil)
+				control.Message.SetType("heka.signer.rotate")
+				control.Signer = "ops"
+				message.NewInt64Field(control.Message, "version", 3, "")
+				oth.MockOutputRunner.EXPECT().UpdateCursor(gomock.Any())
+				err = tcpOutput.ProcessMessage(control)
+				c.Expect(err, gs.Not(gs.IsNil))
+				c.Expect(tcpOutput.signer.Key, gs.Equals, "key1")
+
+				c.Specify("even if the message provides a key", func() {
+					message.NewStringField(control.Message, "hmac_key", "key3")
+					oth.MockOutputRunner.EXPECT().UpdateCursor(gomock.Any())
+					err = tcpOutput.ProcessMessage(control)
+					c.Expect(err, gs.Not(gs.IsNil))
+					c.Expect(tcpOutput.signer.Key, gs.Equals, "key1")
+				})
+			})
+
+			c.Specify("ignores control messages from other signers", func() {
+				err := tcpOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				oth.MockOutputRunner.EXPECT().UsesFraming().Return(false)
+				err = tcpOutput.Prepare(oth.MockOutputRunner, oth.MockHelper)
+				c.Assume(err, gs.IsNil)
+
+				control := NewPipelinePack(nil)
+				control.Message.SetType("heka.signer.rotate")
+				message.NewInt64Field(control.Message, "version", 2, "")
+				oth.MockOutputRunner.EXPECT().UpdateCursor(gomock.Any()).Times(2)
+				err = tcpOutput.ProcessMessage(control)
+				c.Expect(err, gs.Not(gs.IsNil))
+				control.Signer = "dev"
+				err = tcpOutput.ProcessMessage(control)
+				c.Expect(err, gs.Not(gs.IsNil))
+				c.Expect(tcpOutput.signer.Key, gs.Equals, "key1")
+				c.Expect(atomic.LoadInt64(&tcpOutput.signerVersion), gs.Equals, int64(1))
+			})
+
+			c.Specify("requires a signer for signer_control_signer", func() {
+				config.Signer = message.MessageSigningConfig{}
+				config.SignerKeys = nil
+				err := tcpOutput.Init(config)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+
+		c.Specify("with compression", func() {
+			config.Compression = compression.Zstd
+
+			c.Specify("rejects disabled framing", func() {
+				useFraming := false
+				config.UseFraming = &useFraming
+				err := tcpOutput.Init(config)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("rejects an invalid level", func() {
+				config.CompressionLevel = 23
+				err := tcpOutput.Init(config)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("compresses the message inside the framing", func() {
+				ln, err := net.Listen("tcp", "localhost:9125")
+				c.Assume(err, gs.IsNil)
+				defer ln.Close()
+				ch := make(chan []byte, 1)
+				go func() {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+					b := make([]byte, 2000)
+					n, _ := conn.Read(b)
+					ch <- b[:n]
+				}()
+
+				err = tcpOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+				oth.MockOutputRunner.EXPECT().UsesFraming().Return(true)
+				oth.MockOutputRunner.EXPECT().SetUseFraming(false)
+				err = tcpOutput.Prepare(oth.MockOutputRunner, oth.MockHelper)
+				c.Assume(err, gs.IsNil)
+
+				pack.Message.SetPayload(strings.Repeat("compress me ", 100))
+				msgBytes, err := proto.Marshal(pack.Message)
+				c.Assume(err, gs.IsNil)
+				oth.MockOutputRunner.EXPECT().Encode(pack).Return(msgBytes, nil)
+				oth.MockOutputRunner.EXPECT().UpdateCursor(pack.QueueCursor)
+				err = tcpOutput.ProcessMessage(pack)
+				c.Expect(err, gs.IsNil)
+
+				record := <-ch
+				c.Assume(len(record) > message.HEADER_FRAMING_SIZE, gs.IsTrue)
+				headerEnd := message.HEADER_DELIMITER_SIZE + int(record[1])
+				body := record[headerEnd+1:]
+				c.Expect(len(body) < len(msgBytes), gs.IsTrue)
+				c.Expect(compression.Detect(body), gs.Equals, compression.Zstd)
+				var d compression.Decompressor
+				decompressed, err := d.Decompress(nil, body, compression.Zstd,
+					int64(message.MAX_MESSAGE_SIZE))
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(decompressed), gs.Equals, string(msgBytes))
+				tcpOutput.CleanUp()
+			})
+		})
+
 		// c.Specify("Overload queue drops messages", func() {
 		// 	config.QueueFullAction = "drop"
 		// 	config.QueueMaxBufferSize = uint64(1)
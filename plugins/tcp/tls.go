@@ -20,6 +20,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/rafrombrc/go-notify"
 )
 
 var ciphers map[string]uint16 = map[string]uint16{
@@ -159,3 +164,71 @@ func certPoolFromFile(pemfile string) (*x509.CertPool, error) {
 	}
 	return nil, fmt.Errorf("No PEM encoded certificates found in: %s\n", pemfile)
 }
+
+// Caches the Go TLS config built from a TlsConfig so that clients which
+// reconnect don't reread their cert and CA files on every connection attempt,
+// which is wasteful for large CA bundles and can pick up half rotated files.
+// Once WatchReload has been called the config is rebuilt on the next call to
+// Config after hekad receives a SIGHUP.
+type TlsClientConfig struct {
+	tomlConf *TlsConfig
+	goConf   *tls.Config
+	lock     sync.Mutex
+	reload   int32
+	hupChan  chan interface{}
+}
+
+// Builds the Go TLS config right away, so config errors surface at Init time.
+func NewTlsClientConfig(tomlConf *TlsConfig) (c *TlsClientConfig, err error) {
+	c = &TlsClientConfig{tomlConf: tomlConf}
+	if c.goConf, err = CreateGoTlsConfig(tomlConf); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Returns the cached Go TLS config, rebuilding it first if a reload was
+// requested. If the rebuild fails the error is returned and the reload is
+// retried on the next call.
+func (c *TlsClientConfig) Config() (*tls.Config, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if atomic.LoadInt32(&c.reload) == 1 {
+		goConf, err := CreateGoTlsConfig(c.tomlConf)
+		if err != nil {
+			return nil, fmt.Errorf("TLS reload error: %s", err)
+		}
+		c.goConf = goConf
+		atomic.StoreInt32(&c.reload, 0)
+	}
+	return c.goConf, nil
+}
+
+// Requests that the config be rebuilt on the next call to Config.
+func (c *TlsClientConfig) Reload() {
+	atomic.StoreInt32(&c.reload, 1)
+}
+
+// Starts listening for hekad's reload notification.
+func (c *TlsClientConfig) WatchReload() {
+	if c.hupChan != nil {
+		return
+	}
+	c.hupChan = make(chan interface{})
+	notify.Start(RELOAD, c.hupChan)
+	go func(hupChan chan interface{}) {
+		for _ = range hupChan {
+			c.Reload()
+		}
+	}(c.hupChan)
+}
+
+// Stops listening for hekad's reload notification.
+func (c *TlsClientConfig) Close() {
+	if c.hupChan == nil {
+		return
+	}
+	notify.Stop(RELOAD, c.hupChan)
+	close(c.hupChan)
+	c.hupChan = nil
+}
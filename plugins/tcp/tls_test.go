@@ -17,8 +17,15 @@ package tcp
 import (
 	"crypto/tls"
 	"encoding/hex"
-	gs "github.com/rafrombrc/gospec/src/gospec"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/rafrombrc/go-notify"
+	gs "github.com/rafrombrc/gospec/src/gospec"
 )
 
 func TlsSpec(c gs.Context) {
@@ -124,4 +131,76 @@ func TlsSpec(c gs.Context) {
 		})
 
 	})
+
+	c.Specify("A TlsClientConfig", func() {
+		tmpDir, err := ioutil.TempDir("", "tls-client-config")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(tmpDir)
+
+		copyFile := func(name string) {
+			data, err := ioutil.ReadFile(filepath.Join("testsupport", name))
+			c.Assume(err, gs.IsNil)
+			err = ioutil.WriteFile(filepath.Join(tmpDir, name), data, 0644)
+			c.Assume(err, gs.IsNil)
+		}
+		removeFiles := func() {
+			for _, name := range []string{"cert.pem", "key.pem"} {
+				os.Remove(filepath.Join(tmpDir, name))
+			}
+		}
+		copyFile("cert.pem")
+		copyFile("key.pem")
+		tomlConf.CertFile = filepath.Join(tmpDir, "cert.pem")
+		tomlConf.KeyFile = filepath.Join(tmpDir, "key.pem")
+		tomlConf.RootCAs = filepath.Join(tmpDir, "cert.pem")
+
+		clientConf, err := NewTlsClientConfig(tomlConf)
+		c.Assume(err, gs.IsNil)
+		defer clientConf.Close()
+
+		c.Specify("doesn't reread the cert files", func() {
+			goConf, err = clientConf.Config()
+			c.Expect(err, gs.IsNil)
+			removeFiles()
+			for i := 0; i < 3; i++ {
+				again, err := clientConf.Config()
+				c.Expect(err, gs.IsNil)
+				c.Expect(again, gs.Equals, goConf)
+			}
+		})
+
+		c.Specify("rebuilds the config after a reload", func() {
+			goConf, err = clientConf.Config()
+			c.Assume(err, gs.IsNil)
+			removeFiles()
+			clientConf.Reload()
+			_, err = clientConf.Config()
+			c.Expect(err, gs.Not(gs.IsNil))
+
+			// The reload is retried until it succeeds.
+			copyFile("cert.pem")
+			copyFile("key.pem")
+			again, err := clientConf.Config()
+			c.Expect(err, gs.IsNil)
+			c.Expect(again == goConf, gs.IsFalse)
+			c.Expect(len(again.RootCAs.Subjects()), gs.Equals, 1)
+		})
+
+		c.Specify("reloads on hekad's reload notification", func() {
+			goConf, err = clientConf.Config()
+			c.Assume(err, gs.IsNil)
+			clientConf.WatchReload()
+			err = notify.Post(RELOAD, nil)
+			c.Assume(err, gs.IsNil)
+
+			var again *tls.Config
+			for i := 0; i < 100; i++ {
+				if again, _ = clientConf.Config(); again != goConf {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			c.Expect(again == goConf, gs.IsFalse)
+		})
+	})
 }
@@ -0,0 +1,234 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Last known value for a single key.
+type transitionState struct {
+	key       string
+	value     string
+	changedAt int64     // Timestamp of the message that set the value, in ns.
+	lastSeen  time.Time // Wall clock time of the most recent message.
+	elem      *list.Element
+}
+
+// A single value change, ready to be emitted.
+type transition struct {
+	key       string
+	oldValue  string
+	newValue  string
+	timestamp int64 // Timestamp of the message with the new value, in ns.
+	duration  int64 // How long the old value was held, in ns.
+	initial   bool  // Whether this is the first value seen for the key.
+}
+
+// Filter that tracks the last value of a field for each key and emits a
+// message only when the value changes, turning a stream of periodic status
+// samples into a stream of change events.
+type TransitionFilter struct {
+	conf   *TransitionFilterConfig
+	ttl    time.Duration
+	states map[string]*transitionState
+	// States ordered from least to most recently seen, used for both TTL
+	// expiration and eviction when max_keys is reached.
+	lru *list.List
+}
+
+// TransitionFilter config struct.
+type TransitionFilterConfig struct {
+	// Name of the message field holding the tracked value. Required.
+	ValueField string `toml:"value_field"`
+	// Message fields whose values are joined together to identify each
+	// tracked key. Supports "Type", "Logger", "Hostname", and any dynamic
+	// field name. Defaults to a single key for all messages.
+	KeyFields []string `toml:"key_fields"`
+	// Maximum number of keys to track. When this is exceeded the least
+	// recently seen key is forgotten. Defaults to 10000.
+	MaxKeys int `toml:"max_keys"`
+	// Number of seconds a key can go without receiving any messages before
+	// it's forgotten. Defaults to 3600, 0 means keys never expire.
+	Ttl uint `toml:"ttl"`
+	// Whether to emit a message for the first value seen for each key.
+	// Defaults to false.
+	EmitInitial bool `toml:"emit_initial"`
+	// Type to use for the emitted transition messages. Defaults to
+	// "heka.transition".
+	MessageType string `toml:"message_type"`
+	// Defaults to 60 second intervals.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+func (this *TransitionFilter) ConfigStruct() interface{} {
+	return &TransitionFilterConfig{
+		MaxKeys:        10000,
+		Ttl:            3600,
+		MessageType:    "heka.transition",
+		TickerInterval: uint(60),
+	}
+}
+
+func (this *TransitionFilter) Init(config interface{}) (err error) {
+	this.conf = config.(*TransitionFilterConfig)
+	if this.conf.ValueField == "" {
+		return errors.New("`value_field` must be specified")
+	}
+	if this.conf.MaxKeys < 1 {
+		return errors.New("`max_keys` must be greater than zero")
+	}
+	this.ttl = time.Duration(this.conf.Ttl) * time.Second
+	this.states = make(map[string]*transitionState)
+	this.lru = list.New()
+	return
+}
+
+func (this *TransitionFilter) Run(fr FilterRunner, h PluginHelper) (err error) {
+	inChan := fr.InChan()
+	ticker := fr.Ticker()
+
+	var (
+		ok           = true
+		pack         *PipelinePack
+		t            *transition
+		msgLoopCount uint
+	)
+	for ok {
+		select {
+		case pack, ok = <-inChan:
+			if !ok {
+				break
+			}
+			msgLoopCount = pack.MsgLoopCount
+			t = this.addMessage(pack.Message, time.Now())
+			fr.UpdateCursor(pack.QueueCursor)
+			// Recycled before emitting, so we never wait on the pool for a
+			// new pack while holding one.
+			pack.Recycle(nil)
+			if t != nil {
+				this.emit(fr, h, t, msgLoopCount)
+			}
+		case <-ticker:
+			this.expire(time.Now())
+		}
+	}
+	return
+}
+
+func (this *TransitionFilter) CleanupForRestart() {
+	this.states = make(map[string]*transitionState)
+	this.lru.Init()
+}
+
+// Records the message's value for its key, returning a transition if the
+// value changed.
+func (this *TransitionFilter) addMessage(msg *message.Message,
+	now time.Time) (t *transition) {
+
+	val, ok := msg.GetFieldValue(this.conf.ValueField)
+	if !ok {
+		return
+	}
+	value, ok := val.(string)
+	if !ok {
+		value = fmt.Sprint(val)
+	}
+	key := messageKey(msg, this.conf.KeyFields)
+	ts := msg.GetTimestamp()
+
+	s, ok := this.states[key]
+	if !ok {
+		if len(this.states) >= this.conf.MaxKeys {
+			this.remove(this.lru.Front().Value.(*transitionState))
+		}
+		s = &transitionState{key: key, value: value, changedAt: ts}
+		s.elem = this.lru.PushBack(s)
+		this.states[key] = s
+		if this.conf.EmitInitial {
+			t = &transition{key: key, newValue: value, timestamp: ts, initial: true}
+		}
+	} else {
+		this.lru.MoveToBack(s.elem)
+		if value != s.value {
+			t = &transition{
+				key:       key,
+				oldValue:  s.value,
+				newValue:  value,
+				timestamp: ts,
+				duration:  ts - s.changedAt,
+			}
+			s.value = value
+			s.changedAt = ts
+		}
+	}
+	s.lastSeen = now
+	return
+}
+
+// Forgets all of the keys that haven't been seen within the TTL.
+func (this *TransitionFilter) expire(now time.Time) {
+	if this.ttl == 0 {
+		return
+	}
+	for e := this.lru.Front(); e != nil; e = this.lru.Front() {
+		s := e.Value.(*transitionState)
+		if now.Sub(s.lastSeen) < this.ttl {
+			break
+		}
+		this.remove(s)
+	}
+}
+
+func (this *TransitionFilter) remove(s *transitionState) {
+	this.lru.Remove(s.elem)
+	delete(this.states, s.key)
+}
+
+func (this *TransitionFilter) emit(fr FilterRunner, h PluginHelper, t *transition,
+	msgLoopCount uint) {
+
+	pack, e := h.PipelinePack(msgLoopCount)
+	if e != nil {
+		fr.LogError(e)
+		return
+	}
+	pack.Message.SetLogger(fr.Name())
+	pack.Message.SetType(this.conf.MessageType)
+	if t.initial {
+		pack.Message.SetPayload(fmt.Sprintf("%s: %s", t.key, t.newValue))
+	} else {
+		pack.Message.SetPayload(fmt.Sprintf("%s: %s -> %s", t.key, t.oldValue,
+			t.newValue))
+		message.NewStringField(pack.Message, "old_value", t.oldValue)
+		message.NewInt64Field(pack.Message, "duration", t.duration, "ns")
+	}
+	message.NewStringField(pack.Message, "key", t.key)
+	message.NewStringField(pack.Message, "new_value", t.newValue)
+	message.NewInt64Field(pack.Message, "transition_timestamp", t.timestamp, "ns")
+	fr.Inject(pack)
+}
+
+func init() {
+	RegisterPlugin("TransitionFilter", func() interface{} {
+		return new(TransitionFilter)
+	})
+}
@@ -0,0 +1,102 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func TransitionFilterSpec(c gs.Context) {
+	newMsg := func(host, status string, ts int64) *message.Message {
+		msg := pipeline_ts.GetTestMessage()
+		msg.SetHostname(host)
+		msg.SetTimestamp(ts)
+		message.NewStringField(msg, "status", status)
+		return msg
+	}
+
+	c.Specify("A TransitionFilter", func() {
+		filter := new(TransitionFilter)
+		config := filter.ConfigStruct().(*TransitionFilterConfig)
+		config.ValueField = "status"
+		config.KeyFields = []string{"Hostname"}
+		now := time.Now()
+
+		c.Specify("requires a value field", func() {
+			config.ValueField = ""
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("only reports value changes", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(filter.addMessage(newMsg("a", "up", 10), now), gs.IsNil)
+			c.Expect(filter.addMessage(newMsg("a", "up", 20), now), gs.IsNil)
+			c.Expect(filter.addMessage(newMsg("b", "down", 20), now), gs.IsNil)
+
+			t := filter.addMessage(newMsg("a", "down", 30), now)
+			c.Assume(t, gs.Not(gs.IsNil))
+			c.Expect(t.key, gs.Equals, "a")
+			c.Expect(t.oldValue, gs.Equals, "up")
+			c.Expect(t.newValue, gs.Equals, "down")
+			c.Expect(t.duration, gs.Equals, int64(20))
+			c.Expect(filter.addMessage(newMsg("a", "down", 40), now), gs.IsNil)
+			c.Expect(filter.addMessage(newMsg("b", "down", 40), now), gs.IsNil)
+		})
+
+		c.Specify("optionally reports the initial value", func() {
+			config.EmitInitial = true
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			t := filter.addMessage(newMsg("a", "up", 10), now)
+			c.Assume(t, gs.Not(gs.IsNil))
+			c.Expect(t.initial, gs.IsTrue)
+			c.Expect(t.newValue, gs.Equals, "up")
+		})
+
+		c.Specify("evicts the least recently seen key", func() {
+			config.MaxKeys = 2
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", "up", 10), now)
+			filter.addMessage(newMsg("b", "up", 10), now)
+			filter.addMessage(newMsg("a", "up", 20), now)
+			filter.addMessage(newMsg("c", "up", 20), now)
+			c.Expect(len(filter.states), gs.Equals, 2)
+			_, ok := filter.states["b"]
+			c.Expect(ok, gs.IsFalse)
+			// A new value for an evicted key is treated as the first one.
+			c.Expect(filter.addMessage(newMsg("b", "down", 30), now), gs.IsNil)
+		})
+
+		c.Specify("expires idle keys", func() {
+			config.Ttl = 60
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.addMessage(newMsg("a", "up", 10), now.Add(-2*time.Minute))
+			filter.addMessage(newMsg("b", "up", 10), now)
+			filter.expire(now)
+			c.Expect(len(filter.states), gs.Equals, 1)
+			_, ok := filter.states["a"]
+			c.Expect(ok, gs.IsFalse)
+			c.Expect(filter.lru.Len(), gs.Equals, 1)
+		})
+	})
+}
@@ -24,6 +24,7 @@ import (
 	"strings"
 
 	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/mozilla-services/heka/plugins/tcp"
 )
 
 // Input plugin implementation that listens for Heka protocol messages on a
@@ -45,6 +46,9 @@ type UdpInputConfig struct {
 	// String representation of the address of the network connection on which
 	// the listener should be listening (e.g. "127.0.0.1:5565").
 	Address string
+	// Optional name of the network interface (e.g. "eth1") the listener
+	// should be bound to. Only supported for IP addresses.
+	Interface string `toml:"interface"`
 	// Set Hostname field from remote address
 	SetHostname bool `toml:"set_hostname"`
 }
@@ -64,6 +68,12 @@ func (u *UdpInput) ConfigStruct() interface{} {
 func (u *UdpInput) Init(config interface{}) (err error) {
 	u.config = config.(*UdpInputConfig)
 
+	if u.config.Interface != "" && (u.config.Net == "unixgram" ||
+		strings.HasPrefix(u.config.Address, "fd:")) {
+		return errors.New(
+			"Can only bind to an interface when listening on an IP address.")
+	}
+
 	if u.config.Net == "unixgram" {
 		if runtime.GOOS == "windows" {
 			return errors.New(
@@ -113,7 +123,14 @@ func (u *UdpInput) Init(config interface{}) (err error) {
 		}
 	} else {
 		// IP address
-		udpAddr, err := net.ResolveUDPAddr(u.config.Net, u.config.Address)
+		addrStr := u.config.Address
+		if u.config.Interface != "" {
+			if addrStr, err = tcp.InterfaceAddress(u.config.Interface, addrStr,
+				u.config.Net); err != nil {
+				return err
+			}
+		}
+		udpAddr, err := net.ResolveUDPAddr(u.config.Net, addrStr)
 		if err != nil {
 			return fmt.Errorf("ResolveUDPAddr failed: %s\n", err.Error())
 		}
@@ -19,7 +19,9 @@ import (
 	"fmt"
 	"net"
 	"runtime"
+	"sync/atomic"
 
+	"github.com/mozilla-services/heka/message"
 	"github.com/mozilla-services/heka/pipeline"
 )
 
@@ -27,6 +29,10 @@ import (
 type UdpOutput struct {
 	*UdpOutputConfig
 	conn net.Conn
+
+	processMessageCount   int64
+	oversizedMessageCount int64
+	writeErrorCount       int64
 }
 
 // This is our plugin's config struct
@@ -124,13 +130,16 @@ func (o *UdpOutput) Run(or pipeline.OutputRunner, h pipeline.PluginHelper) (err
 		} else if outBytes != nil {
 			msgSize := len(outBytes)
 			if msgSize > o.UdpOutputConfig.MaxMessageSize {
+				atomic.AddInt64(&o.oversizedMessageCount, 1)
 				or.UpdateCursor(pack.QueueCursor)
 				e = fmt.Errorf("Message has exceeded allowed UDP data size: %d > %d",
 					msgSize, o.UdpOutputConfig.MaxMessageSize)
 				pack.Recycle(e)
 				continue
+			} else if _, e = o.conn.Write(outBytes); e != nil {
+				atomic.AddInt64(&o.writeErrorCount, 1)
 			} else {
-				o.conn.Write(outBytes)
+				atomic.AddInt64(&o.processMessageCount, 1)
 			}
 		}
 		or.UpdateCursor(pack.QueueCursor)
@@ -139,6 +148,18 @@ func (o *UdpOutput) Run(or pipeline.OutputRunner, h pipeline.PluginHelper) (err
 	return
 }
 
+// Satisfies the `pipeline.ReportingPlugin` interface to provide plugin state
+// information to the Heka report and dashboard.
+func (o *UdpOutput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ProcessMessageCount",
+		atomic.LoadInt64(&o.processMessageCount), "count")
+	message.NewInt64Field(msg, "OversizedMessageCount",
+		atomic.LoadInt64(&o.oversizedMessageCount), "count")
+	message.NewInt64Field(msg, "WriteErrorCount",
+		atomic.LoadInt64(&o.writeErrorCount), "count")
+	return nil
+}
+
 func init() {
 	pipeline.RegisterPlugin("UdpOutput", func() interface{} {
 		return new(UdpOutput)
@@ -23,6 +23,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/mozilla-services/heka/message"
 	"github.com/mozilla-services/heka/pipeline"
 	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/mozilla-services/heka/plugins"
@@ -244,6 +245,14 @@ func UdpOutputSpec(c gs.Context) {
 
 		close(inChan)
 		wg.Wait()
+
+		msg := new(message.Message)
+		c.Expect(udpOutput.ReportMsg(msg), gs.IsNil)
+		count, ok := msg.GetFieldValue("OversizedMessageCount")
+		c.Expect(ok, gs.IsTrue)
+		c.Expect(count, gs.Equals, int64(1))
+		count, _ = msg.GetFieldValue("ProcessMessageCount")
+		c.Expect(count, gs.Equals, int64(0))
 	})
 
 	c.Specify("checks validation of of Maximum message size limit", func() {
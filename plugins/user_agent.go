@@ -0,0 +1,132 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Normalized description of a user-agent string. Empty values (and a zero
+// version) mean that part couldn't be determined.
+type userAgent struct {
+	browser string
+	version float64 // Browser major version.
+	os      string
+	device  string // "Desktop", "Mobile", "Tablet", or "Spider".
+}
+
+type uaRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// Browser rules, in the order they're tried. Most browsers also claim to be
+// the browsers they're compatible with (e.g. Chrome says it's Safari, Edge
+// says it's Chrome) so the more specific rules have to come first. The first
+// subexpression, if any, is the version.
+var uaBrowserRules = []uaRule{
+	{"", regexp.MustCompile(`(?i)([a-z-]*(?:bot|spider|crawler|slurp))(?:[/ ]v?(\d+))?`)},
+	{"Edge", regexp.MustCompile(`Edge?/(\d+)`)},
+	{"Opera", regexp.MustCompile(`OPR/(\d+)`)},
+	{"Opera", regexp.MustCompile(`Opera.*Version/(\d+)`)},
+	{"Opera", regexp.MustCompile(`Opera[/ ](\d+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d+)`)},
+	{"IE", regexp.MustCompile(`MSIE (\d+)`)},
+	{"IE", regexp.MustCompile(`Trident/.*rv:(\d+)`)},
+	{"Safari", regexp.MustCompile(`Version/(\d+).*Safari/`)},
+	{"curl", regexp.MustCompile(`^curl/(\d+)`)},
+	{"Wget", regexp.MustCompile(`^Wget/(\d+)`)},
+}
+
+// Windows NT kernel versions to marketing names.
+var uaWindowsVersions = map[string]string{
+	"10.0": "Windows 10",
+	"6.3":  "Windows 8.1",
+	"6.2":  "Windows 8",
+	"6.1":  "Windows 7",
+	"6.0":  "Windows Vista",
+	"5.2":  "Windows XP",
+	"5.1":  "Windows XP",
+}
+
+var (
+	uaWindowsRe   = regexp.MustCompile(`Windows NT (\d+\.\d+)`)
+	uaFirefoxOSRe = regexp.MustCompile(`\((?:Mobile|Tablet);.*Firefox/`)
+)
+
+// Parses a user-agent string into its browser, browser version, operating
+// system, and device type.
+func parseUserAgent(ua string) (agent userAgent) {
+	for _, rule := range uaBrowserRules {
+		m := rule.re.FindStringSubmatch(ua)
+		if m == nil {
+			continue
+		}
+		agent.browser = rule.name
+		if rule.name == "" {
+			// Crawlers are named by the match itself.
+			agent.browser = m[1]
+			agent.device = "Spider"
+			m = m[1:]
+		}
+		if len(m) > 1 && m[1] != "" {
+			agent.version, _ = strconv.ParseFloat(m[1], 64)
+		}
+		break
+	}
+
+	switch {
+	case strings.Contains(ua, "Android"):
+		agent.os = "Android"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"),
+		strings.Contains(ua, "iPod"):
+		agent.os = "iOS"
+	case strings.Contains(ua, "Windows Phone"):
+		agent.os = "Windows Phone"
+	case strings.Contains(ua, "Windows"):
+		agent.os = "Windows"
+		if m := uaWindowsRe.FindStringSubmatch(ua); m != nil {
+			if name, ok := uaWindowsVersions[m[1]]; ok {
+				agent.os = name
+			}
+		}
+	case strings.Contains(ua, "CrOS"):
+		agent.os = "Chrome OS"
+	case strings.Contains(ua, "Macintosh"), strings.Contains(ua, "Mac OS X"):
+		agent.os = "Mac OS X"
+	case uaFirefoxOSRe.MatchString(ua):
+		agent.os = "FirefoxOS"
+	case strings.Contains(ua, "Linux"):
+		agent.os = "Linux"
+	}
+
+	if agent.device == "" {
+		switch {
+		case strings.Contains(ua, "iPad"), strings.Contains(ua, "Tablet"),
+			agent.os == "Android" && !strings.Contains(ua, "Mobile"):
+			agent.device = "Tablet"
+		case strings.Contains(ua, "Mobi"), strings.Contains(ua, "iPhone"),
+			strings.Contains(ua, "iPod"), agent.os == "Windows Phone":
+			agent.device = "Mobile"
+		case agent.os != "":
+			agent.device = "Desktop"
+		}
+	}
+	return
+}
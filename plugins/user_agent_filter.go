@@ -0,0 +1,168 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"container/list"
+	"errors"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/pborman/uuid"
+)
+
+type cachedUserAgent struct {
+	ua    string
+	agent userAgent
+	elem  *list.Element
+}
+
+// Filter that parses a message field holding a user-agent string and injects
+// a copy of the message with the normalized browser, version, operating
+// system, and device added as fields.
+type UserAgentFilter struct {
+	conf  *UserAgentFilterConfig
+	cache map[string]*cachedUserAgent
+	// Cached results ordered from least to most recently used.
+	lru *list.List
+}
+
+// UserAgentFilter config struct.
+type UserAgentFilterConfig struct {
+	// Name of the message field holding the user-agent string. Defaults to
+	// "http_user_agent".
+	UserAgentField string `toml:"user_agent_field"`
+	// Prefix for the names of the added fields. Defaults to "ua_".
+	FieldPrefix string `toml:"field_prefix"`
+	// Prepended to the original message Type to give the Type of the enriched
+	// copies. Defaults to "ua.".
+	TypePrefix string `toml:"type_prefix"`
+	// Whether the enriched copies keep the original user-agent field.
+	// Defaults to true.
+	KeepUserAgent bool `toml:"keep_user_agent"`
+	// Maximum number of distinct user-agent strings to cache parse results
+	// for. Defaults to 1000, 0 disables the cache.
+	CacheSize int `toml:"cache_size"`
+}
+
+func (this *UserAgentFilter) ConfigStruct() interface{} {
+	return &UserAgentFilterConfig{
+		UserAgentField: "http_user_agent",
+		FieldPrefix:    "ua_",
+		TypePrefix:     "ua.",
+		KeepUserAgent:  true,
+		CacheSize:      1000,
+	}
+}
+
+func (this *UserAgentFilter) Init(config interface{}) (err error) {
+	this.conf = config.(*UserAgentFilterConfig)
+	if this.conf.UserAgentField == "" {
+		return errors.New("`user_agent_field` must be specified")
+	}
+	if this.conf.TypePrefix == "" {
+		return errors.New("`type_prefix` must be specified so the enriched " +
+			"messages can be told apart from the originals")
+	}
+	if this.conf.CacheSize < 0 {
+		return errors.New("`cache_size` can't be negative")
+	}
+	this.cache = make(map[string]*cachedUserAgent)
+	this.lru = list.New()
+	return
+}
+
+func (this *UserAgentFilter) Run(fr FilterRunner, h PluginHelper) (err error) {
+	for pack := range fr.InChan() {
+		if ua := this.userAgent(pack.Message); ua != "" {
+			newPack, e := h.PipelinePack(pack.MsgLoopCount)
+			if e != nil {
+				fr.LogError(e)
+			} else {
+				pack.Message.Copy(newPack.Message)
+				this.enrich(newPack.Message, this.parse(ua))
+				fr.Inject(newPack)
+			}
+		}
+		fr.UpdateCursor(pack.QueueCursor)
+		pack.Recycle(nil)
+	}
+	return
+}
+
+func (this *UserAgentFilter) CleanupForRestart() {
+	this.cache = make(map[string]*cachedUserAgent)
+	this.lru.Init()
+}
+
+// Returns the message's user-agent string, or "" if it doesn't have one.
+func (this *UserAgentFilter) userAgent(msg *message.Message) string {
+	val, ok := msg.GetFieldValue(this.conf.UserAgentField)
+	if !ok {
+		return ""
+	}
+	ua, _ := val.(string)
+	return ua
+}
+
+// Turns a copy of the original message into the enriched message by giving
+// it a new UUID and Type and adding the parsed user-agent fields.
+func (this *UserAgentFilter) enrich(msg *message.Message, agent userAgent) {
+	msg.SetUuid(uuid.NewRandom())
+	msg.SetType(this.conf.TypePrefix + msg.GetType())
+	if !this.conf.KeepUserAgent {
+		msg.DeleteField(msg.FindFirstField(this.conf.UserAgentField))
+	}
+	prefix := this.conf.FieldPrefix
+	if agent.browser != "" {
+		message.NewStringField(msg, prefix+"browser", agent.browser)
+	}
+	if agent.version != 0 {
+		if f, e := message.NewField(prefix+"version", agent.version, ""); e == nil {
+			msg.AddField(f)
+		}
+	}
+	if agent.os != "" {
+		message.NewStringField(msg, prefix+"os", agent.os)
+	}
+	if agent.device != "" {
+		message.NewStringField(msg, prefix+"device", agent.device)
+	}
+}
+
+// Parses the user-agent string, using the cached result if there is one.
+func (this *UserAgentFilter) parse(ua string) userAgent {
+	if this.conf.CacheSize == 0 {
+		return parseUserAgent(ua)
+	}
+	if c, ok := this.cache[ua]; ok {
+		this.lru.MoveToBack(c.elem)
+		return c.agent
+	}
+	if len(this.cache) >= this.conf.CacheSize {
+		oldest := this.lru.Remove(this.lru.Front()).(*cachedUserAgent)
+		delete(this.cache, oldest.ua)
+	}
+	c := &cachedUserAgent{ua: ua, agent: parseUserAgent(ua)}
+	c.elem = this.lru.PushBack(c)
+	this.cache[ua] = c
+	return c.agent
+}
+
+func init() {
+	RegisterPlugin("UserAgentFilter", func() interface{} {
+		return new(UserAgentFilter)
+	})
+}
@@ -0,0 +1,135 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"github.com/mozilla-services/heka/message"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func UserAgentFilterSpec(c gs.Context) {
+	c.Specify("parseUserAgent", func() {
+		c.Specify("recognizes desktop browsers", func() {
+			agent := parseUserAgent("Mozilla/5.0 (Windows NT 6.1; WOW64) " +
+				"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2228.0 " +
+				"Safari/537.36")
+			c.Expect(agent.browser, gs.Equals, "Chrome")
+			c.Expect(agent.version, gs.Equals, float64(41))
+			c.Expect(agent.os, gs.Equals, "Windows 7")
+			c.Expect(agent.device, gs.Equals, "Desktop")
+
+			agent = parseUserAgent("Mozilla/5.0 (Windows NT 6.1; Trident/7.0; " +
+				"rv:11.0) like Gecko")
+			c.Expect(agent.browser, gs.Equals, "IE")
+			c.Expect(agent.version, gs.Equals, float64(11))
+		})
+
+		c.Specify("recognizes mobile devices", func() {
+			agent := parseUserAgent("Mozilla/5.0 (Mobile; rv:29.0) Gecko/29.0 " +
+				"Firefox/29.0")
+			c.Expect(agent.browser, gs.Equals, "Firefox")
+			c.Expect(agent.os, gs.Equals, "FirefoxOS")
+			c.Expect(agent.device, gs.Equals, "Mobile")
+
+			agent = parseUserAgent("Mozilla/5.0 (iPad; CPU OS 9_1 like Mac OS X) " +
+				"AppleWebKit/601.1.46 (KHTML, like Gecko) Version/9.0 " +
+				"Mobile/13B143 Safari/601.1")
+			c.Expect(agent.browser, gs.Equals, "Safari")
+			c.Expect(agent.os, gs.Equals, "iOS")
+			c.Expect(agent.device, gs.Equals, "Tablet")
+		})
+
+		c.Specify("recognizes crawlers", func() {
+			agent := parseUserAgent("Mozilla/5.0 (compatible; Googlebot/2.1; " +
+				"+http://www.google.com/bot.html)")
+			c.Expect(agent.browser, gs.Equals, "Googlebot")
+			c.Expect(agent.version, gs.Equals, float64(2))
+			c.Expect(agent.device, gs.Equals, "Spider")
+		})
+
+		c.Specify("leaves unknown parts empty", func() {
+			agent := parseUserAgent("something else")
+			c.Expect(agent, gs.Equals, userAgent{})
+		})
+	})
+
+	c.Specify("A UserAgentFilter", func() {
+		filter := new(UserAgentFilter)
+		config := filter.ConfigStruct().(*UserAgentFilterConfig)
+		ua := "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:43.0) Gecko/20100101 " +
+			"Firefox/43.0"
+		msg := pipeline_ts.GetTestMessage()
+		message.NewStringField(msg, "http_user_agent", ua)
+
+		c.Specify("requires a type prefix", func() {
+			config.TypePrefix = ""
+			err := filter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("adds the parsed fields to the copy", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(filter.userAgent(msg), gs.Equals, ua)
+			enriched := message.CopyMessage(msg)
+			filter.enrich(enriched, filter.parse(ua))
+
+			c.Expect(enriched.GetType(), gs.Equals, "ua.TEST")
+			c.Expect(enriched.GetUuidString(), gs.Not(gs.Equals),
+				msg.GetUuidString())
+			browser, _ := enriched.GetFieldValue("ua_browser")
+			c.Expect(browser, gs.Equals, "Firefox")
+			version, _ := enriched.GetFieldValue("ua_version")
+			c.Expect(version, gs.Equals, float64(43))
+			os, _ := enriched.GetFieldValue("ua_os")
+			c.Expect(os, gs.Equals, "Linux")
+			device, _ := enriched.GetFieldValue("ua_device")
+			c.Expect(device, gs.Equals, "Desktop")
+			_, ok := enriched.GetFieldValue("http_user_agent")
+			c.Expect(ok, gs.IsTrue)
+		})
+
+		c.Specify("optionally drops the original field", func() {
+			config.KeepUserAgent = false
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			enriched := message.CopyMessage(msg)
+			filter.enrich(enriched, filter.parse(ua))
+			_, ok := enriched.GetFieldValue("http_user_agent")
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("skips messages without a user agent", func() {
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(filter.userAgent(pipeline_ts.GetTestMessage()), gs.Equals, "")
+		})
+
+		c.Specify("caches a bounded number of parse results", func() {
+			config.CacheSize = 2
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.parse("a")
+			filter.parse("b")
+			filter.parse("a")
+			filter.parse("c")
+			c.Expect(len(filter.cache), gs.Equals, 2)
+			_, ok := filter.cache["b"]
+			c.Expect(ok, gs.IsFalse)
+			c.Expect(filter.lru.Len(), gs.Equals, 2)
+		})
+	})
+}
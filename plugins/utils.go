@@ -17,9 +17,13 @@ package plugins
 
 import (
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+
+	"github.com/mozilla-services/heka/message"
 )
 
 func CheckWritePermission(fp string) (err error) {
@@ -42,3 +46,73 @@ func CheckWritePermission(fp string) (err error) {
 	}
 	return
 }
+
+// Returns the message's value for the named field as a string, and whether
+// the message has it. Supports "Type", "Logger", "Hostname", "Severity",
+// "Payload", "EnvVersion", "Pid", "UUID", and any dynamic field name.
+func messageFieldValue(msg *message.Message, name string) (string, bool) {
+	switch name {
+	case "Type":
+		return msg.GetType(), true
+	case "Logger":
+		return msg.GetLogger(), true
+	case "Hostname":
+		return msg.GetHostname(), true
+	case "Severity":
+		return strconv.Itoa(int(msg.GetSeverity())), true
+	case "Payload":
+		return msg.GetPayload(), true
+	case "EnvVersion":
+		return msg.GetEnvVersion(), true
+	case "Pid":
+		return strconv.Itoa(int(msg.GetPid())), true
+	case "UUID":
+		return msg.GetUuidString(), true
+	}
+	val, ok := msg.GetFieldValue(name)
+	if !ok {
+		return "", false
+	}
+	if b, isBytes := val.([]byte); isBytes {
+		return string(b), true
+	}
+	return fmt.Sprint(val), true
+}
+
+// Joins the values of the named message fields together with "." to build a
+// key. Supports "Type", "Logger", "Hostname", and any dynamic field name,
+// missing fields contribute an empty string.
+func messageKey(msg *message.Message, fields []string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	parts := make([]string, len(fields))
+	for i, name := range fields {
+		switch name {
+		case "Type":
+			parts[i] = msg.GetType()
+		case "Logger":
+			parts[i] = msg.GetLogger()
+		case "Hostname":
+			parts[i] = msg.GetHostname()
+		default:
+			if val, ok := msg.GetFieldValue(name); ok {
+				parts[i] = fmt.Sprint(val)
+			}
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// Returns a hash of the values of the named message fields, missing fields
+// hash like an empty value. See messageFieldValue for the supported names.
+func messageHash(msg *message.Message, fields []string) uint64 {
+	h := fnv.New64a()
+	for _, name := range fields {
+		val, _ := messageFieldValue(msg, name)
+		h.Write([]byte(val))
+		// Keeps e.g. "ab" + "c" and "a" + "bc" apart.
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
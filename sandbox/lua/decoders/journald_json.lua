@@ -0,0 +1,171 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+--[[
+Parses the systemd journal JSON export format, i.e. the output of
+``journalctl -o json``, one entry per line.
+http://www.freedesktop.org/wiki/Software/systemd/json/
+
+The well known journal fields are mapped to the message headers:
+
+- __REALTIME_TIMESTAMP (microseconds since the epoch) -> Timestamp
+- PRIORITY -> Severity
+- _PID -> Pid
+- _HOSTNAME -> Hostname
+- SYSLOG_IDENTIFIER (falling back to _COMM) -> Logger
+- MESSAGE -> Payload
+
+All remaining journal fields are kept as message fields. Binary field values,
+which the journal exports as arrays of bytes, are converted back to strings.
+Fields occurring multiple times in an entry become array fields.
+
+Config:
+
+- type (string, optional, default "journald"):
+    Sets the message 'Type' header to the specified value.
+
+- payload_keep (bool, optional, default false)
+    Preserve the original JSON line in the message payload, in which case the
+    MESSAGE journal field is kept as a message field.
+
+*Example of a Journal Export Entry*
+
+.. code-block:: javascript
+
+    {
+      "__CURSOR" : "s=739ad463348b4ceca5a9e69c95a3c93f;i=4ece7;b=6c7c6013a8b34b5da6adb70ec2c9e7c2;m=20d0f7c0;t=4c5e8cb1a7a68;x=2c0c2a0e3f9c1d6a",
+      "__REALTIME_TIMESTAMP" : "1342540861416409",
+      "__MONOTONIC_TIMESTAMP" : "550471616",
+      "_BOOT_ID" : "6c7c6013a8b34b5da6adb70ec2c9e7c2",
+      "PRIORITY" : "6",
+      "SYSLOG_FACILITY" : "3",
+      "SYSLOG_IDENTIFIER" : "systemd",
+      "_PID" : "1",
+      "_COMM" : "systemd",
+      "_HOSTNAME" : "epsilon",
+      "MESSAGE" : "Started Journal Service."
+    }
+
+*Example Heka Configuration*
+
+.. code-block:: ini
+
+    [JournalInput]
+    type = "LogstreamerInput"
+    log_directory = "/var/log/journal-export"
+    file_match = 'journal\.json'
+    decoder = "JournaldJsonDecoder"
+
+    [JournaldJsonDecoder]
+    type = "SandboxDecoder"
+    filename = "lua_decoders/journald_json.lua"
+
+        [JournaldJsonDecoder.config]
+        type = "journald"
+
+*Example Heka Message*
+
+:Timestamp: 2012-07-17 16:01:01.416409 +0000 UTC
+:Type: journald
+:Hostname: epsilon
+:Pid: 1
+:UUID: 1d4bc4e0-0a7a-4de6-8b44-0f7fcd2a4cd0
+:Logger: systemd
+:Payload: Started Journal Service.
+:EnvVersion:
+:Severity: 6
+:Fields:
+    | name:"__CURSOR" value_type:STRING value_string:"s=739ad463348b4ceca5a9e69c95a3c93f;i=4ece7;b=6c7c6013a8b34b5da6adb70ec2c9e7c2;m=20d0f7c0;t=4c5e8cb1a7a68;x=2c0c2a0e3f9c1d6a"
+    | name:"__MONOTONIC_TIMESTAMP" value_type:STRING value_string:"550471616"
+    | name:"_BOOT_ID" value_type:STRING value_string:"6c7c6013a8b34b5da6adb70ec2c9e7c2"
+    | name:"SYSLOG_FACILITY" value_type:STRING value_string:"3"
+    | name:"_COMM" value_type:STRING value_string:"systemd"
+--]]
+
+require "cjson"
+require "string"
+require "table"
+
+local msg_type     = read_config("type") or "journald"
+local payload_keep = read_config("payload_keep")
+
+local msg = {
+    Timestamp  = nil,
+    Type       = msg_type,
+    Hostname   = nil,
+    Logger     = nil,
+    Payload    = nil,
+    Pid        = nil,
+    Severity   = nil,
+    Fields     = nil
+}
+
+-- Converts an exported journal value to a field value. Binary data is
+-- exported as an array of byte values, repeated fields as an array of values
+-- and oversized values as null, which is dropped.
+local function field_value(v)
+    if type(v) == "string" then return v end
+    if type(v) ~= "table" then return nil end
+
+    if type(v[1]) == "number" then
+        local chars = {}
+        for i, b in ipairs(v) do
+            if type(b) ~= "number" then return nil end
+            chars[i] = string.char(b)
+        end
+        return table.concat(chars)
+    end
+
+    local values = {}
+    for i, e in ipairs(v) do
+        e = field_value(e)
+        if type(e) ~= "string" then return nil end
+        values[i] = e
+    end
+    if #values == 0 then return nil end
+    return values
+end
+
+-- Removes a journal field, returning its value as a scalar.
+local function take(json, name)
+    local v = field_value(json[name])
+    json[name] = nil
+    if type(v) == "table" then return v[1] end
+    return v
+end
+
+function process_message()
+    local ok, json = pcall(cjson.decode, read_message("Payload"))
+    if not ok or type(json) ~= "table" then
+        return -1, "Failed to decode JSON."
+    end
+
+    local ts = tonumber(take(json, "__REALTIME_TIMESTAMP"))
+    if not ts then return -1, "Missing __REALTIME_TIMESTAMP." end
+    msg.Timestamp = ts * 1e3
+
+    msg.Severity = tonumber(take(json, "PRIORITY"))
+    msg.Pid = tonumber(take(json, "_PID"))
+    msg.Hostname = take(json, "_HOSTNAME")
+    msg.Logger = take(json, "SYSLOG_IDENTIFIER") or field_value(json["_COMM"])
+    if type(msg.Logger) == "table" then msg.Logger = msg.Logger[1] end
+
+    if payload_keep then
+        msg.Payload = read_message("Payload")
+    else
+        msg.Payload = take(json, "MESSAGE")
+    end
+
+    local fields = {}
+    for k, v in pairs(json) do
+        fields[k] = field_value(v)
+    end
+    msg.Fields = fields
+
+    if not pcall(inject_message, msg) then
+        return -1, "Failed to inject message."
+    end
+
+    return 0
+end
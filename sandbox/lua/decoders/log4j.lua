@@ -0,0 +1,84 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+--[[
+Parses log lines written by log4j or logback using the layout's conversion
+pattern. The date becomes the message Timestamp, the message becomes the
+Payload, the level sets the Severity, and the remaining converted values
+(e.g. thread, level, logger) are stored as message fields. See the
+:ref:`sandbox_log4j_module` for the supported conversion specifiers.
+
+Config:
+
+- pattern (string)
+    The conversion pattern from the log4j `PatternLayout` or the logback
+    encoder configuration.
+
+- type (string, optional, default nil):
+    Sets the message 'Type' header to the specified value
+
+*Example Heka Configuration*
+
+.. code-block:: ini
+
+    [Log4jDecoder]
+    type = "SandboxDecoder"
+    filename = "lua_decoders/log4j.lua"
+
+    [Log4jDecoder.config]
+    type = "java.app"
+    pattern = '%d{ISO8601} [%thread] %-5level %logger - %msg%n'
+
+*Example Heka Message*
+
+:Timestamp: 2016-03-08 17:02:55.123 +0000 UTC
+:Type: java.app
+:Hostname: app-1
+:Pid: 0
+:UUID: 7b8d9a4b-bfa2-4bd6-9a71-6d5bf8a1c5d3
+:Logger: JavaAppLogInput
+:Payload: Starting the order service
+:EnvVersion:
+:Severity: 6
+:Fields:
+    | name:"thread" value_string:"main"
+    | name:"level" value_string:"INFO"
+    | name:"logger" value_string:"com.example.OrderService"
+--]]
+
+local log4j = require "log4j"
+
+local pattern = read_config("pattern") or error("pattern must be specified")
+local msg_type = read_config("type")
+
+local msg = {
+Timestamp   = nil,
+Type        = msg_type,
+Payload     = nil,
+Severity    = nil,
+Fields      = nil
+}
+
+local grammar = log4j.build_grammar(pattern)
+
+function process_message ()
+    local log = read_message("Payload")
+    local fields = grammar:match(log)
+    if not fields then return -1 end
+
+    msg.Timestamp = fields.Timestamp
+    fields.Timestamp = nil
+
+    msg.Payload = fields.message
+    fields.message = nil
+
+    msg.Severity = nil
+    if fields.level then
+        msg.Severity = log4j.severity(fields.level)
+    end
+
+    msg.Fields = fields
+    if not pcall(inject_message, msg) then return -1 end
+    return 0
+end
@@ -170,6 +170,32 @@ int read_config(lua_State* lua)
     return 1;
 }
 
+////////////////////////////////////////////////////////////////////////////////
+int read_match_count(lua_State* lua)
+{
+    void* luserdata = lua_touserdata(lua, lua_upvalueindex(1));
+    if (NULL == luserdata) {
+        luaL_error(lua, "read_match_count() invalid lightuserdata");
+    }
+    lua_sandbox* lsb = (lua_sandbox*)luserdata;
+
+    if (lua_gettop(lua) != 1) {
+        luaL_error(lua, "read_match_count() must have a single argument");
+    }
+    const char* name = luaL_checkstring(lua, 1);
+
+    struct go_lua_read_match_count_return gr;
+    // Cast away constness of the Lua string, the value is not modified
+    // and it will save a copy.
+    gr = go_lua_read_match_count(lsb_get_parent(lsb), (char*)name);
+    if (gr.r0) {
+        lua_pushnumber(lua, gr.r1);
+    } else {
+        lua_pushnil(lua);
+    }
+    return 1;
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 int read_message(lua_State* lua)
 {
@@ -223,6 +249,10 @@ int read_message(lua_State* lua)
             break;
         }
     }
+    if (gr.r3) {
+        lua_pushboolean(lua, 1);
+        return 2;
+    }
     return 1;
 }
 
@@ -477,7 +507,8 @@ int inject_payload(lua_State* lua)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-int sandbox_init(lua_sandbox* lsb, const char* data_file, const char* plugin_type)
+int sandbox_init(lua_sandbox* lsb, const char* data_file, const char* plugin_type,
+                 int match_counts)
 {
     static const char *output = "output";
     if (!lsb || !plugin_type) return 1;
@@ -487,6 +518,10 @@ int sandbox_init(lua_sandbox* lsb, const char* data_file, const char* plugin_typ
     lsb_add_function(lsb, &read_config, "read_config");
     lsb_add_function(lsb, &lsb_decode_protobuf, "decode_message");
 
+    if (match_counts) {
+        lsb_add_function(lsb, &read_match_count, "read_match_count");
+    }
+
     if (strcmp(plugin_type, "input") == 0) {
         lsb_add_function(lsb, &inject_message, "inject_message");
     }
@@ -50,6 +50,16 @@ int timer_event(lua_sandbox* lsb, long long ns);
 */
 int read_config(lua_State* lua);
 
+/**
+* Returns the number of messages matched by the message matcher of the named
+* filter or output, or nil if there isn't one with that name.
+*
+* @param lua Pointer to the Lua state.
+*
+* @return int Returns one value on the stack.
+*/
+int read_match_count(lua_State* lua);
+
 /**
 * Reads a data field from a Heka message and returns the value.
 *
@@ -85,10 +95,13 @@ int inject_message(lua_State* lua);
  * @param lsb Pointer to the sandbox.
  * @param data_file File used for the data restoration (empty or NULL for no
  *                  restoration)
+ * @param plugin_type Type of the plugin the sandbox is running in.
+ * @param match_counts Non-zero to make read_match_count available.
  *
  * @return int 0 on success
  */
-int sandbox_init(lua_sandbox* lsb, const char* data_file, const char* plugin_type);
+int sandbox_init(lua_sandbox* lsb, const char* data_file, const char* plugin_type,
+                 int match_counts);
 
 /**
  * Sends a shutdown message to the sandbox.
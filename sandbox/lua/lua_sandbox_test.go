@@ -473,6 +473,30 @@ func TestReadMessage(t *testing.T) {
 	sb.Destroy("")
 }
 
+func TestReadMessageTruncatedPayload(t *testing.T) {
+	var sbc SandboxConfig
+	sbc.ScriptFilename = "./testsupport/read_message_truncated.lua"
+	sbc.MemoryLimit = 32767
+	sbc.InstructionLimit = 1000
+	sbc.MaxReadPayloadBytes = 10
+	sbc.PluginType = "decoder"
+	pack := getTestPack()
+	pack.Message.SetPayload("0123456789abcdef")
+	sb, err := lua.CreateLuaSandbox(&sbc)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	err = sb.Init("")
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	r := sb.ProcessMessage(pack)
+	if r != 0 {
+		t.Errorf("ProcessMessage should return 0, received %d %s", r, sb.LastError())
+	}
+	sb.Destroy("")
+}
+
 func TestReadRaw(t *testing.T) {
 	var sbc SandboxConfig
 	sbc.ScriptFilename = "./testsupport/read_raw.lua"
@@ -1017,6 +1041,50 @@ stats.statsd.numStats 2 0
 	sb.Destroy("")
 }
 
+func TestStringBuilder(t *testing.T) {
+	var sbc SandboxConfig
+	sbc.ScriptFilename = "./testsupport/string_builder.lua"
+	sbc.ModuleDirectory = "./modules"
+	sbc.MemoryLimit = 100000
+	sbc.InstructionLimit = 1000
+	sbc.OutputLimit = 1024
+	pack := getTestPack()
+	sb, err := lua.CreateLuaSandbox(&sbc)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	err = sb.Init("")
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+
+	expected := []struct{ payload, payloadType, payloadName string }{
+		{"abc123defg", "txt", "first"},
+		{"second", "txt", ""},
+	}
+	injectCount := 0
+	sb.InjectMessage(func(p, pt, pn string) int {
+		if injectCount >= len(expected) {
+			t.Errorf("Unexpected inject: %s", p)
+			return 0
+		}
+		e := expected[injectCount]
+		if p != e.payload || pt != e.payloadType || pn != e.payloadName {
+			t.Errorf("Received payload: %s type: %s name: %s", p, pt, pn)
+		}
+		injectCount++
+		return 0
+	})
+	r := sb.ProcessMessage(pack)
+	if r != 0 {
+		t.Errorf("ProcessMessage should return 0, received %d %s", r, sb.LastError())
+	}
+	if injectCount != len(expected) {
+		t.Errorf("Expected %d injects, received %d", len(expected), injectCount)
+	}
+	sb.Destroy("")
+}
+
 func TestReadNilConfig(t *testing.T) {
 	var sbc SandboxConfig
 	sbc.ScriptFilename = "./testsupport/read_config_nil.lua"
@@ -0,0 +1,280 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+--[[
+Builds LPeg grammars from log4j / logback conversion patterns.
+
+API
+^^^
+
+**build_grammar(pattern)**
+    Converts a log4j or logback conversion pattern (i.e. the `PatternLayout`
+    `ConversionPattern`) into an LPeg grammar. The supported conversion
+    specifiers are listed below, as well as the name each is captured under.
+    Format modifiers (e.g. `%-5level` or `%.30logger`) are supported, the
+    padding spaces are removed from the captured value.
+
+    - %d, %date (Timestamp): Parsed into nanoseconds since the epoch. The
+      optional date format can be ISO8601 (the default), ISO8601_BASIC, DATE,
+      UNIX, UNIX_MILLIS, or a SimpleDateFormat string using the y, M, d, H, m,
+      s, S, Z and X letters, e.g. `%d{yyyy-MM-dd'T'HH:mm:ss.SSSZ}`. Dates
+      without a zone offset are treated as UTC.
+    - %c, %lo, %logger (logger)
+    - %C, %class (class)
+    - %F, %file (file)
+    - %L, %line (line): Converted to a number.
+    - %m, %msg, %message (message)
+    - %M, %method (method)
+    - %n: Newline, optional in the input.
+    - %p, %le, %level (level)
+    - %r, %relative (relative): Converted to a number.
+    - %t, %thread (thread)
+    - %x, %NDC (ndc)
+    - %X, %mdc (mdc): If a key is specified, e.g. `%X{user}`, the value is
+      captured under the key name.
+    - %%: A literal percent sign.
+
+    *Arguments*
+        - pattern (string)
+            The conversion pattern, e.g. `%d{ISO8601} [%thread] %-5level %logger - %msg%n`
+
+    *Return*
+        An LPeg grammar that returns a table of the captured values. An error
+        is raised if the pattern is invalid or uses an unsupported specifier.
+
+**severity(level)**
+    Maps a log4j level name to a syslog severity.
+
+    *Arguments*
+        - level (string)
+            The level name, e.g. "WARN". Matching is case insensitive.
+
+    *Return*
+        The severity number (FATAL = 2, ERROR = 3, WARN = 4, INFO = 6,
+        DEBUG and TRACE = 7), or nil if the level is unknown.
+--]]
+
+local l = require "lpeg"
+l.locale(l)
+local string = require "string"
+local date_time = require "date_time"
+local error = error
+local ipairs = ipairs
+local tonumber = tonumber
+
+local M = {}
+setfenv(1, M) -- Remove external access to contain everything in the module
+
+local severities = {
+    FATAL   = 2,
+    ERROR   = 3,
+    WARN    = 4,
+    WARNING = 4,
+    INFO    = 6,
+    DEBUG   = 7,
+    TRACE   = 7,
+}
+
+local converters = {
+    c = "logger", lo = "logger", logger = "logger",
+    C = "class", class = "class",
+    d = "date", date = "date",
+    F = "file", file = "file",
+    L = "line", line = "line",
+    m = "message", msg = "message", message = "message",
+    M = "method", method = "method",
+    n = "newline",
+    p = "level", le = "level", level = "level",
+    r = "relative", relative = "relative",
+    t = "thread", thread = "thread",
+    x = "ndc", NDC = "ndc",
+    X = "mdc", mdc = "mdc",
+}
+
+local numeric = {line = true, relative = true}
+
+local named_dates = {
+    ISO8601         = "yyyy-MM-dd HH:mm:ss,SSS",
+    ISO8601_BASIC   = "yyyyMMdd'T'HHmmss,SSS",
+    DATE            = "dd MMM yyyy HH:mm:ss,SSS",
+}
+
+local digit2 = l.digit * l.digit
+
+local month_abbr = l.P(false)
+for i, m in ipairs({"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug",
+                    "Sep", "Oct", "Nov", "Dec"}) do
+    month_abbr = month_abbr + l.P(m) * l.Cc(i)
+end
+
+local offset = l.Cg(l.S"+-", "offset_sign")
+* l.Cg(digit2 / tonumber, "offset_hour")
+* l.P":"^-1
+* l.Cg(digit2 / tonumber, "offset_min")
++ l.P"Z"
+
+local date_tokens = {
+    yyyy    = l.Cg(l.digit^4 / tonumber, "year"),
+    yy      = l.Cg(digit2 / function(s) return tonumber(s) + 2000 end, "year"),
+    MMM     = l.Cg(month_abbr, "month"),
+    MM      = l.Cg(digit2 / tonumber, "month"),
+    dd      = l.Cg(digit2 / tonumber, "day"),
+    HH      = l.Cg(digit2 / tonumber, "hour"),
+    mm      = l.Cg(digit2 / tonumber, "min"),
+    ss      = l.Cg(digit2 / tonumber, "sec"),
+    SSS     = l.Cg(l.digit^1 / function(s) return tonumber("0." .. s) end, "sec_frac"),
+    Z       = offset,
+    X       = offset,
+    XX      = offset,
+    XXX     = offset,
+}
+
+local function build_date_grammar(format)
+    if format == "UNIX" then
+        return l.digit^1 / function(s) return tonumber(s) * 1e9 end
+    elseif format == "UNIX_MILLIS" then
+        return l.digit^1 / function(s) return tonumber(s) * 1e6 end
+    end
+    format = named_dates[format] or format
+
+    local grammar = l.P(true)
+    local i = 1
+    while i <= #format do
+        local c = format:sub(i, i)
+        if c == "'" then
+            local close = format:find("'", i + 1, true)
+            if not close then error("unterminated quote in date format: " .. format) end
+            if close == i + 1 then
+                grammar = grammar * l.P"'"
+            else
+                grammar = grammar * l.P(format:sub(i + 1, close - 1))
+            end
+            i = close + 1
+        elseif c:match("%a") then
+            local s, e = format:find("^" .. c .. "+", i)
+            local token = format:sub(s, e)
+            local patt = date_tokens[token]
+            if c == "S" then patt = date_tokens.SSS end
+            if not patt then error("unsupported date format token: " .. token) end
+            grammar = grammar * patt
+            i = e + 1
+        else
+            grammar = grammar * l.P(c)
+            i = i + 1
+        end
+    end
+    return l.Ct(grammar) / date_time.time_to_ns
+end
+
+-- Splits the conversion pattern into a list of literal strings and
+-- conversion specifiers.
+local function parse_pattern(pattern)
+    local elems = {}
+    local literal = ""
+    local i = 1
+    while i <= #pattern do
+        local c = pattern:sub(i, i)
+        if c ~= "%" then
+            literal = literal .. c
+            i = i + 1
+        elseif pattern:sub(i + 1, i + 1) == "%" then
+            literal = literal .. "%"
+            i = i + 2
+        else
+            if literal ~= "" then
+                elems[#elems + 1] = {literal = literal}
+                literal = ""
+            end
+            local s, e, left, min, word = pattern:find("^(%-?)(%d*)%.?%d*(%a+)", i + 1)
+            if not s then error("invalid conversion specifier at position " .. i) end
+            local name = converters[word]
+            if not name then error("unsupported conversion specifier: %" .. word) end
+            local elem = {name = name, left = left == "-", padded = min ~= ""}
+            i = e + 1
+            if pattern:sub(i, i) == "{" then
+                local close = pattern:find("}", i, true)
+                if not close then error("unterminated option for %" .. word) end
+                elem.option = pattern:sub(i + 1, close - 1)
+                i = close + 1
+            end
+            elems[#elems + 1] = elem
+        end
+    end
+    if literal ~= "" then
+        elems[#elems + 1] = {literal = literal}
+    end
+    return elems
+end
+
+-- Returns true if there's nothing but newlines after the element at idx.
+local function at_end(elems, idx)
+    for i = idx + 1, #elems do
+        if elems[i].name ~= "newline" then return false end
+    end
+    return true
+end
+
+local function build_value(elems, idx)
+    local elem = elems[idx]
+    local nxt = elems[idx + 1]
+    local stop
+    if at_end(elems, idx) then
+        stop = l.P"\n"^-1 * -1
+    elseif nxt.literal then
+        stop = l.P(nxt.literal)
+    else
+        stop = l.space
+    end
+
+    local value
+    if elem.padded then
+        value = l.C((1 - stop - l.space)^1)
+        if elem.left then
+            -- Trailing padding, leave a space for a literal that starts
+            -- with one.
+            if nxt and nxt.literal and nxt.literal:sub(1, 1) == " " then
+                value = value * (l.P" " * #l.P" ")^0
+            else
+                value = value * l.P" "^0
+            end
+        else
+            value = l.P" "^0 * value
+        end
+    elseif elem.name == "message" then
+        value = l.C((1 - stop)^0)
+    else
+        value = l.C((1 - stop)^1)
+    end
+    if numeric[elem.name] then
+        value = value / tonumber
+    end
+    return value
+end
+
+function build_grammar(pattern)
+    local elems = parse_pattern(pattern)
+    local grammar = l.P(true)
+    for i, elem in ipairs(elems) do
+        if elem.literal then
+            grammar = grammar * l.P(elem.literal)
+        elseif elem.name == "newline" then
+            grammar = grammar * l.P"\n"^-1
+        elseif elem.name == "date" then
+            grammar = grammar * l.Cg(build_date_grammar(elem.option or "ISO8601"), "Timestamp")
+        else
+            local name = elem.name
+            if name == "mdc" and elem.option and elem.option ~= "" then
+                name = elem.option
+            end
+            grammar = grammar * l.Cg(build_value(elems, i), name)
+        end
+    end
+    return l.Ct(grammar * l.P"\n"^-1 * -1)
+end
+
+function severity(level)
+    return severities[string.upper(level)]
+end
+
+return M
@@ -0,0 +1,158 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+--[[
+Bounded string builder, for filters that assemble large payloads. Unlike
+`add_to_payload`, which terminates the sandbox when the output limit is
+exceeded, the builder refuses appends that would take it over its size cap
+and reports an error, so the filter can inject what it has so far and carry
+on.
+
+API
+^^^
+
+**new(max_size)**
+    Creates a new string builder.
+
+    *Arguments*
+        - max_size (uint)
+            Maximum number of bytes the builder will hold. When the contents
+            are injected as a payload this should not be larger than the
+            sandbox `output_limit`.
+
+    *Return*
+        A string builder object.
+
+**builder:add(...)**
+    Appends the arguments to the builder. The arguments are added all or
+    nothing, if together they would take the builder over its max_size none
+    of them are added.
+
+    *Arguments*
+        - ... (string or number)
+
+    *Return*
+        true on success, or false and an error message if the arguments don't
+        fit. An argument that isn't a string or a number raises an error.
+
+**builder:len()**
+    *Return*
+        The number of bytes in the builder.
+
+**builder:remaining()**
+    *Return*
+        The number of bytes that can still be added to the builder.
+
+**builder:result()**
+    *Return*
+        The builder contents as a single string.
+
+**builder:reset()**
+    Empties the builder.
+
+**builder:inject(payload_type, payload_name)**
+    Injects the builder contents with `inject_payload` and empties the
+    builder. Nothing is injected when the builder is empty.
+
+    *Arguments*
+        - payload_type (string, optional, default "txt")
+        - payload_name (string, optional, default "")
+
+    *Return*
+        true if a payload was injected, false if the builder was empty.
+
+*Example*
+
+.. code-block:: lua
+
+    local string_builder = require "string_builder"
+    local report = string_builder.new(60 * 1024)
+
+    function timer_event(ns)
+        for k, v in pairs(counts) do
+            if not report:add(k, "\t", v, "\n") then
+                report:inject("tsv", "counts")
+                report:add(k, "\t", v, "\n")
+            end
+        end
+        report:inject("tsv", "counts")
+    end
+--]]
+
+local string = require "string"
+local table = require "table"
+local error = error
+local inject_payload = inject_payload
+local setmetatable = setmetatable
+local select = select
+local tostring = tostring
+local type = type
+
+local M = {}
+setfenv(1, M) -- Remove external access to contain everything in the module
+
+local builder = {}
+builder.__index = builder
+
+function new(max_size)
+    if type(max_size) ~= "number" or max_size < 1 then
+        error("max_size must be a positive number")
+    end
+    return setmetatable({max_size = max_size, size = 0, parts = {}}, builder)
+end
+
+function builder:add(...)
+    local n = select("#", ...)
+    local args = {...}
+    local size = 0
+    for i = 1, n do
+        local v = args[i]
+        local t = type(v)
+        if t == "number" then
+            v = tostring(v)
+            args[i] = v
+        elseif t ~= "string" then
+            error(string.format("bad argument #%d to 'add' (string or number expected, got %s)", i, t))
+        end
+        size = size + #v
+    end
+
+    if self.size + size > self.max_size then
+        return false, string.format("string builder limit exceeded (%d + %d > %d)",
+                                    self.size, size, self.max_size)
+    end
+
+    local parts = self.parts
+    for i = 1, n do
+        parts[#parts + 1] = args[i]
+    end
+    self.size = self.size + size
+    return true
+end
+
+function builder:len()
+    return self.size
+end
+
+function builder:remaining()
+    return self.max_size - self.size
+end
+
+function builder:result()
+    return table.concat(self.parts)
+end
+
+function builder:reset()
+    self.parts = {}
+    self.size = 0
+end
+
+function builder:inject(payload_type, payload_name)
+    if self.size == 0 then return false end
+    inject_payload(payload_type or "txt", payload_name or "", self:result())
+    self:reset()
+    return true
+end
+
+return M
@@ -0,0 +1,17 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+require "lpeg"
+
+-- The grammar references an undefined rule.
+local grammar = lpeg.P{
+    "line";
+    line = lpeg.V"timestamp" * lpeg.V"message",
+    timestamp = lpeg.R"09"^1,
+}
+
+function process_message()
+    if not grammar:match(read_message("Payload")) then return -1 end
+    return 0
+end
@@ -0,0 +1,22 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+function process_message ()
+    local payload, truncated = read_message("Payload")
+    if payload ~= "0123456789" then return 1 end
+    if truncated ~= true then return 2 end
+
+    local hostname, htruncated = read_message("Hostname")
+    if hostname == nil or htruncated ~= nil then return 3 end
+
+    write_message("Payload", "short")
+    payload, truncated = read_message("Payload")
+    if payload ~= "short" then return 4 end
+    if truncated ~= nil then return 5 end
+
+    return 0
+end
+
+function timer_event()
+end
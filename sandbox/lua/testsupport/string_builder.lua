@@ -0,0 +1,32 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+local string_builder = require "string_builder"
+
+local sb = string_builder.new(10)
+
+function process_message()
+    assert(sb:add("abc", 123), "add failed")
+    assert(sb:len() == 6, sb:len())
+    assert(sb:remaining() == 4, sb:remaining())
+
+    local ok, err = sb:add("de", "fgh")
+    assert(not ok, "add past the limit succeeded")
+    assert(err == "string builder limit exceeded (6 + 5 > 10)", err)
+    assert(sb:len() == 6, "failed add changed the length")
+
+    assert(sb:add("defg"), "add up to the limit failed")
+    assert(sb:result() == "abc123defg", sb:result())
+
+    assert(sb:inject("txt", "first"), "inject failed")
+    assert(sb:len() == 0, "inject didn't reset")
+    assert(not sb:inject(), "empty inject succeeded")
+
+    sb:add("second")
+    sb:inject()
+    return 0
+end
+
+function timer_event(ns)
+end
@@ -0,0 +1,173 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/AdRoll/goamz/aws"
+	"github.com/AdRoll/goamz/s3"
+	"github.com/garyburd/redigo/redis"
+	. "github.com/mozilla-services/heka/sandbox"
+)
+
+// A PreservationStore keeps a copy of a sandbox's preservation file
+// somewhere that outlives the local file system. Restore is called before the
+// sandbox is initialized from the local file, Preserve is called after the
+// sandbox has written the local file during shutdown.
+type PreservationStore interface {
+	// Writes the stored data for the named sandbox to path. If nothing has
+	// been stored for the sandbox, path is left alone and nil is returned.
+	Restore(name, path string) error
+	// Stores the contents of path as the data for the named sandbox.
+	Preserve(name, path string) error
+}
+
+func NewPreservationStore(conf PreservationConfig) (PreservationStore, error) {
+	switch conf.Store {
+	case "", "file":
+		return fileStore{}, nil
+	case "s3":
+		return newS3Store(conf)
+	case "redis":
+		return newRedisStore(conf)
+	}
+	return nil, fmt.Errorf("unsupported preservation store: %s", conf.Store)
+}
+
+// The local preservation file is all the "file" store needs, so there's
+// nothing to do.
+type fileStore struct{}
+
+func (f fileStore) Restore(name, path string) error {
+	return nil
+}
+
+func (f fileStore) Preserve(name, path string) error {
+	return nil
+}
+
+type s3Store struct {
+	bucket    *s3.Bucket
+	keyPrefix string
+}
+
+func newS3Store(conf PreservationConfig) (*s3Store, error) {
+	if conf.Bucket == "" {
+		return nil, errors.New("s3 preservation store requires a `bucket`")
+	}
+	region, ok := aws.Regions[conf.Region]
+	if !ok {
+		return nil, fmt.Errorf("unknown S3 region: %s", conf.Region)
+	}
+	auth, err := aws.GetAuth(conf.AccessKey, conf.SecretKey, "", time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("can't get AWS credentials: %s", err)
+	}
+	return &s3Store{
+		bucket:    s3.New(auth, region).Bucket(conf.Bucket),
+		keyPrefix: conf.KeyPrefix,
+	}, nil
+}
+
+func (s *s3Store) Restore(name, path string) error {
+	data, err := s.bucket.Get(s.keyPrefix + name)
+	if err != nil {
+		if s3Err, ok := err.(*s3.Error); ok && s3Err.StatusCode == 404 {
+			return nil
+		}
+		return fmt.Errorf("fetching preserved data from S3: %s", err)
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func (s *s3Store) Preserve(name, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = s.bucket.Put(s.keyPrefix+name, data, "application/octet-stream",
+		s3.Private, s3.Options{})
+	if err != nil {
+		return fmt.Errorf("storing preserved data in S3: %s", err)
+	}
+	return nil
+}
+
+type redisStore struct {
+	conf PreservationConfig
+}
+
+func newRedisStore(conf PreservationConfig) (*redisStore, error) {
+	if conf.Address == "" {
+		return nil, errors.New("redis preservation store requires an `address`")
+	}
+	return &redisStore{conf: conf}, nil
+}
+
+// Preservation only happens during start up and shut down, so we don't hold
+// a connection open in between.
+func (r *redisStore) dial() (conn redis.Conn, err error) {
+	if conn, err = redis.Dial("tcp", r.conf.Address); err != nil {
+		return nil, fmt.Errorf("connecting to redis: %s", err)
+	}
+	if r.conf.Password != "" {
+		if _, err = conn.Do("AUTH", r.conf.Password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis AUTH failed: %s", err)
+		}
+	}
+	if r.conf.Database != 0 {
+		if _, err = conn.Do("SELECT", r.conf.Database); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis SELECT failed: %s", err)
+		}
+	}
+	return conn, nil
+}
+
+func (r *redisStore) Restore(name, path string) error {
+	conn, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	data, err := redis.Bytes(conn.Do("GET", r.conf.KeyPrefix+name))
+	if err == redis.ErrNil {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("fetching preserved data from redis: %s", err)
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func (r *redisStore) Preserve(name, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	conn, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.Do("SET", r.conf.KeyPrefix+name, data); err != nil {
+		return fmt.Errorf("storing preserved data in redis: %s", err)
+	}
+	return nil
+}
@@ -91,11 +91,24 @@ func (s *SandboxDecoder) Init(config interface{}) (err error) {
 		}
 	}
 
+	var sb Sandbox
 	switch s.sbc.ScriptType {
 	case "lua":
+		sb, err = lua.CreateLuaSandbox(s.sbc)
 	default:
 		return fmt.Errorf("unsupported script type: %s", s.sbc.ScriptType)
 	}
+	if err != nil {
+		return fmt.Errorf("sandbox creation failed: %s", err)
+	}
+	// The decoder's sandbox isn't started until the decoder runner is set, so
+	// load the script once now to catch errors in it, e.g. an invalid LPeg
+	// grammar, at startup rather than when messages arrive.
+	err = sb.Init("")
+	sb.Destroy("")
+	if err != nil {
+		return fmt.Errorf("sandbox initialization failed: %s", err)
+	}
 
 	s.sample = true
 	return
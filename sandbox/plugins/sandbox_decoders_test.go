@@ -3,6 +3,7 @@ package plugins
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/mozilla-services/heka/message"
@@ -105,6 +106,15 @@ func DecoderSpec(c gs.Context) {
 			})
 		})
 
+		c.Specify("with an invalid LPeg grammar fails to initialize", func() {
+			conf.ScriptFilename = "../lua/testsupport/decoder_invalid_grammar.lua"
+			conf.ModuleDirectory = "../lua/modules"
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(strings.Contains(err.Error(), "rule 'message' undefined"),
+				gs.IsTrue)
+		})
+
 		c.Specify("that only uses write_message", func() {
 			conf.ScriptFilename = "../lua/testsupport/write_message_decoder.lua"
 			conf.ModuleDirectory = "../lua/modules"
@@ -372,6 +382,63 @@ func DecoderSpec(c gs.Context) {
 		})
 	})
 
+	c.Specify("journald JSON decoder", func() {
+		decoder := new(SandboxDecoder)
+		decoder.SetPipelineConfig(pConfig)
+		conf := decoder.ConfigStruct().(*sandbox.SandboxConfig)
+		conf.ScriptFilename = "../lua/decoders/journald_json.lua"
+		conf.ModuleDirectory = "../lua/modules"
+		conf.MemoryLimit = 8e6
+		conf.Config = make(map[string]interface{})
+		supply := make(chan *pipeline.PipelinePack, 1)
+		pack := pipeline.NewPipelinePack(supply)
+
+		err := decoder.Init(conf)
+		c.Assume(err, gs.IsNil)
+
+		dRunner := pm.NewMockDecoderRunner(ctrl)
+		dRunner.EXPECT().Name().Return("SandboxDecoder")
+		decoder.SetDecoderRunner(dRunner)
+
+		c.Specify("decodes a journal entry", func() {
+			payload := `{"__REALTIME_TIMESTAMP":"1342540861416409","PRIORITY":"3","_PID":"812","_HOSTNAME":"epsilon","SYSLOG_IDENTIFIER":"sshd","_COMM":"sshd","MESSAGE":"Connection closed","_SYSTEMD_UNIT":"sshd.service","BIN":[104,105],"TAG":["a","b"]}`
+			pack.Message.SetPayload(payload)
+
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(pack.Message.GetType(), gs.Equals, "journald")
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1342540861416409000))
+			c.Expect(pack.Message.GetSeverity(), gs.Equals, int32(3))
+			c.Expect(pack.Message.GetPid(), gs.Equals, int32(812))
+			c.Expect(pack.Message.GetHostname(), gs.Equals, "epsilon")
+			c.Expect(pack.Message.GetLogger(), gs.Equals, "sshd")
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "Connection closed")
+
+			value, ok := pack.Message.GetFieldValue("_SYSTEMD_UNIT")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, "sshd.service")
+
+			value, ok = pack.Message.GetFieldValue("BIN")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, "hi")
+
+			field := pack.Message.FindFirstField("TAG")
+			c.Assume(field, gs.Not(gs.IsNil))
+			c.Expect(len(field.GetValueString()), gs.Equals, 2)
+
+			_, ok = pack.Message.GetFieldValue("PRIORITY")
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("rejects an entry without a timestamp", func() {
+			pack.Message.SetPayload(`{"MESSAGE":"no time"}`)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		decoder.Shutdown()
+	})
+
 	c.Specify("Linux Cpu Stats decoder", func() {
 		decoder := new(SandboxDecoder)
 		decoder.SetPipelineConfig(pConfig)
@@ -867,6 +934,64 @@ HugePages_Free:        0
 		})
 	})
 
+	c.Specify("log4j decoder", func() {
+		decoder := new(SandboxDecoder)
+		decoder.SetPipelineConfig(pConfig)
+		conf := decoder.ConfigStruct().(*sandbox.SandboxConfig)
+		conf.ScriptFilename = "../lua/decoders/log4j.lua"
+		conf.ModuleDirectory = "../lua/modules"
+		conf.MemoryLimit = 8e6
+		conf.Config = make(map[string]interface{})
+		conf.Config["type"] = "java.app"
+		conf.Config["pattern"] = "%d{ISO8601} [%thread] %-5level %logger{36} - %msg%n"
+		supply := make(chan *pipeline.PipelinePack, 1)
+		pack := pipeline.NewPipelinePack(supply)
+		dRunner := pm.NewMockDecoderRunner(ctrl)
+		dRunner.EXPECT().Name().Return("SandboxDecoder")
+		err := decoder.Init(conf)
+		c.Assume(err, gs.IsNil)
+		decoder.SetDecoderRunner(dRunner)
+
+		c.Specify("decodes simple messages", func() {
+			data := "2016-03-08 17:02:55,123 [pool-1-thread-2] WARN  com.example.OrderService - order 42 - retrying\n"
+			pack.Message.SetPayload(data)
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+
+			diff := pack.Message.GetTimestamp() - int64(1457456575123000000)
+			c.Expect(diff < 1e6 && diff > -1e6, gs.IsTrue)
+			c.Expect(pack.Message.GetSeverity(), gs.Equals, int32(4))
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "order 42 - retrying")
+			c.Expect(pack.Message.GetType(), gs.Equals, "java.app")
+
+			var ok bool
+			var value interface{}
+			value, ok = pack.Message.GetFieldValue("thread")
+			c.Expect(ok, gs.Equals, true)
+			c.Expect(value, gs.Equals, "pool-1-thread-2")
+
+			value, ok = pack.Message.GetFieldValue("level")
+			c.Expect(ok, gs.Equals, true)
+			c.Expect(value, gs.Equals, "WARN")
+
+			value, ok = pack.Message.GetFieldValue("logger")
+			c.Expect(ok, gs.Equals, true)
+			c.Expect(value, gs.Equals, "com.example.OrderService")
+
+			decoder.Shutdown()
+		})
+
+		c.Specify("decodes an invalid messages", func() {
+			data := "bogus message"
+			pack.Message.SetPayload(data)
+			packs, err := decoder.Decode(pack)
+			c.Expect(len(packs), gs.Equals, 0)
+			c.Expect(err.Error(), gs.Equals, "Failed parsing:  payload: "+data)
+			c.Expect(decoder.processMessageFailures, gs.Equals, int64(1))
+			decoder.Shutdown()
+		})
+	})
+
 	c.Specify("mysql decoder", func() {
 		decoder := new(SandboxDecoder)
 		decoder.SetPipelineConfig(pConfig)
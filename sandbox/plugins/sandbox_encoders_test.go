@@ -122,6 +122,54 @@ func EncoderSpec(c gs.Context) {
 		})
 	})
 
+	c.Specify("A SandboxTransform", func() {
+		supply := make(chan *pipeline.PipelinePack, 1)
+		pack := pipeline.NewPipelinePack(supply)
+		pack.Message.SetPayload("original")
+		pack.Message.SetType("my_type")
+		pack.Message.SetTimestamp(54321)
+		pack.Message.SetUuid(uuid.NewRandom())
+		pack.EncodeMsgBytes()
+
+		c.Specify("transforms a copy of the message", func() {
+			source := `write_message("Type", "transformed")`
+			transform, err := NewSandboxTransform("test-transform", source, pConfig)
+			c.Assume(err, gs.IsNil)
+			defer transform.Stop()
+
+			tpack, err := transform.Transform(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(tpack.Message.GetType(), gs.Equals, "transformed")
+			c.Expect(pack.Message.GetType(), gs.Equals, "my_type")
+
+			msg := new(message.Message)
+			err = proto.Unmarshal(tpack.MsgBytes, msg)
+			c.Expect(err, gs.IsNil)
+			c.Expect(msg.GetType(), gs.Equals, "transformed")
+		})
+
+		c.Specify("skips the message", func() {
+			source := `if read_message("Payload") == "original" then return -2 end`
+			transform, err := NewSandboxTransform("test-transform", source, pConfig)
+			c.Assume(err, gs.IsNil)
+			defer transform.Stop()
+
+			tpack, err := transform.Transform(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(tpack == nil, gs.IsTrue)
+		})
+
+		c.Specify("reports a failed transform", func() {
+			source := `return -1`
+			transform, err := NewSandboxTransform("test-transform", source, pConfig)
+			c.Assume(err, gs.IsNil)
+			defer transform.Stop()
+
+			_, err = transform.Transform(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
 	c.Specify("cbuf librato encoder", func() {
 		encoder := new(SandboxEncoder)
 		encoder.SetPipelineConfig(pConfig)
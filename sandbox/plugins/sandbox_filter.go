@@ -58,6 +58,7 @@ type SandboxFilter struct {
 	sb                     Sandbox
 	sbc                    *SandboxConfig
 	preservationFile       string
+	preservationStore      PreservationStore
 	reportLock             sync.Mutex
 	name                   string
 	sampleDenominator      int
@@ -99,17 +100,33 @@ func (this *SandboxFilter) Init(config interface{}) (err error) {
 		}
 	}
 
+	if this.sbc.PreserveData {
+		this.preservationStore, err = NewPreservationStore(this.sbc.Preservation)
+		if err != nil {
+			return
+		}
+	}
+
 	switch this.sbc.ScriptType {
 	case "lua":
 		this.sb, err = lua.CreateLuaSandbox(this.sbc)
 		if err != nil {
 			return
 		}
+		if this.sbc.EnableReadMatchCount {
+			this.sb.MatchCount(this.pConfig.MatchCount)
+		}
 	default:
 		return fmt.Errorf("unsupported script type: %s", this.sbc.ScriptType)
 	}
 
 	this.preservationFile = filepath.Join(data_dir, this.name+DATA_EXT)
+	if this.sbc.PreserveData {
+		if err = this.preservationStore.Restore(this.name,
+			this.preservationFile); err != nil {
+			return
+		}
+	}
 	if this.sbc.PreserveData && fileExists(this.preservationFile) {
 		err = this.sb.Init(this.preservationFile)
 	} else {
@@ -374,6 +391,10 @@ func (this *SandboxFilter) destroy() error {
 	if this.sb != nil {
 		if this.sbc.PreserveData {
 			err = this.sb.Destroy(this.preservationFile)
+			if err == nil && fileExists(this.preservationFile) {
+				err = this.preservationStore.Preserve(this.name,
+					this.preservationFile)
+			}
 		} else {
 			err = this.sb.Destroy("")
 		}
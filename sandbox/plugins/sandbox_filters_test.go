@@ -133,6 +133,26 @@ func FilterSpec(c gs.Context) {
 			c.Expect(err, gs.IsNil)
 		})
 
+		c.Specify("Rejects an unknown preservation store", func() {
+			config.ScriptFilename = "../lua/testsupport/serialize.lua"
+			config.ModuleDirectory = "../lua/modules"
+			config.PreserveData = true
+			config.Preservation.Store = "floppy"
+			sbFilter.SetName("serialize")
+			err := sbFilter.Init(config)
+			c.Expect(err.Error(), gs.Equals, "unsupported preservation store: floppy")
+		})
+
+		c.Specify("Requires a bucket for the s3 preservation store", func() {
+			config.ScriptFilename = "../lua/testsupport/serialize.lua"
+			config.ModuleDirectory = "../lua/modules"
+			config.PreserveData = true
+			config.Preservation.Store = "s3"
+			sbFilter.SetName("serialize")
+			err := sbFilter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
 		c.Specify("process_message error string", func() {
 			var timer <-chan time.Time
 			fth.MockFilterRunner.EXPECT().Ticker().Return(timer)
@@ -255,6 +275,30 @@ func FilterSpec(c gs.Context) {
 			c.Expect(sbmFilter.outputLimit, gs.Equals, config.OutputLimit)
 		})
 
+		c.Specify("Defaults the maximum limits to the configured limits", func() {
+			config.InstructionLimit = 4321
+			config.MaxMemoryLimit = 16 * 1024 * 1024
+			sbmFilter.Init(config)
+			c.Expect(sbmFilter.maxInstructionLimit, gs.Equals, uint(4321))
+			c.Expect(sbmFilter.maxMemoryLimit, gs.Equals, uint(16*1024*1024))
+			c.Expect(sbmFilter.maxOutputLimit, gs.Equals, uint(63*1024))
+		})
+
+		c.Specify("Honors sandbox specified limits within the maximum", func() {
+			section := map[string]interface{}{"instruction_limit": int64(2e6)}
+			limit, err := sandboxLimit(section, "instruction_limit", 1e6, 5e6)
+			c.Expect(err, gs.IsNil)
+			c.Expect(limit, gs.Equals, uint(2e6))
+
+			limit, err = sandboxLimit(section, "output_limit", 1024, 2048)
+			c.Expect(err, gs.IsNil)
+			c.Expect(limit, gs.Equals, uint(1024))
+
+			_, err = sandboxLimit(section, "instruction_limit", 1e6, 1e6)
+			c.Expect(err.Error(), gs.Equals,
+				"instruction_limit 2000000 exceeds the maximum of 1000000")
+		})
+
 		c.Specify("Creates a SandboxFilter runner", func() {
 			sbxName := "SandboxFilter"
 			sbxMgrName := "SandboxManagerFilter"
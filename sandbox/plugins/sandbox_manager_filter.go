@@ -44,6 +44,10 @@ type SandboxManagerFilter struct {
 	memoryLimit         uint
 	instructionLimit    uint
 	outputLimit         uint
+	maxMemoryLimit      uint
+	maxInstructionLimit uint
+	maxOutputLimit      uint
+	preservation        PreservationConfig
 	pConfig             *pipeline.PipelineConfig
 }
 
@@ -61,14 +65,26 @@ type SandboxManagerFilterConfig struct {
 	// all SandboxFilter 'require' requests. Defaults to
 	// ${SHARE_DIR}/lua_modules.
 	ModuleDirectory string `toml:"module_directory"`
-	// Memory limit applied to all managed sandboxes.
+	// Memory limit applied to managed sandboxes that don't specify their own.
 	MemoryLimit uint `toml:"memory_limit"`
-	// Instruction limit applied to all managed sandboxes.
+	// Instruction limit applied to managed sandboxes that don't specify their
+	// own.
 	InstructionLimit uint `toml:"instruction_limit"`
-	// Output limit applied to all managed sandboxes.
+	// Output limit applied to managed sandboxes that don't specify their own.
 	OutputLimit uint `toml:"output_limit"`
+	// Largest memory limit a managed sandbox may request. Defaults to
+	// MemoryLimit.
+	MaxMemoryLimit uint `toml:"max_memory_limit"`
+	// Largest instruction limit a managed sandbox may request. Defaults to
+	// InstructionLimit.
+	MaxInstructionLimit uint `toml:"max_instruction_limit"`
+	// Largest output limit a managed sandbox may request. Defaults to
+	// OutputLimit.
+	MaxOutputLimit uint `toml:"max_output_limit"`
 	// Default message matcher.
 	MessageMatcher string `toml:"message_matcher"`
+	// Preservation store applied to all managed sandboxes.
+	Preservation PreservationConfig `toml:"preservation"`
 }
 
 func (this *SandboxManagerFilter) ConfigStruct() interface{} {
@@ -80,6 +96,7 @@ func (this *SandboxManagerFilter) ConfigStruct() interface{} {
 		InstructionLimit: sbDefaults.InstructionLimit,
 		OutputLimit:      sbDefaults.OutputLimit,
 		MessageMatcher:   "Type == 'heka.control.sandbox'",
+		Preservation:     sbDefaults.Preservation,
 	}
 }
 
@@ -104,10 +121,43 @@ func (this *SandboxManagerFilter) Init(config interface{}) (err error) {
 	this.memoryLimit = conf.MemoryLimit
 	this.instructionLimit = conf.InstructionLimit
 	this.outputLimit = conf.OutputLimit
+	this.maxMemoryLimit = maxLimit(conf.MaxMemoryLimit, conf.MemoryLimit)
+	this.maxInstructionLimit = maxLimit(conf.MaxInstructionLimit, conf.InstructionLimit)
+	this.maxOutputLimit = maxLimit(conf.MaxOutputLimit, conf.OutputLimit)
+	this.preservation = conf.Preservation
 	err = os.MkdirAll(this.workingDirectory, 0700)
 	return
 }
 
+// Returns the configured ceiling for a sandbox limit, never lower than the
+// manager's default value for that limit.
+func maxLimit(max, def uint) uint {
+	if max < def {
+		return def
+	}
+	return max
+}
+
+// Returns the value of the specified limit setting from a submitted sandbox
+// config section, or the default if the setting isn't present. Values larger
+// than max are rejected.
+func sandboxLimit(section map[string]interface{}, key string, def, max uint) (
+	uint, error) {
+
+	v, ok := section[key]
+	if !ok {
+		return def, nil
+	}
+	i, ok := v.(int64)
+	if !ok || i < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+	if uint64(i) > uint64(max) {
+		return 0, fmt.Errorf("%s %d exceeds the maximum of %d", key, i, max)
+	}
+	return uint(i), nil
+}
+
 // Adds running filters count to the report output.
 func (this *SandboxManagerFilter) ReportMsg(msg *message.Message) error {
 	message.NewIntField(msg, "RunningFilters", int(atomic.LoadInt32(&this.currentFilters)),
@@ -130,6 +180,27 @@ func (this *SandboxManagerFilter) createRunner(dir, name string, configSection t
 			maker.Type())
 	}
 
+	// The sandbox may request its own limits, within the manager's bounds.
+	section := make(map[string]interface{})
+	if err = toml.PrimitiveDecode(configSection, &section); err != nil {
+		return nil, err
+	}
+	memoryLimit, err := sandboxLimit(section, "memory_limit", this.memoryLimit,
+		this.maxMemoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	instructionLimit, err := sandboxLimit(section, "instruction_limit",
+		this.instructionLimit, this.maxInstructionLimit)
+	if err != nil {
+		return nil, err
+	}
+	outputLimit, err := sandboxLimit(section, "output_limit", this.outputLimit,
+		this.maxOutputLimit)
+	if err != nil {
+		return nil, err
+	}
+
 	// Customize the PrepConfig method so we can override any specified
 	// settings with the manager's settings.
 	mutMaker := maker.(pipeline.MutableMaker)
@@ -141,9 +212,10 @@ func (this *SandboxManagerFilter) createRunner(dir, name string, configSection t
 		conf := config.(*SandboxConfig)
 		conf.ScriptFilename = filepath.Join(dir, fmt.Sprintf("%s.%s", name, conf.ScriptType))
 		conf.ModuleDirectory = this.moduleDirectory
-		conf.MemoryLimit = this.memoryLimit
-		conf.InstructionLimit = this.instructionLimit
-		conf.OutputLimit = this.outputLimit
+		conf.MemoryLimit = memoryLimit
+		conf.InstructionLimit = instructionLimit
+		conf.OutputLimit = outputLimit
+		conf.Preservation = this.preservation
 		conf.PluginType = "filter"
 		return conf, nil
 	}
@@ -0,0 +1,155 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Rob Miller (rmiller@mozilla.com)
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mozilla-services/heka/pipeline"
+	"github.com/mozilla-services/heka/sandbox"
+	"github.com/mozilla-services/heka/sandbox/lua"
+)
+
+const (
+	TRANSFORM_DIR = "sandbox_transforms"
+
+	// Transforms are meant to be tiny, so they get much tighter limits than
+	// the other sandbox plugins.
+	TRANSFORM_MEMORY_LIMIT      = 1024 * 1024
+	TRANSFORM_INSTRUCTION_LIMIT = 1e4
+	TRANSFORM_OUTPUT_LIMIT      = 1024
+)
+
+// The inline transform code is used as the body of the process_message
+// function. It's wrapped in a `do` block so it can `return -2` to skip the
+// message, or return a negative value to signal an error.
+const transformTemplate = `function process_message()
+do
+%s
+end
+return 0
+end
+`
+
+// SandboxTransform is an OutputTransform that runs a snippet of Lua code,
+// provided in an output's `transform` setting, against each message before
+// it is encoded. The code can use `read_message` and `write_message`; writes
+// are applied to a copy of the message so other plugins are unaffected.
+type SandboxTransform struct {
+	sb   sandbox.Sandbox
+	sbc  *sandbox.SandboxConfig
+	lock sync.Mutex
+}
+
+func NewSandboxTransform(name, source string, pConfig *pipeline.PipelineConfig) (
+	pipeline.OutputTransform, error) {
+
+	globals := pConfig.Globals
+	dir := globals.PrependBaseDir(TRANSFORM_DIR)
+	if !fileExists(dir) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	filename := filepath.Join(dir, name+".lua")
+	code := fmt.Sprintf(transformTemplate, source)
+	if err := ioutil.WriteFile(filename, []byte(code), 0600); err != nil {
+		return nil, fmt.Errorf("can't write transform script: %s", err)
+	}
+
+	t := &SandboxTransform{
+		sbc: &sandbox.SandboxConfig{
+			ScriptType:       "lua",
+			ScriptFilename:   filename,
+			ModuleDirectory:  globals.PrependShareDir("lua_modules"),
+			MemoryLimit:      TRANSFORM_MEMORY_LIMIT,
+			InstructionLimit: TRANSFORM_INSTRUCTION_LIMIT,
+			OutputLimit:      TRANSFORM_OUTPUT_LIMIT,
+			Globals:          globals,
+			// The encoder plugin type gives us copy on write semantics for
+			// write_message.
+			PluginType: "encoder",
+		},
+	}
+
+	var err error
+	if t.sb, err = lua.CreateLuaSandbox(t.sbc); err != nil {
+		return nil, fmt.Errorf("Sandbox creation failed: '%s'", err)
+	}
+	if err = t.sb.Init(""); err != nil {
+		return nil, fmt.Errorf("Sandbox initialization failed: %s", err)
+	}
+	t.sb.InjectMessage(func(payload, payload_type, payload_name string) int {
+		// Transforms don't produce output of their own.
+		return 1
+	})
+	return t, nil
+}
+
+func (t *SandboxTransform) Transform(pack *pipeline.PipelinePack) (
+	*pipeline.PipelinePack, error) {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.sb == nil {
+		return nil, errors.New("No sandbox.")
+	}
+
+	cowpack := new(pipeline.PipelinePack)
+	cowpack.Message = pack.Message // the actual copy will happen if write_message is called
+	cowpack.MsgBytes = pack.MsgBytes
+	cowpack.TrustMsgBytes = pack.TrustMsgBytes
+	cowpack.QueueCursor = pack.QueueCursor
+	retval := t.sb.ProcessMessage(cowpack)
+
+	if retval > 0 {
+		return nil, fmt.Errorf("FATAL: %s", t.sb.LastError())
+	}
+	if retval == -2 {
+		// Transform wants the message skipped.
+		return nil, nil
+	}
+	if retval < 0 {
+		return nil, fmt.Errorf("Failed transforming: %s", t.sb.LastError())
+	}
+	if cowpack.Message != pack.Message {
+		// The message was copied and modified, the original MsgBytes are
+		// stale and are shared w/ other plugins, so we need our own.
+		cowpack.MsgBytes = nil
+		cowpack.TrustMsgBytes = false
+		if err := cowpack.EncodeMsgBytes(); err != nil {
+			return nil, fmt.Errorf("encoding transformed message: %s", err)
+		}
+	}
+	return cowpack, nil
+}
+
+func (t *SandboxTransform) Stop() {
+	t.lock.Lock()
+	if t.sb != nil {
+		t.sb.Destroy("")
+		t.sb = nil
+	}
+	t.lock.Unlock()
+}
+
+func init() {
+	pipeline.RegisterOutputTransform("lua", NewSandboxTransform)
+}
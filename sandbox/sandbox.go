@@ -50,6 +50,43 @@ type Sandbox interface {
 
 	// Go callback
 	InjectMessage(f func(payload, payload_type, payload_name string) int)
+	// Go callback for `read_match_count`, the function is only made available
+	// to the sandbox if this is set before Init is called.
+	MatchCount(f func(name string) (count int64, ok bool))
+}
+
+// Specifies where a sandbox's preserved data is stored between restarts. The
+// sandbox always serializes to and restores from a local file, remote stores
+// just copy that file to and from somewhere more durable.
+type PreservationConfig struct {
+	// One of "file", "s3", or "redis". Defaults to "file", i.e. only the
+	// local preservation file is used.
+	Store string `toml:"store"`
+	// String prepended to the sandbox name to generate the S3 object key or
+	// Redis key.
+	KeyPrefix string `toml:"key_prefix"`
+	// S3 bucket name.
+	Bucket string `toml:"bucket"`
+	// S3 bucket region. Defaults to "us-east-1".
+	Region string `toml:"region"`
+	// AWS credentials. If not provided the standard AWS environment variables
+	// or instance metadata will be used.
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	// Redis server address. Defaults to "127.0.0.1:6379".
+	Address string `toml:"address"`
+	// Redis password, if the server requires AUTH.
+	Password string `toml:"password"`
+	// Redis database number. Defaults to 0.
+	Database int `toml:"database"`
+}
+
+func NewPreservationConfig() PreservationConfig {
+	return PreservationConfig{
+		Store:   "file",
+		Region:  "us-east-1",
+		Address: "127.0.0.1:6379",
+	}
 }
 
 type SandboxConfig struct {
@@ -62,10 +99,19 @@ type SandboxConfig struct {
 	OutputLimit          uint   `toml:"output_limit"`
 	CanExit              bool   `toml:"can_exit"`
 	TimerEventOnShutdown bool   `toml:"timer_event_on_shutdown"`
-	Profile              bool
-	Config               map[string]interface{}
-	Globals              *pipeline.GlobalConfigStruct
-	PluginType           string
+	// Whether filters can use `read_match_count` to get the number of
+	// messages matched by other plugins' message matchers.
+	EnableReadMatchCount bool `toml:"enable_read_match_count"`
+	// Maximum number of Payload bytes `read_message` hands to the sandbox,
+	// longer payloads are truncated. Defaults to 0 (unlimited).
+	MaxReadPayloadBytes uint `toml:"max_read_payload_bytes"`
+	Profile             bool
+	Config              map[string]interface{}
+	Globals             *pipeline.GlobalConfigStruct
+	PluginType          string
+
+	// Where the preserved data is kept, only used if PreserveData is true.
+	Preservation PreservationConfig `toml:"preservation"`
 }
 
 func NewSandboxConfig(globals *pipeline.GlobalConfigStruct) interface{} {
@@ -77,5 +123,6 @@ func NewSandboxConfig(globals *pipeline.GlobalConfigStruct) interface{} {
 		ScriptType:       "lua",
 		Globals:          globals,
 		CanExit:          true,
+		Preservation:     NewPreservationConfig(),
 	}
 }